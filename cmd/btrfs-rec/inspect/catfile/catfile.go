@@ -0,0 +1,155 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package catfile is the guts of the `btrfs-rec inspect cat` command,
+// which writes the contents of a single file, for recovering a file
+// without having to mount the filesystem (or even having a working
+// path to it).
+package catfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
+)
+
+// ResolvePath returns the subvolume and inode that `filepath`
+// (relative to the root of the subvolume `treeID`) refers to.  Child
+// subvolumes are followed.  `cacheSize` is passed to
+// btrfs.NewSubvolume.
+func ResolvePath(ctx context.Context, fs btrfs.ReadableFS, treeID btrfsprim.ObjID, filepath string, cacheSize int) (btrfsprim.ObjID, btrfsprim.ObjID, error) {
+	sv := btrfs.NewSubvolume(ctx, fs, treeID, false, false, cacheSize)
+	inode, err := sv.GetRootInode()
+	if err != nil {
+		return 0, 0, err
+	}
+	var resolved string
+	isDir := true
+	for _, name := range strings.Split(path.Clean("/"+filepath), "/") {
+		if name == "" {
+			continue
+		}
+		if !isDir {
+			return 0, 0, fmt.Errorf("%q: not a directory", resolved)
+		}
+		resolved = path.Join("/", resolved, name)
+		dir, err := sv.AcquireDir(inode)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%q: %w", path.Dir(resolved), err)
+		}
+		entry, ok := dir.ChildrenByName[name]
+		if ok {
+			entry = entry.Clone()
+		}
+		sv.ReleaseDir(inode)
+		if !ok {
+			return 0, 0, fmt.Errorf("%q: no such file or directory", resolved)
+		}
+		isDir = entry.Type == btrfsitem.FT_DIR
+		if tree, ok := entry.TargetTree(); ok {
+			sv = sv.NewChildSubvolume(tree)
+			inode, err = sv.GetRootInode()
+			if err != nil {
+				return 0, 0, fmt.Errorf("%q: %w", resolved, err)
+			}
+			continue
+		}
+		inode, ok = entry.TargetInode()
+		if !ok {
+			return 0, 0, fmt.Errorf("%q: dirent has unexpected location.ItemType=%v", resolved, entry.Location.ItemType)
+		}
+	}
+	return sv.TreeID, inode, nil
+}
+
+// CatFile writes the contents of the file `inode` in the subvolume
+// `treeID` to `out`.
+//
+// If `ignoreCSum` is false, then the first block that can't be read
+// (including because of a checksum mismatch) aborts the copy with an
+// error; everything before it will have already been written to
+// `out`.  If `ignoreCSum` is true, then checksum mismatches are
+// logged but the data is used anyway, and blocks that can't be read
+// at all are logged and written as zeros; the number of such problems
+// is returned.
+//
+// `cacheSize` is passed to btrfs.NewSubvolume.
+func CatFile(
+	ctx context.Context,
+	out io.Writer,
+	fs btrfs.ReadableFS,
+	treeID btrfsprim.ObjID,
+	inode btrfsprim.ObjID,
+	ignoreCSum bool,
+	cacheSize int,
+) (int, error) {
+	sv := btrfs.NewSubvolume(ctx, fs, treeID, false, ignoreCSum, cacheSize)
+
+	// Check this before AcquireFile, which doesn't know what to
+	// do with a directory's items.
+	bareInode, err := sv.AcquireBareInode(inode)
+	if err != nil {
+		return 0, err
+	}
+	isDir := bareInode.InodeItem.Mode.IsDir()
+	sv.ReleaseBareInode(inode)
+	if isDir {
+		return 0, fmt.Errorf("inode %v is a directory", inode)
+	}
+
+	file, err := sv.AcquireFile(inode)
+	if err != nil {
+		return 0, err
+	}
+	defer sv.ReleaseFile(inode)
+	if file.InodeItem == nil {
+		return 0, errors.New("missing INODE_ITEM")
+	}
+
+	var numBad int
+	for _, err := range file.Errs {
+		numBad++
+		dlog.Errorf(ctx, "subvol=%v inode=%v: %v", treeID, inode, err)
+	}
+
+	var buf [btrfssum.BlockSize]byte
+	size := file.InodeItem.Size
+	for off := int64(0); off < size; {
+		if ctx.Err() != nil {
+			return numBad, ctx.Err()
+		}
+		chunk := buf[:slices.Min(int64(len(buf)), size-off)]
+		n, err := file.ReadAt(chunk, off)
+		if err != nil {
+			if !ignoreCSum {
+				if _, werr := out.Write(chunk[:n]); werr != nil {
+					return numBad, werr
+				}
+				return numBad, fmt.Errorf("offset %v: %w", off+int64(n), err)
+			}
+			numBad++
+			dlog.Errorf(ctx, "subvol=%v inode=%v: offset %v: %v (filling the rest of the block with zeros)",
+				treeID, inode, off+int64(n), err)
+			for i := range chunk[n:] {
+				chunk[n+i] = 0
+			}
+		}
+		if _, err := out.Write(chunk); err != nil {
+			return numBad, err
+		}
+		off += int64(len(chunk))
+	}
+	return numBad, nil
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package catfile_test
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/catfile"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+const (
+	rootDir = btrfsprim.FIRST_FREE_OBJECTID + iota
+	subDir
+	helloFile
+	brokenFile
+	subvolID = btrfsprim.FIRST_FREE_OBJECTID + 100
+)
+
+func makeFS() btrfstest.ItemsFS {
+	inode := func(inode btrfsprim.ObjID, mode btrfsitem.StatMode, size int64) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY},
+			Body: &btrfsitem.Inode{Mode: mode, Size: size, NumBytes: size},
+		}
+	}
+	dirEntry := func(dir btrfsprim.ObjID, name string, typ btrfsitem.FileType, location btrfsprim.Key) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: btrfsitem.NameHash([]byte(name))},
+			Body: &btrfsitem.DirEntry{
+				Location: location,
+				Type:     typ,
+				Name:     []byte(name),
+			},
+		}
+	}
+	inodeLoc := func(inode btrfsprim.ObjID) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY}
+	}
+	extent := func(inode btrfsprim.ObjID, off uint64, ext btrfsitem.FileExtent) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: off},
+			Body: &ext,
+		}
+	}
+
+	// /
+	// ├── dir/
+	// │   └── hello
+	// ├── broken
+	// └── sub/ (another subvolume, whose root is also `rootDir`)
+	fsTree := []btrfstree.Item{
+		inode(rootDir, btrfsitem.ModeFmtDir|0o755, 0),
+		dirEntry(rootDir, "dir", btrfsitem.FT_DIR, inodeLoc(subDir)),
+		dirEntry(rootDir, "broken", btrfsitem.FT_REG_FILE, inodeLoc(brokenFile)),
+		dirEntry(rootDir, "sub", btrfsitem.FT_DIR, btrfsprim.Key{ObjectID: subvolID, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: math.MaxUint64}),
+		inode(subDir, btrfsitem.ModeFmtDir|0o755, 0),
+		dirEntry(subDir, "hello", btrfsitem.FT_REG_FILE, inodeLoc(helloFile)),
+		inode(helloFile, btrfsitem.ModeFmtRegular|0o644, 13),
+		extent(helloFile, 0, btrfsitem.FileExtent{
+			Type:       btrfsitem.FILE_EXTENT_INLINE,
+			RAMBytes:   13,
+			BodyInline: []byte("hello, world\n"),
+		}),
+		inode(brokenFile, btrfsitem.ModeFmtRegular|0o644, 5+btrfssum.BlockSize),
+		extent(brokenFile, 0, btrfsitem.FileExtent{
+			Type:       btrfsitem.FILE_EXTENT_INLINE,
+			RAMBytes:   5,
+			BodyInline: []byte("head\n"),
+		}),
+		extent(brokenFile, 5, btrfsitem.FileExtent{
+			Type: btrfsitem.FILE_EXTENT_REG,
+			BodyExtent: btrfsitem.FileExtentExtent{
+				DiskByteNr:   1024 * 1024,
+				DiskNumBytes: btrfssum.BlockSize,
+				NumBytes:     btrfssum.BlockSize,
+			},
+		}),
+	}
+	sort.Slice(fsTree, func(i, j int) bool {
+		return fsTree[i].Key.Compare(fsTree[j].Key) < 0
+	})
+	rootItem := func(treeID btrfsprim.ObjID) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: treeID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+			Body: &btrfsitem.Root{RootDirID: rootDir},
+		}
+	}
+	return btrfstest.ItemsFS{
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.ROOT_TREE_OBJECTID: {
+				rootItem(btrfsprim.FS_TREE_OBJECTID),
+				rootItem(subvolID),
+			},
+			btrfsprim.FS_TREE_OBJECTID: fsTree,
+			subvolID:                   fsTree,
+		},
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	fs := makeFS()
+
+	type TestCase struct {
+		Path     string
+		ExpTree  btrfsprim.ObjID
+		ExpInode btrfsprim.ObjID
+		ExpErr   string
+	}
+	testcases := map[string]TestCase{
+		"root":        {Path: "/", ExpTree: btrfsprim.FS_TREE_OBJECTID, ExpInode: rootDir},
+		"file":        {Path: "/dir/hello", ExpTree: btrfsprim.FS_TREE_OBJECTID, ExpInode: helloFile},
+		"relative":    {Path: "dir//./hello", ExpTree: btrfsprim.FS_TREE_OBJECTID, ExpInode: helloFile},
+		"subvol":      {Path: "/sub/dir/hello", ExpTree: subvolID, ExpInode: helloFile},
+		"missing":     {Path: "/dir/nope", ExpErr: `"/dir/nope": no such file or directory`},
+		"not-a-dir":   {Path: "/dir/hello/x", ExpErr: `"/dir/hello": not a directory`},
+		"missing-dir": {Path: "/nope/hello", ExpErr: `"/nope": no such file or directory`},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			treeID, inode, err := catfile.ResolvePath(ctx, fs, btrfsprim.FS_TREE_OBJECTID, tc.Path, 0)
+			if tc.ExpErr != "" {
+				assert.ErrorContains(t, err, tc.ExpErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpTree, treeID)
+			assert.Equal(t, tc.ExpInode, inode)
+		})
+	}
+}
+
+func TestCatFile(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	fs := makeFS()
+
+	var out bytes.Buffer
+	numBad, err := catfile.CatFile(ctx, &out, fs, btrfsprim.FS_TREE_OBJECTID, helloFile, false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, numBad)
+	assert.Equal(t, "hello, world\n", out.String())
+
+	out.Reset()
+	_, err = catfile.CatFile(ctx, &out, fs, btrfsprim.FS_TREE_OBJECTID, subDir, false, 0)
+	assert.EqualError(t, err, "inode 257 is a directory")
+
+	// Without --ignore-csum, the unreadable block aborts the
+	// copy, but only after everything before it is written.
+	out.Reset()
+	_, err = catfile.CatFile(ctx, &out, fs, btrfsprim.FS_TREE_OBJECTID, brokenFile, false, 0)
+	assert.ErrorContains(t, err, "offset 5: ")
+	assert.Equal(t, "head\n", out.String())
+
+	// With --ignore-csum, it is filled with zeros.  The extent
+	// straddles 2 of the file's blocks, so that's 2 problems.
+	out.Reset()
+	numBad, err = catfile.CatFile(ctx, &out, fs, btrfsprim.FS_TREE_OBJECTID, brokenFile, true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, numBad)
+	assert.Equal(t, append([]byte("head\n"), make([]byte, btrfssum.BlockSize)...), out.Bytes())
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package checkdevextents is the guts of the `btrfs-rec inspect
+// check-dev-extents` command, which verifies that the DEV_EXTENT
+// items in the device tree exactly mirror the stripes of the
+// CHUNK_ITEMs in the chunk tree.
+package checkdevextents
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/datawire/dlib/derror"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// extent is a run of physical addresses, as claimed by either a chunk
+// stripe or a DEV_EXTENT.
+type extent struct {
+	LAddr btrfsvol.LogicalAddr
+	Size  btrfsvol.AddrDelta
+}
+
+// CheckDevExtents compares every stripe of every chunk in the chunk
+// tree against the DEV_EXTENT items in the device tree, writing to
+// `out` each stripe that has no DEV_EXTENT, each DEV_EXTENT that has
+// no stripe, and each pair that disagrees on the logical address or
+// the length.
+//
+// The number of problems found is returned.  Parts of either tree
+// that could not be read (or items that could not be parsed) don't
+// stop the comparison of what could be read, but are returned as an
+// error, as any DEV_EXTENTs or stripes in them are missing from the
+// comparison.
+func CheckDevExtents(ctx context.Context, out io.Writer, fs btrfs.ReadableFS) (int, error) {
+	var errs derror.MultiError
+	stripes, err := readStripes(ctx, fs)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	devExts, err := readDevExtents(ctx, fs)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	var numBad int
+	paddrs := make(containers.Set[btrfsvol.QualifiedPhysicalAddr], len(stripes)+len(devExts))
+	for paddr := range stripes {
+		paddrs.Insert(paddr)
+	}
+	for paddr := range devExts {
+		paddrs.Insert(paddr)
+	}
+	sorted := maps.Keys(paddrs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Compare(sorted[j]) < 0
+	})
+	for _, paddr := range sorted {
+		stripe, haveStripe := stripes[paddr]
+		devExt, haveDevExt := devExts[paddr]
+		switch {
+		case !haveDevExt:
+			numBad++
+			textui.Fprintf(out, "dev=%v paddr=%v: chunk laddr=%v has a stripe here (size=%v), but there is no DEV_EXTENT\n",
+				paddr.Dev, paddr.Addr, stripe.LAddr, stripe.Size)
+		case !haveStripe:
+			numBad++
+			textui.Fprintf(out, "dev=%v paddr=%v: DEV_EXTENT (laddr=%v size=%v) is not a stripe of any chunk\n",
+				paddr.Dev, paddr.Addr, devExt.LAddr, devExt.Size)
+		case stripe != devExt:
+			numBad++
+			textui.Fprintf(out, "dev=%v paddr=%v: chunk stripe says laddr=%v size=%v, but DEV_EXTENT says laddr=%v size=%v\n",
+				paddr.Dev, paddr.Addr, stripe.LAddr, stripe.Size, devExt.LAddr, devExt.Size)
+		}
+	}
+	if len(errs) > 0 {
+		return numBad, errs
+	}
+	return numBad, nil
+}
+
+func readStripes(ctx context.Context, fs btrfs.ReadableFS) (map[btrfsvol.QualifiedPhysicalAddr]extent, error) {
+	tree, err := fs.ForrestLookup(ctx, btrfsprim.CHUNK_TREE_OBJECTID)
+	if err != nil {
+		return nil, fmt.Errorf("chunk tree: %w", err)
+	}
+	ret := make(map[btrfsvol.QualifiedPhysicalAddr]extent)
+	var errs derror.MultiError
+	if err := tree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType != btrfsitem.CHUNK_ITEM_KEY {
+			return true
+		}
+		switch body := item.Body.(type) {
+		case *btrfsitem.Chunk:
+			for _, stripe := range body.Stripes {
+				ret[btrfsvol.QualifiedPhysicalAddr{
+					Dev:  stripe.DeviceID,
+					Addr: stripe.Offset,
+				}] = extent{
+					LAddr: btrfsvol.LogicalAddr(item.Key.Offset),
+					Size:  body.StripeSize(),
+				}
+			}
+		case *btrfsitem.Error:
+			errs = append(errs, fmt.Errorf("chunk tree: %v: malformed CHUNK_ITEM: %w", item.Key, body.Err))
+		}
+		return true
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("chunk tree: %w", err))
+	}
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
+
+func readDevExtents(ctx context.Context, fs btrfs.ReadableFS) (map[btrfsvol.QualifiedPhysicalAddr]extent, error) {
+	tree, err := fs.ForrestLookup(ctx, btrfsprim.DEV_TREE_OBJECTID)
+	if err != nil {
+		return nil, fmt.Errorf("dev tree: %w", err)
+	}
+	ret := make(map[btrfsvol.QualifiedPhysicalAddr]extent)
+	var errs derror.MultiError
+	if err := tree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType != btrfsitem.DEV_EXTENT_KEY {
+			return true
+		}
+		switch body := item.Body.(type) {
+		case *btrfsitem.DevExtent:
+			ret[btrfsvol.QualifiedPhysicalAddr{
+				Dev:  btrfsvol.DeviceID(item.Key.ObjectID),
+				Addr: btrfsvol.PhysicalAddr(item.Key.Offset),
+			}] = extent{
+				LAddr: body.ChunkOffset,
+				Size:  body.Length,
+			}
+		case *btrfsitem.Error:
+			errs = append(errs, fmt.Errorf("dev tree: %v: malformed DEV_EXTENT: %w", item.Key, body.Err))
+		}
+		return true
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("dev tree: %w", err))
+	}
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
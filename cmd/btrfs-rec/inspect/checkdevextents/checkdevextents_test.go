@@ -0,0 +1,113 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package checkdevextents_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/checkdevextents"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestCheckDevExtents(t *testing.T) {
+	t.Parallel()
+	chunk := func(laddr btrfsvol.LogicalAddr, size btrfsvol.AddrDelta, stripes ...btrfsvol.PhysicalAddr) btrfstree.Item {
+		body := &btrfsitem.Chunk{
+			Head: btrfsitem.ChunkHeader{Size: size, Type: btrfsvol.BLOCK_GROUP_DATA},
+		}
+		for _, paddr := range stripes {
+			body.Stripes = append(body.Stripes, btrfsitem.ChunkStripe{DeviceID: 1, Offset: paddr})
+		}
+		return btrfstree.Item{
+			Key: btrfsprim.Key{
+				ObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+				ItemType: btrfsitem.CHUNK_ITEM_KEY,
+				Offset:   uint64(laddr),
+			},
+			Body: body,
+		}
+	}
+	devExt := func(paddr btrfsvol.PhysicalAddr, laddr btrfsvol.LogicalAddr, size btrfsvol.AddrDelta) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{
+				ObjectID: 1,
+				ItemType: btrfsitem.DEV_EXTENT_KEY,
+				Offset:   uint64(paddr),
+			},
+			Body: &btrfsitem.DevExtent{ChunkOffset: laddr, Length: size},
+		}
+	}
+	trees := map[btrfsprim.ObjID][]btrfstree.Item{
+		btrfsprim.CHUNK_TREE_OBJECTID: {
+			chunk(0x100000, 0x10000, 0x100000, 0x200000),
+			chunk(0x300000, 0x10000, 0x300000),
+			{
+				Key: btrfsprim.Key{
+					ObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+					ItemType: btrfsitem.CHUNK_ITEM_KEY,
+					Offset:   0x400000,
+				},
+				Body: &btrfsitem.Error{Err: errors.New("bogus")},
+			},
+		},
+		btrfsprim.DEV_TREE_OBJECTID: {
+			devExt(0x100000, 0x100000, 0x10000),
+			devExt(0x200000, 0x100000, 0x10000),
+			devExt(0x300000, 0x300000, 0x8000),
+			devExt(0x500000, 0x500000, 0x10000),
+		},
+	}
+	const expOut = "" +
+		"dev=1 paddr=0x0000000000300000: chunk stripe says laddr=0x0000000000300000 size=0x0000000000010000, but DEV_EXTENT says laddr=0x0000000000300000 size=0x0000000000008000\n" +
+		"dev=1 paddr=0x0000000000500000: DEV_EXTENT (laddr=0x0000000000500000 size=0x0000000000010000) is not a stripe of any chunk\n"
+
+	ctx := dlog.NewTestContext(t, false)
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Parallel()
+		var out bytes.Buffer
+		numBad, err := checkdevextents.CheckDevExtents(ctx, &out, btrfstest.ItemsFS{Trees: trees})
+		assert.ErrorContains(t, err, "malformed CHUNK_ITEM: bogus")
+		assert.Equal(t, 2, numBad)
+		assert.Equal(t, expOut, out.String())
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		t.Parallel()
+		treeErr := errors.New("node@0x1000: unreadable")
+		var out bytes.Buffer
+		numBad, err := checkdevextents.CheckDevExtents(ctx, &out, btrfstest.ItemsFS{
+			Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+				btrfsprim.CHUNK_TREE_OBJECTID: trees[btrfsprim.CHUNK_TREE_OBJECTID][:2],
+				btrfsprim.DEV_TREE_OBJECTID:   trees[btrfsprim.DEV_TREE_OBJECTID],
+			},
+			TreeErrs: map[btrfsprim.ObjID]error{
+				btrfsprim.DEV_TREE_OBJECTID: treeErr,
+			},
+		})
+		assert.ErrorIs(t, err, treeErr)
+		assert.ErrorContains(t, err, "dev tree: ")
+		assert.Equal(t, 2, numBad)
+		assert.Equal(t, expOut, out.String())
+	})
+
+	t.Run("notree", func(t *testing.T) {
+		t.Parallel()
+		var out bytes.Buffer
+		_, err := checkdevextents.CheckDevExtents(ctx, &out, btrfstest.ItemsFS{})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, btrfstree.ErrNoTree)
+	})
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package checkextentrefs is the guts of the `btrfs-rec inspect
+// check-extent-refs` command, which compares the reference count
+// recorded in each EXTENT_ITEM/METADATA_ITEM against the backrefs
+// that are actually present for it.
+package checkextentrefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+type extentRefs struct {
+	Key      btrfsprim.Key
+	Expected int64
+	Found    int64
+}
+
+// inlineRefCount returns how many references a single backref
+// accounts for.
+func inlineRefCount(typ btrfsitem.Type, body btrfsitem.Item) int64 {
+	switch typ {
+	case btrfsitem.TREE_BLOCK_REF_KEY, btrfsitem.SHARED_BLOCK_REF_KEY:
+		return 1
+	case btrfsitem.EXTENT_DATA_REF_KEY:
+		if ref, ok := body.(*btrfsitem.ExtentDataRef); ok {
+			return int64(ref.Count)
+		}
+	case btrfsitem.SHARED_DATA_REF_KEY:
+		if ref, ok := body.(*btrfsitem.SharedDataRef); ok {
+			return int64(ref.Count)
+		}
+	}
+	return 0
+}
+
+// CheckExtentRefs walks the extent tree, and for every
+// EXTENT_ITEM/METADATA_ITEM compares .Head.Refs against the sum of
+// the inline and keyed backrefs for that extent, writing any
+// discrepancies to `out`.
+//
+// The number of discrepancies found is returned.
+func CheckExtentRefs(ctx context.Context, out io.Writer, fs btrfs.ReadableFS) (int, error) {
+	tree, err := fs.ForrestLookup(ctx, btrfsprim.EXTENT_TREE_OBJECTID)
+	if err != nil {
+		return 0, err
+	}
+
+	var numBad int
+	var cur *extentRefs
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if cur.Found != cur.Expected {
+			numBad++
+			textui.Fprintf(out, "extent laddr=%v size=%v (%v): expected=%v found=%v\n",
+				btrfsvol.LogicalAddr(cur.Key.ObjectID), cur.Key.Offset, cur.Key.ItemType,
+				cur.Expected, cur.Found)
+		}
+		cur = nil
+	}
+
+	err = tree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		switch body := item.Body.(type) {
+		case *btrfsitem.Extent:
+			flush()
+			cur = &extentRefs{
+				Key:      item.Key,
+				Expected: body.Head.Refs,
+			}
+			for _, ref := range body.Refs {
+				cur.Found += inlineRefCount(ref.Type, ref.Body)
+			}
+		case *btrfsitem.Metadata:
+			flush()
+			cur = &extentRefs{
+				Key:      item.Key,
+				Expected: body.Head.Refs,
+			}
+			for _, ref := range body.Refs {
+				cur.Found += inlineRefCount(ref.Type, ref.Body)
+			}
+		case *btrfsitem.Error:
+			dlog.Errorf(ctx, "extent tree: item %v: %v", item.Key, body.Err)
+		default:
+			switch item.Key.ItemType {
+			case btrfsitem.TREE_BLOCK_REF_KEY, btrfsitem.SHARED_BLOCK_REF_KEY,
+				btrfsitem.EXTENT_DATA_REF_KEY, btrfsitem.SHARED_DATA_REF_KEY:
+				if cur == nil || cur.Key.ObjectID != item.Key.ObjectID {
+					flush()
+					numBad++
+					textui.Fprintf(out, "extent laddr=%v: orphaned %v backref with no extent item\n",
+						btrfsvol.LogicalAddr(item.Key.ObjectID), item.Key.ItemType)
+					return true
+				}
+				cur.Found += inlineRefCount(item.Key.ItemType, item.Body)
+			default:
+				flush()
+			}
+		}
+		return true
+	})
+	flush()
+	if err != nil {
+		return numBad, fmt.Errorf("extent tree: %w", err)
+	}
+	return numBad, nil
+}
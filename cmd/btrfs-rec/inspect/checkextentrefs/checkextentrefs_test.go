@@ -0,0 +1,93 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package checkextentrefs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/checkextentrefs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+func TestCheckExtentRefs(t *testing.T) {
+	t.Parallel()
+	key := func(laddr btrfsprim.ObjID, typ btrfsitem.Type, off uint64) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: laddr, ItemType: typ, Offset: off}
+	}
+	extentTree := []btrfstree.Item{
+		// Good: 1 ref, 1 inline backref.
+		{
+			Key: key(0x100000, btrfsitem.EXTENT_ITEM_KEY, 0x1000),
+			Body: &btrfsitem.Extent{
+				Head: btrfsitem.ExtentHeader{Refs: 1},
+				Refs: []btrfsitem.ExtentInlineRef{
+					{Type: btrfsitem.TREE_BLOCK_REF_KEY, Offset: 5},
+				},
+			},
+		},
+		// Good: 3 refs, split between inline and keyed backrefs.
+		{
+			Key: key(0x200000, btrfsitem.METADATA_ITEM_KEY, 0),
+			Body: &btrfsitem.Metadata{
+				Head: btrfsitem.ExtentHeader{Refs: 3},
+				Refs: []btrfsitem.ExtentInlineRef{
+					{Type: btrfsitem.TREE_BLOCK_REF_KEY, Offset: 5},
+				},
+			},
+		},
+		{
+			Key:  key(0x200000, btrfsitem.SHARED_DATA_REF_KEY, 0x300000),
+			Body: &btrfsitem.SharedDataRef{Count: 2},
+		},
+		// Bad: 3 refs, but the backrefs only account for 1.
+		{
+			Key: key(0x300000, btrfsitem.EXTENT_ITEM_KEY, 0x4000),
+			Body: &btrfsitem.Extent{
+				Head: btrfsitem.ExtentHeader{Refs: 3},
+				Refs: []btrfsitem.ExtentInlineRef{
+					{Type: btrfsitem.EXTENT_DATA_REF_KEY, Body: &btrfsitem.ExtentDataRef{Count: 1}},
+				},
+			},
+		},
+		// Bad: a backref with no extent item.
+		{
+			Key:  key(0x400000, btrfsitem.SHARED_BLOCK_REF_KEY, 0x100000),
+			Body: &btrfsitem.Empty{},
+		},
+		// Bad: the last extent in the tree is missing a backref.
+		{
+			Key: key(0x500000, btrfsitem.METADATA_ITEM_KEY, 0),
+			Body: &btrfsitem.Metadata{
+				Head: btrfsitem.ExtentHeader{Refs: 1},
+			},
+		},
+	}
+
+	ctx := dlog.NewTestContext(t, false)
+	var out bytes.Buffer
+	numBad, err := checkextentrefs.CheckExtentRefs(ctx, &out, btrfstest.ItemsFS{
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.EXTENT_TREE_OBJECTID: extentTree,
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, numBad)
+	assert.Equal(t, ""+
+		"extent laddr=0x0000000000300000 size=16,384 (EXTENT_ITEM): expected=3 found=1\n"+
+		"extent laddr=0x0000000000400000: orphaned SHARED_BLOCK_REF backref with no extent item\n"+
+		"extent laddr=0x0000000000500000 size=0 (METADATA_ITEM): expected=1 found=0\n",
+		out.String())
+
+	_, err = checkextentrefs.CheckExtentRefs(ctx, &out, btrfstest.ItemsFS{})
+	assert.ErrorIs(t, err, btrfstree.ErrNoTree)
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package checkordering is the guts of the `btrfs-rec inspect
+// check-ordering` command, which verifies that the keys in each
+// b-tree are in order, as they are actually laid out on disk.
+package checkordering
+
+import (
+	"context"
+	"io"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// CheckOrdering walks every tree in the filesystem, writing to `out`
+// each place where a key is not strictly greater than the key before
+// it (whether within a node, or across adjacent nodes), or where a
+// node's keys fall outside of the range that its parent key-pointer
+// says that it covers.
+//
+// Unlike the usual tree walks, nodes are not rejected for having
+// out-of-range keys; this walks the real on-disk structure no matter
+// how broken it is.
+//
+// The number of problems found is returned.
+func CheckOrdering(ctx context.Context, out io.Writer, fs *btrfs.FS) (int, error) {
+	treeIDs := []btrfsprim.ObjID{
+		btrfsprim.ROOT_TREE_OBJECTID,
+		btrfsprim.CHUNK_TREE_OBJECTID,
+		btrfsprim.TREE_LOG_OBJECTID,
+		btrfsprim.BLOCK_GROUP_TREE_OBJECTID,
+	}
+	rootTree, err := fs.RawTree(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	if err != nil {
+		return 0, err
+	}
+	if err := rootTree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType == btrfsprim.ROOT_ITEM_KEY {
+			treeIDs = append(treeIDs, item.Key.ObjectID)
+		}
+		return true
+	}); err != nil {
+		dlog.Errorf(ctx, "root tree: %v", err)
+	}
+
+	var numBad int
+	for _, treeID := range treeIDs {
+		if ctx.Err() != nil {
+			return numBad, ctx.Err()
+		}
+		tree, err := fs.RawTree(ctx, treeID)
+		if err != nil {
+			dlog.Errorf(ctx, "tree %v: %v", treeID.Format(btrfsprim.ROOT_TREE_OBJECTID), err)
+			continue
+		}
+		if tree.RootNode == 0 {
+			continue
+		}
+		c := &checker{
+			fs:     fs,
+			out:    out,
+			treeID: treeID,
+		}
+		c.checkNode(ctx, tree.RootNode, tree.Level,
+			containers.Optional[btrfsprim.Key]{}, containers.Optional[btrfsprim.Key]{})
+		numBad += c.numBad
+	}
+	return numBad, nil
+}
+
+type checker struct {
+	fs     *btrfs.FS
+	out    io.Writer
+	treeID btrfsprim.ObjID
+
+	// lastKey is the last leaf key seen in the tree so far, for
+	// checking the ordering across adjacent leaves.
+	lastKey     containers.Optional[btrfsprim.Key]
+	lastKeyNode btrfsvol.LogicalAddr
+
+	numBad int
+}
+
+func (c *checker) report(addr btrfsvol.LogicalAddr, slot int, format string, args ...any) {
+	c.numBad++
+	textui.Fprintf(c.out, "tree %v node@%v slot %v: ", c.treeID.Format(btrfsprim.ROOT_TREE_OBJECTID), addr, slot)
+	textui.Fprintf(c.out, format, args...)
+	textui.Fprintf(c.out, "\n")
+}
+
+// checkNode checks the node at `addr` and (recursively) its children.
+// minKey and maxKey are the (inclusive) bounds that the parent
+// key-pointer says this node covers.
+func (c *checker) checkNode(ctx context.Context, addr btrfsvol.LogicalAddr, level uint8, minKey, maxKey containers.Optional[btrfsprim.Key]) {
+	if ctx.Err() != nil {
+		return
+	}
+	node, err := c.fs.AcquireNode(ctx, addr, btrfstree.NodeExpectations{
+		LAddr: containers.OptionalValue(addr),
+		Level: containers.OptionalValue(level),
+	})
+	defer c.fs.ReleaseNode(node)
+	if err != nil {
+		dlog.Errorf(ctx, "tree %v: %v", c.treeID.Format(btrfsprim.ROOT_TREE_OBJECTID), err)
+		return
+	}
+
+	var keys []btrfsprim.Key
+	if node.Head.Level > 0 {
+		keys = make([]btrfsprim.Key, len(node.BodyInterior))
+		for i, kp := range node.BodyInterior {
+			keys[i] = kp.Key
+		}
+	} else {
+		keys = make([]btrfsprim.Key, len(node.BodyLeaf))
+		for i, item := range node.BodyLeaf {
+			keys[i] = item.Key
+		}
+	}
+
+	for i, key := range keys {
+		if minKey.OK && key.Compare(minKey.Val) < 0 {
+			c.report(addr, i, "key %v is less than the parent key-pointer's min key %v", key, minKey.Val)
+		}
+		if maxKey.OK && key.Compare(maxKey.Val) > 0 {
+			c.report(addr, i, "key %v is greater than the parent key-pointer's max key %v", key, maxKey.Val)
+		}
+		if i > 0 && key.Compare(keys[i-1]) <= 0 {
+			c.report(addr, i, "key %v is not greater than the key %v in the previous slot", key, keys[i-1])
+		}
+	}
+
+	if node.Head.Level > 0 {
+		for i, kp := range node.BodyInterior {
+			childMax := maxKey
+			if i+1 < len(node.BodyInterior) {
+				childMax = containers.OptionalValue(node.BodyInterior[i+1].Key.Mm())
+			}
+			c.checkNode(ctx, kp.BlockPtr, node.Head.Level-1, containers.OptionalValue(kp.Key), childMax)
+		}
+		return
+	}
+
+	if len(keys) > 0 {
+		if c.lastKey.OK && keys[0].Compare(c.lastKey.Val) <= 0 {
+			c.report(addr, 0, "key %v is not greater than the last key %v in the previous leaf node@%v",
+				keys[0], c.lastKey.Val, c.lastKeyNode)
+		}
+		c.lastKey = containers.OptionalValue(keys[len(keys)-1])
+		c.lastKeyNode = addr
+	}
+}
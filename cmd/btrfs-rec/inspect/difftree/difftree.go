@@ -0,0 +1,145 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package difftree is the guts of the `btrfs-rec inspect diff-tree`
+// command, which compares the items in the same tree in two
+// filesystems.
+package difftree
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/davecgh/go-spew/spew"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+type item struct {
+	Key  btrfsprim.Key
+	Dat  []byte
+	Body btrfsitem.Item // only if `full`
+}
+
+// streamTree sends each item in the tree to `ch`, closing `ch` when
+// done.
+func streamTree(ctx context.Context, fs btrfs.ReadableFS, treeID btrfsprim.ObjID, full bool, ch chan<- item) error {
+	defer close(ch)
+	tree, err := fs.ForrestLookup(ctx, treeID)
+	if err != nil {
+		return fmt.Errorf("%v: %w", fs.Name(), err)
+	}
+	var marshalErr error
+	err = tree.TreeRange(ctx, func(in btrfstree.Item) bool {
+		dat, err := binstruct.Marshal(in.Body)
+		if err != nil {
+			marshalErr = fmt.Errorf("%v: item %v: %w", fs.Name(), in.Key, err)
+			return false
+		}
+		out := item{
+			Key: in.Key,
+			Dat: dat,
+		}
+		if full {
+			out.Body = in.Body.CloneItem()
+		}
+		select {
+		case ch <- out:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	if marshalErr != nil {
+		return marshalErr
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %w", fs.Name(), err)
+	}
+	return nil
+}
+
+// DiffTree walks the tree `treeID` in both `fsA` and `fsB`, writing a
+// line to `out` for each item that was removed ("-"), added ("+"), or
+// changed ("~") going from A to B.  If `full` is set, then the item
+// bodies are also dumped.
+//
+// The number of differing items is returned.
+func DiffTree(ctx context.Context, out io.Writer, fsA, fsB btrfs.ReadableFS, treeID btrfsprim.ObjID, full bool) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chA := make(chan item)
+	chB := make(chan item)
+
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	grp.Go("a", func(ctx context.Context) error {
+		return streamTree(ctx, fsA, treeID, full, chA)
+	})
+	grp.Go("b", func(ctx context.Context) error {
+		return streamTree(ctx, fsB, treeID, full, chB)
+	})
+
+	dumper := spew.NewDefaultConfig()
+	dumper.DisablePointerAddresses = true
+	report := func(op string, it item) {
+		textui.Fprintf(out, "%s %v\n", op, it.Key.Format(treeID))
+		if full {
+			textui.Fprintf(out, "%s\n", dumper.Sdump(it.Body))
+			it.Body.Free()
+		}
+	}
+
+	var numDiff int
+	a, okA := <-chA
+	b, okB := <-chB
+	for okA || okB {
+		var cmp int
+		switch {
+		case !okA:
+			cmp = 1
+		case !okB:
+			cmp = -1
+		default:
+			cmp = a.Key.Compare(b.Key)
+		}
+		switch {
+		case cmp < 0:
+			numDiff++
+			report("-", a)
+			a, okA = <-chA
+		case cmp > 0:
+			numDiff++
+			report("+", b)
+			b, okB = <-chB
+		default:
+			if !bytes.Equal(a.Dat, b.Dat) {
+				numDiff++
+				textui.Fprintf(out, "~ %v\n", a.Key.Format(treeID))
+				if full {
+					textui.Fprintf(out, "- %s\n+ %s\n", dumper.Sdump(a.Body), dumper.Sdump(b.Body))
+				}
+			}
+			if full {
+				a.Body.Free()
+				b.Body.Free()
+			}
+			a, okA = <-chA
+			b, okB = <-chB
+		}
+	}
+
+	if err := grp.Wait(); err != nil {
+		return numDiff, err
+	}
+	return numDiff, nil
+}
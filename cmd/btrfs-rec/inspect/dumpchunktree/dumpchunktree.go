@@ -0,0 +1,142 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package dumpchunktree is the guts of the `btrfs-rec inspect
+// dump-chunk-tree` command, which prints the chunk mappings from the
+// superblocks' sys_chunk_arrays and from the chunk tree, without
+// relying on any other tree.
+package dumpchunktree
+
+import (
+	"context"
+	"io"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// DumpChunkTree writes to `out` the SYSTEM chunks in the
+// sys_chunk_array of each device's superblock, followed by every
+// chunk and device item in the chunk tree.  Unreadable nodes and
+// items are reported and skipped rather than aborting the dump, and
+// SYSTEM chunks that are missing from (or that disagree with) the
+// chunk tree are reported at the end.
+//
+// The number of problems found is returned.
+func DumpChunkTree(ctx context.Context, out io.Writer, fs *btrfs.FS) int {
+	var numBad int
+	report := func(format string, args ...any) {
+		numBad++
+		textui.Fprintf(out, "error: "+format+"\n", args...)
+	}
+
+	// sys_chunk_array /////////////////////////////////////////////////////
+
+	sysChunks := make(map[btrfsvol.LogicalAddr]btrfstree.SysChunk)
+	devs := fs.LV.PhysicalVolumes()
+	for _, devID := range maps.SortedKeys(devs) {
+		dev := devs[devID]
+		textui.Fprintf(out, "device %v (%q) sys_chunk_array:\n", devID, dev.Name())
+		sb, err := dev.Superblock()
+		if err != nil {
+			report("device %v: %v", devID, err)
+			continue
+		}
+		chunks, err := sb.ParseSysChunkArray()
+		if err != nil {
+			report("device %v: sys_chunk_array: %v", devID, err)
+		}
+		for _, chunk := range chunks {
+			printChunk(out, chunk.Key, chunk.Chunk)
+			laddr := btrfsvol.LogicalAddr(chunk.Key.Offset)
+			if _, ok := sysChunks[laddr]; !ok {
+				sysChunks[laddr] = chunk
+			}
+		}
+	}
+
+	// chunk tree //////////////////////////////////////////////////////////
+
+	tree, err := fs.RawTree(ctx, btrfsprim.CHUNK_TREE_OBJECTID)
+	if err != nil {
+		report("chunk tree: %v", err)
+		return numBad
+	}
+	textui.Fprintf(out, "chunk tree root@%v level %v generation %v:\n",
+		tree.RootNode, tree.Level, tree.Generation)
+	seen := make(map[btrfsvol.LogicalAddr]bool)
+	handlers := btrfstree.TreeWalkHandler{
+		BadSuperblock: func(err error) {
+			report("chunk tree: %v", err)
+		},
+		BadNode: func(path btrfstree.Path, node *btrfstree.Node, err error) bool {
+			report("chunk tree: %v: %v", path, err)
+			// Salvage whatever we can from the node, if it
+			// was read at all.
+			return node != nil
+		},
+		Item: func(path btrfstree.Path, item btrfstree.Item) {
+			switch body := item.Body.(type) {
+			case *btrfsitem.Chunk:
+				printChunk(out, item.Key, *body)
+				laddr := btrfsvol.LogicalAddr(item.Key.Offset)
+				if sysChunk, ok := sysChunks[laddr]; ok {
+					seen[laddr] = true
+					if !chunksEqual(sysChunk.Chunk, *body) {
+						report("chunk tree: %v: SYSTEM chunk at laddr=%v does not match the sys_chunk_array",
+							path, laddr)
+					}
+				}
+			case *btrfsitem.Dev:
+				textui.Fprintf(out, "\tkey %v\n", item.Key.Format(btrfsprim.CHUNK_TREE_OBJECTID))
+				textui.Fprintf(out, "\t\tdevid %d total_bytes %v bytes_used %v\n",
+					body.DevID, body.NumBytes, body.NumBytesUsed)
+				textui.Fprintf(out, "\t\tuuid %v\n", body.DevUUID)
+			case *btrfsitem.Error:
+				report("chunk tree: %v: %v", path, body.Err)
+			default:
+				textui.Fprintf(out, "\tkey %v\n", item.Key.Format(btrfsprim.CHUNK_TREE_OBJECTID))
+			}
+		},
+	}
+	handlers.BadItem = handlers.Item
+	tree.TreeWalk(ctx, handlers)
+
+	for _, laddr := range maps.SortedKeys(sysChunks) {
+		if !seen[laddr] {
+			report("SYSTEM chunk at laddr=%v from the sys_chunk_array is missing from the chunk tree", laddr)
+		}
+	}
+
+	return numBad
+}
+
+func printChunk(out io.Writer, key btrfsprim.Key, chunk btrfsitem.Chunk) {
+	textui.Fprintf(out, "\tkey %v\n", key.Format(btrfsprim.CHUNK_TREE_OBJECTID))
+	textui.Fprintf(out, "\t\tlength %d owner %d stripe_len %v type %v\n",
+		chunk.Head.Size, chunk.Head.Owner, chunk.Head.StripeLen, chunk.Head.Type)
+	textui.Fprintf(out, "\t\tnum_stripes %v sub_stripes %v\n",
+		chunk.Head.NumStripes, chunk.Head.SubStripes)
+	for i, stripe := range chunk.Stripes {
+		textui.Fprintf(out, "\t\t\tstripe %v devid %d offset %d dev_uuid %v\n",
+			i, stripe.DeviceID, stripe.Offset, stripe.DeviceUUID)
+	}
+}
+
+func chunksEqual(a, b btrfsitem.Chunk) bool {
+	if a.Head != b.Head || len(a.Stripes) != len(b.Stripes) {
+		return false
+	}
+	for i := range a.Stripes {
+		if a.Stripes[i] != b.Stripes[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package dumptree is the guts of the `btrfs-rec inspect dump-tree`
+// command, which prints each node header and a one-line summary of
+// each item, without decoding the item bodies.
+package dumptree
+
+import (
+	"context"
+	"io"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// DumpTree writes to `out` a summary of each node in the tree
+// `treeID`, or in every tree if `treeID` is not set.  Unreadable
+// nodes are logged, and whatever could be parsed of them is still
+// dumped; only failing to look up the tree `treeID` is an error.
+func DumpTree(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, treeID containers.Optional[btrfsprim.ObjID]) error {
+	if !treeID.OK {
+		btrfsutil.WalkAllTrees(ctx, fs, btrfsutil.WalkAllTreesHandler{
+			PreTree: func(name string, treeID btrfsprim.ObjID) {
+				textui.Fprintf(out, "tree id=%v name=%q\n", treeID, name)
+			},
+			BadTree: func(name string, _ btrfsprim.ObjID, err error) {
+				dlog.Errorf(ctx, "%v: %v", name, err)
+			},
+			Tree: handler(ctx, out),
+		})
+		return nil
+	}
+
+	tree, err := fs.ForrestLookup(ctx, treeID.Val)
+	if err != nil {
+		return err
+	}
+	tree.TreeWalk(ctx, handler(ctx, out))
+	return nil
+}
+
+func handler(ctx context.Context, out io.Writer) btrfstree.TreeWalkHandler {
+	return btrfstree.TreeWalkHandler{
+		Node: func(_ btrfstree.Path, node *btrfstree.Node) {
+			dumpNode(out, node)
+		},
+		BadNode: func(path btrfstree.Path, node *btrfstree.Node, err error) bool {
+			dlog.Errorf(ctx, "%v: %v", path, err)
+			if node != nil {
+				dumpNode(out, node)
+			}
+			return false
+		},
+	}
+}
+
+func dumpNode(out io.Writer, node *btrfstree.Node) {
+	textui.Fprintf(out, "node@%v level=%v items=%v gen=%v owner=%v\n",
+		node.Head.Addr,
+		node.Head.Level,
+		node.Head.NumItems,
+		node.Head.Generation,
+		node.Head.Owner.Format(btrfsprim.ROOT_TREE_OBJECTID))
+	if node.Head.Level > 0 {
+		for i, kp := range node.BodyInterior {
+			textui.Fprintf(out, "\tkey %v %v block=%v gen=%v\n",
+				i, kp.Key.Format(node.Head.Owner), kp.BlockPtr, kp.Generation)
+		}
+		return
+	}
+	// Print the offsets from the item headers, rather than
+	// assuming that the bodies are tightly packed; a node with
+	// gaps or overlaps between the bodies is exactly the kind of
+	// thing that someone might be using this to look at.
+	for i, item := range node.BodyLeaf {
+		textui.Fprintf(out, "\titem %v %v itemoff=%v itemsize=%v\n",
+			i, item.Key.Format(node.Head.Owner), item.BodyOffset, item.BodySize)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package dumptree_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/dumptree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+// nodesFS is a btrfs.ReadableFS with a single tree, whose walk
+// visits a fixed list of nodes (each of which may be bad).
+type nodesFS struct {
+	btrfs.ReadableFS
+	treeID btrfsprim.ObjID
+	nodes  []*btrfstree.Node
+	errs   []error
+}
+
+func (fs nodesFS) ForrestLookup(_ context.Context, treeID btrfsprim.ObjID) (btrfstree.Tree, error) {
+	if treeID != fs.treeID {
+		return nil, fmt.Errorf("tree %v: %w", treeID, btrfstree.ErrNoTree)
+	}
+	return nodesTree{treeID: fs.treeID, nodes: fs.nodes, errs: fs.errs}, nil
+}
+
+type nodesTree struct {
+	btrfstree.Tree
+	treeID btrfsprim.ObjID
+	nodes  []*btrfstree.Node
+	errs   []error
+}
+
+func (tree nodesTree) TreeWalk(_ context.Context, cbs btrfstree.TreeWalkHandler) {
+	for i, node := range tree.nodes {
+		path := btrfstree.Path{btrfstree.PathRoot{TreeID: tree.treeID, ToAddr: node.Head.Addr}}
+		if tree.errs[i] == nil {
+			cbs.Node(path, node)
+		} else {
+			cbs.BadNode(path, node, tree.errs[i])
+		}
+	}
+}
+
+func TestDumpTree(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	const nodeSize = 4096
+
+	devExt := func(paddr uint64) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{
+				ObjectID: 1,
+				ItemType: btrfsitem.DEV_EXTENT_KEY,
+				Offset:   paddr,
+			},
+			Body: &btrfsitem.DevExtent{
+				ChunkTree:     btrfsprim.CHUNK_TREE_OBJECTID,
+				ChunkObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+				ChunkOffset:   0x100000,
+				Length:        0x100000,
+			},
+		}
+	}
+	newLeaf := func(t *testing.T, addr int64, gap uint32) (*btrfstree.Node, error) {
+		t.Helper()
+		orig := btrfstree.Node{
+			Size:         nodeSize,
+			ChecksumType: btrfssum.TYPE_CRC32,
+			Head: btrfstree.NodeHeader{
+				Addr:       btrfsvol.LogicalAddr(addr),
+				Generation: 5,
+				Owner:      btrfsprim.DEV_TREE_OBJECTID,
+			},
+			BodyLeaf: []btrfstree.Item{devExt(0x100000), devExt(0x200000)},
+		}
+		dat, err := orig.MarshalBinary()
+		require.NoError(t, err)
+		// Move the second item's body down, leaving a gap
+		// between it and the first item's body.
+		headSize := binstruct.StaticSize(btrfstree.NodeHeader{})
+		itemHeadSize := binstruct.StaticSize(btrfstree.ItemHeader{})
+		dataOffPos := headSize + itemHeadSize + 0x11
+		dataOff := binary.LittleEndian.Uint32(dat[dataOffPos:])
+		binary.LittleEndian.PutUint32(dat[dataOffPos:], dataOff-gap)
+		node := &btrfstree.Node{ChecksumType: btrfssum.TYPE_CRC32}
+		_, err = node.UnmarshalBinary(dat)
+		return node, err
+	}
+
+	good, err := newLeaf(t, 0x10000, 0)
+	require.NoError(t, err)
+	bad, badErr := newLeaf(t, 0x20000, 8)
+	require.Error(t, badErr)
+
+	var out bytes.Buffer
+	fs := nodesFS{
+		treeID: btrfsprim.DEV_TREE_OBJECTID,
+		nodes:  []*btrfstree.Node{good, bad},
+		errs:   []error{nil, badErr},
+	}
+	require.NoError(t, dumptree.DumpTree(ctx, &out, fs, containers.OptionalValue(btrfsprim.DEV_TREE_OBJECTID)))
+	// The node body is 4096-101=3995 bytes, and each DEV_EXTENT
+	// is 48 bytes; in the bad node, the second body is 8 bytes
+	// further down than it would be if the bodies were packed.
+	assert.Equal(t, ""+
+		"node@0x0000000000010000 level=0 items=2 gen=5 owner=DEV_TREE\n"+
+		"\titem 0 (1 DEV_EXTENT 1048576) itemoff=3,947 itemsize=48\n"+
+		"\titem 1 (1 DEV_EXTENT 2097152) itemoff=3,899 itemsize=48\n"+
+		"node@0x0000000000020000 level=0 items=2 gen=5 owner=DEV_TREE\n"+
+		"\titem 0 (1 DEV_EXTENT 1048576) itemoff=3,947 itemsize=48\n"+
+		"\titem 1 (1 DEV_EXTENT 2097152) itemoff=3,891 itemsize=48\n",
+		out.String())
+
+	assert.ErrorIs(t, dumptree.DumpTree(ctx, &out, fs, containers.OptionalValue(btrfsprim.FS_TREE_OBJECTID)), btrfstree.ErrNoTree)
+}
@@ -139,8 +139,11 @@ func printTree(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, treeID b
 					textui.Fprintf(out, "\t\tindex %v namelen %v name: %s\n",
 						ref.Index, ref.NameLen, ref.Name)
 				}
-			// case btrfsitem.INODE_EXTREF_KEY:
-			// 	// TODO
+			case *btrfsitem.InodeExtRefs:
+				for _, ref := range body.Refs {
+					textui.Fprintf(out, "\t\tindex %v parent %v namelen %v name: %s\n",
+						ref.Index, ref.Parent, ref.NameLen, ref.Name)
+				}
 			case *btrfsitem.DirEntry:
 				textui.Fprintf(out, "\t\tlocation key %v type %v\n",
 					body.Location.Format(treeID), body.Type)
@@ -308,6 +311,8 @@ func printTree(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, treeID b
 					uint64(body.Flags),
 					body.MaxReferenced, body.MaxExclusive,
 					body.RsvReferenced, body.RsvExclusive)
+			case *btrfsitem.QGroupRelation:
+				// do nothing
 			case *btrfsitem.UUIDMap:
 				textui.Fprintf(out, "\t\tsubvol_id %d\n", body.ObjID)
 			// case btrfsitem.STRING_ITEM_KEY:
@@ -339,8 +344,6 @@ func printTree(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, treeID b
 					textui.Fprintf(out, "\t\tshared block backref\n")
 				case btrfsitem.FREE_SPACE_EXTENT_KEY: // 199
 					textui.Fprintf(out, "\t\tfree space extent\n")
-				case btrfsitem.QGROUP_RELATION_KEY: // 246
-					// do nothing
 				// case btrfsitem.EXTENT_REF_V0_KEY:
 				// 	textui.Fprintf(out, "\t\textent ref v0 (deprecated)\n")
 				// case btrfsitem.CSUM_ITEM_KEY:
@@ -348,6 +351,8 @@ func printTree(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, treeID b
 				default:
 					textui.Fprintf(out, "\t\t(error) unhandled empty item type: %v\n", item.Key.ItemType)
 				}
+			case *btrfsitem.UntypedUnknown:
+				textui.Fprintf(out, "\t\tunknown untyped item objectid %v (%v bytes)\n", body.ObjID, len(body.Dat))
 			case *btrfsitem.Error:
 				textui.Fprintf(out, "\t\t(error) error item: %v\n", body.Err)
 			default:
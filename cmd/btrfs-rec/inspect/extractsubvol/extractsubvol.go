@@ -0,0 +1,259 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package extractsubvol is the guts of the `btrfs-rec inspect
+// extract-subvol` command, which writes the contents of a subvolume
+// as a tar archive.
+package extractsubvol
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
+)
+
+// ExtractSubvol writes the subvolume `treeID` to `out` as a tar
+// archive, preserving ownership, modes, times, symlinks, hard links,
+// device numbers, and xattrs.  Child subvolumes are included as empty
+// directories; they are not descended in to.
+//
+// Problems with individual files do not abort the archive; they are
+// logged, and the file is written as best as possible (unreadable
+// blocks are filled with zeros).  The number of problems is returned.
+// A non-nil error is only returned if writing the archive failed.
+//
+// `cacheSize` is passed to btrfs.NewSubvolume.
+func ExtractSubvol(
+	ctx context.Context,
+	out io.Writer,
+	fs btrfs.ReadableFS,
+	treeID btrfsprim.ObjID,
+	lenientChecksums bool,
+	cacheSize int,
+) (int, error) {
+	e := &extractor{
+		ctx:       ctx,
+		tw:        tar.NewWriter(out),
+		sv:        btrfs.NewSubvolume(ctx, fs, treeID, false, lenientChecksums, cacheSize),
+		hardlinks: make(map[btrfsprim.ObjID]string),
+	}
+
+	rootInode, err := e.sv.GetRootInode()
+	if err != nil {
+		e.warnf("/", "%v", err)
+		return e.numBad, e.tw.Close()
+	}
+	if err := e.extractDir(".", rootInode); err != nil {
+		return e.numBad, err
+	}
+	return e.numBad, e.tw.Close()
+}
+
+type extractor struct {
+	ctx context.Context //nolint:containedctx // this is a short-lived object
+	tw  *tar.Writer
+	sv  *btrfs.Subvolume
+
+	// hardlinks maps inode numbers of non-directories to the
+	// first path that they were written at.
+	hardlinks map[btrfsprim.ObjID]string
+
+	numBad int
+}
+
+func (e *extractor) warnf(name string, format string, args ...any) {
+	e.numBad++
+	dlog.Errorf(e.ctx, "subvol=%v %q: "+format,
+		append([]any{e.sv.TreeID, name}, args...)...)
+}
+
+// header returns a tar header for the given inode, with everything
+// but the type and the size filled in.
+func (e *extractor) header(name string, inode btrfs.FullInode) *tar.Header {
+	for _, err := range inode.Errs {
+		e.warnf(name, "%v", err)
+	}
+	hdr := &tar.Header{
+		Name:   name,
+		Format: tar.FormatPAX,
+	}
+	if inode.InodeItem == nil {
+		e.warnf(name, "missing INODE_ITEM")
+		hdr.Mode = 0o600
+		return hdr
+	}
+	hdr.Mode = int64(inode.InodeItem.Mode & btrfsitem.ModePerm)
+	hdr.Uid = int(inode.InodeItem.UID)
+	hdr.Gid = int(inode.InodeItem.GID)
+	hdr.ModTime = inode.InodeItem.MTime.ToStd()
+	hdr.AccessTime = inode.InodeItem.ATime.ToStd()
+	hdr.ChangeTime = inode.InodeItem.CTime.ToStd()
+	for _, xattrName := range maps.SortedKeys(inode.XAttrs) {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string, len(inode.XAttrs))
+		}
+		hdr.PAXRecords["SCHILY.xattr."+xattrName] = inode.XAttrs[xattrName]
+	}
+	return hdr
+}
+
+func (e *extractor) writeHeader(hdr *tar.Header) error {
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("%q: %w", hdr.Name, err)
+	}
+	return nil
+}
+
+func (e *extractor) extractDir(name string, inode btrfsprim.ObjID) error {
+	dir, err := e.sv.AcquireDir(inode)
+	if err != nil {
+		e.warnf(name, "%v", err)
+		return nil
+	}
+	hdr := e.header(name+"/", dir.FullInode)
+	hdr.Typeflag = tar.TypeDir
+	children := dir.ChildrenByName
+	e.sv.ReleaseDir(inode)
+	if err := e.writeHeader(hdr); err != nil {
+		return err
+	}
+
+	for _, childName := range maps.SortedKeys(children) {
+		if err := e.extractDirEntry(path.Join(name, childName), children[childName]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) extractDirEntry(name string, entry btrfsitem.DirEntry) error {
+	if e.ctx.Err() != nil {
+		return e.ctx.Err()
+	}
+	if len(entry.Data) != 0 {
+		e.warnf(name, "ignoring unexpected dirent data: %q", entry.Data)
+	}
+	if tree, ok := entry.TargetTree(); ok {
+		if entry.Type != btrfsitem.FT_DIR {
+			e.warnf(name, "subvolume dirent has type=%v", entry.Type)
+		}
+		dlog.Infof(e.ctx, "subvol=%v %q: not descending in to child subvolume %v",
+			e.sv.TreeID, name, tree)
+		return e.writeHeader(&tar.Header{
+			Name:     name + "/",
+			Typeflag: tar.TypeDir,
+			Mode:     0o755,
+			Format:   tar.FormatPAX,
+		})
+	}
+	inode, ok := entry.TargetInode()
+	if !ok {
+		e.warnf(name, "skipping: dirent has unexpected location.ItemType=%v", entry.Location.ItemType)
+		return nil
+	}
+
+	if entry.Type == btrfsitem.FT_DIR {
+		return e.extractDir(name, inode)
+	}
+
+	if first, ok := e.hardlinks[inode]; ok {
+		return e.writeHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeLink,
+			Linkname: first,
+			Format:   tar.FormatPAX,
+		})
+	}
+
+	file, err := e.sv.AcquireFile(inode)
+	if err != nil {
+		e.warnf(name, "%v", err)
+		return nil
+	}
+	defer e.sv.ReleaseFile(inode)
+	hdr := e.header(name, file.FullInode)
+	if file.InodeItem != nil && file.InodeItem.NLink > 1 {
+		e.hardlinks[inode] = name
+	}
+
+	switch entry.Type {
+	case btrfsitem.FT_REG_FILE:
+		hdr.Typeflag = tar.TypeReg
+		if file.InodeItem != nil {
+			hdr.Size = file.InodeItem.Size
+		}
+		if err := e.writeHeader(hdr); err != nil {
+			return err
+		}
+		return e.copyFile(name, file, hdr.Size)
+	case btrfsitem.FT_SYMLINK:
+		hdr.Typeflag = tar.TypeSymlink
+		if file.InodeItem != nil {
+			tgt, err := io.ReadAll(io.NewSectionReader(file, 0, file.InodeItem.Size))
+			if err != nil {
+				e.warnf(name, "symlink target: %v", err)
+			}
+			hdr.Linkname = string(tgt)
+		}
+		return e.writeHeader(hdr)
+	case btrfsitem.FT_CHRDEV, btrfsitem.FT_BLKDEV:
+		hdr.Typeflag = tar.TypeChar
+		if entry.Type == btrfsitem.FT_BLKDEV {
+			hdr.Typeflag = tar.TypeBlock
+		}
+		if file.InodeItem != nil {
+			// The kernel stores its internal dev_t
+			// representation: include/linux/kdev_t.h
+			hdr.Devmajor = file.InodeItem.RDev >> 20
+			hdr.Devminor = file.InodeItem.RDev & 0xfffff
+		}
+		return e.writeHeader(hdr)
+	case btrfsitem.FT_FIFO:
+		hdr.Typeflag = tar.TypeFifo
+		return e.writeHeader(hdr)
+	case btrfsitem.FT_SOCK:
+		dlog.Infof(e.ctx, "subvol=%v %q: skipping socket (tar can't represent sockets)",
+			e.sv.TreeID, name)
+		return nil
+	default:
+		e.warnf(name, "skipping: unknown file type %v", entry.Type)
+		return nil
+	}
+}
+
+// copyFile writes `size` bytes of `file` to the archive.  Since the
+// size has already been committed to in the tar header, blocks that
+// can't be read are written as zeros rather than aborting.
+func (e *extractor) copyFile(name string, file *btrfs.File, size int64) error {
+	var buf [btrfssum.BlockSize]byte
+	for off := int64(0); off < size; {
+		if e.ctx.Err() != nil {
+			return e.ctx.Err()
+		}
+		chunk := buf[:slices.Min(int64(len(buf)), size-off)]
+		n, err := file.ReadAt(chunk, off)
+		if err != nil {
+			e.warnf(name, "offset %v: %v (filling the rest of the block with zeros)", off+int64(n), err)
+			for i := range chunk[n:] {
+				chunk[n+i] = 0
+			}
+		}
+		if _, err := e.tw.Write(chunk); err != nil {
+			return fmt.Errorf("%q: %w", name, err)
+		}
+		off += int64(len(chunk))
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package extractsubvol_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/extractsubvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+const (
+	rootDir = btrfsprim.FIRST_FREE_OBJECTID + iota
+	subDir
+	helloFile
+	linkFile
+	fifoFile
+	charDev
+	sockFile
+	brokenFile
+	subvolID = btrfsprim.FIRST_FREE_OBJECTID + 100
+)
+
+const mtime = 1672628645 // 2023-01-02T03:04:05Z
+
+func makeFS() btrfstest.ItemsFS {
+	inode := func(inode btrfsprim.ObjID, mode btrfsitem.StatMode, size int64, nlink int32) btrfstree.Item {
+		ts := btrfsprim.Time{Sec: mtime}
+		return btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY},
+			Body: &btrfsitem.Inode{
+				Mode:     mode,
+				Size:     size,
+				NumBytes: size,
+				NLink:    nlink,
+				UID:      1000,
+				GID:      100,
+				RDev:     8<<20 | 1,
+				ATime:    ts,
+				CTime:    ts,
+				MTime:    ts,
+			},
+		}
+	}
+	var index uint64
+	dirEntry := func(dir btrfsprim.ObjID, name string, typ btrfsitem.FileType, location btrfsprim.Key) []btrfstree.Item {
+		entry := &btrfsitem.DirEntry{
+			Location: location,
+			Type:     typ,
+			Name:     []byte(name),
+		}
+		index++
+		return []btrfstree.Item{
+			{
+				Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: btrfsitem.NameHash(entry.Name)},
+				Body: entry,
+			},
+			{
+				Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_INDEX_KEY, Offset: index + 2},
+				Body: entry,
+			},
+		}
+	}
+	inodeLoc := func(inode btrfsprim.ObjID) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY}
+	}
+	inline := func(inode btrfsprim.ObjID, off uint64, dat string) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: off},
+			Body: &btrfsitem.FileExtent{
+				Type:       btrfsitem.FILE_EXTENT_INLINE,
+				RAMBytes:   int64(len(dat)),
+				BodyInline: []byte(dat),
+			},
+		}
+	}
+
+	// /
+	// ├── dir/
+	// │   ├── hello (with an xattr)
+	// │   └── hello2 (a hard link to hello)
+	// ├── link -> dir/hello
+	// ├── fifo
+	// ├── chr
+	// ├── sock
+	// ├── broken
+	// └── sub/ (another subvolume)
+	var fsTree []btrfstree.Item
+	fsTree = append(fsTree, inode(rootDir, btrfsitem.ModeFmtDir|0o755, 0, 1))
+	fsTree = append(fsTree, dirEntry(rootDir, "dir", btrfsitem.FT_DIR, inodeLoc(subDir))...)
+	fsTree = append(fsTree, dirEntry(rootDir, "link", btrfsitem.FT_SYMLINK, inodeLoc(linkFile))...)
+	fsTree = append(fsTree, dirEntry(rootDir, "fifo", btrfsitem.FT_FIFO, inodeLoc(fifoFile))...)
+	fsTree = append(fsTree, dirEntry(rootDir, "chr", btrfsitem.FT_CHRDEV, inodeLoc(charDev))...)
+	fsTree = append(fsTree, dirEntry(rootDir, "sock", btrfsitem.FT_SOCK, inodeLoc(sockFile))...)
+	fsTree = append(fsTree, dirEntry(rootDir, "broken", btrfsitem.FT_REG_FILE, inodeLoc(brokenFile))...)
+	fsTree = append(fsTree, dirEntry(rootDir, "sub", btrfsitem.FT_DIR, btrfsprim.Key{ObjectID: subvolID, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: math.MaxUint64})...)
+	fsTree = append(fsTree, inode(subDir, btrfsitem.ModeFmtDir|0o700, 0, 1))
+	fsTree = append(fsTree, dirEntry(subDir, "hello", btrfsitem.FT_REG_FILE, inodeLoc(helloFile))...)
+	fsTree = append(fsTree, dirEntry(subDir, "hello2", btrfsitem.FT_REG_FILE, inodeLoc(helloFile))...)
+	fsTree = append(fsTree,
+		inode(helloFile, btrfsitem.ModeFmtRegular|0o644, 13, 2),
+		btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: helloFile, ItemType: btrfsitem.XATTR_ITEM_KEY, Offset: btrfsitem.NameHash([]byte("user.greeting"))},
+			Body: &btrfsitem.DirEntry{
+				Type: btrfsitem.FT_XATTR,
+				Name: []byte("user.greeting"),
+				Data: []byte("hi"),
+			},
+		},
+		inline(helloFile, 0, "hello, world\n"),
+		inode(linkFile, btrfsitem.ModeFmtSymlink|0o777, 9, 1),
+		inline(linkFile, 0, "dir/hello"),
+		inode(fifoFile, btrfsitem.ModeFmtNamedPipe|0o600, 0, 1),
+		inode(charDev, btrfsitem.ModeFmtCharDevice|0o660, 0, 1),
+		inode(sockFile, btrfsitem.ModeFmtSocket|0o755, 0, 1),
+		inode(brokenFile, btrfsitem.ModeFmtRegular|0o644, 5+btrfssum.BlockSize, 1),
+		inline(brokenFile, 0, "head\n"),
+		btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: brokenFile, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 5},
+			Body: &btrfsitem.FileExtent{
+				Type: btrfsitem.FILE_EXTENT_REG,
+				BodyExtent: btrfsitem.FileExtentExtent{
+					DiskByteNr:   1024 * 1024,
+					DiskNumBytes: btrfssum.BlockSize,
+					NumBytes:     btrfssum.BlockSize,
+				},
+			},
+		},
+	)
+	sort.Slice(fsTree, func(i, j int) bool {
+		return fsTree[i].Key.Compare(fsTree[j].Key) < 0
+	})
+	rootItem := func(treeID btrfsprim.ObjID) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: treeID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+			Body: &btrfsitem.Root{RootDirID: rootDir},
+		}
+	}
+	return btrfstest.ItemsFS{
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.ROOT_TREE_OBJECTID: {
+				rootItem(btrfsprim.FS_TREE_OBJECTID),
+				rootItem(subvolID),
+			},
+			btrfsprim.FS_TREE_OBJECTID: fsTree,
+			subvolID:                   fsTree,
+		},
+	}
+}
+
+type tarEntry struct {
+	Name     string
+	Type     byte
+	Mode     int64
+	Uid, Gid int
+	Linkname string
+	Devmajor int64
+	Devminor int64
+	MTime    int64
+	XAttrs   map[string]string
+	Body     string
+}
+
+func TestExtractSubvol(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	var out bytes.Buffer
+	numBad, err := extractsubvol.ExtractSubvol(ctx, &out, makeFS(), btrfsprim.FS_TREE_OBJECTID, true, 0)
+	require.NoError(t, err)
+	// The only problem is the unreadable extent in "broken"; it
+	// is counted twice because the file is read a block at a
+	// time, and the extent straddles 2 blocks.
+	assert.Equal(t, 2, numBad)
+
+	var act []tarEntry
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		ent := tarEntry{
+			Name:     hdr.Name,
+			Type:     hdr.Typeflag,
+			Mode:     hdr.Mode,
+			Uid:      hdr.Uid,
+			Gid:      hdr.Gid,
+			Linkname: hdr.Linkname,
+			Devmajor: hdr.Devmajor,
+			Devminor: hdr.Devminor,
+			Body:     string(body),
+		}
+		if !hdr.ModTime.IsZero() {
+			ent.MTime = hdr.ModTime.Unix()
+		}
+		for k, v := range hdr.PAXRecords {
+			if !strings.HasPrefix(k, "SCHILY.xattr.") {
+				continue
+			}
+			if ent.XAttrs == nil {
+				ent.XAttrs = make(map[string]string)
+			}
+			ent.XAttrs[k] = v
+		}
+		act = append(act, ent)
+	}
+
+	assert.Equal(t, []tarEntry{
+		{Name: "./", Type: tar.TypeDir, Mode: 0o755, Uid: 1000, Gid: 100, MTime: mtime},
+		{Name: "broken", Type: tar.TypeReg, Mode: 0o644, Uid: 1000, Gid: 100, MTime: mtime, Body: "head\n" + string(make([]byte, btrfssum.BlockSize))},
+		{Name: "chr", Type: tar.TypeChar, Mode: 0o660, Uid: 1000, Gid: 100, MTime: mtime, Devmajor: 8, Devminor: 1},
+		{Name: "dir/", Type: tar.TypeDir, Mode: 0o700, Uid: 1000, Gid: 100, MTime: mtime},
+		{Name: "dir/hello", Type: tar.TypeReg, Mode: 0o644, Uid: 1000, Gid: 100, MTime: mtime, XAttrs: map[string]string{"SCHILY.xattr.user.greeting": "hi"}, Body: "hello, world\n"},
+		{Name: "dir/hello2", Type: tar.TypeLink, Linkname: "dir/hello"},
+		{Name: "fifo", Type: tar.TypeFifo, Mode: 0o600, Uid: 1000, Gid: 100, MTime: mtime},
+		{Name: "link", Type: tar.TypeSymlink, Mode: 0o777, Uid: 1000, Gid: 100, MTime: mtime, Linkname: "dir/hello"},
+		{Name: "sub/", Type: tar.TypeDir, Mode: 0o755},
+	}, act)
+
+	// A missing subvolume is a problem, but still writes a
+	// (empty) archive.
+	out.Reset()
+	numBad, err = extractsubvol.ExtractSubvol(ctx, &out, makeFS(), subvolID+1, true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numBad)
+	_, err = tar.NewReader(&out).Next()
+	assert.Equal(t, io.EOF, err)
+}
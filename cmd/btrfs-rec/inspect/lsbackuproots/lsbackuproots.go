@@ -0,0 +1,184 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package lsbackuproots is the guts of the `btrfs-rec inspect
+// ls-backup-roots` command, which lists which trees are intact as of
+// the current superblock and as of each of the superblock's backup
+// roots; telling an operator exactly what they would gain by falling
+// back to a backup root.
+package lsbackuproots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// rootSet is a set of tree roots: either those of the current
+// superblock, or those of one of its backup roots.
+type rootSet struct {
+	Name string
+	SB   btrfstree.Superblock
+}
+
+// nodeSource is a btrfstree.NodeSource that reads nodes from the
+// real filesystem, but reports a different superblock.
+type nodeSource struct {
+	btrfstree.NodeSource
+	sb btrfstree.Superblock
+}
+
+func (src nodeSource) Superblock() (*btrfstree.Superblock, error) {
+	return &src.sb, nil
+}
+
+// LsBackupRoots writes to `out` a table of every tree that can be
+// found from either the current superblock or any of its backup
+// roots, saying whether the tree is intact as of each of those
+// generations, and the newest generation at which it is intact.
+//
+// A tree is considered intact as of a generation if its root can be
+// looked up and its root node can be read and is what was expected;
+// the rest of the tree is not walked.
+func LsBackupRoots(ctx context.Context, out io.Writer, fs btrfs.ReadableFS) error {
+	sb, err := fs.Superblock()
+	if err != nil {
+		return err
+	}
+
+	rootSets := []rootSet{{Name: "current", SB: *sb}}
+	var backups []rootSet
+	for i, backup := range sb.SuperRoots {
+		if backup.TreeRoot == 0 {
+			continue
+		}
+		backups = append(backups, rootSet{
+			Name: fmt.Sprintf("backup %v", i),
+			SB:   sb.WithRootBackup(backup),
+		})
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].SB.Generation > backups[j].SB.Generation
+	})
+	rootSets = append(rootSets, backups...)
+
+	// results[treeID][i] is the status of treeID as of rootSets[i].
+	results := make(map[btrfsprim.ObjID][]error)
+	for i, set := range rootSets {
+		ctx := dlog.WithField(ctx, "btrfs.inspect.ls-backup-roots.roots", set.Name)
+		forrest := btrfstree.RawForrest{NodeSource: nodeSource{NodeSource: fs, sb: set.SB}}
+		for _, treeID := range listTrees(ctx, forrest) {
+			if _, ok := results[treeID]; !ok {
+				results[treeID] = make([]error, len(rootSets))
+				for j := range rootSets {
+					results[treeID][j] = btrfstree.ErrNoTree
+				}
+			}
+			results[treeID][i] = checkTree(ctx, forrest, set.SB, treeID)
+			if err := results[treeID][i]; err != nil && !errors.Is(err, btrfstree.ErrNoTree) {
+				dlog.Errorf(ctx, "tree %v: %v", treeID.Format(btrfsprim.ROOT_TREE_OBJECTID), err)
+			}
+		}
+	}
+
+	table := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0) //nolint:gomnd // This is what looks nice.
+	textui.Fprintf(table, "tree")
+	for _, set := range rootSets {
+		textui.Fprintf(table, "\t%v (gen %v)", set.Name, set.SB.Generation)
+	}
+	textui.Fprintf(table, "\tnewest intact gen\n")
+	var numOnlyBackup int
+	for _, treeID := range maps.SortedKeys(results) {
+		textui.Fprintf(table, "%v", treeID.Format(btrfsprim.ROOT_TREE_OBJECTID))
+		var newest containers.Optional[btrfsprim.Generation]
+		for i, err := range results[treeID] {
+			switch {
+			case err == nil:
+				textui.Fprintf(table, "\tok")
+				if gen := rootSets[i].SB.Generation; !newest.OK || gen > newest.Val {
+					newest = containers.OptionalValue(gen)
+				}
+			case errors.Is(err, btrfstree.ErrNoTree):
+				textui.Fprintf(table, "\t-")
+			default:
+				textui.Fprintf(table, "\tbad")
+			}
+		}
+		switch {
+		case !newest.OK:
+			textui.Fprintf(table, "\tnone\n")
+		case results[treeID][0] != nil:
+			numOnlyBackup++
+			textui.Fprintf(table, "\t%v (only via backup roots)\n", newest.Val)
+		default:
+			textui.Fprintf(table, "\t%v\n", newest.Val)
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	textui.Fprintf(out, "%v trees are intact only via backup roots\n", numOnlyBackup)
+	return nil
+}
+
+// listTrees returns the IDs of the trees with fixed roots in the
+// superblock, and of every tree with a ROOT_ITEM in the root tree.
+func listTrees(ctx context.Context, forrest btrfstree.RawForrest) []btrfsprim.ObjID {
+	treeIDs := containers.NewSet[btrfsprim.ObjID](
+		btrfsprim.ROOT_TREE_OBJECTID,
+		btrfsprim.CHUNK_TREE_OBJECTID,
+	)
+	rootTree, err := forrest.ForrestLookup(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	if err != nil {
+		dlog.Errorf(ctx, "root tree: %v", err)
+		return maps.SortedKeys(treeIDs)
+	}
+	if err := rootTree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType == btrfsitem.ROOT_ITEM_KEY {
+			treeIDs.Insert(item.Key.ObjectID)
+		}
+		return true
+	}); err != nil {
+		dlog.Errorf(ctx, "root tree: %v", err)
+	}
+	return maps.SortedKeys(treeIDs)
+}
+
+// checkTree returns nil if the tree's root can be looked up and its
+// root node read.
+func checkTree(ctx context.Context, forrest btrfstree.RawForrest, sb btrfstree.Superblock, treeID btrfsprim.ObjID) error {
+	root, err := btrfstree.LookupTreeRoot(ctx, forrest, sb, treeID)
+	if err != nil {
+		return err
+	}
+	if root.RootNode == 0 {
+		return btrfstree.ErrNoTree
+	}
+	path := btrfstree.Path{
+		btrfstree.PathRoot{
+			Forrest:      forrest,
+			TreeID:       root.ID,
+			ToAddr:       root.RootNode,
+			ToGeneration: root.Generation,
+			ToLevel:      root.Level,
+		},
+	}
+	addr, exp, _ := path.NodeExpectations(ctx)
+	node, err := forrest.NodeSource.AcquireNode(ctx, addr, exp)
+	forrest.NodeSource.ReleaseNode(node)
+	return err
+}
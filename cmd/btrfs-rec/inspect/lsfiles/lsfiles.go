@@ -20,7 +20,6 @@ import (
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
-	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
 	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
 )
 
@@ -28,6 +27,7 @@ func LsFiles(
 	ctx context.Context,
 	out io.Writer,
 	fs btrfs.ReadableFS,
+	cacheSize int,
 ) (err error) {
 	defer func() {
 		if _err := derror.PanicToError(recover()); _err != nil {
@@ -41,6 +41,8 @@ func LsFiles(
 		fs,
 		btrfsprim.FS_TREE_OBJECTID,
 		false,
+		false,
+		cacheSize,
 	))
 
 	return nil
@@ -108,12 +110,17 @@ func fmtErr(err error) string {
 
 func fmtInode(inode btrfs.BareInode) string {
 	var mode btrfsitem.StatMode
+	var flags btrfsitem.InodeFlags
 	if inode.InodeItem == nil {
 		inode.Errs = append(inode.Errs, errors.New("missing INODE_ITEM"))
 	} else {
 		mode = inode.InodeItem.Mode
+		flags = inode.InodeItem.Flags
 	}
 	ret := textui.Sprintf("ino=%v mode=%v", inode.Inode, mode)
+	if flags != 0 {
+		ret += textui.Sprintf(" flags=%v", flags)
+	}
 	if len(inode.Errs) > 0 {
 		ret += " err=" + fmtErr(inode.Errs)
 	}
@@ -122,8 +129,10 @@ func fmtInode(inode btrfs.BareInode) string {
 
 func printDir(out io.Writer, prefix string, isLast bool, name string, dir *btrfs.Dir) {
 	printText(out, prefix, isLast, name+"/", fmtInode(dir.BareInode))
-	childrenByName := dir.ChildrenByName
 	subvol := dir.SV
+	// Any inconsistencies between the DIR_ITEMs and DIR_INDEXes
+	// are in dir.Errs, which fmtInode already printed.
+	children, _ := subvol.ReadDir(dir.Inode)
 	subvol.ReleaseDir(dir.Inode)
 
 	if isLast {
@@ -131,14 +140,14 @@ func printDir(out io.Writer, prefix string, isLast bool, name string, dir *btrfs
 	} else {
 		prefix += tl
 	}
-	for i, childName := range maps.SortedKeys(childrenByName) {
+	for i, child := range children {
 		printDirEntry(
 			out,
 			prefix,
-			i == len(childrenByName)-1,
+			i == len(children)-1,
 			subvol,
-			path.Join(name, childName),
-			childrenByName[childName])
+			path.Join(name, string(child.Name)),
+			child.DirEntry)
 	}
 }
 
@@ -148,67 +157,72 @@ func printDirEntry(out io.Writer, prefix string, isLast bool, subvol *btrfs.Subv
 	}
 	switch entry.Type {
 	case btrfsitem.FT_DIR:
-		switch entry.Location.ItemType {
-		case btrfsitem.INODE_ITEM_KEY:
-			dir, err := subvol.AcquireDir(entry.Location.ObjectID)
-			if err != nil {
-				printText(out, prefix, isLast, name, textui.Sprintf("%v err=%v", entry.Type, fmtErr(err)))
-				return
-			}
-			printDir(out, prefix, isLast, name, dir)
-		case btrfsitem.ROOT_ITEM_KEY:
-			printSubvol(out, prefix, isLast, name, subvol.NewChildSubvolume(entry.Location.ObjectID))
-		default:
+		if tree, ok := entry.TargetTree(); ok {
+			printSubvol(out, prefix, isLast, name, subvol.NewChildSubvolume(tree))
+			return
+		}
+		inode, ok := entry.TargetInode()
+		if !ok {
 			panic(fmt.Errorf("TODO: I don't know how to handle an FT_DIR with location.ItemType=%v: %q",
 				entry.Location.ItemType, name))
 		}
+		dir, err := subvol.AcquireDir(inode)
+		if err != nil {
+			printText(out, prefix, isLast, name, textui.Sprintf("%v err=%v", entry.Type, fmtErr(err)))
+			return
+		}
+		printDir(out, prefix, isLast, name, dir)
 	case btrfsitem.FT_SYMLINK:
-		if entry.Location.ItemType != btrfsitem.INODE_ITEM_KEY {
+		inode, ok := entry.TargetInode()
+		if !ok {
 			panic(fmt.Errorf("TODO: I don't know how to handle an FT_SYMLINK with location.ItemType=%v: %q",
 				entry.Location.ItemType, name))
 		}
-		file, err := subvol.AcquireFile(entry.Location.ObjectID)
+		file, err := subvol.AcquireFile(inode)
 		if err != nil {
 			printText(out, prefix, isLast, name, textui.Sprintf("%v err=%v", entry.Type, fmtErr(err)))
 			return
 		}
-		defer subvol.ReleaseFile(entry.Location.ObjectID)
+		defer subvol.ReleaseFile(inode)
 		printSymlink(out, prefix, isLast, name, file)
 	case btrfsitem.FT_REG_FILE:
-		if entry.Location.ItemType != btrfsitem.INODE_ITEM_KEY {
+		inode, ok := entry.TargetInode()
+		if !ok {
 			panic(fmt.Errorf("TODO: I don't know how to handle an FT_REG_FILE with location.ItemType=%v: %q",
 				entry.Location.ItemType, name))
 		}
-		file, err := subvol.AcquireFile(entry.Location.ObjectID)
+		file, err := subvol.AcquireFile(inode)
 		if err != nil {
 			printText(out, prefix, isLast, name, textui.Sprintf("%v err=%v", entry.Type, fmtErr(err)))
 			return
 		}
-		defer subvol.ReleaseFile(entry.Location.ObjectID)
+		defer subvol.ReleaseFile(inode)
 		printFile(out, prefix, isLast, name, file)
 	case btrfsitem.FT_SOCK:
-		if entry.Location.ItemType != btrfsitem.INODE_ITEM_KEY {
+		inode, ok := entry.TargetInode()
+		if !ok {
 			panic(fmt.Errorf("TODO: I don't know how to handle an FT_SOCK with location.ItemType=%v: %q",
 				entry.Location.ItemType, name))
 		}
-		file, err := subvol.AcquireFile(entry.Location.ObjectID)
+		file, err := subvol.AcquireFile(inode)
 		if err != nil {
 			printText(out, prefix, isLast, name, textui.Sprintf("%v err=%v", entry.Type, fmtErr(err)))
 			return
 		}
-		defer subvol.ReleaseFile(entry.Location.ObjectID)
+		defer subvol.ReleaseFile(inode)
 		printSocket(out, prefix, isLast, name, file)
 	case btrfsitem.FT_FIFO:
-		if entry.Location.ItemType != btrfsitem.INODE_ITEM_KEY {
+		inode, ok := entry.TargetInode()
+		if !ok {
 			panic(fmt.Errorf("TODO: I don't know how to handle an FT_FIFO with location.ItemType=%v: %q",
 				entry.Location.ItemType, name))
 		}
-		file, err := subvol.AcquireFile(entry.Location.ObjectID)
+		file, err := subvol.AcquireFile(inode)
 		if err != nil {
 			printText(out, prefix, isLast, name, textui.Sprintf("%v err=%v", entry.Type, fmtErr(err)))
 			return
 		}
-		defer subvol.ReleaseFile(entry.Location.ObjectID)
+		defer subvol.ReleaseFile(inode)
 		printPipe(out, prefix, isLast, name, file)
 	default:
 		panic(fmt.Errorf("TODO: I don't know how to handle a fileType=%v: %q",
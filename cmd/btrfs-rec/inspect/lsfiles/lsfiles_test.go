@@ -0,0 +1,85 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package lsfiles_test
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/lsfiles"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+func TestLsFiles(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	const (
+		rootDir = btrfsprim.FIRST_FREE_OBJECTID + iota
+		zebraFile
+		appleFile
+	)
+	inode := func(inode btrfsprim.ObjID, mode btrfsitem.StatMode) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY},
+			Body: &btrfsitem.Inode{Mode: mode},
+		}
+	}
+	dirEntries := func(dir btrfsprim.ObjID, index uint64, name string, inode btrfsprim.ObjID) []btrfstree.Item {
+		entry := &btrfsitem.DirEntry{
+			Location: btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY},
+			Type:     btrfsitem.FT_REG_FILE,
+			Name:     []byte(name),
+		}
+		return []btrfstree.Item{
+			{
+				Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: btrfsitem.NameHash([]byte(name))},
+				Body: entry,
+			},
+			{
+				Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_INDEX_KEY, Offset: index},
+				Body: entry,
+			},
+		}
+	}
+
+	// "zebra" was created before "apple", so it comes first in
+	// the directory, even though it sorts after it by name.
+	fsTree := []btrfstree.Item{
+		inode(rootDir, btrfsitem.ModeFmtDir|0o755),
+		inode(zebraFile, btrfsitem.ModeFmtRegular|0o644),
+		inode(appleFile, btrfsitem.ModeFmtRegular|0o644),
+	}
+	fsTree = append(fsTree, dirEntries(rootDir, 2, "zebra", zebraFile)...)
+	fsTree = append(fsTree, dirEntries(rootDir, 3, "apple", appleFile)...)
+	sort.Slice(fsTree, func(i, j int) bool {
+		return fsTree[i].Key.Compare(fsTree[j].Key) < 0
+	})
+	fs := btrfstest.ItemsFS{
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.ROOT_TREE_OBJECTID: {{
+				Key:  btrfsprim.Key{ObjectID: btrfsprim.FS_TREE_OBJECTID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+				Body: &btrfsitem.Root{RootDirID: rootDir},
+			}},
+			btrfsprim.FS_TREE_OBJECTID: fsTree,
+		},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, lsfiles.LsFiles(ctx, &out, fs, 0))
+	assert.Equal(t, ""+
+		"└── \"//\" ino=256 mode=drwxr-xr-x\n"+
+		"\u00a0\u00a0\u00a0 ├── \"/zebra\" ino=257 mode=-rw-r--r--\n"+
+		"\u00a0\u00a0\u00a0 └── \"/apple\" ino=258 mode=-rw-r--r--\n",
+		out.String())
+}
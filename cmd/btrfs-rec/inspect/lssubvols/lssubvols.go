@@ -0,0 +1,94 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package lssubvols is the guts of the `btrfs-rec inspect ls-subvols`
+// command, which lists the subvolumes in the ROOT_TREE along with
+// their send/receive provenance.
+package lssubvols
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+func fmtOptUUID(uuid btrfsprim.UUID) string {
+	if uuid == (btrfsprim.UUID{}) {
+		return "-"
+	}
+	return uuid.String()
+}
+
+func fmtOptTime(t btrfsprim.Time) string {
+	if t == (btrfsprim.Time{}) {
+		return "-"
+	}
+	return t.String()
+}
+
+// LsSubvols writes a table to `out` with a row for each subvolume's
+// ROOT_ITEM: its ID, UUID, parent UUID, received UUID, and the
+// stransid/rtransid/rtime that 'btrfs receive' records.  If
+// `onlyReceived` is true, then subvolumes that have no received UUID
+// are skipped.
+//
+// Unreadable ROOT_ITEMs are logged and skipped; an error reading the
+// ROOT_TREE itself is returned, but only after the rows that could be
+// read have been written.
+func LsSubvols(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, onlyReceived bool) error {
+	rootTree, err := fs.ForrestLookup(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0) //nolint:gomnd // This is what looks nice.
+	textui.Fprintf(table, "id\tuuid\tparent_uuid\treceived_uuid\tstransid\trtransid\trtime\tflags\n")
+	err = rootTree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType != btrfsitem.ROOT_ITEM_KEY {
+			return true
+		}
+		if item.Key.ObjectID != btrfsprim.FS_TREE_OBJECTID &&
+			(item.Key.ObjectID < btrfsprim.FIRST_FREE_OBJECTID || item.Key.ObjectID > btrfsprim.LAST_FREE_OBJECTID) {
+			return true
+		}
+		switch body := item.Body.(type) {
+		case *btrfsitem.Root:
+			if onlyReceived && body.ReceivedUUID == (btrfsprim.UUID{}) {
+				return true
+			}
+			textui.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				item.Key.ObjectID,
+				fmtOptUUID(body.UUID),
+				fmtOptUUID(body.ParentUUID),
+				fmtOptUUID(body.ReceivedUUID),
+				body.STransID,
+				body.RTransID,
+				fmtOptTime(body.RTime),
+				body.Flags)
+		case *btrfsitem.Error:
+			dlog.Errorf(ctx, "ROOT_ITEM %v: %v", item.Key, body.Err)
+		default:
+			// This is a panic because the item decoder should not emit ROOT_ITEM items as anything but
+			// btrfsitem.Root or btrfsitem.Error without this code also being updated.
+			panic(fmt.Errorf("should not happen: ROOT_ITEM item has unexpected type: %T", body))
+		}
+		return true
+	})
+	if _err := table.Flush(); _err != nil && err == nil {
+		err = _err
+	}
+	if err != nil {
+		return fmt.Errorf("ROOT_TREE: %w", err)
+	}
+	return nil
+}
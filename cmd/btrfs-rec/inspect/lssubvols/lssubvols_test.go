@@ -0,0 +1,90 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package lssubvols_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/lssubvols"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+func TestLsSubvols(t *testing.T) {
+	t.Parallel()
+	rootItem := func(treeID btrfsprim.ObjID, root btrfsitem.Root) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: treeID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+			Body: &root,
+		}
+	}
+	rootTree := []btrfstree.Item{
+		// Not a subvolume.
+		rootItem(btrfsprim.EXTENT_TREE_OBJECTID, btrfsitem.Root{}),
+		rootItem(btrfsprim.FS_TREE_OBJECTID, btrfsitem.Root{
+			UUID: btrfsprim.MustParseUUID("00000000-0000-0000-0000-00000000000a"),
+		}),
+		// A snapshot of the FS_TREE.
+		rootItem(256, btrfsitem.Root{
+			UUID:       btrfsprim.MustParseUUID("00000000-0000-0000-0000-00000000000b"),
+			ParentUUID: btrfsprim.MustParseUUID("00000000-0000-0000-0000-00000000000a"),
+			Flags:      btrfsitem.ROOT_SUBVOL_RDONLY,
+		}),
+		// Not a ROOT_ITEM.
+		{
+			Key:  btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.ROOT_BACKREF_KEY, Offset: 5},
+			Body: &btrfsitem.RootRef{},
+		},
+		// Received.
+		rootItem(257, btrfsitem.Root{
+			UUID:         btrfsprim.MustParseUUID("00000000-0000-0000-0000-00000000000c"),
+			ReceivedUUID: btrfsprim.MustParseUUID("00000000-0000-0000-0000-0000000000ff"),
+			STransID:     10,
+			RTransID:     12,
+			RTime:        btrfsprim.Time{Sec: 1700000000},
+		}),
+	}
+
+	type TestCase struct {
+		OnlyReceived bool
+		ExpOut       string
+	}
+	testcases := map[string]TestCase{
+		"all": {
+			ExpOut: "" +
+				"id       uuid                                  parent_uuid                           received_uuid                         stransid  rtransid  rtime                 flags\n" +
+				"FS_TREE  00000000-0000-0000-0000-00000000000a  -                                     -                                     0         0         -                     0x0(none)\n" +
+				"256      00000000-0000-0000-0000-00000000000b  00000000-0000-0000-0000-00000000000a  -                                     0         0         -                     0x1(SUBVOL_RDONLY)\n" +
+				"257      00000000-0000-0000-0000-00000000000c  -                                     00000000-0000-0000-0000-0000000000ff  10        12        2023-11-14T22:13:20Z  0x0(none)\n",
+		},
+		"received": {
+			OnlyReceived: true,
+			ExpOut: "" +
+				"id   uuid                                  parent_uuid  received_uuid                         stransid  rtransid  rtime                 flags\n" +
+				"257  00000000-0000-0000-0000-00000000000c  -            00000000-0000-0000-0000-0000000000ff  10        12        2023-11-14T22:13:20Z  0x0(none)\n",
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			ctx := dlog.NewTestContext(t, false)
+			var out bytes.Buffer
+			err := lssubvols.LsSubvols(ctx, &out, btrfstest.ItemsFS{
+				Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+					btrfsprim.ROOT_TREE_OBJECTID: rootTree,
+				},
+			}, tc.OnlyReceived)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ExpOut, out.String())
+		})
+	}
+}
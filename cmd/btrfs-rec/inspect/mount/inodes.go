@@ -0,0 +1,106 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package mount
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+)
+
+// inodeKey identifies an inode across all subvolumes.
+type inodeKey struct {
+	Subvol btrfsprim.ObjID
+	Inode  btrfsprim.ObjID
+}
+
+// inodeTable assigns FUSE inode numbers to inodes from several
+// subvolumes, so that they can all be presented in a single mount.
+// Because each subvolume has its own pool of inode numbers, they
+// can't be passed through to FUSE as-is the way that they are when
+// each subvolume gets its own mount.
+//
+// Numbers are assigned the first time that an inode is looked up,
+// and then remain stable for the life of the mount; which is all
+// that FUSE requires, and is enough for tools like `cp -a` to detect
+// hard links.
+type inodeTable struct {
+	mu      sync.Mutex
+	subvols map[btrfsprim.ObjID]*btrfs.Subvolume
+	byKey   map[inodeKey]fuseops.InodeID
+	byID    map[fuseops.InodeID]inodeKey
+	next    fuseops.InodeID
+	rootErr error
+}
+
+func newInodeTable(root *btrfs.Subvolume) *inodeTable {
+	t := &inodeTable{
+		subvols: map[btrfsprim.ObjID]*btrfs.Subvolume{
+			root.TreeID: root,
+		},
+		byKey: make(map[inodeKey]fuseops.InodeID),
+		byID:  make(map[fuseops.InodeID]inodeKey),
+		next:  fuseops.RootInodeID + 1,
+	}
+	rootInode, err := root.GetRootInode()
+	if err != nil {
+		t.rootErr = err
+	} else {
+		key := inodeKey{Subvol: root.TreeID, Inode: rootInode}
+		t.byKey[key] = fuseops.RootInodeID
+		t.byID[fuseops.RootInodeID] = key
+	}
+	return t
+}
+
+// Subvolume returns the (possibly already-open) subvolume `treeID`,
+// opening it as a child of `parent` if it isn't already open.
+func (t *inodeTable) Subvolume(parent *btrfs.Subvolume, treeID btrfsprim.ObjID) *btrfs.Subvolume {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sv, ok := t.subvols[treeID]
+	if !ok {
+		sv = parent.NewChildSubvolume(treeID)
+		t.subvols[treeID] = sv
+	}
+	return sv
+}
+
+// ID returns the FUSE inode number for inode `inode` in subvolume
+// `sv`, assigning one if it doesn't yet have one.
+func (t *inodeTable) ID(sv *btrfs.Subvolume, inode btrfsprim.ObjID) fuseops.InodeID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := inodeKey{Subvol: sv.TreeID, Inode: inode}
+	id, ok := t.byKey[key]
+	if !ok {
+		id = t.next
+		t.next++
+		t.byKey[key] = id
+		t.byID[id] = key
+		if _, ok := t.subvols[sv.TreeID]; !ok {
+			t.subvols[sv.TreeID] = sv
+		}
+	}
+	return id
+}
+
+// Resolve is the inverse of ID.
+func (t *inodeTable) Resolve(id fuseops.InodeID) (*btrfs.Subvolume, btrfsprim.ObjID, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id == fuseops.RootInodeID && t.rootErr != nil {
+		return nil, 0, t.rootErr
+	}
+	key, ok := t.byID[id]
+	if !ok {
+		return nil, 0, syscall.ENOENT
+	}
+	return t.subvols[key.Subvol], key.Inode, nil
+}
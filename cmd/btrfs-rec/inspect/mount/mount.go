@@ -34,24 +34,51 @@ import (
 	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
 )
 
-func MountRO(ctx context.Context, fs btrfs.ReadableFS, mountpoint string, noChecksums bool) error {
+// MountRO mounts the filesystem read-only at `mountpoint`, blocking
+// until it is unmounted (or `ctx` is canceled).
+//
+// The subvolume with tree ID `subvolID` is mounted at `mountpoint`;
+// if `subvolID` is zero, then the filesystem's default subvolume is
+// used (just as the kernel would), falling back to the top-level
+// FS_TREE subvolume if the default can't be determined.
+//
+// If `unified` is false, then each child subvolume is mounted as a
+// separate FUSE filesystem at the appropriate place under
+// `mountpoint`.  If `unified` is true, then child subvolumes are
+// instead presented as plain directories within a single mount.
+//
+// `cacheSize` is passed to btrfs.NewSubvolume.
+func MountRO(ctx context.Context, fs btrfs.ReadableFS, mountpoint string, subvolID btrfsprim.ObjID, noChecksums, lenientChecksums, unified bool, cacheSize int) error {
 	sb, err := fs.Superblock()
 	if err != nil {
 		return err
 	}
 
+	if subvolID == 0 {
+		subvolID, err = btrfs.DefaultSubvolume(ctx, fs)
+		if err != nil {
+			dlog.Errorf(ctx, "error: %v; using FS_TREE", err)
+			subvolID = btrfsprim.FS_TREE_OBJECTID
+		}
+	}
+
 	rootSubvol := &subvolume{
 		Subvolume: btrfs.NewSubvolume(
 			ctx,
 			fs,
-			btrfsprim.FS_TREE_OBJECTID,
+			subvolID,
 			noChecksums,
+			lenientChecksums,
+			cacheSize,
 		),
 		DeviceName: fs.Name(),
 		Mountpoint: mountpoint,
 
 		sb: sb,
 	}
+	if unified {
+		rootSubvol.inodes = newInodeTable(rootSubvol.Subvolume)
+	}
 	return rootSubvol.Run(ctx)
 }
 
@@ -97,7 +124,8 @@ func fuseMount(ctx context.Context, mountpoint string, server fuse.Server, cfg *
 }
 
 type dirState struct {
-	Dir *btrfs.Dir
+	SV      *btrfs.Subvolume
+	Entries []btrfs.DirEntry
 }
 
 type fileState struct {
@@ -111,6 +139,10 @@ type subvolume struct {
 
 	sb *btrfstree.Superblock
 
+	// inodes is non-nil if child subvolumes are presented as
+	// plain directories rather than as separate mounts.
+	inodes *inodeTable
+
 	fuseutil.NotImplementedFileSystem
 	lastHandle  uint64
 	dirHandles  typedsync.Map[fuseops.HandleID, *dirState]
@@ -143,6 +175,49 @@ func (sv *subvolume) newHandle() fuseops.HandleID {
 	return fuseops.HandleID(atomic.AddUint64(&sv.lastHandle, 1))
 }
 
+// resolveInode translates a FUSE inode number to the subvolume and
+// inode number that it refers to.
+func (sv *subvolume) resolveInode(id fuseops.InodeID) (*btrfs.Subvolume, btrfsprim.ObjID, error) {
+	if sv.inodes != nil {
+		return sv.inodes.Resolve(id)
+	}
+	if id == fuseops.RootInodeID {
+		inode, err := sv.GetRootInode()
+		return sv.Subvolume, inode, err
+	}
+	return sv.Subvolume, btrfsprim.ObjID(id), nil
+}
+
+// fuseInode is the inverse of resolveInode.
+func (sv *subvolume) fuseInode(bsv *btrfs.Subvolume, inode btrfsprim.ObjID) fuseops.InodeID {
+	if sv.inodes != nil {
+		return sv.inodes.ID(bsv, inode)
+	}
+	return fuseops.InodeID(inode)
+}
+
+// direntInode returns the FUSE inode number for an entry in a
+// directory in subvolume `dirSV`.
+func (sv *subvolume) direntInode(dirSV *btrfs.Subvolume, entry btrfsitem.DirEntry) fuseops.InodeID {
+	tree, isSubvol := entry.TargetTree()
+	if sv.inodes == nil || !isSubvol {
+		return sv.fuseInode(dirSV, entry.Location.ObjectID)
+	}
+	childSV := sv.inodes.Subvolume(dirSV, tree)
+	// If this fails, then LookUpInode will report the error.
+	child, _ := childSV.GetRootInode()
+	return sv.inodes.ID(childSV, child)
+}
+
+// acquireDir is like bsv.AcquireDir, but (when not in unified mode)
+// also spawns mounts for any child subvolumes in the directory.
+func (sv *subvolume) acquireDir(bsv *btrfs.Subvolume, inode btrfsprim.ObjID) (*btrfs.Dir, error) {
+	if sv.inodes != nil {
+		return bsv.AcquireDir(inode)
+	}
+	return sv.AcquireDir(inode)
+}
+
 func inodeItemToFUSE(itemBody btrfsitem.Inode) fuseops.InodeAttributes {
 	return fuseops.InodeAttributes{
 		Size:  uint64(itemBody.Size),
@@ -164,7 +239,7 @@ func (sv *subvolume) AcquireDir(inode btrfsprim.ObjID) (val *btrfs.Dir, err erro
 		haveSubvolumes := false
 		for _, index := range maps.SortedKeys(val.ChildrenByIndex) {
 			entry := val.ChildrenByIndex[index]
-			if entry.Location.ItemType == btrfsitem.ROOT_ITEM_KEY {
+			if entry.IsSubvolume() {
 				haveSubvolumes = true
 				break
 			}
@@ -177,7 +252,8 @@ func (sv *subvolume) AcquireDir(inode btrfsprim.ObjID) (val *btrfs.Dir, err erro
 			sv.subvolMu.Lock()
 			for _, index := range maps.SortedKeys(val.ChildrenByIndex) {
 				entry := val.ChildrenByIndex[index]
-				if entry.Location.ItemType != btrfsitem.ROOT_ITEM_KEY {
+				tree, ok := entry.TargetTree()
+				if !ok {
 					continue
 				}
 				if sv.subvols == nil {
@@ -190,7 +266,7 @@ func (sv *subvolume) AcquireDir(inode btrfsprim.ObjID) (val *btrfs.Dir, err erro
 					sv.grp.Go(workerName, func(ctx context.Context) error {
 						subSv := &subvolume{
 							sb:         sv.sb,
-							Subvolume:  sv.NewChildSubvolume(entry.Location.ObjectID),
+							Subvolume:  sv.NewChildSubvolume(tree),
 							DeviceName: sv.DeviceName,
 							Mountpoint: filepath.Join(sv.Mountpoint, subMountpoint[1:]),
 						}
@@ -226,54 +302,66 @@ func (sv *subvolume) StatFS(_ context.Context, op *fuseops.StatFSOp) error {
 }
 
 func (sv *subvolume) LookUpInode(_ context.Context, op *fuseops.LookUpInodeOp) error {
-	if op.Parent == fuseops.RootInodeID {
-		parent, err := sv.GetRootInode()
-		if err != nil {
-			return err
-		}
-		op.Parent = fuseops.InodeID(parent)
+	dirSV, parent, err := sv.resolveInode(op.Parent)
+	if err != nil {
+		return err
 	}
 
-	dir, err := sv.AcquireDir(btrfsprim.ObjID(op.Parent))
+	dir, err := sv.acquireDir(dirSV, parent)
 	if err != nil {
 		return err
 	}
-	defer sv.Subvolume.ReleaseDir(btrfsprim.ObjID(op.Parent))
+	defer dirSV.ReleaseDir(parent)
 	entry, ok := dir.ChildrenByName[op.Name]
 	if !ok {
 		return syscall.ENOENT
 	}
-	if entry.Location.ItemType != btrfsitem.INODE_ITEM_KEY {
+	childSV := dirSV
+	child, ok := entry.TargetInode()
+	if !ok {
 		// Subvolume
-		//
-		// Because each subvolume has its own pool of inodes
-		// (as in 2 different subvolumes can have files with
-		// te same inode number), so to represent that to FUSE
-		// we need to have this be a full separate mountpoint.
-		//
-		// I'd want to return EIO or EINTR or something here,
-		// but both the FUSE userspace tools and the kernel
-		// itself stat the mountpoint before mounting it, so
-		// we've got to return something bogus here to let
-		// that mount happen.
-		op.Entry = fuseops.ChildInodeEntry{
-			Child: 2, // an inode number that a real file will never have
-			Attributes: fuseops.InodeAttributes{
-				Nlink: 1,
-				Mode:  uint32(btrfsitem.ModeFmtDir | 0o700), //nolint:gomnd // TODO
-			},
+		if sv.inodes != nil {
+			// In unified mode, present the subvolume's
+			// root directory as a plain directory.
+			tree, ok := entry.TargetTree()
+			if !ok {
+				return syscall.EIO
+			}
+			childSV = sv.inodes.Subvolume(dirSV, tree)
+			child, err = childSV.GetRootInode()
+			if err != nil {
+				return err
+			}
+		} else {
+			// Because each subvolume has its own pool of inodes
+			// (as in 2 different subvolumes can have files with
+			// te same inode number), so to represent that to FUSE
+			// we need to have this be a full separate mountpoint.
+			//
+			// I'd want to return EIO or EINTR or something here,
+			// but both the FUSE userspace tools and the kernel
+			// itself stat the mountpoint before mounting it, so
+			// we've got to return something bogus here to let
+			// that mount happen.
+			op.Entry = fuseops.ChildInodeEntry{
+				Child: 2, // an inode number that a real file will never have
+				Attributes: fuseops.InodeAttributes{
+					Nlink: 1,
+					Mode:  uint32(btrfsitem.ModeFmtDir | 0o700), //nolint:gomnd // TODO
+				},
+			}
+			return nil
 		}
-		return nil
 	}
 
-	bareInode, err := sv.AcquireBareInode(entry.Location.ObjectID)
+	bareInode, err := childSV.AcquireBareInode(child)
 	if err != nil {
 		return err
 	}
-	defer sv.ReleaseBareInode(entry.Location.ObjectID)
+	defer childSV.ReleaseBareInode(child)
 
 	op.Entry = fuseops.ChildInodeEntry{
-		Child:      fuseops.InodeID(entry.Location.ObjectID),
+		Child:      sv.fuseInode(childSV, child),
 		Generation: fuseops.GenerationNumber(bareInode.InodeItem.Sequence),
 		Attributes: inodeItemToFUSE(*bareInode.InodeItem),
 	}
@@ -281,42 +369,39 @@ func (sv *subvolume) LookUpInode(_ context.Context, op *fuseops.LookUpInodeOp) e
 }
 
 func (sv *subvolume) GetInodeAttributes(_ context.Context, op *fuseops.GetInodeAttributesOp) error {
-	if op.Inode == fuseops.RootInodeID {
-		inode, err := sv.GetRootInode()
-		if err != nil {
-			return err
-		}
-		op.Inode = fuseops.InodeID(inode)
+	bsv, inode, err := sv.resolveInode(op.Inode)
+	if err != nil {
+		return err
 	}
 
-	bareInode, err := sv.AcquireBareInode(btrfsprim.ObjID(op.Inode))
+	bareInode, err := bsv.AcquireBareInode(inode)
 	if err != nil {
 		return err
 	}
-	defer sv.Subvolume.ReleaseBareInode(btrfsprim.ObjID(op.Inode))
+	defer bsv.ReleaseBareInode(inode)
 
 	op.Attributes = inodeItemToFUSE(*bareInode.InodeItem)
 	return nil
 }
 
 func (sv *subvolume) OpenDir(_ context.Context, op *fuseops.OpenDirOp) error {
-	if op.Inode == fuseops.RootInodeID {
-		inode, err := sv.GetRootInode()
-		if err != nil {
-			return err
-		}
-		op.Inode = fuseops.InodeID(inode)
+	bsv, inode, err := sv.resolveInode(op.Inode)
+	if err != nil {
+		return err
 	}
 
-	dir, err := sv.AcquireDir(btrfsprim.ObjID(op.Inode))
-	if err != nil {
+	if _, err := sv.acquireDir(bsv, inode); err != nil {
 		return err
 	}
-	defer sv.Subvolume.ReleaseDir(btrfsprim.ObjID(op.Inode))
+	defer bsv.ReleaseDir(inode)
+	// Like everywhere else in the mount, inconsistencies within
+	// the directory are glossed over rather than reported.
+	entries, _ := bsv.ReadDir(inode)
 
 	handle := sv.newHandle()
 	sv.dirHandles.Store(handle, &dirState{
-		Dir: dir,
+		SV:      bsv,
+		Entries: entries,
 	})
 	op.Handle = handle
 	return nil
@@ -328,14 +413,13 @@ func (sv *subvolume) ReadDir(_ context.Context, op *fuseops.ReadDirOp) error {
 		return syscall.EBADF
 	}
 	origOffset := op.Offset
-	for _, index := range maps.SortedKeys(state.Dir.ChildrenByIndex) {
-		if index < uint64(origOffset) {
+	for _, entry := range state.Entries {
+		if entry.Index < uint64(origOffset) {
 			continue
 		}
-		entry := state.Dir.ChildrenByIndex[index]
 		n := fuseutil.WriteDirent(op.Dst[op.BytesRead:], fuseutil.Dirent{
-			Offset: fuseops.DirOffset(index + 1),
-			Inode:  fuseops.InodeID(entry.Location.ObjectID),
+			Offset: fuseops.DirOffset(entry.Index + 1),
+			Inode:  sv.direntInode(state.SV, entry.DirEntry),
 			Name:   string(entry.Name),
 			Type: map[btrfsitem.FileType]fuseutil.DirentType{
 				btrfsitem.FT_UNKNOWN:  fuseutil.DT_Unknown,
@@ -365,11 +449,16 @@ func (sv *subvolume) ReleaseDirHandle(_ context.Context, op *fuseops.ReleaseDirH
 }
 
 func (sv *subvolume) OpenFile(_ context.Context, op *fuseops.OpenFileOp) error {
-	file, err := sv.AcquireFile(btrfsprim.ObjID(op.Inode))
+	bsv, inode, err := sv.resolveInode(op.Inode)
 	if err != nil {
 		return err
 	}
-	defer sv.Subvolume.ReleaseFile(btrfsprim.ObjID(op.Inode))
+
+	file, err := bsv.AcquireFile(inode)
+	if err != nil {
+		return err
+	}
+	defer bsv.ReleaseFile(inode)
 
 	handle := sv.newHandle()
 	sv.fileHandles.Store(handle, &fileState{
@@ -413,11 +502,16 @@ func (sv *subvolume) ReleaseFileHandle(_ context.Context, op *fuseops.ReleaseFil
 }
 
 func (sv *subvolume) ReadSymlink(_ context.Context, op *fuseops.ReadSymlinkOp) error {
-	file, err := sv.AcquireFile(btrfsprim.ObjID(op.Inode))
+	bsv, inode, err := sv.resolveInode(op.Inode)
+	if err != nil {
+		return err
+	}
+
+	file, err := bsv.AcquireFile(inode)
 	if err != nil {
 		return err
 	}
-	defer sv.Subvolume.ReleaseFile(btrfsprim.ObjID(op.Inode))
+	defer bsv.ReleaseFile(inode)
 
 	reader := io.NewSectionReader(file, 0, file.InodeItem.Size)
 	tgt, err := io.ReadAll(reader)
@@ -429,65 +523,71 @@ func (sv *subvolume) ReadSymlink(_ context.Context, op *fuseops.ReadSymlinkOp) e
 }
 
 func (sv *subvolume) ListXattr(_ context.Context, op *fuseops.ListXattrOp) error {
-	if op.Inode == fuseops.RootInodeID {
-		inode, err := sv.GetRootInode()
-		if err != nil {
-			return err
-		}
-		op.Inode = fuseops.InodeID(inode)
-	}
-
-	fullInode, err := sv.AcquireFullInode(btrfsprim.ObjID(op.Inode))
+	bsv, inode, err := sv.resolveInode(op.Inode)
 	if err != nil {
 		return err
 	}
-	defer sv.Subvolume.ReleaseFullInode(btrfsprim.ObjID(op.Inode))
 
-	size := 0
-	for name := range fullInode.XAttrs {
-		size += len(name) + 1
-	}
-	if len(op.Dst) < size {
-		return syscall.ERANGE
+	fullInode, err := bsv.AcquireFullInode(inode)
+	if err != nil {
+		return err
 	}
+	defer bsv.ReleaseFullInode(inode)
 
-	op.BytesRead = size
-	n := 0
-	for _, name := range maps.SortedKeys(fullInode.XAttrs) {
-		n += copy(op.Dst[n:], name)
-		op.Dst[n] = 0
-		n++
-	}
-	return nil
+	op.BytesRead, err = listXattr(fullInode.XAttrs, op.Dst)
+	return err
 }
 
 func (sv *subvolume) GetXattr(_ context.Context, op *fuseops.GetXattrOp) error {
-	if op.Inode == fuseops.RootInodeID {
-		inode, err := sv.GetRootInode()
-		if err != nil {
-			return err
-		}
-		op.Inode = fuseops.InodeID(inode)
+	bsv, inode, err := sv.resolveInode(op.Inode)
+	if err != nil {
+		return err
 	}
 
-	fullInode, err := sv.AcquireFullInode(btrfsprim.ObjID(op.Inode))
+	fullInode, err := bsv.AcquireFullInode(inode)
 	if err != nil {
 		return err
 	}
-	defer sv.Subvolume.ReleaseFullInode(btrfsprim.ObjID(op.Inode))
+	defer bsv.ReleaseFullInode(inode)
 
-	val, ok := fullInode.XAttrs[op.Name]
-	if !ok {
-		return syscall.ENODATA
+	op.BytesRead, err = getXattr(fullInode.XAttrs, op.Name, op.Dst)
+	return err
+}
+
+// listXattr implements listxattr(2) for an inode with the extended
+// attributes `xattrs`; see fillXattrBuf.
+func listXattr(xattrs map[string]string, dst []byte) (int, error) {
+	var list []byte
+	for _, name := range maps.SortedKeys(xattrs) {
+		list = append(list, name...)
+		list = append(list, 0)
 	}
+	return fillXattrBuf(dst, list)
+}
 
-	if len(op.Dst) < len(val) {
-		return syscall.ERANGE
+// getXattr implements getxattr(2) for an inode with the extended
+// attributes `xattrs`; see fillXattrBuf.
+func getXattr(xattrs map[string]string, name string, dst []byte) (int, error) {
+	val, ok := xattrs[name]
+	if !ok {
+		return 0, fuse.ENOATTR
 	}
+	return fillXattrBuf(dst, []byte(val))
+}
 
-	op.BytesRead = len(val)
-	copy(op.Dst, val)
-	return nil
+// fillXattrBuf copies `val` in to `dst`, following the buffer-size
+// protocol of getxattr(2) and listxattr(2): an empty `dst` is a query
+// for the required size, and a `dst` that is too small is ERANGE.
+// Either way, the returned size is the full size of `val`.
+func fillXattrBuf(dst, val []byte) (int, error) {
+	switch {
+	case len(dst) == 0:
+		return len(val), nil
+	case len(dst) < len(val):
+		return len(val), syscall.ERANGE
+	default:
+		return copy(dst, val), nil
+	}
 }
 
 func (*subvolume) Destroy() {}
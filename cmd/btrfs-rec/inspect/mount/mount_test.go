@@ -0,0 +1,219 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package mount
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+func TestXattr(t *testing.T) {
+	t.Parallel()
+	xattrs := map[string]string{
+		"user.foo":         "bar",
+		"security.selinux": "system_u:object_r:unlabeled_t:s0",
+	}
+	const list = "security.selinux\x00user.foo\x00"
+
+	type TestCase struct {
+		Fn      func(dst []byte) (int, error)
+		DstSize int
+		ExpN    int
+		ExpDst  string
+		ExpErr  error
+	}
+	get := func(name string) func([]byte) (int, error) {
+		return func(dst []byte) (int, error) { return getXattr(xattrs, name, dst) }
+	}
+	ls := func(dst []byte) (int, error) { return listXattr(xattrs, dst) }
+	testcases := map[string]TestCase{
+		"get":            {Fn: get("user.foo"), DstSize: 16, ExpN: 3, ExpDst: "bar"},
+		"get-exact":      {Fn: get("user.foo"), DstSize: 3, ExpN: 3, ExpDst: "bar"},
+		"get-probe":      {Fn: get("user.foo"), DstSize: 0, ExpN: 3},
+		"get-short":      {Fn: get("user.foo"), DstSize: 2, ExpN: 3, ExpErr: syscall.ERANGE},
+		"get-missing":    {Fn: get("user.bar"), DstSize: 16, ExpErr: fuse.ENOATTR},
+		"get-missing-0":  {Fn: get("user.bar"), DstSize: 0, ExpErr: fuse.ENOATTR},
+		"list":           {Fn: ls, DstSize: 64, ExpN: len(list), ExpDst: list},
+		"list-probe":     {Fn: ls, DstSize: 0, ExpN: len(list)},
+		"list-short":     {Fn: ls, DstSize: 10, ExpN: len(list), ExpErr: syscall.ERANGE},
+		"list-exact":     {Fn: ls, DstSize: len(list), ExpN: len(list), ExpDst: list},
+		"list-empty":     {Fn: func(dst []byte) (int, error) { return listXattr(nil, dst) }, DstSize: 64},
+		"list-empty-0":   {Fn: func(dst []byte) (int, error) { return listXattr(nil, dst) }, DstSize: 0},
+		"get-empty-attr": {Fn: func(dst []byte) (int, error) { return getXattr(map[string]string{"user.e": ""}, "user.e", dst) }, DstSize: 0},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			var dst []byte
+			if tc.DstSize > 0 {
+				dst = make([]byte, tc.DstSize)
+			}
+			n, err := tc.Fn(dst)
+			assert.Equal(t, tc.ExpErr, err)
+			assert.Equal(t, tc.ExpN, n)
+			if tc.ExpDst != "" {
+				assert.Equal(t, tc.ExpDst, string(dst[:n]))
+			}
+		})
+	}
+}
+
+const (
+	rootDir = btrfsprim.FIRST_FREE_OBJECTID + iota
+	helloFile
+	subvolID = btrfsprim.FIRST_FREE_OBJECTID + 100
+)
+
+// makeUnifiedFS returns a filesystem with a child subvolume "sub" in
+// the root of FS_TREE; both subvolumes have a file "hello" with the
+// same inode number (but different sizes).
+func makeUnifiedFS() btrfstest.ItemsFS {
+	subvol := func(helloSize int64, children ...btrfsitem.DirEntry) []btrfstree.Item {
+		items := []btrfstree.Item{
+			{
+				Key:  btrfsprim.Key{ObjectID: rootDir, ItemType: btrfsitem.INODE_ITEM_KEY},
+				Body: &btrfsitem.Inode{Mode: btrfsitem.ModeFmtDir | 0o755, NLink: 1},
+			},
+			{
+				Key:  btrfsprim.Key{ObjectID: helloFile, ItemType: btrfsitem.INODE_ITEM_KEY},
+				Body: &btrfsitem.Inode{Mode: btrfsitem.ModeFmtRegular | 0o644, NLink: 1, Size: helloSize},
+			},
+		}
+		for i := range children {
+			entry := &children[i]
+			items = append(items,
+				btrfstree.Item{
+					Key:  btrfsprim.Key{ObjectID: rootDir, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: btrfsitem.NameHash(entry.Name)},
+					Body: entry,
+				},
+				btrfstree.Item{
+					Key:  btrfsprim.Key{ObjectID: rootDir, ItemType: btrfsitem.DIR_INDEX_KEY, Offset: uint64(i) + 2},
+					Body: entry,
+				})
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Key.Compare(items[j].Key) < 0
+		})
+		return items
+	}
+	hello := btrfsitem.DirEntry{
+		Location: btrfsprim.Key{ObjectID: helloFile, ItemType: btrfsitem.INODE_ITEM_KEY},
+		Type:     btrfsitem.FT_REG_FILE,
+		Name:     []byte("hello"),
+	}
+	sub := btrfsitem.DirEntry{
+		Location: btrfsprim.Key{ObjectID: subvolID, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: math.MaxUint64},
+		Type:     btrfsitem.FT_DIR,
+		Name:     []byte("sub"),
+	}
+	rootItem := func(treeID btrfsprim.ObjID) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: treeID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+			Body: &btrfsitem.Root{RootDirID: rootDir},
+		}
+	}
+	return btrfstest.ItemsFS{
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.ROOT_TREE_OBJECTID: {
+				rootItem(btrfsprim.FS_TREE_OBJECTID),
+				rootItem(subvolID),
+			},
+			btrfsprim.FS_TREE_OBJECTID: subvol(1, hello, sub),
+			subvolID:                   subvol(2, hello),
+		},
+	}
+}
+
+// readDirents parses the output of fuseutil.WriteDirent, returning a
+// map of name to inode number.
+func readDirents(t *testing.T, dat []byte) map[string]fuseops.InodeID {
+	t.Helper()
+	const direntSize = 8 + 8 + 4 + 4
+	ret := make(map[string]fuseops.InodeID)
+	for len(dat) > 0 {
+		require.GreaterOrEqual(t, len(dat), direntSize)
+		ino := binary.NativeEndian.Uint64(dat[0:])
+		namelen := int(binary.NativeEndian.Uint32(dat[16:]))
+		ret[string(dat[direntSize:direntSize+namelen])] = fuseops.InodeID(ino)
+		dat = dat[direntSize+(namelen+7)/8*8:]
+	}
+	return ret
+}
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	fs := makeUnifiedFS()
+	sv := &subvolume{
+		Subvolume: btrfs.NewSubvolume(ctx, fs, btrfsprim.FS_TREE_OBJECTID, true, false, 0),
+	}
+	sv.inodes = newInodeTable(sv.Subvolume)
+
+	lookup := func(parent fuseops.InodeID, name string) fuseops.ChildInodeEntry {
+		t.Helper()
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		require.NoError(t, sv.LookUpInode(ctx, op))
+		return op.Entry
+	}
+
+	// The child subvolume is its real root directory, not the
+	// bogus placeholder that a separate mount would get.
+	subEntry := lookup(fuseops.RootInodeID, "sub")
+	assert.NotEqual(t, fuseops.RootInodeID, subEntry.Child)
+	assert.Equal(t, uint32(btrfsitem.ModeFmtDir|0o755), subEntry.Attributes.Mode)
+
+	// Files with the same inode number in different subvolumes
+	// get different FUSE inode numbers.
+	rootHello := lookup(fuseops.RootInodeID, "hello")
+	subHello := lookup(subEntry.Child, "hello")
+	assert.NotEqual(t, rootHello.Child, subHello.Child)
+	assert.Equal(t, uint64(1), rootHello.Attributes.Size)
+	assert.Equal(t, uint64(2), subHello.Attributes.Size)
+
+	// The numbers are stable.
+	assert.Equal(t, subEntry, lookup(fuseops.RootInodeID, "sub"))
+	assert.Equal(t, subHello, lookup(subEntry.Child, "hello"))
+
+	// ...and resolve back to the right inodes.
+	attrOp := &fuseops.GetInodeAttributesOp{Inode: subHello.Child}
+	require.NoError(t, sv.GetInodeAttributes(ctx, attrOp))
+	assert.Equal(t, uint64(2), attrOp.Attributes.Size)
+	attrOp = &fuseops.GetInodeAttributesOp{Inode: fuseops.InodeID(1000)}
+	assert.Equal(t, syscall.ENOENT, sv.GetInodeAttributes(ctx, attrOp))
+
+	// ReadDir agrees with LookUpInode.
+	readdir := func(dir fuseops.InodeID) map[string]fuseops.InodeID {
+		t.Helper()
+		openOp := &fuseops.OpenDirOp{Inode: dir}
+		require.NoError(t, sv.OpenDir(ctx, openOp))
+		readOp := &fuseops.ReadDirOp{Handle: openOp.Handle, Dst: make([]byte, 4096)}
+		require.NoError(t, sv.ReadDir(ctx, readOp))
+		require.NoError(t, sv.ReleaseDirHandle(ctx, &fuseops.ReleaseDirHandleOp{Handle: openOp.Handle}))
+		return readDirents(t, readOp.Dst[:readOp.BytesRead])
+	}
+	assert.Equal(t, map[string]fuseops.InodeID{
+		"hello": rootHello.Child,
+		"sub":   subEntry.Child,
+	}, readdir(fuseops.RootInodeID))
+	assert.Equal(t, map[string]fuseops.InodeID{
+		"hello": subHello.Child,
+	}, readdir(subEntry.Child))
+}
@@ -28,6 +28,101 @@ func getNodeSize(fs *btrfs.FS) (btrfsvol.AddrDelta, error) {
 	return btrfsvol.AddrDelta(sb.NodeSize), nil
 }
 
+// addFoundChunk adds the mappings for each stripe of a CHUNK_ITEM
+// found by the scan.  The chunk is added all-or-nothing: if any of
+// its stripes conflict with the existing mappings (say, the item is
+// from a stale copy of the chunk tree), then the whole chunk is
+// skipped, rather than leaving behind a chunk with only some of its
+// stripes.
+func addFoundChunk(ctx context.Context, lv *btrfsvol.LogicalVolume[*btrfs.Device], chunk FoundChunk) {
+	mappings := chunk.Chunk.Mappings(chunk.Key)
+	for _, mapping := range mappings {
+		if !lv.CouldAddMapping(mapping) {
+			dlog.Errorf(ctx, "error: skipping chunk laddr=%v: stripe on device=%v paddr=%v conflicts with existing mappings",
+				mapping.LAddr, mapping.PAddr.Dev, mapping.PAddr.Addr)
+			return
+		}
+	}
+	for _, mapping := range mappings {
+		if err := lv.AddMapping(mapping); err != nil {
+			dlog.Errorf(ctx, "error: adding chunk: %v", err)
+		}
+	}
+}
+
+// getStripedChunks returns the stripes of each striped chunk that is
+// already mapped, by the chunk's logical address.
+func getStripedChunks(lv *btrfsvol.LogicalVolume[*btrfs.Device]) map[btrfsvol.LogicalAddr][]btrfsvol.Mapping {
+	ret := make(map[btrfsvol.LogicalAddr][]btrfsvol.Mapping)
+	lv.RangeMappings(func(mapping btrfsvol.Mapping) bool {
+		if mapping.Striping.OK {
+			ret[mapping.LAddr] = append(ret[mapping.LAddr], mapping)
+		}
+		return true
+	})
+	return ret
+}
+
+// addFoundDevExtent adds the mapping for a DEV_EXTENT found by the
+// scan.  A DEV_EXTENT doesn't say how its chunk is striped, so if the
+// chunk is already known (from `stripedChunks`, as returned by
+// getStripedChunks) to be striped, then the DEV_EXTENT is added as
+// the stripe of that chunk that it is at; and if it isn't at any of
+// the chunk's stripes, then it is skipped.  Otherwise, the chunk is
+// assumed to not be striped.
+func addFoundDevExtent(ctx context.Context, lv *btrfsvol.LogicalVolume[*btrfs.Device], stripedChunks map[btrfsvol.LogicalAddr][]btrfsvol.Mapping, ext FoundDevExtent) {
+	mapping := ext.DevExt.Mapping(ext.Key, containers.Optional[btrfsvol.Striping]{}, 0)
+	if stripes, ok := stripedChunks[ext.DevExt.ChunkOffset]; ok {
+		found := false
+		for _, stripe := range stripes {
+			if stripe.PAddr == mapping.PAddr {
+				mapping = ext.DevExt.Mapping(ext.Key, stripe.Striping, stripe.StripeIndex)
+				found = true
+				break
+			}
+		}
+		if !found {
+			dlog.Errorf(ctx, "error: skipping devext device=%v paddr=%v: it is not a stripe of the striped chunk laddr=%v",
+				mapping.PAddr.Dev, mapping.PAddr.Addr, mapping.LAddr)
+			return
+		}
+	}
+	if err := lv.AddMapping(mapping); err != nil {
+		dlog.Errorf(ctx, "error: adding devext: %v", err)
+	}
+}
+
+// addFoundNodes adds mappings for the nodes found by the scan of
+// device `devID`.  Nodes that are at the same laddr-paddr offset and
+// are physically contiguous are surely in the same chunk; so rather
+// than adding one mapping per node, they are coalesced in to stripes,
+// and each stripe is added as one mapping.
+func addFoundNodes(ctx context.Context, lv *btrfsvol.LogicalVolume[*btrfs.Device], devID btrfsvol.DeviceID, nodes map[btrfsvol.LogicalAddr][]btrfsvol.PhysicalAddr, nodeSize btrfsvol.AddrDelta) {
+	byOffset := make(map[btrfsvol.AddrDelta][]btrfsvol.PhysicalAddr)
+	for laddr, paddrs := range nodes {
+		for _, paddr := range paddrs {
+			off := btrfsvol.AddrDelta(laddr) - btrfsvol.AddrDelta(paddr)
+			byOffset[off] = append(byOffset[off], paddr)
+		}
+	}
+	// Sort them so that progress numbers are predictable.
+	for _, off := range maps.SortedKeys(byOffset) {
+		for _, stripe := range btrfsvol.CoalesceStripes(byOffset[off], nodeSize) {
+			if err := lv.AddMapping(btrfsvol.Mapping{
+				LAddr: btrfsvol.LogicalAddr(stripe.Addr).Add(off),
+				PAddr: btrfsvol.QualifiedPhysicalAddr{
+					Dev:  devID,
+					Addr: stripe.Addr,
+				},
+				Size:       stripe.Size,
+				SizeLocked: false,
+			}); err != nil {
+				dlog.Errorf(ctx, "error: adding node stripe: %v", err)
+			}
+		}
+	}
+}
+
 func RebuildMappings(ctx context.Context, fs *btrfs.FS, scanResults ScanDevicesResult) error {
 	nodeSize, err := getNodeSize(fs)
 	if err != nil {
@@ -62,23 +157,18 @@ func RebuildMappings(ctx context.Context, fs *btrfs.FS, scanResults ScanDevicesR
 	for _, devID := range devIDs {
 		devResults := scanResults[devID]
 		for _, chunk := range devResults.FoundChunks {
-			for _, mapping := range chunk.Chunk.Mappings(chunk.Key) {
-				if err := fs.LV.AddMapping(mapping); err != nil {
-					dlog.Errorf(ctx, "error: adding chunk: %v", err)
-				}
-			}
+			addFoundChunk(ctx, &fs.LV, chunk)
 		}
 	}
 	dlog.Info(_ctx, "... done processing chunks")
 
 	ctx = dlog.WithField(_ctx, "btrfs.inspect.rebuild-mappings.process.step", "2/6")
 	dlog.Infof(_ctx, "2/6: Processing %d device extents...", numDevExts)
+	stripedChunks := getStripedChunks(&fs.LV)
 	for _, devID := range devIDs {
 		devResults := scanResults[devID]
 		for _, ext := range devResults.FoundDevExtents {
-			if err := fs.LV.AddMapping(ext.DevExt.Mapping(ext.Key)); err != nil {
-				dlog.Errorf(ctx, "error: adding devext: %v", err)
-			}
+			addFoundDevExtent(ctx, &fs.LV, stripedChunks, ext)
 		}
 	}
 	dlog.Info(_ctx, "... done processing device extents")
@@ -90,23 +180,7 @@ func RebuildMappings(ctx context.Context, fs *btrfs.FS, scanResults ScanDevicesR
 	ctx = dlog.WithField(_ctx, "btrfs.inspect.rebuild-mappings.process.step", "3/6")
 	dlog.Infof(_ctx, "3/6: Processing %d nodes...", numNodes)
 	for _, devID := range devIDs {
-		devResults := scanResults[devID]
-		// Sort them so that progress numbers are predictable.
-		for _, laddr := range maps.SortedKeys(devResults.FoundNodes) {
-			for _, paddr := range devResults.FoundNodes[laddr] {
-				if err := fs.LV.AddMapping(btrfsvol.Mapping{
-					LAddr: laddr,
-					PAddr: btrfsvol.QualifiedPhysicalAddr{
-						Dev:  devID,
-						Addr: paddr,
-					},
-					Size:       nodeSize,
-					SizeLocked: false,
-				}); err != nil {
-					dlog.Errorf(ctx, "error: adding node ident: %v", err)
-				}
-			}
-		}
+		addFoundNodes(ctx, &fs.LV, devID, scanResults[devID].FoundNodes, nodeSize)
 	}
 	dlog.Info(_ctx, "... done processing nodes")
 
@@ -200,6 +274,16 @@ func RebuildMappings(ctx context.Context, fs *btrfs.FS, scanResults ScanDevicesR
 	}
 	dlog.Infof(ctx, "... %d of unmapped block groups (across %d groups)", textui.IEC(unmappedBlockGroups, "B"), len(bgs))
 
+	// Now that the mappings are rebuilt, read the block groups
+	// back out of the filesystem and make sure that they agree
+	// with what we came up with.
+	realBGs, err := btrfs.ReadBlockGroups(ctx, fs)
+	if err != nil {
+		dlog.Errorf(ctx, "error: reading block groups: %v", err)
+	}
+	flagMismatches := checkBlockGroupFlags(fs.LV.Mappings(), realBGs)
+	dlog.Infof(ctx, "... %d mappings with flags that disagree with the block group items (of %d block group items)", len(flagMismatches), len(realBGs))
+
 	dlog.Info(_ctx, "detailed report:")
 	for _, devID := range maps.SortedKeys(unmappedPhysicalRegions) {
 		for _, region := range unmappedPhysicalRegions[devID] {
@@ -216,6 +300,11 @@ func RebuildMappings(ctx context.Context, fs *btrfs.FS, scanResults ScanDevicesR
 		dlog.Infof(ctx, "... umapped block group:            beg=%v end=%v (size=%v) flags=%v",
 			bg.LAddr, bg.LAddr.Add(bg.Size), bg.Size, bg.Flags)
 	}
+	for _, mismatch := range flagMismatches {
+		dlog.Infof(ctx, "... mismatched flags:               beg=%v end=%v (size=%v) mapping.flags=%v blockgroup.flags=%v",
+			mismatch.Mapping.LAddr, mismatch.Mapping.LAddr.Add(mismatch.Mapping.Size), mismatch.Mapping.Size,
+			mismatch.Mapping.Flags.Val, mismatch.BG.Flags)
+	}
 
 	return nil
 }
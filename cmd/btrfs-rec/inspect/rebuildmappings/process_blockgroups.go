@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"sort"
 
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
@@ -55,3 +56,38 @@ func dedupedBlockGroups(scanResults ScanDevicesResult) (map[btrfsvol.LogicalAddr
 	}
 	return bgsMap, nil
 }
+
+type blockGroupFlagsMismatch struct {
+	BG      btrfs.BlockGroup
+	Mapping btrfsvol.Mapping
+}
+
+// checkBlockGroupFlags cross-checks the flags of the mappings against
+// the flags of the block groups that overlap them, returning one
+// entry per (chunk, block group) pair that disagrees.  Mappings that
+// don't have flags are not considered to disagree.  Both `mappings`
+// and `bgs` must be sorted by LAddr (which .Mappings() and
+// btrfs.ReadBlockGroups() both are).
+func checkBlockGroupFlags(mappings []btrfsvol.Mapping, bgs []btrfs.BlockGroup) []blockGroupFlagsMismatch {
+	var ret []blockGroupFlagsMismatch
+	for _, bg := range bgs {
+		bgEnd := bg.LAddr.Add(bg.Size)
+		beg := sort.Search(len(mappings), func(i int) bool {
+			return mappings[i].LAddr.Add(mappings[i].Size) > bg.LAddr
+		})
+		for i := beg; i < len(mappings) && mappings[i].LAddr < bgEnd; i++ {
+			mapping := mappings[i]
+			if i > beg && mapping.LAddr == mappings[i-1].LAddr {
+				// Another stripe of the same chunk.
+				continue
+			}
+			if mapping.Flags.OK && mapping.Flags.Val != bg.Flags {
+				ret = append(ret, blockGroupFlagsMismatch{
+					BG:      bg,
+					Mapping: mapping,
+				})
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package rebuildmappings
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func TestCheckBlockGroupFlags(t *testing.T) {
+	t.Parallel()
+	const (
+		data = btrfsvol.BLOCK_GROUP_DATA
+		meta = btrfsvol.BLOCK_GROUP_METADATA | btrfsvol.BLOCK_GROUP_DUP
+	)
+	mapping := func(laddr btrfsvol.LogicalAddr, dev btrfsvol.DeviceID, flags containers.Optional[btrfsvol.BlockGroupFlags]) btrfsvol.Mapping {
+		return btrfsvol.Mapping{
+			LAddr: laddr,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: dev, Addr: btrfsvol.PhysicalAddr(laddr)},
+			Size:  0x1000,
+			Flags: flags,
+		}
+	}
+	bg := func(laddr btrfsvol.LogicalAddr, flags btrfsvol.BlockGroupFlags) btrfs.BlockGroup {
+		return btrfs.BlockGroup{
+			LAddr:      laddr,
+			Size:       0x1000,
+			BlockGroup: btrfsitem.BlockGroup{Flags: flags},
+		}
+	}
+
+	mappings := []btrfsvol.Mapping{
+		mapping(0x1000, 1, containers.OptionalValue(data)),
+		mapping(0x2000, 1, containers.OptionalValue(meta)),
+		mapping(0x2000, 2, containers.OptionalValue(meta)),
+		mapping(0x3000, 1, containers.Optional[btrfsvol.BlockGroupFlags]{}),
+		mapping(0x4000, 1, containers.OptionalValue(data)),
+	}
+
+	type TestCase struct {
+		BGs []btrfs.BlockGroup
+		Exp []blockGroupFlagsMismatch
+	}
+	testcases := map[string]TestCase{
+		"agree": {
+			BGs: []btrfs.BlockGroup{bg(0x1000, data), bg(0x2000, meta), bg(0x3000, data), bg(0x4000, data)},
+			Exp: nil,
+		},
+		"mismatch": {
+			BGs: []btrfs.BlockGroup{bg(0x1000, data), bg(0x2000, data), bg(0x4000, meta)},
+			Exp: []blockGroupFlagsMismatch{
+				{BG: bg(0x2000, data), Mapping: mappings[1]},
+				{BG: bg(0x4000, meta), Mapping: mappings[4]},
+			},
+		},
+		"unmapped": {
+			BGs: []btrfs.BlockGroup{bg(0x8000, meta)},
+			Exp: nil,
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Exp, checkBlockGroupFlags(mappings, tc.BGs))
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package rebuildmappings
+
+import (
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func TestAddFoundChunk(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	var lv btrfsvol.LogicalVolume[*btrfs.Device]
+	for _, devID := range []btrfsvol.DeviceID{1, 2} {
+		var sb btrfstree.Superblock
+		sb.DevItem.DevID = devID
+		require.NoError(t, lv.AddPhysicalVolume(devID, &btrfs.Device{File: NewPhonyFile(16*1024*1024, sb)}))
+	}
+
+	// What's already known (say, from the superblock's
+	// sys_chunk_array): dev=2 paddr=0x400000 holds laddr=0x100000.
+	existing := btrfsvol.Mapping{
+		LAddr:      0x100000,
+		PAddr:      btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: 0x400000},
+		Size:       0x100000,
+		SizeLocked: true,
+		Flags:      containers.OptionalValue(btrfsvol.BLOCK_GROUP_SYSTEM),
+	}
+	require.NoError(t, lv.AddMapping(existing))
+
+	chunk := func(laddr btrfsvol.LogicalAddr, stripes ...btrfsvol.QualifiedPhysicalAddr) FoundChunk {
+		ret := FoundChunk{
+			Key: btrfsprim.Key{
+				ObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+				ItemType: btrfsitem.CHUNK_ITEM_KEY,
+				Offset:   uint64(laddr),
+			},
+			Chunk: btrfsitem.Chunk{
+				Head: btrfsitem.ChunkHeader{
+					Size: 0x100000,
+					Type: btrfsvol.BLOCK_GROUP_METADATA | btrfsvol.BLOCK_GROUP_RAID1,
+				},
+			},
+		}
+		for _, stripe := range stripes {
+			ret.Chunk.Stripes = append(ret.Chunk.Stripes, btrfsitem.ChunkStripe{
+				DeviceID: stripe.Dev,
+				Offset:   stripe.Addr,
+			})
+		}
+		return ret
+	}
+
+	// A good chunk: both stripes get added.
+	good := chunk(0x200000,
+		btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x200000},
+		btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: 0x200000})
+	addFoundChunk(ctx, &lv, good)
+
+	// A stale chunk: its second stripe claims the physical
+	// space already used by `existing`, so neither stripe gets
+	// added.
+	stale := chunk(0x800000,
+		btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x800000},
+		btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: 0x400000})
+	addFoundChunk(ctx, &lv, stale)
+
+	exp := append([]btrfsvol.Mapping{existing}, good.Chunk.Mappings(good.Key)...)
+	for i := range exp {
+		// .Mappings() doesn't report .SizeLocked.
+		exp[i].SizeLocked = false
+	}
+	assert.Equal(t, exp, lv.Mappings())
+}
+
+func TestAddFoundNodes(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	var lv btrfsvol.LogicalVolume[*btrfs.Device]
+	var sb btrfstree.Superblock
+	sb.DevItem.DevID = 1
+	require.NoError(t, lv.AddPhysicalVolume(1, &btrfs.Device{File: NewPhonyFile(16*1024*1024, sb)}))
+
+	const nodeSize = 0x4000
+	addFoundNodes(ctx, &lv, 1, map[btrfsvol.LogicalAddr][]btrfsvol.PhysicalAddr{
+		// Three contiguous nodes, DUP'ed: two stripes.
+		0x100000: {0x200000, 0x300000},
+		0x104000: {0x204000, 0x304000},
+		0x108000: {0x208000, 0x308000},
+		// Physically contiguous with the above, but at a
+		// different offset: a stripe of its own.
+		0x900000: {0x20c000},
+		// Same offset as the above, but after a gap: a
+		// stripe of its own.
+		0x110000: {0x210000},
+	}, nodeSize)
+
+	stripe := func(laddr btrfsvol.LogicalAddr, paddr btrfsvol.PhysicalAddr, size btrfsvol.AddrDelta) btrfsvol.Mapping {
+		return btrfsvol.Mapping{
+			LAddr: laddr,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: paddr},
+			Size:  size,
+		}
+	}
+	assert.Equal(t, []btrfsvol.Mapping{
+		stripe(0x100000, 0x200000, 3*nodeSize),
+		stripe(0x100000, 0x300000, 3*nodeSize),
+		stripe(0x110000, 0x210000, nodeSize),
+		stripe(0x900000, 0x20c000, nodeSize),
+	}, lv.Mappings())
+}
+
+func TestAddFoundDevExtent(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	var lv btrfsvol.LogicalVolume[*btrfs.Device]
+	for _, devID := range []btrfsvol.DeviceID{1, 2} {
+		var sb btrfstree.Superblock
+		sb.DevItem.DevID = devID
+		require.NoError(t, lv.AddPhysicalVolume(devID, &btrfs.Device{File: NewPhonyFile(16*1024*1024, sb)}))
+	}
+
+	// A RAID0 chunk, split across 2 devices.
+	chunk := FoundChunk{
+		Key: btrfsprim.Key{
+			ObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+			ItemType: btrfsitem.CHUNK_ITEM_KEY,
+			Offset:   0x100000,
+		},
+		Chunk: btrfsitem.Chunk{
+			Head: btrfsitem.ChunkHeader{
+				Size:       0x200000,
+				Type:       btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID0,
+				StripeLen:  0x10000,
+				NumStripes: 2,
+				SubStripes: 1,
+			},
+			Stripes: []btrfsitem.ChunkStripe{
+				{DeviceID: 1, Offset: 0x200000},
+				{DeviceID: 2, Offset: 0x300000},
+			},
+		},
+	}
+	addFoundChunk(ctx, &lv, chunk)
+	exp := chunk.Chunk.Mappings(chunk.Key)
+	for i := range exp {
+		// .Mappings() doesn't report .SizeLocked.
+		exp[i].SizeLocked = false
+	}
+	require.Equal(t, exp, lv.Mappings())
+
+	devExt := func(dev btrfsvol.DeviceID, paddr btrfsvol.PhysicalAddr, laddr btrfsvol.LogicalAddr, size btrfsvol.AddrDelta) FoundDevExtent {
+		return FoundDevExtent{
+			Key: btrfsprim.Key{
+				ObjectID: btrfsprim.ObjID(dev),
+				ItemType: btrfsitem.DEV_EXTENT_KEY,
+				Offset:   uint64(paddr),
+			},
+			DevExt: btrfsitem.DevExtent{
+				ChunkOffset: laddr,
+				Length:      size,
+			},
+		}
+	}
+	stripedChunks := getStripedChunks(&lv)
+	// The DEV_EXTENTs for the chunk's stripes are each the size
+	// of one stripe; they must be recognized as the stripes
+	// rather than added as unstriped mappings (or panic).
+	addFoundDevExtent(ctx, &lv, stripedChunks, devExt(1, 0x200000, 0x100000, 0x100000))
+	addFoundDevExtent(ctx, &lv, stripedChunks, devExt(2, 0x300000, 0x100000, 0x100000))
+	// A DEV_EXTENT that claims to be part of the chunk, but isn't
+	// at any of its stripes, is skipped.
+	addFoundDevExtent(ctx, &lv, stripedChunks, devExt(1, 0x800000, 0x100000, 0x100000))
+	// A DEV_EXTENT for some other chunk is added as-is.
+	addFoundDevExtent(ctx, &lv, stripedChunks, devExt(1, 0x900000, 0x900000, 0x100000))
+
+	exp = append(exp, btrfsvol.Mapping{
+		LAddr: 0x900000,
+		PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x900000},
+		Size:  0x100000,
+	})
+	assert.Equal(t, exp, lv.Mappings())
+}
@@ -67,14 +67,21 @@ func (a FoundExtentCSum) Compare(b FoundExtentCSum) int {
 
 // Convenience functions for those types ///////////////////////////////////////
 
-func ScanDevices(ctx context.Context, fs *btrfs.FS) (ScanDevicesResult, error) {
-	return btrfsutil.ScanDevices[scanStats, ScanOneDeviceResult](ctx, fs, newDeviceScanner)
+// ScanDevices scans every device in the filesystem.  If nodeFilter is
+// non-nil, then only nodes that it accepts are parsed for items; for
+// example, btrfsutil.NodeOwnerFilter(btrfsprim.CHUNK_TREE_OBJECTID)
+// makes for a much faster scan that only finds chunks.
+//
+// Up to numWorkers devices are scanned in parallel; if numWorkers is
+// less than 1, then every device is scanned in parallel.
+func ScanDevices(ctx context.Context, fs *btrfs.FS, numWorkers int, nodeFilter func(btrfstree.NodeHeader) bool) (ScanDevicesResult, error) {
+	return btrfsutil.ScanDevices[scanStats, ScanOneDeviceResult](ctx, fs, numWorkers, nodeFilter, newDeviceScanner)
 }
 
 // ScanOneDevice mostly mimics btrfs-progs
 // cmds/rescue-chunk-recover.c:scan_one_device().
-func ScanOneDevice(ctx context.Context, dev *btrfs.Device) (ScanOneDeviceResult, error) {
-	return btrfsutil.ScanOneDevice[scanStats, ScanOneDeviceResult](ctx, dev, newDeviceScanner)
+func ScanOneDevice(ctx context.Context, dev *btrfs.Device, nodeFilter func(btrfstree.NodeHeader) bool) (ScanOneDeviceResult, error) {
+	return btrfsutil.ScanOneDevice[scanStats, ScanOneDeviceResult](ctx, dev, nodeFilter, newDeviceScanner)
 }
 
 // scanner implementation //////////////////////////////////////////////////////
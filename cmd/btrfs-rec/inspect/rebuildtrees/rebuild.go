@@ -27,6 +27,7 @@ import (
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
 	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
 )
 
@@ -65,9 +66,9 @@ type rebuilder struct {
 }
 
 type treeAugmentQueue struct {
-	zero   map[want]struct{}
-	single map[want]btrfsvol.LogicalAddr
-	multi  map[want]containers.Set[btrfsvol.LogicalAddr]
+	zero   map[btrfstree.Search]struct{}
+	single map[btrfstree.Search]btrfsvol.LogicalAddr
+	multi  map[btrfstree.Search]containers.Set[btrfsvol.LogicalAddr]
 }
 
 type Rebuilder interface {
@@ -75,9 +76,9 @@ type Rebuilder interface {
 	ListRoots(context.Context) map[btrfsprim.ObjID]containers.Set[btrfsvol.LogicalAddr]
 }
 
-func NewRebuilder(ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.LogicalAddr) (Rebuilder, error) {
+func NewRebuilder(ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.LogicalAddr, scanWorkers int) (Rebuilder, error) {
 	ctx = dlog.WithField(ctx, "btrfs.inspect.rebuild-trees.step", "read-fs-data")
-	scanData, err := ScanDevices(ctx, fs, nodeList) // ScanDevices does its own logging
+	scanData, err := ScanDevices(ctx, fs, nodeList, scanWorkers) // ScanDevices does its own logging
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +209,7 @@ func (o *rebuilder) processAddedItemQueue(ctx context.Context) error {
 		if ok && tree.RebuiltShouldReplace(incPtr.Node, excPtr.Node) {
 			wantKey := wantWithTree{
 				TreeID: key.TreeID,
-				Key:    wantFromKey(key.Key),
+				Key:    btrfstree.SearchExactKey(key.Key),
 			}
 			o.wantAugment(ctx, wantKey, tree.RebuiltLeafToRoots(ctx, excPtr.Node))
 			progress.NumAugments = o.numAugments
@@ -407,40 +408,48 @@ func (o *rebuilder) processSettledItemQueue(ctx context.Context) error {
 	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
 	grp.Go("io", func(ctx context.Context) error {
 		defer close(itemChan)
-	nextKey:
-		for _, key := range queue {
+		// Read the queue in batches, so that the items in a
+		// batch that share a node cost only one read of that
+		// node.
+		batchSize := textui.Tunable(300)
+		for len(queue) > 0 {
 			if err := ctx.Err(); err != nil {
 				return err
 			}
-			ctx := dlog.WithField(ctx, "btrfs.inspect.rebuild-trees.rebuild.process.item", key)
-			item := keyAndBody{
-				itemToVisit: key,
-				Body:        discardErr(discardErr(o.rebuilt.RebuiltTree(ctx, key.TreeID)).TreeLookup(ctx, key.Key)).Body,
-			}
-			if key.TreeID == btrfsprim.EXTENT_TREE_OBJECTID &&
-				(key.ItemType == btrfsprim.EXTENT_ITEM_KEY || key.ItemType == btrfsprim.METADATA_ITEM_KEY) {
-				switch itemBody := item.Body.(type) {
-				case *btrfsitem.Extent:
-					item.Body = itemBody.Refs[key.RefNum].Body
-					if item.Body == nil {
-						continue nextKey
-					}
-				case *btrfsitem.Metadata:
-					item.Body = itemBody.Refs[key.RefNum].Body
-					if item.Body == nil {
-						continue nextKey
+			batch := queue[:slices.Min(batchSize, len(queue))]
+			queue = queue[len(batch):]
+			bodies := o.readSettledItems(ctx, batch)
+		nextKey:
+			for i, key := range batch {
+				item := keyAndBody{
+					itemToVisit: key,
+					Body:        bodies[i],
+				}
+				if key.TreeID == btrfsprim.EXTENT_TREE_OBJECTID &&
+					(key.ItemType == btrfsprim.EXTENT_ITEM_KEY || key.ItemType == btrfsprim.METADATA_ITEM_KEY) {
+					switch itemBody := item.Body.(type) {
+					case *btrfsitem.Extent:
+						item.Body = itemBody.Refs[key.RefNum].Body
+						if item.Body == nil {
+							continue nextKey
+						}
+					case *btrfsitem.Metadata:
+						item.Body = itemBody.Refs[key.RefNum].Body
+						if item.Body == nil {
+							continue nextKey
+						}
+					case *btrfsitem.Error:
+						// do nothing
+					default:
+						// This is a panic because the item decoder should not emit a new
+						// type to ref.Body without this code also being updated.
+						panic(fmt.Errorf("should not happen: unexpected type %T for %v", itemBody, key.ItemType))
 					}
-				case *btrfsitem.Error:
-					// do nothing
-				default:
-					// This is a panic because the item decoder should not emit a new
-					// type to ref.Body without this code also being updated.
-					panic(fmt.Errorf("should not happen: unexpected type %T for %v", itemBody, key.ItemType))
 				}
-			}
-			select {
-			case itemChan <- item:
-			case <-ctx.Done():
+				select {
+				case itemChan <- item:
+				case <-ctx.Done():
+				}
 			}
 		}
 		return nil
@@ -470,6 +479,39 @@ func (o *rebuilder) processSettledItemQueue(ctx context.Context) error {
 	return grp.Wait()
 }
 
+// readSettledItems returns the body of each item in `batch`, in
+// order, using RebuiltReadItems so that each node is read only once
+// per batch.
+func (o *rebuilder) readSettledItems(ctx context.Context, batch []itemToVisit) []btrfsitem.Item {
+	ptrs := make([]btrfsutil.ItemPtr, 0, len(batch))
+	var missing []int
+	for i, key := range batch {
+		tree := discardErr(o.rebuilt.RebuiltTree(ctx, key.TreeID))
+		ptr, ok := tree.RebuiltAcquireItems(ctx).Load(key.Key)
+		tree.RebuiltReleaseItems()
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		ptrs = append(ptrs, ptr)
+	}
+	items := o.rebuilt.RebuiltReadItems(ctx, ptrs)
+
+	ret := make([]btrfsitem.Item, len(batch))
+	for i := range ret {
+		if len(missing) > 0 && missing[0] == i {
+			missing = missing[1:]
+			// Let TreeLookup figure out what to say about it.
+			ctx := dlog.WithField(ctx, "btrfs.inspect.rebuild-trees.rebuild.process.item", batch[i])
+			ret[i] = discardErr(discardErr(o.rebuilt.RebuiltTree(ctx, batch[i].TreeID)).TreeLookup(ctx, batch[i].Key)).Body
+			continue
+		}
+		ret[i] = items[0].Body
+		items = items[1:]
+	}
+	return ret
+}
+
 // processAugmentQueue drains o.augmentQueue (and maybe o.retryItemQueue), filling o.addedItemQueue.
 func (o *rebuilder) processAugmentQueue(ctx context.Context) error {
 	ctx = dlog.WithField(ctx, "btrfs.inspect.rebuild-trees.rebuild.substep", "apply-augments")
@@ -684,7 +726,7 @@ func (o *rebuilder) resolveTreeAugments(ctx context.Context, treeID btrfsprim.Ob
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func (queue *treeAugmentQueue) has(wantKey want) bool {
+func (queue *treeAugmentQueue) has(wantKey btrfstree.Search) bool {
 	if queue == nil {
 		return false
 	}
@@ -693,8 +735,8 @@ func (queue *treeAugmentQueue) has(wantKey want) bool {
 		(queue.multi != nil && maps.HasKey(queue.multi, wantKey))
 }
 
-func (queue *treeAugmentQueue) store(wantKey want, choices containers.Set[btrfsvol.LogicalAddr]) {
-	if len(choices) == 0 && wantKey.OffsetType > offsetExact {
+func (queue *treeAugmentQueue) store(wantKey btrfstree.Search, choices containers.Set[btrfsvol.LogicalAddr]) {
+	if len(choices) == 0 && wantKey.OffsetMatching > btrfstree.OffsetExact {
 		// This wantKey is unlikely to come up again, so it's
 		// not worth the RAM of storing a negative result.
 		return
@@ -702,17 +744,17 @@ func (queue *treeAugmentQueue) store(wantKey want, choices containers.Set[btrfsv
 	switch len(choices) {
 	case 0:
 		if queue.zero == nil {
-			queue.zero = make(map[want]struct{})
+			queue.zero = make(map[btrfstree.Search]struct{})
 		}
 		queue.zero[wantKey] = struct{}{}
 	case 1:
 		if queue.single == nil {
-			queue.single = make(map[want]btrfsvol.LogicalAddr)
+			queue.single = make(map[btrfstree.Search]btrfsvol.LogicalAddr)
 		}
 		queue.single[wantKey] = choices.TakeOne()
 	default:
 		if queue.multi == nil {
-			queue.multi = make(map[want]containers.Set[btrfsvol.LogicalAddr])
+			queue.multi = make(map[btrfstree.Search]containers.Set[btrfsvol.LogicalAddr])
 		}
 		queue.multi[wantKey] = choices
 	}
@@ -40,11 +40,7 @@ func (o forrestCallbacks) AddedRoot(_ context.Context, tree btrfsprim.ObjID, _ b
 func (o forrestCallbacks) LookupRoot(ctx context.Context, tree btrfsprim.ObjID) (offset btrfsprim.Generation, _item btrfsitem.Root, err error) {
 	wantKey := wantWithTree{
 		TreeID: btrfsprim.ROOT_TREE_OBJECTID,
-		Key: want{
-			ObjectID:   tree,
-			ItemType:   btrfsitem.ROOT_ITEM_KEY,
-			OffsetType: offsetAny,
-		},
+		Key:    btrfstree.SearchRootItem(tree),
 	}
 	ctx = withWant(ctx, logFieldTreeWant, "tree Root", wantKey)
 	foundKey, ok := o._want(ctx, wantKey)
@@ -71,7 +67,7 @@ func (o forrestCallbacks) LookupRoot(ctx context.Context, tree btrfsprim.ObjID)
 func (o forrestCallbacks) LookupUUID(ctx context.Context, uuid btrfsprim.UUID) (id btrfsprim.ObjID, err error) {
 	wantKey := wantWithTree{
 		TreeID: btrfsprim.UUID_TREE_OBJECTID,
-		Key:    wantFromKey(btrfsitem.UUIDToKey(uuid)),
+		Key:    btrfstree.SearchExactKey(btrfsitem.UUIDToKey(uuid)),
 	}
 	ctx = withWant(ctx, logFieldTreeWant, "resolve parent UUID", wantKey)
 	if !o._wantOff(ctx, wantKey) {
@@ -80,7 +76,7 @@ func (o forrestCallbacks) LookupUUID(ctx context.Context, uuid btrfsprim.UUID) (
 		}
 		return 0, btrfstree.ErrNoItem
 	}
-	item, _ := discardErr(o.rebuilt.RebuiltTree(ctx, wantKey.TreeID)).TreeLookup(ctx, wantKey.Key.Key())
+	item, _ := discardErr(o.rebuilt.RebuiltTree(ctx, wantKey.TreeID)).TreeSearch(ctx, wantKey.Key)
 	defer item.Body.Free()
 	switch itemBody := item.Body.(type) {
 	case *btrfsitem.UUIDMap:
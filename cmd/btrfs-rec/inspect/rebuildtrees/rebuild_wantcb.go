@@ -14,6 +14,7 @@ import (
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
@@ -32,11 +33,7 @@ func (graphCallbacks) FSErr(ctx context.Context, e error) {
 func (o graphCallbacks) Want(ctx context.Context, reason string, treeID btrfsprim.ObjID, objID btrfsprim.ObjID, typ btrfsprim.ItemType) {
 	wantKey := wantWithTree{
 		TreeID: treeID,
-		Key: want{
-			ObjectID:   objID,
-			ItemType:   typ,
-			OffsetType: offsetAny,
-		},
+		Key:    btrfstree.SearchObjectIDType(objID, typ),
 	}
 	ctx = withWant(ctx, logFieldItemWant, reason, wantKey)
 	o._want(ctx, wantKey)
@@ -49,13 +46,10 @@ func (o *rebuilder) _want(ctx context.Context, wantKey wantWithTree) (key btrfsp
 		return btrfsprim.Key{}, false
 	}
 
-	tgt := wantKey.Key.Key()
-
 	// check if we already have it
 
 	key, _, ok = tree.RebuiltAcquireItems(ctx).Search(func(key btrfsprim.Key, _ btrfsutil.ItemPtr) int {
-		key.Offset = 0
-		return tgt.Compare(key)
+		return wantKey.Key.Search(key, 0)
 	})
 	tree.RebuiltReleaseItems()
 	if ok {
@@ -70,8 +64,7 @@ func (o *rebuilder) _want(ctx context.Context, wantKey wantWithTree) (key btrfsp
 	wants := make(containers.Set[btrfsvol.LogicalAddr])
 	tree.RebuiltAcquirePotentialItems(ctx).Subrange(
 		func(k btrfsprim.Key, _ btrfsutil.ItemPtr) int {
-			k.Offset = 0
-			return tgt.Compare(k)
+			return wantKey.Key.Search(k, 0)
 		},
 		func(_ btrfsprim.Key, v btrfsutil.ItemPtr) bool {
 			wants.InsertFrom(tree.RebuiltLeafToRoots(ctx, v.Node))
@@ -86,12 +79,11 @@ func (o *rebuilder) _want(ctx context.Context, wantKey wantWithTree) (key btrfsp
 func (o graphCallbacks) WantOff(ctx context.Context, reason string, treeID btrfsprim.ObjID, objID btrfsprim.ObjID, typ btrfsprim.ItemType, off uint64) {
 	wantKey := wantWithTree{
 		TreeID: treeID,
-		Key: want{
-			ObjectID:   objID,
-			ItemType:   typ,
-			OffsetType: offsetExact,
-			OffsetLow:  off,
-		},
+		Key: btrfstree.SearchExactKey(btrfsprim.Key{
+			ObjectID: objID,
+			ItemType: typ,
+			Offset:   off,
+		}),
 	}
 	ctx = withWant(ctx, logFieldItemWant, reason, wantKey)
 	o._wantOff(ctx, wantKey)
@@ -104,7 +96,7 @@ func (o *rebuilder) _wantOff(ctx context.Context, wantKey wantWithTree) (ok bool
 		return false
 	}
 
-	tgt := wantKey.Key.Key()
+	tgt := searchKey(wantKey.Key)
 
 	// check if we already have it
 
@@ -135,11 +127,12 @@ func (o *rebuilder) _wantOff(ctx context.Context, wantKey wantWithTree) (ok bool
 func (o graphCallbacks) WantDirIndex(ctx context.Context, reason string, treeID btrfsprim.ObjID, objID btrfsprim.ObjID, name []byte) {
 	wantKey := wantWithTree{
 		TreeID: treeID,
-		Key: want{
-			ObjectID:   objID,
-			ItemType:   btrfsitem.DIR_INDEX_KEY,
-			OffsetType: offsetName,
-			OffsetName: string(name),
+		Key: btrfstree.Search{
+			ObjectID:         objID,
+			ItemTypeMatching: btrfstree.ItemTypeExact,
+			ItemType:         btrfsitem.DIR_INDEX_KEY,
+			OffsetMatching:   btrfstree.OffsetName,
+			OffsetName:       string(name),
 		},
 	}
 	ctx = withWant(ctx, logFieldItemWant, reason, wantKey)
@@ -150,15 +143,12 @@ func (o graphCallbacks) WantDirIndex(ctx context.Context, reason string, treeID
 		return
 	}
 
-	tgt := wantKey.Key.Key()
-
 	// check if we already have it
 
 	found := false
 	tree.RebuiltAcquireItems(ctx).Subrange(
 		func(key btrfsprim.Key, _ btrfsutil.ItemPtr) int {
-			key.Offset = 0
-			return tgt.Compare(key)
+			return wantKey.Key.Search(key, 0)
 		},
 		func(_ btrfsprim.Key, ptr btrfsutil.ItemPtr) bool {
 			if itemName, ok := o.scan.Names[ptr]; ok && bytes.Equal(itemName, name) {
@@ -179,8 +169,7 @@ func (o graphCallbacks) WantDirIndex(ctx context.Context, reason string, treeID
 	wants := make(containers.Set[btrfsvol.LogicalAddr])
 	tree.RebuiltAcquirePotentialItems(ctx).Subrange(
 		func(key btrfsprim.Key, _ btrfsutil.ItemPtr) int {
-			key.Offset = 0
-			return tgt.Compare(key)
+			return wantKey.Key.Search(key, 0)
 		},
 		func(_ btrfsprim.Key, ptr btrfsutil.ItemPtr) bool {
 			if itemName, ok := o.scan.Names[ptr]; ok && bytes.Equal(itemName, name) {
@@ -199,26 +188,12 @@ func (o graphCallbacks) _walkRange(
 	beg, end uint64,
 	fn func(key btrfsprim.Key, ptr btrfsutil.ItemPtr, beg, end uint64),
 ) {
-	min := btrfsprim.Key{
-		ObjectID: objID,
-		ItemType: typ,
-		Offset:   0, // *NOT* `beg`
-	}
-	max := btrfsprim.Key{
-		ObjectID: objID,
-		ItemType: typ,
-		Offset:   end - 1,
-	}
+	search := btrfstree.SearchOffsetRange(objID, typ,
+		0, // *NOT* `beg`
+		end)
 	items.Subrange(
 		func(runKey btrfsprim.Key, _ btrfsutil.ItemPtr) int {
-			switch {
-			case min.Compare(runKey) < 0:
-				return 1
-			case max.Compare(runKey) > 0:
-				return -1
-			default:
-				return 0
-			}
+			return search.Search(runKey, 0)
 		},
 		func(runKey btrfsprim.Key, runPtr btrfsutil.ItemPtr) bool {
 			runSizeAndErr, ok := o.scan.Sizes[runPtr]
@@ -264,14 +239,10 @@ func (o graphCallbacks) _wantRange(
 ) {
 	wantKey := wantWithTree{
 		TreeID: treeID,
-		Key: want{
-			ObjectID:   objID,
-			ItemType:   typ,
-			OffsetType: offsetAny,
-		},
+		Key:    btrfstree.SearchObjectIDType(objID, typ),
 	}
 	ctx = withWant(ctx, logFieldItemWant, reason, wantKey)
-	wantKey.Key.OffsetType = offsetRange
+	wantKey.Key.OffsetMatching = btrfstree.OffsetRange
 
 	tree, err := o.rebuilt.RebuiltTree(ctx, treeID)
 	if err != nil {
@@ -377,12 +348,11 @@ func (o graphCallbacks) _wantRange(
 func (o graphCallbacks) WantCSum(ctx context.Context, reason string, inodeTree, inode btrfsprim.ObjID, beg, end btrfsvol.LogicalAddr) {
 	inodeWant := wantWithTree{
 		TreeID: inodeTree,
-		Key: want{
-			ObjectID:   inode,
-			ItemType:   btrfsitem.INODE_ITEM_KEY,
-			OffsetType: offsetExact,
-			OffsetLow:  0,
-		},
+		Key: btrfstree.SearchExactKey(btrfsprim.Key{
+			ObjectID: inode,
+			ItemType: btrfsitem.INODE_ITEM_KEY,
+			Offset:   0,
+		}),
 	}
 	inodeCtx := withWant(ctx, logFieldItemWant, reason, inodeWant)
 	if !o._wantOff(inodeCtx, inodeWant) {
@@ -390,7 +360,7 @@ func (o graphCallbacks) WantCSum(ctx context.Context, reason string, inodeTree,
 		return
 	}
 	tree := discardErr(o.rebuilt.RebuiltTree(inodeCtx, inodeTree))
-	inodePtr, ok := tree.RebuiltAcquireItems(inodeCtx).Load(inodeWant.Key.Key())
+	inodePtr, ok := tree.RebuiltAcquireItems(inodeCtx).Load(searchKey(inodeWant.Key))
 	tree.RebuiltReleaseItems()
 	if !ok {
 		panic(fmt.Errorf("should not happen: could not load key: %v", inodeWant))
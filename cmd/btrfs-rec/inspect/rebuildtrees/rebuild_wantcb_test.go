@@ -0,0 +1,75 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package rebuildtrees
+
+import (
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func TestWalkRange(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	// Inode 257 has file extents [0,4096), [4096,12288), and
+	// [16384,20480); inodes 256 and 258 have extents that must
+	// never be walked.
+	type run struct {
+		Key  btrfsprim.Key
+		Size uint64
+	}
+	runs := []run{
+		{btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 0}, 4096},
+		{btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 0}, 4096},
+		{btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 4096}, 8192},
+		{btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 16384}, 4096},
+		{btrfsprim.Key{ObjectID: 258, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 0}, 4096},
+	}
+	items := new(containers.SortedMap[btrfsprim.Key, btrfsutil.ItemPtr])
+	o := graphCallbacks{&rebuilder{
+		scan: ScanDevicesResult{
+			Sizes: make(map[btrfsutil.ItemPtr]SizeAndErr),
+		},
+	}}
+	for i, run := range runs {
+		ptr := btrfsutil.ItemPtr{Node: 0x1000, Slot: i}
+		items.Store(run.Key, ptr)
+		o.scan.Sizes[ptr] = SizeAndErr{Size: run.Size}
+	}
+
+	type TestCase struct {
+		Beg, End uint64
+		Exp      []uint64 // offsets of the runs walked
+	}
+	testcases := map[string]TestCase{
+		"all":          {Beg: 0, End: 20480, Exp: []uint64{0, 4096, 16384}},
+		"middle":       {Beg: 6000, End: 7000, Exp: []uint64{4096}},
+		"straddle":     {Beg: 4000, End: 5000, Exp: []uint64{0, 4096}},
+		"gap":          {Beg: 12288, End: 16384, Exp: nil},
+		"after-gap":    {Beg: 12288, End: 16385, Exp: []uint64{16384}},
+		"past-the-end": {Beg: 20480, End: 30000, Exp: nil},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			var act []uint64
+			o._walkRange(ctx, items, btrfsprim.FS_TREE_OBJECTID, 257, btrfsitem.EXTENT_DATA_KEY, tc.Beg, tc.End,
+				func(key btrfsprim.Key, _ btrfsutil.ItemPtr, beg, end uint64) {
+					assert.Equal(t, btrfsprim.ObjID(257), key.ObjectID)
+					assert.Equal(t, key.Offset, beg)
+					act = append(act, beg)
+				})
+			assert.Equal(t, tc.Exp, act)
+		})
+	}
+}
@@ -11,86 +11,22 @@ import (
 	"github.com/datawire/dlib/dlog"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
-	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 )
 
-type wantOffsetType int8
-
-const (
-	offsetAny = wantOffsetType(iota)
-	offsetExact
-	offsetRange
-	offsetName
-)
-
-type want struct {
-	// TODO(lukeshu): Delete the 'want' type in favor of
-	// btrfstree.Search.
-	ObjectID   btrfsprim.ObjID
-	ItemType   btrfsprim.ItemType
-	OffsetType wantOffsetType
-	OffsetLow  uint64
-	OffsetHigh uint64
-	OffsetName string
-}
-
-func (a want) Compare(b want) int {
-	if d := containers.NativeCompare(a.ObjectID, b.ObjectID); d != 0 {
-		return d
-	}
-	if d := containers.NativeCompare(a.ItemType, b.ItemType); d != 0 {
-		return d
-	}
-	if d := containers.NativeCompare(a.OffsetType, b.OffsetType); d != 0 {
-		return d
-	}
-	if d := containers.NativeCompare(a.OffsetLow, b.OffsetLow); d != 0 {
-		return d
-	}
-	if d := containers.NativeCompare(a.OffsetHigh, b.OffsetHigh); d != 0 {
-		return d
-	}
-	if d := containers.NativeCompare(a.OffsetName, b.OffsetName); d != 0 {
-		return d
-	}
-	return 0
-}
-
-func (o want) Key() btrfsprim.Key {
+// searchKey returns the key that an OffsetExact search is looking
+// for.
+func searchKey(s btrfstree.Search) btrfsprim.Key {
 	return btrfsprim.Key{
-		ObjectID: o.ObjectID,
-		ItemType: o.ItemType,
-		Offset:   o.OffsetLow,
-	}
-}
-
-func wantFromKey(k btrfsprim.Key) want {
-	return want{
-		ObjectID:   k.ObjectID,
-		ItemType:   k.ItemType,
-		OffsetType: offsetExact,
-		OffsetLow:  k.Offset,
-	}
-}
-
-func (o want) String() string {
-	switch o.OffsetType {
-	case offsetAny:
-		return fmt.Sprintf("{%v %v ?}", o.ObjectID, o.ItemType)
-	case offsetExact:
-		return fmt.Sprintf("{%v %v %v}", o.ObjectID, o.ItemType, o.OffsetLow)
-	case offsetRange:
-		return fmt.Sprintf("{%v %v %v-%v}", o.ObjectID, o.ItemType, o.OffsetLow, o.OffsetHigh)
-	case offsetName:
-		return fmt.Sprintf("{%v %v name=%q}", o.ObjectID, o.ItemType, o.OffsetName)
-	default:
-		panic(fmt.Errorf("should not happen: OffsetType=%#v", o.OffsetType))
+		ObjectID: s.ObjectID,
+		ItemType: s.ItemType,
+		Offset:   s.OffsetLow,
 	}
 }
 
 type wantWithTree struct {
 	TreeID btrfsprim.ObjID
-	Key    want
+	Key    btrfstree.Search
 }
 
 func (o wantWithTree) String() string {
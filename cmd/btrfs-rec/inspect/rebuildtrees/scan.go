@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
@@ -48,7 +49,11 @@ type ScanDevicesResult struct {
 	DataBackrefs map[btrfsutil.ItemPtr][]btrfsprim.ObjID // EXTENT_DATA_REF, EXTENT_ITEM, and METADATA_ITEM
 }
 
-func ScanDevices(_ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.LogicalAddr) (ScanDevicesResult, error) {
+// ScanDevices reads every node in nodeList, reading up to numWorkers
+// nodes ahead of the one being inserted in to the result (see
+// readNodes).  Regardless of numWorkers, nodes are inserted in to the
+// result in nodeList order, so that the result is deterministic.
+func ScanDevices(_ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.LogicalAddr, numWorkers int) (ScanDevicesResult, error) {
 	// read-superblock /////////////////////////////////////////////////////////////
 	ctx := dlog.WithField(_ctx, "btrfs.inspect.rebuild-trees.read.substep", "read-superblock")
 	dlog.Info(ctx, "Reading superblock...")
@@ -77,24 +82,15 @@ func ScanDevices(_ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.Logical
 		ctx,
 		dlog.LogLevelInfo,
 		textui.Tunable(1*time.Second))
+	progressWriter.EnableETA()
 	progressWriter.Set(stats)
-	for _, laddr := range nodeList {
-		if err := ctx.Err(); err != nil {
-			progressWriter.Done()
-			return ScanDevicesResult{}, err
-		}
-		node, err := fs.AcquireNode(ctx, laddr, btrfstree.NodeExpectations{
-			LAddr: containers.OptionalValue(laddr),
-		})
-		if err != nil {
-			fs.ReleaseNode(node)
-			progressWriter.Done()
-			return ScanDevicesResult{}, err
-		}
+	if err := readNodes(ctx, fs, nodeList, numWorkers, func(node *btrfstree.Node) {
 		ret.insertNode(node)
-		fs.ReleaseNode(node)
 		stats.N++
 		progressWriter.Set(stats)
+	}); err != nil {
+		progressWriter.Done()
+		return ScanDevicesResult{}, err
 	}
 	if stats.N != stats.D {
 		panic("should not happen")
@@ -111,6 +107,82 @@ func ScanDevices(_ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.Logical
 	return ret, nil
 }
 
+type readNodeResult struct {
+	node *btrfstree.Node
+	err  error
+}
+
+// readNodes reads each node in nodeList and calls handleNode on it.
+// Nodes are read with fs.AcquireNode, so that they go through the
+// node cache and get the same mirror fallback as any other read.  Up
+// to numWorkers nodes are read ahead of the one that handleNode is
+// working on, so that reading overlaps with handling; but handleNode
+// is only ever called from a single goroutine, in nodeList order.
+// The node is released after handleNode returns.
+func readNodes(ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.LogicalAddr, numWorkers int, handleNode func(*btrfstree.Node)) error {
+	// Every read in flight pins an entry in the node cache; if
+	// they pinned every entry, then the read that "cpu" is
+	// waiting on could never get one.
+	cacheSize := fs.NodeCacheSize
+	if cacheSize == 0 {
+		cacheSize = btrfs.DefaultNodeCacheSize
+	}
+	if numWorkers > cacheSize-1 {
+		numWorkers = cacheSize - 1
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	// Each entry in `pending` is a read that has been started; the
+	// capacity of `pending` (plus the one read that "cpu" is
+	// waiting on) bounds the number of reads in flight.
+	pending := make(chan chan readNodeResult, numWorkers-1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	grp.Go("io", func(ctx context.Context) error {
+		defer close(pending)
+		for _, laddr := range nodeList {
+			result := make(chan readNodeResult, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				// "cpu" reports why.
+				return nil
+			}
+			go func(laddr btrfsvol.LogicalAddr) {
+				node, err := fs.AcquireNode(ctx, laddr, btrfstree.NodeExpectations{
+					LAddr: containers.OptionalValue(laddr),
+				})
+				result <- readNodeResult{node: node, err: err}
+			}(laddr)
+		}
+		return nil
+	})
+	grp.Go("cpu", func(ctx context.Context) error {
+		var retErr error
+		for result := range pending {
+			res := <-result
+			switch {
+			case retErr != nil:
+				// Drain the remaining in-flight reads.
+				fs.ReleaseNode(res.node)
+			case res.err != nil:
+				retErr = res.err
+				cancel()
+			case ctx.Err() != nil:
+				fs.ReleaseNode(res.node)
+				retErr = ctx.Err()
+			default:
+				handleNode(res.node)
+				fs.ReleaseNode(res.node)
+			}
+		}
+		return retErr
+	})
+	return grp.Wait()
+}
+
 func (o *ScanDevicesResult) insertNode(node *btrfstree.Node) {
 	o.Graph.InsertNode(node)
 	for i, item := range node.BodyLeaf {
@@ -0,0 +1,110 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package rebuildtrees
+
+import (
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestReadNodes(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	const (
+		nodeSize  = btrfssum.BlockSize
+		chunkSize = 256 * 1024
+		laddr0    = btrfsvol.LogicalAddr(1024 * 1024)
+		numNodes  = 10
+	)
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Self:         btrfs.SuperblockAddrs[0],
+		NodeSize:     nodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	sb.DevItem.DevID = 1
+	copy(sb.Magic[:], "_BHRfS_M")
+	var err error
+	sb.Checksum, err = sb.CalculateChecksum()
+	require.NoError(t, err)
+	sbDat, err := binstruct.Marshal(sb)
+	require.NoError(t, err)
+	img := make([]byte, 2*1024*1024)
+	copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+
+	// A small node cache, so that more reads are in flight than
+	// it can hold.
+	fs := &btrfs.FS{NodeCacheSize: 4}
+	require.NoError(t, fs.AddDevice(ctx, &btrfs.Device{File: diskio.NewMemFile[btrfsvol.PhysicalAddr](t.Name(), img)}))
+	// DUP: two copies of the same logical range.
+	paddrs := []btrfsvol.PhysicalAddr{1024 * 1024, 1024*1024 + chunkSize}
+	for _, paddr := range paddrs {
+		require.NoError(t, fs.LV.AddMapping(btrfsvol.Mapping{
+			LAddr: laddr0,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: paddr},
+			Size:  chunkSize,
+		}))
+	}
+
+	var nodeList []btrfsvol.LogicalAddr
+	for i := 0; i < numNodes; i++ {
+		laddr := laddr0 + btrfsvol.LogicalAddr(i*nodeSize)
+		node := btrfstree.Node{
+			Size:         sb.NodeSize,
+			ChecksumType: sb.ChecksumType,
+			Head: btrfstree.NodeHeader{
+				MetadataUUID: sb.EffectiveMetadataUUID(),
+				Addr:         laddr,
+				Generation:   1,
+				Owner:        btrfsprim.FS_TREE_OBJECTID,
+			},
+			BodyLeaf: []btrfstree.Item{{
+				Key:  btrfsprim.Key{ObjectID: btrfsprim.ObjID(256 + i), ItemType: btrfsitem.INODE_ITEM_KEY},
+				Body: &btrfsitem.Inode{},
+			}},
+		}
+		node.Head.Checksum, err = node.CalculateChecksum()
+		require.NoError(t, err)
+		dat, err := binstruct.Marshal(node)
+		require.NoError(t, err)
+		for _, paddr := range paddrs {
+			copy(img[paddr.Add(laddr.Sub(laddr0)):], dat)
+		}
+		nodeList = append(nodeList, laddr)
+	}
+	// Corrupt the first copy of one of the nodes; it should be
+	// read from the other mirror.
+	img[paddrs[0]+4*nodeSize-1] ^= 0xff
+
+	var got []btrfsvol.LogicalAddr
+	require.NoError(t, readNodes(ctx, fs, nodeList, 16, func(node *btrfstree.Node) {
+		got = append(got, node.Head.Addr)
+	}))
+	assert.Equal(t, nodeList, got)
+	assert.Equal(t, numNodes, fs.NodeCacheStats().Misses)
+
+	// An unreadable node is reported as such, and stops the
+	// scan there.
+	got = nil
+	badList := append(nodeList[:2:2], 0x10000, nodeList[2])
+	err = readNodes(ctx, fs, badList, 16, func(node *btrfstree.Node) {
+		got = append(got, node.Head.Addr)
+	})
+	assert.ErrorIs(t, err, btrfsvol.ErrCouldNotMap)
+	assert.Equal(t, nodeList[:2], got)
+}
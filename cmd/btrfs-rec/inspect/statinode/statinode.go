@@ -0,0 +1,116 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package statinode is the guts of the `btrfs-rec inspect stat`
+// command, which prints everything that is known about a single
+// inode.
+package statinode
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// StatInode writes to `out` the INODE_ITEM, xattrs, and (depending on
+// the type of the inode) the directory entries or file extents of
+// inode `inode` in subvolume `treeID`.
+//
+// It prints as much as it can even if parts of the inode are missing
+// or malformed; the number of problems found is returned.  An error
+// is only returned if the inode could not be found at all.
+//
+// `cacheSize` is passed to btrfs.NewSubvolume.
+func StatInode(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, treeID, inode btrfsprim.ObjID, cacheSize int) (int, error) {
+	sv := btrfs.NewSubvolume(ctx, fs, treeID, false, false, cacheSize)
+
+	full, err := sv.AcquireFullInode(inode)
+	if err != nil {
+		return 0, fmt.Errorf("subvol %v: inode %v not found: %w",
+			treeID.Format(btrfsprim.ROOT_TREE_OBJECTID), inode, err)
+	}
+	item := full.InodeItem
+	errs := full.Errs
+	xattrs := full.XAttrs
+	sv.ReleaseFullInode(inode)
+
+	textui.Fprintf(out, "subvol: %v\n", treeID.Format(btrfsprim.ROOT_TREE_OBJECTID))
+	textui.Fprintf(out, "inode: %v\n", inode)
+	if item == nil {
+		textui.Fprintf(out, "INODE_ITEM: missing\n")
+	} else {
+		textui.Fprintf(out, "size: %v\tnbytes: %v\n", item.Size, item.NumBytes)
+		textui.Fprintf(out, "mode: %v\tnlink: %v\n", item.Mode, item.NLink)
+		textui.Fprintf(out, "uid: %v\tgid: %v\trdev: %v\n", item.UID, item.GID, item.RDev)
+		textui.Fprintf(out, "flags: %v\n", item.Flags)
+		textui.Fprintf(out, "generation: %v\ttransid: %v\tsequence: %v\n",
+			item.Generation, item.TransID, item.Sequence)
+		textui.Fprintf(out, "atime: %v\n", item.ATime)
+		textui.Fprintf(out, "mtime: %v\n", item.MTime)
+		textui.Fprintf(out, "ctime: %v\n", item.CTime)
+		textui.Fprintf(out, "otime: %v\n", item.OTime)
+	}
+
+	textui.Fprintf(out, "xattrs: %v\n", len(xattrs))
+	for _, name := range maps.SortedKeys(xattrs) {
+		textui.Fprintf(out, "\t%q = %q\n", name, xattrs[name])
+	}
+
+	switch {
+	case item == nil:
+		// Without the mode, we don't know whether to load it as
+		// a directory or as a file.
+	case item.Mode.IsDir():
+		dir, err := sv.AcquireDir(inode)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if path, err := dir.AbsPath(); err == nil {
+			textui.Fprintf(out, "path: %q\n", path)
+		}
+		textui.Fprintf(out, "children: %v\n", len(dir.ChildrenByName))
+		for _, name := range maps.SortedKeys(dir.ChildrenByName) {
+			entry := dir.ChildrenByName[name]
+			textui.Fprintf(out, "\t%q\t%v\t%v\n", name, entry.Type, entry.Location)
+		}
+		// dir.Errs is a superset of full.Errs.
+		errs = append(errs[:0:0], dir.Errs...)
+		sv.ReleaseDir(inode)
+	case item.Mode&btrfsitem.ModeFmt == btrfsitem.ModeFmtRegular || item.Mode&btrfsitem.ModeFmt == btrfsitem.ModeFmtSymlink:
+		file, err := sv.AcquireFile(inode)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		textui.Fprintf(out, "extents: %v\n", len(file.Extents))
+		for _, extent := range file.Extents {
+			size, _ := extent.Size()
+			textui.Fprintf(out, "\toffset %v\tsize %v\t%v", extent.OffsetWithinFile, size, extent.Type)
+			switch extent.Type {
+			case btrfsitem.FILE_EXTENT_INLINE:
+				textui.Fprintf(out, "\tcompression %v\n", extent.Compression)
+			default:
+				textui.Fprintf(out, "\tdisk %v+%v\toffset %v\tcompression %v\n",
+					extent.BodyExtent.DiskByteNr, extent.BodyExtent.DiskNumBytes,
+					extent.BodyExtent.Offset, extent.Compression)
+			}
+		}
+		// file.Errs is a superset of full.Errs.
+		errs = append(errs[:0:0], file.Errs...)
+		sv.ReleaseFile(inode)
+	}
+
+	textui.Fprintf(out, "errors: %v\n", len(errs))
+	for _, err := range errs {
+		textui.Fprintf(out, "\t%v\n", err)
+	}
+	return len(errs), nil
+}
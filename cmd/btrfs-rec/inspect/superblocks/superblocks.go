@@ -0,0 +1,76 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package superblocks is the guts of the `btrfs-rec inspect
+// superblocks` command, which prints each copy of a device's
+// superblock and calls out the copies that are damaged or that
+// disagree with each other; for deciding which superblock to trust.
+package superblocks
+
+import (
+	"fmt"
+	"io"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// PrintSuperblocks writes to `out` the key fields of each copy of the
+// superblock on `dev` (the primary and each mirror that fits on the
+// device), marking the copy that is in use (see
+// btrfs.Device.Superblock).  It then writes a line for each copy
+// that has a bad checksum or that disagrees with the copy in use (as
+// decided by btrfstree.Superblock.Equal).
+//
+// It returns the number of such problems found.
+func PrintSuperblocks(out io.Writer, dev *btrfs.Device) (int, error) {
+	sbs, err := dev.Superblocks()
+	if err != nil {
+		return 0, fmt.Errorf("device file %q: %w", dev.Name(), err)
+	}
+	// If there is a copy with a valid checksum, then
+	// dev.Superblock() returns a pointer to one of sbs.
+	ref := -1
+	if inUse, err := dev.Superblock(); err == nil {
+		for i, sb := range sbs {
+			if &sb.Data == inUse {
+				ref = i
+			}
+		}
+	}
+
+	textui.Fprintf(out, "device file %q: %v superblocks\n", dev.Name(), len(sbs))
+	for i, sb := range sbs {
+		csum := "csum=ok"
+		if err := sb.Data.ValidateChecksum(); err != nil {
+			csum = "csum=bad"
+		}
+		var note string
+		if i == ref {
+			note = "\t(in use)"
+		}
+		textui.Fprintf(out, "\tsuperblock %v at paddr=%v\t%v\tgen=%v\troot=%v\tchunk=%v\tlog=%v\tflags=%#x\tincompat_flags=%v\tcsum_type=%v\tnum_devices=%v%v\n",
+			i, sb.Addr, csum, sb.Data.Generation,
+			sb.Data.RootTree, sb.Data.ChunkTree, sb.Data.LogTree,
+			sb.Data.Flags, sb.Data.IncompatFlags, sb.Data.ChecksumType, sb.Data.NumDevices,
+			note)
+	}
+
+	problems := 0
+	for i, sb := range sbs {
+		if err := sb.Data.ValidateChecksum(); err != nil {
+			textui.Fprintf(out, "\tsuperblock %v: %v\n", i, err)
+			problems++
+			continue
+		}
+		if i != ref && !sb.Data.Equal(sbs[ref].Data) {
+			textui.Fprintf(out, "\tsuperblock %v: disagrees with superblock %v\n", i, ref)
+			problems++
+		}
+	}
+	if problems == 0 {
+		textui.Fprintf(out, "\tall %v superblocks are valid and agree\n", len(sbs))
+	}
+	return problems, nil
+}
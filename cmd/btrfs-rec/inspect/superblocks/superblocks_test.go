@@ -0,0 +1,116 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package superblocks_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/superblocks"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestPrintSuperblocks(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		// Applied to each mirror in turn (0 is the primary)
+		// before the checksum is calculated.
+		Mutate   func(i int, sb *btrfstree.Superblock)
+		BadCSum  int // index of a mirror to give a bad checksum; -1 for none
+		ExpOut   string
+		ExpProbs int
+	}
+	line := func(i int, paddr string, csum string, gen int, inUse bool) string {
+		ret := fmt.Sprintf("\tsuperblock %v at paddr=%v\t%v\tgen=%v", i, paddr, csum, gen) +
+			"\troot=0x0000000000100000\tchunk=0x0000000000200000\tlog=0x0000000000000000" +
+			"\tflags=0x1\tincompat_flags=0x0(none)\tcsum_type=crc32c\tnum_devices=1"
+		if inUse {
+			ret += "\t(in use)"
+		}
+		return ret + "\n"
+	}
+	testcases := map[string]TestCase{
+		"agree": {
+			Mutate:  func(int, *btrfstree.Superblock) {},
+			BadCSum: -1,
+			ExpOut: "" +
+				"device file \"mem\": 2 superblocks\n" +
+				line(0, "0x0000000000010000", "csum=ok", 5, true) +
+				line(1, "0x0000000004000000", "csum=ok", 5, false) +
+				"\tall 2 superblocks are valid and agree\n",
+		},
+		"mismatch": {
+			Mutate: func(i int, sb *btrfstree.Superblock) {
+				if i == 1 {
+					sb.Generation = 4
+				}
+			},
+			BadCSum: -1,
+			ExpOut: "" +
+				"device file \"mem\": 2 superblocks\n" +
+				line(0, "0x0000000000010000", "csum=ok", 5, true) +
+				line(1, "0x0000000004000000", "csum=ok", 4, false) +
+				"\tsuperblock 1: disagrees with superblock 0\n",
+			ExpProbs: 1,
+		},
+		"bad-primary": {
+			Mutate:  func(int, *btrfstree.Superblock) {},
+			BadCSum: 0,
+			ExpOut: "" +
+				"device file \"mem\": 2 superblocks\n" +
+				line(0, "0x0000000000010000", "csum=bad", 5, false) +
+				line(1, "0x0000000004000000", "csum=ok", 5, true) +
+				"\tsuperblock 0: superblock checksum mismatch: stored=00000000 calculated=8a0e4bc6\n",
+			ExpProbs: 1,
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			img := make([]byte, btrfs.SuperblockAddrs[1]+btrfs.SuperblockSize)
+			dev := &btrfs.Device{File: diskio.NewMemFile[btrfsvol.PhysicalAddr]("mem", img)}
+			sbs, err := dev.Superblocks()
+			require.NoError(t, err)
+			require.Len(t, sbs, 2)
+			for i, sb := range sbs {
+				sb.Data = btrfstree.Superblock{
+					FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+					Self:         sb.Addr,
+					Flags:        1,
+					Generation:   5,
+					RootTree:     0x100000,
+					ChunkTree:    0x200000,
+					NumDevices:   1,
+					SectorSize:   btrfssum.BlockSize,
+					NodeSize:     btrfssum.BlockSize,
+					ChecksumType: btrfssum.TYPE_CRC32,
+				}
+				copy(sb.Data.Magic[:], "_BHRfS_M")
+				tc.Mutate(i, &sb.Data)
+				if i != tc.BadCSum {
+					sb.Data.Checksum, err = sb.Data.CalculateChecksum()
+					require.NoError(t, err)
+				}
+				require.NoError(t, sb.Write())
+			}
+
+			var out bytes.Buffer
+			problems, err := superblocks.PrintSuperblocks(&out, dev)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpOut, out.String())
+			assert.Equal(t, tc.ExpProbs, problems)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package which is the guts of the `btrfs-rec inspect which`
+// command, which reports which file (if any) is stored at a given
+// address; for figuring out which file to restore when a disk
+// reports a bad sector.
+package which
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/datawire/dlib/derror"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// WhichPhysical writes to `out` the logical address that the
+// physical address `paddr` maps to, and then does the same as
+// WhichLogical.
+//
+// A physical address that isn't mapped to any logical address (such
+// as unallocated space) is reported to `out`, but is not an error.
+func WhichPhysical(ctx context.Context, out io.Writer, fs *btrfs.FS, paddr btrfsvol.QualifiedPhysicalAddr, cacheSize int) error {
+	if _, ok := fs.LV.PhysicalVolumes()[paddr.Dev]; !ok {
+		return fmt.Errorf("paddr=%v:%v: no such device", paddr.Dev, paddr.Addr)
+	}
+	laddr := fs.LV.UnResolve(paddr)
+	if laddr < 0 {
+		textui.Fprintf(out, "paddr=%v:%v: not mapped to any logical address\n", paddr.Dev, paddr.Addr)
+		return nil
+	}
+	textui.Fprintf(out, "paddr=%v:%v: laddr=%v\n", paddr.Dev, paddr.Addr, laddr)
+	return WhichLogical(ctx, out, fs, laddr, cacheSize)
+}
+
+// WhichLogical writes to `out` the files that reference the data
+// extent containing the logical address `laddr` (see
+// btrfsutil.LogicalToInodes), along with each file's path within its
+// subvolume.  `cacheSize` is passed to btrfs.NewSubvolume.
+func WhichLogical(ctx context.Context, out io.Writer, fs btrfs.ReadableFS, laddr btrfsvol.LogicalAddr, cacheSize int) error {
+	refs, err := btrfsutil.LogicalToInodes(ctx, fs, laddr)
+	var errs derror.MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	subvols := make(map[btrfsprim.ObjID]*btrfs.Subvolume)
+	for _, ref := range refs {
+		sv, ok := subvols[ref.Tree]
+		if !ok {
+			sv = btrfs.NewSubvolume(ctx, fs, ref.Tree, false, false, cacheSize)
+			subvols[ref.Tree] = sv
+		}
+		path, err := inodePath(sv, ref.Inode)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tree=%v inode=%v: path: %w", ref.Tree, ref.Inode, err))
+			path = "?"
+		}
+		textui.Fprintf(out, "laddr=%v: %v path=%q\n", laddr, ref, path)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// inodePath returns the path of `inode` within the subvolume `sv`;
+// if the inode has several hard links, only the first is returned.
+func inodePath(sv *btrfs.Subvolume, inode btrfsprim.ObjID) (string, error) {
+	paths, err := sv.InodePaths(inode)
+	if len(paths) == 0 {
+		return "", err
+	}
+	return paths[0], nil
+}
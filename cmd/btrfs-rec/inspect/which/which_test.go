@@ -0,0 +1,237 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package which_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/which"
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+const (
+	metadataLAddr btrfsvol.LogicalAddr  = 0x100000
+	metadataPAddr btrfsvol.PhysicalAddr = 0x100000
+	dataLAddr     btrfsvol.LogicalAddr  = 0x200000
+	dataPAddr     btrfsvol.PhysicalAddr = 0x300000
+	chunkSize     btrfsvol.AddrDelta    = 0x100000
+
+	rootTreeAddr   = metadataLAddr
+	extentTreeAddr = metadataLAddr + 0x1000
+	fsTreeAddr     = metadataLAddr + 0x2000
+
+	fileExtentAddr = dataLAddr + 0x10000
+	fileExtentSize = 0x4000
+)
+
+// makeTestFS returns a single-device filesystem containing a root
+// directory with a single file, "/dir/hello.txt" (inode 258), whose
+// one data extent is at fileExtentAddr.
+func makeTestFS(t *testing.T) *btrfs.FS {
+	t.Helper()
+	ctx := dlog.NewTestContext(t, false)
+
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Self:         btrfs.SuperblockAddrs[0],
+		Generation:   1,
+		RootTree:     rootTreeAddr,
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     btrfssum.BlockSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	sb.DevItem.DevID = 1
+	copy(sb.Magic[:], "_BHRfS_M")
+	var err error
+	sb.Checksum, err = sb.CalculateChecksum()
+	require.NoError(t, err)
+	sbDat, err := binstruct.Marshal(sb)
+	require.NoError(t, err)
+	img := make([]byte, 4*1024*1024)
+	file := diskio.NewMemFile[btrfsvol.PhysicalAddr]("mem", img)
+	copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+
+	fs := new(btrfs.FS)
+	require.NoError(t, fs.AddDevice(ctx, &btrfs.Device{File: file}))
+	for _, mapping := range []btrfsvol.Mapping{
+		{
+			LAddr: metadataLAddr,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: metadataPAddr},
+			Size:  chunkSize,
+			Flags: containers.OptionalValue(btrfsvol.BLOCK_GROUP_METADATA),
+		},
+		{
+			LAddr: dataLAddr,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: dataPAddr},
+			Size:  chunkSize,
+			Flags: containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA),
+		},
+	} {
+		require.NoError(t, fs.LV.AddMapping(mapping))
+	}
+
+	writeTree := func(addr btrfsvol.LogicalAddr, owner btrfsprim.ObjID, items []btrfstree.Item) {
+		nodes, err := btrfstree.BuildTree(sb, btrfstree.NodeHeader{
+			Flags:      btrfstree.NodeWritten,
+			BackrefRev: btrfstree.MixedBackrefRev,
+			Generation: 1,
+			Owner:      owner,
+		}, items, func() (btrfsvol.LogicalAddr, error) {
+			return addr, nil
+		})
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+		dat, err := nodes[0].MarshalBinary()
+		require.NoError(t, err)
+		copy(img[metadataPAddr.Add(addr.Sub(metadataLAddr)):], dat)
+	}
+
+	writeTree(rootTreeAddr, btrfsprim.ROOT_TREE_OBJECTID, []btrfstree.Item{
+		{
+			Key:  btrfsprim.Key{ObjectID: btrfsprim.EXTENT_TREE_OBJECTID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+			Body: &btrfsitem.Root{ByteNr: extentTreeAddr, Generation: 1},
+		},
+		{
+			Key:  btrfsprim.Key{ObjectID: btrfsprim.FS_TREE_OBJECTID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+			Body: &btrfsitem.Root{ByteNr: fsTreeAddr, Generation: 1, RootDirID: 256},
+		},
+	})
+
+	writeTree(extentTreeAddr, btrfsprim.EXTENT_TREE_OBJECTID, []btrfstree.Item{
+		{
+			Key: btrfsprim.Key{ObjectID: btrfsprim.ObjID(fileExtentAddr), ItemType: btrfsitem.EXTENT_ITEM_KEY, Offset: fileExtentSize},
+			Body: &btrfsitem.Extent{
+				Head: btrfsitem.ExtentHeader{Refs: 1, Generation: 1, Flags: btrfsitem.EXTENT_FLAG_DATA},
+				Refs: []btrfsitem.ExtentInlineRef{{
+					Type: btrfsitem.EXTENT_DATA_REF_KEY,
+					Body: &btrfsitem.ExtentDataRef{Root: btrfsprim.FS_TREE_OBJECTID, ObjectID: 258, Offset: 0, Count: 1},
+				}},
+			},
+		},
+	})
+
+	dirEntry := func(name string, inode btrfsprim.ObjID, typ btrfsitem.FileType) btrfsitem.DirEntry {
+		return btrfsitem.DirEntry{
+			Location: btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY},
+			Type:     typ,
+			Name:     []byte(name),
+		}
+	}
+	dirItems := func(dir btrfsprim.ObjID, index uint64, entry btrfsitem.DirEntry) []btrfstree.Item {
+		return []btrfstree.Item{
+			{
+				Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: btrfsitem.NameHash(entry.Name)},
+				Body: &entry,
+			},
+			{
+				Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_INDEX_KEY, Offset: index},
+				Body: &entry,
+			},
+		}
+	}
+	inodeRef := func(inode, parent btrfsprim.ObjID, name string) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_REF_KEY, Offset: uint64(parent)},
+			Body: &btrfsitem.InodeRefs{Refs: []btrfsitem.InodeRef{{
+				Index: 2,
+				Name:  []byte(name),
+			}}},
+		}
+	}
+	inodeItem := func(inode btrfsprim.ObjID, mode btrfsitem.StatMode, size int64) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY},
+			Body: &btrfsitem.Inode{Generation: 1, Size: size, NLink: 1, Mode: mode},
+		}
+	}
+	var fsTree []btrfstree.Item
+	fsTree = append(fsTree,
+		inodeItem(256, btrfsitem.ModeFmtDir|0o755, 0),
+		inodeRef(256, 256, ".."))
+	fsTree = append(fsTree, dirItems(256, 2, dirEntry("dir", 257, btrfsitem.FT_DIR))...)
+	fsTree = append(fsTree,
+		inodeItem(257, btrfsitem.ModeFmtDir|0o755, 0),
+		inodeRef(257, 256, "dir"))
+	fsTree = append(fsTree, dirItems(257, 2, dirEntry("hello.txt", 258, btrfsitem.FT_REG_FILE))...)
+	fsTree = append(fsTree,
+		inodeItem(258, btrfsitem.ModeFmtRegular|0o644, fileExtentSize),
+		inodeRef(258, 257, "hello.txt"),
+		btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: 258, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 0},
+			Body: &btrfsitem.FileExtent{
+				Generation: 1,
+				RAMBytes:   fileExtentSize,
+				Type:       btrfsitem.FILE_EXTENT_REG,
+				BodyExtent: btrfsitem.FileExtentExtent{
+					DiskByteNr:   fileExtentAddr,
+					DiskNumBytes: fileExtentSize,
+					NumBytes:     fileExtentSize,
+				},
+			},
+		})
+	writeTree(fsTreeAddr, btrfsprim.FS_TREE_OBJECTID, fsTree)
+
+	return fs
+}
+
+func TestWhichPhysical(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	fs := makeTestFS(t)
+
+	type TestCase struct {
+		PAddr  btrfsvol.QualifiedPhysicalAddr
+		ExpOut string
+		ExpErr string
+	}
+	testcases := map[string]TestCase{
+		"file": {
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: dataPAddr + 0x10000 + 0x1234},
+			ExpOut: "" +
+				"paddr=1:0x0000000000311234: laddr=0x0000000000211234\n" +
+				"laddr=0x0000000000211234: tree=FS_TREE inode=258 offset=0 path=\"/dir/hello.txt\"\n",
+		},
+		"unmapped": {
+			PAddr:  btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x10},
+			ExpOut: "paddr=1:0x0000000000000010: not mapped to any logical address\n",
+		},
+		"no-extent": {
+			PAddr:  btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: dataPAddr},
+			ExpOut: "paddr=1:0x0000000000300000: laddr=0x0000000000200000\n",
+			ExpErr: "laddr=0x0000000000200000: no extent item found",
+		},
+		"no-device": {
+			PAddr:  btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: dataPAddr},
+			ExpErr: "paddr=2:0x0000000000300000: no such device",
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			err := which.WhichPhysical(ctx, &out, fs, tc.PAddr, 0)
+			if tc.ExpErr != "" {
+				assert.EqualError(t, err, tc.ExpErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.ExpOut, out.String())
+		})
+	}
+}
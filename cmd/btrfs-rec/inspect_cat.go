@@ -0,0 +1,94 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/catfile"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+)
+
+func init() {
+	var flags struct {
+		tree       string
+		inode      string
+		path       string
+		ignoreCSum bool
+	}
+	cmd := &cobra.Command{
+		Use:   "cat {--inode=INODE|--path=PATH}",
+		Short: "Write the contents of a single file to stdout",
+		Long: "" +
+			"Write the contents of a single file to stdout, for " +
+			"recovering a file without having to mount the " +
+			"filesystem.  The file is identified either by its inode " +
+			"number (which works even if the directories leading to " +
+			"it are too damaged to mount) or by its path; in either " +
+			"case relative to the subvolume given by --tree.\n" +
+			"\n" +
+			"By default the first block that can't be read aborts the " +
+			"copy.  With --ignore-csum, checksum mismatches are logged " +
+			"but the data is used anyway, and unreadable blocks are " +
+			"logged and written as zeros.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) (err error) {
+			ctx := cmd.Context()
+			treeID, err := parseTreeID(flags.tree)
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+			var inode btrfsprim.ObjID
+			switch {
+			case (flags.inode == "") == (flags.path == ""):
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("exactly one of --inode or --path must be given"))
+			case flags.inode != "":
+				n, err := strconv.ParseUint(flags.inode, 0, 64)
+				if err != nil {
+					return cliutil.FlagErrorFunc(cmd, fmt.Errorf("--inode: %w", err))
+				}
+				inode = btrfsprim.ObjID(n)
+			default:
+				treeID, inode, err = catfile.ResolvePath(ctx, fs, treeID, flags.path, globalFlags.cacheNodes)
+				if err != nil {
+					return err
+				}
+			}
+
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			numBad, err := catfile.CatFile(ctx, out, fs, treeID, inode, flags.ignoreCSum, globalFlags.cacheNodes)
+			if err != nil {
+				return err
+			}
+			if numBad > 0 {
+				return fmt.Errorf("file written, but with %v problems", numBad)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&flags.tree, "tree", "FS_TREE",
+		"the subvolume `TREE_ID` (a number, or a name like 'FS_TREE') that the file is in")
+	cmd.Flags().StringVar(&flags.inode, "inode", "",
+		"the `INODE` number of the file")
+	cmd.Flags().StringVar(&flags.path, "path", "",
+		"the `PATH` of the file, relative to the root of the subvolume")
+	cmd.Flags().BoolVar(&flags.ignoreCSum, "ignore-csum", false,
+		"log checksum mismatches and unreadable blocks rather than aborting")
+
+	inspectors.AddCommand(cmd)
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/checkdevextents"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "check-dev-extents",
+		Short: "Check that DEV_EXTENTs and chunk stripes agree",
+		Long: "" +
+			"Every stripe of every CHUNK_ITEM in the chunk tree should " +
+			"have a DEV_EXTENT item in the device tree claiming the same " +
+			"physical range for the same chunk, and vice-versa.  Report " +
+			"stripes with no DEV_EXTENT, DEV_EXTENTs with no stripe, and " +
+			"pairs that disagree on the logical address or the length.  " +
+			"Exits non-zero if any are found, or if either tree could not " +
+			"be fully read.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) (err error) {
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			numBad, err := checkdevextents.CheckDevExtents(cmd.Context(), out, fs)
+			if err != nil {
+				return fmt.Errorf("found %v dev extent/chunk stripe discrepancies, but the check is incomplete: %w", numBad, err)
+			}
+			if numBad > 0 {
+				return fmt.Errorf("found %v dev extent/chunk stripe discrepancies", numBad)
+			}
+			return nil
+		}),
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/checkextentrefs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "check-extent-refs",
+		Short: "Verify the reference counts of extent items against their backrefs",
+		Args:  cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) (err error) {
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			numBad, err := checkextentrefs.CheckExtentRefs(cmd.Context(), out, fs)
+			if err != nil {
+				return err
+			}
+			if numBad > 0 {
+				return fmt.Errorf("found %v extent reference count discrepancies", numBad)
+			}
+			return nil
+		}),
+	})
+}
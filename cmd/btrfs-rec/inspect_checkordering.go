@@ -0,0 +1,50 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/checkordering"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "check-ordering",
+		Short: "Verify that the keys in every tree are in order",
+		Long: "" +
+			"Walk the on-disk structure of every tree, and report each " +
+			"node and slot where a key is not greater than the key " +
+			"before it, or falls outside of the range that the parent " +
+			"node says it should be in.\n" +
+			"\n" +
+			"This always looks at the trees as they are on disk; it " +
+			"ignores --rebuild and --trees.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithRawFS(nil, func(fs *btrfs.FS, cmd *cobra.Command, _ []string) (err error) {
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			numBad, err := checkordering.CheckOrdering(cmd.Context(), out, fs)
+			if err != nil {
+				return err
+			}
+			if numBad > 0 {
+				return fmt.Errorf("found %v key ordering problems", numBad)
+			}
+			return nil
+		}),
+	})
+}
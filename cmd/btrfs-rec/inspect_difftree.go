@@ -0,0 +1,111 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/difftree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+)
+
+// openImage opens a single-device filesystem image, independently of
+// the global --pv flags.  The returned func closes the filesystem,
+// first logging its --io-stats if those are enabled.
+func openImage(ctx context.Context, filename string) (*btrfs.FS, func() error, error) {
+	dev, statsFile, err := openDevice(ctx, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	fs := &btrfs.FS{
+		NodeCacheSize: globalFlags.cacheNodes,
+	}
+	if err := fs.AddDevice(ctx, dev); err != nil {
+		_ = dev.Close()
+		return nil, nil, fmt.Errorf("device file %q: %w", filename, err)
+	}
+	if err := fs.InitChunks(ctx); err != nil {
+		dlog.Errorf(ctx, "error: %q: InitChunks: %v", filename, err)
+	}
+	closeFn := func() error {
+		if globalFlags.ioStats {
+			logNodeCacheStats(ctx, fs)
+		}
+		err := fs.Close()
+		if statsFile != nil {
+			logIOStats(ctx, filename, statsFile)
+		}
+		return err
+	}
+	return fs, closeFn, nil
+}
+
+func init() {
+	var full bool
+	cmd := &cobra.Command{
+		Use:   "diff-tree IMG_A IMG_B TREE_ID",
+		Short: "Compare the items in a tree between two filesystem images",
+		Long: "" +
+			"Walk the same tree in two single-device filesystem images, " +
+			"and print the key of each item that was removed (\"-\"), " +
+			"added (\"+\"), or changed (\"~\") going from IMG_A to IMG_B.\n" +
+			"\n" +
+			"TREE_ID may be a number or the name of a well-known tree " +
+			"(such as \"FS_TREE\" or \"EXTENT\").",
+		Args: cliutil.WrapPositionalArgs(cobra.ExactArgs(3)),
+		RunE: run(func(cmd *cobra.Command, args []string) (err error) {
+			ctx := cmd.Context()
+
+			maybeSetErr := func(_err error) {
+				if _err != nil && err == nil {
+					err = _err
+				}
+			}
+
+			treeID, err := parseTreeID(args[2])
+			if err != nil {
+				return err
+			}
+
+			fsA, closeA, err := openImage(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			defer func() {
+				maybeSetErr(closeA())
+			}()
+			fsB, closeB, err := openImage(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			defer func() {
+				maybeSetErr(closeB())
+			}()
+
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				maybeSetErr(out.Flush())
+			}()
+
+			numDiff, err := difftree.DiffTree(ctx, out, fsA, fsB, treeID, full)
+			if err != nil {
+				return err
+			}
+			dlog.Infof(ctx, "%v differing items", numDiff)
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&full, "full", false,
+		"also dump the bodies of differing items")
+
+	inspectors.AddCommand(cmd)
+}
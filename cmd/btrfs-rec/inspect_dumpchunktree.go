@@ -0,0 +1,46 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/dumpchunktree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "dump-chunk-tree",
+		Short: "Dump the chunk mappings, even if other trees are broken",
+		Long: "" +
+			"Print the SYSTEM chunks from each device's sys_chunk_array, " +
+			"then every chunk and device item in the chunk tree.  This " +
+			"only relies on the superblocks and the chunk tree itself, " +
+			"so it works even when the root tree is unreadable.\n" +
+			"\n" +
+			"Unreadable nodes and items are reported and skipped, as are " +
+			"SYSTEM chunks that the chunk tree disagrees with.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithRawFS(nil, func(fs *btrfs.FS, cmd *cobra.Command, _ []string) (err error) {
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			if numBad := dumpchunktree.DumpChunkTree(cmd.Context(), out, fs); numBad > 0 {
+				return fmt.Errorf("found %v problems with the chunk tree", numBad)
+			}
+			return nil
+		}),
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/dumptree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func init() {
+	var flags struct {
+		tree string
+	}
+	cmd := &cobra.Command{
+		Use:   "dump-tree",
+		Short: "Print each node header and a one-line summary of each item",
+		Long: "" +
+			"Print each node header and a one-line summary of each item " +
+			"or key-pointer in it, in the spirit of `btrfs " +
+			"inspect-internal dump-tree` but without decoding the item " +
+			"bodies.  (For a full clone of that, see `dump-trees`.)\n" +
+			"\n" +
+			"Nodes that fail to parse are still dumped as far as they " +
+			"could be parsed.\n" +
+			"\n" +
+			"With --tree, only that tree is dumped.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) error {
+			var treeID containers.Optional[btrfsprim.ObjID]
+			if flags.tree != "" {
+				id, err := parseTreeID(flags.tree)
+				if err != nil {
+					return cliutil.FlagErrorFunc(cmd, err)
+				}
+				treeID = containers.OptionalValue(id)
+			}
+			return dumptree.DumpTree(cmd.Context(), os.Stdout, fs, treeID)
+		}),
+	}
+	cmd.Flags().StringVar(&flags.tree, "tree", "",
+		"only dump the tree `TREE_ID` (a number, or a name like 'FS_TREE')")
+
+	inspectors.AddCommand(cmd)
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "explain-node TREE_ID NODE_LADDR",
+		Short: "Explain why a node is or isn't part of a rebuilt tree",
+		Long: "" +
+			"When a tree is rebuilt (with --rebuild or --trees), nodes " +
+			"whose owner or generation don't fit the tree are silently " +
+			"left out of it.  For debugging why expected data didn't " +
+			"appear in a rebuilt tree, print the chain of decisions that " +
+			"included or excluded the node at logical address NODE_LADDR " +
+			"from the tree TREE_ID (a number, or a name like 'FS_TREE').",
+		Args: cliutil.WrapPositionalArgs(cobra.ExactArgs(2)),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			treeID, err := parseTreeID(args[0])
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+			laddr, err := strconv.ParseInt(args[1], 0, 64)
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("invalid logical address %q: %w", args[1], err))
+			}
+
+			rfs, ok := fs.(*btrfsutil.RebuiltForrest)
+			if !ok {
+				return cliutil.FlagErrorFunc(cmd, errors.New("explain-node requires --rebuild or --trees"))
+			}
+			tree, err := rfs.RebuiltTree(ctx, treeID)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(os.Stdout, tree.RebuiltExplainNode(ctx, btrfsvol.LogicalAddr(laddr)))
+			return err
+		}),
+	})
+}
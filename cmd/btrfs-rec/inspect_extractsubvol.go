@@ -0,0 +1,64 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/extractsubvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+)
+
+func init() {
+	var checksumErrorsAreFatal bool
+	cmd := &cobra.Command{
+		Use:   "extract-subvol [SUBVOL_ID]",
+		Short: "Write the contents of a subvolume to stdout as a tar archive",
+		Long: "" +
+			"Write the files in the subvolume SUBVOL_ID (default: FS_TREE) " +
+			"to stdout as a tar archive, for recovering files without " +
+			"having to mount the filesystem.  Child subvolumes are " +
+			"included as empty directories.\n" +
+			"\n" +
+			"Problems with individual files are logged and do not abort " +
+			"the archive; unreadable parts of files are filled with zeros.",
+		Args: cliutil.WrapPositionalArgs(cobra.MaximumNArgs(1)),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, args []string) (err error) {
+			treeID := btrfsprim.FS_TREE_OBJECTID
+			if len(args) > 0 {
+				treeID, err = parseTreeID(args[0])
+				if err != nil {
+					return cliutil.FlagErrorFunc(cmd, err)
+				}
+			}
+
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			numBad, err := extractsubvol.ExtractSubvol(cmd.Context(), out, fs, treeID, !checksumErrorsAreFatal, globalFlags.cacheNodes)
+			if err != nil {
+				return err
+			}
+			if numBad > 0 {
+				return fmt.Errorf("archive written, but with %v problems", numBad)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&checksumErrorsAreFatal, "checksum-errors-are-fatal", true,
+		"treat a checksum mismatch in file contents as an unreadable block; if false, only log it")
+
+	inspectors.AddCommand(cmd)
+}
@@ -31,9 +31,12 @@ func init() {
 		RunE: runWithRawFS(nil, func(fs *btrfs.FS, cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
-			nodeList, err := btrfsutil.ListNodes(ctx, fs)
-			if err != nil {
-				return err
+			nodeList, scanErr := btrfsutil.ListNodes(ctx, fs)
+			if nodeList == nil && scanErr != nil {
+				return scanErr
+			}
+			if scanErr != nil {
+				dlog.Errorf(ctx, "scan stopped early: %v; writing the partial node list", scanErr)
 			}
 
 			dlog.Infof(ctx, "Writing nodes to stdout...")
@@ -45,7 +48,7 @@ func init() {
 			}
 			dlog.Info(ctx, "... done writing")
 
-			return nil
+			return scanErr
 		}),
 	})
 }
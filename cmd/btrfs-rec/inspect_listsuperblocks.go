@@ -0,0 +1,74 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "list-superblocks",
+		Short: "Scan the devices for superblock-like sectors",
+		Long: "" +
+			"This scans each --pv sector-by-sector looking for anything " +
+			"with the btrfs superblock magic number, and lists each one " +
+			"found along with its generation, FSID, and whether its " +
+			"checksum is valid.  This helps identify stale superblocks, " +
+			"or superblocks left behind by a previous filesystem.\n" +
+			"\n" +
+			"Unlike most commands, this does not require that the devices' " +
+			"own superblocks be valid.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: run(func(cmd *cobra.Command, _ []string) (err error) {
+			ctx := cmd.Context()
+			if len(globalFlags.pvs) == 0 {
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("must specify 1 or more physical volumes with --pv"))
+			}
+
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			for _, filename := range globalFlags.pvs {
+				dev, _, err := openDevice(ctx, filename)
+				if err != nil {
+					return err
+				}
+				found, err := btrfsutil.ScanForSuperblocks(ctx, dev)
+				_ = dev.Close()
+				if err != nil {
+					return fmt.Errorf("device file %q: %w", filename, err)
+				}
+				textui.Fprintf(out, "device file %q: %v superblock-like sectors\n", filename, len(found))
+				for _, sb := range found {
+					where := "stray"
+					if sb.IsMirror() {
+						where = "mirror"
+					}
+					csum := "csum=ok"
+					if sb.ChecksumErr != nil {
+						csum = fmt.Sprintf("csum=bad(%v)", sb.ChecksumErr)
+					}
+					textui.Fprintf(out, "\tpaddr=%v\t%v\tgen=%v\tfsid=%v\tdevid=%v\t%v\n",
+						sb.Addr, where, sb.Superblock.Generation, sb.Superblock.FSUUID,
+						sb.Superblock.DevItem.DevID, csum)
+				}
+			}
+			return nil
+		}),
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/lsbackuproots"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "ls-backup-roots",
+		Short: "List which trees are intact as of each backup root",
+		Long: "" +
+			"The superblock records the tree roots of several recent " +
+			"generations as 'backup roots'.  For every tree that can be " +
+			"found from either the current superblock or any backup root, " +
+			"say whether that tree is intact as of each of those " +
+			"generations, and the newest generation at which it is intact; " +
+			"calling out which trees are intact only via a backup root.\n" +
+			"\n" +
+			"A tree is considered intact if its root node can be read; " +
+			"the rest of the tree is not checked.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) (err error) {
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			return lsbackuproots.LsBackupRoots(cmd.Context(), out, fs)
+		}),
+	})
+}
@@ -31,7 +31,8 @@ func init() {
 			return lsfiles.LsFiles(
 				cmd.Context(),
 				out,
-				fs)
+				fs,
+				globalFlags.cacheNodes)
 		}),
 	})
 }
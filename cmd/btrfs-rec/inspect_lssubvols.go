@@ -0,0 +1,35 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/lssubvols"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+)
+
+func init() {
+	var onlyReceived bool
+	cmd := &cobra.Command{
+		Use:   "ls-subvols",
+		Short: "List subvolumes, along with their send/receive provenance",
+		Long: "" +
+			"For each subvolume, print its ID, UUID, parent UUID (if it is a " +
+			"snapshot), and received UUID, the received generation (rtransid), " +
+			"and the received time (if it was created by 'btrfs receive').",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) error {
+			return lssubvols.LsSubvols(cmd.Context(), os.Stdout, fs, onlyReceived)
+		}),
+	}
+	cmd.Flags().BoolVar(&onlyReceived, "received", false,
+		"only list subvolumes that were created by 'btrfs receive'")
+
+	inspectors.AddCommand(cmd)
+}
@@ -5,10 +5,15 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"text/tabwriter"
 
+	"git.lukeshu.com/go/lowmemjson"
 	"github.com/datawire/ocibuild/pkg/cliutil"
 	"github.com/spf13/cobra"
 
@@ -25,92 +30,304 @@ import (
 )
 
 func init() {
-	inspectors.AddCommand(&cobra.Command{
+	var flags struct {
+		tree   string
+		minKey string
+		maxKey string
+		json   bool
+	}
+	cmd := &cobra.Command{
 		Use:   "ls-trees",
 		Short: "A brief view what types of items are in each tree",
 		Long: "" +
 			"If no --node-list is given, then a slow sector-by-sector scan " +
-			"will be used to find all lost+found nodes.",
+			"will be used to find all lost+found nodes.\n" +
+			"\n" +
+			"With --tree, only that tree (and lost+found nodes owned by " +
+			"it) is listed.  With --min-key and/or --max-key, only items " +
+			"within that (inclusive) range of keys are counted, and " +
+			"parts of the trees that can't contain such items are " +
+			"skipped.  Without --tree, the range does not prevent the " +
+			"root tree from being walked in order to find the other trees.\n" +
+			"\n" +
+			"With --json, the output is instead a JSON array with one " +
+			"object per tree (the last being lost+found, with a null ID), " +
+			"written as each tree is finished.",
 		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
 		RunE: runWithReadableFSAndNodeList(func(fs btrfs.ReadableFS, nodeList []btrfsvol.LogicalAddr, cmd *cobra.Command, _ []string) error {
-			ctx := cmd.Context()
-
-			var treeErrCnt int
-			var treeItemCnt map[btrfsitem.Type]int
-			flush := func() {
-				totalItems := 0
-				for _, cnt := range treeItemCnt {
-					totalItems += cnt
-				}
-				numWidth := len(strconv.Itoa(slices.Max(treeErrCnt, totalItems)))
+			filter, err := parseLsTreesFilter(flags.tree, flags.minKey, flags.maxKey)
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				_ = out.Flush()
+			}()
+			var sink lsTreesSink = lsTreesText{out: out}
+			if flags.json {
+				sink = &lsTreesJSON{out: out}
+			}
+			if err := lsTrees(cmd.Context(), sink, fs, nodeList, filter); err != nil {
+				return err
+			}
+			return out.Flush()
+		}),
+	}
+	cmd.Flags().StringVar(&flags.tree, "tree", "",
+		"only list the tree `TREE_ID` (a number, or a name like 'EXTENT_TREE')")
+	cmd.Flags().StringVar(&flags.minKey, "min-key", "",
+		"only count items with a key >= `OBJECTID,TYPE,OFFSET`")
+	cmd.Flags().StringVar(&flags.maxKey, "max-key", "",
+		"only count items with a key <= `OBJECTID,TYPE,OFFSET`")
+	cmd.Flags().BoolVar(&flags.json, "json", false,
+		"write the output as JSON instead of as text tables")
 
-				table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0) //nolint:gomnd // This is what looks nice.
-				textui.Fprintf(table, "        errors\t% *s\n", numWidth, strconv.Itoa(treeErrCnt))
-				for _, typ := range maps.SortedKeys(treeItemCnt) {
-					textui.Fprintf(table, "        %v items\t% *s\n", typ, numWidth, strconv.Itoa(treeItemCnt[typ]))
-				}
-				textui.Fprintf(table, "        total items\t% *s\n", numWidth, strconv.Itoa(totalItems))
-				_ = table.Flush()
+	inspectors.AddCommand(cmd)
+}
+
+type lsTreesFilter struct {
+	TreeID containers.Optional[btrfsprim.ObjID]
+	MinKey btrfsprim.Key
+	MaxKey btrfsprim.Key
+}
+
+func parseLsTreesFilter(treeStr, minKeyStr, maxKeyStr string) (lsTreesFilter, error) {
+	ret := lsTreesFilter{
+		MinKey: btrfsprim.Key{},
+		MaxKey: btrfsprim.MaxKey,
+	}
+	if treeStr != "" {
+		treeID, err := parseTreeID(treeStr)
+		if err != nil {
+			return lsTreesFilter{}, fmt.Errorf("--tree: %w", err)
+		}
+		ret.TreeID = containers.OptionalValue(treeID)
+	}
+	if minKeyStr != "" {
+		key, err := parseKey(minKeyStr)
+		if err != nil {
+			return lsTreesFilter{}, fmt.Errorf("--min-key: %w", err)
+		}
+		ret.MinKey = key
+	}
+	if maxKeyStr != "" {
+		key, err := parseKey(maxKeyStr)
+		if err != nil {
+			return lsTreesFilter{}, fmt.Errorf("--max-key: %w", err)
+		}
+		ret.MaxKey = key
+	}
+	if ret.MinKey.Compare(ret.MaxKey) > 0 {
+		return lsTreesFilter{}, fmt.Errorf("--min-key=%v is greater than --max-key=%v", ret.MinKey, ret.MaxKey)
+	}
+	return ret, nil
+}
+
+func (f lsTreesFilter) matchKey(key btrfsprim.Key) bool {
+	return key.Compare(f.MinKey) >= 0 && key.Compare(f.MaxKey) <= 0
+}
+
+// matchKeyRange returns whether the inclusive range [min, max]
+// overlaps with the filter's range.
+func (f lsTreesFilter) matchKeyRange(min, max btrfsprim.Key) bool {
+	return max.Compare(f.MinKey) >= 0 && min.Compare(f.MaxKey) <= 0
+}
+
+// lsTreesSummary is the item histogram of one tree, or of the
+// lost+found nodes.
+type lsTreesSummary struct {
+	ID     containers.Optional[btrfsprim.ObjID] // not set for lost+found
+	Name   string
+	Errors int
+	Items  map[btrfsitem.Type]int
+}
+
+func (s lsTreesSummary) totalItems() int {
+	total := 0
+	for _, cnt := range s.Items {
+		total += cnt
+	}
+	return total
+}
+
+type lsTreesSink interface {
+	Tree(lsTreesSummary) error
+	Done() error
+}
+
+// lsTreesText writes the human-readable tables.
+type lsTreesText struct {
+	out io.Writer
+}
+
+func (sink lsTreesText) Tree(tree lsTreesSummary) error {
+	if tree.ID.OK {
+		textui.Fprintf(sink.out, "tree id=%v name=%q\n", tree.ID.Val, tree.Name)
+	} else {
+		textui.Fprintf(sink.out, "%s\n", tree.Name)
+	}
+	totalItems := tree.totalItems()
+	numWidth := len(strconv.Itoa(slices.Max(tree.Errors, totalItems)))
+
+	table := tabwriter.NewWriter(sink.out, 0, 8, 2, ' ', 0) //nolint:gomnd // This is what looks nice.
+	textui.Fprintf(table, "        errors\t% *s\n", numWidth, strconv.Itoa(tree.Errors))
+	for _, typ := range maps.SortedKeys(tree.Items) {
+		textui.Fprintf(table, "        %v items\t% *s\n", typ, numWidth, strconv.Itoa(tree.Items[typ]))
+	}
+	textui.Fprintf(table, "        total items\t% *s\n", numWidth, strconv.Itoa(totalItems))
+	return table.Flush()
+}
+
+func (lsTreesText) Done() error { return nil }
+
+// lsTreesJSON writes a JSON array, one element at a time, so that
+// the whole thing never needs to be in memory.
+type lsTreesJSON struct {
+	out     io.Writer
+	started bool
+}
+
+type lsTreesJSONTree struct {
+	ID         containers.Optional[btrfsprim.ObjID]
+	Name       string
+	Errors     int
+	Items      map[string]int // by item type name
+	TotalItems int
+}
+
+func (sink *lsTreesJSON) Tree(tree lsTreesSummary) error {
+	sep := ",\n"
+	if !sink.started {
+		sep = "[\n"
+		sink.started = true
+	}
+	if _, err := io.WriteString(sink.out, sep); err != nil {
+		return err
+	}
+	obj := lsTreesJSONTree{
+		ID:         tree.ID,
+		Name:       tree.Name,
+		Errors:     tree.Errors,
+		Items:      make(map[string]int, len(tree.Items)),
+		TotalItems: tree.totalItems(),
+	}
+	for typ, cnt := range tree.Items {
+		obj.Items[typ.String()] = cnt
+	}
+	return lowmemjson.NewEncoder(sink.out).Encode(obj)
+}
+
+func (sink *lsTreesJSON) Done() error {
+	end := "\n]\n"
+	if !sink.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(sink.out, end)
+	return err
+}
+
+func lsTrees(ctx context.Context, sink lsTreesSink, fs btrfs.ReadableFS, nodeList []btrfsvol.LogicalAddr, filter lsTreesFilter) error {
+	var cur lsTreesSummary
+	var sinkErr error
+	flush := func() {
+		if sinkErr == nil {
+			sinkErr = sink.Tree(cur)
+		}
+	}
+	visitedNodes := make(containers.Set[btrfsvol.LogicalAddr])
+	walkHandler := btrfstree.TreeWalkHandler{
+		Node: func(path btrfstree.Path, node *btrfstree.Node) {
+			visitedNodes.Insert(node.Head.Addr)
+		},
+		BadNode: func(path btrfstree.Path, node *btrfstree.Node, err error) bool {
+			cur.Errors++
+			return false
+		},
+		KeyPointer: func(path btrfstree.Path, _ btrfstree.KeyPointer) bool {
+			// WalkAllTrees needs to see all of the ROOT_ITEMs
+			// in order to find the other trees.
+			if !filter.TreeID.OK && path[0].(btrfstree.PathRoot).TreeID == btrfsprim.ROOT_TREE_OBJECTID { //nolint:forcetypeassert // has to be
+				return true
 			}
-			visitedNodes := make(containers.Set[btrfsvol.LogicalAddr])
-			btrfsutil.WalkAllTrees(ctx, fs, btrfsutil.WalkAllTreesHandler{
-				PreTree: func(name string, treeID btrfsprim.ObjID) {
-					treeErrCnt = 0
-					treeItemCnt = make(map[btrfsitem.Type]int)
-					textui.Fprintf(os.Stdout, "tree id=%v name=%q\n", treeID, name)
-				},
-				BadTree: func(_ string, _ btrfsprim.ObjID, _ error) {
-					treeErrCnt++
-				},
-				Tree: btrfstree.TreeWalkHandler{
-					Node: func(path btrfstree.Path, node *btrfstree.Node) {
-						visitedNodes.Insert(node.Head.Addr)
-					},
-					BadNode: func(path btrfstree.Path, node *btrfstree.Node, err error) bool {
-						treeErrCnt++
-						return false
-					},
-					Item: func(_ btrfstree.Path, item btrfstree.Item) {
-						typ := item.Key.ItemType
-						treeItemCnt[typ]++
-					},
-					BadItem: func(_ btrfstree.Path, item btrfstree.Item) {
-						typ := item.Key.ItemType
-						treeItemCnt[typ]++
-					},
-				},
-				PostTree: func(_ string, _ btrfsprim.ObjID) {
-					flush()
-				},
-			})
+			kp := path[len(path)-1].(btrfstree.PathKP) //nolint:forcetypeassert // has to be
+			return filter.matchKeyRange(kp.ToMinKey, kp.ToMaxKey)
+		},
+		Item: func(_ btrfstree.Path, item btrfstree.Item) {
+			if filter.matchKey(item.Key) {
+				cur.Items[item.Key.ItemType]++
+			}
+		},
+		BadItem: func(_ btrfstree.Path, item btrfstree.Item) {
+			if filter.matchKey(item.Key) {
+				cur.Items[item.Key.ItemType]++
+			}
+		},
+	}
+	preTree := func(name string, treeID btrfsprim.ObjID) {
+		cur = lsTreesSummary{
+			ID:    containers.OptionalValue(treeID),
+			Name:  name,
+			Items: make(map[btrfsitem.Type]int),
+		}
+	}
 
-			{
-				treeErrCnt = 0
-				treeItemCnt = make(map[btrfsitem.Type]int)
-				textui.Fprintf(os.Stdout, "lost+found\n")
-				for _, laddr := range nodeList {
-					if visitedNodes.Has(laddr) {
-						continue
-					}
-					visitedNodes.Insert(laddr)
-					node, err := fs.AcquireNode(ctx, laddr, btrfstree.NodeExpectations{
-						LAddr: containers.OptionalValue(laddr),
-					})
-					if err != nil {
-						fs.ReleaseNode(node)
-						treeErrCnt++
-						continue
-					}
-					for _, item := range node.BodyLeaf {
-						typ := item.Key.ItemType
-						treeItemCnt[typ]++
-					}
-					fs.ReleaseNode(node)
-				}
+	if filter.TreeID.OK {
+		treeID := filter.TreeID.Val
+		preTree(fmt.Sprintf("tree %v", treeID), treeID)
+		tree, err := fs.ForrestLookup(ctx, treeID)
+		if err != nil {
+			cur.Errors++
+		} else {
+			tree.TreeWalk(ctx, walkHandler)
+		}
+		flush()
+	} else {
+		btrfsutil.WalkAllTrees(ctx, fs, btrfsutil.WalkAllTreesHandler{
+			PreTree: preTree,
+			BadTree: func(_ string, _ btrfsprim.ObjID, _ error) {
+				cur.Errors++
+			},
+			Tree: walkHandler,
+			PostTree: func(_ string, _ btrfsprim.ObjID) {
 				flush()
+			},
+		})
+	}
+
+	{
+		cur = lsTreesSummary{
+			Name:  "lost+found",
+			Items: make(map[btrfsitem.Type]int),
+		}
+		for _, laddr := range nodeList {
+			if visitedNodes.Has(laddr) {
+				continue
 			}
+			visitedNodes.Insert(laddr)
+			node, err := fs.AcquireNode(ctx, laddr, btrfstree.NodeExpectations{
+				LAddr: containers.OptionalValue(laddr),
+			})
+			if err != nil {
+				fs.ReleaseNode(node)
+				cur.Errors++
+				continue
+			}
+			if filter.TreeID.OK && node.Head.Owner != filter.TreeID.Val {
+				fs.ReleaseNode(node)
+				continue
+			}
+			for _, item := range node.BodyLeaf {
+				if filter.matchKey(item.Key) {
+					cur.Items[item.Key.ItemType]++
+				}
+			}
+			fs.ReleaseNode(node)
+		}
+		flush()
+	}
 
-			return nil
-		}),
-	})
+	if sinkErr != nil {
+		return sinkErr
+	}
+	return sink.Done()
 }
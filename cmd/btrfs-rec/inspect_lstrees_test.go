@@ -0,0 +1,282 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"git.lukeshu.com/go/lowmemjson"
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func TestParseKey(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		Str    string
+		ExpKey btrfsprim.Key
+		ExpErr string
+	}
+	testcases := map[string]TestCase{
+		"numbers": {
+			Str:    "256,1,0",
+			ExpKey: btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.INODE_ITEM_KEY, Offset: 0},
+		},
+		"names": {
+			Str:    "0x100, inode_ref, 0x10",
+			ExpKey: btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.INODE_REF_KEY, Offset: 16},
+		},
+		"max-offset": {
+			Str:    "256,DIR_ITEM,-1",
+			ExpKey: btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: math.MaxUint64},
+		},
+		"too-few": {
+			Str:    "256,1",
+			ExpErr: `invalid key: "256,1" is not of the form OBJECTID,TYPE,OFFSET`,
+		},
+		"bad-type": {
+			Str:    "256,NOT_A_TYPE,0",
+			ExpErr: `invalid key: "256,NOT_A_TYPE,0": invalid item type: "NOT_A_TYPE"`,
+		},
+		"bad-offset": {
+			Str:    "256,1,x",
+			ExpErr: `invalid key: "256,1,x": offset: strconv.ParseUint: parsing "x": invalid syntax`,
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			key, err := parseKey(tc.Str)
+			if tc.ExpErr != "" {
+				assert.EqualError(t, err, tc.ExpErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpKey, key)
+		})
+	}
+}
+
+func TestParseLsTreesFilter(t *testing.T) {
+	t.Parallel()
+	filter, err := parseLsTreesFilter("", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, lsTreesFilter{MaxKey: btrfsprim.MaxKey}, filter)
+
+	filter, err = parseLsTreesFilter("extent", "256,0,0", "256,-1,-1")
+	require.Error(t, err)
+	assert.Equal(t, lsTreesFilter{}, filter)
+
+	filter, err = parseLsTreesFilter("extent", "256,0,0", "256,255,-1")
+	require.NoError(t, err)
+	assert.Equal(t, lsTreesFilter{
+		TreeID: containers.OptionalValue(btrfsprim.EXTENT_TREE_OBJECTID),
+		MinKey: btrfsprim.Key{ObjectID: 256},
+		MaxKey: btrfsprim.Key{ObjectID: 256, ItemType: 255, Offset: math.MaxUint64},
+	}, filter)
+
+	_, err = parseLsTreesFilter("", "257,0,0", "256,0,0")
+	assert.EqualError(t, err, "--min-key=(257 UNTYPED 0) is greater than --max-key=(256 UNTYPED 0)")
+}
+
+// lsTreesTestFS is a btrfs.ReadableFS that only implements
+// ForrestLookup.
+type lsTreesTestFS struct {
+	btrfs.ReadableFS
+	trees map[btrfsprim.ObjID]*lsTreesTestTree
+}
+
+func (fs *lsTreesTestFS) ForrestLookup(_ context.Context, treeID btrfsprim.ObjID) (btrfstree.Tree, error) {
+	tree, ok := fs.trees[treeID]
+	if !ok {
+		return nil, btrfstree.ErrNoTree
+	}
+	return tree, nil
+}
+
+// lsTreesTestTree is a btrfstree.Tree that only implements TreeWalk;
+// it is a single interior node pointing at a number of leaves.
+type lsTreesTestTree struct {
+	btrfstree.Tree
+	id     btrfsprim.ObjID
+	leaves [][]btrfsprim.Key
+
+	walkedLeaves []int
+}
+
+func (tree *lsTreesTestTree) leafAddr(i int) btrfsvol.LogicalAddr {
+	return btrfsvol.LogicalAddr(tree.id)<<20 + btrfsvol.LogicalAddr(i+1)<<12
+}
+
+func (tree *lsTreesTestTree) TreeWalk(_ context.Context, cbs btrfstree.TreeWalkHandler) {
+	root := btrfstree.Path{btrfstree.PathRoot{
+		TreeID:  tree.id,
+		ToAddr:  tree.leafAddr(-1),
+		ToLevel: 1,
+	}}
+	for i, leaf := range tree.leaves {
+		maxKey := btrfsprim.MaxKey
+		if i+1 < len(tree.leaves) {
+			maxKey = tree.leaves[i+1][0].Mm()
+		}
+		kp := btrfstree.KeyPointer{
+			Key:      leaf[0],
+			BlockPtr: tree.leafAddr(i),
+		}
+		path := append(root[:len(root):len(root)], btrfstree.PathKP{
+			FromTree: tree.id,
+			FromSlot: i,
+			ToAddr:   kp.BlockPtr,
+			ToMinKey: kp.Key,
+			ToMaxKey: maxKey,
+		})
+		if cbs.KeyPointer != nil && !cbs.KeyPointer(path, kp) {
+			continue
+		}
+		tree.walkedLeaves = append(tree.walkedLeaves, i)
+		if cbs.Node != nil {
+			cbs.Node(path, &btrfstree.Node{Head: btrfstree.NodeHeader{
+				Addr:     kp.BlockPtr,
+				Owner:    tree.id,
+				NumItems: uint32(len(leaf)),
+			}})
+		}
+		for j, key := range leaf {
+			itemPath := append(path[:len(path):len(path)], btrfstree.PathItem{
+				FromTree: tree.id,
+				FromSlot: j,
+				ToKey:    key,
+			})
+			cbs.Item(itemPath, btrfstree.Item{Key: key, Body: &btrfsitem.Empty{}})
+		}
+	}
+}
+
+func TestLsTreesFiltered(t *testing.T) {
+	t.Parallel()
+	key := func(objID btrfsprim.ObjID, typ btrfsprim.ItemType, offset uint64) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: objID, ItemType: typ, Offset: offset}
+	}
+	newFS := func() *lsTreesTestFS {
+		return &lsTreesTestFS{
+			trees: map[btrfsprim.ObjID]*lsTreesTestTree{
+				btrfsprim.ROOT_TREE_OBJECTID: {
+					id: btrfsprim.ROOT_TREE_OBJECTID,
+					leaves: [][]btrfsprim.Key{
+						{key(btrfsprim.FS_TREE_OBJECTID, btrfsitem.ROOT_ITEM_KEY, 0)},
+					},
+				},
+				btrfsprim.FS_TREE_OBJECTID: {
+					id: btrfsprim.FS_TREE_OBJECTID,
+					leaves: [][]btrfsprim.Key{
+						{key(256, btrfsitem.INODE_ITEM_KEY, 0), key(256, btrfsitem.INODE_REF_KEY, 256)},
+						{key(257, btrfsitem.INODE_ITEM_KEY, 0), key(257, btrfsitem.DIR_ITEM_KEY, 5)},
+						{key(258, btrfsitem.INODE_ITEM_KEY, 0)},
+					},
+				},
+			},
+		}
+	}
+	minKey := key(257, btrfsitem.INODE_ITEM_KEY, 0)
+	maxKey := key(257, math.MaxUint8, math.MaxUint64)
+
+	t.Run("tree", func(t *testing.T) {
+		t.Parallel()
+		ctx := dlog.NewTestContext(t, false)
+		fs := newFS()
+		var out strings.Builder
+		require.NoError(t, lsTrees(ctx, lsTreesText{out: &out}, fs, nil, lsTreesFilter{
+			TreeID: containers.OptionalValue(btrfsprim.FS_TREE_OBJECTID),
+			MinKey: minKey,
+			MaxKey: maxKey,
+		}))
+		assert.Equal(t, ""+
+			"tree id=FS_TREE name=\"tree FS_TREE\"\n"+
+			"        errors            0\n"+
+			"        INODE_ITEM items  1\n"+
+			"        DIR_ITEM items    1\n"+
+			"        total items       2\n"+
+			"lost+found\n"+
+			"        errors       0\n"+
+			"        total items  0\n",
+			out.String())
+		assert.Equal(t, []int{1}, fs.trees[btrfsprim.FS_TREE_OBJECTID].walkedLeaves)
+		assert.Nil(t, fs.trees[btrfsprim.ROOT_TREE_OBJECTID].walkedLeaves)
+	})
+	t.Run("all-trees", func(t *testing.T) {
+		t.Parallel()
+		ctx := dlog.NewTestContext(t, false)
+		fs := newFS()
+		var out strings.Builder
+		require.NoError(t, lsTrees(ctx, lsTreesText{out: &out}, fs, nil, lsTreesFilter{
+			MinKey: minKey,
+			MaxKey: maxKey,
+		}))
+		assert.Contains(t, out.String(), ""+
+			"        errors            0\n"+
+			"        INODE_ITEM items  1\n"+
+			"        DIR_ITEM items    1\n"+
+			"        total items       2\n")
+		// The root tree must still be walked in order to find
+		// the FS_TREE, even though its items are all outside of
+		// the range.
+		assert.Equal(t, []int{0}, fs.trees[btrfsprim.ROOT_TREE_OBJECTID].walkedLeaves)
+		assert.Equal(t, []int{1}, fs.trees[btrfsprim.FS_TREE_OBJECTID].walkedLeaves)
+	})
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		ctx := dlog.NewTestContext(t, false)
+		filter := lsTreesFilter{
+			MinKey: btrfsprim.Key{},
+			MaxKey: btrfsprim.MaxKey,
+		}
+
+		var textOut strings.Builder
+		require.NoError(t, lsTrees(ctx, lsTreesText{out: &textOut}, newFS(), nil, filter))
+		var jsonOut strings.Builder
+		require.NoError(t, lsTrees(ctx, &lsTreesJSON{out: &jsonOut}, newFS(), nil, filter))
+
+		var trees []lsTreesJSONTree
+		require.NoError(t, lowmemjson.NewDecoder(strings.NewReader(jsonOut.String())).DecodeThenEOF(&trees))
+		require.NotEmpty(t, trees)
+		var fsTree *lsTreesJSONTree
+		for i := range trees {
+			if trees[i].ID == containers.OptionalValue(btrfsprim.FS_TREE_OBJECTID) {
+				fsTree = &trees[i]
+			}
+		}
+		require.NotNil(t, fsTree)
+		assert.Equal(t, 0, fsTree.Errors)
+		assert.Equal(t, map[string]int{"INODE_ITEM": 3, "INODE_REF": 1, "DIR_ITEM": 1}, fsTree.Items)
+		assert.Equal(t, 5, fsTree.TotalItems)
+		assert.Equal(t, lsTreesJSONTree{
+			Name:       "lost+found",
+			Items:      map[string]int{},
+			TotalItems: 0,
+		}, trees[len(trees)-1])
+
+		// The counts must match the text output.
+		for _, tree := range trees {
+			assert.Regexp(t, fmt.Sprintf(`\n        errors +%d\n`, tree.Errors), textOut.String())
+			for typ, cnt := range tree.Items {
+				assert.Regexp(t, fmt.Sprintf(`\n        %s items +%d\n`, typ, cnt), textOut.String())
+			}
+			assert.Regexp(t, fmt.Sprintf(`\n        total items +%d\n`, tree.TotalItems), textOut.String())
+		}
+	})
+}
@@ -5,25 +5,61 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/datawire/ocibuild/pkg/cliutil"
 	"github.com/spf13/cobra"
 
 	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/mount"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 )
 
 func init() {
 	var skipFileSums bool
+	var checksumErrorsAreFatal bool
+	var unified bool
+	var subvol string
 	cmd := &cobra.Command{
 		Use:   "mount MOUNTPOINT",
 		Short: "Mount the filesystem read-only",
-		Args:  cliutil.WrapPositionalArgs(cobra.ExactArgs(1)),
+		Long: "" +
+			"Mount the filesystem read-only at MOUNTPOINT using FUSE.\n" +
+			"\n" +
+			"By default, each child subvolume is mounted as a separate FUSE " +
+			"filesystem (since each subvolume has its own set of inode " +
+			"numbers).  With --unified, child subvolumes are instead " +
+			"presented as plain directories within the one mount, which is " +
+			"easier to `cp -a` out of.\n" +
+			"\n" +
+			"Like the kernel, this mounts the filesystem's default subvolume " +
+			"(as set by `btrfs subvolume set-default`) unless --subvol is " +
+			"given.",
+		Args: cliutil.WrapPositionalArgs(cobra.ExactArgs(1)),
 		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, args []string) error {
-			return mount.MountRO(cmd.Context(), fs, args[0], skipFileSums)
+			var subvolID btrfsprim.ObjID
+			if subvol != "" {
+				var err error
+				subvolID, err = parseTreeID(subvol)
+				if err != nil {
+					return cliutil.FlagErrorFunc(cmd, fmt.Errorf("--subvol: %w", err))
+				}
+			}
+			return mount.MountRO(cmd.Context(), fs, args[0], subvolID, skipFileSums, !checksumErrorsAreFatal, unified, globalFlags.cacheNodes)
 		}),
 	}
 	cmd.Flags().BoolVar(&skipFileSums, "skip-filesums", false,
-		"ignore checksum failures on file contents; allow such files to be read")
+		"don't verify file contents against their checksums at all")
+	cmd.Flags().BoolVar(&checksumErrorsAreFatal, "checksum-errors-are-fatal", true,
+		"fail reads of file contents on a checksum mismatch; if false, log the mismatch and return the data anyway"+
+			" (has no effect with --skip-filesums, so the two may not be combined)")
+	cmd.MarkFlagsMutuallyExclusive("skip-filesums", "checksum-errors-are-fatal")
+
+	cmd.Flags().StringVar(&subvol, "subvol", "",
+		"mount the subvolume with this tree ID, rather than the default subvolume")
+
+	cmd.Flags().BoolVar(&unified, "unified", false,
+		"present child subvolumes as plain directories in a single mount, rather than as separate mounts")
 
 	inspectors.AddCommand(cmd)
 }
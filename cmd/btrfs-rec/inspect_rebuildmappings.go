@@ -5,7 +5,10 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 
 	"git.lukeshu.com/go/lowmemjson"
@@ -15,12 +18,83 @@ import (
 
 	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/rebuildmappings"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
 )
 
+// scanNodeFilter returns the node filter for
+// rebuildmappings.ScanDevices given the --node-owner flag values.
+func scanNodeFilter(owners []string) (func(btrfstree.NodeHeader) bool, error) {
+	if len(owners) == 0 {
+		return nil, nil
+	}
+	treeIDs := make([]btrfsprim.ObjID, 0, len(owners))
+	for _, owner := range owners {
+		treeID, err := parseTreeID(owner)
+		if err != nil {
+			return nil, err
+		}
+		treeIDs = append(treeIDs, treeID)
+	}
+	return btrfsutil.NodeOwnerFilter(treeIDs...), nil
+}
+
+// loadMappings adds the mappings from the --load-mappings file (if
+// any) to the filesystem, so that rebuilding starts from them.
+func loadMappings(ctx context.Context, fs *btrfs.FS, filename string) error {
+	if filename == "" {
+		return nil
+	}
+	dlog.Infof(ctx, "Loading mappings from %q...", filename)
+	if err := decodeJSONFile(ctx, filename, func(r io.RuneScanner) error {
+		return btrfsvol.DecodeMappings(r, fs.LV.AddMapping)
+	}); err != nil {
+		return fmt.Errorf("--load-mappings: %w", err)
+	}
+	dlog.Info(ctx, "... done loading")
+	return nil
+}
+
+// saveMappings writes the filesystem's mappings to the
+// --save-mappings file (if any), in the human-readable form that
+// --load-mappings and --mappings accept.
+func saveMappings(ctx context.Context, fs *btrfs.FS, filename string) (err error) {
+	if filename == "" {
+		return nil
+	}
+	dlog.Infof(ctx, "Saving mappings to %q...", filename)
+	fh, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("--save-mappings: %w", err)
+	}
+	defer func() {
+		if _err := fh.Close(); err == nil && _err != nil {
+			err = fmt.Errorf("--save-mappings: %w", _err)
+		}
+	}()
+	buffer := bufio.NewWriter(fh)
+	if err := btrfsvol.DumpMappingsJSON(lowmemjson.NewReEncoder(buffer, lowmemjson.ReEncoderConfig{
+		Indent:                "\t",
+		ForceTrailingNewlines: true,
+		CompactIfUnder:        120, //nolint:gomnd // This is what looks nice.
+	}), fs.LV.Mappings()); err != nil {
+		return fmt.Errorf("--save-mappings: %w", err)
+	}
+	if err := buffer.Flush(); err != nil {
+		return fmt.Errorf("--save-mappings: %w", err)
+	}
+	dlog.Info(ctx, "... done saving")
+	return nil
+}
+
 func init() {
+	var nodeOwners []string
+	var scanWorkers int
+	var loadMappingsFile, saveMappingsFile string
 	cmd := &cobra.Command{
 		Use:   "rebuild-mappings",
 		Short: "Rebuild broken chunk/dev/blockgroup trees",
@@ -28,6 +102,10 @@ func init() {
 			"The rebuilt information is printed as JSON on stdout, and can " +
 			"be loaded by the --mappings flag.\n" +
 			"\n" +
+			"To carry a reconstructed set of mappings between runs, use " +
+			"--save-mappings to also write it to a file, and " +
+			"--load-mappings to start the next run from it.\n" +
+			"\n" +
 			"This is very similar to `btrfs rescue chunk-recover`, but (1) " +
 			"does a better job, (2) is less buggy, and (3) doesn't actually " +
 			"write the info back to the filesystem; instead writing it " +
@@ -41,7 +119,16 @@ func init() {
 		RunE: runWithRawFS(nil, func(fs *btrfs.FS, cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
-			scanResults, err := rebuildmappings.ScanDevices(ctx, fs)
+			nodeFilter, err := scanNodeFilter(nodeOwners)
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+
+			if err := loadMappings(ctx, fs, loadMappingsFile); err != nil {
+				return err
+			}
+
+			scanResults, err := rebuildmappings.ScanDevices(ctx, fs, scanWorkers, nodeFilter)
 			if err != nil {
 				return err
 			}
@@ -49,9 +136,12 @@ func init() {
 			if err := rebuildmappings.RebuildMappings(ctx, fs, scanResults); err != nil {
 				return err
 			}
+			if err := saveMappings(ctx, fs, saveMappingsFile); err != nil {
+				return err
+			}
 
 			dlog.Infof(ctx, "Writing reconstructed mappings to stdout...")
-			if err := writeJSONFile(os.Stdout, fs.LV.Mappings(), lowmemjson.ReEncoderConfig{
+			if err := writeJSONFile(os.Stdout, fs.LV.MappingsJSON(), lowmemjson.ReEncoderConfig{
 				Indent:                "\t",
 				ForceTrailingNewlines: true,
 				CompactIfUnder:        120, //nolint:gomnd // This is what looks nice.
@@ -64,6 +154,18 @@ func init() {
 		}),
 	}
 
+	cmd.PersistentFlags().StringArrayVar(&nodeOwners, "node-owner", nil,
+		"only parse nodes owned by this tree (may be given multiple times; e.g. 'CHUNK' "+
+			"for a fast chunk-only scan); the scan results will be missing everything else")
+	cmd.PersistentFlags().IntVar(&scanWorkers, "scan-workers", 0,
+		"number of devices to scan in parallel (0 for one per device)")
+	cmd.PersistentFlags().StringVar(&loadMappingsFile, "load-mappings", "",
+		"start from the mappings in `mappings.json` (as written by --save-mappings) before rebuilding")
+	noError(cmd.MarkPersistentFlagFilename("load-mappings"))
+	cmd.PersistentFlags().StringVar(&saveMappingsFile, "save-mappings", "",
+		"also write the reconstructed mappings to `mappings.json`, in a human-readable form")
+	noError(cmd.MarkPersistentFlagFilename("save-mappings"))
+
 	cmd.AddCommand(&cobra.Command{
 		Use:   "scan",
 		Short: "Read from the filesystem all data nescessary to rebuild the mappings",
@@ -71,9 +173,17 @@ func init() {
 		RunE: runWithRawFS(nil, func(fs *btrfs.FS, cmd *cobra.Command, _ []string) (err error) {
 			ctx := cmd.Context()
 
-			devResults, err := rebuildmappings.ScanDevices(ctx, fs)
+			nodeFilter, err := scanNodeFilter(nodeOwners)
 			if err != nil {
-				return err
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+
+			devResults, scanErr := rebuildmappings.ScanDevices(ctx, fs, scanWorkers, nodeFilter)
+			if devResults == nil && scanErr != nil {
+				return scanErr
+			}
+			if scanErr != nil {
+				dlog.Errorf(ctx, "scan stopped early: %v; writing the partial scan results", scanErr)
 			}
 
 			scanResults := rebuildmappings.ScanResult{
@@ -91,7 +201,7 @@ func init() {
 			}
 			dlog.Info(ctx, "... done writing")
 
-			return nil
+			return scanErr
 		}),
 	})
 
@@ -130,16 +240,19 @@ func init() {
 					return err
 				}
 			}
-			return nil
+			return loadMappings(ctx, fs, loadMappingsFile)
 		}, func(fs *btrfs.FS, cmd *cobra.Command, _ []string) error {
 			ctx := cmd.Context()
 
 			if err := rebuildmappings.RebuildMappings(ctx, fs, scanResults.Devices); err != nil {
 				return err
 			}
+			if err := saveMappings(ctx, fs, saveMappingsFile); err != nil {
+				return err
+			}
 
 			dlog.Infof(ctx, "Writing reconstructed mappings to stdout...")
-			if err := writeJSONFile(os.Stdout, fs.LV.Mappings(), lowmemjson.ReEncoderConfig{
+			if err := writeJSONFile(os.Stdout, fs.LV.MappingsJSON(), lowmemjson.ReEncoderConfig{
 				Indent:                "\t",
 				ForceTrailingNewlines: true,
 				CompactIfUnder:        120, //nolint:gomnd // This is what looks nice.
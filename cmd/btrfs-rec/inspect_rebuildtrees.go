@@ -16,12 +16,15 @@ import (
 
 	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/rebuildtrees"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
 )
 
 func init() {
-	inspectors.AddCommand(&cobra.Command{
+	scanWorkers := runtime.GOMAXPROCS(0)
+	var nodeDecodeCacheSize int
+	cmd := &cobra.Command{
 		Use: "rebuild-trees",
 		Long: "" +
 			"Rebuild broken btrees based on missing items that are implied " +
@@ -35,7 +38,14 @@ func init() {
 		RunE: runWithRawFSAndNodeList(func(fs *btrfs.FS, nodeList []btrfsvol.LogicalAddr, cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
-			rebuilder, err := rebuildtrees.NewRebuilder(ctx, fs, nodeList)
+			if nodeDecodeCacheSize > 0 {
+				fs.NodeDecodeCache = btrfstree.NewNodeDecodeCache(nodeDecodeCacheSize)
+				defer func() {
+					dlog.Infof(ctx, "node decode cache: %v", fs.NodeDecodeCache.Stats())
+				}()
+			}
+
+			rebuilder, err := rebuildtrees.NewRebuilder(ctx, fs, nodeList, scanWorkers)
 			if err != nil {
 				return err
 			}
@@ -65,5 +75,11 @@ func init() {
 
 			return rebuildErr
 		}),
-	})
+	}
+	cmd.Flags().IntVar(&scanWorkers, "scan-workers", scanWorkers,
+		"number of nodes to read ahead when scanning the node list (at most one less than --cache-nodes)")
+	cmd.Flags().IntVar(&nodeDecodeCacheSize, "node-decode-cache", 0,
+		"cache up to this many parsed nodes by address and checksum, so that nodes re-read by later passes need not be re-parsed (0 to disable)")
+
+	inspectors.AddCommand(cmd)
 }
@@ -0,0 +1,51 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "rebuild-uuid-tree",
+		Short: "Reconstruct the UUID tree from the ROOT_ITEMs in the root tree",
+		Long: "" +
+			"Print the items that the UUID_TREE should contain, as derived " +
+			"from the ROOT_ITEMs in the ROOT_TREE.  This does not read the " +
+			"UUID_TREE at all, so it works even if the UUID_TREE is damaged.\n" +
+			"\n" +
+			"Several subvolumes may have been received from the same UUID; " +
+			"such a UUID_RECEIVED_SUBVOL item is printed as one line per " +
+			"subvolume.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) (err error) {
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			items, err := btrfsutil.RebuildUUIDTree(cmd.Context(), fs)
+			for _, item := range items {
+				for _, subvolID := range item.SubvolIDs {
+					textui.Fprintf(out, "%v uuid=%v subvol_id=%v\n",
+						item.Key, btrfsitem.KeyToUUID(item.Key), subvolID)
+				}
+			}
+			return err
+		}),
+	})
+}
@@ -5,7 +5,10 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/ocibuild/pkg/cliutil"
@@ -20,34 +23,154 @@ import (
 )
 
 func init() {
-	inspectors.AddCommand(&cobra.Command{
+	var flags struct {
+		tree        string
+		objectID    string
+		itemType    string
+		offset      string
+		offsetRange string
+		name        string
+	}
+	cmd := &cobra.Command{
 		Use:   "spew-items",
 		Short: "Spew all items as parsed",
-		Args:  cliutil.WrapPositionalArgs(cobra.NoArgs),
+		Long: "" +
+			"Spew all items as parsed.\n" +
+			"\n" +
+			"With --tree, only that tree is spewed.  The items may be " +
+			"further narrowed down with --objectid, --type, and one " +
+			"of --offset, --offset-range, or --name.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
 		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, _ []string) error {
 			ctx := cmd.Context()
 
 			spew := spew.NewDefaultConfig()
 			spew.DisablePointerAddresses = true
 
-			btrfsutil.WalkAllTrees(ctx, fs, btrfsutil.WalkAllTreesHandler{
-				BadTree: func(name string, id btrfsprim.ObjID, err error) {
-					dlog.Errorf(ctx, "%v: %v", name, err)
-				},
-				Tree: btrfstree.TreeWalkHandler{
-					Item: func(path btrfstree.Path, item btrfstree.Item) {
-						textui.Fprintf(os.Stdout, "%s = ", path)
-						spew.Dump(item)
-						_, _ = os.Stdout.WriteString("\n")
+			if flags.tree == "" {
+				if flags.objectID != "" || flags.itemType != "" || flags.offset != "" || flags.offsetRange != "" || flags.name != "" {
+					return cliutil.FlagErrorFunc(cmd, fmt.Errorf("--objectid, --type, --offset, --offset-range, and --name require --tree"))
+				}
+				btrfsutil.WalkAllTrees(ctx, fs, btrfsutil.WalkAllTreesHandler{
+					BadTree: func(name string, id btrfsprim.ObjID, err error) {
+						dlog.Errorf(ctx, "%v: %v", name, err)
 					},
-					BadItem: func(path btrfstree.Path, item btrfstree.Item) {
-						textui.Fprintf(os.Stdout, "%s = ", path)
-						spew.Dump(item)
-						_, _ = os.Stdout.WriteString("\n")
+					Tree: btrfstree.TreeWalkHandler{
+						Item: func(path btrfstree.Path, item btrfstree.Item) {
+							textui.Fprintf(os.Stdout, "%s = ", path)
+							spew.Dump(item)
+							_, _ = os.Stdout.WriteString("\n")
+						},
+						BadItem: func(path btrfstree.Path, item btrfstree.Item) {
+							textui.Fprintf(os.Stdout, "%s = ", path)
+							spew.Dump(item)
+							_, _ = os.Stdout.WriteString("\n")
+						},
 					},
-				},
-			})
-			return nil
+				})
+				return nil
+			}
+
+			treeID, err := parseTreeID(flags.tree)
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+			search, err := parseSearch(flags.objectID, flags.itemType, flags.offset, flags.offsetRange, flags.name)
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+			tree, err := fs.ForrestLookup(ctx, treeID)
+			if err != nil {
+				return err
+			}
+			handleItem := func(item btrfstree.Item) bool {
+				if search == nil || search.MatchItem(item) {
+					textui.Fprintf(os.Stdout, "tree %v item %v = ", treeID.Format(btrfsprim.ROOT_TREE_OBJECTID), item.Key)
+					spew.Dump(item)
+					_, _ = os.Stdout.WriteString("\n")
+				}
+				return true
+			}
+			if search == nil {
+				return tree.TreeRange(ctx, handleItem)
+			}
+			return tree.TreeSubrange(ctx, 0, search, handleItem)
 		}),
-	})
+	}
+	cmd.Flags().StringVar(&flags.tree, "tree", "",
+		"only spew items from the tree `TREE_ID` (a number, or a name like 'FS_TREE')")
+	cmd.Flags().StringVar(&flags.objectID, "objectid", "",
+		"only spew items with the object ID `OBJID`")
+	cmd.Flags().StringVar(&flags.itemType, "type", "",
+		"only spew items with the item type `TYPE` (a number, or a name like 'INODE_ITEM'); requires --objectid")
+	cmd.Flags().StringVar(&flags.offset, "offset", "",
+		"only spew items with the offset `OFFSET`; requires --type")
+	cmd.Flags().StringVar(&flags.offsetRange, "offset-range", "",
+		"only spew items with an offset in the half-open range `LOW-HIGH`; requires --type")
+	cmd.Flags().StringVar(&flags.name, "name", "",
+		"only spew DIR_ITEM or DIR_INDEX items for the file name `NAME`; requires --type")
+
+	inspectors.AddCommand(cmd)
+}
+
+// parseSearch builds a search from the spew-items flags.  It returns
+// nil if no --objectid is given.
+func parseSearch(objIDStr, itemTypeStr, offsetStr, offsetRangeStr, name string) (*btrfstree.Search, error) {
+	numOffsetFlags := 0
+	for _, str := range []string{offsetStr, offsetRangeStr, name} {
+		if str != "" {
+			numOffsetFlags++
+		}
+	}
+	switch {
+	case numOffsetFlags > 1:
+		return nil, fmt.Errorf("only one of --offset, --offset-range, or --name may be given")
+	case numOffsetFlags > 0 && itemTypeStr == "":
+		return nil, fmt.Errorf("--offset, --offset-range, and --name require --type")
+	case itemTypeStr != "" && objIDStr == "":
+		return nil, fmt.Errorf("--type requires --objectid")
+	case objIDStr == "":
+		return nil, nil //nolint:nilnil // There is no search.
+	}
+
+	objID, err := strconv.ParseUint(objIDStr, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("--objectid: %w", err)
+	}
+	search := btrfstree.SearchObject(btrfsprim.ObjID(objID))
+	if itemTypeStr == "" {
+		return &search, nil
+	}
+
+	search.ItemTypeMatching = btrfstree.ItemTypeExact
+	search.ItemType, err = parseItemType(itemTypeStr)
+	if err != nil {
+		return nil, fmt.Errorf("--type: %w", err)
+	}
+	switch {
+	case offsetStr != "":
+		search.OffsetMatching = btrfstree.OffsetExact
+		search.OffsetLow, err = strconv.ParseUint(offsetStr, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("--offset: %w", err)
+		}
+	case offsetRangeStr != "":
+		lowStr, highStr, ok := strings.Cut(offsetRangeStr, "-")
+		if !ok {
+			return nil, fmt.Errorf("--offset-range: %q is not of the form LOW-HIGH", offsetRangeStr)
+		}
+		search.OffsetMatching = btrfstree.OffsetRange
+		search.OffsetLow, err = strconv.ParseUint(lowStr, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("--offset-range: %w", err)
+		}
+		search.OffsetHigh, err = strconv.ParseUint(highStr, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("--offset-range: %w", err)
+		}
+	case name != "":
+		search.OffsetMatching = btrfstree.OffsetName
+		search.OffsetName = name
+	}
+	return &search, nil
 }
@@ -0,0 +1,58 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/statinode"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "stat SUBVOL_ID INODE",
+		Short: "Print everything known about a single inode",
+		Long: "" +
+			"Print the INODE_ITEM (size, mode, ownership, flags, " +
+			"timestamps...), the xattrs, and either the directory " +
+			"entries or the file extents of inode number INODE in the " +
+			"subvolume SUBVOL_ID (a number, or a name like 'FS_TREE').",
+		Args: cliutil.WrapPositionalArgs(cobra.ExactArgs(2)),
+		RunE: runWithReadableFS(func(fs btrfs.ReadableFS, cmd *cobra.Command, args []string) (err error) {
+			treeID, err := parseTreeID(args[0])
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+			inode, err := strconv.ParseUint(args[1], 0, 64)
+			if err != nil {
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("invalid inode number: %w", err))
+			}
+
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			numBad, err := statinode.StatInode(cmd.Context(), out, fs, treeID, btrfsprim.ObjID(inode), globalFlags.cacheNodes)
+			if err != nil {
+				return err
+			}
+			if numBad > 0 {
+				return fmt.Errorf("inode has %v problems", numBad)
+			}
+			return nil
+		}),
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/superblocks"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "superblocks",
+		Short: "Print and compare each device's superblock mirrors",
+		Long: "" +
+			"For each --pv, print the key fields of the primary superblock " +
+			"and of each mirror (generation, tree root addresses, flags, " +
+			"checksum type, number of devices), marking which copy would " +
+			"be used; then call out each copy that has a bad checksum or " +
+			"that disagrees with the copy that would be used.\n" +
+			"\n" +
+			"Unlike most commands, this does not require that the devices' " +
+			"superblocks be valid.",
+		Args: cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: run(func(cmd *cobra.Command, _ []string) (err error) {
+			ctx := cmd.Context()
+			if len(globalFlags.pvs) == 0 {
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("must specify 1 or more physical volumes with --pv"))
+			}
+
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			total := 0
+			for _, filename := range globalFlags.pvs {
+				dev, _, err := openDevice(ctx, filename)
+				if err != nil {
+					return err
+				}
+				problems, err := superblocks.PrintSuperblocks(out, dev)
+				_ = dev.Close()
+				if err != nil {
+					return err
+				}
+				total += problems
+			}
+			if total > 0 {
+				dlog.Errorf(ctx, "found %v superblock problems", total)
+			}
+			return nil
+		}),
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/cmd/btrfs-rec/inspect/which"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func init() {
+	inspectors.AddCommand(&cobra.Command{
+		Use:   "which {paddr=DEV:OFFSET|laddr=ADDR}",
+		Short: "Report which file is stored at an address",
+		Long: "" +
+			"Given a physical address (such as the location of a bad " +
+			"sector that a disk reported), print the logical address that " +
+			"it maps to (if any), and the subvolume, inode, and path of " +
+			"each file that references the data there.  A logical address " +
+			"may be given instead, to skip the first step.",
+		Args: cliutil.WrapPositionalArgs(cobra.ExactArgs(1)),
+		RunE: runWithRawFS(nil, func(fs *btrfs.FS, cmd *cobra.Command, args []string) (err error) {
+			ctx := cmd.Context()
+
+			out := bufio.NewWriter(os.Stdout)
+			defer func() {
+				if _err := out.Flush(); _err != nil && err == nil {
+					err = _err
+				}
+			}()
+
+			kind, val, _ := strings.Cut(args[0], "=")
+			switch kind {
+			case "paddr":
+				paddr, err := btrfsvol.ParseQualifiedPhysicalAddr(val)
+				if err != nil {
+					return cliutil.FlagErrorFunc(cmd, err)
+				}
+				return which.WhichPhysical(ctx, out, fs, paddr, globalFlags.cacheNodes)
+			case "laddr":
+				laddr, err := strconv.ParseInt(val, 0, 64)
+				if err != nil {
+					return cliutil.FlagErrorFunc(cmd, fmt.Errorf("invalid logical address %q: %w", val, err))
+				}
+				return which.WhichLogical(ctx, out, fs, btrfsvol.LogicalAddr(laddr), globalFlags.cacheNodes)
+			default:
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("invalid address %q: expected paddr=DEV:OFFSET or laddr=ADDR", args[0]))
+			}
+		}),
+	})
+}
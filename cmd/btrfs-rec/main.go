@@ -8,8 +8,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
@@ -18,11 +22,14 @@ import (
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
 	"git.lukeshu.com/btrfs-progs-ng/lib/profile"
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
 	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
 )
 
@@ -51,15 +58,24 @@ var (
 var globalFlags struct {
 	logLevel textui.LogLevelFlag
 	pvs      []string
+	pvDir    string
 
 	mappings  string
 	nodeList  string
 	rebuild   bool
 	treeRoots string
 
+	ioStats          bool
+	skipStaleDevices bool
+	cacheNodes       int
+	timeout          time.Duration
+
 	stopProfiling profile.StopFunc
 
-	openFlag int
+	openFlag         int
+	writableCompatRO btrfstree.CompatROFlags
+	mmap             bool
+	forceCompatRO    bool
 }
 
 func noError(err error) {
@@ -97,6 +113,10 @@ func main() {
 		"open the file `physical_volume` as part of the filesystem")
 	noError(argparser.MarkPersistentFlagFilename("pv"))
 
+	argparser.PersistentFlags().StringVar(&globalFlags.pvDir, "pv-dir", "",
+		"scan the directory `dir` for the other physical volumes of the filesystem given by --pv, and open them too")
+	noError(argparser.MarkPersistentFlagDirname("pv-dir"))
+
 	argparser.PersistentFlags().StringVar(&globalFlags.mappings, "mappings", "",
 		"load chunk/dev-extent/blockgroup data from external JSON file `mappings.json`")
 	noError(argparser.MarkPersistentFlagFilename("mappings"))
@@ -112,10 +132,29 @@ func main() {
 		"load list of tree roots (output of 'btrfs-recs inspect rebuild-trees') from external JSON file `trees.json`; implies --rebuild")
 	noError(argparser.MarkPersistentFlagFilename("trees"))
 
+	argparser.PersistentFlags().BoolVar(&globalFlags.ioStats, "io-stats", false,
+		"print per-device I/O statistics and node-cache statistics at the end of the run")
+
+	argparser.PersistentFlags().BoolVar(&globalFlags.skipStaleDevices, "skip-stale-devices", false,
+		"if the --pv devices have superblocks from different generations, only use the devices with the newest generation")
+
+	argparser.PersistentFlags().IntVar(&globalFlags.cacheNodes, "cache-nodes", btrfs.DefaultNodeCacheSize,
+		"keep up to `N` btree nodes cached in memory; each costs at least the filesystem's node size (typically 16KiB); "+
+			"commands that read files also cache up to N inodes, directories, and files per subvolume")
+
+	argparser.PersistentFlags().DurationVar(&globalFlags.timeout, "timeout", 0,
+		"stop after `duration` (e.g. '2h'), writing out whatever partial results have been found, and exit with status 124; 0 for no limit")
+
 	globalFlags.stopProfiling = profile.AddProfileFlags(argparser.PersistentFlags(), "profile.")
 
 	globalFlags.openFlag = os.O_RDONLY
 
+	inspectors.PersistentFlags().BoolVar(&globalFlags.mmap, "mmap", false,
+		"map the device files in to memory rather than reading (and buffering) them with syscalls; the files must fit in the address space")
+
+	repairers.PersistentFlags().BoolVar(&globalFlags.forceCompatRO, "force-compat-ro", false,
+		"write to the filesystem even if it has compat_ro features (such as the free space tree) that are not maintained by the command (this may corrupt it)")
+
 	// Sub-commands
 
 	argparser.AddCommand(inspectors)
@@ -125,13 +164,49 @@ func main() {
 
 	if err := argparser.ExecuteContext(context.Background()); err != nil {
 		textui.Fprintf(os.Stderr, "%v: error: %v\n", argparser.CommandPath(), err)
+		var timeoutErr *timeoutError
+		if errors.As(err, &timeoutErr) {
+			os.Exit(exitTimeout)
+		}
 		os.Exit(1)
 	}
 }
 
+// exitTimeout is the exit status when --timeout is hit; it is the
+// same as timeout(1) uses.
+const exitTimeout = 124
+
+// timeoutError is returned by commands that were stopped by
+// --timeout, whether or not the command itself returned an error.
+type timeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *timeoutError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("timed out after %v", e.Timeout)
+	}
+	return fmt.Sprintf("timed out after %v: %v", e.Timeout, e.Err)
+}
+
+func (e *timeoutError) Unwrap() error { return e.Err }
+
 func run(runE func(*cobra.Command, []string) error) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
+		if globalFlags.cacheNodes <= 0 {
+			return cliutil.FlagErrorFunc(cmd, fmt.Errorf("--cache-nodes must be positive, got %v", globalFlags.cacheNodes))
+		}
+		if globalFlags.timeout < 0 {
+			return cliutil.FlagErrorFunc(cmd, fmt.Errorf("--timeout must not be negative, got %v", globalFlags.timeout))
+		}
+
 		ctx := cmd.Context()
+		if globalFlags.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, globalFlags.timeout)
+			defer cancel()
+		}
 		logger := textui.NewLogger(os.Stderr, globalFlags.logLevel.Level)
 		ctx = dlog.WithLogger(ctx, logger)
 		if globalFlags.logLevel.Level >= dlog.LogLevelDebug {
@@ -155,7 +230,18 @@ func run(runE func(*cobra.Command, []string) error) func(*cobra.Command, []strin
 			cmd.SetContext(ctx)
 			return runE(cmd, args)
 		})
-		return grp.Wait()
+		err := grp.Wait()
+		// The scans and rebuilds stop at the next loop boundary
+		// once the Context is done, and (like with a SIGINT)
+		// write out what they have so far; so all that's left
+		// to do is make sure that the exit status reflects it.
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &timeoutError{
+				Timeout: globalFlags.timeout,
+				Err:     err,
+			}
+		}
+		return err
 	}
 }
 
@@ -177,32 +263,84 @@ func runWithRawFS(
 			// it doesn't interfere with the `help` sub-command.
 			return cliutil.FlagErrorFunc(cmd, fmt.Errorf("must specify 1 or more physical volumes with --pv"))
 		}
-		fs := new(btrfs.FS)
+		pvs := globalFlags.pvs
+		statsFiles := make(map[string]*diskio.StatsFile[btrfsvol.PhysicalAddr])
+		defer func() {
+			for _, filename := range pvs {
+				statsFile, ok := statsFiles[filename]
+				if !ok {
+					continue
+				}
+				logIOStats(ctx, filename, statsFile)
+			}
+		}()
+		fs := &btrfs.FS{
+			NodeCacheSize: globalFlags.cacheNodes,
+		}
 		defer func() {
 			maybeSetErr(fs.Close())
 		}()
-		for i, filename := range globalFlags.pvs {
-			dlog.Debugf(ctx, "Adding device file %d/%d %q...", i, len(globalFlags.pvs), filename)
-			osFile, err := os.OpenFile(filename, globalFlags.openFlag, 0)
+		if globalFlags.ioStats {
+			defer logNodeCacheStats(ctx, fs)
+		}
+		// devFiles are the devices that have been opened but not
+		// (yet) handed to fs; once they are, fs.Close() closes
+		// them, but until then it is up to us.
+		devFiles := make([]*btrfs.Device, 0, len(pvs))
+		defer func() {
+			for _, devFile := range devFiles {
+				if _err := devFile.Close(); _err != nil {
+					maybeSetErr(fmt.Errorf("device file %q: %w", devFile.Name(), _err))
+				}
+			}
+		}()
+		openDevices := func(filenames []string) error {
+			for i, filename := range filenames {
+				dlog.Debugf(ctx, "Opening device file %d/%d %q...", i, len(filenames), filename)
+				devFile, statsFile, err := openDevice(ctx, filename)
+				if err != nil {
+					return err
+				}
+				if statsFile != nil {
+					statsFiles[filename] = statsFile
+				}
+				devFiles = append(devFiles, devFile)
+			}
+			return nil
+		}
+		if err := openDevices(pvs); err != nil {
+			return err
+		}
+		if globalFlags.pvDir != "" {
+			siblings, err := findSiblingDevices(ctx, globalFlags.pvDir, devFiles)
 			if err != nil {
-				return fmt.Errorf("device file %q: %w", filename, err)
+				return err
 			}
-			typedFile := &diskio.OSFile[btrfsvol.PhysicalAddr]{
-				File: osFile,
+			pvs = append(pvs[:len(pvs):len(pvs)], siblings...)
+			if err := openDevices(siblings); err != nil {
+				return err
 			}
-			bufFile := diskio.NewBufferedFile[btrfsvol.PhysicalAddr](
-				ctx,
-				typedFile,
-				//nolint:gomnd // False positive: gomnd.ignored-functions=[textui.Tunable] doesn't support type params.
-				textui.Tunable[btrfsvol.PhysicalAddr](16*1024), // block size: 16KiB
-				textui.Tunable(1024),                           // number of blocks to buffer; total of 16MiB
-			)
-			devFile := &btrfs.Device{
-				File: bufFile,
+		}
+		useDevs, err := checkDeviceGenerations(ctx, devFiles)
+		if err != nil {
+			return err
+		}
+		for i := 0; len(devFiles) > 0; {
+			devFile := devFiles[0]
+			if !slices.Contains(devFile, useDevs) {
+				dlog.Infof(ctx, "skipping stale device file %q", devFile.Name())
+				devFiles = devFiles[1:]
+				if err := devFile.Close(); err != nil {
+					return fmt.Errorf("device file %q: %w", devFile.Name(), err)
+				}
+				continue
 			}
+			dlog.Debugf(ctx, "Adding device file %d/%d %q...", i, len(useDevs), devFile.Name())
 			if err := fs.AddDevice(ctx, devFile); err != nil {
-				return fmt.Errorf("device file %q: %w", filename, err)
+				return fmt.Errorf("device file %q: %w", devFile.Name(), err)
 			}
+			devFiles = devFiles[1:]
+			i++
 		}
 		if overrideInitChunks != nil {
 			if err := overrideInitChunks(fs, cmd, args); err != nil {
@@ -215,21 +353,247 @@ func runWithRawFS(
 		}
 
 		if globalFlags.mappings != "" {
-			mappingsJSON, err := readJSONFile[[]btrfsvol.Mapping](ctx, globalFlags.mappings)
-			if err != nil {
+			if err := decodeJSONFile(ctx, globalFlags.mappings, func(r io.RuneScanner) error {
+				return btrfsvol.DecodeMappings(r, fs.LV.AddMapping)
+			}); err != nil {
 				return err
 			}
-			for _, mapping := range mappingsJSON {
-				if err := fs.LV.AddMapping(mapping); err != nil {
-					return err
-				}
-			}
 		}
 
 		return runE(fs, cmd, args)
 	})
 }
 
+// openDevice opens the file `filename` as a btrfs.Device, according
+// to the global flags.  If --io-stats is set, then the
+// diskio.StatsFile that is counting the device's I/O is also
+// returned.
+func openDevice(ctx context.Context, filename string) (*btrfs.Device, *diskio.StatsFile[btrfsvol.PhysicalAddr], error) {
+	var typedFile diskio.File[btrfsvol.PhysicalAddr]
+	if globalFlags.mmap {
+		// --mmap is only a flag for inspectors, which open
+		// read-only; and reads from a mapping are already
+		// cheap, so there's no buffering.
+		mmapFile, err := diskio.NewMmapFile[btrfsvol.PhysicalAddr](filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("device file %q: %w", filename, err)
+		}
+		typedFile = mmapFile
+	} else {
+		osFile, err := os.OpenFile(filename, globalFlags.openFlag, 0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("device file %q: %w", filename, err)
+		}
+		typedFile = &diskio.OSFile[btrfsvol.PhysicalAddr]{
+			File: osFile,
+		}
+	}
+	var statsFile *diskio.StatsFile[btrfsvol.PhysicalAddr]
+	if globalFlags.ioStats {
+		statsFile = diskio.NewStatsFile(typedFile)
+		typedFile = statsFile
+	}
+	if !globalFlags.mmap {
+		typedFile = diskio.NewBufferedFile[btrfsvol.PhysicalAddr](
+			ctx,
+			typedFile,
+			//nolint:gomnd // False positive: gomnd.ignored-functions=[textui.Tunable] doesn't support type params.
+			textui.Tunable[btrfsvol.PhysicalAddr](16*1024), // block size: 16KiB
+			textui.Tunable(1024),                           // number of blocks to buffer; total of 16MiB
+		)
+	}
+	dev := &btrfs.Device{File: typedFile}
+	if globalFlags.openFlag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err := checkCompatROFlags(ctx, dev, globalFlags.writableCompatRO, globalFlags.forceCompatRO); err != nil {
+			_ = dev.Close()
+			return nil, nil, err
+		}
+	}
+	return dev, statsFile, nil
+}
+
+// logIOStats logs the I/O statistics that --io-stats collected for
+// the device file `filename`.
+func logIOStats(ctx context.Context, filename string, statsFile *diskio.StatsFile[btrfsvol.PhysicalAddr]) {
+	stats := statsFile.Stats()
+	dlog.Infof(ctx, "I/O stats for %q: read %v in %v calls taking %v; wrote %v in %v calls taking %v",
+		filename,
+		textui.IEC(stats.ReadBytes, "B"), stats.ReadCalls, stats.ReadTime,
+		textui.IEC(stats.WriteBytes, "B"), stats.WriteCalls, stats.WriteTime)
+}
+
+// logNodeCacheStats logs the node cache statistics that --io-stats
+// collected for `fs`.
+func logNodeCacheStats(ctx context.Context, fs *btrfs.FS) {
+	stats := fs.NodeCacheStats()
+	dlog.Infof(ctx, "node cache stats: %v hits, %v misses, %v evictions, %v nodes currently cached",
+		stats.Hits, stats.Misses, stats.Evictions, stats.Size)
+}
+
+// checkCompatROFlags refuses to let a device be opened for writing if
+// its superblocks have compat_ro flags for features that the command
+// doesn't maintain (`writable` is the set that it does; unknown flags
+// are never writable); only implementations that support all of a
+// filesystem's compat_ro features may write to it.  If `force` is set
+// (--force-compat-ro), it just logs an error instead of refusing.
+func checkCompatROFlags(ctx context.Context, dev *btrfs.Device, writable btrfstree.CompatROFlags, force bool) error {
+	sbs, err := dev.Superblocks()
+	if err != nil {
+		return fmt.Errorf("device file %q: %w", dev.Name(), err)
+	}
+	var unwritable btrfstree.CompatROFlags
+	for _, sb := range sbs {
+		unwritable |= sb.Data.CompatROFlags.Unwritable(writable)
+	}
+	if unwritable == 0 {
+		return nil
+	}
+	if force {
+		dlog.Errorf(ctx, "device file %q: superblock has compat_ro flags %v that are not supported for writing; writing anyway because of --force-compat-ro",
+			dev.Name(), unwritable)
+		return nil
+	}
+	return fmt.Errorf("device file %q: superblock has compat_ro flags %v that are not supported for writing; refusing to open it for writing (override with --force-compat-ro)",
+		dev.Name(), unwritable)
+}
+
+// superblockWriterPreRunE is the PreRunE for repair commands that
+// only write to the devices with rewriteSuperblocks.  Rewriting the
+// superblocks doesn't touch any of the structures that compat_ro
+// features add, so such commands may write to filesystems with any
+// known compat_ro flags set (see checkCompatROFlags); commands that
+// write anything else keep the default of none.
+func superblockWriterPreRunE(_ *cobra.Command, _ []string) error {
+	globalFlags.writableCompatRO = btrfstree.CompatROFlagsKnown
+	return nil
+}
+
+// checkDeviceGenerations checks that all of the devices have
+// superblocks from the same generation; a device with an older
+// generation (e.g. because a write to it failed) would give a torn
+// view of the filesystem.  If they disagree, each device's generation
+// is logged, and if --skip-stale-devices is set then the devices with
+// older generations are dropped from the returned list (it is up to
+// the caller to close them).
+func checkDeviceGenerations(ctx context.Context, devs []*btrfs.Device) ([]*btrfs.Device, error) {
+	gens := make([]btrfsprim.Generation, len(devs))
+	var newest btrfsprim.Generation
+	for i, dev := range devs {
+		sb, err := dev.Superblock()
+		if err != nil {
+			return nil, fmt.Errorf("device file %q: %w", dev.Name(), err)
+		}
+		gens[i] = sb.Generation
+		if gens[i] > newest {
+			newest = gens[i]
+		}
+	}
+	consistent := true
+	for _, gen := range gens {
+		if gen != newest {
+			consistent = false
+		}
+	}
+	if consistent {
+		return devs, nil
+	}
+
+	for i, dev := range devs {
+		dlog.Errorf(ctx, "device file %q: superblock generation %v (newest is %v)", dev.Name(), gens[i], newest)
+	}
+	if !globalFlags.skipStaleDevices {
+		dlog.Error(ctx, "error: devices have superblocks from different generations; "+
+			"the assembled filesystem may be inconsistent (use --skip-stale-devices to only use the newest devices)")
+		return devs, nil
+	}
+	ret := make([]*btrfs.Device, 0, len(devs))
+	for i, dev := range devs {
+		if gens[i] == newest {
+			ret = append(ret, dev)
+		}
+	}
+	return ret, nil
+}
+
+// findSiblingDevices looks in the directory `dir` for the devices of
+// the filesystem that `devs` are members of, other than `devs`
+// themselves; it returns the filenames of the devices that it finds.
+// A file is a sibling if it has a readable superblock with the same
+// FSUUID as `devs[0]` and a DevID that isn't already taken.  Files
+// that aren't siblings are skipped with a debug message.
+//
+// If the filesystem would still be missing devices, an error is
+// logged (but not returned), so that the user can still try to read
+// whatever is on the devices that are present.
+func findSiblingDevices(ctx context.Context, dir string, devs []*btrfs.Device) ([]string, error) {
+	if len(devs) == 0 {
+		return nil, fmt.Errorf("--pv-dir: must specify at least 1 physical volume with --pv")
+	}
+	sb, err := devs[0].Superblock()
+	if err != nil {
+		return nil, fmt.Errorf("device file %q: %w", devs[0].Name(), err)
+	}
+	haveDevIDs := make(containers.Set[btrfsvol.DeviceID], len(devs))
+	for _, dev := range devs {
+		devSB, err := dev.Superblock()
+		if err != nil {
+			return nil, fmt.Errorf("device file %q: %w", dev.Name(), err)
+		}
+		if devSB.FSUUID != sb.FSUUID {
+			return nil, fmt.Errorf("--pv-dir: device files %q and %q are from different filesystems (fs_uuid=%v and fs_uuid=%v)",
+				devs[0].Name(), dev.Name(), sb.FSUUID, devSB.FSUUID)
+		}
+		haveDevIDs.Insert(devSB.DevItem.DevID)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("--pv-dir: %w", err)
+	}
+	var ret []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		devSB, err := peekSuperblock(filename)
+		switch {
+		case err != nil:
+			dlog.Debugf(ctx, "--pv-dir: skipping %q: %v", filename, err)
+		case devSB.FSUUID != sb.FSUUID:
+			dlog.Debugf(ctx, "--pv-dir: skipping %q: fs_uuid=%v is a different filesystem", filename, devSB.FSUUID)
+		case haveDevIDs.Has(devSB.DevItem.DevID):
+			dlog.Debugf(ctx, "--pv-dir: skipping %q: devid=%v has already been found", filename, devSB.DevItem.DevID)
+		default:
+			dlog.Infof(ctx, "--pv-dir: found devid=%v: %q", devSB.DevItem.DevID, filename)
+			haveDevIDs.Insert(devSB.DevItem.DevID)
+			ret = append(ret, filename)
+		}
+	}
+
+	if uint64(len(haveDevIDs)) < sb.NumDevices {
+		dlog.Errorf(ctx, "error: fs_uuid=%v has %v devices, but only found %v (devids %v); the filesystem will be missing data",
+			sb.FSUUID, sb.NumDevices, len(haveDevIDs), maps.SortedKeys(haveDevIDs))
+	}
+	return ret, nil
+}
+
+// peekSuperblock reads the superblock of the file `filename`,
+// without going through the buffering or I/O-stats of openDevice.
+func peekSuperblock(filename string) (*btrfstree.Superblock, error) {
+	osFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	dev := &btrfs.Device{
+		File: &diskio.OSFile[btrfsvol.PhysicalAddr]{
+			File: osFile,
+		},
+	}
+	defer func() { _ = dev.Close() }()
+	return dev.Superblock()
+}
+
 func runWithRawFSAndNodeList(runE func(*btrfs.FS, []btrfsvol.LogicalAddr, *cobra.Command, []string) error) func(*cobra.Command, []string) error {
 	return runWithRawFS(nil, func(fs *btrfs.FS, cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
@@ -0,0 +1,151 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestFindSiblingDevices(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	uuidA := btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90")
+	uuidB := btrfsprim.MustParseUUID("0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+	mkImg := func(fsUUID btrfsprim.UUID, devID btrfsvol.DeviceID) []byte {
+		sb := btrfstree.Superblock{
+			FSUUID:       fsUUID,
+			Self:         btrfs.SuperblockAddrs[0],
+			NumDevices:   4,
+			SectorSize:   btrfssum.BlockSize,
+			NodeSize:     btrfssum.BlockSize,
+			ChecksumType: btrfssum.TYPE_CRC32,
+		}
+		sb.DevItem.DevID = devID
+		copy(sb.Magic[:], "_BHRfS_M")
+		var err error
+		sb.Checksum, err = sb.CalculateChecksum()
+		require.NoError(t, err)
+		sbDat, err := binstruct.Marshal(sb)
+		require.NoError(t, err)
+		img := make([]byte, btrfs.SuperblockAddrs[0]+btrfs.SuperblockSize)
+		copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+		return img
+	}
+	mkDev := func(fsUUID btrfsprim.UUID, devID btrfsvol.DeviceID) *btrfs.Device {
+		return &btrfs.Device{File: diskio.NewMemFile[btrfsvol.PhysicalAddr]("pv", mkImg(fsUUID, devID))}
+	}
+
+	dir := t.TempDir()
+	for name, dat := range map[string][]byte{
+		"dev1-again": mkImg(uuidA, 1), // same devid as the --pv
+		"dev2":       mkImg(uuidA, 2),
+		"dev3":       mkImg(uuidA, 3),
+		"dev3-again": mkImg(uuidA, 3), // same devid as "dev3"
+		"other-fs":   mkImg(uuidB, 4),
+		"garbage":    []byte("not a btrfs device"),
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), dat, 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0o700))
+
+	// os.ReadDir sorts the entries, so "dev3" is found before
+	// "dev3-again".
+	siblings, err := findSiblingDevices(ctx, dir, []*btrfs.Device{mkDev(uuidA, 1)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "dev2"),
+		filepath.Join(dir, "dev3"),
+	}, siblings)
+
+	siblings, err = findSiblingDevices(ctx, dir, []*btrfs.Device{mkDev(uuidA, 1), mkDev(uuidA, 2)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "dev3"),
+	}, siblings)
+
+	_, err = findSiblingDevices(ctx, dir, []*btrfs.Device{mkDev(uuidA, 1), mkDev(uuidB, 2)})
+	assert.ErrorContains(t, err, "different filesystems")
+
+	_, err = findSiblingDevices(ctx, dir, nil)
+	assert.Error(t, err)
+
+	_, err = findSiblingDevices(ctx, filepath.Join(dir, "nonexistent"), []*btrfs.Device{mkDev(uuidA, 1)})
+	assert.Error(t, err)
+}
+
+func TestCheckCompatROFlags(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	mkDev := func(flags btrfstree.CompatROFlags) *btrfs.Device {
+		sb := btrfstree.Superblock{
+			Self:          btrfs.SuperblockAddrs[0],
+			SectorSize:    btrfssum.BlockSize,
+			NodeSize:      btrfssum.BlockSize,
+			ChecksumType:  btrfssum.TYPE_CRC32,
+			CompatROFlags: flags,
+		}
+		copy(sb.Magic[:], "_BHRfS_M")
+		var err error
+		sb.Checksum, err = sb.CalculateChecksum()
+		require.NoError(t, err)
+		sbDat, err := binstruct.Marshal(sb)
+		require.NoError(t, err)
+		img := make([]byte, btrfs.SuperblockAddrs[0]+btrfs.SuperblockSize)
+		copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+		return &btrfs.Device{File: diskio.NewMemFile[btrfsvol.PhysicalAddr]("pv", img)}
+	}
+
+	type TestCase struct {
+		Flags    btrfstree.CompatROFlags
+		Writable btrfstree.CompatROFlags
+		Force    bool
+		ExpErr   string
+	}
+	testcases := map[string]TestCase{
+		"none":                        {Flags: 0},
+		"none-force":                  {Flags: 0, Force: true},
+		"free-space-tree":             {Flags: btrfstree.FeatureCompatROFreeSpaceTree | btrfstree.FeatureCompatROFreeSpaceTreeValid, ExpErr: "refusing to open it for writing"},
+		"verity":                      {Flags: btrfstree.FeatureCompatROVerity, ExpErr: "refusing to open it for writing"},
+		"block-group-tree":            {Flags: btrfstree.FeatureCompatROBlockGroupTree, ExpErr: "refusing to open it for writing"},
+		"unknown":                     {Flags: 1 << 10, ExpErr: "refusing to open it for writing"},
+		"unknown-force":               {Flags: 1 << 10, Force: true},
+		"superblock-free-space-tree":  {Flags: btrfstree.FeatureCompatROFreeSpaceTree | btrfstree.FeatureCompatROFreeSpaceTreeValid, Writable: btrfstree.CompatROFlagsKnown},
+		"superblock-block-group-tree": {Flags: btrfstree.FeatureCompatROBlockGroupTree, Writable: btrfstree.CompatROFlagsKnown},
+		"superblock-unknown":          {Flags: btrfstree.FeatureCompatROFreeSpaceTree | 1<<10, Writable: btrfstree.CompatROFlagsKnown, ExpErr: "compat_ro flags 0x400((1<<10)) that"},
+		"partial":                     {Flags: btrfstree.FeatureCompatROFreeSpaceTree | btrfstree.FeatureCompatROVerity, Writable: btrfstree.FeatureCompatROFreeSpaceTree, ExpErr: "FeatureCompatROVerity"},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			err := checkCompatROFlags(ctx, mkDev(tc.Flags), tc.Writable, tc.Force)
+			if tc.ExpErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.ExpErr)
+			}
+		})
+	}
+
+	// Superblocks that can't be read are an error, not a pass.
+	dev := &btrfs.Device{File: diskio.NewMemFile[btrfsvol.PhysicalAddr]("pv", nil)}
+	assert.Error(t, checkCompatROFlags(ctx, dev, btrfstree.CompatROFlagsKnown, false))
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func init() {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "fix-superblock-csum",
+		Short: "Recompute and rewrite the superblock checksums",
+		Long: "" +
+			"Recompute the checksum of every superblock mirror on each " +
+			"--pv (using the checksum type named in that superblock), " +
+			"and rewrite any mirror whose stored checksum does not match.\n" +
+			"\n" +
+			"This is only the right thing to do if the rest of the " +
+			"superblock is known to be good (e.g. after editing it by " +
+			"hand); it makes no attempt to validate any other fields.",
+		PreRunE: superblockWriterPreRunE,
+		Args:    cliutil.WrapPositionalArgs(cobra.NoArgs),
+		RunE: run(func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			if len(globalFlags.pvs) == 0 {
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("must specify 1 or more physical volumes with --pv"))
+			}
+			var total fixSuperblockCSumsStats
+			for _, filename := range globalFlags.pvs {
+				stats, err := fixSuperblockCSums(ctx, filename, dryRun)
+				total.Checked += stats.Checked
+				total.Bad += stats.Bad
+				if err != nil {
+					return err
+				}
+			}
+			if dryRun {
+				dlog.Infof(ctx, "would rewrite %v of %v superblocks", total.Bad, total.Checked)
+			} else {
+				dlog.Infof(ctx, "rewrote %v of %v superblocks", total.Bad, total.Checked)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"report which superblocks would be rewritten, but don't write anything")
+
+	repairers.AddCommand(cmd)
+}
+
+type fixSuperblockCSumsStats struct {
+	Checked int // number of superblocks looked at
+	Bad     int // number of those with bad checksums
+}
+
+func fixSuperblockCSums(ctx context.Context, filename string, dryRun bool) (fixSuperblockCSumsStats, error) {
+	var stats fixSuperblockCSumsStats
+	var sb0 btrfstree.Superblock
+	err := rewriteSuperblocks(ctx, filename, func(i int, sb *diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock]) (bool, error) {
+		stats.Checked++
+		calced, err := sb.Data.CalculateChecksum()
+		if err != nil {
+			return false, err
+		}
+		if i == 0 {
+			sb0 = sb.Data
+		} else if !sb.Data.Equal(sb0) {
+			dlog.Errorf(ctx, "device file %q: superblock %v and superblock %v disagree on more than just the checksum",
+				filename, 0, i)
+		}
+		if calced == sb.Data.Checksum {
+			dlog.Infof(ctx, "device file %q: superblock %v at %v: checksum OK", filename, i, sb.Addr)
+			return false, nil
+		}
+		stats.Bad++
+		if dryRun {
+			dlog.Infof(ctx, "device file %q: superblock %v at %v: would rewrite checksum %v => %v",
+				filename, i, sb.Addr, sb.Data.Checksum.Fmt(sb.Data.ChecksumType), calced.Fmt(sb.Data.ChecksumType))
+			return false, nil
+		}
+		dlog.Infof(ctx, "device file %q: superblock %v at %v: rewriting checksum %v => %v",
+			filename, i, sb.Addr, sb.Data.Checksum.Fmt(sb.Data.ChecksumType), calced.Fmt(sb.Data.ChecksumType))
+		return true, nil
+	})
+	return stats, err
+}
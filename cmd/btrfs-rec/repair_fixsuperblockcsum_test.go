@@ -0,0 +1,53 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+func TestFixSuperblockCSumsDryRun(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Self:         btrfs.SuperblockAddrs[0],
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     btrfssum.BlockSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	copy(sb.Magic[:], "_BHRfS_M")
+	sb.Checksum = btrfssum.CSum{0xde, 0xad, 0xbe, 0xef} // wrong
+	sbDat, err := binstruct.Marshal(sb)
+	require.NoError(t, err)
+	img := make([]byte, 1024*1024)
+	copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+	filename := filepath.Join(t.TempDir(), "img")
+	require.NoError(t, os.WriteFile(filename, img, 0o600))
+
+	// The global --pv open flags are read-only (the "repair"
+	// command is what switches them to read-write), so if a dry
+	// run tried to write anything, it would fail.
+	stats, err := fixSuperblockCSums(ctx, filename, true)
+	require.NoError(t, err)
+	assert.Equal(t, fixSuperblockCSumsStats{Checked: 1, Bad: 1}, stats)
+
+	act, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, img, act)
+}
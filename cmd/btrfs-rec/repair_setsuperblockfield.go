@@ -0,0 +1,162 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/ocibuild/pkg/cliutil"
+	"github.com/spf13/cobra"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func init() {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "set-superblock-field FIELD VALUE",
+		Short: "Set a single field in the superblocks",
+		Long: "" +
+			"Set FIELD to VALUE in every superblock mirror on each --pv, " +
+			"re-calculate the checksums, and write the mirrors back.\n" +
+			"\n" +
+			"FIELD is the name of a field of the superblock struct " +
+			"(case-insensitive), with nested fields separated by '.' " +
+			"(e.g. 'ChunkTree' or 'DevItem.DevID').  VALUE is parsed " +
+			"according to the type of the field: integers may be given " +
+			"in any base that Go understands (e.g. '0x' for hex), UUIDs " +
+			"in the usual dashed form, and byte arrays (e.g. 'Label') as " +
+			"strings.\n" +
+			"\n" +
+			"The old and new values are always printed; nothing is " +
+			"written unless --force is given.",
+		PreRunE: superblockWriterPreRunE,
+		Args:    cliutil.WrapPositionalArgs(cobra.ExactArgs(2)),
+		RunE: run(func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			if len(globalFlags.pvs) == 0 {
+				return cliutil.FlagErrorFunc(cmd, fmt.Errorf("must specify 1 or more physical volumes with --pv"))
+			}
+			fieldPath := args[0]
+			if _, err := lookupBinField(reflect.ValueOf(new(btrfstree.Superblock)).Elem(), fieldPath); err != nil {
+				return cliutil.FlagErrorFunc(cmd, err)
+			}
+			for _, filename := range globalFlags.pvs {
+				if err := setSuperblockField(ctx, filename, fieldPath, args[1], force); err != nil {
+					return err
+				}
+			}
+			if !force {
+				return fmt.Errorf("refusing to write superblocks without --force")
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&force, "force", false,
+		"actually write the modified superblocks")
+
+	repairers.AddCommand(cmd)
+}
+
+func setSuperblockField(ctx context.Context, filename, fieldPath, value string, force bool) error {
+	return rewriteSuperblocks(ctx, filename, func(i int, sb *diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock]) (bool, error) {
+		if err := sb.Data.ValidateChecksum(); err != nil {
+			dlog.Errorf(ctx, "device file %q: superblock %v at %v: %v (it will be re-calculated)",
+				filename, i, sb.Addr, err)
+		}
+		field, err := lookupBinField(reflect.ValueOf(&sb.Data).Elem(), fieldPath)
+		if err != nil {
+			return false, err
+		}
+		before := fmtBinField(field)
+		if err := parseBinField(field, value); err != nil {
+			return false, fmt.Errorf("field %s: %w", fieldPath, err)
+		}
+		after := fmtBinField(field)
+		dlog.Infof(ctx, "device file %q: superblock %v at %v: %s: %s => %s",
+			filename, i, sb.Addr, fieldPath, before, after)
+		return force, nil
+	})
+}
+
+// lookupBinField returns the (settable) field of the binstruct struct
+// `val` named by the '.'-separated, case-insensitive `path`.
+func lookupBinField(val reflect.Value, path string) (reflect.Value, error) {
+	for _, name := range strings.Split(path, ".") {
+		if val.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("field %q: %v is not a struct", path, val.Type())
+		}
+		found := false
+		for _, field := range binstruct.StructFields(val.Type()) {
+			if strings.EqualFold(field.Name, name) {
+				val = val.Field(field.Index)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("field %q: %v has no field %q", path, val.Type(), name)
+		}
+	}
+	if val.Type() == reflect.TypeOf(btrfstree.Superblock{}.Checksum) {
+		return reflect.Value{}, fmt.Errorf("field %q: checksums are re-calculated automatically; see 'fix-superblock-csum'", path)
+	}
+	return val, nil
+}
+
+// parseBinField parses `str` according to the type of `field`, and
+// stores the result in `field`.
+func parseBinField(field reflect.Value, str string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(str))
+	}
+	switch field.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(str, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(str, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Array:
+		if field.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %v", field.Type())
+		}
+		if len(str) > field.Len() {
+			return fmt.Errorf("value is %v bytes long, but the field only holds %v bytes", len(str), field.Len())
+		}
+		field.Set(reflect.Zero(field.Type()))
+		reflect.Copy(field, reflect.ValueOf([]byte(str)))
+	default:
+		return fmt.Errorf("unsupported type %v", field.Type())
+	}
+	return nil
+}
+
+func fmtBinField(field reflect.Value) string {
+	// Print plain byte arrays (like the label) as strings, rather
+	// than as a list of numbers.
+	if field.Kind() == reflect.Array && field.Type().Elem().Kind() == reflect.Uint8 && field.Type().NumMethod() == 0 {
+		dat := make([]byte, field.Len())
+		reflect.Copy(reflect.ValueOf(dat), field)
+		return strconv.Quote(string(bytes.TrimRight(dat, "\x00")))
+	}
+	return fmt.Sprintf("%v", field.Interface())
+}
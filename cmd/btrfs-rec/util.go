@@ -7,35 +7,50 @@ package main
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 
 	"git.lukeshu.com/go/lowmemjson"
 	"github.com/datawire/dlib/dlog"
 
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
 	"git.lukeshu.com/btrfs-progs-ng/lib/streamio"
 )
 
 func readJSONFile[T any](ctx context.Context, filename string) (T, error) {
-	fh, err := os.Open(filename)
-	if err != nil {
+	var ret T
+	if err := decodeJSONFile(ctx, filename, func(r io.RuneScanner) error {
+		return lowmemjson.NewDecoder(r).DecodeThenEOF(&ret)
+	}); err != nil {
 		var zero T
 		return zero, err
 	}
+	return ret, nil
+}
+
+// decodeJSONFile is like readJSONFile, but rather than decoding the
+// file in to a value, it passes the opened file to `decode`; this is
+// for callers that can consume the JSON incrementally.
+func decodeJSONFile(ctx context.Context, filename string, decode func(io.RuneScanner) error) error {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
 	buf, err := streamio.NewRuneScanner(dlog.WithField(ctx, "btrfs.read-json-file", filename), fh)
 	defer func() {
 		_ = buf.Close()
 	}()
 	if err != nil {
-		var zero T
-		return zero, err
+		return err
 	}
-	var ret T
-	if err := lowmemjson.NewDecoder(buf).DecodeThenEOF(&ret); err != nil {
-		var zero T
-		return zero, err
-	}
-	return ret, nil
+	return decode(buf)
 }
 
 func writeJSONFile(w io.Writer, obj any, cfg lowmemjson.ReEncoderConfig) (err error) {
@@ -47,3 +62,128 @@ func writeJSONFile(w io.Writer, obj any, cfg lowmemjson.ReEncoderConfig) (err er
 	}()
 	return lowmemjson.NewEncoder(lowmemjson.NewReEncoder(buffer, cfg)).Encode(obj)
 }
+
+// wellKnownTrees is the list of trees that parseTreeID accepts by
+// name.
+var wellKnownTrees = []btrfsprim.ObjID{
+	btrfsprim.ROOT_TREE_OBJECTID,
+	btrfsprim.EXTENT_TREE_OBJECTID,
+	btrfsprim.CHUNK_TREE_OBJECTID,
+	btrfsprim.DEV_TREE_OBJECTID,
+	btrfsprim.FS_TREE_OBJECTID,
+	btrfsprim.CSUM_TREE_OBJECTID,
+	btrfsprim.QUOTA_TREE_OBJECTID,
+	btrfsprim.UUID_TREE_OBJECTID,
+	btrfsprim.FREE_SPACE_TREE_OBJECTID,
+	btrfsprim.BLOCK_GROUP_TREE_OBJECTID,
+	btrfsprim.TREE_LOG_OBJECTID,
+	btrfsprim.DATA_RELOC_TREE_OBJECTID,
+}
+
+// parseTreeID parses a tree ID given on the command line, which may
+// either be a number or the name of a well-known tree (e.g. "FS_TREE"
+// or just "FS"; case-insensitive).
+func parseTreeID(str string) (btrfsprim.ObjID, error) {
+	if n, err := strconv.ParseInt(str, 0, 64); err == nil {
+		return btrfsprim.ObjID(n), nil
+	}
+	if n, err := strconv.ParseUint(str, 0, 64); err == nil {
+		return btrfsprim.ObjID(n), nil
+	}
+	for _, treeID := range wellKnownTrees {
+		name := treeID.Format(btrfsprim.ROOT_TREE_OBJECTID)
+		if strings.EqualFold(str, name) || strings.EqualFold(str, strings.TrimSuffix(name, "_TREE")) {
+			return treeID, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid tree ID: %q", str)
+}
+
+// parseItemType parses an item type given on the command line, which
+// may either be a number or a name (e.g. "INODE_ITEM" or
+// "INODE_ITEM_KEY"; case-insensitive).
+func parseItemType(str string) (btrfsprim.ItemType, error) {
+	if n, err := strconv.ParseUint(str, 0, 8); err == nil {
+		return btrfsprim.ItemType(n), nil
+	}
+	name := strings.TrimSuffix(strings.ToUpper(str), "_KEY")
+	for i := 0; i <= int(btrfsprim.MAX_KEY); i++ {
+		if typ := btrfsprim.ItemType(i); typ.String() == name {
+			return typ, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid item type: %q", str)
+}
+
+// parseKey parses a key given on the command line as an
+// "OBJECTID,TYPE,OFFSET" triple.  The type is parsed with
+// parseItemType, and the offset may be "-1" (as Key.Format prints
+// it) to mean the maximum offset.
+func parseKey(str string) (btrfsprim.Key, error) {
+	parts := strings.Split(str, ",")
+	if len(parts) != 3 {
+		return btrfsprim.Key{}, fmt.Errorf("invalid key: %q is not of the form OBJECTID,TYPE,OFFSET", str)
+	}
+	objID, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 0, 64)
+	if err != nil {
+		return btrfsprim.Key{}, fmt.Errorf("invalid key: %q: objectid: %w", str, err)
+	}
+	itemType, err := parseItemType(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return btrfsprim.Key{}, fmt.Errorf("invalid key: %q: %w", str, err)
+	}
+	var offset uint64
+	if offsetStr := strings.TrimSpace(parts[2]); offsetStr == "-1" {
+		offset = math.MaxUint64
+	} else {
+		offset, err = strconv.ParseUint(offsetStr, 0, 64)
+		if err != nil {
+			return btrfsprim.Key{}, fmt.Errorf("invalid key: %q: offset: %w", str, err)
+		}
+	}
+	return btrfsprim.Key{
+		ObjectID: btrfsprim.ObjID(objID),
+		ItemType: itemType,
+		Offset:   offset,
+	}, nil
+}
+
+// rewriteSuperblocks opens the device file `filename` directly
+// (rather than with runWithRawFS, which refuses devices with bad
+// superblock checksums), and calls fn on each of its superblock
+// mirrors in turn.  fn may modify the superblock; if it returns true,
+// then the superblock's checksum is re-calculated and the mirror is
+// written back to the device.
+func rewriteSuperblocks(ctx context.Context, filename string, fn func(int, *diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock]) (bool, error)) (err error) {
+	dev, _, err := openDevice(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if _err := dev.Close(); err == nil && _err != nil {
+			err = fmt.Errorf("device file %q: %w", filename, _err)
+		}
+	}()
+
+	sbs, err := dev.Superblocks()
+	if err != nil {
+		return fmt.Errorf("device file %q: %w", filename, err)
+	}
+	for i, sb := range sbs {
+		write, err := fn(i, sb)
+		if err != nil {
+			return fmt.Errorf("device file %q: superblock %v: %w", filename, i, err)
+		}
+		if !write {
+			continue
+		}
+		sb.Data.Checksum, err = sb.Data.CalculateChecksum()
+		if err != nil {
+			return fmt.Errorf("device file %q: superblock %v: %w", filename, i, err)
+		}
+		if err := sb.Write(); err != nil {
+			return fmt.Errorf("device file %q: superblock %v: %w", filename, i, err)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@
 package binstruct_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -63,3 +64,183 @@ func TestSmoke(t *testing.T) {
 	assert.Equal(t, 0x6F, n)
 	assert.Equal(t, input, output)
 }
+
+func TestStructFields(t *testing.T) {
+	t.Parallel()
+	type TestType struct {
+		Magic   [5]byte `bin:"off=0x0, siz=0x5"`
+		Ignored string  `bin:"-"`
+		Addr    int64   `bin:"off=0x5, siz=0x8"`
+
+		binstruct.End `bin:"off=0xd"`
+	}
+
+	assert.Equal(t,
+		[]binstruct.StructField{
+			{Name: "Magic", Index: 0, Offset: 0x0, Size: 0x5},
+			{Name: "Addr", Index: 2, Offset: 0x5, Size: 0x8},
+		},
+		binstruct.StructFields(reflect.TypeOf(TestType{})))
+	assert.Panics(t, func() {
+		binstruct.StructFields(reflect.TypeOf(0))
+	})
+}
+
+func TestByteOrder(t *testing.T) {
+	t.Parallel()
+	type TestType struct {
+		BE    uint32    `bin:"off=0x0, siz=0x4, be"`
+		LE    uint32    `bin:"off=0x4, siz=0x4, le"`
+		Dflt  uint32    `bin:"off=0x8, siz=0x4"`
+		BEArr [2]uint16 `bin:"off=0xc, siz=0x4, be"`
+		BEInt int16     `bin:"off=0x10, siz=0x2, be"`
+
+		binstruct.End `bin:"off=0x12"`
+	}
+	input := TestType{
+		BE:    0x01020304,
+		LE:    0x01020304,
+		Dflt:  0x01020304,
+		BEArr: [2]uint16{0x0506, 0x0708},
+		BEInt: -2,
+	}
+	exp := []byte{
+		0x01, 0x02, 0x03, 0x04, // BE
+		0x04, 0x03, 0x02, 0x01, // LE
+		0x04, 0x03, 0x02, 0x01, // Dflt
+		0x05, 0x06, 0x07, 0x08, // BEArr
+		0xff, 0xfe, // BEInt
+	}
+
+	bs, err := binstruct.Marshal(input)
+	assert.NoError(t, err)
+	assert.Equal(t, exp, bs)
+
+	var output TestType
+	n, err := binstruct.Unmarshal(bs, &output)
+	assert.NoError(t, err)
+	assert.Equal(t, len(exp), n)
+	assert.Equal(t, input, output)
+}
+
+func TestByteOrderInvalid(t *testing.T) {
+	t.Parallel()
+	type Inner struct {
+		X             uint16 `bin:"off=0x0, siz=0x2"`
+		binstruct.End `bin:"off=0x2"`
+	}
+	type TestType struct {
+		Inner         Inner `bin:"off=0x0, siz=0x2, be"`
+		binstruct.End `bin:"off=0x2"`
+	}
+	assert.Panics(t, func() {
+		binstruct.StaticSize(TestType{})
+	})
+}
+
+func TestBitfields(t *testing.T) {
+	t.Parallel()
+	type TestType struct {
+		Before uint8  `bin:"off=0x0, siz=0x1"`
+		Hi     uint8  `bin:"off=0x1, siz=0x1, bits=7:4"`
+		Lo     uint8  `bin:"off=0x1, siz=0x1, bits=3:0"`
+		Flag   uint8  `bin:"off=0x2, siz=0x2, bits=15:15"`
+		Count  uint16 `bin:"off=0x2, siz=0x2, bits=9:0"`
+		After  uint8  `bin:"off=0x4, siz=0x1"`
+
+		binstruct.End `bin:"off=0x5"`
+	}
+	assert.Equal(t, 5, binstruct.StaticSize(TestType{}))
+
+	input := TestType{
+		Before: 0xAA,
+		Hi:     0x3,
+		Lo:     0xC,
+		Flag:   1,
+		Count:  0x201,
+		After:  0xBB,
+	}
+	exp := []byte{
+		0xAA,
+		0x3C,
+		0x01, 0x82, // 0x8000 | 0x0201, little-endian
+		0xBB,
+	}
+
+	bs, err := binstruct.Marshal(input)
+	assert.NoError(t, err)
+	assert.Equal(t, exp, bs)
+
+	var output TestType
+	n, err := binstruct.Unmarshal(bs, &output)
+	assert.NoError(t, err)
+	assert.Equal(t, len(exp), n)
+	assert.Equal(t, input, output)
+
+	input.Lo = 0x10
+	_, err = binstruct.Marshal(input)
+	assert.Error(t, err)
+}
+
+func TestBitfieldsInvalid(t *testing.T) {
+	t.Parallel()
+	type Overlap struct {
+		A             uint8 `bin:"off=0x0, siz=0x1, bits=7:3"`
+		B             uint8 `bin:"off=0x0, siz=0x1, bits=3:0"`
+		binstruct.End `bin:"off=0x1"`
+	}
+	type TooWide struct {
+		A             uint8 `bin:"off=0x0, siz=0x2, bits=15:0"`
+		binstruct.End `bin:"off=0x2"`
+	}
+	type Signed struct {
+		A             int8 `bin:"off=0x0, siz=0x1, bits=3:0"`
+		binstruct.End `bin:"off=0x1"`
+	}
+	type OutOfRange struct {
+		A             uint8 `bin:"off=0x0, siz=0x1, bits=8:5"`
+		binstruct.End `bin:"off=0x1"`
+	}
+	assert.Panics(t, func() { binstruct.StaticSize(Overlap{}) })
+	assert.Panics(t, func() { binstruct.StaticSize(TooWide{}) })
+	assert.Panics(t, func() { binstruct.StaticSize(Signed{}) })
+	assert.Panics(t, func() { binstruct.StaticSize(OutOfRange{}) })
+}
+
+func TestArrays(t *testing.T) {
+	t.Parallel()
+	type NamedByte uint8
+	type Inner struct {
+		X             uint16 `bin:"off=0x0, siz=0x2"`
+		binstruct.End `bin:"off=0x2"`
+	}
+	type TestType struct {
+		Bytes   [4]byte      `bin:"off=0x0, siz=0x4"`
+		Named   [2]NamedByte `bin:"off=0x4, siz=0x2"`
+		Structs [2]Inner     `bin:"off=0x6, siz=0x4"`
+		Ptr     *[2]byte     `bin:"off=0xa, siz=0x2"`
+
+		binstruct.End `bin:"off=0xc"`
+	}
+	input := TestType{
+		Bytes:   [4]byte{1, 2, 3, 4},
+		Named:   [2]NamedByte{5, 6},
+		Structs: [2]Inner{{X: 0x0807}, {X: 0x0a09}},
+		Ptr:     &[2]byte{0x0b, 0x0c},
+	}
+	exp := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	bs, err := binstruct.Marshal(input)
+	assert.NoError(t, err)
+	assert.Equal(t, exp, bs)
+
+	var output TestType
+	n, err := binstruct.Unmarshal(bs, &output)
+	assert.NoError(t, err)
+	assert.Equal(t, len(exp), n)
+	assert.Equal(t, input, output)
+
+	var short [4]byte
+	_, err = binstruct.Unmarshal([]byte{1, 2}, &short)
+	assert.Error(t, err)
+}
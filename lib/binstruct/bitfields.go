@@ -0,0 +1,115 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package binstruct
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A bitRange is the value of a `bits=hi:lo` tag option; it says that
+// the field is bits hi through lo (inclusive, with bit 0 being the
+// least significant) of the siz-byte integer at off.  Consecutive
+// bitfields with the same off share that integer.
+type bitRange struct {
+	Hi, Lo int
+}
+
+func parseBitRange(str string) (*bitRange, error) {
+	parts := strings.SplitN(str, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("bits=%q: not of the form hi:lo", str)
+	}
+	hi, err := strconv.ParseUint(parts[0], 0, 8)
+	if err != nil {
+		return nil, fmt.Errorf("bits=%q: %w", str, err)
+	}
+	lo, err := strconv.ParseUint(parts[1], 0, 8)
+	if err != nil {
+		return nil, fmt.Errorf("bits=%q: %w", str, err)
+	}
+	if hi < lo {
+		return nil, fmt.Errorf("bits=%q: hi < lo", str)
+	}
+	return &bitRange{Hi: int(hi), Lo: int(lo)}, nil
+}
+
+func (r bitRange) Width() int { return r.Hi - r.Lo + 1 }
+
+func (r bitRange) Mask() uint64 {
+	if r.Width() == 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << r.Width()) - 1
+}
+
+func (r bitRange) Get(word uint64) uint64 {
+	return (word >> r.Lo) & r.Mask()
+}
+
+func (r bitRange) Set(word, val uint64) uint64 {
+	return (word &^ (r.Mask() << r.Lo)) | (val << r.Lo)
+}
+
+// checkBitfieldType returns an error if a field of type `typ` cannot
+// hold bits `r` of a siz-byte integer.
+func checkBitfieldType(typ reflect.Type, siz int, r bitRange) error {
+	switch siz {
+	case sizeof8, sizeof16, sizeof32, sizeof64:
+	default:
+		return fmt.Errorf("bitfield backing integer must be 1, 2, 4, or 8 bytes, not %v", siz)
+	}
+	if r.Hi >= siz*8 {
+		return fmt.Errorf("bits=%v:%v does not fit in a %v-byte integer", r.Hi, r.Lo, siz)
+	}
+	if typ.Implements(marshalerType) || typ.Implements(unmarshalerType) ||
+		reflect.PtrTo(typ).Implements(marshalerType) || reflect.PtrTo(typ).Implements(unmarshalerType) {
+		return fmt.Errorf("bitfield of type %v, which implements binstruct.Marshaler or binstruct.Unmarshaler", typ)
+	}
+	switch typ.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return fmt.Errorf("bitfield of type %v, but bitfields must be unsigned integers", typ)
+	}
+	if r.Width() > typ.Bits() {
+		return fmt.Errorf("bits=%v:%v is too wide for type %v", r.Hi, r.Lo, typ)
+	}
+	return nil
+}
+
+func getWord(dat []byte, order binary.ByteOrder) uint64 {
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	switch len(dat) {
+	case sizeof8:
+		return uint64(dat[0])
+	case sizeof16:
+		return uint64(order.Uint16(dat))
+	case sizeof32:
+		return uint64(order.Uint32(dat))
+	default: // sizeof64
+		return order.Uint64(dat)
+	}
+}
+
+func putWord(dat []byte, order binary.ByteOrder, word uint64) {
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	switch len(dat) {
+	case sizeof8:
+		dat[0] = byte(word)
+	case sizeof16:
+		order.PutUint16(dat, uint16(word))
+	case sizeof32:
+		order.PutUint32(dat, uint32(word))
+	default: // sizeof64
+		order.PutUint64(dat, word)
+	}
+}
@@ -29,7 +29,24 @@ func Marshal(obj any) ([]byte, error) {
 }
 
 func MarshalWithoutInterface(obj any) ([]byte, error) {
-	val := reflect.ValueOf(obj)
+	return marshalWithoutInterface(reflect.ValueOf(obj), nil)
+}
+
+// marshal is like Marshal, but for internal use; if `order` is
+// non-nil then the value is known to not implement Marshaler, and
+// integers are encoded with that byte order.
+func marshal(val reflect.Value, order binary.ByteOrder) ([]byte, error) {
+	if order == nil {
+		return Marshal(val.Interface())
+	}
+	return marshalWithoutInterface(val, order)
+}
+
+func marshalWithoutInterface(val reflect.Value, order binary.ByteOrder) ([]byte, error) {
+	byteOrder := order
+	if byteOrder == nil {
+		byteOrder = binary.LittleEndian
+	}
 	switch val.Kind() {
 	case reflect.Uint8:
 		var buf [sizeof8]byte
@@ -41,34 +58,42 @@ func MarshalWithoutInterface(obj any) ([]byte, error) {
 		return buf[:], nil
 	case reflect.Uint16:
 		var buf [sizeof16]byte
-		binary.LittleEndian.PutUint16(buf[:], uint16(val.Uint()))
+		byteOrder.PutUint16(buf[:], uint16(val.Uint()))
 		return buf[:], nil
 	case reflect.Int16:
 		var buf [sizeof16]byte
-		binary.LittleEndian.PutUint16(buf[:], uint16(val.Int()))
+		byteOrder.PutUint16(buf[:], uint16(val.Int()))
 		return buf[:], nil
 	case reflect.Uint32:
 		var buf [sizeof32]byte
-		binary.LittleEndian.PutUint32(buf[:], uint32(val.Uint()))
+		byteOrder.PutUint32(buf[:], uint32(val.Uint()))
 		return buf[:], nil
 	case reflect.Int32:
 		var buf [sizeof32]byte
-		binary.LittleEndian.PutUint32(buf[:], uint32(val.Int()))
+		byteOrder.PutUint32(buf[:], uint32(val.Int()))
 		return buf[:], nil
 	case reflect.Uint64:
 		var buf [sizeof64]byte
-		binary.LittleEndian.PutUint64(buf[:], val.Uint())
+		byteOrder.PutUint64(buf[:], val.Uint())
 		return buf[:], nil
 	case reflect.Int64:
 		var buf [sizeof64]byte
-		binary.LittleEndian.PutUint64(buf[:], uint64(val.Int()))
+		byteOrder.PutUint64(buf[:], uint64(val.Int()))
 		return buf[:], nil
 	case reflect.Ptr:
-		return Marshal(val.Elem().Interface())
+		return marshal(val.Elem(), order)
 	case reflect.Array:
+		if val.Type().Elem() == byteType {
+			// Fast-path for byte arrays (UUIDs, checksums,
+			// ...), which would otherwise be marshaled one
+			// reflect.Value at a time.
+			ret := make([]byte, val.Len())
+			reflect.Copy(reflect.ValueOf(ret), val)
+			return ret, nil
+		}
 		var ret []byte
 		for i := 0; i < val.Len(); i++ {
-			bs, err := Marshal(val.Index(i).Interface())
+			bs, err := marshal(val.Index(i), order)
 			ret = append(ret, bs...)
 			if err != nil {
 				return ret, err
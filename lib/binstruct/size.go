@@ -23,6 +23,7 @@ func StaticSize(obj any) int {
 }
 
 var (
+	byteType        = reflect.TypeOf(byte(0))
 	staticSizerType = reflect.TypeOf((*StaticSizer)(nil)).Elem()
 	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
 	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
@@ -5,6 +5,7 @@
 package binstruct
 
 import (
+	"encoding/binary"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -24,6 +25,12 @@ type tag struct {
 
 	off int
 	siz int
+
+	// order is nil unless the tag explicitly specifies a byte
+	// order with "le" or "be"; the default is little-endian.
+	order binary.ByteOrder
+
+	bits *bitRange
 }
 
 func parseStructTag(str string) (tag, error) {
@@ -33,8 +40,15 @@ func parseStructTag(str string) (tag, error) {
 		if part == "" {
 			continue
 		}
-		if part == "-" {
+		switch part {
+		case "-":
 			return tag{skip: true}, nil
+		case "le":
+			ret.order = binary.LittleEndian
+			continue
+		case "be":
+			ret.order = binary.BigEndian
+			continue
 		}
 		keyval := strings.SplitN(part, "=", 2)
 		if len(keyval) != 2 {
@@ -55,6 +69,12 @@ func parseStructTag(str string) (tag, error) {
 				return tag{}, err
 			}
 			ret.siz = int(vint)
+		case "bits":
+			bits, err := parseBitRange(val)
+			if err != nil {
+				return tag{}, err
+			}
+			ret.bits = bits
 		default:
 			return tag{}, fmt.Errorf("unrecognized option %q", key)
 		}
@@ -71,6 +91,9 @@ type structHandler struct {
 type structField struct {
 	name          string
 	isUnmarshaler bool
+	// bitsFirst is whether this is the first of a run of
+	// bitfields sharing the same backing integer.
+	bitsFirst bool
 	tag
 }
 
@@ -79,11 +102,26 @@ func (sh structHandler) Unmarshal(dat []byte, dst reflect.Value) (int, error) {
 		return 0, fmt.Errorf("struct %q %w", sh.name, err)
 	}
 	var n int
+	var word uint64
 	for i, field := range sh.fields {
 		if field.skip {
 			continue
 		}
-		_n, err := unmarshal(dat[n:], dst.Field(i), field.isUnmarshaler)
+		if field.bits != nil {
+			if field.bitsFirst {
+				word = getWord(dat[n:n+field.siz], field.order)
+				n += field.siz
+			}
+			dst.Field(i).SetUint(field.bits.Get(word))
+			continue
+		}
+		var _n int
+		var err error
+		if field.order != nil {
+			_n, err = unmarshalWithoutInterface(dat[n:], dst.Field(i), field.order)
+		} else {
+			_n, err = unmarshal(dat[n:], dst.Field(i), field.isUnmarshaler)
+		}
 		if err != nil {
 			if _n >= 0 {
 				n += _n
@@ -102,11 +140,25 @@ func (sh structHandler) Unmarshal(dat []byte, dst reflect.Value) (int, error) {
 
 func (sh structHandler) Marshal(val reflect.Value) ([]byte, error) {
 	ret := make([]byte, 0, sh.Size)
+	var wordBytes []byte
 	for i, field := range sh.fields {
 		if field.skip {
 			continue
 		}
-		bs, err := Marshal(val.Field(i).Interface())
+		if field.bits != nil {
+			v := val.Field(i).Uint()
+			if v > field.bits.Mask() {
+				return ret, fmt.Errorf("struct %q field %v %q: value %#x does not fit in bits=%v:%v",
+					sh.name, i, field.name, v, field.bits.Hi, field.bits.Lo)
+			}
+			if field.bitsFirst {
+				ret = append(ret, make([]byte, field.siz)...)
+				wordBytes = ret[len(ret)-field.siz:]
+			}
+			putWord(wordBytes, field.order, field.bits.Set(getWord(wordBytes, field.order), v))
+			continue
+		}
+		bs, err := marshal(val.Field(i), field.order)
 		ret = append(ret, bs...)
 		if err != nil {
 			return ret, fmt.Errorf("struct %q field %v %q: %w",
@@ -122,6 +174,8 @@ func genStructHandler(structInfo reflect.Type) (structHandler, error) {
 	ret.name = structInfo.String()
 
 	var curOffset, endOffset int
+	var bitsGroup *tag  // the tag of the first field of the current run of bitfields
+	var bitsUsed uint64 // which bits of the current run are already taken
 	for i := 0; i < structInfo.NumField(); i++ {
 		fieldInfo := structInfo.Field(i)
 
@@ -144,6 +198,52 @@ func genStructHandler(structInfo reflect.Type) (structHandler, error) {
 			continue
 		}
 
+		if fieldTag.bits != nil {
+			if err := checkBitfieldType(fieldInfo.Type, fieldTag.siz, *fieldTag.bits); err != nil {
+				return ret, fmt.Errorf("struct %q field %v %q: %w",
+					ret.name, i, fieldInfo.Name, err)
+			}
+			mask := fieldTag.bits.Mask() << fieldTag.bits.Lo
+			field := structField{
+				name: fieldInfo.Name,
+				tag:  fieldTag,
+			}
+			if bitsGroup != nil && fieldTag.off == bitsGroup.off {
+				// Continue the current run.
+				var err error
+				switch {
+				case fieldTag.siz != bitsGroup.siz:
+					err = fmt.Errorf("tag says siz=%#x but the bitfield at off=%#x has siz=%#x",
+						fieldTag.siz, bitsGroup.off, bitsGroup.siz)
+				case fieldTag.order != bitsGroup.order:
+					err = fmt.Errorf("byte order differs from the rest of the bitfield at off=%#x", bitsGroup.off)
+				case bitsUsed&mask != 0:
+					err = fmt.Errorf("bits=%v:%v overlaps another bitfield at off=%#x",
+						fieldTag.bits.Hi, fieldTag.bits.Lo, bitsGroup.off)
+				}
+				if err != nil {
+					return ret, fmt.Errorf("struct %q field %v %q: %w",
+						ret.name, i, fieldInfo.Name, err)
+				}
+				bitsUsed |= mask
+				ret.fields = append(ret.fields, field)
+				continue
+			}
+			// Start a new run.
+			if fieldTag.off != curOffset {
+				err := fmt.Errorf("tag says off=%#x but curOffset=%#x", fieldTag.off, curOffset)
+				return ret, fmt.Errorf("struct %q field %v %q: %w",
+					ret.name, i, fieldInfo.Name, err)
+			}
+			curOffset += fieldTag.siz
+			field.bitsFirst = true
+			ret.fields = append(ret.fields, field)
+			bitsGroup = &fieldTag
+			bitsUsed = mask
+			continue
+		}
+		bitsGroup = nil
+
 		if fieldTag.off != curOffset {
 			err := fmt.Errorf("tag says off=%#x but curOffset=%#x", fieldTag.off, curOffset)
 			return ret, fmt.Errorf("struct %q field %v %q: %w",
@@ -152,6 +252,12 @@ func genStructHandler(structInfo reflect.Type) (structHandler, error) {
 		if fieldInfo.Type == endType {
 			endOffset = curOffset
 		}
+		if fieldTag.order != nil {
+			if err := checkByteOrderType(fieldInfo.Type); err != nil {
+				return ret, fmt.Errorf("struct %q field %v %q: %w",
+					ret.name, i, fieldInfo.Name, err)
+			}
+		}
 
 		fieldSize, err := staticSize(fieldInfo.Type)
 		if err != nil {
@@ -182,6 +288,63 @@ func genStructHandler(structInfo reflect.Type) (structHandler, error) {
 	return ret, nil
 }
 
+// checkByteOrderType returns an error if a "le" or "be" tag option
+// would not be meaningful for the type; the options only apply to
+// plain integers (and arrays of them), not to structs or to types
+// that do their own marshaling.
+func checkByteOrderType(typ reflect.Type) error {
+	if typ.Implements(marshalerType) || typ.Implements(unmarshalerType) ||
+		reflect.PtrTo(typ).Implements(marshalerType) || reflect.PtrTo(typ).Implements(unmarshalerType) {
+		return fmt.Errorf("byte-order option given for type %v, which implements binstruct.Marshaler or binstruct.Unmarshaler", typ)
+	}
+	switch typ.Kind() {
+	case reflect.Uint8, reflect.Int8,
+		reflect.Uint16, reflect.Int16,
+		reflect.Uint32, reflect.Int32,
+		reflect.Uint64, reflect.Int64:
+		return nil
+	case reflect.Ptr, reflect.Array:
+		return checkByteOrderType(typ.Elem())
+	default:
+		return fmt.Errorf("byte-order option given for type %v, but it only applies to integers", typ)
+	}
+}
+
+// StructField describes one of the fields of a struct that will be
+// marshaled by binstruct, as described by its `bin:"..."` tag.
+type StructField struct {
+	Name   string // the Go name of the field
+	Index  int    // the index of the field, for reflect.Value.Field
+	Offset int
+	Size   int
+}
+
+// StructFields returns the fields of struct type `typ` that binstruct
+// marshals, in order.  Fields tagged `bin:"-"` and the binstruct.End
+// marker are not included.  It panics with an *InvalidTypeError if
+// `typ` is not a struct that binstruct can marshal.
+func StructFields(typ reflect.Type) []StructField {
+	if typ.Kind() != reflect.Struct {
+		panic(&InvalidTypeError{
+			Type: typ,
+			Err:  fmt.Errorf("not a struct"),
+		})
+	}
+	var ret []StructField
+	for i, field := range getStructHandler(typ).fields {
+		if field.skip || typ.Field(i).Type == endType {
+			continue
+		}
+		ret = append(ret, StructField{
+			Name:   field.name,
+			Index:  i,
+			Offset: field.off,
+			Size:   field.siz,
+		})
+	}
+	return ret
+}
+
 var structCache typedsync.CacheMap[reflect.Type, structHandler]
 
 func getStructHandler(typ reflect.Type) structHandler {
@@ -5,6 +5,17 @@
 // Package binstruct implements simple struct-tag-based conversion
 // between Go structures and binary on-disk representations of that
 // data.
+//
+// Integers are little-endian, unless the struct field's tag includes
+// the "be" option (for example `bin:"off=0x0, siz=0x4, be"`); "le"
+// may be given to be explicit about the default.
+//
+// Several unsigned-integer fields may share the bytes of a single
+// integer with the "bits=hi:lo" option; consecutive fields with the
+// same "off" and "siz" are packed in to the same integer:
+//
+//	A uint8 `bin:"off=0x0, siz=0x1, bits=7:4"`
+//	B uint8 `bin:"off=0x0, siz=0x1, bits=3:0"`
 package binstruct
 
 import (
@@ -49,7 +60,7 @@ func unmarshal(dat []byte, dst reflect.Value, isUnmarshaler bool) (int, error) {
 		}
 		return n, err
 	}
-	return unmarshalWithoutInterface(dat, dst)
+	return unmarshalWithoutInterface(dat, dst, nil)
 }
 
 func UnmarshalWithoutInterface(dat []byte, dstPtr any) (int, error) {
@@ -60,10 +71,17 @@ func UnmarshalWithoutInterface(dat []byte, dstPtr any) (int, error) {
 			Err:  errors.New("not a pointer"),
 		})
 	}
-	return unmarshalWithoutInterface(dat, _dstPtr.Elem())
+	return unmarshalWithoutInterface(dat, _dstPtr.Elem(), nil)
 }
 
-func unmarshalWithoutInterface(dat []byte, dst reflect.Value) (int, error) {
+// unmarshalWithoutInterface decodes integers with the given byte
+// order (little-endian if nil).  If `order` is non-nil, then `dst` is
+// known to not contain anything that implements Unmarshaler.
+func unmarshalWithoutInterface(dat []byte, dst reflect.Value, order binary.ByteOrder) (int, error) {
+	byteOrder := order
+	if byteOrder == nil {
+		byteOrder = binary.LittleEndian
+	}
 	switch dst.Kind() {
 	case reflect.Uint8:
 		if err := binutil.NeedNBytes(dat, sizeof8); err != nil {
@@ -81,49 +99,70 @@ func unmarshalWithoutInterface(dat []byte, dst reflect.Value) (int, error) {
 		if err := binutil.NeedNBytes(dat, sizeof16); err != nil {
 			return 0, err
 		}
-		dst.SetUint(uint64(binary.LittleEndian.Uint16(dat[:sizeof16])))
+		dst.SetUint(uint64(byteOrder.Uint16(dat[:sizeof16])))
 		return sizeof16, nil
 	case reflect.Int16:
 		if err := binutil.NeedNBytes(dat, sizeof16); err != nil {
 			return 0, err
 		}
-		dst.SetInt(int64(binary.LittleEndian.Uint16(dat[:sizeof16])))
+		dst.SetInt(int64(byteOrder.Uint16(dat[:sizeof16])))
 		return sizeof16, nil
 	case reflect.Uint32:
 		if err := binutil.NeedNBytes(dat, sizeof32); err != nil {
 			return 0, err
 		}
-		dst.SetUint(uint64(binary.LittleEndian.Uint32(dat[:sizeof32])))
+		dst.SetUint(uint64(byteOrder.Uint32(dat[:sizeof32])))
 		return sizeof32, nil
 	case reflect.Int32:
 		if err := binutil.NeedNBytes(dat, sizeof32); err != nil {
 			return 0, err
 		}
-		dst.SetInt(int64(binary.LittleEndian.Uint32(dat[:sizeof32])))
+		dst.SetInt(int64(byteOrder.Uint32(dat[:sizeof32])))
 		return sizeof32, nil
 	case reflect.Uint64:
 		if err := binutil.NeedNBytes(dat, sizeof64); err != nil {
 			return 0, err
 		}
-		dst.SetUint(binary.LittleEndian.Uint64(dat[:sizeof64]))
+		dst.SetUint(byteOrder.Uint64(dat[:sizeof64]))
 		return sizeof64, nil
 	case reflect.Int64:
 		if err := binutil.NeedNBytes(dat, sizeof64); err != nil {
 			return 0, err
 		}
-		dst.SetInt(int64(binary.LittleEndian.Uint64(dat[:sizeof64])))
+		dst.SetInt(int64(byteOrder.Uint64(dat[:sizeof64])))
 		return sizeof64, nil
 	case reflect.Ptr:
 		typ := dst.Type()
 		elemPtr := reflect.New(typ.Elem())
-		n, err := unmarshal(dat, elemPtr.Elem(), typ.Implements(unmarshalerType))
-		dst.SetPointer(elemPtr.UnsafePointer())
+		var n int
+		var err error
+		if order != nil {
+			n, err = unmarshalWithoutInterface(dat, elemPtr.Elem(), order)
+		} else {
+			n, err = unmarshal(dat, elemPtr.Elem(), typ.Implements(unmarshalerType))
+		}
+		dst.Set(elemPtr)
 		return n, err
 	case reflect.Array:
+		if dst.Type().Elem() == byteType {
+			// Fast-path for byte arrays (UUIDs, checksums,
+			// ...), which would otherwise be unmarshaled one
+			// reflect.Value at a time.
+			if err := binutil.NeedNBytes(dat, dst.Len()); err != nil {
+				return 0, err
+			}
+			return reflect.Copy(dst, reflect.ValueOf(dat[:dst.Len()])), nil
+		}
 		isUnmarshaler := dst.Type().Elem().Implements(unmarshalerType)
 		var n int
 		for i := 0; i < dst.Len(); i++ {
-			_n, err := unmarshal(dat[n:], dst.Index(i), isUnmarshaler)
+			var _n int
+			var err error
+			if order != nil {
+				_n, err = unmarshalWithoutInterface(dat[n:], dst.Index(i), order)
+			} else {
+				_n, err = unmarshal(dat[n:], dst.Index(i), isUnmarshaler)
+			}
 			n += _n
 			if err != nil {
 				return n, err
@@ -0,0 +1,66 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfs
+
+import (
+	"context"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+// A BlockGroup is a BLOCK_GROUP_ITEM along with the logical range
+// that is stored in its key.
+type BlockGroup struct {
+	LAddr btrfsvol.LogicalAddr
+	Size  btrfsvol.AddrDelta
+	btrfsitem.BlockGroup
+}
+
+// ReadBlockGroups returns all of the block groups in the filesystem,
+// in key (logical address) order.  They are read from the
+// BLOCK_GROUP_TREE if the filesystem has the block-group-tree
+// feature, and from the EXTENT_TREE otherwise.
+//
+// Malformed items are logged and skipped, rather than causing the
+// entire read to fail.
+func ReadBlockGroups(ctx context.Context, fs ReadableFS) ([]BlockGroup, error) {
+	sb, err := fs.Superblock()
+	if err != nil {
+		return nil, err
+	}
+	treeID := btrfsprim.EXTENT_TREE_OBJECTID
+	if sb.CompatROFlags.Has(btrfstree.FeatureCompatROBlockGroupTree) {
+		treeID = btrfsprim.BLOCK_GROUP_TREE_OBJECTID
+	}
+	tree, err := fs.ForrestLookup(ctx, treeID)
+	if err != nil {
+		return nil, err
+	}
+	var ret []BlockGroup
+	if err := tree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType != btrfsitem.BLOCK_GROUP_ITEM_KEY {
+			return true
+		}
+		switch body := item.Body.(type) {
+		case *btrfsitem.BlockGroup:
+			ret = append(ret, BlockGroup{
+				LAddr:      btrfsvol.LogicalAddr(item.Key.ObjectID),
+				Size:       btrfsvol.AddrDelta(item.Key.Offset),
+				BlockGroup: *body,
+			})
+		case *btrfsitem.Error:
+			dlog.Errorf(ctx, "%v: %v: malformed BLOCK_GROUP_ITEM: %v", treeID, item.Key, body.Err)
+		}
+		return true
+	}); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
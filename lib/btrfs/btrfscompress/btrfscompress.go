@@ -0,0 +1,64 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package btrfscompress contains the decompressors for the
+// compression algorithms that btrfs uses for file extents.
+package btrfscompress
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+)
+
+// MaxUncompressedSize is the most that a compressed extent may
+// decompress to (BTRFS_MAX_UNCOMPRESSED); the kernel splits larger
+// writes in to several extents.
+const MaxUncompressedSize = 128 * 1024
+
+// MaxCompressedSize is the most on-disk space that a compressed extent
+// may take up (BTRFS_MAX_COMPRESSED).
+const MaxCompressedSize = 128 * 1024
+
+// Decompress decompresses the entirety of a compressed extent, `in`,
+// which should decompress to `outSize` bytes (the extent's
+// .RAMBytes).  If the compressed stream ends early, the rest of the
+// output is zero-filled, as the kernel does.
+//
+// Since `outSize` comes from disk, it is an error for it to be
+// non-positive or greater than MaxUncompressedSize, rather than
+// trusting it as an allocation size.
+func Decompress(typ btrfsitem.CompressionType, in []byte, outSize int64) ([]byte, error) {
+	if outSize <= 0 || outSize > MaxUncompressedSize {
+		return nil, fmt.Errorf("invalid decompressed size %v (must be between 1 and %v)", outSize, MaxUncompressedSize)
+	}
+	out := make([]byte, outSize)
+	switch typ {
+	case btrfsitem.COMPRESS_ZLIB:
+		zr, err := zlib.NewReader(bytes.NewReader(in))
+		if err != nil {
+			return nil, fmt.Errorf("zlib: %w", err)
+		}
+		defer zr.Close()
+		n, err := io.ReadFull(zr, out)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("zlib: after %v bytes: %w", n, err)
+		}
+	case btrfsitem.COMPRESS_LZO:
+		if err := decompressLZO(out, in); err != nil {
+			return nil, fmt.Errorf("lzo: %w", err)
+		}
+	case btrfsitem.COMPRESS_ZSTD:
+		if err := decompressZstd(out, in); err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression type %v", typ)
+	}
+	return out, nil
+}
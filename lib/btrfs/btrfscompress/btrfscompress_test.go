@@ -0,0 +1,149 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfscompress_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfscompress"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+)
+
+func TestDecompressZlib(t *testing.T) {
+	t.Parallel()
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, err := zw.Write([]byte("hello, world"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	out, err := btrfscompress.Decompress(btrfsitem.COMPRESS_ZLIB, compressed.Bytes(), 16)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello, world\x00\x00\x00\x00"), out)
+}
+
+func TestDecompressBadSize(t *testing.T) {
+	t.Parallel()
+	for _, size := range []int64{-1, 0, btrfscompress.MaxUncompressedSize + 1, 1 << 62} {
+		_, err := btrfscompress.Decompress(btrfsitem.COMPRESS_ZLIB, nil, size)
+		assert.ErrorContains(t, err, "invalid decompressed size", size)
+	}
+}
+
+// lzoSegment is an lzo1x stream that was assembled by hand to use
+// each kind of instruction.
+var (
+	lzoSegment = []byte{
+		0x19, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', // 8 literals
+		0x2e, 0x1f, 0x00, 'X', 'Y', 'Z', // M3: copy 16 from 8 back, then 3 literals
+		0x04, 0x00, // M1 (after 1-3 literals): copy 2 from 2 back
+		0x70, 0x03, // M2: copy 4 from 29 back
+		0x11, 0x00, 0x00, // M4: end of stream
+	}
+	lzoSegmentPlain = []byte("abcdefgh" + "abcdefghabcdefgh" + "XYZ" + "YZ" + "abcd")
+)
+
+// lzoLiterals returns an lzo1x stream that encodes `dat` (which must
+// be at least 19 bytes) as a single literal run.
+func lzoLiterals(dat []byte) []byte {
+	ret := []byte{0x00}
+	rem := len(dat) - 3 - 15
+	for rem > 255 {
+		ret = append(ret, 0x00)
+		rem -= 255
+	}
+	ret = append(ret, byte(rem))
+	ret = append(ret, dat...)
+	return append(ret, 0x11, 0x00, 0x00)
+}
+
+// lzoFrame wraps lzo1x segments in btrfs' framing.
+func lzoFrame(segments ...[]byte) []byte {
+	ret := make([]byte, 4)
+	for _, seg := range segments {
+		if left := btrfssum.BlockSize - len(ret)%btrfssum.BlockSize; left < 4 {
+			ret = append(ret, make([]byte, left)...)
+		}
+		ret = binary.LittleEndian.AppendUint32(ret, uint32(len(seg)))
+		ret = append(ret, seg...)
+	}
+	binary.LittleEndian.PutUint32(ret, uint32(len(ret)))
+	return ret
+}
+
+func TestDecompressLZO(t *testing.T) {
+	t.Parallel()
+
+	t.Run("single", func(t *testing.T) {
+		t.Parallel()
+		out, err := btrfscompress.Decompress(btrfsitem.COMPRESS_LZO, lzoFrame(lzoSegment), int64(len(lzoSegmentPlain)+3))
+		require.NoError(t, err)
+		assert.Equal(t, append(lzoSegmentPlain, 0, 0, 0), out)
+	})
+
+	t.Run("padding", func(t *testing.T) {
+		t.Parallel()
+		// Make the first segment end 2 bytes before the end of
+		// the sector, so that the second segment header has to
+		// skip to the next sector.
+		plain1 := bytes.Repeat([]byte("0123456789"), 407)[:4066]
+		seg1 := lzoLiterals(plain1)
+		require.Len(t, seg1, btrfssum.BlockSize-2-8)
+		in := lzoFrame(seg1, lzoSegment)
+		require.Equal(t, btrfssum.BlockSize+4+len(lzoSegment), len(in))
+
+		out, err := btrfscompress.Decompress(btrfsitem.COMPRESS_LZO, in, int64(len(plain1)+len(lzoSegmentPlain)))
+		require.NoError(t, err)
+		assert.Equal(t, append(plain1, lzoSegmentPlain...), out)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		t.Parallel()
+		in := lzoFrame(lzoSegment[:len(lzoSegment)-3])
+		_, err := btrfscompress.Decompress(btrfsitem.COMPRESS_LZO, in, int64(len(lzoSegmentPlain)))
+		assert.Error(t, err)
+	})
+
+	t.Run("lookbehind", func(t *testing.T) {
+		t.Parallel()
+		in := lzoFrame([]byte{0x15, 'a', 'b', 'c', 'd', 0x70, 0x03, 0x11, 0x00, 0x00})
+		_, err := btrfscompress.Decompress(btrfsitem.COMPRESS_LZO, in, 8)
+		assert.ErrorContains(t, err, "lookbehind overrun")
+	})
+}
+
+func TestDecompressUnsupported(t *testing.T) {
+	t.Parallel()
+	_, err := btrfscompress.Decompress(btrfsitem.CompressionType(9), nil, 0)
+	assert.Error(t, err)
+}
+
+func TestDecompressZstd(t *testing.T) {
+	t.Parallel()
+	plain := bytes.Repeat([]byte("hello, world "), 1000)
+	zw, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	compressed := zw.EncodeAll(plain, nil)
+	require.NoError(t, zw.Close())
+	// On disk, the frame is zero-padded to a whole sector.
+	padded := make([]byte, btrfssum.BlockSize)
+	copy(padded, compressed)
+
+	out, err := btrfscompress.Decompress(btrfsitem.COMPRESS_ZSTD, padded, int64(len(plain)))
+	require.NoError(t, err)
+	assert.Equal(t, plain, out)
+
+	// But a bad frame is still an error.
+	_, err = btrfscompress.Decompress(btrfsitem.COMPRESS_ZSTD, make([]byte, btrfssum.BlockSize), int64(len(plain)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,249 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfscompress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
+)
+
+// btrfs doesn't store a bare LZO stream; see the comment at the top
+// of the kernel's fs/btrfs/lzo.c.  The layout is
+//
+//	LE32 total length (including this header)
+//	segments, each:
+//	    LE32 compressed length of the segment
+//	    the segment, an lzo1x stream of at most 1 sector of output
+//
+// A segment header never straddles a sector boundary; if there are
+// fewer than 4 bytes left in the sector after a segment, they are
+// zero padding, and the next header starts at the next sector.
+const lzoLenSize = 4
+
+func decompressLZO(out, in []byte) error {
+	if len(in) < lzoLenSize {
+		return fmt.Errorf("short header: %v bytes", len(in))
+	}
+	totalLen := int(binary.LittleEndian.Uint32(in))
+	if totalLen > len(in) {
+		return fmt.Errorf("header says %v bytes, but only have %v", totalLen, len(in))
+	}
+
+	var buf [btrfssum.BlockSize]byte
+	inPos, outPos := lzoLenSize, 0
+	for inPos < totalLen && outPos < len(out) {
+		if inPos+lzoLenSize > totalLen {
+			return fmt.Errorf("in@%v: short segment header", inPos)
+		}
+		segLen := int(binary.LittleEndian.Uint32(in[inPos:]))
+		inPos += lzoLenSize
+		if segLen > totalLen-inPos {
+			return fmt.Errorf("in@%v: segment length %v runs past the end (%v)", inPos, segLen, totalLen)
+		}
+		n, err := lzo1xDecompress(buf[:], in[inPos:inPos+segLen])
+		if err != nil {
+			return fmt.Errorf("in@%v: %w", inPos, err)
+		}
+		inPos += segLen
+		outPos += copy(out[outPos:], buf[:n])
+
+		if sectorLeft := btrfssum.BlockSize - inPos%btrfssum.BlockSize; sectorLeft < lzoLenSize {
+			inPos += sectorLeft
+		}
+	}
+	return nil
+}
+
+var (
+	errLZOInputOverrun      = errors.New("input overrun")
+	errLZOOutputOverrun     = errors.New("output overrun")
+	errLZOLookbehindOverrun = errors.New("lookbehind overrun")
+)
+
+// lzo1xDecompress decompresses the lzo1x stream `src` in to `dst`,
+// returning the number of bytes written.  This follows the kernel's
+// lib/lzo/lzo1x_decompress_safe.c (minus support for the LZO-RLE
+// bitstream, which btrfs doesn't use); see
+// Documentation/staging/lzo.rst for a description of the format.
+func lzo1xDecompress(dst, src []byte) (int, error) {
+	var ip, op int
+
+	needIP := func(n int) error {
+		if ip+n > len(src) {
+			return errLZOInputOverrun
+		}
+		return nil
+	}
+	needOP := func(n int) error {
+		if op+n > len(dst) {
+			return errLZOOutputOverrun
+		}
+		return nil
+	}
+	// readRun reads the zero-byte-extended length of an
+	// instruction whose length bits were all zero.
+	readRun := func(base int) (int, error) {
+		zeros := 0
+		for {
+			if err := needIP(1); err != nil {
+				return 0, err
+			}
+			if src[ip] != 0 {
+				break
+			}
+			zeros++
+			ip++
+		}
+		n := zeros*255 + base + int(src[ip])
+		ip++
+		return n, nil
+	}
+	copyLiterals := func(n int) error {
+		if err := needIP(n); err != nil {
+			return err
+		}
+		if err := needOP(n); err != nil {
+			return err
+		}
+		copy(dst[op:op+n], src[ip:ip+n])
+		op += n
+		ip += n
+		return nil
+	}
+	copyMatch := func(dist, n int) error {
+		if dist <= 0 || dist > op {
+			return errLZOLookbehindOverrun
+		}
+		if err := needOP(n); err != nil {
+			return err
+		}
+		// Byte-by-byte, since the ranges may overlap.
+		for i := 0; i < n; i++ {
+			dst[op+i] = dst[op-dist+i]
+		}
+		op += n
+		return nil
+	}
+
+	if err := needIP(3); err != nil {
+		return 0, err
+	}
+
+	// state is the number of literals copied by the previous
+	// instruction (4 meaning "4 or more"); it changes the meaning
+	// of instructions 0-15.
+	var state int
+	if src[0] > 17 {
+		n := int(src[0]) - 17
+		ip++
+		if err := copyLiterals(n); err != nil {
+			return op, err
+		}
+		state = slices.Min(n, 4)
+	}
+
+	for {
+		if err := needIP(1); err != nil {
+			return op, err
+		}
+		inst := int(src[ip])
+		ip++
+
+		var dist, length, next int
+		switch {
+		case inst >= 64: // M2: 3-8 bytes from within 2KiB
+			if err := needIP(1); err != nil {
+				return op, err
+			}
+			length = (inst >> 5) + 1
+			dist = ((inst >> 2) & 7) + (int(src[ip]) << 3) + 1
+			ip++
+			next = inst & 3
+		case inst >= 32: // M3: from within 16KiB
+			length = (inst & 31) + 2
+			if length == 2 {
+				n, err := readRun(31)
+				if err != nil {
+					return op, err
+				}
+				length += n
+			}
+			if err := needIP(2); err != nil {
+				return op, err
+			}
+			v := int(binary.LittleEndian.Uint16(src[ip:]))
+			ip += 2
+			dist = (v >> 2) + 1
+			next = v & 3
+		case inst >= 16: // M4: from within 16-48KiB, or end-of-stream
+			length = (inst & 7) + 2
+			if length == 2 {
+				n, err := readRun(7)
+				if err != nil {
+					return op, err
+				}
+				length += n
+			}
+			if err := needIP(2); err != nil {
+				return op, err
+			}
+			v := int(binary.LittleEndian.Uint16(src[ip:]))
+			ip += 2
+			dist = ((inst & 8) << 11) + (v >> 2)
+			next = v & 3
+			if dist == 0 {
+				if length != 3 {
+					return op, fmt.Errorf("malformed end-of-stream marker")
+				}
+				if ip != len(src) {
+					return op, fmt.Errorf("%v bytes of trailing input", len(src)-ip)
+				}
+				return op, nil
+			}
+			dist += 0x4000
+		case state == 0: // M1 with no preceding literals: a long literal run
+			length = inst + 3
+			if inst == 0 {
+				n, err := readRun(15)
+				if err != nil {
+					return op, err
+				}
+				length += n
+			}
+			if err := copyLiterals(length); err != nil {
+				return op, err
+			}
+			state = 4
+			continue
+		case state < 4: // M1 after 1-3 literals: 2 bytes from within 1KiB
+			if err := needIP(1); err != nil {
+				return op, err
+			}
+			length = 2
+			dist = (inst >> 2) + (int(src[ip]) << 2) + 1
+			ip++
+			next = inst & 3
+		default: // M1 after 4+ literals: 3 bytes from 2-3KiB back
+			if err := needIP(1); err != nil {
+				return op, err
+			}
+			length = 3
+			dist = (inst >> 2) + (int(src[ip]) << 2) + 0x0801
+			ip++
+			next = inst & 3
+		}
+		if err := copyMatch(dist, length); err != nil {
+			return op, err
+		}
+		if err := copyLiterals(next); err != nil {
+			return op, err
+		}
+		state = next
+	}
+}
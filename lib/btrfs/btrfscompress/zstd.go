@@ -0,0 +1,42 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfscompress
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+)
+
+// decompressZstd decompresses the zstd frame in `in` in to `out`.
+//
+// The on-disk extent is rounded up to a whole number of sectors, so
+// the frame is followed by zero padding; the decoder sees that as the
+// start of a second frame with a bad magic number, which is fine as
+// long as the first frame decoded.
+func decompressZstd(out, in []byte) error {
+	zstdDecoderOnce.Do(func() {
+		// DecodeAll is safe for concurrent use, so share one
+		// decoder rather than paying for its buffers on every
+		// extent.
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil)
+	})
+	if zstdDecoderErr != nil {
+		return zstdDecoderErr
+	}
+	dat, err := zstdDecoder.DecodeAll(in, make([]byte, 0, len(out)))
+	if err != nil && !(errors.Is(err, zstd.ErrMagicMismatch) && len(dat) > 0) {
+		return err
+	}
+	copy(out, dat)
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestBlockGroupUnmarshal(t *testing.T) {
+	t.Parallel()
+	dat := []byte{
+		0x00, 0x00, 0x30, 0x00, 0x00, 0x00, 0x00, 0x00, // used
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // chunk_objectid
+		0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // flags
+	}
+
+	key := btrfsprim.Key{
+		ObjectID: 0x1500000,
+		ItemType: btrfsitem.BLOCK_GROUP_ITEM_KEY,
+		Offset:   0x4000000,
+	}
+	item := btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, dat)
+	require.IsType(t, &btrfsitem.BlockGroup{}, item)
+	assert.Equal(t, &btrfsitem.BlockGroup{
+		Used:          0x300000,
+		ChunkObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+		Flags:         btrfsvol.BLOCK_GROUP_METADATA | btrfsvol.BLOCK_GROUP_DUP,
+	}, item)
+
+	out, err := binstruct.Marshal(item)
+	require.NoError(t, err)
+	assert.Equal(t, dat, out)
+}
@@ -33,7 +33,7 @@ type Chunk struct { // complex CHUNK_ITEM=228
 type ChunkHeader struct {
 	Size           btrfsvol.AddrDelta       `bin:"off=0x0,  siz=0x8"`
 	Owner          btrfsprim.ObjID          `bin:"off=0x8,  siz=0x8"` // root referencing this chunk (always EXTENT_TREE_OBJECTID=2)
-	StripeLen      uint64                   `bin:"off=0x10, siz=0x8"` // ???
+	StripeLen      uint64                   `bin:"off=0x10, siz=0x8"` // size of each piece that RAID0/RAID10 stripes the chunk in to
 	Type           btrfsvol.BlockGroupFlags `bin:"off=0x18, siz=0x8"`
 	IOOptimalAlign uint32                   `bin:"off=0x20, siz=0x4"`
 	IOOptimalWidth uint32                   `bin:"off=0x24, siz=0x4"`
@@ -51,9 +51,10 @@ type ChunkStripe struct {
 }
 
 func (chunk Chunk) Mappings(key btrfsprim.Key) []btrfsvol.Mapping {
+	striping := chunk.striping()
 	ret := make([]btrfsvol.Mapping, 0, len(chunk.Stripes))
-	for _, stripe := range chunk.Stripes {
-		ret = append(ret, btrfsvol.Mapping{
+	for i, stripe := range chunk.Stripes {
+		mapping := btrfsvol.Mapping{
 			LAddr: btrfsvol.LogicalAddr(key.Offset),
 			PAddr: btrfsvol.QualifiedPhysicalAddr{
 				Dev:  stripe.DeviceID,
@@ -62,11 +63,64 @@ func (chunk Chunk) Mappings(key btrfsprim.Key) []btrfsvol.Mapping {
 			Size:       chunk.Head.Size,
 			SizeLocked: true,
 			Flags:      containers.OptionalValue(chunk.Head.Type),
-		})
+			Striping:   striping,
+		}
+		if striping.OK {
+			mapping.StripeIndex = uint16(i)
+		}
+		ret = append(ret, mapping)
 	}
 	return ret
 }
 
+// striping returns how the chunk's logical range is split across its
+// stripes, if it is (RAID0, RAID10, RAID5, or RAID6).
+func (chunk Chunk) striping() containers.Optional[btrfsvol.Striping] {
+	var subStripes, parity uint16
+	switch {
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID0):
+		subStripes = 1
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID10):
+		subStripes = chunk.Head.SubStripes
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID5):
+		subStripes, parity = 1, 1
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID6):
+		subStripes, parity = 1, 2
+	default:
+		return containers.Optional[btrfsvol.Striping]{}
+	}
+	return containers.OptionalValue(btrfsvol.Striping{
+		StripeLen:  btrfsvol.AddrDelta(chunk.Head.StripeLen),
+		NumStripes: uint16(len(chunk.Stripes)),
+		SubStripes: subStripes,
+		Parity:     parity,
+	})
+}
+
+// StripeSize returns how many bytes of each device the chunk's
+// stripes occupy (that is, what the .Length of the corresponding
+// DevExtents should be), based on the chunk's RAID profile.
+func (chunk Chunk) StripeSize() btrfsvol.AddrDelta {
+	numStripes := btrfsvol.AddrDelta(len(chunk.Stripes))
+	var numDataStripes btrfsvol.AddrDelta
+	switch {
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID0):
+		numDataStripes = numStripes
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID10) && chunk.Head.SubStripes > 0:
+		numDataStripes = numStripes / btrfsvol.AddrDelta(chunk.Head.SubStripes)
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID5):
+		numDataStripes = numStripes - 1
+	case chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_RAID6):
+		numDataStripes = numStripes - 2
+	default: // single, DUP, RAID1*
+		numDataStripes = 1
+	}
+	if numDataStripes <= 0 {
+		return chunk.Head.Size
+	}
+	return chunk.Head.Size / numDataStripes
+}
+
 var chunkStripePool containers.SlicePool[ChunkStripe]
 
 func (chunk *Chunk) Free() {
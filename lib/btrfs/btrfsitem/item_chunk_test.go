@@ -0,0 +1,88 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func TestChunkStripeSize(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		Type       btrfsvol.BlockGroupFlags
+		NumStripes int
+		SubStripes uint16
+		Exp        btrfsvol.AddrDelta
+	}
+	testcases := map[string]TestCase{
+		"single": {btrfsvol.BLOCK_GROUP_DATA, 1, 1, 1200},
+		"dup":    {btrfsvol.BLOCK_GROUP_METADATA | btrfsvol.BLOCK_GROUP_DUP, 2, 1, 1200},
+		"raid1":  {btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID1, 2, 1, 1200},
+		"raid0":  {btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID0, 3, 1, 400},
+		"raid10": {btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID10, 4, 2, 600},
+		"raid5":  {btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID5, 4, 1, 400},
+		"raid6":  {btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID6, 5, 1, 400},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			chunk := btrfsitem.Chunk{
+				Head: btrfsitem.ChunkHeader{
+					Size:       1200,
+					Type:       tc.Type,
+					NumStripes: uint16(tc.NumStripes),
+					SubStripes: tc.SubStripes,
+				},
+				Stripes: make([]btrfsitem.ChunkStripe, tc.NumStripes),
+			}
+			assert.Equal(t, tc.Exp, chunk.StripeSize())
+		})
+	}
+}
+
+func TestChunkMappingsStriping(t *testing.T) {
+	t.Parallel()
+	chunk := btrfsitem.Chunk{
+		Head: btrfsitem.ChunkHeader{
+			Size:       0x40000,
+			StripeLen:  0x10000,
+			Type:       btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID10,
+			NumStripes: 4,
+			SubStripes: 2,
+		},
+		Stripes: []btrfsitem.ChunkStripe{
+			{DeviceID: 1, Offset: 0x1000},
+			{DeviceID: 2, Offset: 0x2000},
+			{DeviceID: 3, Offset: 0x3000},
+			{DeviceID: 4, Offset: 0x4000},
+		},
+	}
+	mappings := chunk.Mappings(btrfsprim.Key{Offset: 0x100000})
+	require.Len(t, mappings, 4)
+	for i, mapping := range mappings {
+		assert.Equal(t, btrfsvol.AddrDelta(0x40000), mapping.Size)
+		assert.Equal(t, uint16(i), mapping.StripeIndex)
+		assert.Equal(t, containers.OptionalValue(btrfsvol.Striping{
+			StripeLen:  0x10000,
+			NumStripes: 4,
+			SubStripes: 2,
+		}), mapping.Striping)
+	}
+
+	chunk.Head.Type = btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID1
+	for _, mapping := range chunk.Mappings(btrfsprim.Key{Offset: 0x100000}) {
+		assert.False(t, mapping.Striping.OK)
+		assert.Zero(t, mapping.StripeIndex)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 )
 
 // A DevExtent tracks allocation of the physical address space.
@@ -36,8 +37,15 @@ type DevExtent struct { // trivial DEV_EXTENT=204
 	binstruct.End `bin:"off=48"`
 }
 
-func (devext DevExtent) Mapping(key btrfsprim.Key) btrfsvol.Mapping {
-	return btrfsvol.Mapping{
+// Mapping returns the mapping that the DevExtent describes.  A
+// DevExtent doesn't record how its chunk is striped, so that has to
+// come from elsewhere (usually the CHUNK_ITEM): if `striping` is set,
+// then the DevExtent is stripe number `stripeIndex` of a striped
+// chunk, and .Length is the physical size of that stripe rather than
+// the logical size of the chunk.  If `striping` is not set, the
+// chunk is assumed to not be striped.
+func (devext DevExtent) Mapping(key btrfsprim.Key, striping containers.Optional[btrfsvol.Striping], stripeIndex uint16) btrfsvol.Mapping {
+	ret := btrfsvol.Mapping{
 		LAddr: devext.ChunkOffset,
 		PAddr: btrfsvol.QualifiedPhysicalAddr{
 			Dev:  btrfsvol.DeviceID(key.ObjectID),
@@ -46,4 +54,10 @@ func (devext DevExtent) Mapping(key btrfsprim.Key) btrfsvol.Mapping {
 		Size:       devext.Length,
 		SizeLocked: true,
 	}
+	if striping.OK {
+		ret.Size = striping.Val.LogicalSize(devext.Length)
+		ret.Striping = striping
+		ret.StripeIndex = stripeIndex
+	}
+	return ret
 }
@@ -38,6 +38,34 @@ type DirEntry struct { // complex DIR_ITEM=84 DIR_INDEX=96 XATTR_ITEM=24
 	Name          []byte `bin:"-"`
 }
 
+// IsSubvolume returns whether the entry refers to the root directory
+// of another subvolume, rather than to an inode in the same subvolume
+// as the directory containing the entry.
+func (o DirEntry) IsSubvolume() bool {
+	return o.Location.ItemType == ROOT_ITEM_KEY
+}
+
+// TargetInode returns the number of the inode that the entry refers
+// to, within the same subvolume as the directory containing the
+// entry.  If the entry does not refer to an inode (for example, it
+// refers to a subvolume), then ok is false.
+func (o DirEntry) TargetInode() (inode btrfsprim.ObjID, ok bool) {
+	if o.Location.ItemType != INODE_ITEM_KEY {
+		return 0, false
+	}
+	return o.Location.ObjectID, true
+}
+
+// TargetTree returns the ID of the subvolume tree that the entry
+// refers to.  If the entry does not refer to a subvolume, then ok is
+// false.
+func (o DirEntry) TargetTree() (tree btrfsprim.ObjID, ok bool) {
+	if !o.IsSubvolume() {
+		return 0, false
+	}
+	return o.Location.ObjectID, true
+}
+
 func (o *DirEntry) Free() {
 	bytePool.Put(o.Data)
 	bytePool.Put(o.Name)
@@ -0,0 +1,56 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+)
+
+func TestDirEntryTarget(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		Location   btrfsprim.Key
+		ExpSubvol  bool
+		ExpInode   btrfsprim.ObjID
+		ExpInodeOK bool
+		ExpTree    btrfsprim.ObjID
+		ExpTreeOK  bool
+	}
+	testcases := map[string]TestCase{
+		"inode": {
+			Location:   btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.INODE_ITEM_KEY},
+			ExpInode:   257,
+			ExpInodeOK: true,
+		},
+		"subvol": {
+			Location:  btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: btrfsprim.MaxOffset},
+			ExpSubvol: true,
+			ExpTree:   256,
+			ExpTreeOK: true,
+		},
+		"garbage": {
+			Location: btrfsprim.Key{ObjectID: 258, ItemType: btrfsitem.DIR_ITEM_KEY},
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			entry := btrfsitem.DirEntry{Location: tc.Location}
+			assert.Equal(t, tc.ExpSubvol, entry.IsSubvolume())
+			inode, ok := entry.TargetInode()
+			assert.Equal(t, tc.ExpInode, inode)
+			assert.Equal(t, tc.ExpInodeOK, ok)
+			tree, ok := entry.TargetTree()
+			assert.Equal(t, tc.ExpTree, tree)
+			assert.Equal(t, tc.ExpTreeOK, ok)
+		})
+	}
+}
@@ -126,6 +126,10 @@ var fileExtentTypeNames = []string{
 func (o FileExtent) Size() (int64, error) {
 	switch o.Type {
 	case FILE_EXTENT_INLINE:
+		if o.Compression != COMPRESS_NONE {
+			// .BodyInline is the compressed data.
+			return o.RAMBytes, nil
+		}
 		return int64(len(o.BodyInline)), nil
 	case FILE_EXTENT_REG, FILE_EXTENT_PREALLOC:
 		return o.BodyExtent.NumBytes, nil
@@ -0,0 +1,54 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+)
+
+func TestFileExtentUnmarshal(t *testing.T) {
+	t.Parallel()
+	dat := []byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // generation
+		0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // ram_bytes
+		0x03,       // compression
+		0x00,       // encryption
+		0x00, 0x00, // other_encoding
+		0x01,                                           // type
+		0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, // disk_bytenr
+		0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // disk_num_bytes
+		0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // offset
+		0x00, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // num_bytes
+	}
+
+	var item btrfsitem.FileExtent
+	n, err := item.UnmarshalBinary(dat)
+	require.NoError(t, err)
+	assert.Equal(t, len(dat), n)
+	assert.Equal(t, btrfsitem.FileExtent{
+		Generation:    0x0807060504030201,
+		RAMBytes:      0x2000,
+		Compression:   btrfsitem.COMPRESS_ZSTD,
+		Encryption:    0,
+		OtherEncoding: 0,
+		Type:          btrfsitem.FILE_EXTENT_REG,
+		BodyExtent: btrfsitem.FileExtentExtent{
+			DiskByteNr:   0x100000,
+			DiskNumBytes: 0x1000,
+			Offset:       0x400,
+			NumBytes:     0xc00,
+		},
+	}, item)
+	assert.Equal(t, "3 (zstd)", item.Compression.String())
+
+	out, err := item.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, dat, out)
+}
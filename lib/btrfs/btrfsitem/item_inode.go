@@ -50,21 +50,29 @@ const (
 	INODE_NOATIME
 	INODE_DIRSYNC
 	INODE_COMPRESS
+
+	INODE_ROOT_ITEM_INIT InodeFlags = 1 << 31
+
+	// The kernel keeps the "ro_flags" in the upper 32 bits.
+
+	INODE_RO_VERITY InodeFlags = 1 << 32
 )
 
 var inodeFlagNames = []string{
-	"NODATASUM",
-	"NODATACOW",
-	"READONLY",
-	"NOCOMPRESS",
-	"PREALLOC",
-	"SYNC",
-	"IMMUTABLE",
-	"APPEND",
-	"NODUMP",
-	"NOATIME",
-	"DIRSYNC",
-	"COMPRESS",
+	0:  "NODATASUM",
+	1:  "NODATACOW",
+	2:  "READONLY",
+	3:  "NOCOMPRESS",
+	4:  "PREALLOC",
+	5:  "SYNC",
+	6:  "IMMUTABLE",
+	7:  "APPEND",
+	8:  "NODUMP",
+	9:  "NOATIME",
+	10: "DIRSYNC",
+	11: "COMPRESS",
+	31: "ROOT_ITEM_INIT",
+	32: "RO_VERITY",
 }
 
 func (f InodeFlags) Has(req InodeFlags) bool { return f&req == req }
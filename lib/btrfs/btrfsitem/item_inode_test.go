@@ -0,0 +1,27 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+)
+
+func TestInodeFlagsString(t *testing.T) {
+	t.Parallel()
+	testcases := map[btrfsitem.InodeFlags]string{
+		0: "0x0(none)",
+		btrfsitem.INODE_NODATASUM | btrfsitem.INODE_NODATACOW:     "0x3(NODATASUM|NODATACOW)",
+		btrfsitem.INODE_COMPRESS | btrfsitem.INODE_ROOT_ITEM_INIT: "0x80000800(COMPRESS|ROOT_ITEM_INIT)",
+		btrfsitem.INODE_RO_VERITY:                                 "0x100000000(RO_VERITY)",
+		1 << 12:                                                   "0x1000((1<<12))",
+	}
+	for in, exp := range testcases {
+		assert.Equal(t, exp, in.String())
+	}
+}
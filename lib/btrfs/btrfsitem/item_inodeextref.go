@@ -0,0 +1,121 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem
+
+import (
+	"fmt"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct/binutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+// An InodeExtRefs item is a set of back-references that point to a
+// given Inode, like InodeRefs; they are used (with the
+// EXTENDED_IREF incompat feature) for hard links that don't fit in
+// to the INODE_REF item for their directory.
+//
+// Key:
+//
+//	key.objectid = inode number of the file
+//	key.offset   = crc32c(parent inode number, name)
+//
+// There might be multiple back-references in a single InodeExtRef
+// item if their hashes collide.
+type InodeExtRefs struct { // complex INODE_EXTREF=13
+	Refs []InodeExtRef
+}
+
+var inodeExtRefPool containers.SlicePool[InodeExtRef]
+
+func (o *InodeExtRefs) Free() {
+	for i := range o.Refs {
+		bytePool.Put(o.Refs[i].Name)
+		o.Refs[i] = InodeExtRef{}
+	}
+	inodeExtRefPool.Put(o.Refs)
+	*o = InodeExtRefs{}
+	inodeExtRefsPool.Put(o)
+}
+
+func (o InodeExtRefs) Clone() InodeExtRefs {
+	var ret InodeExtRefs
+	ret.Refs = inodeExtRefPool.Get(len(o.Refs))
+	copy(ret.Refs, o.Refs)
+	for i := range ret.Refs {
+		ret.Refs[i].Name = cloneBytes(o.Refs[i].Name)
+	}
+	return ret
+}
+
+func (o *InodeExtRefs) UnmarshalBinary(dat []byte) (int, error) {
+	o.Refs = nil
+	if len(dat) > 0 {
+		o.Refs = inodeExtRefPool.Get(1)[:0]
+	}
+	n := 0
+	for n < len(dat) {
+		var ref InodeExtRef
+		_n, err := binstruct.Unmarshal(dat[n:], &ref)
+		n += _n
+		if err != nil {
+			return n, err
+		}
+		o.Refs = append(o.Refs, ref)
+	}
+	return n, nil
+}
+
+func (o InodeExtRefs) MarshalBinary() ([]byte, error) {
+	var dat []byte
+	for _, ref := range o.Refs {
+		_dat, err := binstruct.Marshal(ref)
+		dat = append(dat, _dat...)
+		if err != nil {
+			return dat, err
+		}
+	}
+	return dat, nil
+}
+
+type InodeExtRef struct {
+	Parent        btrfsprim.ObjID `bin:"off=0x0, siz=0x8"` // inode number of the parent directory
+	Index         int64           `bin:"off=0x8, siz=0x8"`
+	NameLen       uint16          `bin:"off=0x10, siz=0x2"` // [ignored-when-writing]
+	binstruct.End `bin:"off=0x12"`
+	Name          []byte `bin:"-"`
+}
+
+func (o *InodeExtRef) UnmarshalBinary(dat []byte) (int, error) {
+	if err := binutil.NeedNBytes(dat, 0x12); err != nil {
+		return 0, err
+	}
+	n, err := binstruct.UnmarshalWithoutInterface(dat, o)
+	if err != nil {
+		return n, err
+	}
+	if o.NameLen > MaxNameLen {
+		return 0, fmt.Errorf("maximum name len is %v, but .NameLen=%v",
+			MaxNameLen, o.NameLen)
+	}
+	if err := binutil.NeedNBytes(dat, 0x12+int(o.NameLen)); err != nil {
+		return 0, err
+	}
+	dat = dat[n:]
+	o.Name = cloneBytes(dat[:o.NameLen])
+	n += int(o.NameLen)
+	return n, nil
+}
+
+func (o InodeExtRef) MarshalBinary() ([]byte, error) {
+	o.NameLen = uint16(len(o.Name))
+	dat, err := binstruct.MarshalWithoutInterface(o)
+	if err != nil {
+		return dat, err
+	}
+	dat = append(dat, o.Name...)
+	return dat, nil
+}
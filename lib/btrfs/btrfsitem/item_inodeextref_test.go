@@ -0,0 +1,50 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+)
+
+func TestInodeExtRefsUnmarshal(t *testing.T) {
+	t.Parallel()
+	dat := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // parent=256
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // index=2
+		0x03, 0x00, // name_len=3
+		'f', 'o', 'o',
+		0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // parent=257
+		0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // index=5
+		0x02, 0x00, // name_len=2
+		'b', 'a',
+	}
+	exp := &btrfsitem.InodeExtRefs{
+		Refs: []btrfsitem.InodeExtRef{
+			{Parent: 256, Index: 2, NameLen: 3, Name: []byte("foo")},
+			{Parent: 257, Index: 5, NameLen: 2, Name: []byte("ba")},
+		},
+	}
+
+	key := btrfsprim.Key{ObjectID: 258, ItemType: btrfsitem.INODE_EXTREF_KEY, Offset: 0x1234}
+	item := btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, dat)
+	assert.Equal(t, exp, item)
+	assert.Equal(t, "INODE_EXTREF", key.ItemType.String())
+
+	// Round-trip.
+	out, err := binstruct.Marshal(exp)
+	assert.NoError(t, err)
+	assert.Equal(t, dat, out)
+
+	// Truncated names are an error.
+	item = btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, dat[:len(dat)-1])
+	assert.IsType(t, &btrfsitem.Error{}, item)
+}
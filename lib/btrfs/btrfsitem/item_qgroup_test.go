@@ -0,0 +1,128 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+)
+
+// le64 returns the 8 little-endian bytes of `v`.
+func le64(v uint64) []byte {
+	ret := make([]byte, 8)
+	for i := range ret {
+		ret[i] = byte(v >> (8 * i))
+	}
+	return ret
+}
+
+func concat(parts ...[]byte) []byte {
+	var ret []byte
+	for _, part := range parts {
+		ret = append(ret, part...)
+	}
+	return ret
+}
+
+func TestQGroupItems(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		ItemType btrfsprim.ItemType
+		Dat      []byte
+		Exp      btrfsitem.Item
+	}
+	testcases := map[string]TestCase{
+		"status": {
+			ItemType: btrfsitem.QGROUP_STATUS_KEY,
+			Dat:      concat(le64(1), le64(0x1234), le64(0x5), le64(0x400000)),
+			Exp: &btrfsitem.QGroupStatus{
+				Version:        btrfsitem.QGroupStatusVersion,
+				Generation:     0x1234,
+				Flags:          btrfsitem.QGroupStatusFlagOn | btrfsitem.QGroupStatusFlagInconsistent,
+				RescanProgress: 0x400000,
+			},
+		},
+		"info": {
+			ItemType: btrfsitem.QGROUP_INFO_KEY,
+			Dat:      concat(le64(7), le64(0x10000), le64(0x8000), le64(0x4000), le64(0x2000)),
+			Exp: &btrfsitem.QGroupInfo{
+				Generation:                7,
+				ReferencedBytes:           0x10000,
+				ReferencedBytesCompressed: 0x8000,
+				ExclusiveBytes:            0x4000,
+				ExclusiveBytesCompressed:  0x2000,
+			},
+		},
+		"limit": {
+			ItemType: btrfsitem.QGROUP_LIMIT_KEY,
+			Dat:      concat(le64(0x3), le64(1<<30), le64(1<<29), le64(0), le64(0)),
+			Exp: &btrfsitem.QGroupLimit{
+				Flags:         btrfsitem.QGroupLimitFlagMaxRfer | btrfsitem.QGroupLimitFlagMaxExcl,
+				MaxReferenced: 1 << 30,
+				MaxExclusive:  1 << 29,
+			},
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			key := btrfsprim.Key{ItemType: tc.ItemType}
+			item := btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, tc.Dat)
+			assert.Equal(t, tc.Exp, item)
+		})
+	}
+
+	assert.Equal(t, "ON|INCONSISTENT", (btrfsitem.QGroupStatusFlagOn | btrfsitem.QGroupStatusFlagInconsistent).String())
+	assert.Equal(t, "MAX_RFER|MAX_EXCL", (btrfsitem.QGroupLimitFlagMaxRfer | btrfsitem.QGroupLimitFlagMaxExcl).String())
+}
+
+func TestQGroupRelation(t *testing.T) {
+	t.Parallel()
+	const (
+		sub256 = 0<<48 | 256
+		sub257 = 0<<48 | 257
+		sub258 = 0<<48 | 258
+		grp1a  = 1<<48 | 10
+		grp1b  = 1<<48 | 11
+		grp2   = 2<<48 | 1
+	)
+	relation := func(a, b uint64) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: btrfsprim.ObjID(a), ItemType: btrfsitem.QGROUP_RELATION_KEY, Offset: b}
+	}
+
+	// The item body is empty, but it decodes to its own type.
+	item := btrfsitem.UnmarshalItem(relation(sub256, grp1a), btrfssum.TYPE_CRC32, nil)
+	assert.Equal(t, &btrfsitem.QGroupRelation{}, item)
+
+	// Either direction of the key decodes the same.
+	member, parent := btrfsitem.QGroupRelationMembers(relation(sub256, grp1a))
+	assert.Equal(t, uint64(sub256), member)
+	assert.Equal(t, uint64(grp1a), parent)
+	member, parent = btrfsitem.QGroupRelationMembers(relation(grp1a, sub256))
+	assert.Equal(t, uint64(sub256), member)
+	assert.Equal(t, uint64(grp1a), parent)
+
+	keys := []btrfsprim.Key{
+		{ObjectID: 0, ItemType: btrfsitem.QGROUP_STATUS_KEY, Offset: 0},
+		relation(sub256, grp1a), relation(grp1a, sub256),
+		relation(sub257, grp1a), relation(grp1a, sub257),
+		relation(grp1b, sub258), // only one direction
+		relation(sub258, grp1a),
+		relation(grp1a, grp2), relation(grp2, grp1a),
+		relation(grp1b, grp2),
+		{ObjectID: 0, ItemType: btrfsitem.QGROUP_INFO_KEY, Offset: grp2},
+	}
+	assert.Equal(t, map[uint64][]uint64{
+		grp1a: {sub256, sub257, sub258},
+		grp1b: {sub258},
+		grp2:  {grp1a, grp1b},
+	}, btrfsitem.QGroupRelationGraph(keys))
+}
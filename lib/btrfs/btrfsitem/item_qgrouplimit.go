@@ -12,7 +12,7 @@ import (
 type QGroupLimitFlags uint64
 
 const (
-	QGroupLimitFlagMaxRfer = 1 << iota
+	QGroupLimitFlagMaxRfer QGroupLimitFlags = 1 << iota
 	QGroupLimitFlagMaxExcl
 	QGroupLimitFlagRsvRfer
 	QGroupLimitFlagRsvExcl
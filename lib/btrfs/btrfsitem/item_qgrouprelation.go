@@ -0,0 +1,62 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem
+
+import (
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+)
+
+// A QGroupRelation records that one qgroup is a member of another.
+// The item has no body; the relationship is entirely in the key.
+// Each relationship is stored twice, once in each direction.
+//
+// A qgroup ID is the qgroup's level in the top 16 bits and its ID
+// within that level in the lower 48 bits, and a parent is always at a
+// higher level than its members; so the member is whichever of the
+// two is smaller.
+//
+// Key:
+//
+//	key.objectid = qgroup ID of one side
+//	key.offset   = qgroup ID of the other side
+type QGroupRelation struct { // trivial QGROUP_RELATION=246
+	binstruct.End `bin:"off=0"`
+}
+
+// QGroupRelationMembers returns the member (child) and parent qgroup
+// IDs of the QGROUP_RELATION item with the key `key`.
+func QGroupRelationMembers(key btrfsprim.Key) (member, parent uint64) {
+	if key.ObjectID < btrfsprim.ObjID(key.Offset) {
+		return uint64(key.ObjectID), key.Offset
+	}
+	return key.Offset, uint64(key.ObjectID)
+}
+
+// QGroupRelationGraph builds the qgroup hierarchy from the keys of
+// QGROUP_RELATION items, returning a map from each parent qgroup ID
+// to the sorted IDs of its members.  It doesn't matter whether one or
+// both directions of each relationship are included in `keys`; keys
+// of other item types are ignored.
+func QGroupRelationGraph(keys []btrfsprim.Key) map[uint64][]uint64 {
+	members := make(map[uint64]containers.Set[uint64])
+	for _, key := range keys {
+		if key.ItemType != QGROUP_RELATION_KEY {
+			continue
+		}
+		member, parent := QGroupRelationMembers(key)
+		if members[parent] == nil {
+			members[parent] = make(containers.Set[uint64])
+		}
+		members[parent].Insert(member)
+	}
+	ret := make(map[uint64][]uint64, len(members))
+	for parent, set := range members {
+		ret[parent] = maps.SortedKeys(set)
+	}
+	return ret
+}
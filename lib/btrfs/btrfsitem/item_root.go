@@ -69,3 +69,13 @@ var rootFlagNames = []string{
 
 func (f RootFlags) Has(req RootFlags) bool { return f&req == req }
 func (f RootFlags) String() string         { return fmtutil.BitfieldString(f, rootFlagNames, fmtutil.HexLower) }
+
+// IsBeingDeleted returns whether the tree was in the middle of being
+// deleted (a non-zero .DropProgress) as of this root item.  The
+// kernel drops a tree incrementally, recording in .DropProgress and
+// .DropLevel how far it has got, so a recovered tree with this set is
+// likely to be only partially present, and should not be trusted as
+// a complete subvolume.
+func (r Root) IsBeingDeleted() bool {
+	return r.DropProgress != (btrfsprim.Key{})
+}
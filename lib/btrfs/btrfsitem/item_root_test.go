@@ -0,0 +1,104 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestRootUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	// Lay out a root_item by hand, following "struct
+	// btrfs_root_item" in the kernel's
+	// include/uapi/linux/btrfs_tree.h, rather than using
+	// binstruct.Marshal, so that the test catches any mistakes in
+	// the `bin:"off=..."` tags.
+	dat := make([]byte, 0x1b7)
+	le := binary.LittleEndian
+	le.PutUint64(dat[0x0a0:], 0x1234)            // generation
+	le.PutUint64(dat[0x0a8:], 256)               // root_dirid
+	le.PutUint64(dat[0x0b0:], 0x500000)          // bytenr
+	le.PutUint64(dat[0x0c0:], 0x4000)            // bytes_used
+	le.PutUint64(dat[0x0c8:], 0x1200)            // last_snapshot
+	le.PutUint64(dat[0x0d0:], 1)                 // flags
+	le.PutUint32(dat[0x0d8:], 1)                 // refs
+	le.PutUint64(dat[0x0dc:], 260)               // drop_progress.objectid
+	dat[0x0e4] = byte(btrfsitem.EXTENT_DATA_KEY) // drop_progress.type
+	le.PutUint64(dat[0x0e5:], 0x3000)            // drop_progress.offset
+	dat[0x0ed] = 1                               // drop_level
+	dat[0x0ee] = 2                               // level
+	le.PutUint64(dat[0x0ef:], 0x1234)            // generation_v2
+	for i := 0; i < 0x10; i++ {
+		dat[0x0f7+i] = 0xa0 + byte(i) // uuid
+		dat[0x107+i] = 0xb0 + byte(i) // parent_uuid
+		dat[0x117+i] = 0xc0 + byte(i) // received_uuid
+	}
+	le.PutUint64(dat[0x127:], 0x1231) // ctransid
+	le.PutUint64(dat[0x12f:], 0x1232) // otransid
+	le.PutUint64(dat[0x137:], 0x1233) // stransid
+	le.PutUint64(dat[0x13f:], 0x1234) // rtransid
+	le.PutUint64(dat[0x147:], 1000)   // ctime.sec
+	le.PutUint32(dat[0x14f:], 1)      // ctime.nsec
+	le.PutUint64(dat[0x153:], 2000)   // otime.sec
+	le.PutUint64(dat[0x15f:], 3000)   // stime.sec
+	le.PutUint64(dat[0x16b:], 4000)   // rtime.sec
+
+	key := btrfsprim.Key{
+		ObjectID: 257,
+		ItemType: btrfsitem.ROOT_ITEM_KEY,
+		Offset:   0,
+	}
+	item := btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, dat)
+	require.IsType(t, &btrfsitem.Root{}, item)
+	root := item.(*btrfsitem.Root)
+
+	assert.Equal(t, btrfsprim.Generation(0x1234), root.Generation)
+	assert.Equal(t, btrfsprim.ObjID(256), root.RootDirID)
+	assert.Equal(t, btrfsvol.LogicalAddr(0x500000), root.ByteNr)
+	assert.Equal(t, int64(0x4000), root.BytesUsed)
+	assert.Equal(t, int64(0x1200), root.LastSnapshot)
+	assert.Equal(t, btrfsitem.ROOT_SUBVOL_RDONLY, root.Flags)
+	assert.Equal(t, int32(1), root.Refs)
+	assert.Equal(t, btrfsprim.Key{
+		ObjectID: 260,
+		ItemType: btrfsitem.EXTENT_DATA_KEY,
+		Offset:   0x3000,
+	}, root.DropProgress)
+	assert.Equal(t, uint8(1), root.DropLevel)
+	assert.Equal(t, uint8(2), root.Level)
+	assert.Equal(t, btrfsprim.Generation(0x1234), root.GenerationV2)
+	assert.Equal(t, btrfsprim.MustParseUUID("a0a1a2a3-a4a5-a6a7-a8a9-aaabacadaeaf"), root.UUID)
+	assert.Equal(t, btrfsprim.MustParseUUID("b0b1b2b3-b4b5-b6b7-b8b9-babbbcbdbebf"), root.ParentUUID)
+	assert.Equal(t, btrfsprim.MustParseUUID("c0c1c2c3-c4c5-c6c7-c8c9-cacbcccdcecf"), root.ReceivedUUID)
+	assert.Equal(t, int64(0x1231), root.CTransID)
+	assert.Equal(t, int64(0x1232), root.OTransID)
+	assert.Equal(t, int64(0x1233), root.STransID)
+	assert.Equal(t, int64(0x1234), root.RTransID)
+	assert.Equal(t, btrfsprim.Time{Sec: 1000, NSec: 1}, root.CTime)
+	assert.Equal(t, btrfsprim.Time{Sec: 2000}, root.OTime)
+	assert.Equal(t, btrfsprim.Time{Sec: 3000}, root.STime)
+	assert.Equal(t, btrfsprim.Time{Sec: 4000}, root.RTime)
+	assert.True(t, root.IsBeingDeleted())
+
+	out, err := binstruct.Marshal(root)
+	require.NoError(t, err)
+	assert.Equal(t, dat, out)
+
+	// A tree that isn't being dropped has a zero drop_progress.
+	root.DropProgress = btrfsprim.Key{}
+	root.DropLevel = 0
+	assert.False(t, root.IsBeingDeleted())
+}
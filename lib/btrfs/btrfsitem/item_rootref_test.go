@@ -0,0 +1,47 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+)
+
+func TestRootRefUnmarshal(t *testing.T) {
+	t.Parallel()
+	dat := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // dirid=256
+		0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // sequence=3
+		0x04, 0x00, // name_len=4
+		'h', 'o', 'm', 'e',
+	}
+	exp := &btrfsitem.RootRef{
+		DirID:    256,
+		Sequence: 3,
+		NameLen:  4,
+		Name:     []byte("home"),
+	}
+
+	for _, typ := range []btrfsprim.ItemType{btrfsitem.ROOT_REF_KEY, btrfsitem.ROOT_BACKREF_KEY} {
+		key := btrfsprim.Key{ObjectID: 5, ItemType: typ, Offset: 256}
+		item := btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, dat)
+		assert.Equal(t, exp, item, typ)
+	}
+
+	// Round-trip.
+	out, err := exp.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, dat, out)
+
+	// Truncated names are an error.
+	key := btrfsprim.Key{ObjectID: 5, ItemType: btrfsitem.ROOT_REF_KEY, Offset: 256}
+	item := btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, dat[:len(dat)-1])
+	assert.IsType(t, &btrfsitem.Error{}, item)
+}
@@ -5,6 +5,8 @@
 package btrfsitem
 
 import (
+	"git.lukeshu.com/go/typedsync"
+
 	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 )
@@ -16,3 +18,38 @@ type FreeSpaceHeader struct { // trivial UNTYPED=0:FREE_SPACE_OBJECTID
 	NumBitmaps    int64                `bin:"off=0x21, siz=0x8"`
 	binstruct.End `bin:"off=0x29"`
 }
+
+// UntypedUnknown is an UNTYPED item with an object ID that we don't
+// know how to parse.  Unlike Error, this does not indicate that the
+// item is corrupt; it most likely indicates that the filesystem uses
+// a feature that is newer than this tool.
+type UntypedUnknown struct {
+	ObjID btrfsprim.ObjID
+	Dat   []byte
+}
+
+var untypedUnknownPool = &typedsync.Pool[*UntypedUnknown]{New: func() *UntypedUnknown { return new(UntypedUnknown) }}
+
+func (*UntypedUnknown) isItem() {}
+
+func (o *UntypedUnknown) Free() {
+	*o = UntypedUnknown{}
+	untypedUnknownPool.Put(o)
+}
+
+func (o UntypedUnknown) Clone() UntypedUnknown { return o }
+
+func (o *UntypedUnknown) CloneItem() Item {
+	ret, _ := untypedUnknownPool.Get()
+	*ret = *o
+	return ret
+}
+
+func (o UntypedUnknown) MarshalBinary() ([]byte, error) {
+	return o.Dat, nil
+}
+
+func (o *UntypedUnknown) UnmarshalBinary(dat []byte) (int, error) {
+	o.Dat = dat
+	return len(dat), nil
+}
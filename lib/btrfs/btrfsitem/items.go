@@ -62,18 +62,18 @@ func (o *Error) UnmarshalBinary(dat []byte) (int, error) {
 // the item type specified by `key`.
 //
 // If there is an error, rather than returning a separate error value,
-// return an Error item.
+// return an Error item.  An UNTYPED item with an object ID that isn't
+// known is not an error; it is returned as an UntypedUnknown item.
 func UnmarshalItem(key btrfsprim.Key, csumType btrfssum.CSumType, dat []byte) Item {
 	var gotyp reflect.Type
 	if key.ItemType == UNTYPED_KEY {
 		var ok bool
 		gotyp, ok = untypedObjID2gotype[key.ObjectID]
 		if !ok {
-			ret, _ := errorPool.Get()
-			*ret = Error{
-				Dat: dat,
-				Err: fmt.Errorf("btrfsitem.UnmarshalItem({ItemType:%v, ObjectID:%v}, dat): unknown object ID for untyped item",
-					key.ItemType, key.ObjectID),
+			ret, _ := untypedUnknownPool.Get()
+			*ret = UntypedUnknown{
+				ObjID: key.ObjectID,
+				Dat:   dat,
 			}
 			return ret
 		}
@@ -95,6 +95,9 @@ func UnmarshalItem(key btrfsprim.Key, csumType btrfssum.CSumType, dat []byte) It
 		csums.Addr = btrfsvol.LogicalAddr(key.Offset)
 	}
 	n, err := binstruct.Unmarshal(dat, ptr)
+	if err != nil && len(dat) == 0 {
+		err = fmt.Errorf("zero-length item body: %w", err)
+	}
 	if err != nil {
 		ptr.Free()
 		ret, _ := errorPool.Get()
@@ -24,6 +24,7 @@ const (
 	FREE_SPACE_BITMAP_KEY    = btrfsprim.FREE_SPACE_BITMAP_KEY
 	FREE_SPACE_EXTENT_KEY    = btrfsprim.FREE_SPACE_EXTENT_KEY
 	FREE_SPACE_INFO_KEY      = btrfsprim.FREE_SPACE_INFO_KEY
+	INODE_EXTREF_KEY         = btrfsprim.INODE_EXTREF_KEY
 	INODE_ITEM_KEY           = btrfsprim.INODE_ITEM_KEY
 	INODE_REF_KEY            = btrfsprim.INODE_REF_KEY
 	METADATA_ITEM_KEY        = btrfsprim.METADATA_ITEM_KEY
@@ -61,10 +62,12 @@ var (
 	freeSpaceHeaderType = reflect.TypeOf(FreeSpaceHeader{})
 	freeSpaceInfoType   = reflect.TypeOf(FreeSpaceInfo{})
 	inodeType           = reflect.TypeOf(Inode{})
+	inodeExtRefsType    = reflect.TypeOf(InodeExtRefs{})
 	inodeRefsType       = reflect.TypeOf(InodeRefs{})
 	metadataType        = reflect.TypeOf(Metadata{})
 	qGroupInfoType      = reflect.TypeOf(QGroupInfo{})
 	qGroupLimitType     = reflect.TypeOf(QGroupLimit{})
+	qGroupRelationType  = reflect.TypeOf(QGroupRelation{})
 	qGroupStatusType    = reflect.TypeOf(QGroupStatus{})
 	rootType            = reflect.TypeOf(Root{})
 	rootRefType         = reflect.TypeOf(RootRef{})
@@ -87,6 +90,7 @@ var keytype2gotype = map[Type]reflect.Type{
 	FREE_SPACE_BITMAP_KEY:    freeSpaceBitmapType,
 	FREE_SPACE_EXTENT_KEY:    emptyType,
 	FREE_SPACE_INFO_KEY:      freeSpaceInfoType,
+	INODE_EXTREF_KEY:         inodeExtRefsType,
 	INODE_ITEM_KEY:           inodeType,
 	INODE_REF_KEY:            inodeRefsType,
 	METADATA_ITEM_KEY:        metadataType,
@@ -94,7 +98,7 @@ var keytype2gotype = map[Type]reflect.Type{
 	PERSISTENT_ITEM_KEY:      devStatsType,
 	QGROUP_INFO_KEY:          qGroupInfoType,
 	QGROUP_LIMIT_KEY:         qGroupLimitType,
-	QGROUP_RELATION_KEY:      emptyType,
+	QGROUP_RELATION_KEY:      qGroupRelationType,
 	QGROUP_STATUS_KEY:        qGroupStatusType,
 	ROOT_BACKREF_KEY:         rootRefType,
 	ROOT_ITEM_KEY:            rootType,
@@ -129,10 +133,12 @@ var (
 	freeSpaceHeaderPool = typedsync.Pool[Item]{New: func() Item { return new(FreeSpaceHeader) }}
 	freeSpaceInfoPool   = typedsync.Pool[Item]{New: func() Item { return new(FreeSpaceInfo) }}
 	inodePool           = typedsync.Pool[Item]{New: func() Item { return new(Inode) }}
+	inodeExtRefsPool    = typedsync.Pool[Item]{New: func() Item { return new(InodeExtRefs) }}
 	inodeRefsPool       = typedsync.Pool[Item]{New: func() Item { return new(InodeRefs) }}
 	metadataPool        = typedsync.Pool[Item]{New: func() Item { return new(Metadata) }}
 	qGroupInfoPool      = typedsync.Pool[Item]{New: func() Item { return new(QGroupInfo) }}
 	qGroupLimitPool     = typedsync.Pool[Item]{New: func() Item { return new(QGroupLimit) }}
+	qGroupRelationPool  = typedsync.Pool[Item]{New: func() Item { return new(QGroupRelation) }}
 	qGroupStatusPool    = typedsync.Pool[Item]{New: func() Item { return new(QGroupStatus) }}
 	rootPool            = typedsync.Pool[Item]{New: func() Item { return new(Root) }}
 	rootRefPool         = typedsync.Pool[Item]{New: func() Item { return new(RootRef) }}
@@ -157,10 +163,12 @@ var gotype2pool = map[reflect.Type]*typedsync.Pool[Item]{
 	freeSpaceHeaderType: &freeSpaceHeaderPool,
 	freeSpaceInfoType:   &freeSpaceInfoPool,
 	inodeType:           &inodePool,
+	inodeExtRefsType:    &inodeExtRefsPool,
 	inodeRefsType:       &inodeRefsPool,
 	metadataType:        &metadataPool,
 	qGroupInfoType:      &qGroupInfoPool,
 	qGroupLimitType:     &qGroupLimitPool,
+	qGroupRelationType:  &qGroupRelationPool,
 	qGroupStatusType:    &qGroupStatusPool,
 	rootType:            &rootPool,
 	rootRefType:         &rootRefPool,
@@ -184,10 +192,12 @@ func (*FreeSpaceBitmap) isItem() {}
 func (*FreeSpaceHeader) isItem() {}
 func (*FreeSpaceInfo) isItem()   {}
 func (*Inode) isItem()           {}
+func (*InodeExtRefs) isItem()    {}
 func (*InodeRefs) isItem()       {}
 func (*Metadata) isItem()        {}
 func (*QGroupInfo) isItem()      {}
 func (*QGroupLimit) isItem()     {}
+func (*QGroupRelation) isItem()  {}
 func (*QGroupStatus) isItem()    {}
 func (*Root) isItem()            {}
 func (*RootRef) isItem()         {}
@@ -207,6 +217,7 @@ func (o *FreeSpaceInfo) Free()   { *o = FreeSpaceInfo{}; freeSpaceInfoPool.Put(o
 func (o *Inode) Free()           { *o = Inode{}; inodePool.Put(o) }
 func (o *QGroupInfo) Free()      { *o = QGroupInfo{}; qGroupInfoPool.Put(o) }
 func (o *QGroupLimit) Free()     { *o = QGroupLimit{}; qGroupLimitPool.Put(o) }
+func (o *QGroupRelation) Free()  { *o = QGroupRelation{}; qGroupRelationPool.Put(o) }
 func (o *QGroupStatus) Free()    { *o = QGroupStatus{}; qGroupStatusPool.Put(o) }
 func (o *Root) Free()            { *o = Root{}; rootPool.Put(o) }
 func (o *SharedDataRef) Free()   { *o = SharedDataRef{}; sharedDataRefPool.Put(o) }
@@ -225,6 +236,7 @@ func (o FreeSpaceInfo) Clone() FreeSpaceInfo     { return o }
 func (o Inode) Clone() Inode                     { return o }
 func (o QGroupInfo) Clone() QGroupInfo           { return o }
 func (o QGroupLimit) Clone() QGroupLimit         { return o }
+func (o QGroupRelation) Clone() QGroupRelation   { return o }
 func (o QGroupStatus) Clone() QGroupStatus       { return o }
 func (o Root) Clone() Root                       { return o }
 func (o SharedDataRef) Clone() SharedDataRef     { return o }
@@ -290,6 +302,11 @@ func (o *FreeSpaceInfo) CloneItem() Item {
 	return ret
 }
 func (o *Inode) CloneItem() Item { ret, _ := inodePool.Get(); *(ret.(*Inode)) = o.Clone(); return ret }
+func (o *InodeExtRefs) CloneItem() Item {
+	ret, _ := inodeExtRefsPool.Get()
+	*(ret.(*InodeExtRefs)) = o.Clone()
+	return ret
+}
 func (o *InodeRefs) CloneItem() Item {
 	ret, _ := inodeRefsPool.Get()
 	*(ret.(*InodeRefs)) = o.Clone()
@@ -310,6 +327,11 @@ func (o *QGroupLimit) CloneItem() Item {
 	*(ret.(*QGroupLimit)) = o.Clone()
 	return ret
 }
+func (o *QGroupRelation) CloneItem() Item {
+	ret, _ := qGroupRelationPool.Get()
+	*(ret.(*QGroupRelation)) = o.Clone()
+	return ret
+}
 func (o *QGroupStatus) CloneItem() Item {
 	ret, _ := qGroupStatusPool.Get()
 	*(ret.(*QGroupStatus)) = o.Clone()
@@ -349,10 +371,12 @@ var (
 	_ Item = (*FreeSpaceHeader)(nil)
 	_ Item = (*FreeSpaceInfo)(nil)
 	_ Item = (*Inode)(nil)
+	_ Item = (*InodeExtRefs)(nil)
 	_ Item = (*InodeRefs)(nil)
 	_ Item = (*Metadata)(nil)
 	_ Item = (*QGroupInfo)(nil)
 	_ Item = (*QGroupLimit)(nil)
+	_ Item = (*QGroupRelation)(nil)
 	_ Item = (*QGroupStatus)(nil)
 	_ Item = (*Root)(nil)
 	_ Item = (*RootRef)(nil)
@@ -377,10 +401,12 @@ var (
 	_ interface{ Clone() FreeSpaceHeader } = FreeSpaceHeader{}
 	_ interface{ Clone() FreeSpaceInfo }   = FreeSpaceInfo{}
 	_ interface{ Clone() Inode }           = Inode{}
+	_ interface{ Clone() InodeExtRefs }    = InodeExtRefs{}
 	_ interface{ Clone() InodeRefs }       = InodeRefs{}
 	_ interface{ Clone() Metadata }        = Metadata{}
 	_ interface{ Clone() QGroupInfo }      = QGroupInfo{}
 	_ interface{ Clone() QGroupLimit }     = QGroupLimit{}
+	_ interface{ Clone() QGroupRelation }  = QGroupRelation{}
 	_ interface{ Clone() QGroupStatus }    = QGroupStatus{}
 	_ interface{ Clone() Root }            = Root{}
 	_ interface{ Clone() RootRef }         = RootRef{}
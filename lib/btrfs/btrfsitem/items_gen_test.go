@@ -0,0 +1,42 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsitem
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+)
+
+// intentionallyUnhandled is the list of item types that are known
+// (have a name in btrfsprim) but that deliberately don't have an
+// entry in keytype2gotype, and why.
+var intentionallyUnhandled = map[btrfsprim.ItemType]string{
+	UNTYPED_KEY: "dispatched on the object ID by untypedObjID2gotype",
+}
+
+// TestItemTypesHandled asserts that every named item type has a
+// decoder (so that it doesn't silently decode as an Error item), and
+// that every decoder has a pool.
+func TestItemTypesHandled(t *testing.T) {
+	t.Parallel()
+	for i := 0; i <= int(btrfsprim.MAX_KEY); i++ {
+		typ := btrfsprim.ItemType(i)
+		if _, err := strconv.Atoi(typ.String()); err == nil {
+			// Not a named item type.
+			continue
+		}
+		gotyp, handled := keytype2gotype[typ]
+		_, unhandled := intentionallyUnhandled[typ]
+		assert.Truef(t, handled != unhandled,
+			"item type %v: must have either a decoder or an intentionallyUnhandled entry (but not both)", typ)
+		if handled {
+			assert.Containsf(t, gotype2pool, gotyp, "item type %v: decoder %v has no pool", typ, gotyp)
+		}
+	}
+}
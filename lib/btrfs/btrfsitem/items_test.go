@@ -1,4 +1,4 @@
-// Copyright (C) 2022  Luke Shumaker <lukeshu@lukeshu.com>
+// Copyright (C) 2022-2023  Luke Shumaker <lukeshu@lukeshu.com>
 //
 // SPDX-License-Identifier: GPL-2.0-or-later
 
@@ -63,3 +63,17 @@ func FuzzRoundTrip(f *testing.F) {
 		require.Equal(t, string(itemInDat), string(itemOutDat), "binstruct.Marshal(item)")
 	})
 }
+
+func TestUnmarshalUntypedUnknown(t *testing.T) {
+	t.Parallel()
+	dat := []byte("some future feature")
+	key := btrfsprim.Key{
+		ObjectID: 12345,
+		ItemType: btrfsitem.UNTYPED_KEY,
+		Offset:   0,
+	}
+	item := btrfsitem.UnmarshalItem(key, btrfssum.TYPE_CRC32, dat)
+	require.IsType(t, &btrfsitem.UntypedUnknown{}, item)
+	require.Equal(t, btrfsprim.ObjID(12345), item.(*btrfsitem.UntypedUnknown).ObjID)
+	require.Equal(t, dat, item.(*btrfsitem.UntypedUnknown).Dat)
+}
@@ -23,6 +23,7 @@ const (
 	FREE_SPACE_BITMAP_KEY    ItemType = 200
 	FREE_SPACE_EXTENT_KEY    ItemType = 199
 	FREE_SPACE_INFO_KEY      ItemType = 198
+	INODE_EXTREF_KEY         ItemType = 13
 	INODE_ITEM_KEY           ItemType = 1
 	INODE_REF_KEY            ItemType = 12
 	METADATA_ITEM_KEY        ItemType = 169
@@ -74,6 +75,8 @@ func (t ItemType) String() string {
 		return "FREE_SPACE_EXTENT"
 	case FREE_SPACE_INFO_KEY:
 		return "FREE_SPACE_INFO"
+	case INODE_EXTREF_KEY:
+		return "INODE_EXTREF"
 	case INODE_ITEM_KEY:
 		return "INODE_ITEM"
 	case INODE_REF_KEY:
@@ -100,4 +100,19 @@ func (a Key) Compare(b Key) int {
 	return containers.NativeCompare(a.Offset, b.Offset)
 }
 
+// CompareWithoutOffset is like Compare, but ignores .Offset; it only
+// compares .ObjectID and .ItemType.
+func (a Key) CompareWithoutOffset(b Key) int {
+	if d := containers.NativeCompare(a.ObjectID, b.ObjectID); d != 0 {
+		return d
+	}
+	return containers.NativeCompare(a.ItemType, b.ItemType)
+}
+
+// CompareObjectID is like Compare, but ignores .ItemType and
+// .Offset; it only compares .ObjectID.
+func (a Key) CompareObjectID(b Key) int {
+	return containers.NativeCompare(a.ObjectID, b.ObjectID)
+}
+
 var _ containers.Ordered[Key] = Key{}
@@ -57,3 +57,36 @@ func TestKey(t *testing.T) {
 	mmEq(t, k(18446744073709551615, 0, 0), k(18446744073709551614, 255, 18446744073709551615))
 	mmEq(t, k(0, 0, 0), k(0, 0, 0))
 }
+
+func TestKeyCompareWithoutOffset(t *testing.T) {
+	t.Parallel()
+
+	// equal objectid and type; offset is ignored
+	assert.Equal(t, 0, k(5, 1, 0).CompareWithoutOffset(k(5, 1, 100)))
+	assert.Equal(t, 0, k(5, 1, 100).CompareWithoutOffset(k(5, 1, 0)))
+
+	// equal objectid, different type
+	assert.Equal(t, -1, k(5, 1, 100).CompareWithoutOffset(k(5, 2, 0)))
+	assert.Equal(t, 1, k(5, 2, 0).CompareWithoutOffset(k(5, 1, 100)))
+
+	// objectid takes precedence over type
+	assert.Equal(t, -1, k(4, 255, 0).CompareWithoutOffset(k(5, 0, 0)))
+	assert.Equal(t, 1, k(6, 0, 0).CompareWithoutOffset(k(5, 255, 0)))
+
+	// the receiver is not mutated
+	key := k(5, 1, 100)
+	_ = key.CompareWithoutOffset(k(5, 1, 0))
+	eq(t, key, k(5, 1, 100))
+}
+
+func TestKeyCompareObjectID(t *testing.T) {
+	t.Parallel()
+
+	// equal objectid; type and offset are ignored
+	assert.Equal(t, 0, k(5, 1, 0).CompareObjectID(k(5, 2, 100)))
+	assert.Equal(t, 0, k(5, 2, 100).CompareObjectID(k(5, 1, 0)))
+
+	// different objectid
+	assert.Equal(t, -1, k(4, 255, 100).CompareObjectID(k(5, 0, 0)))
+	assert.Equal(t, 1, k(6, 0, 0).CompareObjectID(k(5, 255, 100)))
+}
@@ -20,6 +20,23 @@ type Time struct {
 	binstruct.End `bin:"off=0xc"`
 }
 
+// TimeFromStd converts a Go time.Time to the btrfs on-disk
+// representation, preserving nanosecond precision.
+func TimeFromStd(t time.Time) Time {
+	return Time{
+		Sec:  t.Unix(),
+		NSec: uint32(t.Nanosecond()),
+	}
+}
+
+// ToStd converts the btrfs on-disk representation to a Go time.Time,
+// preserving nanosecond precision.
 func (t Time) ToStd() time.Time {
 	return time.Unix(t.Sec, int64(t.NSec))
 }
+
+// String formats the time as RFC 3339 in UTC, with as many digits of
+// fractional seconds as are needed to be exact.
+func (t Time) String() string {
+	return t.ToStd().UTC().Format(time.RFC3339Nano)
+}
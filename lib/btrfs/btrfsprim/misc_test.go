@@ -0,0 +1,39 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsprim_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+)
+
+func TestTimeRoundTrip(t *testing.T) {
+	t.Parallel()
+	std := time.Date(2023, time.March, 14, 15, 9, 26, 535897932, time.UTC)
+
+	btime := btrfsprim.TimeFromStd(std)
+	assert.Equal(t, btrfsprim.Time{Sec: 1678806566, NSec: 535897932}, btime)
+	assert.True(t, std.Equal(btime.ToStd()))
+	assert.Equal(t, "2023-03-14T15:09:26.535897932Z", btime.String())
+
+	dat, err := binstruct.Marshal(btime)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x26, 0x8e, 0x10, 0x64, 0x00, 0x00, 0x00, 0x00, // sec
+		0x4c, 0x27, 0xf1, 0x1f, // nsec
+	}, dat)
+
+	var parsed btrfsprim.Time
+	n, err := binstruct.Unmarshal(dat, &parsed)
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+	assert.Equal(t, btime, parsed)
+}
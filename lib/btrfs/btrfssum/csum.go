@@ -7,12 +7,16 @@
 package btrfssum
 
 import (
+	"crypto/sha256"
 	"encoding"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"hash/crc32"
 
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+
 	"git.lukeshu.com/btrfs-progs-ng/lib/fmtutil"
 )
 
@@ -43,6 +47,9 @@ func (csum *CSum) UnmarshalText(text []byte) error {
 	return err
 }
 
+// Fmt is like String, but only includes the bytes of the checksum
+// that are meaningful for the given checksum type (e.g. 4 bytes for
+// crc32c), rather than the full 32-byte field.
 func (csum CSum) Fmt(typ CSumType) string {
 	return hex.EncodeToString(csum[:typ.Size()])
 }
@@ -88,21 +95,28 @@ func (typ CSumType) Size() int {
 	return len(CSum{})
 }
 
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Sum computes the checksum of `data` using the algorithm `typ`.
+// Checksums that are shorter than a CSum are zero-padded; integer
+// checksums (crc32c and xxhash64) are stored little-endian, as they
+// are on disk.
+//
+// An error is returned if `typ` is not a checksum type that we know
+// about.
 func (typ CSumType) Sum(data []byte) (CSum, error) {
+	var ret CSum
 	switch typ {
 	case TYPE_CRC32:
-		crc := crc32.Update(0, crc32.MakeTable(crc32.Castagnoli), data)
-
-		var ret CSum
-		binary.LittleEndian.PutUint32(ret[:], crc)
-		return ret, nil
+		binary.LittleEndian.PutUint32(ret[:], crc32.Update(0, crc32cTable, data))
 	case TYPE_XXHASH:
-		panic("TODO: XXHASH not yet implemented")
+		binary.LittleEndian.PutUint64(ret[:], xxhash.Sum64(data))
 	case TYPE_SHA256:
-		panic("TODO: SHA256 not yet implemented")
+		ret = sha256.Sum256(data)
 	case TYPE_BLAKE2:
-		panic("TODO: BLAKE2 not yet implemented")
+		ret = blake2b.Sum256(data)
 	default:
 		return CSum{}, fmt.Errorf("unknown checksum type: %v", typ)
 	}
+	return ret, nil
 }
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
 )
@@ -37,3 +38,50 @@ func TestCSumFormat(t *testing.T) {
 		})
 	}
 }
+
+func TestCSumFmt(t *testing.T) {
+	t.Parallel()
+	csum := btrfssum.CSum{0xbd, 0x7b, 0x41, 0xf4, 0x01, 0x02, 0x03, 0x04}
+	assert.Equal(t, "bd7b41f4", csum.Fmt(btrfssum.TYPE_CRC32))
+	assert.Equal(t, "bd7b41f401020304", csum.Fmt(btrfssum.TYPE_XXHASH))
+	assert.Equal(t, csum.String(), csum.Fmt(btrfssum.TYPE_SHA256))
+	assert.Equal(t, csum.String(), csum.Fmt(btrfssum.CSumType(0xffff)))
+}
+
+func TestCSumTypeSum(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		Type   btrfssum.CSumType
+		Input  string
+		Output string
+	}
+	testcases := map[string]TestCase{
+		"crc32c-empty":   {Type: btrfssum.TYPE_CRC32, Input: "", Output: "00000000"},
+		"crc32c":         {Type: btrfssum.TYPE_CRC32, Input: "123456789", Output: "839206e3"},
+		"xxhash64-empty": {Type: btrfssum.TYPE_XXHASH, Input: "", Output: "99e9d85137db46ef"},
+		"xxhash64":       {Type: btrfssum.TYPE_XXHASH, Input: "abc", Output: "990977adf52cbc44"},
+		"sha256-empty":   {Type: btrfssum.TYPE_SHA256, Input: "", Output: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		"sha256":         {Type: btrfssum.TYPE_SHA256, Input: "abc", Output: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		"blake2b-empty":  {Type: btrfssum.TYPE_BLAKE2, Input: "", Output: "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		"blake2b":        {Type: btrfssum.TYPE_BLAKE2, Input: "abc", Output: "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			sum, err := tc.Type.Sum([]byte(tc.Input))
+			require.NoError(t, err)
+			assert.Equal(t, tc.Output, sum.Fmt(tc.Type))
+			// Short checksums are zero-padded.
+			for _, b := range sum[tc.Type.Size():] {
+				assert.Zero(t, b)
+			}
+		})
+	}
+}
+
+func TestCSumTypeSumUnknown(t *testing.T) {
+	t.Parallel()
+	_, err := btrfssum.CSumType(4).Sum([]byte("abc"))
+	assert.EqualError(t, err, "unknown checksum type: 4")
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package btrfstest provides fakes for testing code that reads btrfs
+// filesystems, without having to build actual nodes.
+package btrfstest
+
+import (
+	"context"
+	"fmt"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+// ItemsFS is a btrfs.ReadableFS whose trees are flat lists of items,
+// rather than actual nodes.
+//
+// Everything other than the trees is delegated to the embedded
+// ReadableFS.  It may be nil, in which case the superblock is a
+// minimal one (CRC32 checksums, and everything else zero), and the
+// logical address space is unreadable; anything else panics.
+type ItemsFS struct {
+	btrfs.ReadableFS
+
+	// Trees are the items in each tree, in the order that they
+	// should be visited; trees that aren't listed don't exist.
+	Trees map[btrfsprim.ObjID][]btrfstree.Item
+	// TreeErrs are errors that a TreeRange of the tree returns
+	// after visiting all of its items, as if the tree were broken
+	// partway through.
+	TreeErrs map[btrfsprim.ObjID]error
+}
+
+var _ btrfs.ReadableFS = ItemsFS{}
+
+func (fs ItemsFS) Superblock() (*btrfstree.Superblock, error) {
+	if fs.ReadableFS != nil {
+		return fs.ReadableFS.Superblock()
+	}
+	return &btrfstree.Superblock{ChecksumType: btrfssum.TYPE_CRC32}, nil
+}
+
+func (fs ItemsFS) ReadAt(p []byte, laddr btrfsvol.LogicalAddr) (int, error) {
+	if fs.ReadableFS != nil {
+		return fs.ReadableFS.ReadAt(p, laddr)
+	}
+	return 0, fmt.Errorf("laddr=%v: unreadable", laddr)
+}
+
+func (fs ItemsFS) ForrestLookup(_ context.Context, treeID btrfsprim.ObjID) (btrfstree.Tree, error) {
+	items, ok := fs.Trees[treeID]
+	if !ok {
+		return nil, fmt.Errorf("tree %v: %w", treeID, btrfstree.ErrNoTree)
+	}
+	return ItemsTree{Items: items, Err: fs.TreeErrs[treeID]}, nil
+}
+
+// ItemsTree is a btrfstree.Tree that implements lookups, searches,
+// and ranges (but not walks) over a flat list of items.
+type ItemsTree struct {
+	btrfstree.Tree
+	Items []btrfstree.Item
+	// Err is returned by TreeRange after visiting all of the
+	// items.
+	Err error
+}
+
+func (tree ItemsTree) TreeLookup(ctx context.Context, key btrfsprim.Key) (btrfstree.Item, error) {
+	return tree.TreeSearch(ctx, btrfstree.SearchExactKey(key))
+}
+
+func (tree ItemsTree) TreeSearch(_ context.Context, search btrfstree.TreeSearcher) (btrfstree.Item, error) {
+	for _, item := range tree.Items {
+		if search.Search(item.Key, item.BodySize) == 0 {
+			return item, nil
+		}
+	}
+	return btrfstree.Item{}, fmt.Errorf("%v: %w", search, btrfstree.ErrNoItem)
+}
+
+func (tree ItemsTree) TreeRange(_ context.Context, handleFn func(btrfstree.Item) bool) error {
+	for _, item := range tree.Items {
+		if !handleFn(item) {
+			return nil
+		}
+	}
+	return tree.Err
+}
+
+func (tree ItemsTree) TreeSubrange(_ context.Context, min int, search btrfstree.TreeSearcher, handleFn func(btrfstree.Item) bool) error {
+	cnt := 0
+	for _, item := range tree.Items {
+		if search.Search(item.Key, item.BodySize) != 0 {
+			continue
+		}
+		cnt++
+		if !handleFn(item) {
+			break
+		}
+	}
+	if cnt < min {
+		return fmt.Errorf("%v: %w", search, btrfstree.ErrNoItem)
+	}
+	return nil
+}
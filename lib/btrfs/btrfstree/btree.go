@@ -84,6 +84,11 @@ type Tree interface {
 	// If the Tree is valid, then everything is walked in key-order; but
 	// if the Tree is broken, then ordering is not guaranteed.
 	//
+	// If a key-pointer points at a node that is already on the
+	// path (the tree is broken and has a loop in it), then that is
+	// reported to cbs.BadNode with a nil node, and is not recursed
+	// in to.
+	//
 	// Canceling the Context causes TreeWalk to return early; no values
 	// from the Context are used.
 	//
@@ -6,8 +6,10 @@ package btrfstree
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
@@ -26,8 +28,8 @@ type SearchOffset int8
 const (
 	OffsetAny SearchOffset = iota
 	OffsetExact
-	OffsetRange // .Search behaves same as OffsetAny (TODO?)
-	OffsetName  // .Search behaves same as OffsetAny
+	OffsetRange // the half-open range [.OffsetLow, .OffsetHigh)
+	OffsetName  // see .OffsetName
 )
 
 // Search is a fairly generic and reusable implementation of
@@ -42,7 +44,13 @@ type Search struct {
 	OffsetMatching SearchOffset
 	OffsetLow      uint64 // only for .OffsetMatching==OffsetExact or .OffsetMatching==OffsetRange
 	OffsetHigh     uint64 // only for .OffsetMatching==OffsetRange
-	OffsetName     string // only for .OffsetMatching==OffsetName
+	// OffsetName is only for .OffsetMatching==OffsetName.  For
+	// DIR_ITEM the offset is the hash of the name, so .Search
+	// matches on the hash.  For other item types (DIR_INDEX) the
+	// name can't be derived from the key, so .Search behaves the
+	// same as OffsetAny, and the caller must use .MatchItem to
+	// check the item body.
+	OffsetName string
 }
 
 var (
@@ -127,15 +135,45 @@ func (o Search) Search(k btrfsprim.Key, _ uint32) int {
 	}
 
 	switch o.OffsetMatching {
-	case OffsetAny, OffsetRange, OffsetName:
+	case OffsetAny:
 		return 0
 	case OffsetExact:
 		return containers.NativeCompare(o.OffsetLow, k.Offset)
+	case OffsetRange:
+		switch {
+		case k.Offset < o.OffsetLow:
+			return 1
+		case k.Offset >= o.OffsetHigh:
+			return -1
+		default:
+			return 0
+		}
+	case OffsetName:
+		if o.ItemType == btrfsprim.DIR_ITEM_KEY {
+			return containers.NativeCompare(btrfsitem.NameHash([]byte(o.OffsetName)), k.Offset)
+		}
+		return 0
 	default:
 		panic(fmt.Errorf("should not happen: OffsetMatching=%#v", o.OffsetMatching))
 	}
 }
 
+// MatchItem returns whether an item that .Search returned 0 for
+// actually matches; this is only ever false for OffsetName searches,
+// which need to inspect the item body to compare the name.  Items
+// that fail to decode do not match an OffsetName search.
+func (o Search) MatchItem(item Item) bool {
+	if o.ItemTypeMatching != ItemTypeExact || o.OffsetMatching != OffsetName {
+		return true
+	}
+	switch body := item.Body.(type) {
+	case *btrfsitem.DirEntry:
+		return string(body.Name) == o.OffsetName
+	default:
+		return false
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 // SearchObject returns a Search that searches all items belonging to
@@ -147,6 +185,35 @@ func SearchObject(objID btrfsprim.ObjID) Search {
 	}
 }
 
+// SearchObjectIDType returns a Search that searches all items of a
+// given type belonging to a given object.
+func SearchObjectIDType(objID btrfsprim.ObjID, typ btrfsprim.ItemType) Search {
+	return Search{
+		ObjectID: objID,
+
+		ItemTypeMatching: ItemTypeExact,
+		ItemType:         typ,
+
+		OffsetMatching: OffsetAny,
+	}
+}
+
+// SearchOffsetRange returns a Search that searches all items of a
+// given type belonging to a given object, with offsets in the
+// half-open range [lo, hi).
+func SearchOffsetRange(objID btrfsprim.ObjID, typ btrfsprim.ItemType, lo, hi uint64) Search {
+	return Search{
+		ObjectID: objID,
+
+		ItemTypeMatching: ItemTypeExact,
+		ItemType:         typ,
+
+		OffsetMatching: OffsetRange,
+		OffsetLow:      lo,
+		OffsetHigh:     hi,
+	}
+}
+
 // SearchExactKey returns a Search that searches for the exact key.
 func SearchExactKey(k btrfsprim.Key) Search {
 	return Search{
@@ -173,6 +240,36 @@ func SearchRootItem(treeID btrfsprim.ObjID) Search {
 	}
 }
 
+type keyRangeSearcher struct {
+	min, max btrfsprim.Key
+}
+
+func (s keyRangeSearcher) String() string {
+	return fmt.Sprintf("keys in [%v,%v]", s.min, s.max)
+}
+
+func (s keyRangeSearcher) Search(key btrfsprim.Key, _ uint32) int {
+	switch {
+	case key.Compare(s.min) < 0:
+		return 1
+	case key.Compare(s.max) > 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// SearchKeyRange returns a TreeSearcher that searches for all items
+// with keys in the closed range [min, max].  Passing it to
+// Tree.TreeSubrange streams the range without reading the rest of
+// the tree or holding the whole range in memory.
+func SearchKeyRange(min, max btrfsprim.Key) TreeSearcher {
+	return keyRangeSearcher{
+		min: min,
+		max: max,
+	}
+}
+
 type csumSearcher struct {
 	laddr   btrfsvol.LogicalAddr
 	algSize int
@@ -187,6 +284,14 @@ func (s csumSearcher) Search(key btrfsprim.Key, size uint32) int {
 		return d
 	}
 	itemBeg := btrfsvol.LogicalAddr(key.Offset)
+	if size == math.MaxUint32 {
+		// A key-pointer, which we can only compare as a
+		// single point; if we instead treated it as
+		// potentially containing every address after it,
+		// then the search would always descend in to the
+		// left-most subtree.
+		return containers.NativeCompare(s.laddr, itemBeg)
+	}
 	numSums := int64(size) / int64(s.algSize)
 	itemEnd := itemBeg + btrfsvol.LogicalAddr(numSums*btrfssum.BlockSize)
 	switch {
@@ -207,3 +312,54 @@ func SearchCSum(laddr btrfsvol.LogicalAddr, algSize int) TreeSearcher {
 		algSize: algSize,
 	}
 }
+
+type csumRangeSearcher struct {
+	beg, end btrfsvol.LogicalAddr
+	algSize  int
+}
+
+func (s csumRangeSearcher) String() string {
+	return fmt.Sprintf("csums for laddr=[%v,%v)", s.beg, s.end)
+}
+
+func (s csumRangeSearcher) Search(key btrfsprim.Key, size uint32) int {
+	if d := containers.NativeCompare(btrfsprim.EXTENT_CSUM_OBJECTID, key.ObjectID); d != 0 {
+		return d
+	}
+	if d := containers.NativeCompare(btrfsprim.EXTENT_CSUM_KEY, key.ItemType); d != 0 {
+		return d
+	}
+	itemBeg := btrfsvol.LogicalAddr(key.Offset)
+	if size == math.MaxUint32 {
+		// A key-pointer; see csumSearcher.Search.
+		switch {
+		case itemBeg < s.beg:
+			return 1
+		case s.end <= itemBeg:
+			return -1
+		default:
+			return 0
+		}
+	}
+	numSums := int64(size) / int64(s.algSize)
+	itemEnd := itemBeg + btrfsvol.LogicalAddr(numSums*btrfssum.BlockSize)
+	switch {
+	case itemEnd <= s.beg:
+		return 1
+	case s.end <= itemBeg:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// SearchCSumRange returns a TreeSearcher that searches for the
+// csum-runs containing any of the csums for the LogicalAddresses in
+// the half-open range [beg, end).
+func SearchCSumRange(beg, end btrfsvol.LogicalAddr, algSize int) TreeSearcher {
+	return csumRangeSearcher{
+		beg:     beg,
+		end:     end,
+		algSize: algSize,
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfstree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+func TestSearchHelpers(t *testing.T) {
+	t.Parallel()
+	key := func(objID btrfsprim.ObjID, typ btrfsprim.ItemType, off uint64) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: objID, ItemType: typ, Offset: off}
+	}
+	type TestCase struct {
+		Search btrfstree.Search
+		ExpStr string
+		// ExpSearch maps each key to the expected result of
+		// .Search.
+		ExpSearch map[btrfsprim.Key]int
+	}
+	testcases := map[string]TestCase{
+		"object": {
+			Search: btrfstree.SearchObject(256),
+			ExpStr: "(256 ? ?)",
+			ExpSearch: map[btrfsprim.Key]int{
+				key(255, btrfsprim.MAX_KEY, 100):      1,
+				key(256, 0, 0):                        0,
+				key(256, btrfsprim.INODE_ITEM_KEY, 0): 0,
+				key(256, btrfsprim.MAX_KEY, 100):      0,
+				key(257, 0, 0):                        -1,
+				key(257, btrfsprim.INODE_ITEM_KEY, 0): -1,
+			},
+		},
+		"object-type": {
+			Search: btrfstree.SearchObjectIDType(256, btrfsprim.INODE_REF_KEY),
+			ExpStr: "(256 INODE_REF ?)",
+			ExpSearch: map[btrfsprim.Key]int{
+				key(255, btrfsprim.INODE_REF_KEY, 0):   1,
+				key(256, btrfsprim.INODE_ITEM_KEY, 0):  1,
+				key(256, btrfsprim.INODE_REF_KEY, 0):   0,
+				key(256, btrfsprim.INODE_REF_KEY, 256): 0,
+				key(256, btrfsprim.XATTR_ITEM_KEY, 0):  -1,
+				key(257, btrfsprim.INODE_REF_KEY, 0):   -1,
+			},
+		},
+		"offset-range": {
+			Search: btrfstree.SearchOffsetRange(256, btrfsprim.EXTENT_DATA_KEY, 4096, 8192),
+			ExpStr: "(256 EXTENT_DATA 4096-8192)",
+			ExpSearch: map[btrfsprim.Key]int{
+				key(255, btrfsprim.EXTENT_DATA_KEY, 4096): 1,
+				key(256, btrfsprim.INODE_ITEM_KEY, 4096):  1,
+				key(256, btrfsprim.EXTENT_DATA_KEY, 0):    1,
+				key(256, btrfsprim.EXTENT_DATA_KEY, 4095): 1,
+				key(256, btrfsprim.EXTENT_DATA_KEY, 4096): 0,
+				key(256, btrfsprim.EXTENT_DATA_KEY, 8191): 0,
+				key(256, btrfsprim.EXTENT_DATA_KEY, 8192): -1,
+				key(256, btrfsprim.EXTENT_CSUM_KEY, 0):    -1,
+				key(257, btrfsprim.EXTENT_DATA_KEY, 4096): -1,
+			},
+		},
+		"offset-range-empty": {
+			Search: btrfstree.SearchOffsetRange(256, btrfsprim.EXTENT_DATA_KEY, 4096, 4096),
+			ExpStr: "(256 EXTENT_DATA 4096-4096)",
+			ExpSearch: map[btrfsprim.Key]int{
+				key(256, btrfsprim.EXTENT_DATA_KEY, 4095): 1,
+				key(256, btrfsprim.EXTENT_DATA_KEY, 4096): -1,
+			},
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.ExpStr, tc.Search.String())
+			for k, exp := range tc.ExpSearch {
+				assert.Equal(t, exp, tc.Search.Search(k, 0), "key=%v", k)
+			}
+		})
+	}
+}
+
+func TestSearchOffsetRange(t *testing.T) {
+	t.Parallel()
+	search := btrfstree.Search{
+		ObjectID:         256,
+		ItemTypeMatching: btrfstree.ItemTypeExact,
+		ItemType:         btrfsprim.EXTENT_DATA_KEY,
+		OffsetMatching:   btrfstree.OffsetRange,
+		OffsetLow:        4096,
+		OffsetHigh:       8192,
+	}
+	key := func(off uint64) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: 256, ItemType: btrfsprim.EXTENT_DATA_KEY, Offset: off}
+	}
+	assert.Equal(t, 1, search.Search(key(0), 0))
+	assert.Equal(t, 0, search.Search(key(4096), 0))
+	assert.Equal(t, 0, search.Search(key(8191), 0))
+	assert.Equal(t, -1, search.Search(key(8192), 0))
+}
+
+func TestSearchOffsetName(t *testing.T) {
+	t.Parallel()
+	name := []byte("foo")
+	entry := func(name string) btrfstree.Item {
+		return btrfstree.Item{Body: &btrfsitem.DirEntry{Name: []byte(name)}}
+	}
+
+	dirItem := btrfstree.Search{
+		ObjectID:         256,
+		ItemTypeMatching: btrfstree.ItemTypeExact,
+		ItemType:         btrfsprim.DIR_ITEM_KEY,
+		OffsetMatching:   btrfstree.OffsetName,
+		OffsetName:       string(name),
+	}
+	hash := btrfsitem.NameHash(name)
+	assert.Equal(t, 0, dirItem.Search(btrfsprim.Key{ObjectID: 256, ItemType: btrfsprim.DIR_ITEM_KEY, Offset: hash}, 0))
+	assert.NotEqual(t, 0, dirItem.Search(btrfsprim.Key{ObjectID: 256, ItemType: btrfsprim.DIR_ITEM_KEY, Offset: hash + 1}, 0))
+	assert.True(t, dirItem.MatchItem(entry("foo")))
+	assert.False(t, dirItem.MatchItem(entry("bar")))
+
+	dirIndex := dirItem
+	dirIndex.ItemType = btrfsprim.DIR_INDEX_KEY
+	assert.Equal(t, 0, dirIndex.Search(btrfsprim.Key{ObjectID: 256, ItemType: btrfsprim.DIR_INDEX_KEY, Offset: 2}, 0))
+	assert.True(t, dirIndex.MatchItem(entry("foo")))
+	assert.False(t, dirIndex.MatchItem(entry("bar")))
+}
@@ -58,6 +58,16 @@ func (tree *RawTree) walk(ctx context.Context, sb Superblock, path Path, cbs Tre
 	if !ok {
 		return
 	}
+	// The level checks in nodeExp normally keep a corrupt tree
+	// from sending us in circles, but not if .BadNode says to
+	// process a node that failed them; so also refuse to descend
+	// in to a node that is already on the path.
+	if path.Parent().hasNode(nodeAddr) {
+		if cbs.BadNode != nil {
+			cbs.BadNode(path, nil, fmt.Errorf("loop detected: node@%v is its own ancestor", nodeAddr))
+		}
+		return
+	}
 	node, err := tree.Forrest.NodeSource.AcquireNode(ctx, nodeAddr, nodeExp)
 	defer tree.Forrest.NodeSource.ReleaseNode(node)
 	if ctx.Err() != nil {
@@ -165,6 +175,21 @@ func searchKP(haystack []KeyPointer, searchFn func(key btrfsprim.Key, size uint3
 	return KeyPointer{}, false
 }
 
+// searchKPRangeStart is like searchKP, but for finding where to start
+// iterating over a range of items rather than for finding a single
+// item: where searchKP would return the left-most member for which
+// `searchFn(member.Key, math.MaxUint32) == 0`, the member before that
+// (if there is one) is returned instead, because items before the
+// first matching member's key may also be in the range.
+func searchKPRangeStart(haystack []KeyPointer, searchFn func(key btrfsprim.Key, size uint32) int) (_ KeyPointer, ok bool) {
+	if leftZero, ok := slices.SearchLowest(haystack, func(kp KeyPointer) int {
+		return searchFn(kp.Key, math.MaxUint32)
+	}); ok {
+		return haystack[slices.Max(leftZero-1, 0)], true
+	}
+	return searchKP(haystack, searchFn)
+}
+
 // TreeSearch implements the 'Tree' interface.
 func (tree *RawTree) TreeSearch(ctx context.Context, searcher TreeSearcher) (Item, error) {
 	ctx, cancel := context.WithCancel(ctx)
@@ -257,7 +282,7 @@ func (tree *RawTree) TreeSubrange(ctx context.Context, min int, searcher TreeSea
 			if node.Head.Level == 0 {
 				return
 			}
-			kp, ok := searchKP(node.BodyInterior, searcher.Search)
+			kp, ok := searchKPRangeStart(node.BodyInterior, searcher.Search)
 			if !ok {
 				cancel()
 				return
@@ -273,7 +298,7 @@ func (tree *RawTree) TreeSubrange(ctx context.Context, min int, searcher TreeSea
 				cancel()
 				return false
 			}
-			if kp.Key.Compare(minKP) > 0 {
+			if kp.Key.Compare(minKP) < 0 {
 				return false
 			}
 			return true
@@ -0,0 +1,435 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfstree_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+// memNodeSource is a NodeSource of in-memory nodes.
+type memNodeSource struct {
+	sb    btrfstree.Superblock
+	nodes map[btrfsvol.LogicalAddr]*btrfstree.Node
+	// If lax, then nodes that don't meet the expectations are
+	// returned along with the error.
+	lax bool
+}
+
+func (src *memNodeSource) Superblock() (*btrfstree.Superblock, error) { return &src.sb, nil }
+
+func (src *memNodeSource) AcquireNode(_ context.Context, addr btrfsvol.LogicalAddr, exp btrfstree.NodeExpectations) (*btrfstree.Node, error) {
+	node, ok := src.nodes[addr]
+	if !ok {
+		return nil, fmt.Errorf("node@%v: no such node", addr)
+	}
+	if err := exp.Check(node); err != nil {
+		if src.lax {
+			return node, err
+		}
+		return nil, err
+	}
+	return node, nil
+}
+
+func (*memNodeSource) ReleaseNode(*btrfstree.Node) {}
+
+// buildMemTree builds a tree of `items` with BuildTree, and returns
+// a RawTree that reads it from memory, along with the nodes that make
+// it up (the root node is last).
+func buildMemTree(t *testing.T, sb btrfstree.Superblock, treeID btrfsprim.ObjID, items []btrfstree.Item) (*btrfstree.RawTree, []*btrfstree.Node) {
+	t.Helper()
+	src := &memNodeSource{
+		sb:    sb,
+		nodes: make(map[btrfsvol.LogicalAddr]*btrfstree.Node),
+	}
+	nextAddr := btrfsvol.LogicalAddr(0)
+	nodes, err := btrfstree.BuildTree(sb, btrfstree.NodeHeader{
+		Flags:      btrfstree.NodeWritten,
+		BackrefRev: btrfstree.MixedBackrefRev,
+		Generation: 1,
+		Owner:      treeID,
+	}, items, func() (btrfsvol.LogicalAddr, error) {
+		addr := nextAddr
+		nextAddr += btrfsvol.LogicalAddr(sb.NodeSize)
+		return addr, nil
+	})
+	require.NoError(t, err)
+	for _, node := range nodes {
+		src.nodes[node.Head.Addr] = node
+	}
+	root := nodes[len(nodes)-1]
+	return &btrfstree.RawTree{
+		Forrest: btrfstree.RawForrest{NodeSource: src},
+		TreeRoot: btrfstree.TreeRoot{
+			ID:         treeID,
+			RootNode:   root.Head.Addr,
+			Level:      root.Head.Level,
+			Generation: 1,
+		},
+	}, nodes
+}
+
+func TestRawTreeCSumSearch(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	const (
+		nodeSize = 4096
+		numItems = 20000
+		// Each item has the csums for 4 blocks (16KiB), and
+		// then there is a 48KiB gap before the next item.
+		itemStride = 64 * 1024
+		itemSize   = 4 * btrfssum.BlockSize
+		dataBeg    = btrfsvol.LogicalAddr(1024 * 1024)
+	)
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     nodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	algSize := sb.ChecksumType.Size()
+
+	items := make([]btrfstree.Item, numItems)
+	for i := range items {
+		laddr := dataBeg + btrfsvol.LogicalAddr(i*itemStride)
+		items[i] = btrfstree.Item{
+			Key: btrfsprim.Key{
+				ObjectID: btrfsprim.EXTENT_CSUM_OBJECTID,
+				ItemType: btrfsitem.EXTENT_CSUM_KEY,
+				Offset:   uint64(laddr),
+			},
+			Body: &btrfsitem.ExtentCSum{SumRun: btrfssum.SumRun[btrfsvol.LogicalAddr]{
+				ChecksumSize: algSize,
+				Addr:         laddr,
+				Sums:         btrfssum.ShortSum(make([]byte, algSize*itemSize/btrfssum.BlockSize)),
+			}},
+		}
+	}
+	tree, nodes := buildMemTree(t, sb, btrfsprim.CSUM_TREE_OBJECTID, items)
+	require.Equal(t, uint8(2), tree.TreeRoot.Level)
+
+	itemAddr := func(i int) btrfsvol.LogicalAddr {
+		return dataBeg + btrfsvol.LogicalAddr(i*itemStride)
+	}
+
+	t.Run("search", func(t *testing.T) {
+		t.Parallel()
+		type TestCase struct {
+			LAddr   btrfsvol.LogicalAddr
+			ExpItem int // -1 for none
+		}
+		testcases := map[string]TestCase{
+			"first":        {LAddr: itemAddr(0), ExpItem: 0},
+			"before-first": {LAddr: itemAddr(0) - 1, ExpItem: -1},
+			"middle":       {LAddr: itemAddr(12345) + 3*btrfssum.BlockSize, ExpItem: 12345},
+			"gap":          {LAddr: itemAddr(12345) + itemSize, ExpItem: -1},
+			"last":         {LAddr: itemAddr(numItems-1) + itemSize - 1, ExpItem: numItems - 1},
+			"after-last":   {LAddr: itemAddr(numItems-1) + itemSize, ExpItem: -1},
+		}
+		// Also every item that begins a node.
+		for _, node := range nodes {
+			if node.Head.Level == 0 && len(node.BodyLeaf) > 0 {
+				laddr := btrfsvol.LogicalAddr(node.BodyLeaf[0].Key.Offset)
+				i := int((laddr - dataBeg) / itemStride)
+				testcases[fmt.Sprintf("leaf-%v", node.Head.Addr)] = TestCase{LAddr: laddr + 1, ExpItem: i}
+			}
+		}
+		for tcName, tc := range testcases {
+			tc := tc
+			t.Run(tcName, func(t *testing.T) {
+				t.Parallel()
+				item, err := tree.TreeSearch(ctx, btrfstree.SearchCSum(tc.LAddr, algSize))
+				if tc.ExpItem < 0 {
+					assert.ErrorIs(t, err, btrfstree.ErrNoItem)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, items[tc.ExpItem].Key, item.Key)
+			})
+		}
+	})
+
+	t.Run("subrange", func(t *testing.T) {
+		t.Parallel()
+		type TestCase struct {
+			Lo, Hi         btrfsvol.LogicalAddr
+			ExpBeg, ExpEnd int
+		}
+		testcases := map[string]TestCase{
+			"all": {
+				Lo: 0, Hi: btrfsvol.LogicalAddr(1) << 62,
+				ExpBeg: 0, ExpEnd: numItems,
+			},
+			"many-leaves": {
+				Lo: itemAddr(1000), Hi: itemAddr(9000),
+				ExpBeg: 1000, ExpEnd: 9000,
+			},
+			"one": {
+				Lo: itemAddr(1000), Hi: itemAddr(1000) + 1,
+				ExpBeg: 1000, ExpEnd: 1001,
+			},
+			"none": {
+				Lo: itemAddr(1000) + 1, Hi: itemAddr(1001),
+				ExpBeg: 1001, ExpEnd: 1001,
+			},
+		}
+		// Also ranges that begin at the first item of a leaf,
+		// and so whose first item is in a different subtree
+		// than the item before it.
+		for _, node := range nodes {
+			if node.Head.Level == 0 && len(node.BodyLeaf) > 0 {
+				laddr := btrfsvol.LogicalAddr(node.BodyLeaf[0].Key.Offset)
+				i := int((laddr - dataBeg) / itemStride)
+				end := i + 500
+				if end > numItems {
+					end = numItems
+				}
+				testcases[fmt.Sprintf("leaf-%v", node.Head.Addr)] = TestCase{
+					Lo: laddr, Hi: itemAddr(end),
+					ExpBeg: i, ExpEnd: end,
+				}
+			}
+		}
+		for tcName, tc := range testcases {
+			tc := tc
+			t.Run(tcName, func(t *testing.T) {
+				t.Parallel()
+				var actKeys []btrfsprim.Key
+				err := tree.TreeSubrange(ctx, 0, btrfstree.Search{
+					ObjectID:         btrfsprim.EXTENT_CSUM_OBJECTID,
+					ItemTypeMatching: btrfstree.ItemTypeExact,
+					ItemType:         btrfsitem.EXTENT_CSUM_KEY,
+					OffsetMatching:   btrfstree.OffsetRange,
+					OffsetLow:        uint64(tc.Lo),
+					OffsetHigh:       uint64(tc.Hi),
+				}, func(item btrfstree.Item) bool {
+					actKeys = append(actKeys, item.Key)
+					return true
+				})
+				require.NoError(t, err)
+				var expKeys []btrfsprim.Key
+				for _, item := range items[tc.ExpBeg:tc.ExpEnd] {
+					expKeys = append(expKeys, item.Key)
+				}
+				assert.Equal(t, expKeys, actKeys)
+			})
+		}
+	})
+
+	t.Run("csum-subrange", func(t *testing.T) {
+		t.Parallel()
+		type TestCase struct {
+			Beg, End       btrfsvol.LogicalAddr
+			ExpBeg, ExpEnd int
+		}
+		testcases := map[string]TestCase{
+			"all": {
+				Beg: 0, End: btrfsvol.LogicalAddr(1) << 62,
+				ExpBeg: 0, ExpEnd: numItems,
+			},
+			"many-leaves": {
+				// Begins part-way through an item.
+				Beg: itemAddr(1000) + 1, End: itemAddr(9000),
+				ExpBeg: 1000, ExpEnd: 9000,
+			},
+			"gap": {
+				Beg: itemAddr(1000) + itemSize, End: itemAddr(1001),
+				ExpBeg: 1001, ExpEnd: 1001,
+			},
+		}
+		for tcName, tc := range testcases {
+			tc := tc
+			t.Run(tcName, func(t *testing.T) {
+				t.Parallel()
+				var actKeys []btrfsprim.Key
+				err := tree.TreeSubrange(ctx, 0, btrfstree.SearchCSumRange(tc.Beg, tc.End, algSize), func(item btrfstree.Item) bool {
+					actKeys = append(actKeys, item.Key)
+					return true
+				})
+				require.NoError(t, err)
+				var expKeys []btrfsprim.Key
+				for _, item := range items[tc.ExpBeg:tc.ExpEnd] {
+					expKeys = append(expKeys, item.Key)
+				}
+				assert.Equal(t, expKeys, actKeys)
+			})
+		}
+	})
+}
+
+func TestRawTreeKeyRange(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     4096,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+
+	// Objects 256-5255 each have ORPHAN_ITEM and TREE_BLOCK_REF
+	// items at offsets 10 and 20; enough items for a 3-level
+	// tree.
+	var items []btrfstree.Item
+	for objID := btrfsprim.ObjID(256); objID < 5256; objID++ {
+		for _, typ := range []btrfsprim.ItemType{btrfsitem.ORPHAN_ITEM_KEY, btrfsitem.TREE_BLOCK_REF_KEY} {
+			for _, off := range []uint64{10, 20} {
+				items = append(items, btrfstree.Item{
+					Key:  btrfsprim.Key{ObjectID: objID, ItemType: typ, Offset: off},
+					Body: &btrfsitem.Empty{},
+				})
+			}
+		}
+	}
+	tree, _ := buildMemTree(t, sb, btrfsprim.FS_TREE_OBJECTID, items)
+	require.Equal(t, uint8(2), tree.TreeRoot.Level)
+
+	key := func(objID btrfsprim.ObjID, typ btrfsprim.ItemType, off uint64) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: objID, ItemType: typ, Offset: off}
+	}
+	type TestCase struct {
+		Min, Max btrfsprim.Key
+	}
+	testcases := map[string]TestCase{
+		"all":          {Min: btrfsprim.Key{}, Max: btrfsprim.MaxKey},
+		"one":          {Min: key(1000, btrfsitem.ORPHAN_ITEM_KEY, 20), Max: key(1000, btrfsitem.ORPHAN_ITEM_KEY, 20)},
+		"object":       {Min: key(1000, 0, 0), Max: key(1000, btrfsprim.MAX_KEY, math.MaxUint64)},
+		"many-leaves":  {Min: key(1000, btrfsitem.TREE_BLOCK_REF_KEY, 15), Max: key(4000, btrfsitem.ORPHAN_ITEM_KEY, 15)},
+		"between-keys": {Min: key(1000, btrfsitem.ORPHAN_ITEM_KEY, 11), Max: key(1000, btrfsitem.ORPHAN_ITEM_KEY, 19)},
+		"before-first": {Min: btrfsprim.Key{}, Max: key(255, btrfsprim.MAX_KEY, math.MaxUint64)},
+		"after-last":   {Min: key(5256, 0, 0), Max: btrfsprim.MaxKey},
+		"inverted":     {Min: key(2000, 0, 0), Max: key(1000, 0, 0)},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			var expKeys []btrfsprim.Key
+			for _, item := range items {
+				if item.Key.Compare(tc.Min) >= 0 && item.Key.Compare(tc.Max) <= 0 {
+					expKeys = append(expKeys, item.Key)
+				}
+			}
+			var actKeys []btrfsprim.Key
+			err := tree.TreeSubrange(ctx, 0, btrfstree.SearchKeyRange(tc.Min, tc.Max), func(item btrfstree.Item) bool {
+				actKeys = append(actKeys, item.Key)
+				return true
+			})
+			require.NoError(t, err)
+			assert.Equal(t, expKeys, actKeys)
+		})
+	}
+
+	t.Run("stop-early", func(t *testing.T) {
+		t.Parallel()
+		var actKeys []btrfsprim.Key
+		err := tree.TreeSubrange(ctx, 0, btrfstree.SearchKeyRange(key(1000, 0, 0), btrfsprim.MaxKey), func(item btrfstree.Item) bool {
+			actKeys = append(actKeys, item.Key)
+			return len(actKeys) < 3
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []btrfsprim.Key{
+			key(1000, btrfsitem.ORPHAN_ITEM_KEY, 10),
+			key(1000, btrfsitem.ORPHAN_ITEM_KEY, 20),
+			key(1000, btrfsitem.TREE_BLOCK_REF_KEY, 10),
+		}, actKeys)
+	})
+}
+
+func TestRawTreeWalkLoop(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	// node@0x1000 (level 1) -+-> node@0x2000 (level 0)
+	//     ^                  |
+	//     |                  +-> node@0x3000 (claims level 1) -+
+	//     |                                                    |
+	//     +----------------------------------------------------+
+	key := func(objID btrfsprim.ObjID) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: objID, ItemType: btrfsitem.ORPHAN_ITEM_KEY}
+	}
+	node := func(addr btrfsvol.LogicalAddr, level uint8) *btrfstree.Node {
+		return &btrfstree.Node{
+			Head: btrfstree.NodeHeader{
+				Addr:       addr,
+				Generation: 1,
+				Owner:      btrfsprim.FS_TREE_OBJECTID,
+				NumItems:   1,
+				Level:      level,
+			},
+		}
+	}
+	root := node(0x1000, 1)
+	root.Head.NumItems = 2
+	root.BodyInterior = []btrfstree.KeyPointer{
+		{Key: key(256), BlockPtr: 0x2000, Generation: 1},
+		{Key: key(300), BlockPtr: 0x3000, Generation: 1},
+	}
+	leaf := node(0x2000, 0)
+	leaf.BodyLeaf = []btrfstree.Item{
+		{Key: key(256), Body: &btrfsitem.Empty{}},
+	}
+	bad := node(0x3000, 1)
+	bad.BodyInterior = []btrfstree.KeyPointer{
+		{Key: key(300), BlockPtr: 0x1000, Generation: 1},
+	}
+	src := &memNodeSource{
+		nodes: map[btrfsvol.LogicalAddr]*btrfstree.Node{
+			root.Head.Addr: root,
+			leaf.Head.Addr: leaf,
+			bad.Head.Addr:  bad,
+		},
+		lax: true,
+	}
+	tree := &btrfstree.RawTree{
+		Forrest: btrfstree.RawForrest{NodeSource: src},
+		TreeRoot: btrfstree.TreeRoot{
+			ID:         btrfsprim.FS_TREE_OBJECTID,
+			RootNode:   root.Head.Addr,
+			Level:      root.Head.Level,
+			Generation: 1,
+		},
+	}
+
+	var events []string
+	tree.TreeWalk(ctx, btrfstree.TreeWalkHandler{
+		Node: func(_ btrfstree.Path, node *btrfstree.Node) {
+			events = append(events, fmt.Sprintf("node@%v", node.Head.Addr))
+		},
+		BadNode: func(_ btrfstree.Path, node *btrfstree.Node, err error) bool {
+			if node == nil {
+				events = append(events, fmt.Sprintf("bad node: %v", err))
+			} else {
+				events = append(events, fmt.Sprintf("bad node@%v", node.Head.Addr))
+			}
+			// Process bad nodes anyway, as a recovery tool
+			// would.
+			return true
+		},
+		Item: func(_ btrfstree.Path, item btrfstree.Item) {
+			events = append(events, fmt.Sprintf("item %v", item.Key))
+		},
+	})
+	assert.Equal(t, []string{
+		"node@0x0000000000001000",
+		"node@0x0000000000002000",
+		"item " + key(256).String(),
+		"bad node@0x0000000000003000",
+		"bad node: loop detected: node@0x0000000000001000 is its own ancestor",
+	}, events)
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfstree
+
+import (
+	"fmt"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+// BuildTree packs `items` (which must already be sorted by key) in
+// to as many leaf nodes as are needed to hold them, and then builds
+// as many levels of interior nodes over those as are needed to arrive
+// at a single root node; it is for writing out a reconstructed tree
+// (such as a chunk tree) that may not fit in a single node.
+//
+// Each node's header is a copy of `head`, with .MetadataUUID set
+// from the superblock, and with .Addr (from calling `alloc`),
+// .Level, .NumItems, and .Checksum filled in.  The nodes are returned
+// in the order that they were allocated, which puts the root last.
+//
+// The returned leaf nodes share item bodies with `items`; they must
+// not be released with .RawFree.
+func BuildTree(sb Superblock, head NodeHeader, items []Item, alloc func() (btrfsvol.LogicalAddr, error)) ([]*Node, error) {
+	bodySize := int(sb.NodeSize) - nodeHeaderSize
+	if bodySize < keyPointerSize*2 {
+		return nil, fmt.Errorf("superblock.NodeSize=%v is too small to build a tree in", sb.NodeSize)
+	}
+	head.MetadataUUID = sb.EffectiveMetadataUUID()
+
+	var ret []*Node
+	newNode := func(level uint8) (*Node, error) {
+		addr, err := alloc()
+		if err != nil {
+			return nil, err
+		}
+		node := &Node{
+			Size:         sb.NodeSize,
+			ChecksumType: sb.ChecksumType,
+			Head:         head,
+		}
+		node.Head.Addr = addr
+		node.Head.Level = level
+		ret = append(ret, node)
+		return node, nil
+	}
+
+	// Leaves.
+	var level []*Node
+	leaf, err := newNode(0)
+	if err != nil {
+		return nil, err
+	}
+	level = append(level, leaf)
+	free, tail := bodySize, bodySize
+	for i, item := range items {
+		if i > 0 && items[i-1].Key.Compare(item.Key) >= 0 {
+			return nil, fmt.Errorf("item %v: key %v is not greater than the previous key %v",
+				i, item.Key, items[i-1].Key)
+		}
+		itemBody, err := binstruct.Marshal(item.Body)
+		if err != nil {
+			return nil, fmt.Errorf("item %v: body: %w", i, err)
+		}
+		itemSize := itemHeaderSize + len(itemBody)
+		if itemSize > bodySize {
+			return nil, fmt.Errorf("item %v: %v bytes is too big to fit in a node",
+				i, itemSize)
+		}
+		if itemSize > free {
+			if leaf, err = newNode(0); err != nil {
+				return nil, err
+			}
+			level = append(level, leaf)
+			free, tail = bodySize, bodySize
+		}
+		tail -= len(itemBody)
+		item.BodyOffset = uint32(tail)
+		item.BodySize = uint32(len(itemBody))
+		leaf.BodyLeaf = append(leaf.BodyLeaf, item)
+		free -= itemSize
+	}
+
+	// Interior nodes.
+	ptrsPerNode := bodySize / keyPointerSize
+	for lvl := uint8(1); len(level) > 1; lvl++ {
+		if lvl > MaxLevel {
+			return nil, fmt.Errorf("tree would need more than the maximum level=%v", MaxLevel)
+		}
+		var parents []*Node
+		for len(level) > 0 {
+			children := level
+			if len(children) > ptrsPerNode {
+				children = children[:ptrsPerNode]
+			}
+			level = level[len(children):]
+			parent, err := newNode(lvl)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range children {
+				minKey, _ := child.MinItem()
+				parent.BodyInterior = append(parent.BodyInterior, KeyPointer{
+					Key:        minKey,
+					BlockPtr:   child.Head.Addr,
+					Generation: child.Head.Generation,
+				})
+			}
+			parents = append(parents, parent)
+		}
+		level = parents
+	}
+
+	// Headers.
+	for _, node := range ret {
+		if node.Head.Level > 0 {
+			node.Head.NumItems = uint32(len(node.BodyInterior))
+		} else {
+			node.Head.NumItems = uint32(len(node.BodyLeaf))
+		}
+		if node.Head.Checksum, err = node.CalculateChecksum(); err != nil {
+			return nil, fmt.Errorf("node@%v: %w", node.Head.Addr, err)
+		}
+	}
+
+	return ret, nil
+}
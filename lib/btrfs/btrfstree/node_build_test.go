@@ -0,0 +1,156 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfstree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestBuildTree(t *testing.T) {
+	t.Parallel()
+	const nodeSize = 4096
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     nodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+
+	chunkItems := func(n int) []btrfstree.Item {
+		ret := make([]btrfstree.Item, n)
+		for i := range ret {
+			laddr := btrfsvol.LogicalAddr(i+1) * 1024 * 1024
+			ret[i] = btrfstree.Item{
+				Key: btrfsprim.Key{
+					ObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+					ItemType: btrfsitem.CHUNK_ITEM_KEY,
+					Offset:   uint64(laddr),
+				},
+				Body: &btrfsitem.Chunk{
+					Head: btrfsitem.ChunkHeader{
+						Size:       1024 * 1024,
+						Owner:      btrfsprim.EXTENT_TREE_OBJECTID,
+						Type:       btrfsvol.BLOCK_GROUP_SYSTEM,
+						NumStripes: 1,
+					},
+					Stripes: []btrfsitem.ChunkStripe{{
+						DeviceID: 1,
+						Offset:   btrfsvol.PhysicalAddr(laddr),
+					}},
+				},
+			}
+		}
+		return ret
+	}
+
+	type TestCase struct {
+		NumItems  int
+		ExpLevel  uint8
+		ExpLeaves int
+	}
+	testcases := map[string]TestCase{
+		"empty":       {NumItems: 0, ExpLevel: 0, ExpLeaves: 1},
+		"one-leaf":    {NumItems: 10, ExpLevel: 0, ExpLeaves: 1},
+		"two-levels":  {NumItems: 200, ExpLevel: 1, ExpLeaves: 6},
+		"three-level": {NumItems: 5000, ExpLevel: 2, ExpLeaves: 132},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			items := chunkItems(tc.NumItems)
+
+			nextAddr := btrfsvol.LogicalAddr(0)
+			nodes, err := btrfstree.BuildTree(sb, btrfstree.NodeHeader{
+				Flags:      btrfstree.NodeWritten,
+				BackrefRev: btrfstree.MixedBackrefRev,
+				Generation: 3,
+				Owner:      btrfsprim.CHUNK_TREE_OBJECTID,
+			}, items, func() (btrfsvol.LogicalAddr, error) {
+				addr := nextAddr
+				nextAddr += nodeSize
+				return addr, nil
+			})
+			require.NoError(t, err)
+
+			// Write them out.
+			file := make(bytesReaderAt, nextAddr)
+			built := make(map[btrfsvol.LogicalAddr]*btrfstree.Node)
+			var numLeaves int
+			for _, node := range nodes {
+				built[node.Head.Addr] = node
+				if node.Head.Level == 0 {
+					numLeaves++
+				}
+				dat, err := node.MarshalBinary()
+				require.NoError(t, err)
+				copy(file[node.Head.Addr:], dat)
+			}
+			assert.Equal(t, tc.ExpLeaves, numLeaves)
+			root := nodes[len(nodes)-1]
+			assert.Equal(t, tc.ExpLevel, root.Head.Level)
+
+			// Read them back in, walking down from the root.
+			var actKeys []btrfsprim.Key
+			var walk func(addr btrfsvol.LogicalAddr, level uint8)
+			walk = func(addr btrfsvol.LogicalAddr, level uint8) {
+				node, err := btrfstree.ReadNode[btrfsvol.LogicalAddr](file, sb, addr)
+				require.NoError(t, err)
+				defer node.RawFree()
+				assert.Equal(t, addr, node.Head.Addr)
+				assert.Equal(t, level, node.Head.Level)
+				assert.Equal(t, btrfsprim.CHUNK_TREE_OBJECTID, node.Head.Owner)
+				assert.NoError(t, node.Head.Validate(sb))
+				if level > 0 {
+					for _, kp := range node.BodyInterior {
+						walk(kp.BlockPtr, level-1)
+					}
+					return
+				}
+				for i, item := range node.BodyLeaf {
+					actKeys = append(actKeys, item.Key)
+					assert.IsType(t, &btrfsitem.Chunk{}, item.Body)
+					assert.Equal(t, built[addr].BodyLeaf[i].BodyOffset, item.BodyOffset)
+					assert.Equal(t, built[addr].BodyLeaf[i].BodySize, item.BodySize)
+				}
+			}
+			walk(root.Head.Addr, root.Head.Level)
+
+			expKeys := make([]btrfsprim.Key, 0, len(items))
+			for _, item := range items {
+				expKeys = append(expKeys, item.Key)
+			}
+			if len(expKeys) == 0 {
+				expKeys = nil
+			}
+			assert.Equal(t, expKeys, actKeys)
+		})
+	}
+}
+
+func TestBuildTreeUnsorted(t *testing.T) {
+	t.Parallel()
+	sb := btrfstree.Superblock{
+		NodeSize:     4096,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	items := []btrfstree.Item{
+		{Key: btrfsprim.Key{ObjectID: 2}, Body: &btrfsitem.Empty{}},
+		{Key: btrfsprim.Key{ObjectID: 1}, Body: &btrfsitem.Empty{}},
+	}
+	_, err := btrfstree.BuildTree(sb, btrfstree.NodeHeader{}, items, func() (btrfsvol.LogicalAddr, error) {
+		return 0, nil
+	})
+	assert.Error(t, err)
+}
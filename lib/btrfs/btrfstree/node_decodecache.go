@@ -0,0 +1,129 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfstree
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+// A NodeDecodeCache is a cache of decoded nodes, keyed by the nodes'
+// addresses and checksums.  It is consulted by ReadNodeCached once
+// the checksum of the raw node data has been verified, so that a
+// node is only parsed once, even if the address-keyed node cache has
+// since evicted it (or if it is read from several mirrors).
+//
+// Because the checksum is only 32 bits for CRC32C filesystems,
+// matching it is not taken as proof that the contents are identical:
+// the raw node data is kept alongside the decoded node, and compared
+// before a cached node is reused.  So entries never need to be
+// invalidated; a node that has been rewritten simply misses.
+//
+// Nodes are copied in to and out of the cache (with .RawClone()), so
+// the caller owns the nodes returned by ReadNodeCached just as it
+// owns nodes returned by ReadNode.
+type NodeDecodeCache struct {
+	cap int
+
+	mu    sync.Mutex
+	lru   containers.LinkedList[nodeDecodeEntry]
+	byKey map[nodeDecodeKey]*containers.LinkedListEntry[nodeDecodeEntry]
+	stats NodeDecodeCacheStats
+}
+
+type nodeDecodeKey struct {
+	Addr int64
+	CSum btrfssum.CSum
+}
+
+type nodeDecodeEntry struct {
+	Key  nodeDecodeKey
+	Raw  []byte
+	Node *Node
+}
+
+// NodeDecodeCacheStats is returned by NodeDecodeCache.Stats.
+type NodeDecodeCacheStats struct {
+	Hits   int
+	Misses int
+}
+
+func (s NodeDecodeCacheStats) String() string {
+	return textui.Sprintf("%v hits, %v misses (%v)",
+		s.Hits, s.Misses, textui.Portion[int]{N: s.Hits, D: s.Hits + s.Misses})
+}
+
+// NewNodeDecodeCache returns a new NodeDecodeCache that holds up to
+// `cap` nodes.
+//
+// It is invalid (runtime-panic) to call NewNodeDecodeCache with a
+// non-positive capacity.
+//
+//nolint:predeclared // 'cap' is the best name for it.
+func NewNodeDecodeCache(cap int) *NodeDecodeCache {
+	if cap <= 0 {
+		panic(fmt.Errorf("btrfstree.NewNodeDecodeCache: invalid capacity: %v", cap))
+	}
+	return &NodeDecodeCache{
+		cap:   cap,
+		byKey: make(map[nodeDecodeKey]*containers.LinkedListEntry[nodeDecodeEntry], cap),
+	}
+}
+
+// Stats returns how many lookups have hit and missed the cache.
+func (c *NodeDecodeCache) Stats() NodeDecodeCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// get returns a copy of the cached node that was decoded from `raw`
+// at `key`, or nil if there is no such node in the cache.
+func (c *NodeDecodeCache) get(key nodeDecodeKey, raw []byte) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.byKey[key]
+	if !ok || !bytes.Equal(entry.Value.Raw, raw) {
+		c.stats.Misses++
+		return nil
+	}
+	c.stats.Hits++
+	c.lru.MoveToNewest(entry)
+	return entry.Value.Node.RawClone()
+}
+
+// put stores a copy of the node (and of the raw data that it was
+// decoded from) in the cache.
+func (c *NodeDecodeCache) put(key nodeDecodeKey, raw []byte, node *Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.byKey[key]
+	switch {
+	case ok && bytes.Equal(entry.Value.Raw, raw):
+		return
+	case ok:
+		// Same address and checksum, but different contents;
+		// replace the old node.
+		entry.Value.Node.RawFree()
+		c.lru.MoveToNewest(entry)
+	case c.lru.Len < c.cap:
+		entry = new(containers.LinkedListEntry[nodeDecodeEntry])
+		c.lru.Store(entry)
+	default:
+		entry = c.lru.Oldest
+		delete(c.byKey, entry.Value.Key)
+		entry.Value.Node.RawFree()
+		c.lru.MoveToNewest(entry)
+	}
+	entry.Value.Key = key
+	entry.Value.Raw = append(entry.Value.Raw[:0], raw...)
+	entry.Value.Node = node.RawClone()
+	c.byKey[key] = entry
+}
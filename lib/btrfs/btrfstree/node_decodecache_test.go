@@ -0,0 +1,156 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfstree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+type bytesReaderAt []byte
+
+func (b bytesReaderAt) ReadAt(p []byte, off btrfsvol.LogicalAddr) (int, error) {
+	return copy(p, b[off:]), nil
+}
+
+func TestReadNodeCached(t *testing.T) {
+	t.Parallel()
+	const nodeSize = 4096
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		NodeSize:     nodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	node := btrfstree.Node{
+		Size:         nodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+		Head: btrfstree.NodeHeader{
+			MetadataUUID: sb.FSUUID,
+			Addr:         nodeSize,
+			Generation:   5,
+			Owner:        btrfsprim.FS_TREE_OBJECTID,
+		},
+		BodyLeaf: []btrfstree.Item{
+			{
+				Key:  btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.INODE_ITEM_KEY},
+				Body: &btrfsitem.Inode{Size: 42},
+			},
+		},
+	}
+	var err error
+	node.Head.Checksum, err = node.CalculateChecksum()
+	require.NoError(t, err)
+	nodeDat, err := node.MarshalBinary()
+	require.NoError(t, err)
+	file := make(bytesReaderAt, 2*nodeSize)
+	copy(file[nodeSize:], nodeDat)
+
+	cache := btrfstree.NewNodeDecodeCache(4)
+
+	first, err := btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](file, sb, nodeSize, cache)
+	require.NoError(t, err)
+	assert.Equal(t, btrfstree.NodeDecodeCacheStats{Hits: 0, Misses: 1}, cache.Stats())
+
+	second, err := btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](file, sb, nodeSize, cache)
+	require.NoError(t, err)
+	assert.Equal(t, btrfstree.NodeDecodeCacheStats{Hits: 1, Misses: 1}, cache.Stats())
+
+	assert.Equal(t, first, second)
+	require.Len(t, second.BodyLeaf, 1)
+	assert.Equal(t, int64(42), second.BodyLeaf[0].Body.(*btrfsitem.Inode).Size)
+
+	// The nodes returned must be independent copies; freeing one
+	// must not affect the other, or the cache.
+	first.RawFree()
+	assert.Equal(t, int64(42), second.BodyLeaf[0].Body.(*btrfsitem.Inode).Size)
+	second.RawFree()
+	third, err := btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](file, sb, nodeSize, cache)
+	require.NoError(t, err)
+	assert.Equal(t, btrfstree.NodeDecodeCacheStats{Hits: 2, Misses: 1}, cache.Stats())
+	assert.Equal(t, int64(42), third.BodyLeaf[0].Body.(*btrfsitem.Inode).Size)
+	third.RawFree()
+}
+
+// TestReadNodeCachedCollision checks that two different nodes at the
+// same address that have the same checksum don't get confused.
+func TestReadNodeCachedCollision(t *testing.T) {
+	t.Parallel()
+	const nodeSize = 4096
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		NodeSize:     nodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	const csumSize = 0x20
+	mkNode := func(blockPtr btrfsvol.LogicalAddr) bytesReaderAt {
+		node := btrfstree.Node{
+			Size:         nodeSize,
+			ChecksumType: btrfssum.TYPE_CRC32,
+			Head: btrfstree.NodeHeader{
+				MetadataUUID: sb.FSUUID,
+				Addr:         nodeSize,
+				Generation:   5,
+				Owner:        btrfsprim.FS_TREE_OBJECTID,
+				Level:        1,
+			},
+			BodyInterior: []btrfstree.KeyPointer{
+				{
+					Key:        btrfsprim.Key{ObjectID: 256},
+					BlockPtr:   blockPtr,
+					Generation: 5,
+				},
+			},
+		}
+		dat, err := node.MarshalBinary()
+		require.NoError(t, err)
+		// Use the unused tail of the node to force a CRC
+		// collision: appending a message's CRC32C to it
+		// results in a message with a constant CRC32C.
+		body := dat[csumSize:]
+		sum, err := btrfssum.TYPE_CRC32.Sum(body[:len(body)-4])
+		require.NoError(t, err)
+		copy(body[len(body)-4:], sum[:4])
+		sum, err = btrfssum.TYPE_CRC32.Sum(body)
+		require.NoError(t, err)
+		copy(dat[:csumSize], sum[:])
+		file := make(bytesReaderAt, 2*nodeSize)
+		copy(file[nodeSize:], dat)
+		return file
+	}
+	fileA := mkNode(0x10000)
+	fileB := mkNode(0x20000)
+	require.Equal(t, fileA[nodeSize:nodeSize+csumSize], fileB[nodeSize:nodeSize+csumSize])
+	require.NotEqual(t, fileA, fileB)
+
+	cache := btrfstree.NewNodeDecodeCache(4)
+
+	nodeA, err := btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](fileA, sb, nodeSize, cache)
+	require.NoError(t, err)
+	require.Len(t, nodeA.BodyInterior, 1)
+	assert.Equal(t, btrfsvol.LogicalAddr(0x10000), nodeA.BodyInterior[0].BlockPtr)
+	nodeA.RawFree()
+
+	nodeB, err := btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](fileB, sb, nodeSize, cache)
+	require.NoError(t, err)
+	require.Len(t, nodeB.BodyInterior, 1)
+	assert.Equal(t, btrfsvol.LogicalAddr(0x20000), nodeB.BodyInterior[0].BlockPtr)
+	nodeB.RawFree()
+	assert.Equal(t, btrfstree.NodeDecodeCacheStats{Hits: 0, Misses: 2}, cache.Stats())
+
+	// B has replaced A in the cache.
+	nodeB, err = btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](fileB, sb, nodeSize, cache)
+	require.NoError(t, err)
+	assert.Equal(t, btrfsvol.LogicalAddr(0x20000), nodeB.BodyInterior[0].BlockPtr)
+	nodeB.RawFree()
+	assert.Equal(t, btrfstree.NodeDecodeCacheStats{Hits: 1, Misses: 2}, cache.Stats())
+}
@@ -222,3 +222,21 @@ func (path Path) NodeExpectations(ctx context.Context) (_ btrfsvol.LogicalAddr,
 func (path Path) Parent() Path {
 	return path[:len(path)-1]
 }
+
+// hasNode returns whether any element of the path points at the node
+// at `addr`.
+func (path Path) hasNode(addr btrfsvol.LogicalAddr) bool {
+	for _, elem := range path {
+		switch elem := elem.(type) {
+		case PathRoot:
+			if elem.ToAddr == addr {
+				return true
+			}
+		case PathKP:
+			if elem.ToAddr == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
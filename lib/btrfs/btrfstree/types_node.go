@@ -10,6 +10,7 @@ import (
 	"fmt"
 
 	"git.lukeshu.com/go/typedsync"
+	"github.com/datawire/dlib/derror"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
@@ -122,6 +123,34 @@ func (node Node) MaxItems() uint32 {
 	}
 }
 
+// Validate performs the sanity checks on a node header that are
+// possible knowing only the superblock (and not where the node was
+// read from or what points to it); it is useful for weeding out
+// checksum-valid garbage when scanning a device for nodes.
+func (head NodeHeader) Validate(sb Superblock) error {
+	var errs derror.MultiError
+	if head.MetadataUUID != sb.EffectiveMetadataUUID() {
+		errs = append(errs, fmt.Errorf("metadata_uuid=%v does not match superblock metadata_uuid=%v",
+			head.MetadataUUID, sb.EffectiveMetadataUUID()))
+	}
+	if head.Addr < 0 || (sb.SectorSize > 0 && head.Addr%btrfsvol.LogicalAddr(sb.SectorSize) != 0) {
+		errs = append(errs, fmt.Errorf("claims to be at laddr=%v, which is not aligned to sector_size=%v",
+			head.Addr, sb.SectorSize))
+	}
+	if head.Level > MaxLevel {
+		errs = append(errs, fmt.Errorf("maximum level=%v but claims to be level=%v",
+			MaxLevel, head.Level))
+	}
+	if maxItems := (Node{Size: sb.NodeSize, Head: head}).MaxItems(); head.NumItems > maxItems {
+		errs = append(errs, fmt.Errorf("claims to have %v items, but a level=%v node of node_size=%v can hold at most %v",
+			head.NumItems, head.Level, sb.NodeSize, maxItems))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 func (node Node) MinItem() (btrfsprim.Key, bool) {
 	switch {
 	case node.Head.Level > 0:
@@ -168,7 +197,7 @@ func (node Node) ValidateChecksum() error {
 	}
 	if calced != stored {
 		return fmt.Errorf("node checksum mismatch: stored=%v calculated=%v",
-			stored, calced)
+			stored.Fmt(node.ChecksumType), calced.Fmt(node.ChecksumType))
 	}
 	return nil
 }
@@ -295,9 +324,10 @@ func (node *Node) marshalInteriorTo(bodyBuf []byte) error {
 // Node: "leaf" ////////////////////////////////////////////////////////////////////////////////////
 
 type Item struct {
-	Key      btrfsprim.Key
-	BodySize uint32 // [ignored-when-writing]
-	Body     btrfsitem.Item
+	Key        btrfsprim.Key
+	BodyOffset uint32 // [ignored-when-writing] the item header's DataOffset; relative to the end of the node header
+	BodySize   uint32 // [ignored-when-writing]
+	Body       btrfsitem.Item
 }
 
 type ItemHeader struct {
@@ -310,39 +340,78 @@ type ItemHeader struct {
 func (node *Node) unmarshalLeaf(bodyBuf []byte) (int, error) {
 	head := 0
 	tail := len(bodyBuf)
+	if maxItems := len(bodyBuf) / itemHeaderSize; int(node.Head.NumItems) > maxItems {
+		return 0, fmt.Errorf("head: claims %v items, but there is only room for %v item heads",
+			node.Head.NumItems, maxItems)
+	}
 	node.BodyLeaf = itemPool.Get(int(node.Head.NumItems))
 	var itemHead ItemHeader
+	// Problems with an individual item's body location don't
+	// prevent parsing the remaining items; the item becomes a
+	// btrfsitem.Error (since this is a recovery tool, corrupt
+	// items are expected input), and the first such problem is
+	// returned once all items have been parsed.  After such a
+	// problem we don't know where the next item's body should end,
+	// so only the bounds of the next item's body are checked.
+	var bodyErr error
+	tailKnown := true
 	for i := range node.BodyLeaf {
 		itemHead = ItemHeader{} // zero it out
 		n, err := binstruct.Unmarshal(bodyBuf[head:], &itemHead)
 		head += n
 		if err != nil {
+			node.BodyLeaf = node.BodyLeaf[:i]
 			return 0, fmt.Errorf("item %v: head: %w", i, err)
 		}
 		if head > tail {
+			node.BodyLeaf = node.BodyLeaf[:i]
 			return 0, fmt.Errorf("item %v: head: end_offset=%#x is in the body section (offset>%#x)",
 				i, head, tail)
 		}
 
 		dataOff := int(itemHead.DataOffset)
-		if dataOff < head {
-			return 0, fmt.Errorf("item %v: body: beg_offset=%#x is in the head section (offset<%#x)",
-				i, dataOff, head)
-		}
 		dataSize := int(itemHead.DataSize)
-		if dataOff+dataSize != tail {
-			return 0, fmt.Errorf("item %v: body: end_offset=%#x is not cur_tail=%#x)",
+		var err2 error
+		switch {
+		case dataOff < head:
+			err2 = fmt.Errorf("item %v: body: beg_offset=%#x is in the head section (offset<%#x)",
+				i, dataOff, head)
+		case dataOff+dataSize > len(bodyBuf):
+			err2 = fmt.Errorf("item %v: body: end_offset=%#x (beg_offset=%#x + size=%#x) is past the end of the node (offset>%#x)",
+				i, dataOff+dataSize, dataOff, dataSize, len(bodyBuf))
+		case tailKnown && dataOff+dataSize != tail:
+			err2 = fmt.Errorf("item %v: body: end_offset=%#x is not cur_tail=%#x)",
 				i, dataOff+dataSize, tail)
 		}
+		if err2 != nil {
+			if bodyErr == nil {
+				bodyErr = err2
+			}
+			tailKnown = false
+			node.BodyLeaf[i] = Item{
+				Key:        itemHead.Key,
+				BodyOffset: itemHead.DataOffset,
+				BodySize:   itemHead.DataSize,
+				Body: &btrfsitem.Error{
+					Err: err2,
+				},
+			}
+			continue
+		}
 		tail = dataOff
+		tailKnown = true
 		dataBuf := bodyBuf[dataOff : dataOff+dataSize]
 
 		node.BodyLeaf[i] = Item{
-			Key:      itemHead.Key,
-			BodySize: itemHead.DataSize,
-			Body:     btrfsitem.UnmarshalItem(itemHead.Key, node.ChecksumType, dataBuf),
+			Key:        itemHead.Key,
+			BodyOffset: itemHead.DataOffset,
+			BodySize:   itemHead.DataSize,
+			Body:       btrfsitem.UnmarshalItem(itemHead.Key, node.ChecksumType, dataBuf),
 		}
 	}
+	if bodyErr != nil {
+		return 0, bodyErr
+	}
 
 	node.Padding = bytePool.Get(len(bodyBuf[head:tail]))
 	copy(node.Padding, bodyBuf[head:tail])
@@ -399,7 +468,11 @@ func (node *Node) LeafFreeSpace() uint32 {
 
 // Tie Nodes in to the FS //////////////////////////////////////////////////////////////////////////
 
-var ErrNotANode = errors.New("does not look like a node")
+var (
+	ErrNotANode     = errors.New("does not look like a node")
+	ErrNodeChecksum = errors.New("checksum mismatch")
+	ErrNodeFiltered = errors.New("node rejected by filter")
+)
 
 type NodeError[Addr ~int64] struct {
 	Op       string
@@ -445,13 +518,64 @@ func (node *Node) RawFree() {
 	nodePool.Put(node)
 }
 
+// RawClone is for low-level use by caches; it returns a deep copy of
+// the node, which must be freed separately from the original.
+func (node *Node) RawClone() *Node {
+	if node == nil {
+		return nil
+	}
+	ret, _ := nodePool.Get()
+	ret.Size = node.Size
+	ret.ChecksumType = node.ChecksumType
+	ret.Head = node.Head
+	if node.BodyInterior != nil {
+		ret.BodyInterior = make([]KeyPointer, len(node.BodyInterior))
+		copy(ret.BodyInterior, node.BodyInterior)
+	}
+	if node.BodyLeaf != nil {
+		ret.BodyLeaf = itemPool.Get(len(node.BodyLeaf))
+		for i, item := range node.BodyLeaf {
+			ret.BodyLeaf[i] = item
+			if item.Body != nil {
+				ret.BodyLeaf[i].Body = item.Body.CloneItem()
+			}
+		}
+	}
+	ret.Padding = bytePool.Get(len(node.Padding))
+	copy(ret.Padding, node.Padding)
+	return ret
+}
+
 // ReadNode reads a node from the given file.
 //
 // It is possible that both a non-nil diskio.Ref and an error are
 // returned.  The error returned (if non-nil) is always of type
 // *NodeError[Addr].  Notable errors that may be inside of the
-// NodeError are ErrNotANode and *IOError.
+// NodeError are ErrNotANode, ErrNodeChecksum, and *IOError.
 func ReadNode[Addr ~int64](fs diskio.ReaderAt[Addr], sb Superblock, addr Addr) (*Node, error) {
+	return ReadNodeFiltered[Addr](fs, sb, addr, nil)
+}
+
+// ReadNodeFiltered is like ReadNode, but once the node header has
+// been read and the checksum verified, it calls `filter` (if
+// non-nil) on the header.  If the filter returns false, then the
+// comparatively expensive parse of the node body is skipped, and the
+// node is returned with only .Head populated, along with an error
+// wrapping ErrNodeFiltered.
+func ReadNodeFiltered[Addr ~int64](fs diskio.ReaderAt[Addr], sb Superblock, addr Addr, filter func(NodeHeader) bool) (*Node, error) {
+	return readNode[Addr](fs, sb, addr, filter, nil)
+}
+
+// ReadNodeCached is like ReadNode, but once the node's checksum has
+// been verified, it consults `cache` (if non-nil) for a node
+// previously decoded from the same bytes at the same address before
+// parsing the node body; and stores the parsed node in `cache` if it
+// wasn't there already.
+func ReadNodeCached[Addr ~int64](fs diskio.ReaderAt[Addr], sb Superblock, addr Addr, cache *NodeDecodeCache) (*Node, error) {
+	return readNode[Addr](fs, sb, addr, nil, cache)
+}
+
+func readNode[Addr ~int64](fs diskio.ReaderAt[Addr], sb Superblock, addr Addr, filter func(NodeHeader) bool, cache *NodeDecodeCache) (*Node, error) {
 	if int(sb.NodeSize) < nodeHeaderSize {
 		return nil, &NodeError[Addr]{
 			Op: "btrfstree.ReadNode", NodeAddr: addr,
@@ -494,8 +618,22 @@ func ReadNode[Addr ~int64](fs diskio.ReaderAt[Addr], sb Superblock, addr Addr) (
 		bytePool.Put(nodeBuf)
 		return node, &NodeError[Addr]{
 			Op: "btrfstree.ReadNode", NodeAddr: addr,
-			Err: fmt.Errorf("looks like a node but is corrupt: checksum mismatch: stored=%v calculated=%v",
-				stored, calced),
+			Err: fmt.Errorf("looks like a node but is corrupt: %w: stored=%v calculated=%v",
+				ErrNodeChecksum, stored.Fmt(node.ChecksumType), calced.Fmt(node.ChecksumType)),
+		}
+	}
+
+	if filter != nil && !filter(node.Head) {
+		bytePool.Put(nodeBuf)
+		return node, &NodeError[Addr]{Op: "btrfstree.ReadNode", NodeAddr: addr, Err: ErrNodeFiltered}
+	}
+
+	cacheKey := nodeDecodeKey{Addr: int64(addr), CSum: stored}
+	if cache != nil {
+		if cached := cache.get(cacheKey, nodeBuf); cached != nil {
+			bytePool.Put(nodeBuf)
+			node.RawFree()
+			return cached, nil
 		}
 	}
 
@@ -515,6 +653,10 @@ func ReadNode[Addr ~int64](fs diskio.ReaderAt[Addr], sb Superblock, addr Addr) (
 		return node, &NodeError[Addr]{Op: "btrfstree.ReadNode", NodeAddr: addr, Err: err}
 	}
 
+	if cache != nil {
+		cache.put(cacheKey, nodeBuf, node)
+	}
+
 	bytePool.Put(nodeBuf)
 
 	// return
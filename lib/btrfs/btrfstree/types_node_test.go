@@ -5,11 +5,15 @@
 package btrfstree_test
 
 import (
+	"encoding/binary"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 )
@@ -32,3 +36,76 @@ func FuzzRoundTripNode(f *testing.F) {
 		}
 	})
 }
+
+func TestLeafOversizedItem(t *testing.T) {
+	t.Parallel()
+	const (
+		nodeSize       = 4096
+		nodeHeaderSize = 0x65
+		itemHeaderSize = 0x19
+		dataSizeOffset = 0x15
+	)
+	node := btrfstree.Node{
+		Size:         nodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	for i := 0; i < 3; i++ {
+		node.BodyLeaf = append(node.BodyLeaf, btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: btrfsprim.ObjID(256 + i), ItemType: btrfsitem.INODE_ITEM_KEY},
+			Body: &btrfsitem.Inode{Size: int64(i)},
+		})
+	}
+	dat, err := binstruct.Marshal(node)
+	require.NoError(t, err)
+
+	// Have item 1 claim a body that runs far past the end of
+	// the node.
+	binary.LittleEndian.PutUint32(dat[nodeHeaderSize+1*itemHeaderSize+dataSizeOffset:], 0xffff)
+
+	var out btrfstree.Node
+	_, err = binstruct.Unmarshal(dat, &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "item 1: body: end_offset=")
+	assert.Contains(t, err.Error(), "is past the end of the node")
+
+	require.Len(t, out.BodyLeaf, 3)
+	assert.Equal(t, &btrfsitem.Inode{Size: 0}, out.BodyLeaf[0].Body)
+	if assert.IsType(t, &btrfsitem.Error{}, out.BodyLeaf[1].Body) {
+		assert.ErrorContains(t, out.BodyLeaf[1].Body.(*btrfsitem.Error).Err, "is past the end of the node")
+	}
+	assert.Equal(t, &btrfsitem.Inode{Size: 2}, out.BodyLeaf[2].Body)
+}
+
+func BenchmarkNodeHeaderUnmarshal(b *testing.B) {
+	dat, err := binstruct.Marshal(btrfstree.NodeHeader{
+		Addr:       0x1000,
+		Generation: 7,
+		Owner:      btrfsprim.FS_TREE_OBJECTID,
+		NumItems:   3,
+	})
+	require.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var head btrfstree.NodeHeader
+		if _, err := binstruct.Unmarshal(dat, &head); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNodeHeaderMarshal(b *testing.B) {
+	head := btrfstree.NodeHeader{
+		Addr:       0x1000,
+		Generation: 7,
+		Owner:      btrfsprim.FS_TREE_OBJECTID,
+		NumItems:   3,
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := binstruct.Marshal(head); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package btrfstree
 import (
 	"fmt"
 	"reflect"
+	"sort"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
@@ -42,7 +43,7 @@ type Superblock struct {
 
 	ChunkRootGeneration btrfsprim.Generation `bin:"off=0xa4, siz=0x8"`
 	CompatFlags         uint64               `bin:"off=0xac, siz=0x8"` // compat_flags
-	CompatROFlags       uint64               `bin:"off=0xb4, siz=0x8"` // compat_ro_flags - only implementations that support the flags can write to the filesystem
+	CompatROFlags       CompatROFlags        `bin:"off=0xb4, siz=0x8"` // compat_ro_flags - only implementations that support the flags can write to the filesystem
 	IncompatFlags       IncompatFlags        `bin:"off=0xbc, siz=0x8"` // incompat_flags - only implementations that support the flags can use the filesystem
 	ChecksumType        btrfssum.CSumType    `bin:"off=0xc4, siz=0x2"`
 
@@ -92,7 +93,7 @@ func (sb Superblock) ValidateChecksum() error {
 	}
 	if calced != stored {
 		return fmt.Errorf("superblock checksum mismatch: stored=%v calculated=%v",
-			stored, calced)
+			stored.Fmt(sb.ChecksumType), calced.Fmt(sb.ChecksumType))
 	}
 	return nil
 }
@@ -145,21 +146,87 @@ func (sc *SysChunk) UnmarshalBinary(dat []byte) (int, error) {
 	return n, nil
 }
 
+// SysChunkArrayError is the error returned by ParseSysChunkArray,
+// identifying where in the sys_chunk_array the problem is.
+type SysChunkArrayError struct {
+	Offset int
+	Err    error
+}
+
+func (e *SysChunkArrayError) Error() string {
+	return fmt.Sprintf("sys_chunk_array: offset %#x: %v", e.Offset, e.Err)
+}
+
+func (e *SysChunkArrayError) Unwrap() error { return e.Err }
+
+// ParseSysChunkArray parses the (KEY . CHUNK_ITEM) pairs in the
+// superblock's sys_chunk_array.  If the array is malformed, then the
+// pairs that were parsed before the problem are returned along with
+// a *SysChunkArrayError.
 func (sb Superblock) ParseSysChunkArray() ([]SysChunk, error) {
+	if int(sb.SysChunkArraySize) > len(sb.SysChunkArray) {
+		return nil, &SysChunkArrayError{
+			Offset: len(sb.SysChunkArray),
+			Err: fmt.Errorf("sys_chunk_array_size=%v is larger than the array (%v bytes)",
+				sb.SysChunkArraySize, len(sb.SysChunkArray)),
+		}
+	}
 	dat := sb.SysChunkArray[:sb.SysChunkArraySize]
 	var ret []SysChunk
-	for len(dat) > 0 {
+	for off := 0; off < len(dat); {
 		var pair SysChunk
-		n, err := binstruct.Unmarshal(dat, &pair)
-		dat = dat[n:]
+		n, err := binstruct.Unmarshal(dat[off:], &pair)
 		if err != nil {
-			return nil, err
+			return ret, &SysChunkArrayError{Offset: off, Err: err}
+		}
+		if pair.Key.ItemType != btrfsitem.CHUNK_ITEM_KEY {
+			return ret, &SysChunkArrayError{Offset: off, Err: fmt.Errorf("key %v is not a CHUNK_ITEM", pair.Key)}
 		}
+		if len(pair.Chunk.Stripes) == 0 {
+			return ret, &SysChunkArrayError{Offset: off, Err: fmt.Errorf("chunk %v has no stripes", pair.Key)}
+		}
+		off += n
 		ret = append(ret, pair)
 	}
 	return ret, nil
 }
 
+// SetSysChunkArray replaces the superblock's sys_chunk_array (and
+// sys_chunk_array_size) with the given SYSTEM chunks, sorted by key.
+// This is useful for writing a corrected array after recovering the
+// SYSTEM chunks from elsewhere (such as from the chunk tree, or from
+// a scan of the devices).
+//
+// It does not re-calculate the superblock's checksum.
+func (sb *Superblock) SetSysChunkArray(chunks []SysChunk) error {
+	chunks = append([]SysChunk(nil), chunks...)
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Key.Compare(chunks[j].Key) < 0
+	})
+	var dat []byte
+	for _, chunk := range chunks {
+		if chunk.Key.ItemType != btrfsitem.CHUNK_ITEM_KEY {
+			return fmt.Errorf("key %v is not a CHUNK_ITEM", chunk.Key)
+		}
+		if !chunk.Chunk.Head.Type.Has(btrfsvol.BLOCK_GROUP_SYSTEM) {
+			return fmt.Errorf("chunk %v is not a SYSTEM chunk: type=%v", chunk.Key, chunk.Chunk.Head.Type)
+		}
+		_dat, err := binstruct.Marshal(chunk)
+		if err != nil {
+			return fmt.Errorf("chunk %v: %w", chunk.Key, err)
+		}
+		dat = append(dat, _dat...)
+	}
+	if len(dat) > len(sb.SysChunkArray) {
+		return fmt.Errorf("%v bytes of SYSTEM chunks do not fit in the sys_chunk_array (%v bytes)",
+			len(dat), len(sb.SysChunkArray))
+	}
+	sb.SysChunkArray = [len(sb.SysChunkArray)]byte{}
+	copy(sb.SysChunkArray[:], dat)
+	sb.SysChunkArraySize = uint32(len(dat))
+	return nil
+}
+
 type RootBackup struct {
 	TreeRoot    btrfsprim.ObjID      `bin:"off=0x0, siz=0x8"`
 	TreeRootGen btrfsprim.Generation `bin:"off=0x8, siz=0x8"`
@@ -196,6 +263,67 @@ type RootBackup struct {
 	binstruct.End `bin:"off=0xa8"`
 }
 
+// WithRootBackup returns a copy of the superblock with the tree roots
+// replaced by those recorded in `backup`, as if the backup's
+// generation were the current generation.
+func (sb Superblock) WithRootBackup(backup RootBackup) Superblock {
+	sb.Generation = backup.TreeRootGen
+	sb.RootTree = btrfsvol.LogicalAddr(backup.TreeRoot)
+	sb.RootLevel = backup.TreeRootLevel
+	sb.ChunkTree = btrfsvol.LogicalAddr(backup.ChunkRoot)
+	sb.ChunkLevel = backup.ChunkRootLevel
+	sb.ChunkRootGeneration = backup.ChunkRootGen
+	// The log tree only makes sense for the current generation.
+	sb.LogTree = 0
+	sb.LogLevel = 0
+	return sb
+}
+
+type CompatROFlags uint64
+
+const (
+	FeatureCompatROFreeSpaceTree CompatROFlags = 1 << iota
+	FeatureCompatROFreeSpaceTreeValid
+	FeatureCompatROVerity
+	FeatureCompatROBlockGroupTree
+)
+
+var compatROFlagNames = []string{
+	"FeatureCompatROFreeSpaceTree",
+	"FeatureCompatROFreeSpaceTreeValid",
+	"FeatureCompatROVerity",
+	"FeatureCompatROBlockGroupTree",
+}
+
+// CompatROFlagsKnown is the set of all CompatROFlags that this
+// implementation knows the meaning of.
+const CompatROFlagsKnown = FeatureCompatROFreeSpaceTree |
+	FeatureCompatROFreeSpaceTreeValid |
+	FeatureCompatROVerity |
+	FeatureCompatROBlockGroupTree
+
+func (f CompatROFlags) Has(req CompatROFlags) bool { return f&req == req }
+func (f CompatROFlags) String() string {
+	return fmtutil.BitfieldString(f, compatROFlagNames, fmtutil.HexLower)
+}
+
+// Unknown returns the subset of the flags that this implementation
+// doesn't know the meaning of.  Since an implementation may only
+// write to a filesystem if it supports all of its compat_ro features,
+// writing to a filesystem with any unknown flags risks corrupting it.
+func (f CompatROFlags) Unknown() CompatROFlags { return f &^ CompatROFlagsKnown }
+
+// Unwritable returns the subset of the flags that a writer may not
+// write to a filesystem with, given the set of flags whose features'
+// on-disk structures that writer keeps consistent.  Which features
+// those are depends on what the writer writes: one that only rewrites
+// the superblocks doesn't disturb the free space tree, but one that
+// writes tree nodes would leave it out of date.  Unknown flags are
+// always unwritable, whatever `writable` says.
+func (f CompatROFlags) Unwritable(writable CompatROFlags) CompatROFlags {
+	return f &^ (writable & CompatROFlagsKnown)
+}
+
 type IncompatFlags uint64
 
 const (
@@ -0,0 +1,195 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfstree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func sysChunk(laddr btrfsvol.LogicalAddr, paddrs ...btrfsvol.PhysicalAddr) btrfstree.SysChunk {
+	ret := btrfstree.SysChunk{
+		Key: btrfsprim.Key{
+			ObjectID: btrfsprim.FIRST_CHUNK_TREE_OBJECTID,
+			ItemType: btrfsitem.CHUNK_ITEM_KEY,
+			Offset:   uint64(laddr),
+		},
+	}
+	ret.Chunk.Head.Size = 8 * 1024 * 1024
+	ret.Chunk.Head.Owner = btrfsprim.EXTENT_TREE_OBJECTID
+	ret.Chunk.Head.Type = btrfsvol.BLOCK_GROUP_SYSTEM | btrfsvol.BLOCK_GROUP_DUP
+	ret.Chunk.Head.NumStripes = uint16(len(paddrs))
+	for _, paddr := range paddrs {
+		ret.Chunk.Stripes = append(ret.Chunk.Stripes, btrfsitem.ChunkStripe{
+			DeviceID: 1,
+			Offset:   paddr,
+		})
+	}
+	return ret
+}
+
+func TestSysChunkArrayRoundTrip(t *testing.T) {
+	t.Parallel()
+	in := []btrfstree.SysChunk{
+		sysChunk(0x2000000, 0x3000000, 0x3800000),
+		sysChunk(0x1000000, 0x1000000, 0x1800000),
+	}
+	var sb btrfstree.Superblock
+	require.NoError(t, sb.SetSysChunkArray(in))
+	out, err := sb.ParseSysChunkArray()
+	require.NoError(t, err)
+	// SetSysChunkArray sorts by key.
+	assert.Equal(t, []btrfstree.SysChunk{in[1], in[0]}, out)
+}
+
+func TestSetSysChunkArrayNonSystem(t *testing.T) {
+	t.Parallel()
+	chunk := sysChunk(0x1000000, 0x1000000)
+	chunk.Chunk.Head.Type = btrfsvol.BLOCK_GROUP_METADATA
+	var sb btrfstree.Superblock
+	assert.Error(t, sb.SetSysChunkArray([]btrfstree.SysChunk{chunk}))
+	assert.Equal(t, uint32(0), sb.SysChunkArraySize)
+}
+
+func TestParseSysChunkArrayMalformed(t *testing.T) {
+	t.Parallel()
+	var sb btrfstree.Superblock
+	require.NoError(t, sb.SetSysChunkArray([]btrfstree.SysChunk{
+		sysChunk(0x1000000, 0x1000000),
+		sysChunk(0x2000000, 0x2000000),
+	}))
+	firstSize := int(sb.SysChunkArraySize) / 2
+
+	t.Run("truncated", func(t *testing.T) {
+		t.Parallel()
+		sb := sb
+		sb.SysChunkArraySize -= 8
+		out, err := sb.ParseSysChunkArray()
+		var saErr *btrfstree.SysChunkArrayError
+		require.ErrorAs(t, err, &saErr)
+		assert.Equal(t, firstSize, saErr.Offset)
+		assert.Len(t, out, 1)
+	})
+	t.Run("oversized", func(t *testing.T) {
+		t.Parallel()
+		sb := sb
+		sb.SysChunkArraySize = uint32(len(sb.SysChunkArray)) + 1
+		out, err := sb.ParseSysChunkArray()
+		var saErr *btrfstree.SysChunkArrayError
+		require.ErrorAs(t, err, &saErr)
+		assert.Len(t, out, 0)
+	})
+	t.Run("bad-key-type", func(t *testing.T) {
+		t.Parallel()
+		sb := sb
+		sb.SysChunkArray[firstSize+8] = byte(btrfsitem.DEV_ITEM_KEY)
+		out, err := sb.ParseSysChunkArray()
+		var saErr *btrfstree.SysChunkArrayError
+		require.ErrorAs(t, err, &saErr)
+		assert.Equal(t, firstSize, saErr.Offset)
+		assert.Len(t, out, 1)
+	})
+}
+
+func TestWithRootBackup(t *testing.T) {
+	t.Parallel()
+	sb := btrfstree.Superblock{
+		Generation: 10,
+		RootTree:   0x1000,
+		RootLevel:  1,
+		ChunkTree:  0x2000,
+		LogTree:    0x3000,
+		NodeSize:   4096,
+	}
+	sb.SuperRoots[0] = btrfstree.RootBackup{
+		TreeRoot:       0x4000,
+		TreeRootGen:    8,
+		TreeRootLevel:  2,
+		ChunkRoot:      0x5000,
+		ChunkRootGen:   7,
+		ChunkRootLevel: 0,
+	}
+	old := sb.WithRootBackup(sb.SuperRoots[0])
+	assert.Equal(t, btrfsprim.Generation(8), old.Generation)
+	assert.Equal(t, btrfsvol.LogicalAddr(0x4000), old.RootTree)
+	assert.Equal(t, uint8(2), old.RootLevel)
+	assert.Equal(t, btrfsvol.LogicalAddr(0x5000), old.ChunkTree)
+	assert.Equal(t, btrfsprim.Generation(7), old.ChunkRootGeneration)
+	assert.Equal(t, btrfsvol.LogicalAddr(0), old.LogTree)
+	assert.Equal(t, uint32(4096), old.NodeSize)
+
+	// The original is not modified.
+	assert.Equal(t, btrfsprim.Generation(10), sb.Generation)
+	assert.Equal(t, btrfsvol.LogicalAddr(0x1000), sb.RootTree)
+}
+
+func TestCompatROFlags(t *testing.T) {
+	t.Parallel()
+
+	known := btrfstree.FeatureCompatROFreeSpaceTree | btrfstree.FeatureCompatROFreeSpaceTreeValid
+	assert.Equal(t, "0x3(FeatureCompatROFreeSpaceTree|FeatureCompatROFreeSpaceTreeValid)", known.String())
+	assert.Equal(t, btrfstree.CompatROFlags(0), known.Unknown())
+
+	unknown := known | 1<<10
+	assert.Equal(t, btrfstree.CompatROFlags(1<<10), unknown.Unknown())
+	assert.True(t, unknown.Has(known))
+
+	// Knowing what a flag means doesn't make it safe to write
+	// with a writer that doesn't maintain it...
+	assert.Equal(t, known, known.Unwritable(0))
+	assert.Equal(t, unknown, unknown.Unwritable(0))
+	assert.Equal(t, btrfstree.CompatROFlags(0), btrfstree.CompatROFlags(0).Unwritable(0))
+	// ... but is for one that does.
+	assert.Equal(t, btrfstree.CompatROFlags(0), known.Unwritable(btrfstree.CompatROFlagsKnown))
+	assert.Equal(t, btrfstree.FeatureCompatROFreeSpaceTreeValid, known.Unwritable(btrfstree.FeatureCompatROFreeSpaceTree))
+	// Unknown flags are never writable.
+	assert.Equal(t, btrfstree.CompatROFlags(1<<10), unknown.Unwritable(btrfstree.CompatROFlagsKnown))
+	assert.Equal(t, btrfstree.CompatROFlags(1<<10), unknown.Unwritable(^btrfstree.CompatROFlags(0)))
+}
+
+func TestSuperblockValidateChecksum(t *testing.T) {
+	t.Parallel()
+	for _, typ := range []btrfssum.CSumType{
+		btrfssum.TYPE_CRC32,
+		btrfssum.TYPE_XXHASH,
+		btrfssum.TYPE_SHA256,
+		btrfssum.TYPE_BLAKE2,
+	} {
+		typ := typ
+		t.Run(typ.String(), func(t *testing.T) {
+			t.Parallel()
+			sb := btrfstree.Superblock{
+				FSUUID:       btrfsprim.MustParseUUID("d4d4c8f4-0a4d-4d2b-9a63-6e5b0e0e4b1c"),
+				Generation:   7,
+				ChecksumType: typ,
+			}
+			copy(sb.Magic[:], "_BHRfS_M")
+			var err error
+			sb.Checksum, err = sb.CalculateChecksum()
+			require.NoError(t, err)
+
+			// Round-trip through the on-disk format, as if it
+			// were read from an image.
+			dat, err := binstruct.Marshal(sb)
+			require.NoError(t, err)
+			var parsed btrfstree.Superblock
+			_, err = binstruct.Unmarshal(dat, &parsed)
+			require.NoError(t, err)
+			assert.NoError(t, parsed.ValidateChecksum())
+
+			parsed.Generation++
+			assert.Error(t, parsed.ValidateChecksum())
+		})
+	}
+}
@@ -6,6 +6,8 @@ package btrfsvol
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/fmtutil"
 )
@@ -43,6 +45,28 @@ type QualifiedPhysicalAddr struct {
 	Addr PhysicalAddr
 }
 
+// ParseQualifiedPhysicalAddr parses a physical address of the form
+// "DEV:ADDR" (for example, "1:0x20000"); the inverse of formatting it
+// with "%d:%#x".
+func ParseQualifiedPhysicalAddr(str string) (QualifiedPhysicalAddr, error) {
+	devStr, addrStr, ok := strings.Cut(str, ":")
+	if !ok {
+		return QualifiedPhysicalAddr{}, fmt.Errorf("invalid physical address %q: expected DEV:ADDR", str)
+	}
+	dev, err := strconv.ParseUint(devStr, 0, 64)
+	if err != nil {
+		return QualifiedPhysicalAddr{}, fmt.Errorf("invalid physical address %q: device: %w", str, err)
+	}
+	addr, err := strconv.ParseInt(addrStr, 0, 64)
+	if err != nil {
+		return QualifiedPhysicalAddr{}, fmt.Errorf("invalid physical address %q: address: %w", str, err)
+	}
+	return QualifiedPhysicalAddr{
+		Dev:  DeviceID(dev),
+		Addr: PhysicalAddr(addr),
+	}, nil
+}
+
 func (a QualifiedPhysicalAddr) Add(b AddrDelta) QualifiedPhysicalAddr {
 	return QualifiedPhysicalAddr{
 		Dev:  a.Dev,
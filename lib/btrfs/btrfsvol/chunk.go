@@ -20,6 +20,12 @@ type chunkMapping struct {
 	Size       AddrDelta
 	SizeLocked bool
 	Flags      containers.Optional[BlockGroupFlags]
+
+	// If .Striping is set, then .StripeIdxs is parallel to
+	// .PAddrs, and .PAddrs is sorted by stripe index rather than
+	// by address.
+	Striping   containers.Optional[Striping]
+	StripeIdxs []uint16
 }
 
 // Compare implements containers.Ordered.
@@ -52,6 +58,11 @@ func (a chunkMapping) union(rest ...chunkMapping) (chunkMapping, error) {
 		}
 	}
 	chunks := append([]chunkMapping{a}, rest...)
+	for _, chunk := range chunks {
+		if chunk.Striping.OK {
+			return unionStriped(chunks)
+		}
+	}
 	// figure out the logical range (.LAddr and .Size)
 	beg := chunks[0].LAddr
 	end := chunks[0].LAddr.Add(chunks[0].Size)
@@ -99,3 +110,54 @@ func (a chunkMapping) union(rest ...chunkMapping) (chunkMapping, error) {
 	// done
 	return ret, nil
 }
+
+// unionStriped is the guts of .union() if any of the chunks are
+// striped.  Since a stripe of a striped chunk doesn't hold a
+// contiguous part of the logical range, a striped chunk can only be
+// merged with other stripes of exactly the same chunk; in particular
+// an unstriped mapping (such as a DEV_EXTENT, which doesn't know the
+// striping of its chunk) can't be merged with one.
+func unionStriped(chunks []chunkMapping) (chunkMapping, error) {
+	var ret chunkMapping
+	for _, chunk := range chunks {
+		if chunk.Striping.OK {
+			ret = chunkMapping{
+				LAddr:    chunk.LAddr,
+				Size:     chunk.Size,
+				Flags:    chunk.Flags,
+				Striping: chunk.Striping,
+			}
+			break
+		}
+	}
+	for _, chunk := range chunks {
+		if !chunk.Striping.OK {
+			return chunkMapping{}, fmt.Errorf("striped chunk laddr=%v size=%v striping=%+v overlaps unstriped mapping laddr=%v size=%v",
+				ret.LAddr, ret.Size, ret.Striping.Val, chunk.LAddr, chunk.Size)
+		}
+	}
+	stripes := make(map[uint16]QualifiedPhysicalAddr)
+	for _, chunk := range chunks {
+		if chunk.LAddr != ret.LAddr || chunk.Size != ret.Size || chunk.Striping != ret.Striping {
+			return chunkMapping{}, fmt.Errorf("striped chunk laddr=%v size=%v striping=%+v overlaps mismatched chunk laddr=%v size=%v striping=%+v",
+				ret.LAddr, ret.Size, ret.Striping, chunk.LAddr, chunk.Size, chunk.Striping)
+		}
+		if chunk.Flags != ret.Flags {
+			return chunkMapping{}, fmt.Errorf("mismatch flags: %v != %v", ret.Flags.Val, chunk.Flags.Val)
+		}
+		ret.SizeLocked = ret.SizeLocked || chunk.SizeLocked
+		for i, paddr := range chunk.PAddrs {
+			idx := chunk.StripeIdxs[i]
+			if other, ok := stripes[idx]; ok && other != paddr {
+				return chunkMapping{}, fmt.Errorf("striped chunk laddr=%v has multiple stripes at index %v: %v and %v",
+					ret.LAddr, idx, other, paddr)
+			}
+			stripes[idx] = paddr
+		}
+	}
+	for _, idx := range maps.SortedKeys(stripes) {
+		ret.PAddrs = append(ret.PAddrs, stripes[idx])
+		ret.StripeIdxs = append(ret.StripeIdxs, idx)
+	}
+	return ret, nil
+}
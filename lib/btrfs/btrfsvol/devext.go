@@ -18,6 +18,12 @@ type devextMapping struct {
 	Size       AddrDelta
 	SizeLocked bool
 	Flags      containers.Optional[BlockGroupFlags]
+
+	// If .Striping is set, then .Size is the physical size, and
+	// offsets within the extent do not map linearly to logical
+	// addresses.
+	Striping    containers.Optional[Striping]
+	StripeIndex uint16
 }
 
 // Compare implements containers.Ordered.
@@ -50,6 +56,11 @@ func (a devextMapping) union(rest ...devextMapping) (devextMapping, error) {
 		}
 	}
 	exts := append([]devextMapping{a}, rest...)
+	for _, ext := range exts {
+		if ext.Striping.OK {
+			return unionStripedExt(exts)
+		}
+	}
 	// figure out the physical range (.PAddr and .Size)
 	beg := exts[0].PAddr
 	end := beg.Add(exts[0].Size)
@@ -96,3 +107,20 @@ func (a devextMapping) union(rest ...devextMapping) (devextMapping, error) {
 	// done
 	return ret, nil
 }
+
+// unionStripedExt is the guts of .union() if any of the devexts are
+// striped; a striped devext can only be merged with itself.
+func unionStripedExt(exts []devextMapping) (devextMapping, error) {
+	ret := exts[0]
+	for _, ext := range exts {
+		ret.SizeLocked = ret.SizeLocked || ext.SizeLocked
+	}
+	for _, ext := range exts {
+		ext.SizeLocked = ret.SizeLocked
+		if ext != ret {
+			return devextMapping{}, fmt.Errorf("striped devext paddr=%v size=%v overlaps mismatched devext paddr=%v size=%v",
+				ret.PAddr, ret.Size, ext.PAddr, ext.Size)
+		}
+	}
+	return ret, nil
+}
@@ -8,16 +8,20 @@ package btrfsvol
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
 
 	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
 	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
 )
 
 type LogicalVolume[PhysicalVolume diskio.File[PhysicalAddr]] struct {
@@ -108,6 +112,23 @@ type Mapping struct {
 	Size       AddrDelta
 	SizeLocked bool                                 `json:",omitempty"`
 	Flags      containers.Optional[BlockGroupFlags] `json:",omitempty"`
+
+	// For a RAID0, RAID10, RAID5, or RAID6 chunk, .Striping
+	// describes how the chunk is split across its stripes, and
+	// .StripeIndex says which stripe this mapping is.  .Size is
+	// still the logical size of the whole chunk; see
+	// Striping.PhysicalSize.
+	Striping    containers.Optional[Striping] `json:",omitempty"`
+	StripeIndex uint16                        `json:",omitempty"`
+}
+
+// physicalSize returns how much of the physical volume the mapping
+// occupies.
+func (m Mapping) physicalSize() AddrDelta {
+	if m.Striping.OK {
+		return m.Striping.Val.PhysicalSize(m.Size)
+	}
+	return m.Size
 }
 
 func (lv *LogicalVolume[PhysicalVolume]) CouldAddMapping(m Mapping) bool {
@@ -125,6 +146,15 @@ func (lv *LogicalVolume[PhysicalVolume]) addMapping(m Mapping, dryRun bool) erro
 		return fmt.Errorf("(%p).AddMapping: do not have a physical volume with id=%v",
 			lv, m.PAddr.Dev)
 	}
+	if m.Striping.OK {
+		if err := m.Striping.Val.validate(); err != nil {
+			return fmt.Errorf("(%p).AddMapping: %w", lv, err)
+		}
+		if m.StripeIndex >= m.Striping.Val.NumStripes {
+			return fmt.Errorf("(%p).AddMapping: stripe index %v is out of range for %v stripes",
+				lv, m.StripeIndex, m.Striping.Val.NumStripes)
+		}
+	}
 
 	// logical2physical
 	newChunk := chunkMapping{
@@ -133,6 +163,10 @@ func (lv *LogicalVolume[PhysicalVolume]) addMapping(m Mapping, dryRun bool) erro
 		Size:       m.Size,
 		SizeLocked: m.SizeLocked,
 		Flags:      m.Flags,
+		Striping:   m.Striping,
+	}
+	if m.Striping.OK {
+		newChunk.StripeIdxs = []uint16{m.StripeIndex}
 	}
 	var logicalOverlaps []chunkMapping
 	numOverlappingStripes := 0
@@ -149,11 +183,13 @@ func (lv *LogicalVolume[PhysicalVolume]) addMapping(m Mapping, dryRun bool) erro
 
 	// physical2logical
 	newExt := devextMapping{
-		PAddr:      m.PAddr.Addr,
-		LAddr:      m.LAddr,
-		Size:       m.Size,
-		SizeLocked: m.SizeLocked,
-		Flags:      m.Flags,
+		PAddr:       m.PAddr.Addr,
+		LAddr:       m.LAddr,
+		Size:        m.physicalSize(),
+		SizeLocked:  m.SizeLocked,
+		Flags:       m.Flags,
+		Striping:    m.Striping,
+		StripeIndex: m.StripeIndex,
 	}
 	var physicalOverlaps []devextMapping
 	lv.physical2logical[m.PAddr.Dev].Subrange(newExt.compareRange, func(node *containers.RBNode[devextMapping]) bool {
@@ -175,7 +211,7 @@ func (lv *LogicalVolume[PhysicalVolume]) addMapping(m Mapping, dryRun bool) erro
 		// normal case
 	case len(physicalOverlaps) < numOverlappingStripes:
 		// .Flags = DUP or RAID{X}
-		if newChunk.Flags.OK && newChunk.Flags.Val&BLOCK_GROUP_RAID_MASK == 0 {
+		if newChunk.Flags.OK && newChunk.Flags.Val&BLOCK_GROUP_RAID_MASK == 0 && !newChunk.Striping.OK {
 			return fmt.Errorf("multiple stripes but flags=%v does not allow multiple stripes",
 				newChunk.Flags.Val)
 		}
@@ -228,7 +264,7 @@ func (lv *LogicalVolume[PhysicalVolume]) fsck() error {
 	var err error
 	lv.logical2physical.Range(func(node *containers.RBNode[chunkMapping]) bool {
 		chunk := node.Value
-		for _, stripe := range chunk.PAddrs {
+		for i, stripe := range chunk.PAddrs {
 			if !maps.HasKey(lv.id2pv, stripe.Dev) {
 				err = fmt.Errorf("(%p).fsck: chunk references physical volume %v which does not exist",
 					lv, stripe.Dev)
@@ -237,12 +273,18 @@ func (lv *LogicalVolume[PhysicalVolume]) fsck() error {
 			if !maps.HasKey(physical2logical, stripe.Dev) {
 				physical2logical[stripe.Dev] = new(containers.RBTree[devextMapping])
 			}
-			physical2logical[stripe.Dev].Insert(devextMapping{
+			ext := devextMapping{
 				PAddr: stripe.Addr,
 				LAddr: chunk.LAddr,
 				Size:  chunk.Size,
 				Flags: chunk.Flags,
-			})
+			}
+			if chunk.Striping.OK {
+				ext.Size = chunk.Striping.Val.PhysicalSize(chunk.Size)
+				ext.Striping = chunk.Striping
+				ext.StripeIndex = chunk.StripeIdxs[i]
+			}
+			physical2logical[stripe.Dev].Insert(ext)
 		}
 		return true
 	})
@@ -266,19 +308,36 @@ func (lv *LogicalVolume[PhysicalVolume]) fsck() error {
 
 func (lv *LogicalVolume[PhysicalVolume]) Mappings() []Mapping {
 	var ret []Mapping
+	lv.RangeMappings(func(mapping Mapping) bool {
+		ret = append(ret, mapping)
+		return true
+	})
+	return ret
+}
+
+// RangeMappings calls `fn` for each mapping (in the same order as
+// .Mappings() returns them), without building a []Mapping.  Iteration
+// stops early if `fn` returns false.
+func (lv *LogicalVolume[PhysicalVolume]) RangeMappings(fn func(Mapping) bool) {
 	lv.logical2physical.Range(func(node *containers.RBNode[chunkMapping]) bool {
 		chunk := node.Value
-		for _, slice := range chunk.PAddrs {
-			ret = append(ret, Mapping{
+		for i, slice := range chunk.PAddrs {
+			mapping := Mapping{
 				LAddr: chunk.LAddr,
 				PAddr: slice,
 				Size:  chunk.Size,
 				Flags: chunk.Flags,
-			})
+			}
+			if chunk.Striping.OK {
+				mapping.Striping = chunk.Striping
+				mapping.StripeIndex = chunk.StripeIdxs[i]
+			}
+			if !fn(mapping) {
+				return false
+			}
 		}
 		return true
 	})
-	return ret
 }
 
 func (lv *LogicalVolume[PhysicalVolume]) Resolve(laddr LogicalAddr) (paddrs containers.Set[QualifiedPhysicalAddr], maxlen AddrDelta) {
@@ -294,6 +353,16 @@ func (lv *LogicalVolume[PhysicalVolume]) Resolve(laddr LogicalAddr) (paddrs cont
 	offsetWithinChunk := laddr.Sub(chunk.LAddr)
 	paddrs = make(containers.Set[QualifiedPhysicalAddr])
 	maxlen = chunk.Size - offsetWithinChunk
+	if chunk.Striping.OK {
+		firstIdx, offsetWithinStripe, stripeMaxlen := chunk.Striping.Val.resolve(offsetWithinChunk)
+		maxlen = slices.Min(maxlen, stripeMaxlen)
+		for i, stripe := range chunk.PAddrs {
+			if idx := chunk.StripeIdxs[i]; idx >= firstIdx && idx < firstIdx+chunk.Striping.Val.SubStripes {
+				paddrs.Insert(stripe.Add(offsetWithinStripe))
+			}
+		}
+		return paddrs, maxlen
+	}
 	for _, stripe := range chunk.PAddrs {
 		paddrs.Insert(stripe.Add(offsetWithinChunk))
 	}
@@ -322,6 +391,13 @@ func (lv *LogicalVolume[PhysicalVolume]) UnResolve(paddr QualifiedPhysicalAddr)
 	ext := node.Value
 
 	offsetWithinExt := paddr.Addr.Sub(ext.PAddr)
+	if ext.Striping.OK {
+		offsetWithinChunk, ok := ext.Striping.Val.unresolve(ext.StripeIndex, offsetWithinExt)
+		if !ok {
+			return -1
+		}
+		return ext.LAddr.Add(offsetWithinChunk)
+	}
 	return ext.LAddr.Add(offsetWithinExt)
 }
 
@@ -340,6 +416,20 @@ func (lv *LogicalVolume[PhysicalVolume]) ReadAt(dat []byte, laddr LogicalAddr) (
 var ErrCouldNotMap = errors.New("could not map logical address")
 
 func (lv *LogicalVolume[PhysicalVolume]) maybeShortReadAt(dat []byte, laddr LogicalAddr) (int, error) {
+	n, err := lv.maybeShortReadAtMirrors(dat, laddr)
+	if err != nil {
+		if _, hasParity := lv.parityChunk(laddr); hasParity {
+			n, rErr := lv.reconstructAt(dat, laddr, nil)
+			if rErr != nil {
+				return 0, fmt.Errorf("%w (and %v)", err, rErr)
+			}
+			return n, nil
+		}
+	}
+	return n, err
+}
+
+func (lv *LogicalVolume[PhysicalVolume]) maybeShortReadAtMirrors(dat []byte, laddr LogicalAddr) (int, error) {
 	paddrs, maxlen := lv.Resolve(laddr)
 	if len(paddrs) == 0 {
 		return 0, fmt.Errorf("read: %w %v", ErrCouldNotMap, laddr)
@@ -369,6 +459,74 @@ func (lv *LogicalVolume[PhysicalVolume]) maybeShortReadAt(dat []byte, laddr Logi
 	return len(dat), nil
 }
 
+// ReadAtVerified is like ReadAt, but rather than insisting that all
+// mirrors of `laddr` agree, it tries each mirror in turn and returns
+// the first one for which `verify` returns nil; mirrors that fail to
+// read or fail to verify are logged and skipped.  If none of them
+// verify and the chunk has parity, the data is reconstructed from
+// parity.  It is for reading a single checksummed block, so `dat`
+// must not span chunks.
+func (lv *LogicalVolume[PhysicalVolume]) ReadAtVerified(ctx context.Context, dat []byte, laddr LogicalAddr, verify func([]byte) error) (int, error) {
+	n, err := lv.readAtVerifiedMirrors(ctx, dat, laddr, verify)
+	if err == nil {
+		return n, nil
+	}
+	if _, hasParity := lv.parityChunk(laddr); !hasParity {
+		return 0, err
+	}
+	dlog.Errorf(ctx, "laddr=%v: %v; reconstructing from parity", laddr, err)
+	buf := make([]byte, len(dat))
+	if _, rErr := lv.reconstructAt(buf, laddr, verify); rErr != nil {
+		return 0, fmt.Errorf("%w (and %v)", err, rErr)
+	}
+	return copy(dat, buf), nil
+}
+
+func (lv *LogicalVolume[PhysicalVolume]) readAtVerifiedMirrors(ctx context.Context, dat []byte, laddr LogicalAddr, verify func([]byte) error) (int, error) {
+	paddrs, maxlen := lv.Resolve(laddr)
+	if len(paddrs) == 0 {
+		return 0, fmt.Errorf("read: %w %v", ErrCouldNotMap, laddr)
+	}
+	if AddrDelta(len(dat)) > maxlen {
+		return 0, fmt.Errorf("read: laddr=%v len=%v is not contiguous on disk", laddr, len(dat))
+	}
+
+	sorted := maps.Keys(paddrs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Compare(sorted[j]) < 0
+	})
+
+	buf := make([]byte, len(dat))
+	var errs derror.MultiError
+	for _, paddr := range sorted {
+		err := func() error {
+			dev, ok := lv.id2pv[paddr.Dev]
+			if !ok {
+				return fmt.Errorf("device=%v does not exist", paddr.Dev)
+			}
+			if _, err := dev.ReadAt(buf, paddr.Addr); err != nil {
+				return fmt.Errorf("read device=%v paddr=%v: %w", paddr.Dev, paddr.Addr, err)
+			}
+			if err := verify(buf); err != nil {
+				return fmt.Errorf("device=%v paddr=%v: %w", paddr.Dev, paddr.Addr, err)
+			}
+			return nil
+		}()
+		if err != nil {
+			if len(paddrs) > 1 {
+				dlog.Errorf(ctx, "laddr=%v: bad mirror: %v", laddr, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		return copy(dat, buf), nil
+	}
+	if len(errs) == 1 {
+		return 0, errs[0]
+	}
+	return 0, fmt.Errorf("no good mirror at laddr=%v: %w", laddr, errs)
+}
+
 func (lv *LogicalVolume[PhysicalVolume]) WriteAt(dat []byte, laddr LogicalAddr) (int, error) {
 	done := 0
 	for done < len(dat) {
@@ -382,6 +540,9 @@ func (lv *LogicalVolume[PhysicalVolume]) WriteAt(dat []byte, laddr LogicalAddr)
 }
 
 func (lv *LogicalVolume[PhysicalVolume]) maybeShortWriteAt(dat []byte, laddr LogicalAddr) (int, error) {
+	if _, hasParity := lv.parityChunk(laddr); hasParity {
+		return 0, fmt.Errorf("write: laddr=%v: writing to chunks with parity is not supported", laddr)
+	}
 	paddrs, maxlen := lv.Resolve(laddr)
 	if len(paddrs) == 0 {
 		return 0, fmt.Errorf("write: %w %v", ErrCouldNotMap, laddr)
@@ -0,0 +1,220 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsvol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"git.lukeshu.com/go/lowmemjson"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/fmtutil"
+)
+
+// A fragmented filesystem can have hundreds of thousands of mappings,
+// so rather than going through a []Mapping, these encode and decode
+// one Mapping at a time.
+
+// MappingsJSON returns a lowmemjson.Encodable that encodes the
+// logical volume's mappings as a JSON array, one Mapping at a time.
+func (lv *LogicalVolume[PhysicalVolume]) MappingsJSON() lowmemjson.Encodable {
+	return mappingsEncoder(lv.RangeMappings)
+}
+
+type mappingsEncoder func(fn func(Mapping) bool)
+
+var _ lowmemjson.Encodable = mappingsEncoder(nil)
+
+func (rangeFn mappingsEncoder) EncodeJSON(w io.Writer) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	var err error
+	first := true
+	rangeFn(func(mapping Mapping) bool {
+		if !first {
+			if _, err = io.WriteString(w, ","); err != nil {
+				return false
+			}
+		}
+		first = false
+		err = lowmemjson.NewEncoder(w).Encode(mapping)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// DecodeMappings decodes a JSON array of Mappings from `r`, calling
+// `fn` for each Mapping as it is decoded rather than collecting them
+// in to a []Mapping.  Decoding stops at the first error returned by
+// `fn`.  Both the plain form written by MappingsJSON and the
+// human-readable form written by DumpMappingsJSON are accepted.
+func DecodeMappings(r io.RuneScanner, fn func(Mapping) error) error {
+	return lowmemjson.DecodeArray(r, func(r io.RuneScanner) error {
+		var mapping mappingJSON
+		if err := lowmemjson.NewDecoder(r).Decode(&mapping); err != nil {
+			return err
+		}
+		return fn(mapping.mapping())
+	})
+}
+
+// DumpMappingsJSON writes `mappings` to `w` as a JSON array.  Unlike
+// encoding the []Mapping directly, the .PAddr and .Flags of each
+// Mapping are written as human-readable strings ("1:0x20000",
+// "DATA|RAID1"); DecodeMappings and LoadMappingsJSON accept either
+// form.
+func DumpMappingsJSON(w io.Writer, mappings []Mapping) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, mapping := range mappings {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := lowmemjson.NewEncoder(w).Encode(mappingJSON{
+			LAddr:       mapping.LAddr,
+			PAddr:       qualifiedPhysicalAddrJSON(mapping.PAddr),
+			Size:        mapping.Size,
+			SizeLocked:  mapping.SizeLocked,
+			Flags:       containers.Optional[blockGroupFlagsJSON]{OK: mapping.Flags.OK, Val: blockGroupFlagsJSON(mapping.Flags.Val)},
+			Striping:    mapping.Striping,
+			StripeIndex: mapping.StripeIndex,
+		}); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// LoadMappingsJSON reads a JSON array of Mappings, as written by
+// either DumpMappingsJSON or MappingsJSON, from `r`.
+func LoadMappingsJSON(r io.Reader) ([]Mapping, error) {
+	var ret []Mapping
+	if err := DecodeMappings(bufio.NewReader(r), func(mapping Mapping) error {
+		ret = append(ret, mapping)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// mappingJSON is the on-disk form of a Mapping, accepting both the
+// plain and the human-readable forms of .PAddr and .Flags.
+type mappingJSON struct {
+	LAddr       LogicalAddr
+	PAddr       qualifiedPhysicalAddrJSON
+	Size        AddrDelta
+	SizeLocked  bool                                     `json:",omitempty"`
+	Flags       containers.Optional[blockGroupFlagsJSON] `json:",omitempty"`
+	Striping    containers.Optional[Striping]            `json:",omitempty"`
+	StripeIndex uint16                                   `json:",omitempty"`
+}
+
+func (m mappingJSON) mapping() Mapping {
+	return Mapping{
+		LAddr:       m.LAddr,
+		PAddr:       QualifiedPhysicalAddr(m.PAddr),
+		Size:        m.Size,
+		SizeLocked:  m.SizeLocked,
+		Flags:       containers.Optional[BlockGroupFlags]{OK: m.Flags.OK, Val: BlockGroupFlags(m.Flags.Val)},
+		Striping:    m.Striping,
+		StripeIndex: m.StripeIndex,
+	}
+}
+
+// peekRune returns the next rune that `r` will read, without
+// consuming it.
+func peekRune(r io.RuneScanner) (rune, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	return c, r.UnreadRune()
+}
+
+type qualifiedPhysicalAddrJSON QualifiedPhysicalAddr
+
+var (
+	_ lowmemjson.Encodable = qualifiedPhysicalAddrJSON{}
+	_ lowmemjson.Decodable = (*qualifiedPhysicalAddrJSON)(nil)
+)
+
+func (a qualifiedPhysicalAddrJSON) EncodeJSON(w io.Writer) error {
+	return lowmemjson.NewEncoder(w).Encode(fmt.Sprintf("%d:%#x", uint64(a.Dev), int64(a.Addr)))
+}
+
+func (a *qualifiedPhysicalAddrJSON) DecodeJSON(r io.RuneScanner) error {
+	c, err := peekRune(r)
+	if err != nil {
+		return err
+	}
+	if c != '"' {
+		return lowmemjson.NewDecoder(r).Decode((*QualifiedPhysicalAddr)(a))
+	}
+	var str string
+	if err := lowmemjson.NewDecoder(r).Decode(&str); err != nil {
+		return err
+	}
+	addr, err := ParseQualifiedPhysicalAddr(str)
+	if err != nil {
+		return err
+	}
+	*a = qualifiedPhysicalAddrJSON(addr)
+	return nil
+}
+
+type blockGroupFlagsJSON BlockGroupFlags
+
+var (
+	_ lowmemjson.Encodable = blockGroupFlagsJSON(0)
+	_ lowmemjson.Decodable = (*blockGroupFlagsJSON)(nil)
+)
+
+func (f blockGroupFlagsJSON) EncodeJSON(w io.Writer) error {
+	// Not BlockGroupFlags.String(), because of the "|single"
+	// pseudo-flag.
+	return lowmemjson.NewEncoder(w).Encode(fmtutil.BitfieldString(f, blockGroupFlagNames, fmtutil.HexNone))
+}
+
+func (f *blockGroupFlagsJSON) DecodeJSON(r io.RuneScanner) error {
+	c, err := peekRune(r)
+	if err != nil {
+		return err
+	}
+	if c != '"' {
+		return lowmemjson.NewDecoder(r).Decode((*BlockGroupFlags)(f))
+	}
+	var str string
+	if err := lowmemjson.NewDecoder(r).Decode(&str); err != nil {
+		return err
+	}
+	*f = 0
+	if str == "none" {
+		return nil
+	}
+names:
+	for _, name := range strings.Split(str, "|") {
+		for bit, bitName := range blockGroupFlagNames {
+			if name == bitName {
+				*f |= 1 << bit
+				continue names
+			}
+		}
+		return fmt.Errorf("invalid block group flags %q: unknown flag %q", str, name)
+	}
+	return nil
+}
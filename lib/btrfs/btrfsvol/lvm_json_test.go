@@ -0,0 +1,167 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsvol_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"git.lukeshu.com/go/lowmemjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+type phonyPV struct{}
+
+func (phonyPV) Name() string                                       { return "phony" }
+func (phonyPV) Size() btrfsvol.PhysicalAddr                        { return 1 << 30 }
+func (phonyPV) Close() error                                       { return nil }
+func (phonyPV) ReadAt([]byte, btrfsvol.PhysicalAddr) (int, error)  { return 0, nil }
+func (phonyPV) WriteAt([]byte, btrfsvol.PhysicalAddr) (int, error) { return 0, nil }
+
+func newTestLV(t *testing.T) *btrfsvol.LogicalVolume[phonyPV] {
+	t.Helper()
+	lv := new(btrfsvol.LogicalVolume[phonyPV])
+	require.NoError(t, lv.AddPhysicalVolume(1, phonyPV{}))
+	require.NoError(t, lv.AddPhysicalVolume(2, phonyPV{}))
+	return lv
+}
+
+func TestMappingsJSON(t *testing.T) {
+	t.Parallel()
+
+	lv := newTestLV(t)
+	for _, mapping := range []btrfsvol.Mapping{
+		{
+			LAddr: 0x10000,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x20000},
+			Size:  0x1000,
+			Flags: containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID1),
+		},
+		{
+			LAddr: 0x10000,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: 0x30000},
+			Size:  0x1000,
+			Flags: containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID1),
+		},
+		{
+			LAddr:      0x40000,
+			PAddr:      btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x50000},
+			Size:       0x2000,
+			SizeLocked: true,
+		},
+		{
+			LAddr:       0x80000,
+			PAddr:       btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x60000},
+			Size:        0x20000,
+			Flags:       containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID0),
+			Striping:    containers.OptionalValue(btrfsvol.Striping{StripeLen: 0x10000, NumStripes: 2, SubStripes: 1}),
+			StripeIndex: 0,
+		},
+		{
+			LAddr:       0x80000,
+			PAddr:       btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: 0x70000},
+			Size:        0x20000,
+			Flags:       containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID0),
+			Striping:    containers.OptionalValue(btrfsvol.Striping{StripeLen: 0x10000, NumStripes: 2, SubStripes: 1}),
+			StripeIndex: 1,
+		},
+	} {
+		require.NoError(t, lv.AddMapping(mapping))
+	}
+
+	// Streaming should produce the same output as encoding the
+	// []Mapping.
+	var exp, act bytes.Buffer
+	require.NoError(t, lowmemjson.NewEncoder(&exp).Encode(lv.Mappings()))
+	require.NoError(t, lowmemjson.NewEncoder(&act).Encode(lv.MappingsJSON()))
+	assert.Equal(t, exp.String(), act.String())
+
+	// And it should round-trip.
+	lv2 := newTestLV(t)
+	require.NoError(t, btrfsvol.DecodeMappings(strings.NewReader(act.String()), lv2.AddMapping))
+	assert.Equal(t, lv.Mappings(), lv2.Mappings())
+
+	// Empty.
+	act.Reset()
+	require.NoError(t, lowmemjson.NewEncoder(&act).Encode(newTestLV(t).MappingsJSON()))
+	assert.Equal(t, "[]", act.String())
+	var n int
+	require.NoError(t, btrfsvol.DecodeMappings(strings.NewReader("[]"), func(btrfsvol.Mapping) error {
+		n++
+		return nil
+	}))
+	assert.Equal(t, 0, n)
+}
+
+func TestDumpMappingsJSON(t *testing.T) {
+	t.Parallel()
+
+	mappings := []btrfsvol.Mapping{
+		{
+			LAddr: 0x10000,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x20000},
+			Size:  0x1000,
+			Flags: containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID1),
+		},
+		{
+			LAddr:      0x40000,
+			PAddr:      btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: 0x50000},
+			Size:       0x2000,
+			SizeLocked: true,
+		},
+		{
+			LAddr: 0x80000,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 0x60000},
+			Size:  0x1000,
+			Flags: containers.OptionalValue(btrfsvol.BlockGroupFlags(0)),
+		},
+		{
+			LAddr:       0x90000,
+			PAddr:       btrfsvol.QualifiedPhysicalAddr{Dev: 2, Addr: 0x70000},
+			Size:        0x20000,
+			Flags:       containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID0),
+			Striping:    containers.OptionalValue(btrfsvol.Striping{StripeLen: 0x10000, NumStripes: 2, SubStripes: 1}),
+			StripeIndex: 1,
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, btrfsvol.DumpMappingsJSON(&buf, mappings))
+	assert.Contains(t, buf.String(), `{"LAddr":65536,"PAddr":"1:0x20000","Size":4096,"Flags":"DATA|RAID1","Striping":null}`)
+	assert.Contains(t, buf.String(), `"Flags":"none"`)
+
+	act, err := btrfsvol.LoadMappingsJSON(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, mappings, act)
+
+	// The plain form is still accepted.
+	buf.Reset()
+	require.NoError(t, lowmemjson.NewEncoder(&buf).Encode(mappings))
+	act, err = btrfsvol.LoadMappingsJSON(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, mappings, act)
+
+	// Empty.
+	buf.Reset()
+	require.NoError(t, btrfsvol.DumpMappingsJSON(&buf, nil))
+	assert.Equal(t, "[]", buf.String())
+	act, err = btrfsvol.LoadMappingsJSON(&buf)
+	require.NoError(t, err)
+	assert.Empty(t, act)
+
+	// Bad input.
+	for _, in := range []string{
+		`[{"LAddr":0,"PAddr":"1-0x20000","Size":4096}]`,
+		`[{"LAddr":0,"PAddr":"1:0x20000","Size":4096,"Flags":"DATA|RAID9"}]`,
+	} {
+		_, err := btrfsvol.LoadMappingsJSON(strings.NewReader(in))
+		assert.Error(t, err, in)
+	}
+}
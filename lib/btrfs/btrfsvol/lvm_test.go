@@ -0,0 +1,551 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsvol_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+type memPV []byte
+
+func (memPV) Name() string                   { return "mem" }
+func (pv memPV) Size() btrfsvol.PhysicalAddr { return btrfsvol.PhysicalAddr(len(pv)) }
+func (memPV) Close() error                   { return nil }
+
+func (pv memPV) ReadAt(p []byte, off btrfsvol.PhysicalAddr) (int, error) {
+	return copy(p, pv[off:]), nil
+}
+
+func (pv memPV) WriteAt(p []byte, off btrfsvol.PhysicalAddr) (int, error) {
+	return copy(pv[off:], p), nil
+}
+
+func TestReadAtVerified(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	good := bytes.Repeat([]byte("good"), 0x400)
+	bad := bytes.Repeat([]byte("bad!"), 0x400)
+	verify := func(dat []byte) error {
+		if !bytes.Equal(dat, good) {
+			return errors.New("corrupt")
+		}
+		return nil
+	}
+
+	newLV := func(t *testing.T, dat1, dat2 []byte) *btrfsvol.LogicalVolume[memPV] {
+		t.Helper()
+		lv := new(btrfsvol.LogicalVolume[memPV])
+		pv1, pv2 := make(memPV, 0x10000), make(memPV, 0x10000)
+		copy(pv1[0x2000:], dat1)
+		copy(pv2[0x3000:], dat2)
+		require.NoError(t, lv.AddPhysicalVolume(1, pv1))
+		require.NoError(t, lv.AddPhysicalVolume(2, pv2))
+		for _, paddr := range []btrfsvol.QualifiedPhysicalAddr{{Dev: 1, Addr: 0x2000}, {Dev: 2, Addr: 0x3000}} {
+			require.NoError(t, lv.AddMapping(btrfsvol.Mapping{
+				LAddr: 0x10000,
+				PAddr: paddr,
+				Size:  0x1000,
+				Flags: containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID1),
+			}))
+		}
+		return lv
+	}
+
+	t.Run("first-corrupt", func(t *testing.T) {
+		t.Parallel()
+		lv := newLV(t, bad, good)
+		buf := make([]byte, len(good))
+
+		// A plain read refuses to choose.
+		_, err := lv.ReadAt(buf, 0x10000)
+		assert.Error(t, err)
+
+		n, err := lv.ReadAtVerified(ctx, buf, 0x10000, verify)
+		require.NoError(t, err)
+		assert.Equal(t, len(good), n)
+		assert.Equal(t, good, buf)
+	})
+	t.Run("second-corrupt", func(t *testing.T) {
+		t.Parallel()
+		lv := newLV(t, good, bad)
+		buf := make([]byte, len(good))
+		n, err := lv.ReadAtVerified(ctx, buf, 0x10000, verify)
+		require.NoError(t, err)
+		assert.Equal(t, len(good), n)
+		assert.Equal(t, good, buf)
+	})
+	t.Run("both-corrupt", func(t *testing.T) {
+		t.Parallel()
+		lv := newLV(t, bad, bad)
+		buf := make([]byte, len(good))
+		_, err := lv.ReadAtVerified(ctx, buf, 0x10000, verify)
+		assert.ErrorContains(t, err, "no good mirror")
+	})
+	t.Run("unmapped", func(t *testing.T) {
+		t.Parallel()
+		lv := newLV(t, good, good)
+		buf := make([]byte, len(good))
+		_, err := lv.ReadAtVerified(ctx, buf, 0x20000, verify)
+		assert.ErrorIs(t, err, btrfsvol.ErrCouldNotMap)
+	})
+}
+
+func TestReadAtStriped(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		Flags      btrfsvol.BlockGroupFlags
+		SubStripes uint16
+		NumDevs    int
+	}
+	testcases := map[string]TestCase{
+		"raid0":  {btrfsvol.BLOCK_GROUP_RAID0, 1, 2},
+		"raid10": {btrfsvol.BLOCK_GROUP_RAID10, 2, 4},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			const (
+				stripeLen = 0x1000
+				chunkSize = 0x4000
+				laddrBeg  = 0x100000
+				paddrBeg  = 0x2000
+			)
+			striping := btrfsvol.Striping{
+				StripeLen:  stripeLen,
+				NumStripes: uint16(tc.NumDevs),
+				SubStripes: tc.SubStripes,
+			}
+			physSize := striping.PhysicalSize(chunkSize)
+			assert.Equal(t, btrfsvol.AddrDelta(chunkSize/2), physSize)
+
+			// Lay out the chunk the way the kernel does: the
+			// logical range is cut in to stripeLen pieces that go
+			// to alternating (pairs of) devices.
+			exp := make([]byte, chunkSize)
+			for i := range exp {
+				exp[i] = byte(i/stripeLen + 1)
+				exp[i] ^= byte(i)
+			}
+			pvs := make([]memPV, tc.NumDevs)
+			for i := range pvs {
+				pvs[i] = make(memPV, 0x10000)
+			}
+			numDataStripes := tc.NumDevs / int(tc.SubStripes)
+			for piece := 0; piece < chunkSize/stripeLen; piece++ {
+				dataStripe := piece % numDataStripes
+				row := piece / numDataStripes
+				for j := 0; j < int(tc.SubStripes); j++ {
+					copy(pvs[dataStripe*int(tc.SubStripes)+j][paddrBeg+row*stripeLen:],
+						exp[piece*stripeLen:(piece+1)*stripeLen])
+				}
+			}
+
+			lv := new(btrfsvol.LogicalVolume[memPV])
+			for i, pv := range pvs {
+				require.NoError(t, lv.AddPhysicalVolume(btrfsvol.DeviceID(i+1), pv))
+			}
+			for i := range pvs {
+				require.NoError(t, lv.AddMapping(btrfsvol.Mapping{
+					LAddr:       laddrBeg,
+					PAddr:       btrfsvol.QualifiedPhysicalAddr{Dev: btrfsvol.DeviceID(i + 1), Addr: paddrBeg},
+					Size:        chunkSize,
+					SizeLocked:  true,
+					Flags:       containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | tc.Flags),
+					Striping:    containers.OptionalValue(striping),
+					StripeIndex: uint16(i),
+				}))
+			}
+			assert.Len(t, lv.Mappings(), tc.NumDevs)
+
+			// The whole chunk, and a read that spans a stripe
+			// boundary.
+			act := make([]byte, chunkSize)
+			n, err := lv.ReadAt(act, laddrBeg)
+			require.NoError(t, err)
+			assert.Equal(t, chunkSize, n)
+			assert.Equal(t, exp, act)
+
+			act = make([]byte, 0x200)
+			n, err = lv.ReadAt(act, laddrBeg+stripeLen-0x100)
+			require.NoError(t, err)
+			assert.Equal(t, len(act), n)
+			assert.Equal(t, exp[stripeLen-0x100:stripeLen+0x100], act)
+
+			// The second stripe-piece is at the start of the
+			// second data stripe.
+			paddrs, maxlen := lv.Resolve(laddrBeg + stripeLen + 0x10)
+			assert.Equal(t, btrfsvol.AddrDelta(stripeLen-0x10), maxlen)
+			assert.Len(t, paddrs, int(tc.SubStripes))
+			for paddr := range paddrs {
+				assert.Equal(t, btrfsvol.PhysicalAddr(paddrBeg+0x10), paddr.Addr)
+				assert.Equal(t, laddrBeg+btrfsvol.LogicalAddr(stripeLen+0x10), lv.UnResolve(paddr))
+			}
+
+			// Nothing should have been mapped past the end of
+			// each stripe's physical extent.
+			assert.Equal(t, btrfsvol.LogicalAddr(-1),
+				lv.UnResolve(btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: paddrBeg + btrfsvol.PhysicalAddr(physSize)}))
+		})
+	}
+}
+
+func TestReadAtRAID5(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	const (
+		numDevs   = 3
+		stripeLen = 0x1000
+		chunkSize = 0x6000
+		laddrBeg  = 0x100000
+		paddrBeg  = 0x2000
+	)
+	striping := btrfsvol.Striping{
+		StripeLen:  stripeLen,
+		NumStripes: numDevs,
+		SubStripes: 1,
+		Parity:     1,
+	}
+
+	exp := make([]byte, chunkSize)
+	for i := range exp {
+		exp[i] = byte(i/stripeLen+1) ^ byte(i)
+	}
+
+	// Lay out the chunk the way the kernel does: each row has 2
+	// data pieces and 1 parity piece, and each row is rotated by
+	// 1 device.
+	//
+	//	       dev1  dev2  dev3
+	//	row 0:  d0    d1    P
+	//	row 1:  P     d2    d3
+	//	row 2:  d5    P     d4
+	newPVs := func() []memPV {
+		pvs := make([]memPV, numDevs)
+		for i := range pvs {
+			pvs[i] = make(memPV, 0x10000)
+		}
+		for row := 0; row < chunkSize/stripeLen/(numDevs-1); row++ {
+			parity := make([]byte, stripeLen)
+			for col := 0; col < numDevs-1; col++ {
+				piece := exp[(row*(numDevs-1)+col)*stripeLen:][:stripeLen]
+				copy(pvs[(row+col)%numDevs][paddrBeg+row*stripeLen:], piece)
+				for i := range parity {
+					parity[i] ^= piece[i]
+				}
+			}
+			copy(pvs[(row+numDevs-1)%numDevs][paddrBeg+row*stripeLen:], parity)
+		}
+		return pvs
+	}
+	newLV := func(t *testing.T, pvs []memPV) *btrfsvol.LogicalVolume[memPV] {
+		t.Helper()
+		lv := new(btrfsvol.LogicalVolume[memPV])
+		for i, pv := range pvs {
+			if pv == nil {
+				continue
+			}
+			require.NoError(t, lv.AddPhysicalVolume(btrfsvol.DeviceID(i+1), pv))
+			require.NoError(t, lv.AddMapping(btrfsvol.Mapping{
+				LAddr:       laddrBeg,
+				PAddr:       btrfsvol.QualifiedPhysicalAddr{Dev: btrfsvol.DeviceID(i + 1), Addr: paddrBeg},
+				Size:        chunkSize,
+				SizeLocked:  true,
+				Flags:       containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID5),
+				Striping:    containers.OptionalValue(striping),
+				StripeIndex: uint16(i),
+			}))
+		}
+		return lv
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		t.Parallel()
+		lv := newLV(t, newPVs())
+		act := make([]byte, chunkSize)
+		n, err := lv.ReadAt(act, laddrBeg)
+		require.NoError(t, err)
+		assert.Equal(t, chunkSize, n)
+		assert.Equal(t, exp, act)
+
+		// d3 is on dev3, in the second row; the parity
+		// above it isn't mapped to anything.
+		assert.Equal(t, laddrBeg+btrfsvol.LogicalAddr(3*stripeLen+0x10),
+			lv.UnResolve(btrfsvol.QualifiedPhysicalAddr{Dev: 3, Addr: paddrBeg + stripeLen + 0x10}))
+		assert.Equal(t, btrfsvol.LogicalAddr(-1),
+			lv.UnResolve(btrfsvol.QualifiedPhysicalAddr{Dev: 3, Addr: paddrBeg + 0x10}))
+
+		_, err = lv.WriteAt(act[:0x10], laddrBeg)
+		assert.Error(t, err)
+	})
+	t.Run("missing-device", func(t *testing.T) {
+		t.Parallel()
+		pvs := newPVs()
+		pvs[1] = nil
+		lv := newLV(t, pvs)
+		act := make([]byte, chunkSize)
+		n, err := lv.ReadAt(act, laddrBeg)
+		require.NoError(t, err)
+		assert.Equal(t, chunkSize, n)
+		assert.Equal(t, exp, act)
+	})
+	t.Run("zeroed-device", func(t *testing.T) {
+		t.Parallel()
+		pvs := newPVs()
+		for i := range pvs[1] {
+			pvs[1][i] = 0
+		}
+		lv := newLV(t, pvs)
+
+		// A plain read can't tell that anything is wrong...
+		act := make([]byte, stripeLen)
+		_, err := lv.ReadAt(act, laddrBeg+stripeLen)
+		require.NoError(t, err)
+		assert.NotEqual(t, exp[stripeLen:2*stripeLen], act)
+
+		// ... but a verified read notices, and reconstructs
+		// each piece from the others.
+		for piece := 0; piece < chunkSize/stripeLen; piece++ {
+			piece := piece
+			want := exp[piece*stripeLen:][:stripeLen]
+			act := make([]byte, stripeLen)
+			n, err := lv.ReadAtVerified(ctx, act, laddrBeg+btrfsvol.LogicalAddr(piece*stripeLen), func(dat []byte) error {
+				if !bytes.Equal(dat, want) {
+					return errors.New("corrupt")
+				}
+				return nil
+			})
+			require.NoError(t, err, "piece %v", piece)
+			assert.Equal(t, stripeLen, n)
+			assert.Equal(t, want, act)
+		}
+	})
+}
+
+// slowGFMul multiplies in GF(2^8) the long way, to check the
+// table-driven RAID6 math against.
+func slowGFMul(a, b byte) byte {
+	var ret byte
+	for b != 0 {
+		if b&1 != 0 {
+			ret ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1d
+		}
+		b >>= 1
+	}
+	return ret
+}
+
+func TestReadAtRAID6(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	const (
+		numDevs   = 5
+		numData   = numDevs - 2
+		stripeLen = 0x1000
+		chunkSize = numData * 5 * stripeLen
+		laddrBeg  = 0x100000
+		paddrBeg  = 0x2000
+	)
+	striping := btrfsvol.Striping{
+		StripeLen:  stripeLen,
+		NumStripes: numDevs,
+		SubStripes: 1,
+		Parity:     2,
+	}
+
+	exp := make([]byte, chunkSize)
+	for i := range exp {
+		exp[i] = byte(i/stripeLen+1) ^ byte(i*7)
+	}
+
+	// Each row has 3 data pieces, then P, then Q; and each row is
+	// rotated by 1 device.
+	newPVs := func() []memPV {
+		pvs := make([]memPV, numDevs)
+		for i := range pvs {
+			pvs[i] = make(memPV, 0x40000)
+		}
+		for row := 0; row < chunkSize/stripeLen/numData; row++ {
+			p := make([]byte, stripeLen)
+			q := make([]byte, stripeLen)
+			var g byte = 1
+			for col := 0; col < numData; col++ {
+				piece := exp[(row*numData+col)*stripeLen:][:stripeLen]
+				copy(pvs[(row+col)%numDevs][paddrBeg+row*stripeLen:], piece)
+				for i := range piece {
+					p[i] ^= piece[i]
+					q[i] ^= slowGFMul(g, piece[i])
+				}
+				g = slowGFMul(g, 2)
+			}
+			copy(pvs[(row+numData)%numDevs][paddrBeg+row*stripeLen:], p)
+			copy(pvs[(row+numData+1)%numDevs][paddrBeg+row*stripeLen:], q)
+		}
+		return pvs
+	}
+	newLV := func(t *testing.T, pvs []memPV) *btrfsvol.LogicalVolume[memPV] {
+		t.Helper()
+		lv := new(btrfsvol.LogicalVolume[memPV])
+		for i, pv := range pvs {
+			if pv == nil {
+				continue
+			}
+			require.NoError(t, lv.AddPhysicalVolume(btrfsvol.DeviceID(i+1), pv))
+			require.NoError(t, lv.AddMapping(btrfsvol.Mapping{
+				LAddr:       laddrBeg,
+				PAddr:       btrfsvol.QualifiedPhysicalAddr{Dev: btrfsvol.DeviceID(i + 1), Addr: paddrBeg},
+				Size:        chunkSize,
+				SizeLocked:  true,
+				Flags:       containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID6),
+				Striping:    containers.OptionalValue(striping),
+				StripeIndex: uint16(i),
+			}))
+		}
+		return lv
+	}
+	verifyPiece := func(want []byte) func([]byte) error {
+		return func(dat []byte) error {
+			if !bytes.Equal(dat, want) {
+				return errors.New("corrupt")
+			}
+			return nil
+		}
+	}
+
+	type TestCase struct {
+		Missing []int
+		Zeroed  []int
+	}
+	testcases := map[string]TestCase{
+		"healthy":         {},
+		"missing-1":       {Missing: []int{1}},
+		"missing-2":       {Missing: []int{1, 3}},
+		"missing-2-adj":   {Missing: []int{0, 1}},
+		"zeroed-1":        {Zeroed: []int{2}},
+		"zeroed-2":        {Zeroed: []int{0, 4}},
+		"missing-zeroed":  {Missing: []int{3}, Zeroed: []int{2}},
+		"missing-2-wraps": {Missing: []int{4, 0}},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			pvs := newPVs()
+			for _, dev := range tc.Zeroed {
+				for i := range pvs[dev] {
+					pvs[dev][i] = 0
+				}
+			}
+			for _, dev := range tc.Missing {
+				pvs[dev] = nil
+			}
+			lv := newLV(t, pvs)
+
+			if len(tc.Zeroed) == 0 {
+				act := make([]byte, chunkSize)
+				n, err := lv.ReadAt(act, laddrBeg)
+				require.NoError(t, err)
+				assert.Equal(t, chunkSize, n)
+				assert.Equal(t, exp, act)
+			}
+
+			for piece := 0; piece < chunkSize/stripeLen; piece++ {
+				want := exp[piece*stripeLen:][:stripeLen]
+				act := make([]byte, stripeLen)
+				n, err := lv.ReadAtVerified(ctx, act, laddrBeg+btrfsvol.LogicalAddr(piece*stripeLen), verifyPiece(want))
+				require.NoError(t, err, "piece %v", piece)
+				assert.Equal(t, stripeLen, n)
+				assert.Equal(t, want, act, "piece %v", piece)
+			}
+		})
+	}
+
+	t.Run("missing-3", func(t *testing.T) {
+		t.Parallel()
+		pvs := newPVs()
+		pvs[0], pvs[1], pvs[2] = nil, nil, nil
+		lv := newLV(t, pvs)
+		act := make([]byte, chunkSize)
+		_, err := lv.ReadAt(act, laddrBeg)
+		assert.ErrorContains(t, err, "too many bad stripes")
+	})
+}
+
+func TestAddMappingUnstripedOverStriped(t *testing.T) {
+	t.Parallel()
+	const (
+		chunkSize = 0x4000
+		laddrBeg  = 0x100000
+		paddrBeg  = 0x2000
+	)
+	striping := btrfsvol.Striping{
+		StripeLen:  0x1000,
+		NumStripes: 2,
+		SubStripes: 1,
+	}
+	stripe := func(i int) btrfsvol.Mapping {
+		return btrfsvol.Mapping{
+			LAddr:       laddrBeg,
+			PAddr:       btrfsvol.QualifiedPhysicalAddr{Dev: btrfsvol.DeviceID(i + 1), Addr: paddrBeg},
+			Size:        chunkSize,
+			SizeLocked:  true,
+			Flags:       containers.OptionalValue(btrfsvol.BLOCK_GROUP_DATA | btrfsvol.BLOCK_GROUP_RAID0),
+			Striping:    containers.OptionalValue(striping),
+			StripeIndex: uint16(i),
+		}
+	}
+	// What a DEV_EXTENT for stripe 0 looks like if the striping
+	// of its chunk isn't known.
+	devext := btrfsvol.Mapping{
+		LAddr:      laddrBeg,
+		PAddr:      btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: paddrBeg},
+		Size:       striping.PhysicalSize(chunkSize),
+		SizeLocked: true,
+	}
+	newLV := func(t *testing.T) *btrfsvol.LogicalVolume[memPV] {
+		t.Helper()
+		lv := new(btrfsvol.LogicalVolume[memPV])
+		require.NoError(t, lv.AddPhysicalVolume(1, make(memPV, 0x10000)))
+		require.NoError(t, lv.AddPhysicalVolume(2, make(memPV, 0x10000)))
+		return lv
+	}
+
+	t.Run("striped-first", func(t *testing.T) {
+		t.Parallel()
+		lv := newLV(t)
+		require.NoError(t, lv.AddMapping(stripe(0)))
+		require.NoError(t, lv.AddMapping(stripe(1)))
+		assert.False(t, lv.CouldAddMapping(devext))
+		assert.ErrorContains(t, lv.AddMapping(devext), "overlaps unstriped mapping")
+		assert.Len(t, lv.Mappings(), 2)
+	})
+	t.Run("unstriped-first", func(t *testing.T) {
+		t.Parallel()
+		lv := newLV(t)
+		require.NoError(t, lv.AddMapping(devext))
+		assert.False(t, lv.CouldAddMapping(stripe(1)))
+		assert.ErrorContains(t, lv.AddMapping(stripe(1)), "overlaps unstriped mapping")
+		assert.Equal(t, []btrfsvol.Mapping{{
+			LAddr: devext.LAddr,
+			PAddr: devext.PAddr,
+			Size:  devext.Size,
+		}}, lv.Mappings())
+	})
+}
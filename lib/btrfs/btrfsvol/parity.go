@@ -0,0 +1,124 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsvol
+
+import (
+	"fmt"
+
+	"github.com/datawire/dlib/derror"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
+)
+
+// parityChunk returns the chunk containing `laddr` if that chunk has
+// parity stripes (RAID5 or RAID6).
+func (lv *LogicalVolume[PhysicalVolume]) parityChunk(laddr LogicalAddr) (chunkMapping, bool) {
+	node := lv.logical2physical.Search(func(chunk chunkMapping) int {
+		return chunkMapping{LAddr: laddr, Size: 1}.compareRange(chunk)
+	})
+	if node == nil || !node.Value.Striping.OK || node.Value.Striping.Val.Parity == 0 {
+		return chunkMapping{}, false
+	}
+	return node.Value, true
+}
+
+// reconstructAt is like maybeShortReadAt, but rather than reading the
+// stripe that holds `laddr`, it rebuilds the data from the other
+// stripes of that row.  This is for when the stripe holding `laddr`
+// is on a missing device, can't be read, or is corrupt.
+//
+// Other stripes of the row that can't be read are also treated as
+// bad, as long as there is enough parity to cover them.  If `verify`
+// is non-nil and there is parity to spare, then another stripe that
+// reads fine but is corrupt can also be found, by trying each in turn
+// as the additional bad stripe until the result verifies.
+func (lv *LogicalVolume[PhysicalVolume]) reconstructAt(dat []byte, laddr LogicalAddr, verify func([]byte) error) (int, error) {
+	chunk, ok := lv.parityChunk(laddr)
+	if !ok {
+		return 0, fmt.Errorf("reconstruct: laddr=%v is not in a chunk with parity", laddr)
+	}
+	striping := chunk.Striping.Val
+	offsetWithinChunk := laddr.Sub(chunk.LAddr)
+	targetIdx, offsetWithinStripe, maxlen := striping.resolve(offsetWithinChunk)
+	if rest := chunk.Size - offsetWithinChunk; maxlen > rest {
+		maxlen = rest
+	}
+	if AddrDelta(len(dat)) > maxlen {
+		dat = dat[:maxlen]
+	}
+	row := offsetWithinStripe / striping.StripeLen
+	target := striping.column(row, targetIdx)
+	numData := int(striping.numDataStripes())
+
+	paddrs := make(map[uint16]QualifiedPhysicalAddr, len(chunk.PAddrs))
+	for i, paddr := range chunk.PAddrs {
+		paddrs[chunk.StripeIdxs[i]] = paddr
+	}
+
+	cols := make([][]byte, striping.NumStripes)
+	bad := []int{target}
+	var errs derror.MultiError
+	for col := range cols {
+		if col == target {
+			continue
+		}
+		cols[col] = make([]byte, len(dat))
+		idx := striping.rotate(row, AddrDelta(col))
+		if err := lv.readStripe(cols[col], paddrs, idx, offsetWithinStripe); err != nil {
+			bad = append(bad, col)
+			errs = append(errs, err)
+		}
+	}
+	if len(bad) > int(striping.Parity) {
+		return 0, fmt.Errorf("reconstruct: laddr=%v: too many bad stripes: %w", laddr, errs)
+	}
+
+	candidates := [][]int{bad}
+	if verify != nil && len(bad) < int(striping.Parity) {
+		for col := range cols {
+			if col != target && !slices.Contains(col, bad) {
+				candidates = append(candidates, append(append([]int(nil), bad...), col))
+			}
+		}
+	}
+	var verifyErr error
+	for _, candidate := range candidates {
+		work := append([][]byte(nil), cols...)
+		for _, col := range candidate {
+			work[col] = make([]byte, len(dat))
+		}
+		if err := recoverColumns(work, numData, candidate); err != nil {
+			return 0, fmt.Errorf("reconstruct: laddr=%v: %w", laddr, err)
+		}
+		if verify != nil {
+			if err := verify(work[target]); err != nil {
+				if verifyErr == nil {
+					verifyErr = err
+				}
+				continue
+			}
+		}
+		return copy(dat, work[target]), nil
+	}
+	return 0, fmt.Errorf("reconstruct: laddr=%v: reconstructed data: %w", laddr, verifyErr)
+}
+
+// readStripe reads stripe number `idx` of a chunk (whose stripes are
+// `paddrs`) at `offsetWithinStripe`.
+func (lv *LogicalVolume[PhysicalVolume]) readStripe(dat []byte, paddrs map[uint16]QualifiedPhysicalAddr, idx uint16, offsetWithinStripe AddrDelta) error {
+	paddr, ok := paddrs[idx]
+	if !ok {
+		return fmt.Errorf("stripe %v is not mapped", idx)
+	}
+	dev, ok := lv.id2pv[paddr.Dev]
+	if !ok {
+		return fmt.Errorf("device=%v does not exist", paddr.Dev)
+	}
+	paddr = paddr.Add(offsetWithinStripe)
+	if _, err := dev.ReadAt(dat, paddr.Addr); err != nil {
+		return fmt.Errorf("read device=%v paddr=%v: %w", paddr.Dev, paddr.Addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsvol
+
+import (
+	"fmt"
+)
+
+// RAID6's second parity stripe, Q, is a Reed-Solomon syndrome over
+// GF(2^8) (with the polynomial x^8+x^4+x^3+x^2+1, and the generator
+// g=2):
+//
+//	P = D_0 ⊕ D_1 ⊕ ... ⊕ D_{n-1}
+//	Q = g^0·D_0 ⊕ g^1·D_1 ⊕ ... ⊕ g^{n-1}·D_{n-1}
+//
+// See H. Peter Anvin's "The mathematics of RAID-6".
+
+// gfExp[i] is g^i; it is 2×255 long so that gfMul doesn't need to
+// reduce the sum of two logarithms.  gfLog is the inverse of gfExp
+// (gfLog[0] is meaningless).
+var gfExp, gfLog = func() (exp [2 * 255]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		exp[i+255] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfPow returns g^n.
+func gfPow(n int) byte {
+	return gfExp[n%255]
+}
+
+// recoverColumns fills in the columns listed in `bad` of a row of a
+// chunk with parity, from the other columns.  `cols` has the
+// numData data columns first, then P, then (for RAID6) Q; a bad
+// column's contents on entry are ignored.
+func recoverColumns(cols [][]byte, numData int, bad []int) error {
+	var badData []int
+	pOK, qOK := true, len(cols) > numData+1
+	for _, col := range bad {
+		switch {
+		case col < numData:
+			badData = append(badData, col)
+		case col == numData:
+			pOK = false
+		default:
+			qOK = false
+		}
+	}
+
+	switch {
+	case len(badData) == 0:
+		// Only parity is bad; nothing to recover.
+	case len(badData) == 1 && pOK:
+		x := badData[0]
+		out := cols[x]
+		copy(out, cols[numData])
+		for i := 0; i < numData; i++ {
+			if i != x {
+				xorInto(out, cols[i])
+			}
+		}
+	case len(badData) == 1 && qOK:
+		// D_x = (Q ⊕ Σ_{i≠x} g^i·D_i) / g^x
+		x := badData[0]
+		out := cols[x]
+		copy(out, cols[numData+1])
+		for i := 0; i < numData; i++ {
+			if i != x {
+				mulXORInto(out, gfPow(i), cols[i])
+			}
+		}
+		for j := range out {
+			out[j] = gfDiv(out[j], gfPow(x))
+		}
+	case len(badData) == 2 && pOK && qOK:
+		// With P' = P ⊕ Σ_{i≠x,y} D_i = D_x ⊕ D_y
+		// and  Q' = Q ⊕ Σ_{i≠x,y} g^i·D_i = g^x·D_x ⊕ g^y·D_y,
+		//
+		//	D_x = (g^(y-x)·P' ⊕ g^(-x)·Q') / (g^(y-x) ⊕ 1)
+		//	D_y = P' ⊕ D_x
+		x, y := badData[0], badData[1]
+		if x > y {
+			x, y = y, x
+		}
+		pxy, qxy := cols[x], cols[y]
+		copy(pxy, cols[numData])
+		copy(qxy, cols[numData+1])
+		for i := 0; i < numData; i++ {
+			if i != x && i != y {
+				xorInto(pxy, cols[i])
+				mulXORInto(qxy, gfPow(i), cols[i])
+			}
+		}
+		gyx := gfPow(y - x)
+		denom := gyx ^ 1
+		a := gfDiv(gyx, denom)
+		b := gfDiv(gfDiv(1, gfPow(x)), denom)
+		for j := range pxy {
+			dx := gfMul(a, pxy[j]) ^ gfMul(b, qxy[j])
+			pxy[j], qxy[j] = dx, pxy[j]^dx
+		}
+	default:
+		return fmt.Errorf("too many bad stripes to recover: %v", bad)
+	}
+	return nil
+}
+
+func xorInto(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func mulXORInto(dst []byte, coef byte, src []byte) {
+	for i := range dst {
+		dst[i] ^= gfMul(coef, src[i])
+	}
+}
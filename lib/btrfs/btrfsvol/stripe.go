@@ -0,0 +1,138 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsvol
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A Stripe is a contiguous run of physical addresses on a single
+// device.
+type Stripe struct {
+	Addr PhysicalAddr
+	Size AddrDelta
+}
+
+// CoalesceStripes takes a list of the physical addresses of nodes
+// that were found on a device, and merges them in to the minimal list
+// of contiguous stripes that cover all of them.  Each node is assumed
+// to be nodeSize bytes long.  Nodes that are adjacent or overlapping
+// are merged; a gap of any size starts a new stripe.
+//
+// The input may be in any order and may contain duplicates; it is
+// not modified.  The returned stripes are sorted by address.
+func CoalesceStripes(paddrs []PhysicalAddr, nodeSize AddrDelta) []Stripe {
+	if len(paddrs) == 0 {
+		return nil
+	}
+	sorted := make([]PhysicalAddr, len(paddrs))
+	copy(sorted, paddrs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	ret := []Stripe{{Addr: sorted[0], Size: nodeSize}}
+	for _, paddr := range sorted[1:] {
+		last := &ret[len(ret)-1]
+		lastEnd := last.Addr.Add(last.Size)
+		switch {
+		case paddr > lastEnd:
+			ret = append(ret, Stripe{Addr: paddr, Size: nodeSize})
+		case paddr.Add(nodeSize) > lastEnd:
+			last.Size = paddr.Add(nodeSize).Sub(last.Addr)
+		}
+	}
+	return ret
+}
+
+// Striping describes how a RAID0, RAID10, RAID5, or RAID6 chunk
+// splits its logical range across its stripes, rather than each
+// stripe holding a full copy of it.  The logical range is cut in to
+// StripeLen-sized pieces, which are dealt out round-robin to the
+// "data stripes".
+//
+// For RAID0 and RAID10, there are NumStripes/SubStripes data stripes,
+// and each data stripe is mirrored on SubStripes consecutive stripes
+// (1 for RAID0, 2 for RAID10).
+//
+// For RAID5 and RAID6, SubStripes is 1, and the last Parity (1 or 2)
+// pieces of each row of NumStripes pieces are parity rather than data
+// (P, the XOR of the data pieces, and for RAID6 also Q; see
+// recoverColumns); the rows are rotated by one stripe each, so that
+// the parity is spread across all of the stripes.
+type Striping struct {
+	StripeLen  AddrDelta
+	NumStripes uint16
+	SubStripes uint16
+	Parity     uint16 `json:",omitempty"`
+}
+
+func (s Striping) validate() error {
+	if s.StripeLen <= 0 || s.SubStripes == 0 || s.NumStripes < s.SubStripes || s.NumStripes%s.SubStripes != 0 ||
+		(s.Parity > 0 && (s.Parity > 2 || s.SubStripes != 1 || s.NumStripes <= s.Parity)) {
+		return fmt.Errorf("invalid striping: %+v", s)
+	}
+	return nil
+}
+
+func (s Striping) numDataStripes() AddrDelta {
+	return AddrDelta(s.NumStripes/s.SubStripes - s.Parity)
+}
+
+// PhysicalSize returns how much of each stripe a chunk with the
+// logical size `size` occupies.
+func (s Striping) PhysicalSize(size AddrDelta) AddrDelta {
+	return size / s.numDataStripes()
+}
+
+// LogicalSize is the inverse of PhysicalSize: it returns the logical
+// size of a chunk that occupies `physSize` of each stripe.
+func (s Striping) LogicalSize(physSize AddrDelta) AddrDelta {
+	return physSize * s.numDataStripes()
+}
+
+// resolve maps an offset within the chunk's logical range to the
+// first of the (.SubStripes-many) stripe indexes that hold it, the
+// offset within those stripes, and how many bytes are contiguous
+// from there.
+func (s Striping) resolve(off AddrDelta) (firstIdx uint16, physOff, maxlen AddrDelta) {
+	stripeNr := off / s.StripeLen
+	offWithinStripe := off % s.StripeLen
+	dataStripe := stripeNr % s.numDataStripes()
+	row := stripeNr / s.numDataStripes()
+	physOff = row*s.StripeLen + offWithinStripe
+	maxlen = s.StripeLen - offWithinStripe
+	if s.Parity > 0 {
+		return s.rotate(row, dataStripe), physOff, maxlen
+	}
+	return uint16(dataStripe) * s.SubStripes, physOff, maxlen
+}
+
+// rotate returns which stripe holds column `col` of row `row` of a
+// chunk with parity; columns [0, numDataStripes) are data, and the
+// rest are parity.
+func (s Striping) rotate(row, col AddrDelta) uint16 {
+	return uint16((row + col) % AddrDelta(s.NumStripes))
+}
+
+// column is the inverse of rotate.
+func (s Striping) column(row AddrDelta, idx uint16) int {
+	numStripes := AddrDelta(s.NumStripes)
+	return int(((AddrDelta(idx)-row)%numStripes + numStripes) % numStripes)
+}
+
+// unresolve is the inverse of resolve: it maps an offset within
+// stripe number `idx` to an offset within the chunk's logical range.
+// It returns false if that part of the stripe is parity.
+func (s Striping) unresolve(idx uint16, physOff AddrDelta) (AddrDelta, bool) {
+	row := physOff / s.StripeLen
+	dataStripe := AddrDelta(idx / s.SubStripes)
+	if s.Parity > 0 {
+		dataStripe = AddrDelta(s.column(row, idx))
+		if dataStripe >= s.numDataStripes() {
+			return 0, false
+		}
+	}
+	return (row*s.numDataStripes()+dataStripe)*s.StripeLen + physOff%s.StripeLen, true
+}
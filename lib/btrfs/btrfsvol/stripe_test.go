@@ -0,0 +1,73 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsvol_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestCoalesceStripes(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		InputAddrs    []btrfsvol.PhysicalAddr
+		InputNodeSize btrfsvol.AddrDelta
+		Output        []btrfsvol.Stripe
+	}
+	testcases := map[string]TestCase{
+		"empty": {
+			InputNodeSize: 0x4000,
+			Output:        nil,
+		},
+		"single": {
+			InputAddrs:    []btrfsvol.PhysicalAddr{0x10000},
+			InputNodeSize: 0x4000,
+			Output:        []btrfsvol.Stripe{{Addr: 0x10000, Size: 0x4000}},
+		},
+		"contiguous": {
+			InputAddrs:    []btrfsvol.PhysicalAddr{0x10000, 0x14000, 0x18000},
+			InputNodeSize: 0x4000,
+			Output:        []btrfsvol.Stripe{{Addr: 0x10000, Size: 0xc000}},
+		},
+		"gaps": {
+			InputAddrs:    []btrfsvol.PhysicalAddr{0x10000, 0x14000, 0x20000, 0x28000, 0x2c000},
+			InputNodeSize: 0x4000,
+			Output: []btrfsvol.Stripe{
+				{Addr: 0x10000, Size: 0x8000},
+				{Addr: 0x20000, Size: 0x4000},
+				{Addr: 0x28000, Size: 0x8000},
+			},
+		},
+		"unsorted-dups": {
+			InputAddrs:    []btrfsvol.PhysicalAddr{0x18000, 0x10000, 0x14000, 0x10000},
+			InputNodeSize: 0x4000,
+			Output:        []btrfsvol.Stripe{{Addr: 0x10000, Size: 0xc000}},
+		},
+		"overlapping": {
+			InputAddrs:    []btrfsvol.PhysicalAddr{0x10000, 0x12000},
+			InputNodeSize: 0x4000,
+			Output:        []btrfsvol.Stripe{{Addr: 0x10000, Size: 0x6000}},
+		},
+		"large-nodes": {
+			InputAddrs:    []btrfsvol.PhysicalAddr{0x100000, 0x110000, 0x130000},
+			InputNodeSize: 0x10000,
+			Output: []btrfsvol.Stripe{
+				{Addr: 0x100000, Size: 0x20000},
+				{Addr: 0x130000, Size: 0x10000},
+			},
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			actual := btrfsvol.CoalesceStripes(tc.InputAddrs, tc.InputNodeSize)
+			assert.Equal(t, tc.Output, actual)
+		})
+	}
+}
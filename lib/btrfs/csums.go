@@ -8,6 +8,8 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/datawire/dlib/derror"
+
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
@@ -69,3 +71,35 @@ func LookupCSum(ctx context.Context, fs btrfstree.Forrest, alg btrfssum.CSumType
 		panic(fmt.Errorf("should not happen: EXTENT_CSUM has unexpected item type: %T", body))
 	}
 }
+
+// LookupCSums returns the csum-runs that contain any of the csums for
+// the logical addresses in the half-open range [beg, end), in
+// ascending order.  The runs are not trimmed to the range.
+//
+// Runs that are read successfully are returned even if an error is
+// also returned.
+func LookupCSums(ctx context.Context, fs btrfstree.Forrest, alg btrfssum.CSumType, beg, end btrfsvol.LogicalAddr) ([]btrfssum.SumRun[btrfsvol.LogicalAddr], error) {
+	csumTree, err := fs.ForrestLookup(ctx, btrfsprim.CSUM_TREE_OBJECTID)
+	if err != nil {
+		return nil, err
+	}
+	var ret []btrfssum.SumRun[btrfsvol.LogicalAddr]
+	var errs derror.MultiError
+	if err := csumTree.TreeSubrange(ctx, 0, btrfstree.SearchCSumRange(beg, end, alg.Size()), func(item btrfstree.Item) bool {
+		switch body := item.Body.(type) {
+		case *btrfsitem.ExtentCSum:
+			ret = append(ret, body.SumRun)
+		case *btrfsitem.Error:
+			errs = append(errs, fmt.Errorf("item %v: %w", item.Key, body.Err))
+		default:
+			panic(fmt.Errorf("should not happen: EXTENT_CSUM has unexpected item type: %T", body))
+		}
+		return true
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
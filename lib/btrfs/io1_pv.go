@@ -7,9 +7,15 @@
 package btrfs
 
 import (
+	"context"
 	"fmt"
+	"sync"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
@@ -18,6 +24,7 @@ import (
 type Device struct {
 	diskio.File[btrfsvol.PhysicalAddr]
 
+	cacheMu          sync.Mutex
 	cacheSuperblocks []*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock]
 	cacheSuperblock  *btrfstree.Superblock
 }
@@ -33,6 +40,14 @@ var SuperblockAddrs = []btrfsvol.PhysicalAddr{
 var SuperblockSize = btrfsvol.PhysicalAddr(binstruct.StaticSize(btrfstree.Superblock{}))
 
 func (dev *Device) Superblocks() ([]*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock], error) {
+	dev.cacheMu.Lock()
+	defer dev.cacheMu.Unlock()
+	return dev.superblocks()
+}
+
+// superblocks is the guts of .Superblocks(); you must hold
+// .cacheMu to call it.
+func (dev *Device) superblocks() ([]*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock], error) {
 	if dev.cacheSuperblocks != nil {
 		return dev.cacheSuperblocks, nil
 	}
@@ -59,26 +74,158 @@ func (dev *Device) Superblocks() ([]*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree
 	return ret, nil
 }
 
+// checkSuperblocks returns, for each of the copies of the superblock
+// `sbs` (as returned by .Superblocks()), why that copy can't be
+// trusted, or nil if it can be.  A copy can't be trusted if:
+//
+//   - its checksum is invalid;
+//   - its .Self isn't the address that it was read from (say, it was
+//     copied there along with the rest of an image of a different
+//     device); or
+//   - it is for a different filesystem than the other copies (say, it
+//     is left over from before the device was reformatted).  The
+//     primary copy decides which filesystem that is if it is otherwise
+//     trustworthy; if not, then the FSUUID that the most copies agree
+//     on does (the earliest copy wins a tie).
+func checkSuperblocks(sbs []*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock]) []error {
+	errs := make([]error, len(sbs))
+	for i, sb := range sbs {
+		if err := sb.Data.ValidateChecksum(); err != nil {
+			errs[i] = err
+			continue
+		}
+		if sb.Data.Self != sb.Addr {
+			errs[i] = fmt.Errorf("superblock claims to be at %v, but is at %v", sb.Data.Self, sb.Addr)
+		}
+	}
+
+	var fsUUID btrfsprim.UUID
+	if len(sbs) > 0 && errs[0] == nil {
+		fsUUID = sbs[0].Data.FSUUID
+	} else {
+		cnt := make(map[btrfsprim.UUID]int)
+		bestCnt := 0
+		for i, sb := range sbs {
+			if errs[i] != nil {
+				continue
+			}
+			cnt[sb.Data.FSUUID]++
+			if cnt[sb.Data.FSUUID] > bestCnt {
+				fsUUID = sb.Data.FSUUID
+				bestCnt = cnt[sb.Data.FSUUID]
+			}
+		}
+	}
+	for i, sb := range sbs {
+		if errs[i] == nil && sb.Data.FSUUID != fsUUID {
+			errs[i] = fmt.Errorf("superblock is for filesystem %v, but the device is part of filesystem %v",
+				sb.Data.FSUUID, fsUUID)
+		}
+	}
+	return errs
+}
+
+// ReadSuperblocks returns the copies of the superblock on the device
+// (the primary and each mirror that fits on the device) that can be
+// trusted (see checkSuperblocks), in on-disk order.  Copies that can't
+// be trusted are left out and described by the returned error; so it
+// is possible for both copies and an error to be returned.
+func (dev *Device) ReadSuperblocks() ([]btrfstree.Superblock, error) {
+	sbs, err := dev.Superblocks()
+	if err != nil {
+		return nil, err
+	}
+	var ret []btrfstree.Superblock
+	var errs derror.MultiError
+	for i, err := range checkSuperblocks(sbs) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("superblock %v: %w", i, err))
+			continue
+		}
+		ret = append(ret, sbs[i].Data)
+	}
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
+
+// Superblock returns the copy of the superblock to trust: of the
+// copies that can be trusted at all (see checkSuperblocks), the one
+// with the highest generation (the first one, if several tie).  So a
+// device whose primary superblock is damaged can still be read using
+// a mirror.  Use .LogSuperblockMirrors to report copies that are not
+// being used.
 func (dev *Device) Superblock() (*btrfstree.Superblock, error) {
+	dev.cacheMu.Lock()
+	defer dev.cacheMu.Unlock()
 	if dev.cacheSuperblock != nil {
 		return dev.cacheSuperblock, nil
 	}
-	sbs, err := dev.Superblocks()
+	sbs, err := dev.superblocks()
 	if err != nil {
 		return nil, err
 	}
 
-	for i, sb := range sbs {
-		if err := sb.Data.ValidateChecksum(); err != nil {
-			return nil, fmt.Errorf("superblock %v: %w", i, err)
+	var best *btrfstree.Superblock
+	var errs derror.MultiError
+	for i, err := range checkSuperblocks(sbs) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("superblock %v: %w", i, err))
+			continue
 		}
-		if i > 0 {
-			if !sb.Data.Equal(sbs[0].Data) {
-				return nil, fmt.Errorf("superblock %v and superblock %v disagree", 0, i)
-			}
+		if best == nil || sbs[i].Data.Generation > best.Generation {
+			best = &sbs[i].Data
+		}
+	}
+	if best == nil {
+		return nil, errs
+	}
+
+	dev.cacheSuperblock = best
+	return best, nil
+}
+
+// LogSuperblockMirrors logs each copy of the superblock on the device
+// that .Superblock() is not using because it can't be trusted, or
+// that disagrees with the copy that is being used.
+func (dev *Device) LogSuperblockMirrors(ctx context.Context) {
+	best, err := dev.Superblock()
+	if err != nil {
+		return
+	}
+	sbs, err := dev.Superblocks()
+	if err != nil {
+		return
+	}
+	for i, err := range checkSuperblocks(sbs) {
+		sb := sbs[i]
+		if err != nil {
+			dlog.Errorf(ctx, "device file %q: superblock %v at %v: ignoring: %v",
+				dev.Name(), i, sb.Addr, err)
+			continue
+		}
+		if !sb.Data.Equal(*best) {
+			dlog.Errorf(ctx, "device file %q: superblock %v at %v (generation=%v) disagrees with the superblock in use (generation=%v)",
+				dev.Name(), i, sb.Addr, sb.Data.Generation, best.Generation)
 		}
 	}
+}
 
-	dev.cacheSuperblock = &sbs[0].Data
-	return &sbs[0].Data, nil
+// ReadNode reads the node at physical address `paddr` on this
+// device, without going through the logical-to-physical mapping;
+// this makes it usable before the chunk tree has been read (for
+// instance, during chunk-tree recovery).  The node checksum is still
+// validated, using the checksum type and node size from the device's
+// superblock.
+//
+// As with btrfstree.ReadNode, it is possible that both a non-nil
+// node and an error are returned; the caller is responsible for
+// calling .RawFree() on the node.
+func (dev *Device) ReadNode(paddr btrfsvol.PhysicalAddr) (*btrfstree.Node, error) {
+	sb, err := dev.Superblock()
+	if err != nil {
+		return nil, fmt.Errorf("device %q: %w", dev.Name(), err)
+	}
+	return btrfstree.ReadNode[btrfsvol.PhysicalAddr](dev, *sb, paddr)
 }
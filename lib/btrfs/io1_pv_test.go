@@ -0,0 +1,160 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfs_test
+
+import (
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestDeviceSuperblockMirrors(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		BadPrimary, BadMirror bool
+		MirrorGeneration      btrfsprim.Generation
+		MirrorWrongSelf       bool // the mirror claims to be the primary
+		MirrorOtherFS         bool // the mirror is for a different filesystem
+
+		ExpSelf    int // index in to SuperblockAddrs; -1 for an error
+		ExpNumRead int
+		ExpErr     string
+	}
+	testcases := map[string]TestCase{
+		"good": {
+			MirrorGeneration: 5,
+			ExpSelf:          0, ExpNumRead: 2,
+		},
+		"bad-primary": {
+			BadPrimary: true, MirrorGeneration: 5,
+			ExpSelf: 1, ExpNumRead: 1,
+			ExpErr: "superblock 0: superblock checksum mismatch: stored=00000000 calculated=",
+		},
+		"bad-mirror": {
+			BadMirror: true, MirrorGeneration: 5,
+			ExpSelf: 0, ExpNumRead: 1,
+			ExpErr: "superblock 1: superblock checksum mismatch: stored=00000000 calculated=",
+		},
+		"newer-mirror": {
+			MirrorGeneration: 6,
+			ExpSelf:          1, ExpNumRead: 2,
+		},
+		"older-mirror": {
+			MirrorGeneration: 4,
+			ExpSelf:          0, ExpNumRead: 2,
+		},
+		"wrong-self-mirror": {
+			MirrorGeneration: 6, MirrorWrongSelf: true,
+			ExpSelf: 0, ExpNumRead: 1,
+			ExpErr: "superblock 1: superblock claims to be at 0x0000000000010000, but is at 0x0000000004000000",
+		},
+		"other-fs-mirror": {
+			MirrorGeneration: 6, MirrorOtherFS: true,
+			ExpSelf: 0, ExpNumRead: 1,
+			ExpErr: "superblock 1: superblock is for filesystem 0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0, but the device is part of filesystem a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90",
+		},
+		"other-fs-mirror-bad-primary": {
+			BadPrimary: true, MirrorGeneration: 6, MirrorOtherFS: true,
+			ExpSelf: 1, ExpNumRead: 1,
+			ExpErr: "superblock 0: superblock checksum mismatch: stored=00000000 calculated=",
+		},
+		"all-bad": {
+			BadPrimary: true, BadMirror: true, MirrorGeneration: 5,
+			ExpSelf: -1, ExpNumRead: 0,
+			ExpErr: "superblock 0: superblock checksum mismatch: stored=00000000 calculated=",
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			ctx := dlog.NewTestContext(t, false)
+
+			// Big enough for the primary superblock and
+			// the first mirror.
+			dev := makeTestDevice(t, btrfs.SuperblockAddrs[1]+btrfs.SuperblockSize)
+			sbs, err := dev.Superblocks()
+			require.NoError(t, err)
+			require.Len(t, sbs, 2)
+			sbs[0].Data.Generation = 5
+			sbs[1].Data = sbs[0].Data
+			sbs[1].Data.Self = btrfs.SuperblockAddrs[1]
+			sbs[1].Data.Generation = tc.MirrorGeneration
+			if tc.MirrorWrongSelf {
+				sbs[1].Data.Self = btrfs.SuperblockAddrs[0]
+			}
+			if tc.MirrorOtherFS {
+				sbs[1].Data.FSUUID = btrfsprim.MustParseUUID("0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+			}
+			for i, bad := range []bool{tc.BadPrimary, tc.BadMirror} {
+				sbs[i].Data.Checksum = btrfssum.CSum{}
+				if !bad {
+					sbs[i].Data.Checksum, err = sbs[i].Data.CalculateChecksum()
+					require.NoError(t, err)
+				}
+				require.NoError(t, sbs[i].Write())
+			}
+
+			read, err := dev.ReadSuperblocks()
+			assert.Len(t, read, tc.ExpNumRead)
+			if tc.ExpErr != "" {
+				assert.ErrorContains(t, err, tc.ExpErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			sb, err := dev.Superblock()
+			if tc.ExpSelf < 0 {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, btrfs.SuperblockAddrs[tc.ExpSelf], sb.Self)
+			assert.Equal(t, &sbs[tc.ExpSelf].Data, sb)
+
+			var fs btrfs.FS
+			require.NoError(t, fs.AddDevice(ctx, dev))
+			fsSB, err := fs.Superblock()
+			require.NoError(t, err)
+			assert.Equal(t, sb, fsSB)
+		})
+	}
+}
+
+func TestFSSuperblockOtherFS(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	// Devices 1 and 2 are the filesystem; device 3 is from some
+	// other filesystem, and has a newer superblock.
+	var fs btrfs.FS
+	for _, devID := range []btrfsvol.DeviceID{1, 2, 3} {
+		dev := makeTestDevice(t, 0)
+		sbs, err := dev.Superblocks()
+		require.NoError(t, err)
+		sbs[0].Data.DevItem.DevID = devID
+		sbs[0].Data.Generation = 5
+		if devID == 3 {
+			sbs[0].Data.FSUUID = btrfsprim.MustParseUUID("0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+			sbs[0].Data.Generation = 9
+		}
+		sbs[0].Data.Checksum, err = sbs[0].Data.CalculateChecksum()
+		require.NoError(t, err)
+		require.NoError(t, sbs[0].Write())
+		require.NoError(t, fs.AddDevice(ctx, dev))
+	}
+
+	sb, err := fs.Superblock()
+	require.NoError(t, err)
+	assert.Equal(t, btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"), sb.FSUUID)
+	assert.Equal(t, btrfsprim.Generation(5), sb.Generation)
+}
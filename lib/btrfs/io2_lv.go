@@ -8,16 +8,19 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/datawire/dlib/derror"
 	"github.com/datawire/dlib/dlog"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
 )
 
 type FS struct {
@@ -25,10 +28,30 @@ type FS struct {
 	// implementing special things like fsck.
 	LV btrfsvol.LogicalVolume[*Device]
 
+	// NodeDecodeCache, if non-nil, is consulted when reading a
+	// node that is not in the (address-keyed) node cache, so that
+	// node contents that have already been parsed once don't need
+	// to be parsed again.  It must not be changed once reading has
+	// begun.
+	NodeDecodeCache *btrfstree.NodeDecodeCache
+
+	// NodeCacheSize is how many nodes the (address-keyed) node
+	// cache holds; if zero, DefaultNodeCacheSize is used.  Each
+	// cached node costs at least the filesystem's node size
+	// (typically 16KiB) of memory.  It must not be changed once
+	// reading has begun.
+	NodeCacheSize int
+
+	// cacheMu protects the lazily-initialized members below, so
+	// that an FS may be read from concurrently once it has been
+	// set up.  It does not protect .LV (other than .LV's name,
+	// which .Name() lazily sets); the volume must not be mutated
+	// (.AddDevice, .ReInit, .InitChunks...) concurrently with
+	// other operations.
+	cacheMu          sync.Mutex
 	cacheSuperblocks []*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock]
 	cacheSuperblock  *btrfstree.Superblock
-
-	cacheNodes containers.Cache[btrfsvol.LogicalAddr, nodeCacheEntry]
+	cacheNodes       containers.Cache[btrfsvol.LogicalAddr, nodeCacheEntry]
 }
 
 var _ diskio.File[btrfsvol.LogicalAddr] = (*FS)(nil)
@@ -38,11 +61,14 @@ func (fs *FS) AddDevice(ctx context.Context, dev *Device) error {
 	if err != nil {
 		return err
 	}
+	dev.LogSuperblockMirrors(ctx)
 	if err := fs.LV.AddPhysicalVolume(sb.DevItem.DevID, dev); err != nil {
 		return err
 	}
+	fs.cacheMu.Lock()
 	fs.cacheSuperblocks = nil
 	fs.cacheSuperblock = nil
+	fs.cacheMu.Unlock()
 	if err := fs.initDev(*sb); err != nil {
 		dlog.Errorf(ctx, "error: AddDevice: %q: %v", dev.Name(), err)
 	}
@@ -50,10 +76,12 @@ func (fs *FS) AddDevice(ctx context.Context, dev *Device) error {
 }
 
 func (fs *FS) Name() string {
+	fs.cacheMu.Lock()
+	defer fs.cacheMu.Unlock()
 	if name := fs.LV.Name(); name != "" {
 		return name
 	}
-	sb, err := fs.Superblock()
+	sb, err := fs.superblock()
 	if err != nil {
 		return fmt.Sprintf("fs_uuid=%v", "(unreadable)")
 	}
@@ -70,11 +98,34 @@ func (fs *FS) ReadAt(p []byte, off btrfsvol.LogicalAddr) (int, error) {
 	return fs.LV.ReadAt(p, off)
 }
 
+// ReadAtVerified reads `p` from `off`, using the first mirror whose
+// `alg` checksum is `expSum`; see LogicalVolume.ReadAtVerified.
+func (fs *FS) ReadAtVerified(ctx context.Context, p []byte, off btrfsvol.LogicalAddr, alg btrfssum.CSumType, expSum btrfssum.CSum) (int, error) {
+	return fs.LV.ReadAtVerified(ctx, p, off, func(dat []byte) error {
+		actSum, err := alg.Sum(dat)
+		if err != nil {
+			return err
+		}
+		if actSum != expSum {
+			return fmt.Errorf("actual sum %v != expected sum %v", actSum, expSum)
+		}
+		return nil
+	})
+}
+
 func (fs *FS) WriteAt(p []byte, off btrfsvol.LogicalAddr) (int, error) {
 	return fs.LV.WriteAt(p, off)
 }
 
 func (fs *FS) Superblocks() ([]*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock], error) {
+	fs.cacheMu.Lock()
+	defer fs.cacheMu.Unlock()
+	return fs.superblocks()
+}
+
+// superblocks is the guts of .Superblocks(); you must hold .cacheMu
+// to call it.
+func (fs *FS) superblocks() ([]*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Superblock], error) {
 	if fs.cacheSuperblocks != nil {
 		return fs.cacheSuperblocks, nil
 	}
@@ -95,44 +146,56 @@ func (fs *FS) Superblocks() ([]*diskio.Ref[btrfsvol.PhysicalAddr, btrfstree.Supe
 }
 
 func (fs *FS) Superblock() (*btrfstree.Superblock, error) {
+	fs.cacheMu.Lock()
+	defer fs.cacheMu.Unlock()
+	return fs.superblock()
+}
+
+// superblock is the guts of .Superblock(); you must hold .cacheMu to
+// call it.
+//
+// Each device's superblock is chosen by Device.Superblock (which
+// prefers the trustworthy mirror with the highest generation), and
+// then of those, the one with the highest generation is used.
+// Devices whose superblock is for a different filesystem than most of
+// the devices (the lowest device ID wins a tie) are not considered.
+func (fs *FS) superblock() (*btrfstree.Superblock, error) {
 	if fs.cacheSuperblock != nil {
 		return fs.cacheSuperblock, nil
 	}
-	sbs, err := fs.Superblocks()
-	if err != nil {
-		return nil, err
-	}
-	if len(sbs) == 0 {
-		return nil, fmt.Errorf("no superblocks")
+	devs := fs.LV.PhysicalVolumes()
+	if len(devs) == 0 {
+		return nil, fmt.Errorf("no devices")
 	}
 
-	fname := ""
-	sbi := 0
-	for i, sb := range sbs {
-		if sb.File.Name() != fname {
-			fname = sb.File.Name()
-			sbi = 0
-		} else {
-			sbi++
+	devIDs := maps.SortedKeys(devs)
+	sbs := make([]*btrfstree.Superblock, len(devIDs))
+	cnt := make(map[btrfsprim.UUID]int)
+	var fsUUID btrfsprim.UUID
+	for i, devID := range devIDs {
+		sb, err := devs[devID].Superblock()
+		if err != nil {
+			return nil, fmt.Errorf("file %q: %w", devs[devID].Name(), err)
+		}
+		sbs[i] = sb
+		cnt[sb.FSUUID]++
+		if cnt[sb.FSUUID] > cnt[fsUUID] {
+			fsUUID = sb.FSUUID
 		}
+	}
 
-		if err := sb.Data.ValidateChecksum(); err != nil {
-			return nil, fmt.Errorf("file %q superblock %v: %w", sb.File.Name(), sbi, err)
+	var best *btrfstree.Superblock
+	for _, sb := range sbs {
+		if sb.FSUUID != fsUUID {
+			continue
 		}
-		if i > 0 {
-			// FIXME(lukeshu): This is probably wrong, but
-			// lots of my multi-device code is probably
-			// wrong.
-			if !sb.Data.Equal(sbs[0].Data) {
-				return nil, fmt.Errorf("file %q superblock %v and file %q superblock %v disagree",
-					sbs[0].File.Name(), 0,
-					sb.File.Name(), sbi)
-			}
+		if best == nil || sb.Generation > best.Generation {
+			best = sb
 		}
 	}
 
-	fs.cacheSuperblock = &sbs[0].Data
-	return &sbs[0].Data, nil
+	fs.cacheSuperblock = best
+	return best, nil
 }
 
 func (fs *FS) ReInit(ctx context.Context) error {
@@ -0,0 +1,77 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfs_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
+)
+
+const testNodeSize = 4096
+
+// makeTestDevice returns a Device of `size` bytes that contains a
+// single valid (but otherwise empty) superblock.  `size` is rounded
+// up to be big enough to contain the superblock.
+func makeTestDevice(t testing.TB, size btrfsvol.PhysicalAddr) *btrfs.Device {
+	t.Helper()
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Self:         btrfs.SuperblockAddrs[0],
+		NodeSize:     testNodeSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	sb.DevItem.DevID = 1
+	copy(sb.Magic[:], "_BHRfS_M")
+	var err error
+	sb.Checksum, err = sb.CalculateChecksum()
+	require.NoError(t, err)
+	sbDat, err := binstruct.Marshal(sb)
+	require.NoError(t, err)
+
+	img := make([]byte, slices.Max(size, btrfs.SuperblockAddrs[0]+btrfs.SuperblockSize))
+	file := diskio.NewMemFile[btrfsvol.PhysicalAddr](t.Name(), img)
+	copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+	return &btrfs.Device{File: file}
+}
+
+func TestFSConcurrentCaches(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	var fs btrfs.FS
+	require.NoError(t, fs.AddDevice(ctx, makeTestDevice(t, 0)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sb, err := fs.Superblock()
+			assert.NoError(t, err)
+			assert.NotNil(t, sb)
+			_, err = fs.Superblocks()
+			assert.NoError(t, err)
+			assert.NotEmpty(t, fs.Name())
+			_, err = fs.AcquireNode(context.Background(), 0, btrfstree.NodeExpectations{})
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, "fs_uuid=a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90", fs.LV.Name())
+}
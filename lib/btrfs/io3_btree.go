@@ -6,12 +6,19 @@ package btrfs
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/datawire/dlib/dlog"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
 	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
 )
 
@@ -19,6 +26,10 @@ import (
 
 // btrfstree.NodeSource ////////////////////////////////////////////////////////
 
+// DefaultNodeCacheSize is the default value of FS.NodeCacheSize; it
+// is enough to hold a few full paths from a root to a leaf.
+const DefaultNodeCacheSize = 4 * (btrfstree.MaxLevel + 1)
+
 type nodeCacheEntry struct {
 	node *btrfstree.Node
 	err  error
@@ -26,23 +37,30 @@ type nodeCacheEntry struct {
 
 // AcquireNode implements btrfstree.NodeSource.
 func (fs *FS) AcquireNode(ctx context.Context, addr btrfsvol.LogicalAddr, exp btrfstree.NodeExpectations) (*btrfstree.Node, error) {
+	fs.cacheMu.Lock()
 	if fs.cacheNodes == nil {
+		size := fs.NodeCacheSize
+		if size == 0 {
+			size = textui.Tunable(DefaultNodeCacheSize)
+		}
 		fs.cacheNodes = containers.NewARCache[btrfsvol.LogicalAddr, nodeCacheEntry](
-			textui.Tunable(4*(btrfstree.MaxLevel+1)),
+			size,
 			containers.SourceFunc[btrfsvol.LogicalAddr, nodeCacheEntry](fs.readNode),
 		)
 	}
+	cacheNodes := fs.cacheNodes
+	fs.cacheMu.Unlock()
 
-	nodeEntry := fs.cacheNodes.Acquire(ctx, addr)
+	nodeEntry := cacheNodes.Acquire(ctx, addr)
 	if nodeEntry.err != nil {
 		err := nodeEntry.err
-		fs.cacheNodes.Release(addr)
+		cacheNodes.Release(addr)
 		return nil, err
 	}
 
 	if nodeEntry.node != nil {
 		if err := exp.Check(nodeEntry.node); err != nil {
-			fs.cacheNodes.Release(addr)
+			cacheNodes.Release(addr)
 			return nil, err
 		}
 	}
@@ -58,7 +76,20 @@ func (fs *FS) ReleaseNode(node *btrfstree.Node) {
 	fs.cacheNodes.Release(node.Head.Addr)
 }
 
-func (fs *FS) readNode(_ context.Context, addr btrfsvol.LogicalAddr, nodeEntry *nodeCacheEntry) {
+// NodeCacheStats returns how effective the node cache has been.  It
+// is all zeros if no nodes have been read yet.
+func (fs *FS) NodeCacheStats() containers.CacheStats {
+	fs.cacheMu.Lock()
+	cacheNodes := fs.cacheNodes
+	fs.cacheMu.Unlock()
+	statsCache, ok := cacheNodes.(containers.CacheWithStats[btrfsvol.LogicalAddr, nodeCacheEntry])
+	if !ok {
+		return containers.CacheStats{}
+	}
+	return statsCache.Stats()
+}
+
+func (fs *FS) readNode(ctx context.Context, addr btrfsvol.LogicalAddr, nodeEntry *nodeCacheEntry) {
 	nodeEntry.node.RawFree()
 	nodeEntry.node = nil
 
@@ -68,11 +99,122 @@ func (fs *FS) readNode(_ context.Context, addr btrfsvol.LogicalAddr, nodeEntry *
 		return
 	}
 
-	nodeEntry.node, nodeEntry.err = btrfstree.ReadNode[btrfsvol.LogicalAddr](fs, *sb, addr)
+	nodeEntry.node, nodeEntry.err = btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](fs, *sb, addr, fs.NodeDecodeCache)
+	if nodeEntry.err != nil && !errors.Is(nodeEntry.err, btrfstree.ErrNotANode) {
+		// The mirrors might disagree, or the one copy that we
+		// read might be corrupt; rather than giving up, see if
+		// there is a mirror (or a parity reconstruction) that
+		// has a valid checksum.
+		node, err := btrfstree.ReadNodeCached[btrfsvol.LogicalAddr](mirrorNodeReader{ctx: ctx, fs: fs, alg: sb.ChecksumType}, *sb, addr, fs.NodeDecodeCache)
+		if err != nil {
+			node.RawFree()
+			return
+		}
+		dlog.Infof(ctx, "laddr=%v: read node from a good mirror after: %v", addr, nodeEntry.err)
+		nodeEntry.node.RawFree()
+		nodeEntry.node, nodeEntry.err = node, nil
+	}
+}
+
+// mirrorNodeReader is a diskio.ReaderAt for reading a node that,
+// rather than insisting that all mirrors agree, reads the first
+// mirror whose node checksum is valid; see
+// LogicalVolume.ReadAtVerified.
+type mirrorNodeReader struct {
+	ctx context.Context //nolint:containedctx // don't have an option while keeping the diskio.ReaderAt API
+	fs  *FS
+	alg btrfssum.CSumType
+}
+
+var _ diskio.ReaderAt[btrfsvol.LogicalAddr] = mirrorNodeReader{}
+
+func (r mirrorNodeReader) ReadAt(p []byte, off btrfsvol.LogicalAddr) (int, error) {
+	return r.fs.LV.ReadAtVerified(r.ctx, p, off, func(dat []byte) error {
+		var stored btrfssum.CSum
+		if len(dat) < len(stored) {
+			return fmt.Errorf("%v bytes is too short to be a node", len(dat))
+		}
+		copy(stored[:], dat)
+		calced, err := r.alg.Sum(dat[len(stored):])
+		if err != nil {
+			return err
+		}
+		if stored != calced {
+			return fmt.Errorf("node %w: stored=%v calculated=%v",
+				btrfstree.ErrNodeChecksum, stored.Fmt(r.alg), calced.Fmt(r.alg))
+		}
+		return nil
+	})
 }
 
 var _ btrfstree.NodeSource = (*FS)(nil)
 
+// NodeCopy describes one physical copy of a logical node, as returned
+// by FS.NodeCopies.
+type NodeCopy struct {
+	Dev   btrfsvol.DeviceID
+	PAddr btrfsvol.PhysicalAddr
+	// ChecksumOK is whether the copy was read and its checksum
+	// verified; if the checksum was verified but the node is
+	// still not OK (it failed to parse), then ChecksumOK is true
+	// and Err is non-nil.  A bad checksum is reported in Err as
+	// btrfstree.ErrNodeChecksum, distinct from errors that
+	// prevented the checksum from being checked at all (such as
+	// a *btrfstree.IOError).
+	ChecksumOK bool
+	Generation btrfsprim.Generation // only valid if the header could be read
+	Err        error                // why the copy is not OK, if it isn't
+}
+
+// NodeCopies resolves every physical location of the node at `laddr`
+// (more than one for DUP/RAID1-style profiles), and reads each of
+// them independently (bypassing the node cache), reporting whether
+// each copy is valid.  This is useful for deciding which mirror to
+// trust when they disagree.
+//
+// The returned list is sorted by physical address.
+func (fs *FS) NodeCopies(laddr btrfsvol.LogicalAddr) ([]NodeCopy, error) {
+	sb, err := fs.Superblock()
+	if err != nil {
+		return nil, err
+	}
+	paddrs, _ := fs.LV.Resolve(laddr)
+	if len(paddrs) == 0 {
+		return nil, fmt.Errorf("could not map logical address %v", laddr)
+	}
+	devs := fs.LV.PhysicalVolumes()
+	ret := make([]NodeCopy, 0, len(paddrs))
+	sortedPAddrs := maps.Keys(paddrs)
+	sort.Slice(sortedPAddrs, func(i, j int) bool {
+		return sortedPAddrs[i].Compare(sortedPAddrs[j]) < 0
+	})
+	for _, paddr := range sortedPAddrs {
+		nodeCopy := NodeCopy{
+			Dev:   paddr.Dev,
+			PAddr: paddr.Addr,
+		}
+		dev, ok := devs[paddr.Dev]
+		if !ok {
+			nodeCopy.Err = fmt.Errorf("device=%v does not exist", paddr.Dev)
+			ret = append(ret, nodeCopy)
+			continue
+		}
+		// The filter is only called once the checksum has
+		// been verified.
+		node, err := btrfstree.ReadNodeFiltered[btrfsvol.PhysicalAddr](dev, *sb, paddr.Addr, func(btrfstree.NodeHeader) bool {
+			nodeCopy.ChecksumOK = true
+			return true
+		})
+		if node != nil {
+			nodeCopy.Generation = node.Head.Generation
+			node.RawFree()
+		}
+		nodeCopy.Err = err
+		ret = append(ret, nodeCopy)
+	}
+	return ret, nil
+}
+
 // btrfstree.Forrest ///////////////////////////////////////////////////////////
 
 // RawTree is a variant of ForrestLookup that returns a concrete type
@@ -101,6 +243,7 @@ type ReadableFS interface {
 
 	// For reading file contents.
 	diskio.ReaderAt[btrfsvol.LogicalAddr]
+	ReadAtVerified(ctx context.Context, p []byte, off btrfsvol.LogicalAddr, alg btrfssum.CSumType, expSum btrfssum.CSum) (int, error)
 }
 
 var _ ReadableFS = (*FS)(nil)
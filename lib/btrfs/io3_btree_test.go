@@ -0,0 +1,233 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfs_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+// writeTestNode writes a leaf node containing a single item for the
+// logical address `laddr` to every physical address that `laddr` maps
+// to.
+func writeTestNode(t *testing.T, fs *btrfs.FS, laddr btrfsvol.LogicalAddr, gen btrfsprim.Generation) {
+	t.Helper()
+	sb, err := fs.Superblock()
+	require.NoError(t, err)
+	node := btrfstree.Node{
+		Size:         sb.NodeSize,
+		ChecksumType: sb.ChecksumType,
+		Head: btrfstree.NodeHeader{
+			MetadataUUID: sb.EffectiveMetadataUUID(),
+			Addr:         laddr,
+			Generation:   gen,
+			Owner:        btrfsprim.FS_TREE_OBJECTID,
+		},
+		BodyLeaf: []btrfstree.Item{
+			{
+				Key:  btrfsprim.Key{ObjectID: 256, ItemType: btrfsitem.INODE_ITEM_KEY},
+				Body: &btrfsitem.Inode{Size: 42},
+			},
+		},
+	}
+	node.Head.Checksum, err = node.CalculateChecksum()
+	require.NoError(t, err)
+	dat, err := binstruct.Marshal(node)
+	require.NoError(t, err)
+	_, err = fs.WriteAt(dat, laddr)
+	require.NoError(t, err)
+}
+
+func TestNodeCopies(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	dev := makeTestDevice(t, 4*1024*1024)
+	var fs btrfs.FS
+	require.NoError(t, fs.AddDevice(ctx, dev))
+	// DUP: two copies of the same logical range on one device.
+	for _, paddr := range []btrfsvol.PhysicalAddr{1024 * 1024, 2 * 1024 * 1024} {
+		require.NoError(t, fs.LV.AddMapping(btrfsvol.Mapping{
+			LAddr: 1024 * 1024,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: paddr},
+			Size:  1024 * 1024,
+		}))
+	}
+
+	const laddr = btrfsvol.LogicalAddr(1024*1024 + 2*testNodeSize)
+	writeTestNode(t, &fs, laddr, 7)
+
+	// Both copies good.
+	copies, err := fs.NodeCopies(laddr)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	for _, nodeCopy := range copies {
+		assert.True(t, nodeCopy.ChecksumOK)
+		assert.NoError(t, nodeCopy.Err)
+		assert.Equal(t, btrfsprim.Generation(7), nodeCopy.Generation)
+	}
+	assert.Equal(t, btrfsvol.PhysicalAddr(1024*1024+2*testNodeSize), copies[0].PAddr)
+	assert.Equal(t, btrfsvol.PhysicalAddr(2*1024*1024+2*testNodeSize), copies[1].PAddr)
+
+	// Corrupt the second copy.
+	_, err = dev.WriteAt([]byte{0xff}, copies[1].PAddr+testNodeSize-1)
+	require.NoError(t, err)
+	copies, err = fs.NodeCopies(laddr)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	assert.True(t, copies[0].ChecksumOK)
+	assert.False(t, copies[1].ChecksumOK)
+	assert.ErrorIs(t, copies[1].Err, btrfstree.ErrNodeChecksum)
+	assert.Equal(t, btrfsprim.Generation(7), copies[1].Generation)
+
+	// Unreadable: mapped past the end of the device.  This is not
+	// a checksum failure.
+	require.NoError(t, fs.LV.AddMapping(btrfsvol.Mapping{
+		LAddr: 8 * 1024 * 1024,
+		PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 4 * 1024 * 1024},
+		Size:  1024 * 1024,
+	}))
+	copies, err = fs.NodeCopies(8 * 1024 * 1024)
+	require.NoError(t, err)
+	require.Len(t, copies, 1)
+	assert.False(t, copies[0].ChecksumOK)
+	var ioErr *btrfstree.IOError
+	assert.ErrorAs(t, copies[0].Err, &ioErr)
+	assert.NotErrorIs(t, copies[0].Err, btrfstree.ErrNodeChecksum)
+
+	// Good checksum, but a body that doesn't parse.
+	const badLAddr = laddr + 2*testNodeSize
+	writeTestNode(t, &fs, badLAddr, 8)
+	buf := make([]byte, testNodeSize)
+	_, err = fs.ReadAt(buf, badLAddr)
+	require.NoError(t, err)
+	binary.LittleEndian.PutUint32(buf[0x60:], 0xffff) // .Head.NumItems
+	sb, err := fs.Superblock()
+	require.NoError(t, err)
+	csum, err := sb.ChecksumType.Sum(buf[0x20:])
+	require.NoError(t, err)
+	copy(buf, csum[:])
+	_, err = fs.WriteAt(buf, badLAddr)
+	require.NoError(t, err)
+	copies, err = fs.NodeCopies(badLAddr)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	for _, nodeCopy := range copies {
+		assert.True(t, nodeCopy.ChecksumOK)
+		assert.Error(t, nodeCopy.Err)
+	}
+
+	// Unmapped.
+	_, err = fs.NodeCopies(0)
+	assert.Error(t, err)
+}
+
+func TestDeviceReadNode(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	dev := makeTestDevice(t, 4*1024*1024)
+	var fs btrfs.FS
+	require.NoError(t, fs.AddDevice(ctx, dev))
+	require.NoError(t, fs.LV.AddMapping(btrfsvol.Mapping{
+		LAddr: 1024 * 1024,
+		PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 3 * 1024 * 1024},
+		Size:  1024 * 1024,
+	}))
+	const laddr = btrfsvol.LogicalAddr(1024*1024 + testNodeSize)
+	writeTestNode(t, &fs, laddr, 9)
+	const paddr = btrfsvol.PhysicalAddr(3*1024*1024 + testNodeSize)
+
+	// Read it by physical address, without a logical mapping.
+	var unmapped btrfs.Device
+	unmapped.File = dev.File
+	node, err := unmapped.ReadNode(paddr)
+	require.NoError(t, err)
+	assert.Equal(t, laddr, node.Head.Addr)
+	assert.Equal(t, btrfsprim.Generation(9), node.Head.Generation)
+	node.RawFree()
+
+	// Not a node.
+	node, err = unmapped.ReadNode(paddr + testNodeSize)
+	assert.ErrorIs(t, err, btrfstree.ErrNotANode)
+	if node != nil {
+		node.RawFree()
+	}
+
+	// Bad checksum.
+	_, err = dev.WriteAt([]byte{0xff}, paddr+testNodeSize-1)
+	require.NoError(t, err)
+	node, err = unmapped.ReadNode(paddr)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, btrfstree.ErrNotANode)
+	if node != nil {
+		node.RawFree()
+	}
+}
+
+func TestAcquireNodeBadMirror(t *testing.T) {
+	t.Parallel()
+	const laddr = btrfsvol.LogicalAddr(1024*1024 + 2*testNodeSize)
+	mirrors := []btrfsvol.PhysicalAddr{1024 * 1024, 2 * 1024 * 1024}
+
+	type TestCase struct {
+		Corrupt []int
+		ExpOK   bool
+	}
+	testcases := map[string]TestCase{
+		"both-good":  {Corrupt: nil, ExpOK: true},
+		"first-bad":  {Corrupt: []int{0}, ExpOK: true},
+		"second-bad": {Corrupt: []int{1}, ExpOK: true},
+		"both-bad":   {Corrupt: []int{0, 1}, ExpOK: false},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			ctx := dlog.NewTestContext(t, false)
+
+			dev := makeTestDevice(t, 4*1024*1024)
+			var fs btrfs.FS
+			require.NoError(t, fs.AddDevice(ctx, dev))
+			// DUP: two copies of the same logical range on
+			// one device.
+			for _, paddr := range mirrors {
+				require.NoError(t, fs.LV.AddMapping(btrfsvol.Mapping{
+					LAddr: 1024 * 1024,
+					PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: paddr},
+					Size:  1024 * 1024,
+				}))
+			}
+			writeTestNode(t, &fs, laddr, 7)
+			for i, mirror := range tc.Corrupt {
+				// Flip a different byte in each, so that the
+				// mirrors don't agree with each other either.
+				paddr := mirrors[mirror] + btrfsvol.PhysicalAddr(laddr-1024*1024) + testNodeSize - 1 - btrfsvol.PhysicalAddr(i)
+				_, err := dev.WriteAt([]byte{0xff}, paddr)
+				require.NoError(t, err)
+			}
+
+			node, err := fs.AcquireNode(ctx, laddr, btrfstree.NodeExpectations{})
+			if tc.ExpOK {
+				require.NoError(t, err)
+				assert.Equal(t, btrfsprim.Generation(7), node.Head.Generation)
+				fs.ReleaseNode(node)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -11,9 +11,12 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"sync"
 
 	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
 
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfscompress"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
@@ -50,6 +53,13 @@ type Dir struct {
 	SV              *Subvolume
 }
 
+// A DirEntry is a directory entry along with its DIR_INDEX index; see
+// Subvolume.ReadDir.
+type DirEntry struct {
+	Index uint64
+	btrfsitem.DirEntry
+}
+
 type FileExtent struct {
 	OffsetWithinFile int64
 	btrfsitem.FileExtent
@@ -57,8 +67,43 @@ type FileExtent struct {
 
 type File struct {
 	FullInode
+	// Refs are the file's INODE_REF and INODE_EXTREF
+	// back-references; one for each hard link to the file.
+	Refs []InodeRef
+	// Extents must not be modified after the first read from the
+	// File.
 	Extents []FileExtent
 	SV      *Subvolume
+
+	// extentIndex is .Extents indexed by the range of the file
+	// that each one covers, so that a read doesn't need to scan
+	// every extent.  It is built by .loadFile(), or by the first
+	// read for a File that was constructed some other way.
+	extentIndexOnce sync.Once
+	extentIndex     containers.IntervalTree[containers.NativeOrdered[int64], FileExtent]
+
+	// A compressed extent has to be decompressed from the
+	// beginning even to read a single block out of it; so hang
+	// on to the most recently decompressed one.
+	decompressedMu   sync.Mutex
+	decompressedAddr btrfsvol.LogicalAddr
+	decompressed     []byte
+}
+
+// An ExtentConflict is a pair of a file's extents that both claim the
+// same range of the file, which is a corruption of the filesystem.
+// Deciding which of the two to keep (e.g. by comparing their
+// generations) is up to the caller.
+type ExtentConflict struct {
+	// A starts before (or at the same offset as) B.
+	A, B FileExtent
+	// Beg and End are the range of the file that is claimed by
+	// both A and B.
+	Beg, End int64
+}
+
+func (c ExtentConflict) Error() string {
+	return fmt.Sprintf("extent overlap from %v to %v", c.Beg, c.End)
 }
 
 type Subvolume struct {
@@ -66,6 +111,17 @@ type Subvolume struct {
 	fs          ReadableFS
 	TreeID      btrfsprim.ObjID
 	noChecksums bool
+	// lenientChecksums causes a checksum mismatch when reading a
+	// file to be logged rather than returned as an error, so that
+	// the (possibly corrupt) data is still returned.
+	lenientChecksums bool
+
+	// noHoles is whether the filesystem has the NO_HOLES
+	// feature, in which holes in a file are left out rather than
+	// being given EXTENT_DATA items of their own.
+	noHoles bool
+
+	cacheSize int
 
 	rootErr  error
 	rootInfo btrfstree.TreeRoot
@@ -75,19 +131,50 @@ type Subvolume struct {
 	fullInodeCache containers.Cache[btrfsprim.ObjID, FullInode]
 	dirCache       containers.Cache[btrfsprim.ObjID, Dir]
 	fileCache      containers.Cache[btrfsprim.ObjID, File]
+	csumCache      containers.Cache[btrfsvol.LogicalAddr, csumWindow]
+}
+
+// csumWindowSize is the size of the aligned ranges of logical
+// addresses that the Subvolume's csum cache holds the csum-runs for;
+// so that reading a file doesn't need to search the csum tree for
+// every block.
+const csumWindowSize = 4 * 1024 * 1024
+
+// DefaultSubvolumeCacheSize is how many inodes, directories, and
+// files a Subvolume caches if NewSubvolume is passed a cacheSize of
+// zero.
+const DefaultSubvolumeCacheSize = 128
+
+// subvolumeCSumCacheRatio is how many times fewer csum windows than
+// inodes a Subvolume caches; each window can be much larger than an
+// inode.
+const subvolumeCSumCacheRatio = 8
+
+type csumWindow struct {
+	Runs []btrfssum.SumRun[btrfsvol.LogicalAddr]
+	Err  error
 }
 
+// NewSubvolume returns a Subvolume for reading the files in tree
+// `treeID`.  Up to `cacheSize` each of inodes, directories, and files
+// are cached (along with a proportional number of windows of file
+// checksums); if `cacheSize` is zero, DefaultSubvolumeCacheSize is
+// used.
 func NewSubvolume(
 	ctx context.Context,
 	fs ReadableFS,
 	treeID btrfsprim.ObjID,
 	noChecksums bool,
+	lenientChecksums bool,
+	cacheSize int,
 ) *Subvolume {
 	sv := &Subvolume{
-		ctx:         ctx,
-		fs:          fs,
-		TreeID:      treeID,
-		noChecksums: noChecksums,
+		ctx:              ctx,
+		fs:               fs,
+		TreeID:           treeID,
+		noChecksums:      noChecksums,
+		lenientChecksums: lenientChecksums,
+		cacheSize:        cacheSize,
 	}
 
 	tree, err := sv.fs.ForrestLookup(ctx, sv.TreeID)
@@ -99,21 +186,30 @@ func NewSubvolume(
 	rootInfo, _ := btrfstree.LookupTreeRoot(ctx, sv.fs, *sb, sv.TreeID)
 	sv.rootInfo = *rootInfo
 	sv.tree = tree
+	sv.noHoles = sb.IncompatFlags.Has(btrfstree.FeatureIncompatNoHoles)
 
-	sv.bareInodeCache = containers.NewARCache[btrfsprim.ObjID, BareInode](textui.Tunable(128),
+	size := cacheSize
+	if size == 0 {
+		size = textui.Tunable(DefaultSubvolumeCacheSize)
+	}
+	csumSize := slices.Max(size/subvolumeCSumCacheRatio, 1)
+
+	sv.bareInodeCache = containers.NewARCache[btrfsprim.ObjID, BareInode](size,
 		containers.SourceFunc[btrfsprim.ObjID, BareInode](sv.loadBareInode))
-	sv.fullInodeCache = containers.NewARCache[btrfsprim.ObjID, FullInode](textui.Tunable(128),
+	sv.fullInodeCache = containers.NewARCache[btrfsprim.ObjID, FullInode](size,
 		containers.SourceFunc[btrfsprim.ObjID, FullInode](sv.loadFullInode))
-	sv.dirCache = containers.NewARCache[btrfsprim.ObjID, Dir](textui.Tunable(128),
+	sv.dirCache = containers.NewARCache[btrfsprim.ObjID, Dir](size,
 		containers.SourceFunc[btrfsprim.ObjID, Dir](sv.loadDir))
-	sv.fileCache = containers.NewARCache[btrfsprim.ObjID, File](textui.Tunable(128),
+	sv.fileCache = containers.NewARCache[btrfsprim.ObjID, File](size,
 		containers.SourceFunc[btrfsprim.ObjID, File](sv.loadFile))
+	sv.csumCache = containers.NewLRUCache[btrfsvol.LogicalAddr, csumWindow](csumSize,
+		containers.SourceFunc[btrfsvol.LogicalAddr, csumWindow](sv.loadCSumWindow))
 
 	return sv
 }
 
 func (sv *Subvolume) NewChildSubvolume(childID btrfsprim.ObjID) *Subvolume {
-	return NewSubvolume(sv.ctx, sv.fs, childID, sv.noChecksums)
+	return NewSubvolume(sv.ctx, sv.fs, childID, sv.noChecksums, sv.lenientChecksums, sv.cacheSize)
 }
 
 func (sv *Subvolume) GetRootInode() (btrfsprim.ObjID, error) {
@@ -356,6 +452,218 @@ func (dir *Dir) AbsPath() (string, error) {
 	return filepath.Join(parentName, string(dir.DotDot.Name)), nil
 }
 
+// parseInodeRefs returns the back-references in an INODE_REF or
+// INODE_EXTREF item; there may be several if the inode has several
+// hard links in the same directory (INODE_REF) or whose hashes
+// collide (INODE_EXTREF).
+func parseInodeRefs(item btrfstree.Item) ([]InodeRef, error) {
+	switch body := item.Body.(type) {
+	case *btrfsitem.InodeRefs:
+		refs := make([]InodeRef, 0, len(body.Refs))
+		for _, ref := range body.Refs {
+			refs = append(refs, InodeRef{
+				Inode:    btrfsprim.ObjID(item.Key.Offset),
+				InodeRef: ref,
+			})
+		}
+		return refs, nil
+	case *btrfsitem.InodeExtRefs:
+		refs := make([]InodeRef, 0, len(body.Refs))
+		for _, ref := range body.Refs {
+			refs = append(refs, InodeRef{
+				Inode: ref.Parent,
+				InodeRef: btrfsitem.InodeRef{
+					Index:   ref.Index,
+					NameLen: ref.NameLen,
+					Name:    ref.Name,
+				},
+			})
+		}
+		return refs, nil
+	case *btrfsitem.Error:
+		return nil, fmt.Errorf("malformed %v: %w", item.Key.ItemType, body.Err)
+	default:
+		panic(fmt.Errorf("should not happen: %v has unexpected item type: %T", item.Key.ItemType, body))
+	}
+}
+
+// InodePaths returns the absolute paths of `inode` within the
+// subvolume, one for each of its INODE_REF and INODE_EXTREF
+// back-references; so a file with several hard links has several
+// paths.  They are in on-disk order: the INODE_REF items by parent
+// directory inode number, then the INODE_EXTREF items by hash; and
+// within each item, in the order that the back-references appear.
+//
+// Back-references that can't be resolved to a path (for instance,
+// because the parent directory is missing) are left out, and
+// reported in a derror.MultiError that is returned alongside the
+// paths that could be resolved.
+func (sv *Subvolume) InodePaths(inode btrfsprim.ObjID) ([]string, error) {
+	rootInode, err := sv.GetRootInode()
+	if err != nil {
+		return nil, err
+	}
+	if inode == rootInode {
+		return []string{"/"}, nil
+	}
+
+	full, err := sv.AcquireFullInode(inode)
+	if err != nil {
+		return nil, err
+	}
+	defer sv.ReleaseFullInode(inode)
+
+	var paths []string
+	var errs derror.MultiError
+	for _, item := range full.OtherItems {
+		if item.Key.ItemType != btrfsitem.INODE_REF_KEY && item.Key.ItemType != btrfsitem.INODE_EXTREF_KEY {
+			continue
+		}
+		refs, err := parseInodeRefs(item)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, ref := range refs {
+			dirPath, err := sv.dirAbsPath(ref.Inode)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%q in dir inode %v: %w", ref.Name, ref.Inode, err))
+				continue
+			}
+			paths = append(paths, filepath.Join(dirPath, string(ref.Name)))
+		}
+	}
+	if len(paths) == 0 && len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("no INODE_REF"))
+	}
+
+	if len(errs) > 0 {
+		return paths, errs
+	}
+	return paths, nil
+}
+
+func (sv *Subvolume) dirAbsPath(inode btrfsprim.ObjID) (string, error) {
+	dir, err := sv.AcquireDir(inode)
+	if err != nil {
+		return "", err
+	}
+	defer sv.ReleaseDir(inode)
+	return dir.AbsPath()
+}
+
+// ReadDir returns the entries of the directory `inode`, in on-disk
+// (DIR_INDEX) order.
+//
+// If the directory's DIR_ITEM and DIR_INDEX items are inconsistent
+// with each other, then the reconciled entries are still returned,
+// but they are accompanied by a derror.MultiError describing the
+// problems.
+func (sv *Subvolume) ReadDir(inode btrfsprim.ObjID) ([]DirEntry, error) {
+	dir, err := sv.AcquireDir(inode)
+	if err != nil {
+		return nil, err
+	}
+	defer sv.ReleaseDir(inode)
+
+	ret := make([]DirEntry, 0, len(dir.ChildrenByIndex))
+	for _, index := range maps.SortedKeys(dir.ChildrenByIndex) {
+		ret = append(ret, DirEntry{
+			Index:    index,
+			DirEntry: dir.ChildrenByIndex[index].Clone(),
+		})
+	}
+	if len(dir.Errs) > 0 {
+		return ret, append(derror.MultiError(nil), dir.Errs...)
+	}
+	return ret, nil
+}
+
+// Walk calls fn for every inode that is reachable from the root
+// directory of the subvolume, along with the absolute path (within
+// the subvolume) that it was reached by.  The walk is depth-first,
+// with each directory passed to fn before its entries, and each
+// directory's entries visited in on-disk (DIR_INDEX) order.  The
+// FullInode is only valid for the duration of the call to fn.
+//
+// Entries that refer to other subvolumes are not descended in to
+// (see NewChildSubvolume).  A directory that has already been walked
+// (for instance, because a corrupt entry points back up the tree) is
+// not walked again.
+//
+// Problems with individual inodes and directories don't stop the
+// walk; they are returned as a derror.MultiError once the walk is
+// complete.  If fn returns an error, then the walk stops and that
+// error is returned.
+func (sv *Subvolume) Walk(ctx context.Context, fn func(path string, inode btrfsprim.ObjID, item *FullInode) error) error {
+	rootInode, err := sv.GetRootInode()
+	if err != nil {
+		return err
+	}
+	w := subvolumeWalker{
+		sv:      sv,
+		fn:      fn,
+		visited: make(containers.Set[btrfsprim.ObjID]),
+	}
+	if err := w.walk(ctx, "/", rootInode, true); err != nil {
+		return err
+	}
+	if len(w.errs) > 0 {
+		return w.errs
+	}
+	return nil
+}
+
+type subvolumeWalker struct {
+	sv      *Subvolume
+	fn      func(path string, inode btrfsprim.ObjID, item *FullInode) error
+	visited containers.Set[btrfsprim.ObjID]
+	errs    derror.MultiError
+}
+
+func (w *subvolumeWalker) walk(ctx context.Context, path string, inode btrfsprim.ObjID, isDir bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if isDir {
+		if w.visited.Has(inode) {
+			w.errs = append(w.errs, fmt.Errorf("%s: directory inode %v has already been walked", path, inode))
+			return nil
+		}
+		w.visited.Insert(inode)
+	}
+
+	fullInode, err := w.sv.AcquireFullInode(inode)
+	if err != nil {
+		w.errs = append(w.errs, fmt.Errorf("%s: %w", path, err))
+		return nil
+	}
+	err = w.fn(path, inode, fullInode)
+	w.sv.ReleaseFullInode(inode)
+	if err != nil || !isDir {
+		return err
+	}
+
+	entries, err := w.sv.ReadDir(inode)
+	if err != nil {
+		w.errs = append(w.errs, fmt.Errorf("%s: %w", path, err))
+	}
+	for _, entry := range entries {
+		if entry.IsSubvolume() {
+			continue
+		}
+		childPath := filepath.Join(path, string(entry.Name))
+		child, ok := entry.TargetInode()
+		if !ok {
+			w.errs = append(w.errs, fmt.Errorf("%s: unexpected entry location %v", childPath, entry.Location))
+			continue
+		}
+		if err := w.walk(ctx, childPath, child, entry.Type == btrfsitem.FT_DIR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (sv *Subvolume) AcquireFile(inode btrfsprim.ObjID) (*File, error) {
 	val := sv.fileCache.Acquire(sv.ctx, inode)
 	if val.Inode == 0 {
@@ -382,8 +690,12 @@ func (sv *Subvolume) loadFile(_ context.Context, inode btrfsprim.ObjID, file *Fi
 
 	for _, item := range file.OtherItems {
 		switch item.Key.ItemType {
-		case btrfsitem.INODE_REF_KEY:
-			// TODO
+		case btrfsitem.INODE_REF_KEY, btrfsitem.INODE_EXTREF_KEY:
+			refs, err := parseInodeRefs(item)
+			if err != nil {
+				file.Errs = append(file.Errs, err)
+			}
+			file.Refs = append(file.Refs, refs...)
 		case btrfsitem.EXTENT_DATA_KEY:
 			switch itemBody := item.Body.(type) {
 			case *btrfsitem.FileExtent:
@@ -397,7 +709,7 @@ func (sv *Subvolume) loadFile(_ context.Context, inode btrfsprim.ObjID, file *Fi
 				panic(fmt.Errorf("should not happen: EXTENT_DATA has unexpected item type: %T", itemBody))
 			}
 		default:
-			panic(fmt.Errorf("TODO: handle item type %v", item.Key.ItemType))
+			file.Errs = append(file.Errs, fmt.Errorf("unexpected item type %v", item.Key.ItemType))
 		}
 	}
 
@@ -410,37 +722,112 @@ func (sv *Subvolume) loadFile(_ context.Context, inode btrfsprim.ObjID, file *Fi
 
 	pos := int64(0)
 	for _, extent := range file.Extents {
-		if extent.OffsetWithinFile != pos {
-			if extent.OffsetWithinFile > pos {
-				file.Errs = append(file.Errs, fmt.Errorf("extent gap from %v to %v",
-					pos, extent.OffsetWithinFile))
-			} else {
-				file.Errs = append(file.Errs, fmt.Errorf("extent overlap from %v to %v",
-					extent.OffsetWithinFile, pos))
-			}
+		if extent.OffsetWithinFile > pos && !sv.noHoles {
+			file.Errs = append(file.Errs, fmt.Errorf("extent gap from %v to %v",
+				pos, extent.OffsetWithinFile))
 		}
 		size, err := extent.Size()
 		if err != nil {
 			file.Errs = append(file.Errs, fmt.Errorf("extent %v: %w", extent.OffsetWithinFile, err))
 		}
-		pos = extent.OffsetWithinFile + size
+		pos = slices.Max(pos, extent.OffsetWithinFile+size)
 	}
+	for _, conflict := range file.ExtentConflicts() {
+		file.Errs = append(file.Errs, conflict)
+	}
+	file.indexExtents()
 	if file.InodeItem != nil && pos != file.InodeItem.NumBytes {
-		if file.InodeItem.NumBytes > pos {
-			file.Errs = append(file.Errs, fmt.Errorf("extent gap from %v to %v",
-				pos, file.InodeItem.NumBytes))
-		} else {
+		switch {
+		case file.InodeItem.NumBytes < pos:
 			file.Errs = append(file.Errs, fmt.Errorf("extent mapped past end of file from %v to %v",
 				file.InodeItem.NumBytes, pos))
+		case !sv.noHoles:
+			file.Errs = append(file.Errs, fmt.Errorf("extent gap from %v to %v",
+				pos, file.InodeItem.NumBytes))
+		}
+	}
+}
+
+// ExtentConflicts returns every pair of the file's extents that claim
+// overlapping ranges of the file.  The overlaps are also included in
+// .Errs, but only as error messages.
+//
+// .Extents must be sorted by OffsetWithinFile, as they are when the
+// File is obtained from .AcquireFile().
+func (file *File) ExtentConflicts() []ExtentConflict {
+	type extentWithEnd struct {
+		FileExtent
+		End int64
+	}
+	var ret []ExtentConflict
+	// active is the extents that extend past the start of the
+	// current extent; for a healthy file there is at most 1.
+	var active []extentWithEnd
+	for _, b := range file.Extents {
+		bSize, _ := b.Size() // errors are already reported by .loadFile()
+		bEnd := b.OffsetWithinFile + bSize
+
+		stillActive := active[:0]
+		for _, a := range active {
+			if a.End > b.OffsetWithinFile {
+				stillActive = append(stillActive, a)
+			}
+		}
+		active = stillActive
+
+		if bEnd <= b.OffsetWithinFile {
+			continue
+		}
+		for _, a := range active {
+			ret = append(ret, ExtentConflict{
+				A:   a.FileExtent,
+				B:   b,
+				Beg: b.OffsetWithinFile,
+				End: slices.Min(a.End, bEnd),
+			})
 		}
+		active = append(active, extentWithEnd{
+			FileExtent: b,
+			End:        bEnd,
+		})
 	}
+	return ret
+}
+
+// indexExtents populates .extentIndex from .Extents, if it hasn't
+// been already, and returns it.
+func (file *File) indexExtents() *containers.IntervalTree[containers.NativeOrdered[int64], FileExtent] {
+	file.extentIndexOnce.Do(func() {
+		file.extentIndex = containers.IntervalTree[containers.NativeOrdered[int64], FileExtent]{
+			MinFn: func(extent FileExtent) containers.NativeOrdered[int64] {
+				return containers.NativeOrdered[int64]{Val: extent.OffsetWithinFile}
+			},
+			MaxFn: func(extent FileExtent) containers.NativeOrdered[int64] {
+				size, _ := extent.Size()
+				return containers.NativeOrdered[int64]{Val: extent.OffsetWithinFile + size - 1}
+			},
+		}
+		// Insert in reverse, so that if several extents
+		// claim exactly the same range, the first one in
+		// .Extents is the one that is kept.
+		for i := len(file.Extents) - 1; i >= 0; i-- {
+			extent := file.Extents[i]
+			size, err := extent.Size()
+			if err != nil || size <= 0 {
+				// Errors are already reported by
+				// .loadFile(), and empty extents
+				// can't be read from anyway.
+				continue
+			}
+			file.extentIndex.Insert(extent)
+		}
+	})
+	return &file.extentIndex
 }
 
 func (file *File) ReadAt(dat []byte, off int64) (int, error) {
-	// These stateless maybe-short-reads each do an O(n) extent
-	// lookup, so reading a file is O(n^2), but we expect n to be
-	// small, so whatev.  Turn file.Extents in to an rbtree if it
-	// becomes a problem.
+	// Each of these stateless maybe-short-reads does an O(log n)
+	// extent lookup, so reading a file is O(n log n).
 	done := 0
 	for done < len(dat) {
 		n, err := file.maybeShortReadAt(dat[done:], off+int64(done))
@@ -453,72 +840,214 @@ func (file *File) ReadAt(dat []byte, off int64) (int, error) {
 }
 
 func (file *File) maybeShortReadAt(dat []byte, off int64) (int, error) {
-	for _, extent := range file.Extents {
-		extBeg := extent.OffsetWithinFile
-		if extBeg > off {
-			break
-		}
-		extLen, err := extent.Size()
-		if err != nil {
-			continue
-		}
-		extEnd := extBeg + extLen
-		if extEnd <= off {
-			continue
-		}
+	// If several extents overlap here, use the one that begins
+	// first.
+	for _, extent := range file.indexExtents().Stab(containers.NativeOrdered[int64]{Val: off}) {
+		extLen, _ := extent.Size() // errors are filtered out by .indexExtents()
 		offsetWithinExt := off - extent.OffsetWithinFile
 		readSize := slices.Min(int64(len(dat)), extLen-offsetWithinExt, btrfssum.BlockSize)
-		switch extent.Type {
-		case btrfsitem.FILE_EXTENT_INLINE:
+		switch {
+		case extent.Type == btrfsitem.FILE_EXTENT_REG && extent.BodyExtent.DiskByteNr == 0:
+			// An explicit hole; there is nothing on disk
+			// to read.
+			return file.readHole(dat[:readSize]), nil
+		case extent.Compression != btrfsitem.COMPRESS_NONE && extent.Type != btrfsitem.FILE_EXTENT_PREALLOC:
+			return file.readCompressed(dat[:readSize], extent, offsetWithinExt)
+		case extent.Type == btrfsitem.FILE_EXTENT_INLINE:
 			return copy(dat, extent.BodyInline[offsetWithinExt:offsetWithinExt+readSize]), nil
-		case btrfsitem.FILE_EXTENT_REG, btrfsitem.FILE_EXTENT_PREALLOC:
-			sb, err := file.SV.fs.Superblock()
-			if err != nil {
-				return 0, err
+		case extent.Type == btrfsitem.FILE_EXTENT_PREALLOC:
+			// Preallocated-but-unwritten space reads as
+			// zeros; there is nothing on disk to read, and
+			// no checksum to verify.  Preallocation may
+			// extend past the end of the file (fallocate
+			// with FALLOC_FL_KEEP_SIZE), so respect the
+			// inode size.
+			if file.InodeItem != nil {
+				if off >= file.InodeItem.Size {
+					return 0, io.EOF
+				}
+				readSize = slices.Min(readSize, file.InodeItem.Size-off)
 			}
+			return file.readHole(dat[:readSize]), nil
+		case extent.Type == btrfsitem.FILE_EXTENT_REG:
 			beg := extent.BodyExtent.DiskByteNr.
 				Add(extent.BodyExtent.Offset).
 				Add(btrfsvol.AddrDelta(offsetWithinExt))
 			var block [btrfssum.BlockSize]byte
 			blockBeg := (beg / btrfssum.BlockSize) * btrfssum.BlockSize
-			n, err := file.SV.fs.ReadAt(block[:], blockBeg)
+			n, err := file.readBlock(&block, blockBeg)
+			if err != nil {
+				return 0, err
+			}
 			if n > int(beg-blockBeg) {
 				n = copy(dat[:readSize], block[beg-blockBeg:])
 			} else {
 				n = 0
 			}
-			if err != nil {
-				return 0, err
-			}
-			if !file.SV.noChecksums {
-				sumRun, err := LookupCSum(file.SV.ctx, file.SV.fs, sb.ChecksumType, blockBeg)
-				if err != nil {
-					return 0, fmt.Errorf("checksum@%v: %w", blockBeg, err)
-				}
-				_expSum, ok := sumRun.SumForAddr(blockBeg)
-				if !ok {
-					panic(fmt.Errorf("run from LookupCSum(fs, typ, %v) did not contain %v: %#v",
-						blockBeg, blockBeg, sumRun))
-				}
-				expSum := _expSum.ToFullSum()
-
-				actSum, err := sb.ChecksumType.Sum(block[:])
-				if err != nil {
-					return 0, fmt.Errorf("checksum@%v: %w", blockBeg, err)
-				}
-
-				if actSum != expSum {
-					return 0, fmt.Errorf("checksum@%v: actual sum %v != expected sum %v",
-						blockBeg, actSum, expSum)
-				}
-			}
 			return n, nil
 		}
 	}
 	if file.InodeItem != nil && off >= file.InodeItem.Size {
 		return 0, io.EOF
 	}
+	if file.SV != nil && file.SV.noHoles && file.InodeItem != nil {
+		// With NO_HOLES, a gap between extents (or between
+		// the last extent and the end of the file) is a
+		// hole, rather than a corruption.
+		holeEnd := file.InodeItem.Size
+		next := sort.Search(len(file.Extents), func(i int) bool {
+			return file.Extents[i].OffsetWithinFile > off
+		})
+		if next < len(file.Extents) {
+			holeEnd = slices.Min(holeEnd, file.Extents[next].OffsetWithinFile)
+		}
+		return file.readHole(dat[:slices.Min(int64(len(dat)), holeEnd-off)]), nil
+	}
 	return 0, fmt.Errorf("read: could not map position %v", off)
 }
 
+// readHole fills `dat` with zeros, for a part of the file that has no
+// data on disk (a hole, or preallocated space that hasn't been
+// written to).
+func (*File) readHole(dat []byte) int {
+	for i := range dat {
+		dat[i] = 0
+	}
+	return len(dat)
+}
+
+// readBlock reads the block at `blockBeg` in to `block`, and (unless
+// the subvolume has checksums disabled) verifies it against the csum
+// tree.  If the block is mirrored, the first mirror that matches the
+// checksum is used.
+func (file *File) readBlock(block *[btrfssum.BlockSize]byte, blockBeg btrfsvol.LogicalAddr) (int, error) {
+	if file.SV.noChecksums {
+		return file.SV.fs.ReadAt(block[:], blockBeg)
+	}
+	sb, err := file.SV.fs.Superblock()
+	if err != nil {
+		return 0, err
+	}
+	_expSum, err := file.SV.lookupCSum(sb.ChecksumType, blockBeg)
+	if err != nil {
+		return 0, fmt.Errorf("checksum@%v: %w", blockBeg, err)
+	}
+	expSum := _expSum.ToFullSum()
+
+	n, err := file.SV.fs.ReadAtVerified(file.SV.ctx, block[:], blockBeg, sb.ChecksumType, expSum)
+	if err != nil {
+		err := fmt.Errorf("checksum@%v: %w", blockBeg, err)
+		if !file.SV.lenientChecksums {
+			return 0, err
+		}
+		dlog.Errorf(file.SV.ctx, "subvol=%v inode=%v: %v (returning data anyway)",
+			file.SV.TreeID, file.Inode, err)
+		return file.SV.fs.ReadAt(block[:], blockBeg)
+	}
+	return n, nil
+}
+
+func (sv *Subvolume) loadCSumWindow(ctx context.Context, beg btrfsvol.LogicalAddr, val *csumWindow) {
+	*val = csumWindow{}
+	sb, err := sv.fs.Superblock()
+	if err != nil {
+		val.Err = err
+		return
+	}
+	val.Runs, val.Err = LookupCSums(ctx, sv.fs, sb.ChecksumType, beg, beg+csumWindowSize)
+}
+
+// lookupCSum is like LookupCSum, but consults the Subvolume's csum
+// cache, and returns just the csum for `laddr` rather than the whole
+// run containing it.
+func (sv *Subvolume) lookupCSum(alg btrfssum.CSumType, laddr btrfsvol.LogicalAddr) (btrfssum.ShortSum, error) {
+	beg := (laddr / csumWindowSize) * csumWindowSize
+	win := sv.csumCache.Acquire(sv.ctx, beg)
+	defer sv.csumCache.Release(beg)
+	for _, run := range win.Runs {
+		if sum, ok := run.SumForAddr(laddr); ok {
+			return sum, nil
+		}
+	}
+	if win.Err != nil {
+		return "", win.Err
+	}
+	return "", fmt.Errorf("%v: %w", btrfstree.SearchCSum(laddr, alg.Size()), btrfstree.ErrNoItem)
+}
+
+// readCompressed fills `dat` from the decompressed contents of
+// `extent`, starting at `offsetWithinExt`.
+//
+// For a regular extent, .DiskByteNr and .DiskNumBytes describe the
+// compressed data on disk (and that is what the checksums are of),
+// while .Offset and .NumBytes describe the part of the decompressed
+// data that is in the file.  For an inline extent, the compressed
+// data is the item body, and .RAMBytes is the decompressed size; an
+// inline extent can't be bigger than a sector.
+func (file *File) readCompressed(dat []byte, extent FileExtent, offsetWithinExt int64) (int, error) {
+	var decompressed []byte
+	switch extent.Type {
+	case btrfsitem.FILE_EXTENT_INLINE:
+		if extent.RAMBytes > btrfssum.BlockSize {
+			return 0, fmt.Errorf("inline extent at %v: invalid decompressed size %v (must be at most %v)",
+				extent.OffsetWithinFile, extent.RAMBytes, btrfssum.BlockSize)
+		}
+		var err error
+		decompressed, err = btrfscompress.Decompress(extent.Compression, extent.BodyInline, extent.RAMBytes)
+		if err != nil {
+			return 0, fmt.Errorf("inline extent at %v: %w", extent.OffsetWithinFile, err)
+		}
+	default:
+		var err error
+		decompressed, err = file.decompressExtent(extent)
+		if err != nil {
+			return 0, fmt.Errorf("extent@%v: %w", extent.BodyExtent.DiskByteNr, err)
+		}
+		offsetWithinExt += int64(extent.BodyExtent.Offset)
+	}
+	if offsetWithinExt+int64(len(dat)) > int64(len(decompressed)) {
+		return 0, fmt.Errorf("extent at %v: read of %v bytes at %v is past the end of the %v decompressed bytes",
+			extent.OffsetWithinFile, len(dat), offsetWithinExt, len(decompressed))
+	}
+	return copy(dat, decompressed[offsetWithinExt:]), nil
+}
+
+// decompressExtent reads and decompresses the on-disk data of a
+// regular compressed extent, consulting and updating the
+// single-extent cache in `file`.
+func (file *File) decompressExtent(extent FileExtent) ([]byte, error) {
+	file.decompressedMu.Lock()
+	defer file.decompressedMu.Unlock()
+	if file.decompressed != nil && file.decompressedAddr == extent.BodyExtent.DiskByteNr {
+		return file.decompressed, nil
+	}
+
+	// Check these before using them as allocation sizes.
+	if n := extent.BodyExtent.DiskNumBytes; n <= 0 || n > btrfscompress.MaxCompressedSize {
+		return nil, fmt.Errorf("invalid compressed size %v (must be between 1 and %v)",
+			n, btrfscompress.MaxCompressedSize)
+	}
+	if n := extent.RAMBytes; n <= 0 || n > btrfscompress.MaxUncompressedSize {
+		return nil, fmt.Errorf("invalid decompressed size %v (must be between 1 and %v)",
+			n, btrfscompress.MaxUncompressedSize)
+	}
+
+	compressed := make([]byte, 0, extent.BodyExtent.DiskNumBytes)
+	var block [btrfssum.BlockSize]byte
+	for blockBeg := extent.BodyExtent.DiskByteNr; blockBeg < extent.BodyExtent.DiskByteNr.Add(extent.BodyExtent.DiskNumBytes); blockBeg += btrfssum.BlockSize {
+		n, err := file.readBlock(&block, blockBeg)
+		if err != nil {
+			return nil, err
+		}
+		compressed = append(compressed, block[:n]...)
+	}
+	decompressed, err := btrfscompress.Decompress(extent.Compression, compressed, extent.RAMBytes)
+	if err != nil {
+		return nil, err
+	}
+	file.decompressedAddr = extent.BodyExtent.DiskByteNr
+	file.decompressed = decompressed
+	return decompressed, nil
+}
+
 var _ io.ReaderAt = (*File)(nil)
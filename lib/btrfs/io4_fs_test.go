@@ -0,0 +1,1153 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfs_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestFileReadPrealloc(t *testing.T) {
+	t.Parallel()
+	file := &btrfs.File{
+		FullInode: btrfs.FullInode{
+			BareInode: btrfs.BareInode{
+				InodeItem: &btrfsitem.Inode{Size: 6000},
+			},
+		},
+		Extents: []btrfs.FileExtent{
+			{
+				OffsetWithinFile: 0,
+				FileExtent: btrfsitem.FileExtent{
+					Type: btrfsitem.FILE_EXTENT_INLINE,
+					// A 4KiB inline extent isn't realistic,
+					// but it is convenient for the test.
+					BodyInline: []byte("hello"),
+				},
+			},
+			{
+				OffsetWithinFile: 5,
+				FileExtent: btrfsitem.FileExtent{
+					Type: btrfsitem.FILE_EXTENT_PREALLOC,
+					BodyExtent: btrfsitem.FileExtentExtent{
+						// Extends past the inode size,
+						// as with FALLOC_FL_KEEP_SIZE.
+						NumBytes: 8192,
+					},
+				},
+			},
+		},
+	}
+
+	dat := make([]byte, 8192)
+	for i := range dat {
+		dat[i] = 0xff
+	}
+	n, err := file.ReadAt(dat, 0)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 6000, n)
+	assert.Equal(t, []byte("hello"), dat[:5])
+	assert.Equal(t, make([]byte, 6000-5), dat[5:6000])
+}
+
+func TestFileExtentConflicts(t *testing.T) {
+	t.Parallel()
+	extent := func(off, size int64, gen btrfsprim.Generation) btrfs.FileExtent {
+		return btrfs.FileExtent{
+			OffsetWithinFile: off,
+			FileExtent: btrfsitem.FileExtent{
+				Generation: gen,
+				Type:       btrfsitem.FILE_EXTENT_REG,
+				BodyExtent: btrfsitem.FileExtentExtent{
+					NumBytes: size,
+				},
+			},
+		}
+	}
+	a := extent(0, 8192, 1)
+	b := extent(4096, 1024, 2)
+	c := extent(6144, 4096, 3)
+	d := extent(12288, 4096, 4)
+	file := &btrfs.File{
+		Extents: []btrfs.FileExtent{a, b, c, d},
+	}
+	conflicts := file.ExtentConflicts()
+	assert.Equal(t, []btrfs.ExtentConflict{
+		{A: a, B: b, Beg: 4096, End: 5120},
+		{A: a, B: c, Beg: 6144, End: 8192},
+	}, conflicts)
+	assert.EqualError(t, conflicts[1], "extent overlap from 6144 to 8192")
+
+	file.Extents = []btrfs.FileExtent{a, d}
+	assert.Empty(t, file.ExtentConflicts())
+}
+
+// manyExtentsFile returns a file made of `n` 4KiB inline extents,
+// each filled with its own index; every 7th extent is instead
+// preallocated, and every 13th is left out to make a gap.
+func manyExtentsFile(n int) *btrfs.File {
+	const extentSize = 4096
+	file := &btrfs.File{
+		FullInode: btrfs.FullInode{
+			BareInode: btrfs.BareInode{
+				InodeItem: &btrfsitem.Inode{Size: int64(n) * extentSize},
+			},
+		},
+	}
+	for i := 0; i < n; i++ {
+		extent := btrfs.FileExtent{
+			OffsetWithinFile: int64(i) * extentSize,
+		}
+		switch {
+		case i%13 == 12:
+			continue
+		case i%7 == 6:
+			extent.Type = btrfsitem.FILE_EXTENT_PREALLOC
+			extent.BodyExtent.NumBytes = extentSize
+		default:
+			extent.Type = btrfsitem.FILE_EXTENT_INLINE
+			extent.BodyInline = bytes.Repeat([]byte{byte(i)}, extentSize)
+		}
+		file.Extents = append(file.Extents, extent)
+	}
+	return file
+}
+
+func TestFileReadManyExtents(t *testing.T) {
+	t.Parallel()
+	file := manyExtentsFile(100)
+	// Add some overlapping extents too; the lookup should prefer
+	// whichever one begins first, which is what a linear scan of
+	// the sorted extents does.
+	file.Extents = append(file.Extents,
+		btrfs.FileExtent{
+			OffsetWithinFile: 2*4096 + 100,
+			FileExtent: btrfsitem.FileExtent{
+				Type:       btrfsitem.FILE_EXTENT_INLINE,
+				BodyInline: bytes.Repeat([]byte{0xff}, 4096),
+			},
+		},
+		btrfs.FileExtent{
+			OffsetWithinFile: 12*4096 - 100,
+			FileExtent: btrfsitem.FileExtent{
+				Type:       btrfsitem.FILE_EXTENT_INLINE,
+				BodyInline: bytes.Repeat([]byte{0xfe}, 200),
+			},
+		})
+	sort.Slice(file.Extents, func(i, j int) bool {
+		return file.Extents[i].OffsetWithinFile < file.Extents[j].OffsetWithinFile
+	})
+
+	// expByte does a linear scan of the extents, as
+	// File.ReadAt used to.
+	expByte := func(off int64) (byte, bool) {
+		for _, extent := range file.Extents {
+			size, err := extent.Size()
+			require.NoError(t, err)
+			if off < extent.OffsetWithinFile || off >= extent.OffsetWithinFile+size {
+				continue
+			}
+			if extent.Type == btrfsitem.FILE_EXTENT_PREALLOC {
+				return 0, true
+			}
+			return extent.BodyInline[off-extent.OffsetWithinFile], true
+		}
+		return 0, false
+	}
+
+	fileSize := file.InodeItem.Size
+	for off := int64(0); off < fileSize+1; off += 50 {
+		exp, expOK := expByte(off)
+		var act [1]byte
+		n, err := file.ReadAt(act[:], off)
+		switch {
+		case expOK:
+			if assert.NoError(t, err, off) {
+				assert.Equal(t, 1, n, off)
+				assert.Equal(t, exp, act[0], off)
+			}
+		case off >= fileSize:
+			assert.ErrorIs(t, err, io.EOF, off)
+		default:
+			assert.EqualError(t, err, fmt.Sprintf("read: could not map position %v", off))
+		}
+	}
+
+	// A read that spans several extents stops at the first gap
+	// (which the second overlapping extent reaches 100 bytes in
+	// to).
+	dat := make([]byte, fileSize)
+	n, err := file.ReadAt(dat, 0)
+	assert.EqualError(t, err, fmt.Sprintf("read: could not map position %v", 12*4096+100))
+	assert.Equal(t, 12*4096+100, n)
+	for off := int64(0); off < int64(n); off++ {
+		exp, _ := expByte(off)
+		if dat[off] != exp {
+			assert.Equal(t, exp, dat[off], off)
+			break
+		}
+	}
+}
+
+func BenchmarkFileReadAt(b *testing.B) {
+	for _, n := range []int{10, 100, 1000, 10000} {
+		n := n
+		b.Run(fmt.Sprintf("extents=%d", n), func(b *testing.B) {
+			file := manyExtentsFile(n)
+			var dat [4096]byte
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Read every block; the reads that hit a
+				// gap fail, but still have to look up the
+				// extent.
+				for off := int64(0); off < file.InodeItem.Size; off += int64(len(dat)) {
+					_, _ = file.ReadAt(dat[:], off)
+				}
+			}
+		})
+	}
+}
+
+// noTreesFS is a ReadableFS that has a working logical address space,
+// but no trees.
+type noTreesFS struct {
+	*btrfs.FS
+}
+
+func (noTreesFS) ForrestLookup(_ context.Context, treeID btrfsprim.ObjID) (btrfstree.Tree, error) {
+	return nil, fmt.Errorf("tree %v: %w", treeID, btrfstree.ErrNoTree)
+}
+
+func compressZlib(t *testing.T, dat []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(dat)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func compressZstd(t *testing.T, dat []byte) []byte {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer zw.Close()
+	return zw.EncodeAll(dat, nil)
+}
+
+func TestFileReadCompressed(t *testing.T) {
+	t.Parallel()
+	t.Run("zlib", func(t *testing.T) {
+		t.Parallel()
+		testFileReadCompressed(t, btrfsitem.COMPRESS_ZLIB, compressZlib)
+	})
+	t.Run("zstd", func(t *testing.T) {
+		t.Parallel()
+		testFileReadCompressed(t, btrfsitem.COMPRESS_ZSTD, compressZstd)
+	})
+}
+
+func testFileReadCompressed(t *testing.T, typ btrfsitem.CompressionType, compress func(*testing.T, []byte) []byte) {
+	ctx := dlog.NewTestContext(t, false)
+
+	// 3 blocks of data, of which the file only references the
+	// last 2 (as if the first block had been overwritten).
+	plain := make([]byte, 3*btrfssum.BlockSize)
+	for i := range plain {
+		plain[i] = byte(i / 7)
+	}
+	compressed := compress(t, plain)
+	require.Less(t, len(compressed), btrfssum.BlockSize)
+
+	dev := makeTestDevice(t, 2*1024*1024)
+	var fs btrfs.FS
+	require.NoError(t, fs.AddDevice(ctx, dev))
+	require.NoError(t, fs.LV.AddMapping(btrfsvol.Mapping{
+		LAddr: 1024 * 1024,
+		PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: 1024 * 1024},
+		Size:  1024 * 1024,
+	}))
+	const laddr = btrfsvol.LogicalAddr(1024 * 1024)
+	_, err := fs.WriteAt(compressed, laddr)
+	require.NoError(t, err)
+
+	file := &btrfs.File{
+		FullInode: btrfs.FullInode{
+			BareInode: btrfs.BareInode{
+				InodeItem: &btrfsitem.Inode{Size: 12 + 2*btrfssum.BlockSize},
+			},
+		},
+		Extents: []btrfs.FileExtent{
+			{
+				OffsetWithinFile: 0,
+				FileExtent: btrfsitem.FileExtent{
+					RAMBytes:    12,
+					Compression: typ,
+					Type:        btrfsitem.FILE_EXTENT_INLINE,
+					BodyInline:  compress(t, []byte("hello, world")),
+				},
+			},
+			{
+				OffsetWithinFile: 12,
+				FileExtent: btrfsitem.FileExtent{
+					RAMBytes:    int64(len(plain)),
+					Compression: typ,
+					Type:        btrfsitem.FILE_EXTENT_REG,
+					BodyExtent: btrfsitem.FileExtentExtent{
+						DiskByteNr:   laddr,
+						DiskNumBytes: btrfssum.BlockSize,
+						Offset:       btrfssum.BlockSize,
+						NumBytes:     2 * btrfssum.BlockSize,
+					},
+				},
+			},
+		},
+		SV: btrfs.NewSubvolume(ctx, noTreesFS{&fs}, btrfsprim.FS_TREE_OBJECTID, true, false, 0),
+	}
+
+	// A read that starts in the middle of the compressed extent.
+	dat := make([]byte, 5000)
+	n, err := file.ReadAt(dat, 12+100)
+	require.NoError(t, err)
+	assert.Equal(t, len(dat), n)
+	assert.Equal(t, plain[btrfssum.BlockSize+100:][:len(dat)], dat)
+
+	// The whole file.
+	dat = make([]byte, 12+2*btrfssum.BlockSize)
+	n, err = file.ReadAt(dat, 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(dat), n)
+	assert.Equal(t, []byte("hello, world"), dat[:12])
+	assert.Equal(t, plain[btrfssum.BlockSize:], dat[12:])
+}
+
+func TestFileReadCompressedBadSize(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+	var fs btrfs.FS
+	require.NoError(t, fs.AddDevice(ctx, makeTestDevice(t, 0)))
+	sv := btrfs.NewSubvolume(ctx, noTreesFS{&fs}, btrfsprim.FS_TREE_OBJECTID, true, false, 0)
+
+	reg := func(diskNumBytes btrfsvol.AddrDelta, ramBytes int64) btrfsitem.FileExtent {
+		return btrfsitem.FileExtent{
+			RAMBytes:    ramBytes,
+			Compression: btrfsitem.COMPRESS_ZLIB,
+			Type:        btrfsitem.FILE_EXTENT_REG,
+			BodyExtent: btrfsitem.FileExtentExtent{
+				DiskByteNr:   1024 * 1024,
+				DiskNumBytes: diskNumBytes,
+				NumBytes:     btrfssum.BlockSize,
+			},
+		}
+	}
+	type TestCase struct {
+		Extent btrfsitem.FileExtent
+		ExpErr string
+	}
+	testcases := map[string]TestCase{
+		"reg-ram-zero":  {Extent: reg(btrfssum.BlockSize, 0), ExpErr: "invalid decompressed size 0"},
+		"reg-ram-huge":  {Extent: reg(btrfssum.BlockSize, 1<<62), ExpErr: "invalid decompressed size"},
+		"reg-disk-zero": {Extent: reg(0, btrfssum.BlockSize), ExpErr: "invalid compressed size 0"},
+		"reg-disk-neg":  {Extent: reg(-btrfssum.BlockSize, btrfssum.BlockSize), ExpErr: "invalid compressed size"},
+		"reg-disk-huge": {Extent: reg(1<<62, btrfssum.BlockSize), ExpErr: "invalid compressed size"},
+		"inline-ram-huge": {
+			Extent: btrfsitem.FileExtent{
+				RAMBytes:    btrfssum.BlockSize + 1,
+				Compression: btrfsitem.COMPRESS_ZLIB,
+				Type:        btrfsitem.FILE_EXTENT_INLINE,
+				BodyInline:  compressZlib(t, []byte("hello, world")),
+			},
+			ExpErr: "invalid decompressed size 4097",
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			file := &btrfs.File{
+				FullInode: btrfs.FullInode{
+					BareInode: btrfs.BareInode{
+						InodeItem: &btrfsitem.Inode{Size: 12},
+					},
+				},
+				Extents: []btrfs.FileExtent{{FileExtent: tc.Extent}},
+				SV:      sv,
+			}
+			_, err := file.ReadAt(make([]byte, 12), 0)
+			assert.ErrorContains(t, err, tc.ExpErr)
+		})
+	}
+}
+
+func testInodeItem(inode btrfsprim.ObjID, mode btrfsitem.StatMode) btrfstree.Item {
+	return btrfstree.Item{
+		Key:  btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY},
+		Body: &btrfsitem.Inode{Mode: mode},
+	}
+}
+
+func testDotDot(inode, parent btrfsprim.ObjID) btrfstree.Item {
+	return btrfstree.Item{
+		Key: btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_REF_KEY, Offset: uint64(parent)},
+		Body: &btrfsitem.InodeRefs{Refs: []btrfsitem.InodeRef{{
+			Index: 2,
+			Name:  []byte(".."),
+		}}},
+	}
+}
+
+func testDirEntry(name string, typ btrfsitem.FileType, location btrfsprim.Key) btrfsitem.DirEntry {
+	return btrfsitem.DirEntry{
+		Location: location,
+		Type:     typ,
+		Name:     []byte(name),
+	}
+}
+
+func testInodeLoc(inode btrfsprim.ObjID) btrfsprim.Key {
+	return btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_ITEM_KEY}
+}
+
+// testDirItems returns both the DIR_ITEM and the DIR_INDEX item for
+// an entry.
+func testDirItems(dir btrfsprim.ObjID, index uint64, entry btrfsitem.DirEntry) []btrfstree.Item {
+	return []btrfstree.Item{
+		{
+			Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: btrfsitem.NameHash(entry.Name)},
+			Body: &entry,
+		},
+		{
+			Key:  btrfsprim.Key{ObjectID: dir, ItemType: btrfsitem.DIR_INDEX_KEY, Offset: index},
+			Body: &entry,
+		},
+	}
+}
+
+// newItemsSubvolume returns an FS_TREE subvolume containing the given
+// items (in any order), with a root directory inode of
+// FIRST_FREE_OBJECTID.
+func newItemsSubvolume(ctx context.Context, t *testing.T, items []btrfstree.Item) *btrfs.Subvolume {
+	t.Helper()
+	var fs btrfs.FS
+	require.NoError(t, fs.AddDevice(ctx, makeTestDevice(t, 0)))
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key.Compare(items[j].Key) < 0
+	})
+	rootTree := []btrfstree.Item{{
+		Key:  btrfsprim.Key{ObjectID: btrfsprim.FS_TREE_OBJECTID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+		Body: &btrfsitem.Root{RootDirID: btrfsprim.FIRST_FREE_OBJECTID},
+	}}
+	return btrfs.NewSubvolume(ctx, btrfstest.ItemsFS{
+		ReadableFS: &fs,
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.ROOT_TREE_OBJECTID: rootTree,
+			btrfsprim.FS_TREE_OBJECTID:   items,
+		},
+	}, btrfsprim.FS_TREE_OBJECTID, true, false, 0)
+}
+
+func TestSubvolumeReadDir(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	const (
+		rootDir  = btrfsprim.FIRST_FREE_OBJECTID
+		fileA    = rootDir + 1
+		fileB    = rootDir + 2
+		brokeDir = rootDir + 3
+		subvolID = btrfsprim.FIRST_FREE_OBJECTID
+	)
+
+	// The index order (b, a, sub) is different from both the
+	// name order and the name-hash order.
+	entB := testDirEntry("b", btrfsitem.FT_REG_FILE, testInodeLoc(fileB))
+	entA := testDirEntry("a", btrfsitem.FT_REG_FILE, testInodeLoc(fileA))
+	entSub := testDirEntry("sub", btrfsitem.FT_DIR, btrfsprim.Key{ObjectID: subvolID, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: math.MaxUint64})
+	entX := testDirEntry("x", btrfsitem.FT_REG_FILE, testInodeLoc(fileA))
+
+	items := []btrfstree.Item{
+		testInodeItem(rootDir, btrfsitem.ModeFmtDir|0o755),
+		testDotDot(rootDir, rootDir),
+		testInodeItem(fileA, btrfsitem.ModeFmtRegular|0o644),
+		testInodeItem(fileB, btrfsitem.ModeFmtRegular|0o644),
+		testInodeItem(brokeDir, btrfsitem.ModeFmtDir|0o755),
+		testDotDot(brokeDir, rootDir),
+		testDirItems(brokeDir, 2, entX)[0], // with no DIR_INDEX
+	}
+	items = append(items, testDirItems(rootDir, 2, entB)...)
+	items = append(items, testDirItems(rootDir, 3, entA)...)
+	items = append(items, testDirItems(rootDir, 4, entSub)...)
+	sv := newItemsSubvolume(ctx, t, items)
+
+	entries, err := sv.ReadDir(rootDir)
+	require.NoError(t, err)
+	assert.Equal(t, []btrfs.DirEntry{
+		{Index: 2, DirEntry: entB},
+		{Index: 3, DirEntry: entA},
+		{Index: 4, DirEntry: entSub},
+	}, entries)
+	tree, ok := entries[2].TargetTree()
+	assert.True(t, ok)
+	assert.Equal(t, subvolID, tree)
+
+	entries, err = sv.ReadDir(brokeDir)
+	assert.Equal(t, []btrfs.DirEntry{
+		{Index: 2, DirEntry: entX},
+	}, entries)
+	var errs derror.MultiError
+	require.ErrorAs(t, err, &errs)
+	assert.EqualError(t, errs, `missing by-index direntry for "x"`)
+
+	entries, err = sv.ReadDir(fileA + 100)
+	assert.Error(t, err)
+	assert.Nil(t, entries)
+}
+
+func TestSubvolumeWalk(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	const (
+		rootDir = btrfsprim.FIRST_FREE_OBJECTID + iota
+		dirA
+		dirB
+		fileC
+		fileD
+		missing = btrfsprim.FIRST_FREE_OBJECTID + 100
+	)
+	dir := func(name string, inode btrfsprim.ObjID) btrfsitem.DirEntry {
+		return testDirEntry(name, btrfsitem.FT_DIR, testInodeLoc(inode))
+	}
+	file := func(name string, inode btrfsprim.ObjID) btrfsitem.DirEntry {
+		return testDirEntry(name, btrfsitem.FT_REG_FILE, testInodeLoc(inode))
+	}
+
+	// /
+	// ├── a/
+	// │   ├── b/
+	// │   │   └── c
+	// │   └── loop/ -> /
+	// ├── d
+	// ├── missing
+	// ├── sub/ (another subvolume)
+	// └── d-link -> d
+	items := []btrfstree.Item{
+		testInodeItem(rootDir, btrfsitem.ModeFmtDir|0o755),
+		testDotDot(rootDir, rootDir),
+		testInodeItem(dirA, btrfsitem.ModeFmtDir|0o755),
+		testDotDot(dirA, rootDir),
+		testInodeItem(dirB, btrfsitem.ModeFmtDir|0o755),
+		testDotDot(dirB, dirA),
+		testInodeItem(fileC, btrfsitem.ModeFmtRegular|0o644),
+		testInodeItem(fileD, btrfsitem.ModeFmtRegular|0o644),
+	}
+	items = append(items, testDirItems(rootDir, 2, dir("a", dirA))...)
+	items = append(items, testDirItems(rootDir, 3, file("d", fileD))...)
+	items = append(items, testDirItems(rootDir, 4, file("missing", missing))...)
+	items = append(items, testDirItems(rootDir, 5, testDirEntry("sub", btrfsitem.FT_DIR,
+		btrfsprim.Key{ObjectID: 300, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: math.MaxUint64}))...)
+	items = append(items, testDirItems(rootDir, 6, file("d-link", fileD))...)
+	items = append(items, testDirItems(dirA, 2, dir("b", dirB))...)
+	items = append(items, testDirItems(dirA, 3, dir("loop", rootDir))...)
+	items = append(items, testDirItems(dirB, 2, file("c", fileC))...)
+	sv := newItemsSubvolume(ctx, t, items)
+
+	type visit struct {
+		Path  string
+		Inode btrfsprim.ObjID
+		Mode  btrfsitem.StatMode
+	}
+	var visits []visit
+	err := sv.Walk(ctx, func(path string, inode btrfsprim.ObjID, item *btrfs.FullInode) error {
+		visits = append(visits, visit{
+			Path:  path,
+			Inode: inode,
+			Mode:  item.InodeItem.Mode,
+		})
+		return nil
+	})
+	assert.Equal(t, []visit{
+		{"/", rootDir, btrfsitem.ModeFmtDir | 0o755},
+		{"/a", dirA, btrfsitem.ModeFmtDir | 0o755},
+		{"/a/b", dirB, btrfsitem.ModeFmtDir | 0o755},
+		{"/a/b/c", fileC, btrfsitem.ModeFmtRegular | 0o644},
+		{"/d", fileD, btrfsitem.ModeFmtRegular | 0o644},
+		{"/d-link", fileD, btrfsitem.ModeFmtRegular | 0o644},
+	}, visits)
+	var errs derror.MultiError
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 2)
+	assert.EqualError(t, errs[0], "/a/loop: directory inode 256 has already been walked")
+	assert.ErrorContains(t, errs[1], "/missing: ")
+
+	// An error from fn stops the walk.
+	visits = nil
+	stop := errors.New("stop")
+	err = sv.Walk(ctx, func(path string, inode btrfsprim.ObjID, _ *btrfs.FullInode) error {
+		visits = append(visits, visit{Path: path, Inode: inode})
+		if path == "/a/b" {
+			return stop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, []visit{
+		{Path: "/", Inode: rootDir},
+		{Path: "/a", Inode: dirA},
+		{Path: "/a/b", Inode: dirB},
+	}, visits)
+}
+
+func TestSubvolumeInodePaths(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	const (
+		rootDir = btrfsprim.FIRST_FREE_OBJECTID + iota
+		dirA
+		fileLinked
+		fileOrphan
+		fileNoRefs
+		fileOddItem
+		missing = btrfsprim.FIRST_FREE_OBJECTID + 100
+	)
+	file := func(name string, inode btrfsprim.ObjID) btrfsitem.DirEntry {
+		return testDirEntry(name, btrfsitem.FT_REG_FILE, testInodeLoc(inode))
+	}
+	inodeRef := func(inode, parent btrfsprim.ObjID, refs ...btrfsitem.InodeRef) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_REF_KEY, Offset: uint64(parent)},
+			Body: &btrfsitem.InodeRefs{Refs: refs},
+		}
+	}
+	inodeExtRef := func(inode btrfsprim.ObjID, hash uint64, refs ...btrfsitem.InodeExtRef) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: inode, ItemType: btrfsitem.INODE_EXTREF_KEY, Offset: hash},
+			Body: &btrfsitem.InodeExtRefs{Refs: refs},
+		}
+	}
+
+	// /
+	// ├── a/
+	// │   ├── v -> x (by INODE_EXTREF)
+	// │   ├── y -> x
+	// │   └── z -> x
+	// ├── x
+	// └── o (also linked from a missing directory)
+	items := []btrfstree.Item{
+		testInodeItem(rootDir, btrfsitem.ModeFmtDir|0o755),
+		testDotDot(rootDir, rootDir),
+		testInodeItem(dirA, btrfsitem.ModeFmtDir|0o755),
+		inodeRef(dirA, rootDir,
+			btrfsitem.InodeRef{Index: 3, Name: []byte("a")}),
+		testInodeItem(fileLinked, btrfsitem.ModeFmtRegular|0o644),
+		inodeRef(fileLinked, rootDir,
+			btrfsitem.InodeRef{Index: 4, Name: []byte("x")}),
+		inodeRef(fileLinked, dirA,
+			btrfsitem.InodeRef{Index: 2, Name: []byte("y")},
+			btrfsitem.InodeRef{Index: 3, Name: []byte("z")}),
+		inodeExtRef(fileLinked, 0x1234,
+			btrfsitem.InodeExtRef{Parent: dirA, Index: 4, Name: []byte("v")}),
+		testInodeItem(fileOrphan, btrfsitem.ModeFmtRegular|0o644),
+		inodeRef(fileOrphan, rootDir,
+			btrfsitem.InodeRef{Index: 5, Name: []byte("o")}),
+		inodeRef(fileOrphan, missing,
+			btrfsitem.InodeRef{Index: 2, Name: []byte("w")}),
+		testInodeItem(fileNoRefs, btrfsitem.ModeFmtRegular|0o644),
+		testInodeItem(fileOddItem, btrfsitem.ModeFmtRegular|0o644),
+		inodeRef(fileOddItem, rootDir,
+			btrfsitem.InodeRef{Index: 6, Name: []byte("d")}),
+		{
+			Key:  btrfsprim.Key{ObjectID: fileOddItem, ItemType: btrfsitem.DIR_ITEM_KEY, Offset: 0},
+			Body: &btrfsitem.Error{Err: fmt.Errorf("a file shouldn't have this")},
+		},
+	}
+	items = append(items, testDirItems(rootDir, 3, testDirEntry("a", btrfsitem.FT_DIR, testInodeLoc(dirA)))...)
+	items = append(items, testDirItems(rootDir, 4, file("x", fileLinked))...)
+	items = append(items, testDirItems(rootDir, 5, file("o", fileOrphan))...)
+	items = append(items, testDirItems(dirA, 2, file("y", fileLinked))...)
+	items = append(items, testDirItems(dirA, 3, file("z", fileLinked))...)
+	items = append(items, testDirItems(dirA, 4, file("v", fileLinked))...)
+	items = append(items, testDirItems(rootDir, 6, file("d", fileOddItem))...)
+	sv := newItemsSubvolume(ctx, t, items)
+
+	paths, err := sv.InodePaths(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/"}, paths)
+
+	paths, err = sv.InodePaths(dirA)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/a"}, paths)
+
+	paths, err = sv.InodePaths(fileLinked)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/x", "/a/y", "/a/z", "/a/v"}, paths)
+
+	paths, err = sv.InodePaths(fileOrphan)
+	assert.Equal(t, []string{"/o"}, paths)
+	var errs derror.MultiError
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], `"w" in dir inode 356: `)
+
+	paths, err = sv.InodePaths(fileNoRefs)
+	assert.EqualError(t, err, "no INODE_REF")
+	assert.Empty(t, paths)
+
+	paths, err = sv.InodePaths(missing)
+	assert.Error(t, err)
+	assert.Empty(t, paths)
+
+	// The same back-references are available on the File.
+	f, err := sv.AcquireFile(fileLinked)
+	require.NoError(t, err)
+	defer sv.ReleaseFile(fileLinked)
+	assert.Equal(t, []btrfs.InodeRef{
+		{Inode: rootDir, InodeRef: btrfsitem.InodeRef{Index: 4, Name: []byte("x")}},
+		{Inode: dirA, InodeRef: btrfsitem.InodeRef{Index: 2, Name: []byte("y")}},
+		{Inode: dirA, InodeRef: btrfsitem.InodeRef{Index: 3, Name: []byte("z")}},
+		{Inode: dirA, InodeRef: btrfsitem.InodeRef{Index: 4, Name: []byte("v")}},
+	}, f.Refs)
+
+	// An item that doesn't belong on a file is reported, not a
+	// panic.
+	f, err = sv.AcquireFile(fileOddItem)
+	require.NoError(t, err)
+	defer sv.ReleaseFile(fileOddItem)
+	assert.Equal(t, []btrfs.InodeRef{
+		{Inode: rootDir, InodeRef: btrfsitem.InodeRef{Index: 6, Name: []byte("d")}},
+	}, f.Refs)
+	assert.ErrorContains(t, f.Errs, "unexpected item type DIR_ITEM")
+}
+
+// noHolesFS is an ItemsFS whose superblock has the NO_HOLES feature.
+type noHolesFS struct {
+	btrfstest.ItemsFS
+}
+
+func (fs noHolesFS) Superblock() (*btrfstree.Superblock, error) {
+	sb, err := fs.ItemsFS.Superblock()
+	if err != nil {
+		return nil, err
+	}
+	ret := *sb
+	ret.IncompatFlags |= btrfstree.FeatureIncompatNoHoles
+	return &ret, nil
+}
+
+func TestFileReadSparse(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	// A file with "hello" at the beginning, "world" at 4KiB, and a
+	// hole in between.
+	inline := func(off uint64, dat string) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: off},
+			Body: &btrfsitem.FileExtent{
+				Type:       btrfsitem.FILE_EXTENT_INLINE,
+				BodyInline: []byte(dat),
+			},
+		}
+	}
+	hole := btrfstree.Item{
+		Key: btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 5},
+		Body: &btrfsitem.FileExtent{
+			Type: btrfsitem.FILE_EXTENT_REG,
+			BodyExtent: btrfsitem.FileExtentExtent{
+				DiskByteNr: 0,
+				NumBytes:   4096 - 5,
+			},
+		},
+	}
+	const size = 4096 + 5
+
+	type TestCase struct {
+		NoHoles  bool
+		WithHole bool
+		ExpN     int
+		ExpErr   string
+	}
+	testcases := map[string]TestCase{
+		"explicit-hole":        {WithHole: true, ExpN: size},
+		"no-holes":             {NoHoles: true, ExpN: size},
+		"no-holes-with-hole":   {NoHoles: true, WithHole: true, ExpN: size},
+		"gap-without-no-holes": {ExpN: 5, ExpErr: "read: could not map position 5"},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			inodeItem := testInodeItem(257, btrfsitem.ModeFmtRegular|0o644)
+			inodeItem.Body.(*btrfsitem.Inode).Size = size
+			items := []btrfstree.Item{
+				inodeItem,
+				inline(0, "hello"),
+				inline(4096, "world"),
+			}
+			if tc.WithHole {
+				items = append(items, hole)
+			}
+			sort.Slice(items, func(i, j int) bool {
+				return items[i].Key.Compare(items[j].Key) < 0
+			})
+
+			var fs btrfs.FS
+			require.NoError(t, fs.AddDevice(ctx, makeTestDevice(t, 0)))
+			ifs := btrfstest.ItemsFS{
+				ReadableFS: &fs,
+				Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+					btrfsprim.ROOT_TREE_OBJECTID: {{
+						Key:  btrfsprim.Key{ObjectID: btrfsprim.FS_TREE_OBJECTID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+						Body: &btrfsitem.Root{RootDirID: btrfsprim.FIRST_FREE_OBJECTID},
+					}},
+					btrfsprim.FS_TREE_OBJECTID: items,
+				},
+			}
+			var rfs btrfs.ReadableFS = ifs
+			if tc.NoHoles {
+				rfs = noHolesFS{ifs}
+			}
+			sv := btrfs.NewSubvolume(ctx, rfs, btrfsprim.FS_TREE_OBJECTID, true, false, 0)
+
+			file, err := sv.AcquireFile(257)
+			require.NoError(t, err)
+			defer sv.ReleaseFile(257)
+
+			dat := make([]byte, size+1)
+			for i := range dat {
+				dat[i] = 0xff
+			}
+			n, err := file.ReadAt(dat, 0)
+			assert.Equal(t, tc.ExpN, n)
+			if tc.ExpErr != "" {
+				assert.EqualError(t, err, tc.ExpErr)
+				return
+			}
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Equal(t, []byte("hello"), dat[:5])
+			assert.Equal(t, make([]byte, 4096-5), dat[5:4096])
+			assert.Equal(t, []byte("world"), dat[4096:size])
+		})
+	}
+}
+
+// csumTreeFS is an ItemsFS whose csum tree is made of actual nodes,
+// and which counts how many times the csum tree is searched.
+type csumTreeFS struct {
+	btrfstest.ItemsFS
+	csumRoot btrfstree.TreeRoot
+	searches *atomic.Int64
+}
+
+func (fs csumTreeFS) ForrestLookup(ctx context.Context, treeID btrfsprim.ObjID) (btrfstree.Tree, error) {
+	if treeID != btrfsprim.CSUM_TREE_OBJECTID {
+		return fs.ItemsFS.ForrestLookup(ctx, treeID)
+	}
+	return countingTree{
+		Tree: &btrfstree.RawTree{
+			Forrest:  btrfstree.RawForrest{NodeSource: fs.ReadableFS},
+			TreeRoot: fs.csumRoot,
+		},
+		searches: fs.searches,
+	}, nil
+}
+
+type countingTree struct {
+	btrfstree.Tree
+	searches *atomic.Int64
+}
+
+func (tree countingTree) TreeSearch(ctx context.Context, search btrfstree.TreeSearcher) (btrfstree.Item, error) {
+	tree.searches.Add(1)
+	return tree.Tree.TreeSearch(ctx, search)
+}
+
+func (tree countingTree) TreeSubrange(ctx context.Context, min int, search btrfstree.TreeSearcher, handleFn func(btrfstree.Item) bool) error {
+	tree.searches.Add(1)
+	return tree.Tree.TreeSubrange(ctx, min, search, handleFn)
+}
+
+// newCSumFileSubvolume returns a subvolume containing a file (inode
+// 257) with `size` bytes of data in a single extent, the file's
+// data, and a counter of searches of the csum tree.  The data's
+// checksums are split across many EXTENT_CSUM items; the checksum for
+// the block at index `badBlock` (if non-negative) is wrong.
+func newCSumFileSubvolume(ctx context.Context, tb testing.TB, size int64, badBlock int, lenient bool) (*btrfs.Subvolume, []byte, *atomic.Int64) {
+	tb.Helper()
+	const (
+		metadataAddr = btrfsvol.LogicalAddr(256 * 1024)
+		dataAddr     = btrfsvol.LogicalAddr(1024 * 1024)
+	)
+
+	dat := make([]byte, size)
+	for i := range dat {
+		dat[i] = byte(i / 13)
+	}
+
+	var fs btrfs.FS
+	require.NoError(tb, fs.AddDevice(ctx, makeTestDevice(tb, btrfsvol.PhysicalAddr(dataAddr)+btrfsvol.PhysicalAddr(size))))
+	for _, mapping := range []btrfsvol.Mapping{
+		{
+			LAddr: metadataAddr,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: btrfsvol.PhysicalAddr(metadataAddr)},
+			Size:  dataAddr.Sub(metadataAddr),
+		},
+		{
+			LAddr: dataAddr,
+			PAddr: btrfsvol.QualifiedPhysicalAddr{Dev: 1, Addr: btrfsvol.PhysicalAddr(dataAddr)},
+			Size:  btrfsvol.AddrDelta(size),
+		},
+	} {
+		require.NoError(tb, fs.LV.AddMapping(mapping))
+	}
+	_, err := fs.WriteAt(dat, dataAddr)
+	require.NoError(tb, err)
+
+	// 32 blocks (128KiB) per EXTENT_CSUM item.
+	const blocksPerItem = 32
+	var csumItems []btrfstree.Item
+	for runBeg := int64(0); runBeg < size; runBeg += blocksPerItem * btrfssum.BlockSize {
+		var sums []byte
+		for blockBeg := runBeg; blockBeg < size && blockBeg < runBeg+blocksPerItem*btrfssum.BlockSize; blockBeg += btrfssum.BlockSize {
+			sum, err := btrfssum.TYPE_CRC32.Sum(dat[blockBeg : blockBeg+btrfssum.BlockSize])
+			require.NoError(tb, err)
+			if blockBeg == int64(badBlock)*btrfssum.BlockSize {
+				sum[0] ^= 0xff
+			}
+			sums = append(sums, sum[:btrfssum.TYPE_CRC32.Size()]...)
+		}
+		csumItems = append(csumItems, btrfstree.Item{
+			Key: btrfsprim.Key{
+				ObjectID: btrfsprim.EXTENT_CSUM_OBJECTID,
+				ItemType: btrfsitem.EXTENT_CSUM_KEY,
+				Offset:   uint64(dataAddr.Add(btrfsvol.AddrDelta(runBeg))),
+			},
+			Body: &btrfsitem.ExtentCSum{SumRun: btrfssum.SumRun[btrfsvol.LogicalAddr]{
+				ChecksumSize: btrfssum.TYPE_CRC32.Size(),
+				Addr:         dataAddr.Add(btrfsvol.AddrDelta(runBeg)),
+				Sums:         btrfssum.ShortSum(sums),
+			}},
+		})
+	}
+	sb, err := fs.Superblock()
+	require.NoError(tb, err)
+	nextNode := metadataAddr
+	nodes, err := btrfstree.BuildTree(*sb, btrfstree.NodeHeader{
+		Flags:      btrfstree.NodeWritten,
+		BackrefRev: btrfstree.MixedBackrefRev,
+		Generation: 1,
+		Owner:      btrfsprim.CSUM_TREE_OBJECTID,
+	}, csumItems, func() (btrfsvol.LogicalAddr, error) {
+		addr := nextNode
+		nextNode += testNodeSize
+		return addr, nil
+	})
+	require.NoError(tb, err)
+	for _, node := range nodes {
+		nodeDat, err := node.MarshalBinary()
+		require.NoError(tb, err)
+		_, err = fs.WriteAt(nodeDat, node.Head.Addr)
+		require.NoError(tb, err)
+	}
+	root := nodes[len(nodes)-1]
+
+	inodeItem := testInodeItem(257, btrfsitem.ModeFmtRegular|0o644)
+	inodeItem.Body.(*btrfsitem.Inode).Size = size
+	searches := new(atomic.Int64)
+	return btrfs.NewSubvolume(ctx, csumTreeFS{
+		ItemsFS: btrfstest.ItemsFS{
+			ReadableFS: &fs,
+			Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+				btrfsprim.ROOT_TREE_OBJECTID: {{
+					Key:  btrfsprim.Key{ObjectID: btrfsprim.FS_TREE_OBJECTID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+					Body: &btrfsitem.Root{RootDirID: btrfsprim.FIRST_FREE_OBJECTID},
+				}},
+				btrfsprim.FS_TREE_OBJECTID: {
+					inodeItem,
+					{
+						Key: btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 0},
+						Body: &btrfsitem.FileExtent{
+							Type: btrfsitem.FILE_EXTENT_REG,
+							BodyExtent: btrfsitem.FileExtentExtent{
+								DiskByteNr:   dataAddr,
+								DiskNumBytes: btrfsvol.AddrDelta(size),
+								NumBytes:     size,
+							},
+						},
+					},
+				},
+			},
+		},
+		csumRoot: btrfstree.TreeRoot{
+			ID:         btrfsprim.CSUM_TREE_OBJECTID,
+			RootNode:   root.Head.Addr,
+			Level:      root.Head.Level,
+			Generation: 1,
+		},
+		searches: searches,
+	}, btrfsprim.FS_TREE_OBJECTID, false, lenient, 0), dat, searches
+}
+
+func TestFileReadChecksums(t *testing.T) {
+	t.Parallel()
+	const size = 6 * 1024 * 1024
+	type TestCase struct {
+		BadBlock int
+		Lenient  bool
+		ExpN     int
+		ExpErr   string
+	}
+	testcases := map[string]TestCase{
+		"good":    {BadBlock: -1, ExpN: size},
+		"bad":     {BadBlock: 1100, ExpN: 1100 * btrfssum.BlockSize, ExpErr: "checksum@0x000000000054c000: "},
+		"lenient": {BadBlock: 1100, Lenient: true, ExpN: size},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			ctx := dlog.NewTestContext(t, false)
+			sv, exp, searches := newCSumFileSubvolume(ctx, t, size, tc.BadBlock, tc.Lenient)
+			file, err := sv.AcquireFile(257)
+			require.NoError(t, err)
+			defer sv.ReleaseFile(257)
+
+			dat := make([]byte, size)
+			n, err := file.ReadAt(dat, 0)
+			assert.Equal(t, tc.ExpN, n)
+			if tc.ExpErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.ExpErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(exp, dat))
+			// The file spans 2 of the csum cache's 4MiB
+			// windows.
+			assert.Equal(t, int64(2), searches.Load())
+		})
+	}
+}
+
+func BenchmarkFileReadChecksummed(b *testing.B) {
+	const size = 16 * 1024 * 1024
+	ctx := dlog.NewTestContext(b, false)
+	sv, _, _ := newCSumFileSubvolume(ctx, b, size, -1, false)
+	file, err := sv.AcquireFile(257)
+	require.NoError(b, err)
+	defer sv.ReleaseFile(257)
+
+	dat := make([]byte, 1024*1024)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for off := int64(0); off < size; off += int64(len(dat)) {
+			if _, err := file.ReadAt(dat, off); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSubvolumeConcurrentAcquire(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	const (
+		rootDir = btrfsprim.FIRST_FREE_OBJECTID + iota
+		fileA
+	)
+	items := []btrfstree.Item{
+		testInodeItem(rootDir, btrfsitem.ModeFmtDir|0o755),
+		testDotDot(rootDir, rootDir),
+		testInodeItem(fileA, btrfsitem.ModeFmtRegular|0o644),
+		{
+			Key: btrfsprim.Key{ObjectID: fileA, ItemType: btrfsitem.INODE_REF_KEY, Offset: uint64(rootDir)},
+			Body: &btrfsitem.InodeRefs{Refs: []btrfsitem.InodeRef{{
+				Index: 2,
+				Name:  []byte("a"),
+			}}},
+		},
+		{
+			Key: btrfsprim.Key{ObjectID: fileA, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 0},
+			Body: &btrfsitem.FileExtent{
+				Type:       btrfsitem.FILE_EXTENT_INLINE,
+				RAMBytes:   5,
+				BodyInline: []byte("hello"),
+			},
+		},
+	}
+	items = append(items, testDirItems(rootDir, 2, testDirEntry("a", btrfsitem.FT_REG_FILE, testInodeLoc(fileA)))...)
+	sv := newItemsSubvolume(ctx, t, items)
+
+	// Meant to be run with `-race`; every goroutine acquires and
+	// releases the same handful of cache entries at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				bare, err := sv.AcquireBareInode(fileA)
+				if assert.NoError(t, err) {
+					assert.Equal(t, fileA, bare.Inode)
+				}
+				sv.ReleaseBareInode(fileA)
+
+				full, err := sv.AcquireFullInode(fileA)
+				if assert.NoError(t, err) {
+					assert.Len(t, full.OtherItems, 2)
+				}
+				sv.ReleaseFullInode(fileA)
+
+				dir, err := sv.AcquireDir(rootDir)
+				if assert.NoError(t, err) {
+					assert.Len(t, dir.ChildrenByIndex, 1)
+				}
+				sv.ReleaseDir(rootDir)
+
+				file, err := sv.AcquireFile(fileA)
+				if assert.NoError(t, err) {
+					buf := make([]byte, 5)
+					n, err := file.ReadAt(buf, 0)
+					assert.NoError(t, err)
+					assert.Equal(t, "hello", string(buf[:n]))
+				}
+				sv.ReleaseFile(fileA)
+
+				paths, err := sv.InodePaths(fileA)
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"/a"}, paths)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,258 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/datawire/dlib/derror"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+// SubvolumeInfo describes a subvolume, as seen from the root tree.
+type SubvolumeInfo struct {
+	TreeID btrfsprim.ObjID
+
+	// Name is the name of the directory entry that the subvolume
+	// is linked in to its parent as, and ParentID is the tree ID
+	// of that parent subvolume.  Both are zero for the top-level
+	// (FS_TREE) subvolume, and for subvolumes that aren't linked
+	// anywhere (such as ones that are in the process of being
+	// deleted).
+	Name     string
+	ParentID btrfsprim.ObjID
+
+	UUID         btrfsprim.UUID
+	ParentUUID   btrfsprim.UUID // if this is a snapshot, the UUID of the subvolume that it is a snapshot of
+	ReceivedUUID btrfsprim.UUID // if this was created by 'btrfs receive'
+}
+
+// ListSubvolumes returns all of the subvolumes in the filesystem
+// (including the top-level FS_TREE subvolume), ordered by tree ID.
+func (fs *FS) ListSubvolumes(ctx context.Context) ([]SubvolumeInfo, error) {
+	return ListSubvolumes(ctx, fs)
+}
+
+// ListSubvolumes returns all of the subvolumes in the filesystem
+// (including the top-level FS_TREE subvolume), ordered by tree ID.
+//
+// Subvolumes are found by their ROOT_ITEMs, and named by their
+// ROOT_BACKREFs (falling back to their parent's ROOT_REFs).  Items
+// that fail to parse are reported in the returned error, but do not
+// prevent the remaining subvolumes from being returned.
+func ListSubvolumes(ctx context.Context, fs btrfstree.Forrest) ([]SubvolumeInfo, error) {
+	rootTree, err := fs.ForrestLookup(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []SubvolumeInfo
+	idx := make(map[btrfsprim.ObjID]int)
+	backrefs := make(map[btrfsprim.ObjID]subvolumeLink) // from ROOT_BACKREF items
+	refs := make(map[btrfsprim.ObjID]subvolumeLink)     // from ROOT_REF items
+	var errs derror.MultiError
+
+	isSubvolume := func(id btrfsprim.ObjID) bool {
+		return id == btrfsprim.FS_TREE_OBJECTID ||
+			(id >= btrfsprim.FIRST_FREE_OBJECTID && id <= btrfsprim.LAST_FREE_OBJECTID)
+	}
+
+	if err := rootTree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		switch item.Key.ItemType {
+		case btrfsitem.ROOT_ITEM_KEY:
+			if !isSubvolume(item.Key.ObjectID) {
+				return true
+			}
+			switch body := item.Body.(type) {
+			case *btrfsitem.Root:
+				if _, ok := idx[item.Key.ObjectID]; ok {
+					return true
+				}
+				idx[item.Key.ObjectID] = len(ret)
+				ret = append(ret, SubvolumeInfo{
+					TreeID:       item.Key.ObjectID,
+					UUID:         body.UUID,
+					ParentUUID:   body.ParentUUID,
+					ReceivedUUID: body.ReceivedUUID,
+				})
+			case *btrfsitem.Error:
+				errs = append(errs, fmt.Errorf("ROOT_ITEM %v: %w", item.Key, body.Err))
+			default:
+				panic(fmt.Errorf("should not happen: ROOT_ITEM item has unexpected type: %T", body))
+			}
+		case btrfsitem.ROOT_BACKREF_KEY, btrfsitem.ROOT_REF_KEY:
+			childID, l, err := parseRootRef(item)
+			if err != nil {
+				errs = append(errs, err)
+				return true
+			}
+			if item.Key.ItemType == btrfsitem.ROOT_BACKREF_KEY {
+				backrefs[childID] = l
+			} else {
+				refs[childID] = l
+			}
+		}
+		return true
+	}); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i := range ret {
+		l, ok := backrefs[ret[i].TreeID]
+		if !ok {
+			l = refs[ret[i].TreeID]
+		}
+		ret[i].Name = l.Name
+		ret[i].ParentID = l.ParentID
+	}
+
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
+
+// subvolumeLink is where a subvolume is linked in to its parent
+// subvolume.
+type subvolumeLink struct {
+	Name     string
+	ParentID btrfsprim.ObjID
+}
+
+// parseRootRef returns the child subvolume ID and the link described
+// by a ROOT_REF or ROOT_BACKREF item.
+func parseRootRef(item btrfstree.Item) (btrfsprim.ObjID, subvolumeLink, error) {
+	switch body := item.Body.(type) {
+	case *btrfsitem.RootRef:
+		if item.Key.ItemType == btrfsitem.ROOT_BACKREF_KEY {
+			// key.objectid = child, key.offset = parent
+			return item.Key.ObjectID, subvolumeLink{
+				Name:     string(body.Name),
+				ParentID: btrfsprim.ObjID(item.Key.Offset),
+			}, nil
+		}
+		// key.objectid = parent, key.offset = child
+		return btrfsprim.ObjID(item.Key.Offset), subvolumeLink{
+			Name:     string(body.Name),
+			ParentID: item.Key.ObjectID,
+		}, nil
+	case *btrfsitem.Error:
+		return 0, subvolumeLink{}, fmt.Errorf("%v %v: %w", item.Key.ItemType, item.Key, body.Err)
+	default:
+		panic(fmt.Errorf("should not happen: %v item has unexpected type: %T", item.Key.ItemType, body))
+	}
+}
+
+// LookupSubvolumeName returns the name that the subvolume with tree
+// ID `treeID` is linked in to its parent subvolume as, and the tree
+// ID of that parent.
+//
+// This is found from the subvolume's ROOT_BACKREF item, falling back
+// to scanning for its parent's ROOT_REF item if the ROOT_BACKREF is
+// missing or damaged; which doesn't rely on
+// the parent subvolume's directories being intact, the way that
+// following DIR_ITEMs would.  If the subvolume isn't linked anywhere
+// (such as the top-level FS_TREE subvolume), then the returned error
+// wraps btrfstree.ErrNoItem.
+func LookupSubvolumeName(ctx context.Context, fs btrfstree.Forrest, treeID btrfsprim.ObjID) (name string, parentID btrfsprim.ObjID, err error) {
+	rootTree, err := fs.ForrestLookup(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	item, err := rootTree.TreeSearch(ctx, btrfstree.SearchObjectIDType(treeID, btrfsitem.ROOT_BACKREF_KEY))
+	var errs derror.MultiError
+	switch {
+	case err == nil:
+		_, l, err := parseRootRef(item)
+		if err == nil {
+			return l.Name, l.ParentID, nil
+		}
+		errs = append(errs, err)
+	case !errors.Is(err, btrfstree.ErrNoItem):
+		errs = append(errs, err)
+	}
+
+	// No (usable) ROOT_BACKREF; look for a ROOT_REF.  These are
+	// keyed by the parent's ID, so we have to scan for it.
+	var l subvolumeLink
+	var found bool
+	if err := rootTree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType != btrfsitem.ROOT_REF_KEY || btrfsprim.ObjID(item.Key.Offset) != treeID {
+			return true
+		}
+		_, l, err = parseRootRef(item)
+		if err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		found = true
+		return false
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	if found {
+		return l.Name, l.ParentID, nil
+	}
+	if len(errs) > 0 {
+		return "", 0, fmt.Errorf("subvolume %v: %w", treeID, errs)
+	}
+	return "", 0, fmt.Errorf("subvolume %v: no ROOT_BACKREF or ROOT_REF: %w", treeID, btrfstree.ErrNoItem)
+}
+
+// DefaultSubvolume returns the tree ID of the filesystem's default
+// subvolume (as set by `btrfs subvolume set-default`), which is the
+// subvolume that the kernel mounts if not told otherwise.
+func (fs *FS) DefaultSubvolume(ctx context.Context) (btrfsprim.ObjID, error) {
+	return DefaultSubvolume(ctx, fs)
+}
+
+// DefaultSubvolume returns the tree ID of the filesystem's default
+// subvolume (as set by `btrfs subvolume set-default`), which is the
+// subvolume that the kernel mounts if not told otherwise.
+//
+// The default is stored in the root tree as a directory entry named
+// "default" in the ROOT_TREE_DIR_OBJECTID directory.  If there is no
+// such entry, then the default is the top-level FS_TREE subvolume.
+func DefaultSubvolume(ctx context.Context, fs btrfstree.Forrest) (btrfsprim.ObjID, error) {
+	rootTree, err := fs.ForrestLookup(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	if err != nil {
+		return 0, err
+	}
+	name := []byte("default")
+	item, err := rootTree.TreeLookup(ctx, btrfsprim.Key{
+		ObjectID: btrfsprim.ROOT_TREE_DIR_OBJECTID,
+		ItemType: btrfsitem.DIR_ITEM_KEY,
+		Offset:   btrfsitem.NameHash(name),
+	})
+	if err != nil {
+		if errors.Is(err, btrfstree.ErrNoItem) {
+			return btrfsprim.FS_TREE_OBJECTID, nil
+		}
+		return 0, fmt.Errorf("default subvolume: %w", err)
+	}
+	switch body := item.Body.(type) {
+	case *btrfsitem.DirEntry:
+		if string(body.Name) != string(name) {
+			return 0, fmt.Errorf("default subvolume: DIR_ITEM %v: name is %q, not %q",
+				item.Key, body.Name, name)
+		}
+		treeID, ok := body.TargetTree()
+		if !ok {
+			return 0, fmt.Errorf("default subvolume: DIR_ITEM %v: does not refer to a subvolume: %v",
+				item.Key, body.Location)
+		}
+		return treeID, nil
+	case *btrfsitem.Error:
+		return 0, fmt.Errorf("default subvolume: DIR_ITEM %v: %w", item.Key, body.Err)
+	default:
+		panic(fmt.Errorf("should not happen: DIR_ITEM item has unexpected type: %T", body))
+	}
+}
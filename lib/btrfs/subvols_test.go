@@ -0,0 +1,204 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfs_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstest"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+func TestListSubvolumes(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	var (
+		uuidTop  = btrfsprim.MustParseUUID("00000000-0000-0000-0000-000000000005")
+		uuidHome = btrfsprim.MustParseUUID("00000000-0000-0000-0000-000000000100")
+		uuidSrv  = btrfsprim.MustParseUUID("00000000-0000-0000-0000-000000000101")
+		uuidSnap = btrfsprim.MustParseUUID("00000000-0000-0000-0000-000000000102")
+		uuidRecv = btrfsprim.MustParseUUID("00000000-0000-0000-0000-0000000000ff")
+	)
+	rootItem := func(id btrfsprim.ObjID, offset uint64, body btrfsitem.Root) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: id, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: offset},
+			Body: &body,
+		}
+	}
+	rootRef := func(typ btrfsprim.ItemType, objID, offset btrfsprim.ObjID, name string) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: objID, ItemType: typ, Offset: uint64(offset)},
+			Body: &btrfsitem.RootRef{DirID: 256, Name: []byte(name)},
+		}
+	}
+	// Subvolume 256 ("home") and 257 ("srv") are children of the
+	// top-level subvolume; 258 ("home-snap") is a snapshot of 256
+	// that lives inside of 256, and only has a ROOT_REF (not a
+	// ROOT_BACKREF).
+	rootTree := []btrfstree.Item{
+		rootItem(btrfsprim.EXTENT_TREE_OBJECTID, 0, btrfsitem.Root{}),
+		rootItem(btrfsprim.FS_TREE_OBJECTID, 0, btrfsitem.Root{UUID: uuidTop}),
+		rootRef(btrfsitem.ROOT_REF_KEY, btrfsprim.FS_TREE_OBJECTID, 256, "home"),
+		rootRef(btrfsitem.ROOT_REF_KEY, btrfsprim.FS_TREE_OBJECTID, 257, "srv"),
+		{
+			Key:  btrfsprim.Key{ObjectID: btrfsprim.ROOT_TREE_DIR_OBJECTID, ItemType: btrfsitem.DIR_ITEM_KEY},
+			Body: &btrfsitem.DirEntry{Name: []byte("default")},
+		},
+		rootItem(256, 0, btrfsitem.Root{UUID: uuidHome}),
+		rootRef(btrfsitem.ROOT_BACKREF_KEY, 256, btrfsprim.FS_TREE_OBJECTID, "home"),
+		rootRef(btrfsitem.ROOT_REF_KEY, 256, 258, "home-snap"),
+		rootItem(257, 0, btrfsitem.Root{UUID: uuidSrv, ReceivedUUID: uuidRecv}),
+		rootRef(btrfsitem.ROOT_BACKREF_KEY, 257, btrfsprim.FS_TREE_OBJECTID, "srv"),
+		rootItem(258, 12, btrfsitem.Root{UUID: uuidSnap, ParentUUID: uuidHome}),
+	}
+	fs := btrfstest.ItemsFS{
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.ROOT_TREE_OBJECTID: rootTree,
+		},
+	}
+
+	subvols, err := btrfs.ListSubvolumes(ctx, fs)
+	require.NoError(t, err)
+	assert.Equal(t, []btrfs.SubvolumeInfo{
+		{TreeID: btrfsprim.FS_TREE_OBJECTID, UUID: uuidTop},
+		{TreeID: 256, Name: "home", ParentID: btrfsprim.FS_TREE_OBJECTID, UUID: uuidHome},
+		{TreeID: 257, Name: "srv", ParentID: btrfsprim.FS_TREE_OBJECTID, UUID: uuidSrv, ReceivedUUID: uuidRecv},
+		{TreeID: 258, Name: "home-snap", ParentID: 256, UUID: uuidSnap, ParentUUID: uuidHome},
+	}, subvols)
+}
+
+func TestDefaultSubvolume(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	defaultItem := func(location btrfsprim.Key) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{
+				ObjectID: btrfsprim.ROOT_TREE_DIR_OBJECTID,
+				ItemType: btrfsitem.DIR_ITEM_KEY,
+				Offset:   btrfsitem.NameHash([]byte("default")),
+			},
+			Body: &btrfsitem.DirEntry{
+				Location: location,
+				Type:     btrfsitem.FT_DIR,
+				Name:     []byte("default"),
+			},
+		}
+	}
+
+	type TestCase struct {
+		RootTree []btrfstree.Item
+		ExpID    btrfsprim.ObjID
+		ExpErr   string
+	}
+	testcases := map[string]TestCase{
+		"unset": {
+			ExpID: btrfsprim.FS_TREE_OBJECTID,
+		},
+		"fs-tree": {
+			RootTree: []btrfstree.Item{
+				defaultItem(btrfsprim.Key{ObjectID: btrfsprim.FS_TREE_OBJECTID, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: math.MaxUint64}),
+			},
+			ExpID: btrfsprim.FS_TREE_OBJECTID,
+		},
+		"subvol": {
+			RootTree: []btrfstree.Item{
+				defaultItem(btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.ROOT_ITEM_KEY, Offset: math.MaxUint64}),
+			},
+			ExpID: 257,
+		},
+		"not-a-subvol": {
+			RootTree: []btrfstree.Item{
+				defaultItem(btrfsprim.Key{ObjectID: 257, ItemType: btrfsitem.INODE_ITEM_KEY}),
+			},
+			ExpErr: `default subvolume: DIR_ITEM (ROOT_TREE_DIR DIR_ITEM 2378154706): does not refer to a subvolume: (257 INODE_ITEM 0)`,
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			fs := btrfstest.ItemsFS{
+				Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+					btrfsprim.ROOT_TREE_OBJECTID: tc.RootTree,
+				},
+			}
+			id, err := btrfs.DefaultSubvolume(ctx, fs)
+			if tc.ExpErr != "" {
+				assert.EqualError(t, err, tc.ExpErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ExpID, id)
+		})
+	}
+}
+
+func TestLookupSubvolumeName(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	rootRef := func(typ btrfsprim.ItemType, objID, offset btrfsprim.ObjID, name string) btrfstree.Item {
+		return btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: objID, ItemType: typ, Offset: uint64(offset)},
+			Body: &btrfsitem.RootRef{DirID: 256, Name: []byte(name)},
+		}
+	}
+	// 256 has both a ROOT_REF and a ROOT_BACKREF, 257 has only a
+	// ROOT_REF, 258 has a damaged ROOT_BACKREF but a good
+	// ROOT_REF, and 259 has neither.
+	fs := btrfstest.ItemsFS{
+		Trees: map[btrfsprim.ObjID][]btrfstree.Item{
+			btrfsprim.ROOT_TREE_OBJECTID: {
+				rootRef(btrfsitem.ROOT_REF_KEY, btrfsprim.FS_TREE_OBJECTID, 256, "home"),
+				rootRef(btrfsitem.ROOT_BACKREF_KEY, 256, btrfsprim.FS_TREE_OBJECTID, "home"),
+				rootRef(btrfsitem.ROOT_REF_KEY, 256, 257, "home-snap"),
+				rootRef(btrfsitem.ROOT_REF_KEY, 256, 258, "srv"),
+				{
+					Key:  btrfsprim.Key{ObjectID: 258, ItemType: btrfsitem.ROOT_BACKREF_KEY, Offset: 256},
+					Body: &btrfsitem.Error{Err: fmt.Errorf("garbage")},
+				},
+			},
+		},
+	}
+
+	type TestCase struct {
+		TreeID      btrfsprim.ObjID
+		ExpName     string
+		ExpParentID btrfsprim.ObjID
+		ExpNoItem   bool
+	}
+	testcases := map[string]TestCase{
+		"backref":         {TreeID: 256, ExpName: "home", ExpParentID: btrfsprim.FS_TREE_OBJECTID},
+		"ref-only":        {TreeID: 257, ExpName: "home-snap", ExpParentID: 256},
+		"damaged-backref": {TreeID: 258, ExpName: "srv", ExpParentID: 256},
+		"fs-tree":         {TreeID: btrfsprim.FS_TREE_OBJECTID, ExpNoItem: true},
+		"unlinked":        {TreeID: 259, ExpNoItem: true},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			name, parentID, err := btrfs.LookupSubvolumeName(ctx, fs, tc.TreeID)
+			if tc.ExpNoItem {
+				assert.ErrorIs(t, err, btrfstree.ErrNoItem)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ExpName, name)
+			assert.Equal(t, tc.ExpParentID, parentID)
+		})
+	}
+}
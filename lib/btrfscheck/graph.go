@@ -38,7 +38,11 @@ func HandleItemWouldBeNoOp(typ btrfsprim.ItemType) bool {
 		btrfsprim.TREE_BLOCK_REF_KEY,
 		btrfsprim.SHARED_BLOCK_REF_KEY,
 		btrfsprim.FREE_SPACE_EXTENT_KEY,
+		// btrfsitem.QGroup*
+		btrfsprim.QGROUP_INFO_KEY,
+		btrfsprim.QGROUP_LIMIT_KEY,
 		btrfsprim.QGROUP_RELATION_KEY,
+		btrfsprim.QGROUP_STATUS_KEY,
 		// btrfsite.ExtentCSum
 		btrfsprim.EXTENT_CSUM_KEY:
 		return true
@@ -276,6 +280,8 @@ func HandleItem(ctx context.Context, o GraphCallbacks, treeID btrfsprim.ObjID, i
 				panic(fmt.Errorf("should not happen: Metadata: unexpected .Refs[%d].Body type %T", i, refBody))
 			}
 		}
+	case *btrfsitem.QGroupInfo, *btrfsitem.QGroupLimit, *btrfsitem.QGroupRelation, *btrfsitem.QGroupStatus:
+		// nothing
 	case *btrfsitem.Root:
 		if body.RootDirID != 0 {
 			o.WantOff(ctx, "root directory",
@@ -359,6 +365,8 @@ func HandleItem(ctx context.Context, o GraphCallbacks, treeID btrfsprim.ObjID, i
 			btrfsprim.ROOT_TREE_OBJECTID,
 			body.ObjID,
 			btrfsitem.ROOT_ITEM_KEY)
+	case *btrfsitem.UntypedUnknown:
+		// nothing
 	case *btrfsitem.Error:
 		o.FSErr(ctx, fmt.Errorf("error decoding item: %w", body.Err))
 	default:
@@ -0,0 +1,195 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/datawire/dlib/derror"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+// maxDataExtentSize is the largest that a data extent can be; see
+// linux.git/fs/btrfs/ctree.h:BTRFS_MAX_EXTENT_SIZE.
+const maxDataExtentSize = 128 * 1024 * 1024
+
+// An InodeRef identifies a file that references a data extent.
+type InodeRef struct {
+	Tree  btrfsprim.ObjID
+	Inode btrfsprim.ObjID
+	// Offset is the position within the file that the beginning
+	// of the extent would be at (which may be negative, if the
+	// file only references a later part of the extent).
+	Offset int64
+}
+
+func (ref InodeRef) String() string {
+	return fmt.Sprintf("tree=%v inode=%v offset=%v", ref.Tree, ref.Inode, ref.Offset)
+}
+
+// extentSearcher is a TreeSearcher for the extent tree that matches
+// all items that belong to an extent that could contain laddr.
+type extentSearcher struct {
+	laddr btrfsvol.LogicalAddr
+}
+
+func (s extentSearcher) String() string {
+	return fmt.Sprintf("extent containing laddr=%v", s.laddr)
+}
+
+func (s extentSearcher) Search(key btrfsprim.Key, _ uint32) int {
+	beg := btrfsvol.LogicalAddr(key.ObjectID)
+	switch {
+	case s.laddr < beg:
+		return -1
+	case beg+maxDataExtentSize <= s.laddr:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// LogicalToInodes returns the files that reference the data extent
+// containing the logical address `laddr`, as recorded by the
+// extent's backrefs in the extent tree.
+//
+// EXTENT_DATA_REF backrefs (whether inline in the EXTENT_ITEM or
+// keyed items of their own) name the file directly.
+// SHARED_DATA_REF backrefs instead name a leaf node, and are resolved
+// by reading that node and looking for the EXTENT_DATA items in it
+// that point at the extent; the InodeRef.Tree for these is the
+// owner of that node (other snapshots that share the node are not
+// enumerated).
+//
+// Backrefs that can't be resolved are reported in the returned
+// error, but do not prevent the remaining InodeRefs from being
+// returned.
+func LogicalToInodes(ctx context.Context, fs btrfs.ReadableFS, laddr btrfsvol.LogicalAddr) ([]InodeRef, error) {
+	sb, err := fs.Superblock()
+	if err != nil {
+		return nil, err
+	}
+	extentTree, err := fs.ForrestLookup(ctx, btrfsprim.EXTENT_TREE_OBJECTID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ret         []InodeRef
+		errs        derror.MultiError
+		extent      containers.Optional[btrfsprim.Key]
+		isMetadata  bool
+		sharedLeafs []btrfsvol.LogicalAddr
+	)
+	addRef := func(typ btrfsprim.ItemType, offset uint64, body btrfsitem.Item) {
+		switch typ {
+		case btrfsitem.EXTENT_DATA_REF_KEY:
+			if ref, ok := body.(*btrfsitem.ExtentDataRef); ok {
+				ret = append(ret, InodeRef{
+					Tree:   ref.Root,
+					Inode:  ref.ObjectID,
+					Offset: ref.Offset,
+				})
+			}
+		case btrfsitem.SHARED_DATA_REF_KEY:
+			sharedLeafs = append(sharedLeafs, btrfsvol.LogicalAddr(offset))
+		}
+	}
+	if err := extentTree.TreeSubrange(ctx, 0, extentSearcher{laddr: laddr}, func(item btrfstree.Item) bool {
+		beg := btrfsvol.LogicalAddr(item.Key.ObjectID)
+		switch body := item.Body.(type) {
+		case *btrfsitem.Extent:
+			if laddr >= beg.Add(btrfsvol.AddrDelta(item.Key.Offset)) {
+				return true
+			}
+			if !body.Head.Flags.Has(btrfsitem.EXTENT_FLAG_DATA) {
+				isMetadata = true
+				return false
+			}
+			extent = containers.OptionalValue(item.Key)
+			for _, ref := range body.Refs {
+				addRef(ref.Type, ref.Offset, ref.Body)
+			}
+		case *btrfsitem.Metadata:
+			if laddr >= beg.Add(btrfsvol.AddrDelta(sb.NodeSize)) {
+				return true
+			}
+			isMetadata = true
+			return false
+		case *btrfsitem.ExtentDataRef, *btrfsitem.SharedDataRef:
+			if !extent.OK || item.Key.ObjectID != extent.Val.ObjectID {
+				return true
+			}
+			addRef(item.Key.ItemType, item.Key.Offset, body)
+		case *btrfsitem.Error:
+			errs = append(errs, fmt.Errorf("extent tree: item %v: %w", item.Key, body.Err))
+		}
+		return true
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("extent tree: %w", err))
+	}
+	if isMetadata {
+		return nil, fmt.Errorf("laddr=%v: is a tree block, not file data", laddr)
+	}
+	if !extent.OK {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("laddr=%v: no extent item found: %w", laddr, errs)
+		}
+		return nil, fmt.Errorf("laddr=%v: no extent item found", laddr)
+	}
+
+	for _, leafAddr := range sharedLeafs {
+		refs, err := sharedLeafInodes(ctx, fs, leafAddr, btrfsvol.LogicalAddr(extent.Val.ObjectID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("SHARED_DATA_REF parent=%v: %w", leafAddr, err))
+		}
+		ret = append(ret, refs...)
+	}
+
+	if len(errs) > 0 {
+		return ret, errs
+	}
+	return ret, nil
+}
+
+// sharedLeafInodes returns the EXTENT_DATA items in the leaf node at
+// `leafAddr` that refer to the extent beginning at `extentAddr`.
+func sharedLeafInodes(ctx context.Context, fs btrfstree.NodeSource, leafAddr, extentAddr btrfsvol.LogicalAddr) ([]InodeRef, error) {
+	node, err := fs.AcquireNode(ctx, leafAddr, btrfstree.NodeExpectations{
+		LAddr: containers.OptionalValue(leafAddr),
+		Level: containers.OptionalValue(uint8(0)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer fs.ReleaseNode(node)
+
+	var ret []InodeRef
+	for _, item := range node.BodyLeaf {
+		if item.Key.ItemType != btrfsitem.EXTENT_DATA_KEY {
+			continue
+		}
+		body, ok := item.Body.(*btrfsitem.FileExtent)
+		if !ok || body.Type == btrfsitem.FILE_EXTENT_INLINE || body.BodyExtent.DiskByteNr != extentAddr {
+			continue
+		}
+		ret = append(ret, InodeRef{
+			Tree:   node.Head.Owner,
+			Inode:  item.Key.ObjectID,
+			Offset: int64(item.Key.Offset) - int64(body.BodyExtent.Offset),
+		})
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no EXTENT_DATA items refer to extent laddr=%v", extentAddr)
+	}
+	return ret, nil
+}
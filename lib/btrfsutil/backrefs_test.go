@@ -0,0 +1,178 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+)
+
+// extentsFS is a ReadableFS with an extent tree that is a flat list
+// of items, and a handful of in-memory nodes.
+type extentsFS struct {
+	btrfs.ReadableFS
+	sb      btrfstree.Superblock
+	extents []btrfstree.Item
+	nodes   map[btrfsvol.LogicalAddr]*btrfstree.Node
+}
+
+func (fs *extentsFS) Superblock() (*btrfstree.Superblock, error) { return &fs.sb, nil }
+
+func (fs *extentsFS) ForrestLookup(_ context.Context, treeID btrfsprim.ObjID) (btrfstree.Tree, error) {
+	if treeID != btrfsprim.EXTENT_TREE_OBJECTID {
+		return nil, fmt.Errorf("tree %v: %w", treeID, btrfstree.ErrNoTree)
+	}
+	return extentsTree{items: fs.extents}, nil
+}
+
+func (fs *extentsFS) AcquireNode(_ context.Context, addr btrfsvol.LogicalAddr, exp btrfstree.NodeExpectations) (*btrfstree.Node, error) {
+	node, ok := fs.nodes[addr]
+	if !ok {
+		return nil, fmt.Errorf("node@%v: no such node", addr)
+	}
+	if err := exp.Check(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (*extentsFS) ReleaseNode(*btrfstree.Node) {}
+
+type extentsTree struct {
+	btrfstree.Tree
+	items []btrfstree.Item
+}
+
+func (tree extentsTree) TreeSubrange(_ context.Context, min int, search btrfstree.TreeSearcher, handleFn func(btrfstree.Item) bool) error {
+	cnt := 0
+	for _, item := range tree.items {
+		if search.Search(item.Key, item.BodySize) != 0 {
+			continue
+		}
+		cnt++
+		if !handleFn(item) {
+			break
+		}
+	}
+	if cnt < min {
+		return fmt.Errorf("%v: %w", search, btrfstree.ErrNoItem)
+	}
+	return nil
+}
+
+func TestLogicalToInodes(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	// A 128KiB data extent at 1MiB that is referenced by:
+	//
+	//  - inode 257 in FS_TREE at file offset 0 (inline backref)
+	//  - inode 260 in subvolume 256 at file offset 4KiB (keyed backref)
+	//  - inode 300 in a leaf owned by subvolume 258, at file
+	//    offset 8KiB but starting 4KiB in to the extent (shared
+	//    backref)
+	//
+	// And a tree block at 4MiB.
+	const (
+		extentAddr btrfsvol.LogicalAddr = 0x100000
+		extentSize                      = 0x20000
+		leafAddr   btrfsvol.LogicalAddr = 0x500000
+	)
+	fs := &extentsFS{
+		sb: btrfstree.Superblock{NodeSize: 0x4000},
+		extents: []btrfstree.Item{
+			{
+				Key: btrfsprim.Key{ObjectID: btrfsprim.ObjID(extentAddr), ItemType: btrfsitem.EXTENT_ITEM_KEY, Offset: extentSize},
+				Body: &btrfsitem.Extent{
+					Head: btrfsitem.ExtentHeader{Refs: 3, Flags: btrfsitem.EXTENT_FLAG_DATA},
+					Refs: []btrfsitem.ExtentInlineRef{{
+						Type: btrfsitem.EXTENT_DATA_REF_KEY,
+						Body: &btrfsitem.ExtentDataRef{Root: btrfsprim.FS_TREE_OBJECTID, ObjectID: 257, Offset: 0, Count: 1},
+					}},
+				},
+			},
+			{
+				Key:  btrfsprim.Key{ObjectID: btrfsprim.ObjID(extentAddr), ItemType: btrfsitem.EXTENT_DATA_REF_KEY, Offset: 1},
+				Body: &btrfsitem.ExtentDataRef{Root: 256, ObjectID: 260, Offset: 0x1000, Count: 1},
+			},
+			{
+				Key:  btrfsprim.Key{ObjectID: btrfsprim.ObjID(extentAddr), ItemType: btrfsitem.SHARED_DATA_REF_KEY, Offset: uint64(leafAddr)},
+				Body: &btrfsitem.SharedDataRef{Count: 1},
+			},
+			{
+				Key: btrfsprim.Key{ObjectID: 0x400000, ItemType: btrfsitem.METADATA_ITEM_KEY, Offset: 0},
+				Body: &btrfsitem.Metadata{
+					Head: btrfsitem.ExtentHeader{Refs: 1, Flags: btrfsitem.EXTENT_FLAG_TREE_BLOCK},
+				},
+			},
+		},
+		nodes: map[btrfsvol.LogicalAddr]*btrfstree.Node{
+			leafAddr: {
+				Head: btrfstree.NodeHeader{Addr: leafAddr, Owner: 258, Level: 0, NumItems: 2},
+				BodyLeaf: []btrfstree.Item{
+					{
+						Key:  btrfsprim.Key{ObjectID: 300, ItemType: btrfsitem.INODE_ITEM_KEY},
+						Body: &btrfsitem.Inode{},
+					},
+					{
+						Key: btrfsprim.Key{ObjectID: 300, ItemType: btrfsitem.EXTENT_DATA_KEY, Offset: 0x2000},
+						Body: &btrfsitem.FileExtent{
+							Type: btrfsitem.FILE_EXTENT_REG,
+							BodyExtent: btrfsitem.FileExtentExtent{
+								DiskByteNr:   extentAddr,
+								DiskNumBytes: extentSize,
+								Offset:       0x1000,
+								NumBytes:     0x1000,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	type TestCase struct {
+		LAddr   btrfsvol.LogicalAddr
+		ExpRefs []btrfsutil.InodeRef
+		ExpErr  string
+	}
+	expRefs := []btrfsutil.InodeRef{
+		{Tree: btrfsprim.FS_TREE_OBJECTID, Inode: 257, Offset: 0},
+		{Tree: 256, Inode: 260, Offset: 0x1000},
+		{Tree: 258, Inode: 300, Offset: 0x1000},
+	}
+	testcases := map[string]TestCase{
+		"start":      {LAddr: extentAddr, ExpRefs: expRefs},
+		"middle":     {LAddr: extentAddr + 0x10000, ExpRefs: expRefs},
+		"last-byte":  {LAddr: extentAddr + extentSize - 1, ExpRefs: expRefs},
+		"before":     {LAddr: extentAddr - 1, ExpErr: `laddr=0x00000000000fffff: no extent item found`},
+		"after":      {LAddr: extentAddr + extentSize, ExpErr: `laddr=0x0000000000120000: no extent item found`},
+		"tree-block": {LAddr: 0x400100, ExpErr: `laddr=0x0000000000400100: is a tree block, not file data`},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			refs, err := btrfsutil.LogicalToInodes(ctx, fs, tc.LAddr)
+			if tc.ExpErr != "" {
+				assert.EqualError(t, err, tc.ExpErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ExpRefs, refs)
+		})
+	}
+}
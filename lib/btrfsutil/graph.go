@@ -363,6 +363,7 @@ func ReadGraph(_ctx context.Context, fs *btrfs.FS, nodeList []btrfsvol.LogicalAd
 		ctx,
 		dlog.LogLevelInfo,
 		textui.Tunable(1*time.Second))
+	progressWriter.EnableETA()
 	progressWriter.Set(stats)
 	for _, laddr := range nodeList {
 		if err := ctx.Err(); err != nil {
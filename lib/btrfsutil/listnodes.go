@@ -52,14 +52,19 @@ func (s *nodeLister) ScanDone(_ context.Context) (containers.Set[btrfsvol.Logica
 	return s.nodes, nil
 }
 
+// ListNodes scans every device in the filesystem for nodes, and
+// returns a sorted list of their logical addresses.
+//
+// If the Context is canceled (or times out), then the nodes found so
+// far are returned along with the error.
 func ListNodes(ctx context.Context, fs *btrfs.FS) ([]btrfsvol.LogicalAddr, error) {
-	perDev, err := ScanDevices[nodeListStats, containers.Set[btrfsvol.LogicalAddr]](ctx, fs, newNodeLister)
-	if err != nil {
+	perDev, err := ScanDevices[nodeListStats, containers.Set[btrfsvol.LogicalAddr]](ctx, fs, 0, nil, newNodeLister)
+	if perDev == nil {
 		return nil, err
 	}
 	set := make(containers.Set[btrfsvol.LogicalAddr])
 	for _, devSet := range perDev {
 		set.InsertFrom(devSet)
 	}
-	return maps.SortedKeys(set), nil
+	return maps.SortedKeys(set), err
 }
@@ -13,6 +13,7 @@ import (
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
@@ -250,15 +251,15 @@ func (ts *RebuiltForrest) flushNegativeCache(ctx context.Context) {
 // RebuiltListRoots returns a listing of all initialized trees and
 // their root nodes.
 //
-// Do not mutate the set of roots for a tree; it is a pointer to the
-// RebuiltForrest's internal set!
+// The returned sets are copies, and may be freely mutated by the
+// caller.
 func (ts *RebuiltForrest) RebuiltListRoots(ctx context.Context) map[btrfsprim.ObjID]containers.Set[btrfsvol.LogicalAddr] {
 	_ = ts.treesMu.Lock(ctx)
 	defer ts.treesMu.Unlock()
 	ret := make(map[btrfsprim.ObjID]containers.Set[btrfsvol.LogicalAddr])
 	for treeID, tree := range ts.trees {
 		if len(tree.Roots) > 0 {
-			ret[treeID] = tree.Roots
+			ret[treeID] = tree.Roots.Clone()
 		}
 	}
 	return ret
@@ -342,3 +343,8 @@ func (ts *RebuiltForrest) ReleaseNode(node *btrfstree.Node) {
 func (ts *RebuiltForrest) ReadAt(p []byte, off btrfsvol.LogicalAddr) (int, error) {
 	return ts.inner.ReadAt(p, off)
 }
+
+// ReadAtVerified implements btrfs.ReadableFS.
+func (ts *RebuiltForrest) ReadAtVerified(ctx context.Context, p []byte, off btrfsvol.LogicalAddr, alg btrfssum.CSumType, expSum btrfssum.CSum) (int, error) {
+	return ts.inner.ReadAtVerified(ctx, p, off, alg, expSum)
+}
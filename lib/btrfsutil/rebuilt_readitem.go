@@ -12,6 +12,7 @@ import (
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
 	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+	"git.lukeshu.com/btrfs-progs-ng/lib/maps"
 )
 
 type ItemPtr struct {
@@ -24,19 +25,46 @@ func (ptr ItemPtr) String() string {
 }
 
 func (ts *RebuiltForrest) readItem(ctx context.Context, ptr ItemPtr) btrfstree.Item {
-	graphInfo, ok := ts.graph.Nodes[ptr.Node]
+	node := ts.acquireLeaf(ctx, ptr.Node)
+	defer ts.ReleaseNode(node)
+	return cloneItemFromLeaf(node, ptr.Slot)
+}
+
+// RebuiltReadItems is like reading each ItemPtr in turn, but groups
+// the pointers by node so that each node is read only once, no matter
+// how many of its items are requested or in what order they appear.
+// The returned items are in the same order as `ptrs`.
+//
+// The returned item bodies are clones owned by the caller; call
+// .Body.Free() on them when done.
+func (ts *RebuiltForrest) RebuiltReadItems(ctx context.Context, ptrs []ItemPtr) []btrfstree.Item {
+	byNode := make(map[btrfsvol.LogicalAddr][]int)
+	for i, ptr := range ptrs {
+		byNode[ptr.Node] = append(byNode[ptr.Node], i)
+	}
+
+	ret := make([]btrfstree.Item, len(ptrs))
+	for _, nodeAddr := range maps.SortedKeys(byNode) {
+		node := ts.acquireLeaf(ctx, nodeAddr)
+		for _, i := range byNode[nodeAddr] {
+			ret[i] = cloneItemFromLeaf(node, ptrs[i].Slot)
+		}
+		ts.ReleaseNode(node)
+	}
+	return ret
+}
+
+func (ts *RebuiltForrest) acquireLeaf(ctx context.Context, nodeAddr btrfsvol.LogicalAddr) *btrfstree.Node {
+	graphInfo, ok := ts.graph.Nodes[nodeAddr]
 	if !ok {
-		panic(fmt.Errorf("should not happen: btrfsutil.RebuiltForrest.readItem called for node@%v not mentioned in the in-memory graph", ptr.Node))
+		panic(fmt.Errorf("should not happen: btrfsutil.RebuiltForrest.readItem called for node@%v not mentioned in the in-memory graph", nodeAddr))
 	}
 	if graphInfo.Level != 0 {
-		panic(fmt.Errorf("should not happen: btrfsutil.RebuiltForrest.readItem called for non-leaf node@%v", ptr.Node))
-	}
-	if ptr.Slot < 0 {
-		panic(fmt.Errorf("should not happen: btrfsutil.RebuiltForrest.readItem called for negative item slot: %v", ptr.Slot))
+		panic(fmt.Errorf("should not happen: btrfsutil.RebuiltForrest.readItem called for non-leaf node@%v", nodeAddr))
 	}
 
-	node, err := ts.AcquireNode(ctx, ptr.Node, btrfstree.NodeExpectations{
-		LAddr:      containers.OptionalValue(ptr.Node),
+	node, err := ts.AcquireNode(ctx, nodeAddr, btrfstree.NodeExpectations{
+		LAddr:      containers.OptionalValue(nodeAddr),
 		Level:      containers.OptionalValue(graphInfo.Level),
 		Generation: containers.OptionalValue(graphInfo.Generation),
 		Owner: func(treeID btrfsprim.ObjID, gen btrfsprim.Generation) error {
@@ -50,18 +78,24 @@ func (ts *RebuiltForrest) readItem(ctx context.Context, ptr ItemPtr) btrfstree.I
 		MinItem: containers.OptionalValue(graphInfo.MinItem(ts.graph)),
 		MaxItem: containers.OptionalValue(graphInfo.MaxItem(ts.graph)),
 	})
-	defer ts.ReleaseNode(node)
 	if err != nil {
+		ts.ReleaseNode(node)
 		panic(fmt.Errorf("should not happen: i/o error: %w", err))
 	}
+	return node
+}
 
+func cloneItemFromLeaf(node *btrfstree.Node, slot int) btrfstree.Item {
+	if slot < 0 {
+		panic(fmt.Errorf("should not happen: btrfsutil.RebuiltForrest.readItem called for negative item slot: %v", slot))
+	}
 	items := node.BodyLeaf
-	if ptr.Slot >= len(items) {
+	if slot >= len(items) {
 		panic(fmt.Errorf("should not happen: btrfsutil.RebuiltForrest.readItem called for out-of-bounds item slot: slot=%v len=%v",
-			ptr.Slot, len(items)))
+			slot, len(items)))
 	}
 
-	item := items[ptr.Slot]
+	item := items[slot]
 	item.Body = item.Body.CloneItem()
 	return item
 }
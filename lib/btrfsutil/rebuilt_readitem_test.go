@@ -0,0 +1,113 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+// countingNodesFS is a memNodesFS that counts how many times each
+// node is acquired.
+type countingNodesFS struct {
+	*memNodesFS
+	acquires map[btrfsvol.LogicalAddr]int
+}
+
+func (fs *countingNodesFS) AcquireNode(ctx context.Context, addr btrfsvol.LogicalAddr, exp btrfstree.NodeExpectations) (*btrfstree.Node, error) {
+	fs.acquires[addr]++
+	return fs.memNodesFS.AcquireNode(ctx, addr, exp)
+}
+
+func TestRebuiltReadItems(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Generation:   1,
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     4096,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	var items []btrfstree.Item
+	for objID := btrfsprim.ObjID(256); objID < 756; objID++ {
+		items = append(items, btrfstree.Item{
+			Key:  btrfsprim.Key{ObjectID: objID, ItemType: btrfsitem.INODE_ITEM_KEY},
+			Body: &btrfsitem.Inode{Size: int64(objID)},
+		})
+	}
+	nextAddr := btrfsvol.LogicalAddr(1024 * 1024)
+	nodes, err := btrfstree.BuildTree(sb, btrfstree.NodeHeader{
+		Flags:      btrfstree.NodeWritten,
+		BackrefRev: btrfstree.MixedBackrefRev,
+		Generation: 1,
+		Owner:      btrfsprim.FS_TREE_OBJECTID,
+	}, items, func() (btrfsvol.LogicalAddr, error) {
+		addr := nextAddr
+		nextAddr += btrfsvol.LogicalAddr(sb.NodeSize)
+		return addr, nil
+	})
+	require.NoError(t, err)
+
+	fs := &countingNodesFS{
+		memNodesFS: &memNodesFS{
+			sb:    sb,
+			nodes: make(map[btrfsvol.LogicalAddr]*btrfstree.Node),
+		},
+		acquires: make(map[btrfsvol.LogicalAddr]int),
+	}
+	graph := NewGraph(ctx, sb)
+	var leaves []*btrfstree.Node
+	for _, node := range nodes {
+		fs.nodes[node.Head.Addr] = node
+		graph.InsertNode(node)
+		if node.Head.Level == 0 {
+			leaves = append(leaves, node)
+		}
+	}
+	require.Greater(t, len(leaves), 2)
+	forrest := NewRebuiltForrest(fs, graph, rebuiltForrestCallbacks{
+		lookupRoot: func(_ context.Context, tree btrfsprim.ObjID) (btrfsprim.Generation, btrfsitem.Root, error) {
+			return 0, btrfsitem.Root{}, fmt.Errorf("tree %v: no such tree", tree)
+		},
+	}, false)
+
+	// Interleave items from the first and last leaves, with a
+	// repeat.
+	first, last := leaves[0], leaves[len(leaves)-1]
+	ptrs := []ItemPtr{
+		{Node: last.Head.Addr, Slot: 1},
+		{Node: first.Head.Addr, Slot: 0},
+		{Node: last.Head.Addr, Slot: 0},
+		{Node: first.Head.Addr, Slot: 2},
+		{Node: last.Head.Addr, Slot: 1},
+	}
+	got := forrest.RebuiltReadItems(ctx, ptrs)
+	require.Len(t, got, len(ptrs))
+	for i, ptr := range ptrs {
+		exp := fs.nodes[ptr.Node].BodyLeaf[ptr.Slot]
+		assert.Equal(t, exp.Key, got[i].Key, "ptrs[%d]", i)
+		assert.Equal(t, exp.Body, got[i].Body, "ptrs[%d]", i)
+		// The bodies are clones, not the nodes' own.
+		assert.NotSame(t, exp.Body, got[i].Body, "ptrs[%d]", i)
+		got[i].Body.Free()
+	}
+	assert.Equal(t, map[btrfsvol.LogicalAddr]int{
+		first.Head.Addr: 1,
+		last.Head.Addr:  1,
+	}, fs.acquires)
+}
@@ -7,6 +7,7 @@ package btrfsutil
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -287,13 +288,47 @@ func (tree *RebuiltTree) isOwnerOK(owner btrfsprim.ObjID, gen btrfsprim.Generati
 	}
 }
 
+// explainOwner is like .isOwnerOK, but instead of just returning a
+// bool it also returns the chain of decisions that lead to that
+// result.  Unlike .isOwnerOK, it is not intended to be fast.
+func (tree *RebuiltTree) explainOwner(owner btrfsprim.ObjID, gen btrfsprim.Generation) (bool, []string) {
+	var reasons []string
+	root := tree.ancestorRoot
+	for {
+		if owner == tree.ID {
+			reasons = append(reasons, fmt.Sprintf("tree %v: owner matches", tree.ID))
+			return true, reasons
+		}
+		reasons = append(reasons, fmt.Sprintf("tree %v: owner mismatch: node owner=%v", tree.ID, owner))
+		switch {
+		case tree.Parent == nil:
+			if tree.parentErr != nil {
+				reasons = append(reasons, fmt.Sprintf("tree %v: parent tree could not be loaded: %v", tree.ID, tree.parentErr))
+			} else {
+				reasons = append(reasons, fmt.Sprintf("tree %v: has no parent tree to inherit the node from", tree.ID))
+			}
+			return false, reasons
+		case gen > tree.ParentGen:
+			reasons = append(reasons, fmt.Sprintf("tree %v: node generation=%v is too new to have been inherited from parent tree %v (snapshotted at generation=%v)",
+				tree.ID, gen, tree.Parent.ID, tree.ParentGen))
+			return false, reasons
+		case tree.ID == root:
+			reasons = append(reasons, fmt.Sprintf("tree %v: not considering parent tree %v, as it is part of an ancestor loop",
+				tree.ID, tree.Parent.ID))
+			return false, reasons
+		}
+		tree = tree.Parent
+	}
+}
+
 // evictable members 2 and 3: .Rebuilt{Acquire,Release}{Potential,}Items() /////////////////////////////////////////////
 
 // RebuiltAcquireItems returns a map of the items contained in this
 // tree.
 //
 // Do not mutate the returned map; it is a pointer to the
-// RebuiltTree's internal map!
+// RebuiltTree's internal map!  If you need a map that you can mutate,
+// use .Clone() to make a copy.
 //
 // When done with the map, call .RebuiltReleaseItems().
 func (tree *RebuiltTree) RebuiltAcquireItems(ctx context.Context) *containers.SortedMap[btrfsprim.Key, ItemPtr] {
@@ -315,7 +350,8 @@ func (tree *RebuiltTree) RebuiltReleaseItems() {
 // added to this tree with .RebuiltAddRoot().
 //
 // Do not mutate the returned map; it is a pointer to the
-// RebuiltTree's internal map!
+// RebuiltTree's internal map!  If you need a map that you can mutate,
+// use .Clone() to make a copy.
 //
 // When done with the map, call .RebuiltReleasePotentialItems().
 func (tree *RebuiltTree) RebuiltAcquirePotentialItems(ctx context.Context) *containers.SortedMap[btrfsprim.Key, ItemPtr] {
@@ -805,6 +841,58 @@ func (tree *RebuiltTree) RebuiltLeafToRoots(ctx context.Context, leaf btrfsvol.L
 	return ret
 }
 
+// RebuiltExplainNode returns a human-readable description of why a
+// node is or isn't part of this tree; it is intended for debugging
+// why expected data didn't appear in the rebuilt tree.
+func (tree *RebuiltTree) RebuiltExplainNode(ctx context.Context, node btrfsvol.LogicalAddr) string {
+	nodeInfo, ok := tree.forrest.graph.Nodes[node]
+	if !ok {
+		if err, bad := tree.forrest.graph.BadNodes[node]; bad {
+			return fmt.Sprintf("tree %v: node@%v: excluded: node could not be read: %v", tree.ID, node, err)
+		}
+		return fmt.Sprintf("tree %v: node@%v: excluded: node is not in the node graph", tree.ID, node)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "tree %v: node@%v (owner=%v generation=%v level=%v):\n",
+		tree.ID, node, nodeInfo.Owner, nodeInfo.Generation, nodeInfo.Level)
+
+	ownerOK, reasons := tree.explainOwner(nodeInfo.Owner, nodeInfo.Generation)
+	for _, reason := range reasons {
+		fmt.Fprintf(&out, "\t%s\n", reason)
+	}
+	if !ownerOK {
+		out.WriteString("\t=> excluded: the node may not be in this tree\n")
+		return out.String()
+	}
+
+	tree.forrest.commitTrees(ctx, tree.ID)
+	tree.initRoots(ctx)
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	roots := tree.acquireNodeIndex(ctx).nodeToRoots[node]
+	defer tree.releaseNodeIndex()
+	switch {
+	case len(roots) == 0:
+		// Should not happen; every node that passes
+		// .isOwnerOK is at least its own root.
+		out.WriteString("\t=> excluded: no valid path from any root to the node\n")
+	case maps.HaveAnyKeysInCommon(tree.Roots, roots):
+		var inc []btrfsvol.LogicalAddr
+		for _, root := range maps.SortedKeys(roots) {
+			if tree.Roots.Has(root) {
+				inc = append(inc, root)
+			}
+		}
+		fmt.Fprintf(&out, "\t=> included: reachable from the tree's roots %v\n", inc)
+	default:
+		fmt.Fprintf(&out, "\t=> excluded: not reachable from any of the tree's roots %v; it would be included by adding any of the roots %v\n",
+			maps.SortedKeys(tree.Roots), maps.SortedKeys(roots))
+	}
+	return out.String()
+}
+
 // btrfstree.Tree interface ////////////////////////////////////////////////////////////////////////////////////////////
 
 var _ btrfstree.Tree = (*RebuiltTree)(nil)
@@ -0,0 +1,200 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+)
+
+func TestRebuiltTreeExplainOwner(t *testing.T) {
+	t.Parallel()
+
+	grandparent := &RebuiltTree{ID: 303}
+	parent := &RebuiltTree{ID: 304, Parent: grandparent, ParentGen: 1003}
+	child := &RebuiltTree{ID: 305, Parent: parent, ParentGen: 1004}
+
+	type TestCase struct {
+		Tree       *RebuiltTree
+		Owner      btrfsprim.ObjID
+		Gen        btrfsprim.Generation
+		ExpOK      bool
+		ExpReasons []string
+	}
+	testcases := map[string]TestCase{
+		"own": {
+			Tree: child, Owner: 305, Gen: 2000,
+			ExpOK: true,
+			ExpReasons: []string{
+				"tree 305: owner matches",
+			},
+		},
+		"inherited": {
+			Tree: child, Owner: 303, Gen: 1000,
+			ExpOK: true,
+			ExpReasons: []string{
+				"tree 305: owner mismatch: node owner=303",
+				"tree 304: owner mismatch: node owner=303",
+				"tree 303: owner matches",
+			},
+		},
+		"too-new": {
+			Tree: child, Owner: 303, Gen: 1004,
+			ExpOK: false,
+			ExpReasons: []string{
+				"tree 305: owner mismatch: node owner=303",
+				"tree 304: owner mismatch: node owner=303",
+				"tree 304: node generation=1004 is too new to have been inherited from parent tree 303 (snapshotted at generation=1003)",
+			},
+		},
+		"stranger": {
+			Tree: child, Owner: 5, Gen: 1000,
+			ExpOK: false,
+			ExpReasons: []string{
+				"tree 305: owner mismatch: node owner=FS_TREE",
+				"tree 304: owner mismatch: node owner=FS_TREE",
+				"tree 303: owner mismatch: node owner=FS_TREE",
+				"tree 303: has no parent tree to inherit the node from",
+			},
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			ok, reasons := tc.Tree.explainOwner(tc.Owner, tc.Gen)
+			assert.Equal(t, tc.ExpOK, ok)
+			assert.Equal(t, tc.ExpReasons, reasons)
+			assert.Equal(t, tc.Tree.isOwnerOK(tc.Owner, tc.Gen), ok)
+		})
+	}
+}
+
+// memNodesFS is a ReadableFS that can only read in-memory nodes.
+type memNodesFS struct {
+	btrfs.ReadableFS
+	sb    btrfstree.Superblock
+	nodes map[btrfsvol.LogicalAddr]*btrfstree.Node
+}
+
+func (fs *memNodesFS) Superblock() (*btrfstree.Superblock, error) { return &fs.sb, nil }
+
+func (fs *memNodesFS) AcquireNode(_ context.Context, addr btrfsvol.LogicalAddr, exp btrfstree.NodeExpectations) (*btrfstree.Node, error) {
+	node, ok := fs.nodes[addr]
+	if !ok {
+		return nil, fmt.Errorf("node@%v: no such node", addr)
+	}
+	if err := exp.Check(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (*memNodesFS) ReleaseNode(*btrfstree.Node) {}
+
+func TestRebuiltTreeKeyRange(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Generation:   1,
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     4096,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	var items []btrfstree.Item
+	for objID := btrfsprim.ObjID(256); objID < 1256; objID++ {
+		for _, off := range []uint64{10, 20} {
+			items = append(items, btrfstree.Item{
+				Key:  btrfsprim.Key{ObjectID: objID, ItemType: btrfsitem.ORPHAN_ITEM_KEY, Offset: off},
+				Body: &btrfsitem.Empty{},
+			})
+		}
+	}
+	nextAddr := btrfsvol.LogicalAddr(1024 * 1024)
+	nodes, err := btrfstree.BuildTree(sb, btrfstree.NodeHeader{
+		Flags:      btrfstree.NodeWritten,
+		BackrefRev: btrfstree.MixedBackrefRev,
+		Generation: 1,
+		Owner:      btrfsprim.ROOT_TREE_OBJECTID,
+	}, items, func() (btrfsvol.LogicalAddr, error) {
+		addr := nextAddr
+		nextAddr += btrfsvol.LogicalAddr(sb.NodeSize)
+		return addr, nil
+	})
+	require.NoError(t, err)
+	root := nodes[len(nodes)-1]
+	require.NotZero(t, root.Head.Level)
+	sb.RootTree = root.Head.Addr
+	sb.RootLevel = root.Head.Level
+
+	fs := &memNodesFS{
+		sb:    sb,
+		nodes: make(map[btrfsvol.LogicalAddr]*btrfstree.Node),
+	}
+	graph := NewGraph(ctx, sb)
+	for _, node := range nodes {
+		fs.nodes[node.Head.Addr] = node
+		graph.InsertNode(node)
+	}
+	cbs := rebuiltForrestCallbacks{
+		addedItem: func(context.Context, btrfsprim.ObjID, btrfsprim.Key) {},
+		addedRoot: func(context.Context, btrfsprim.ObjID, btrfsvol.LogicalAddr) {},
+		lookupRoot: func(_ context.Context, tree btrfsprim.ObjID) (btrfsprim.Generation, btrfsitem.Root, error) {
+			return 0, btrfsitem.Root{}, fmt.Errorf("tree %v: no such tree", tree)
+		},
+		lookupUUID: func(_ context.Context, uuid btrfsprim.UUID) (btrfsprim.ObjID, error) {
+			return 0, fmt.Errorf("uuid %v: no such tree", uuid)
+		},
+	}
+	tree, err := NewRebuiltForrest(fs, graph, cbs, false).RebuiltTree(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	require.NoError(t, err)
+
+	key := func(objID btrfsprim.ObjID, off uint64) btrfsprim.Key {
+		return btrfsprim.Key{ObjectID: objID, ItemType: btrfsitem.ORPHAN_ITEM_KEY, Offset: off}
+	}
+	type TestCase struct {
+		Min, Max btrfsprim.Key
+	}
+	testcases := map[string]TestCase{
+		"all":          {Min: btrfsprim.Key{}, Max: btrfsprim.MaxKey},
+		"one":          {Min: key(500, 20), Max: key(500, 20)},
+		"many-leaves":  {Min: key(300, 15), Max: key(1100, 15)},
+		"between-keys": {Min: key(500, 11), Max: key(500, 19)},
+		"after-last":   {Min: key(1256, 0), Max: btrfsprim.MaxKey},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			var expKeys []btrfsprim.Key
+			for _, item := range items {
+				if item.Key.Compare(tc.Min) >= 0 && item.Key.Compare(tc.Max) <= 0 {
+					expKeys = append(expKeys, item.Key)
+				}
+			}
+			var actKeys []btrfsprim.Key
+			err := tree.TreeSubrange(ctx, 0, btrfstree.SearchKeyRange(tc.Min, tc.Max), func(item btrfstree.Item) bool {
+				actKeys = append(actKeys, item.Key)
+				return true
+			})
+			require.NoError(t, err)
+			assert.Equal(t, expKeys, actKeys)
+		})
+	}
+}
@@ -11,14 +11,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 
 	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
 	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
 	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
 )
 
@@ -43,31 +46,76 @@ func (s devScanStats[T]) String() string {
 		s.portion, s.stats)
 }
 
-func ScanDevices[Stats comparable, Result any](ctx context.Context, fs *btrfs.FS, newScanner DeviceScannerFactory[Stats, Result]) (map[btrfsvol.DeviceID]Result, error) {
+// NodeOwnerFilter returns a filter for ScanDevices or ScanOneDevice
+// that only accepts nodes owned by one of the given trees.
+func NodeOwnerFilter(owners ...btrfsprim.ObjID) func(btrfstree.NodeHeader) bool {
+	set := containers.NewSet[btrfsprim.ObjID](owners...)
+	return func(head btrfstree.NodeHeader) bool {
+		return set.Has(head.Owner)
+	}
+}
+
+// ScanDevices runs ScanOneDevice on each device in the filesystem,
+// scanning up to numWorkers devices in parallel.  If numWorkers is
+// less than 1, then all devices are scanned in parallel.
+//
+// If nodeFilter is non-nil, then nodes that it rejects (based on just
+// the node header) are not parsed and are not passed to the
+// DeviceScanner's ScanNode.
+//
+// If the Context is canceled (or times out), then the partial results
+// for each device are returned along with the error.
+func ScanDevices[Stats comparable, Result any](ctx context.Context, fs *btrfs.FS, numWorkers int, nodeFilter func(btrfstree.NodeHeader) bool, newScanner DeviceScannerFactory[Stats, Result]) (map[btrfsvol.DeviceID]Result, error) {
+	devs := fs.LV.PhysicalVolumes()
+	if numWorkers < 1 || numWorkers > len(devs) {
+		numWorkers = len(devs)
+	}
+	// Each device's goroutine holds a slot in `sema` while it is
+	// scanning; each ScanOneDevice has its own progress writer,
+	// so there is no shared state to guard other than `result`.
+	sema := make(chan struct{}, numWorkers)
+
 	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
 	var mu sync.Mutex
 	result := make(map[btrfsvol.DeviceID]Result)
-	for id, dev := range fs.LV.PhysicalVolumes() {
+	for id, dev := range devs {
 		id := id
 		dev := dev
 		grp.Go(fmt.Sprintf("dev-%d", id), func(ctx context.Context) error {
-			devResult, err := ScanOneDevice[Stats, Result](ctx, dev, newScanner)
-			if err != nil {
+			select {
+			case sema <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-sema }()
+			devResult, err := ScanOneDevice[Stats, Result](ctx, dev, nodeFilter, newScanner)
+			if err != nil && ctx.Err() == nil {
 				return err
 			}
 			mu.Lock()
 			result[id] = devResult
 			mu.Unlock()
-			return nil
+			return err
 		})
 	}
 	if err := grp.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return result, err
+		}
 		return nil, err
 	}
 	return result, nil
 }
 
-func ScanOneDevice[Stats comparable, Result any](ctx context.Context, dev *btrfs.Device, newScanner DeviceScannerFactory[Stats, Result]) (Result, error) {
+// ScanOneDevice scans the device sector-by-sector, passing each
+// sector and each node that passes nodeFilter to a DeviceScanner
+// created by newScanner.  Nodes whose header fails
+// btrfstree.NodeHeader.Validate are logged and skipped.
+//
+// If the Context is canceled (or times out), then the scan stops
+// early, and the DeviceScanner's partial result is returned along
+// with the Context's error.
+func ScanOneDevice[Stats comparable, Result any](ctx context.Context, dev *btrfs.Device, nodeFilter func(btrfstree.NodeHeader) bool, newScanner DeviceScannerFactory[Stats, Result]) (Result, error) {
 	ctx = dlog.WithField(ctx, "scandevices.dev", dev.Name())
 
 	sb, err := dev.Superblock()
@@ -96,10 +144,10 @@ func ScanOneDevice[Stats comparable, Result any](ctx context.Context, dev *btrfs
 	stats.portion.D = numBytes
 
 	var minNextNode btrfsvol.PhysicalAddr
+	var ctxErr error
 	for i := 0; i < numSectors; i++ {
-		if ctx.Err() != nil {
-			var zero Result
-			return zero, ctx.Err()
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
 		}
 		pos := btrfsvol.PhysicalAddr(i * btrfssum.BlockSize)
 		stats.portion.N = pos
@@ -122,12 +170,29 @@ func ScanOneDevice[Stats comparable, Result any](ctx context.Context, dev *btrfs
 		}
 
 		if checkForNode {
-			node, err := btrfstree.ReadNode[btrfsvol.PhysicalAddr](dev, *sb, pos)
-			if err != nil {
+			// Checksum-valid garbage (say, a node image
+			// stored in a file) shouldn't be handed to
+			// the scanner; sanity-check the header before
+			// applying nodeFilter.
+			var headErr error
+			node, err := btrfstree.ReadNodeFiltered[btrfsvol.PhysicalAddr](dev, *sb, pos, func(head btrfstree.NodeHeader) bool {
+				if headErr = head.Validate(*sb); headErr != nil {
+					return false
+				}
+				return nodeFilter == nil || nodeFilter(head)
+			})
+			switch {
+			case headErr != nil:
+				dlog.Errorf(ctx, "error: node@%v: looks like a node but has an invalid header: %v", pos, headErr)
+			case errors.Is(err, btrfstree.ErrNodeFiltered):
+				// It's a valid node, just not one that we
+				// care about.
+				minNextNode = pos + btrfsvol.PhysicalAddr(sb.NodeSize)
+			case err != nil:
 				if !errors.Is(err, btrfstree.ErrNotANode) {
 					dlog.Errorf(ctx, "error: %v", err)
 				}
-			} else {
+			default:
 				if err := scanner.ScanNode(ctx, pos, node); err != nil {
 					var zero Result
 					return zero, err
@@ -138,10 +203,19 @@ func ScanOneDevice[Stats comparable, Result any](ctx context.Context, dev *btrfs
 		}
 	}
 
-	stats.portion.N = numBytes
+	if ctxErr == nil {
+		stats.portion.N = numBytes
+	}
 	stats.stats = scanner.ScanStats()
 	progressWriter.Set(stats)
 	progressWriter.Done()
 
+	if ctxErr != nil {
+		ret, err := scanner.ScanDone(dcontext.WithoutCancel(ctx))
+		if err != nil {
+			return ret, err
+		}
+		return ret, ctxErr
+	}
 	return scanner.ScanDone(ctx)
 }
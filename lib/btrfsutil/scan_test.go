@@ -0,0 +1,248 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+type sectorCounter struct {
+	cancelAt int
+	cancel   context.CancelFunc
+	n        int
+}
+
+func (s *sectorCounter) ScanStats() int { return s.n }
+
+func (s *sectorCounter) ScanSector(context.Context, *btrfs.Device, btrfsvol.PhysicalAddr) error {
+	s.n++
+	if s.n == s.cancelAt {
+		s.cancel()
+	}
+	return nil
+}
+
+func (*sectorCounter) ScanNode(context.Context, btrfsvol.PhysicalAddr, *btrfstree.Node) error {
+	return nil
+}
+
+func (s *sectorCounter) ScanDone(ctx context.Context) (int, error) {
+	// ScanDone should be able to do work even if the scan was
+	// cut short.
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.n, nil
+}
+
+func TestScanOneDevicePartial(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Self:         btrfs.SuperblockAddrs[0],
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     btrfssum.BlockSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	copy(sb.Magic[:], "_BHRfS_M")
+	var err error
+	sb.Checksum, err = sb.CalculateChecksum()
+	require.NoError(t, err)
+	sbDat, err := binstruct.Marshal(sb)
+	require.NoError(t, err)
+	img := make([]byte, 1024*1024)
+	file := diskio.NewMemFile[btrfsvol.PhysicalAddr](t.Name(), img)
+	copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+
+	counter := &sectorCounter{
+		cancelAt: 10,
+		cancel:   cancel,
+	}
+	n, err := btrfsutil.ScanOneDevice[int, int](ctx, &btrfs.Device{File: file}, nil,
+		func(context.Context, btrfstree.Superblock, btrfsvol.PhysicalAddr, int) btrfsutil.DeviceScanner[int, int] {
+			return counter
+		})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 10, n)
+}
+
+type sectorSummer struct {
+	n   int
+	sum uint64
+}
+
+func (s *sectorSummer) ScanStats() int { return s.n }
+
+func (s *sectorSummer) ScanSector(_ context.Context, dev *btrfs.Device, paddr btrfsvol.PhysicalAddr) error {
+	var buf [btrfssum.BlockSize]byte
+	if _, err := dev.ReadAt(buf[:], paddr); err != nil {
+		return err
+	}
+	s.n++
+	for _, b := range buf {
+		s.sum = s.sum*31 + uint64(b)
+	}
+	return nil
+}
+
+func (*sectorSummer) ScanNode(context.Context, btrfsvol.PhysicalAddr, *btrfstree.Node) error {
+	return nil
+}
+
+func (s *sectorSummer) ScanDone(context.Context) ([2]uint64, error) {
+	return [2]uint64{uint64(s.n), s.sum}, nil
+}
+
+func newSectorSummer(context.Context, btrfstree.Superblock, btrfsvol.PhysicalAddr, int) btrfsutil.DeviceScanner[int, [2]uint64] {
+	return &sectorSummer{}
+}
+
+func TestScanDevicesMatchesSerial(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	fs := new(btrfs.FS)
+	devs := make(map[btrfsvol.DeviceID]*btrfs.Device)
+	for devID, size := range map[btrfsvol.DeviceID]int{1: 1024 * 1024, 2: 2 * 1024 * 1024} {
+		sb := btrfstree.Superblock{
+			FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+			Self:         btrfs.SuperblockAddrs[0],
+			SectorSize:   btrfssum.BlockSize,
+			NodeSize:     btrfssum.BlockSize,
+			ChecksumType: btrfssum.TYPE_CRC32,
+			NumDevices:   2,
+		}
+		sb.DevItem.DevID = devID
+		copy(sb.Magic[:], "_BHRfS_M")
+		var err error
+		sb.Checksum, err = sb.CalculateChecksum()
+		require.NoError(t, err)
+		sbDat, err := binstruct.Marshal(sb)
+		require.NoError(t, err)
+		img := make([]byte, size)
+		file := diskio.NewMemFile[btrfsvol.PhysicalAddr](fmt.Sprintf("%v-%v", t.Name(), devID), img)
+		for i := range img {
+			img[i] = byte(i / btrfssum.BlockSize * int(devID))
+		}
+		copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+		dev := &btrfs.Device{File: file}
+		require.NoError(t, fs.AddDevice(ctx, dev))
+		devs[devID] = dev
+	}
+
+	exp := make(map[btrfsvol.DeviceID][2]uint64)
+	for devID, dev := range devs {
+		devResult, err := btrfsutil.ScanOneDevice[int, [2]uint64](ctx, dev, nil, newSectorSummer)
+		require.NoError(t, err)
+		exp[devID] = devResult
+	}
+	assert.NotEqual(t, exp[1], exp[2])
+
+	for _, numWorkers := range []int{0, 1, 2} {
+		act, err := btrfsutil.ScanDevices[int, [2]uint64](ctx, fs, numWorkers, nil, newSectorSummer)
+		require.NoError(t, err, "numWorkers=%v", numWorkers)
+		assert.Equal(t, exp, act, "numWorkers=%v", numWorkers)
+	}
+}
+
+type nodeRecorder struct {
+	addrs []btrfsvol.PhysicalAddr
+}
+
+func (s *nodeRecorder) ScanStats() int { return len(s.addrs) }
+
+func (*nodeRecorder) ScanSector(context.Context, *btrfs.Device, btrfsvol.PhysicalAddr) error {
+	return nil
+}
+
+func (s *nodeRecorder) ScanNode(_ context.Context, paddr btrfsvol.PhysicalAddr, _ *btrfstree.Node) error {
+	s.addrs = append(s.addrs, paddr)
+	return nil
+}
+
+func (s *nodeRecorder) ScanDone(context.Context) ([]btrfsvol.PhysicalAddr, error) {
+	return s.addrs, nil
+}
+
+func TestScanOneDeviceSkipsInvalidHeaders(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	sb := btrfstree.Superblock{
+		FSUUID:       btrfsprim.MustParseUUID("a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90"),
+		Self:         btrfs.SuperblockAddrs[0],
+		SectorSize:   btrfssum.BlockSize,
+		NodeSize:     btrfssum.BlockSize,
+		ChecksumType: btrfssum.TYPE_CRC32,
+	}
+	copy(sb.Magic[:], "_BHRfS_M")
+	var err error
+	sb.Checksum, err = sb.CalculateChecksum()
+	require.NoError(t, err)
+	sbDat, err := binstruct.Marshal(sb)
+	require.NoError(t, err)
+	img := make([]byte, 1024*1024)
+	file := diskio.NewMemFile[btrfsvol.PhysicalAddr](t.Name(), img)
+	copy(img[btrfs.SuperblockAddrs[0]:], sbDat)
+
+	// putNode writes an empty leaf at `paddr`, lets `corrupt`
+	// scribble on its header, and then fixes up the checksum so
+	// that only the header sanity checks can reject it.
+	putNode := func(paddr btrfsvol.PhysicalAddr, corrupt func(dat []byte)) {
+		node := btrfstree.Node{
+			Size:         sb.NodeSize,
+			ChecksumType: sb.ChecksumType,
+			Head: btrfstree.NodeHeader{
+				MetadataUUID: sb.EffectiveMetadataUUID(),
+				Addr:         btrfsvol.LogicalAddr(paddr),
+				Generation:   1,
+				Owner:        btrfsprim.FS_TREE_OBJECTID,
+			},
+		}
+		dat, err := binstruct.Marshal(node)
+		require.NoError(t, err)
+		corrupt(dat)
+		csum, err := sb.ChecksumType.Sum(dat[binstruct.StaticSize(btrfssum.CSum{}):])
+		require.NoError(t, err)
+		copy(dat, csum[:])
+		copy(img[paddr:], dat)
+	}
+	putNode(0x40000, func([]byte) {})
+	putNode(0x50000, func(dat []byte) {
+		binary.LittleEndian.PutUint32(dat[0x60:], 100000) // NumItems
+	})
+	putNode(0x60000, func(dat []byte) {
+		binary.LittleEndian.PutUint64(dat[0x30:], 0x60123) // Addr
+	})
+	putNode(0x70000, func(dat []byte) {
+		dat[0x64] = btrfstree.MaxLevel + 1 // Level
+	})
+
+	nodes, err := btrfsutil.ScanOneDevice[int, []btrfsvol.PhysicalAddr](ctx, &btrfs.Device{File: file}, nil,
+		func(context.Context, btrfstree.Superblock, btrfsvol.PhysicalAddr, int) btrfsutil.DeviceScanner[int, []btrfsvol.PhysicalAddr] {
+			return new(nodeRecorder)
+		})
+	require.NoError(t, err)
+	assert.Equal(t, []btrfsvol.PhysicalAddr{0x40000}, nodes)
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/textui"
+)
+
+var superblockMagic = []byte("_BHRfS_M")
+
+// FoundSuperblock is a superblock-like sector found by
+// ScanForSuperblocks.
+type FoundSuperblock struct {
+	Addr       btrfsvol.PhysicalAddr
+	Superblock btrfstree.Superblock
+	// ChecksumErr is the result of .Superblock.ValidateChecksum();
+	// a non-nil value means that the sector has the superblock
+	// magic but is not intact (or that the checksum type is not
+	// one that we know how to compute).
+	ChecksumErr error
+}
+
+// IsMirror returns whether the superblock is at one of the standard
+// superblock-mirror locations (as opposed to being a stray copy left
+// behind by something else, such as a previous filesystem on a
+// partition that has since been moved).
+func (sb FoundSuperblock) IsMirror() bool {
+	for _, addr := range btrfs.SuperblockAddrs {
+		if sb.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+type sbScanStats struct {
+	portion  textui.Portion[btrfsvol.PhysicalAddr]
+	numFound int
+}
+
+func (s sbScanStats) String() string {
+	return textui.Sprintf("scanned %v (found: %v superblocks)",
+		s.portion, s.numFound)
+}
+
+// ScanForSuperblocks scans the device sector-by-sector looking for
+// anything that has the btrfs superblock magic number, and returns
+// all such sectors.  Unlike ScanOneDevice, it does not require that
+// the device's own superblock be readable.
+//
+// This is the superblock analog of ListNodes; it is useful for
+// identifying stale or foreign superblocks.
+func ScanForSuperblocks(ctx context.Context, dev *btrfs.Device) ([]FoundSuperblock, error) {
+	ctx = dlog.WithField(ctx, "scansuperblocks.dev", dev.Name())
+
+	numBytes := dev.Size()
+
+	progressWriter := textui.NewProgress[sbScanStats](ctx, dlog.LogLevelInfo, textui.Tunable(1*time.Second))
+	var stats sbScanStats
+	stats.portion.D = numBytes
+
+	var ret []FoundSuperblock
+	buf := make([]byte, btrfs.SuperblockSize)
+	magic := buf[0x40:][:len(superblockMagic)]
+	for pos := btrfsvol.PhysicalAddr(0); pos+btrfs.SuperblockSize <= numBytes; pos += btrfssum.BlockSize {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		stats.portion.N = pos
+		stats.numFound = len(ret)
+		progressWriter.Set(stats)
+
+		if _, err := dev.ReadAt(magic, pos+0x40); err != nil {
+			dlog.Errorf(ctx, "error: paddr=%v: %v", pos, err)
+			continue
+		}
+		if !bytes.Equal(magic, superblockMagic) {
+			continue
+		}
+		if _, err := dev.ReadAt(buf, pos); err != nil {
+			dlog.Errorf(ctx, "error: paddr=%v: %v", pos, err)
+			continue
+		}
+		found := FoundSuperblock{
+			Addr: pos,
+		}
+		if _, err := binstruct.Unmarshal(buf, &found.Superblock); err != nil {
+			dlog.Errorf(ctx, "error: paddr=%v: %v", pos, err)
+			continue
+		}
+		found.ChecksumErr = found.Superblock.ValidateChecksum()
+		ret = append(ret, found)
+	}
+
+	stats.portion.N = numBytes
+	stats.numFound = len(ret)
+	progressWriter.Set(stats)
+	progressWriter.Done()
+
+	return ret, nil
+}
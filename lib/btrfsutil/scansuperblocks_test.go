@@ -0,0 +1,69 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil_test
+
+import (
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/binstruct"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfssum"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestScanForSuperblocks(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	img := make([]byte, 3*1024*1024)
+	file := diskio.NewMemFile[btrfsvol.PhysicalAddr](t.Name(), img)
+	putSB := func(addr btrfsvol.PhysicalAddr, fsid string, gen btrfsprim.Generation, corrupt bool) {
+		sb := btrfstree.Superblock{
+			FSUUID:       btrfsprim.MustParseUUID(fsid),
+			Self:         addr,
+			Generation:   gen,
+			ChecksumType: btrfssum.TYPE_CRC32,
+		}
+		copy(sb.Magic[:], "_BHRfS_M")
+		var err error
+		sb.Checksum, err = sb.CalculateChecksum()
+		require.NoError(t, err)
+		dat, err := binstruct.Marshal(sb)
+		require.NoError(t, err)
+		if corrupt {
+			dat[len(dat)-1] ^= 0xff
+		}
+		copy(img[addr:], dat)
+	}
+	putSB(btrfs.SuperblockAddrs[0], "a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90", 10, false)
+	putSB(1024*1024, "00000000-0000-0000-0000-000000000001", 3, false)
+	putSB(2*1024*1024, "a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90", 9, true)
+
+	found, err := btrfsutil.ScanForSuperblocks(ctx, &btrfs.Device{File: file})
+	require.NoError(t, err)
+	require.Len(t, found, 3)
+
+	assert.Equal(t, btrfs.SuperblockAddrs[0], found[0].Addr)
+	assert.True(t, found[0].IsMirror())
+	assert.NoError(t, found[0].ChecksumErr)
+	assert.Equal(t, btrfsprim.Generation(10), found[0].Superblock.Generation)
+
+	assert.Equal(t, btrfsvol.PhysicalAddr(1024*1024), found[1].Addr)
+	assert.False(t, found[1].IsMirror())
+	assert.NoError(t, found[1].ChecksumErr)
+	assert.Equal(t, btrfsprim.MustParseUUID("00000000-0000-0000-0000-000000000001"), found[1].Superblock.FSUUID)
+
+	assert.Equal(t, btrfsvol.PhysicalAddr(2*1024*1024), found[2].Addr)
+	assert.False(t, found[2].IsMirror())
+	assert.Error(t, found[2].ChecksumErr)
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/datawire/dlib/dlog"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+)
+
+// UUIDTreeItem is an item that belongs in the UUID_TREE.
+type UUIDTreeItem struct {
+	Key btrfsprim.Key
+	// SubvolIDs are the IDs of the subvolumes that the key's UUID
+	// maps to, in ascending order; on disk, a UUID_TREE item is an
+	// array of them.  A UUID_SUBVOL item always has exactly one,
+	// but several subvolumes may have been received from the same
+	// UUID, so a UUID_RECEIVED_SUBVOL item may have more.
+	SubvolIDs []btrfsprim.ObjID
+}
+
+// RebuildUUIDTree reconstructs the contents of the UUID_TREE from the
+// ROOT_ITEMs in the ROOT_TREE, which carry both the UUID of each
+// subvolume (for UUID_SUBVOL items) and the UUID that each subvolume
+// was received from (for UUID_RECEIVED_SUBVOL items).
+//
+// The returned items are sorted by key.  Errors reading the ROOT_TREE
+// are returned, but only after the items that could be read.
+func RebuildUUIDTree(ctx context.Context, fs btrfs.ReadableFS) ([]UUIDTreeItem, error) {
+	rootTree, err := fs.ForrestLookup(ctx, btrfsprim.ROOT_TREE_OBJECTID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[btrfsprim.Key][]btrfsprim.ObjID)
+	add := func(typ btrfsprim.ItemType, uuid btrfsprim.UUID, treeID btrfsprim.ObjID) {
+		if uuid == (btrfsprim.UUID{}) {
+			return
+		}
+		key := btrfsitem.UUIDToKey(uuid)
+		key.ItemType = typ
+		if others := items[key]; typ == btrfsitem.UUID_SUBVOL_KEY && len(others) > 0 {
+			// A subvolume's own UUID should be unique.
+			dlog.Errorf(ctx, "%v %v: claimed by both tree %v and tree %v; using %v",
+				typ, uuid, others[0], treeID, others[0])
+			return
+		}
+		items[key] = append(items[key], treeID)
+	}
+
+	err = rootTree.TreeRange(ctx, func(item btrfstree.Item) bool {
+		if item.Key.ItemType != btrfsitem.ROOT_ITEM_KEY {
+			return true
+		}
+		switch body := item.Body.(type) {
+		case *btrfsitem.Root:
+			add(btrfsitem.UUID_SUBVOL_KEY, body.UUID, item.Key.ObjectID)
+			add(btrfsitem.UUID_RECEIVED_SUBVOL_KEY, body.ReceivedUUID, item.Key.ObjectID)
+		case *btrfsitem.Error:
+			dlog.Errorf(ctx, "ROOT_ITEM %v: %v", item.Key, body.Err)
+		default:
+			// This is a panic because the item decoder should not emit ROOT_ITEM items as anything but
+			// btrfsitem.Root or btrfsitem.Error without this code also being updated.
+			panic(fmt.Errorf("should not happen: ROOT_ITEM item has unexpected type: %T", body))
+		}
+		return true
+	})
+
+	ret := make([]UUIDTreeItem, 0, len(items))
+	for key, treeIDs := range items {
+		sort.Slice(treeIDs, func(i, j int) bool {
+			return treeIDs[i] < treeIDs[j]
+		})
+		ret = append(ret, UUIDTreeItem{
+			Key:       key,
+			SubvolIDs: treeIDs,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key.Compare(ret[j].Key) < 0
+	})
+
+	if err != nil {
+		return ret, fmt.Errorf("ROOT_TREE: %w", err)
+	}
+	return ret, nil
+}
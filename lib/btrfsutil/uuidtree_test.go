@@ -0,0 +1,94 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package btrfsutil_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsitem"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsprim"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfstree"
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfsutil"
+)
+
+// rootItemsFS is a btrfs.ReadableFS whose only tree is a ROOT_TREE
+// that is a flat list of items.
+type rootItemsFS struct {
+	btrfs.ReadableFS
+	rootTree []btrfstree.Item
+}
+
+func (fs rootItemsFS) ForrestLookup(_ context.Context, treeID btrfsprim.ObjID) (btrfstree.Tree, error) {
+	if treeID != btrfsprim.ROOT_TREE_OBJECTID {
+		return nil, fmt.Errorf("tree %v: %w", treeID, btrfstree.ErrNoTree)
+	}
+	return rootItemsTree{items: fs.rootTree}, nil
+}
+
+type rootItemsTree struct {
+	btrfstree.Tree
+	items []btrfstree.Item
+}
+
+func (tree rootItemsTree) TreeRange(_ context.Context, handleFn func(btrfstree.Item) bool) error {
+	for _, item := range tree.items {
+		if !handleFn(item) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestRebuildUUIDTree(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	uuidA := btrfsprim.MustParseUUID("0000000a-0000-0000-0000-000000000000")
+	uuidB := btrfsprim.MustParseUUID("0000000b-0000-0000-0000-000000000000")
+	uuidC := btrfsprim.MustParseUUID("0000000c-0000-0000-0000-000000000000")
+	uuidD := btrfsprim.MustParseUUID("0000000d-0000-0000-0000-000000000000")
+	uuidR := btrfsprim.MustParseUUID("000000ff-0000-0000-0000-000000000000")
+
+	root := func(treeID btrfsprim.ObjID, uuid, receivedUUID btrfsprim.UUID) btrfstree.Item {
+		return btrfstree.Item{
+			Key: btrfsprim.Key{ObjectID: treeID, ItemType: btrfsitem.ROOT_ITEM_KEY},
+			Body: &btrfsitem.Root{
+				UUID:         uuid,
+				ReceivedUUID: receivedUUID,
+			},
+		}
+	}
+	rootTree := []btrfstree.Item{
+		root(btrfsprim.FS_TREE_OBJECTID, uuidA, btrfsprim.UUID{}),
+		// Two subvolumes received from the same snapshot.
+		root(257, uuidB, uuidR),
+		root(258, uuidC, uuidR),
+		// A duplicate UUID_SUBVOL; the first one wins.
+		root(259, uuidD, btrfsprim.UUID{}),
+		root(260, uuidD, btrfsprim.UUID{}),
+	}
+
+	key := func(typ btrfsprim.ItemType, uuid btrfsprim.UUID) btrfsprim.Key {
+		key := btrfsitem.UUIDToKey(uuid)
+		key.ItemType = typ
+		return key
+	}
+	items, err := btrfsutil.RebuildUUIDTree(ctx, rootItemsFS{rootTree: rootTree})
+	require.NoError(t, err)
+	assert.Equal(t, []btrfsutil.UUIDTreeItem{
+		{Key: key(btrfsitem.UUID_SUBVOL_KEY, uuidA), SubvolIDs: []btrfsprim.ObjID{btrfsprim.FS_TREE_OBJECTID}},
+		{Key: key(btrfsitem.UUID_SUBVOL_KEY, uuidB), SubvolIDs: []btrfsprim.ObjID{257}},
+		{Key: key(btrfsitem.UUID_SUBVOL_KEY, uuidC), SubvolIDs: []btrfsprim.ObjID{258}},
+		{Key: key(btrfsitem.UUID_SUBVOL_KEY, uuidD), SubvolIDs: []btrfsprim.ObjID{259}},
+		{Key: key(btrfsitem.UUID_RECEIVED_SUBVOL_KEY, uuidR), SubvolIDs: []btrfsprim.ObjID{257, 258}},
+	}, items)
+}
@@ -220,8 +220,13 @@ type arCache[K comparable, V any] struct {
 
 	// For blocking related to pinning.
 	waiters LinkedList[chan struct{}]
+
+	// For observability.
+	stats CacheStats
 }
 
+var _ CacheWithStats[int, string] = (*arCache[int, string])(nil)
+
 // Algorithms:
 //
 //   Now that all of our data structures are defined, let's get into
@@ -365,6 +370,7 @@ func (c *arCache[K, V]) dblReplace() *LinkedListEntry[arcLiveEntry[K, V]] {
 			entry := c.recentLive.Oldest
 			c.recentLive.Delete(entry)
 			delete(c.liveByName, entry.Value.key)
+			c.stats.Evictions++
 			return entry
 		default: // case !c.recentPinned.IsEmpty(): // top
 
@@ -473,6 +479,7 @@ func (c *arCache[K, V]) arcReplace(ghostEntry *LinkedListEntry[arcGhostEntry[K]]
 	// Evict.
 	delete(c.liveByName, entry.Value.key)
 	evictFrom.Delete(entry)
+	c.stats.Evictions++
 	// Record the eviction.
 	ghostEntry.Value.key = entry.Value.key
 	evictTo.Store(ghostEntry)
@@ -503,6 +510,7 @@ func (c *arCache[K, V]) Acquire(ctx context.Context, k K) *V {
 	var entry *LinkedListEntry[arcLiveEntry[K, V]]
 	switch {
 	case c.liveByName[k] != nil: // cache-hit
+		c.stats.Hits++
 		entry = c.liveByName[k]
 		// Move to frequentPinned, unless:
 		//
@@ -515,6 +523,7 @@ func (c *arCache[K, V]) Acquire(ctx context.Context, k K) *V {
 		}
 		entry.Value.refs++
 	case c.ghostByName[k] != nil: // cache-miss, but would have been a cache-hit in DBL(2c)
+		c.stats.Misses++
 		ghostEntry := c.ghostByName[k]
 		// Adapt.
 		switch ghostEntry.List {
@@ -538,6 +547,7 @@ func (c *arCache[K, V]) Acquire(ctx context.Context, k K) *V {
 		c.frequentPinned.Store(entry)
 		c.liveByName[k] = entry
 	default: // cache-miss, and would have even been a cache-miss in DBL(2c)
+		c.stats.Misses++
 		// Replace.
 		entry = c.dblReplace()
 		entry.Value.key = k
@@ -628,6 +638,16 @@ func (c *arCache[K, V]) Flush(ctx context.Context) {
 	}
 }
 
+// Stats implements the 'CacheWithStats' interface.
+func (c *arCache[K, V]) Stats() CacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := c.stats
+	ret.Size = len(c.liveByName)
+	return ret
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -707,3 +707,27 @@ func TestARC_Peek(t *testing.T) {
 		t.Errorf("should not have updated recent-ness of 1")
 	}
 }
+
+func TestARCStats(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	cache := NewARCache[int, int](2,
+		SourceFunc[int, int](func(_ context.Context, k int, v *int) { *v = k * k })).(CacheWithStats[int, int])
+	get := func(k int) {
+		cache.Acquire(ctx, k)
+		cache.Release(k)
+	}
+
+	require.Equal(t, CacheStats{}, cache.Stats())
+	get(1) // miss
+	get(2) // miss
+	get(1) // hit
+	require.Equal(t, CacheStats{Hits: 1, Misses: 2, Evictions: 0, Size: 2}, cache.Stats())
+	get(3) // miss, evicts 2 (1 is frequent)
+	get(1) // hit
+	get(2) // miss (but a ghost hit), evicts 1 or 3
+	require.Equal(t, CacheStats{Hits: 2, Misses: 4, Evictions: 2, Size: 2}, cache.Stats())
+	cache.Delete(2)
+	require.Equal(t, CacheStats{Hits: 2, Misses: 4, Evictions: 2, Size: 1}, cache.Stats())
+}
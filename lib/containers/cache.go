@@ -54,6 +54,22 @@ type Cache[K comparable, V any] interface {
 	Flush(context.Context)
 }
 
+// CacheStats is a snapshot of the counters accumulated by a
+// CacheWithStats.
+type CacheStats struct {
+	Hits      int // Acquire calls that found the entry in the cache
+	Misses    int // Acquire calls that had to Load the entry
+	Evictions int // entries removed to make room for another entry
+	Size      int // number of entries currently in the cache
+}
+
+// CacheWithStats is a Cache that keeps track of how effective it is.
+// All of the Cache implementations in this package implement it.
+type CacheWithStats[K comparable, V any] interface {
+	Cache[K, V]
+	Stats() CacheStats
+}
+
 // SourceFunc implements Source.  Load calls the function, and Flush
 // is a no-op.
 type SourceFunc[K comparable, V any] func(context.Context, K, *V)
@@ -154,3 +154,31 @@ func (t *IntervalTree[K, V]) subrange(node *RBNode[intervalValue[K, V]], rangeFn
 	}
 	return true
 }
+
+// Stab returns every value whose interval contains point (inclusive
+// of both ends), in order of ascending interval.
+func (t *IntervalTree[K, V]) Stab(point K) []V {
+	var ret []V
+	t.stab(t.inner.root, point, &ret)
+	return ret
+}
+
+func (t *IntervalTree[K, V]) stab(node *RBNode[intervalValue[K, V]], point K, ret *[]V) {
+	if node == nil {
+		return
+	}
+	if point.Compare(node.Value.ChildSpan.Min) < 0 || point.Compare(node.Value.ChildSpan.Max) > 0 {
+		return
+	}
+	t.stab(node.Left, point, ret)
+	if point.Compare(node.Value.ValSpan.Min) < 0 {
+		// Everything to the right starts at or after this
+		// node's interval does, so can't contain the point
+		// either.
+		return
+	}
+	if point.Compare(node.Value.ValSpan.Max) <= 0 {
+		*ret = append(*ret, node.Value.Val)
+	}
+	t.stab(node.Right, point, ret)
+}
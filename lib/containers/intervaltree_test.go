@@ -86,3 +86,48 @@ func TestIntervalTree(t *testing.T) {
 		},
 		intervals)
 }
+
+func TestIntervalTreeStab(t *testing.T) {
+	t.Parallel()
+	tree := IntervalTree[NativeOrdered[int], SimpleInterval]{
+		MinFn: func(ival SimpleInterval) NativeOrdered[int] { return NativeOrdered[int]{ival.Min} },
+		MaxFn: func(ival SimpleInterval) NativeOrdered[int] { return NativeOrdered[int]{ival.Max} },
+	}
+	assert.Nil(t, tree.Stab(NativeOrdered[int]{5}))
+
+	// nested
+	tree.Insert(SimpleInterval{0, 100})
+	tree.Insert(SimpleInterval{10, 50})
+	tree.Insert(SimpleInterval{20, 30})
+	tree.Insert(SimpleInterval{25, 25})
+	// adjacent
+	tree.Insert(SimpleInterval{200, 209})
+	tree.Insert(SimpleInterval{210, 219})
+	tree.Insert(SimpleInterval{220, 229})
+
+	t.Log("\n" + tree.ASCIIArt())
+
+	type TestCase struct {
+		Point int
+		Exp   []SimpleInterval
+	}
+	testcases := map[string]TestCase{
+		"before-all":     {-1, nil},
+		"outer-only":     {5, []SimpleInterval{{0, 100}}},
+		"nested-edge":    {10, []SimpleInterval{{0, 100}, {10, 50}}},
+		"nested-deepest": {25, []SimpleInterval{{0, 100}, {10, 50}, {20, 30}, {25, 25}}},
+		"nested-after":   {31, []SimpleInterval{{0, 100}, {10, 50}}},
+		"outer-end":      {100, []SimpleInterval{{0, 100}}},
+		"gap":            {150, nil},
+		"adjacent-end":   {209, []SimpleInterval{{200, 209}}},
+		"adjacent-beg":   {210, []SimpleInterval{{210, 219}}},
+		"after-all":      {230, nil},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Exp, tree.Stab(NativeOrdered[int]{tc.Point}))
+		})
+	}
+}
@@ -56,8 +56,12 @@ type lruCache[K comparable, V any] struct {
 	byName    map[K]*LinkedListEntry[lruEntry[K, V]]
 
 	waiters LinkedList[chan struct{}]
+
+	stats CacheStats
 }
 
+var _ CacheWithStats[int, string] = (*lruCache[int, string])(nil)
+
 // Blocking primitives /////////////////////////////////////////////////////////
 
 // waitForAvail is called before storing something into the cache.
@@ -131,6 +135,7 @@ func (c *lruCache[K, V]) lruReplace() *LinkedListEntry[lruEntry[K, V]] {
 	entry := c.evictable.Oldest
 	c.evictable.Delete(entry)
 	delete(c.byName, entry.Value.key)
+	c.stats.Evictions++
 	return entry
 }
 
@@ -141,11 +146,13 @@ func (c *lruCache[K, V]) Acquire(ctx context.Context, k K) *V {
 
 	entry := c.byName[k]
 	if entry != nil {
+		c.stats.Hits++
 		if entry.Value.refs == 0 {
 			c.evictable.Delete(entry)
 		}
 		entry.Value.refs++
 	} else {
+		c.stats.Misses++
 		entry = c.lruReplace()
 
 		entry.Value.key = k
@@ -218,3 +225,13 @@ func (c *lruCache[K, V]) Flush(ctx context.Context) {
 		c.src.Flush(ctx, &entry.Value.val)
 	}
 }
+
+// Stats implements the 'CacheWithStats' interface.
+func (c *lruCache[K, V]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ret := c.stats
+	ret.Size = len(c.byName)
+	return ret
+}
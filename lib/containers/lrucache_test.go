@@ -99,3 +99,27 @@ func TestLRUAllocs(t *testing.T) {
 		}
 	}
 }
+
+func TestLRUStats(t *testing.T) {
+	t.Parallel()
+	ctx := dlog.NewTestContext(t, false)
+
+	cache := NewLRUCache[int, int](2,
+		SourceFunc[int, int](func(_ context.Context, k int, v *int) { *v = k * k })).(CacheWithStats[int, int])
+	get := func(k int) {
+		cache.Acquire(ctx, k)
+		cache.Release(k)
+	}
+
+	assert.Equal(t, CacheStats{}, cache.Stats())
+	get(1) // miss
+	get(2) // miss
+	get(1) // hit
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Evictions: 0, Size: 2}, cache.Stats())
+	get(3) // miss, evicts 2
+	get(1) // hit
+	get(2) // miss, evicts 3
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 4, Evictions: 2, Size: 2}, cache.Stats())
+	cache.Delete(1)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 4, Evictions: 2, Size: 1}, cache.Stats())
+}
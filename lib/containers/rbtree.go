@@ -41,6 +41,30 @@ func (t *RBTree[T]) Len() int {
 	return t.len
 }
 
+// clone returns a copy of the tree with the same shape (so it is
+// O(n), not O(n log n)) that shares no nodes with the original.
+func (t *RBTree[T]) clone() RBTree[T] {
+	return RBTree[T]{
+		AttrFn: t.AttrFn,
+		root:   t.root.clone(nil),
+		len:    t.len,
+	}
+}
+
+func (node *RBNode[T]) clone(parent *RBNode[T]) *RBNode[T] {
+	if node == nil {
+		return nil
+	}
+	ret := &RBNode[T]{
+		Parent: parent,
+		Color:  node.Color,
+		Value:  node.Value,
+	}
+	ret.Left = node.Left.clone(ret)
+	ret.Right = node.Right.clone(ret)
+	return ret
+}
+
 func (t *RBTree[T]) Range(fn func(*RBNode[T]) bool) {
 	t.root._range(fn)
 }
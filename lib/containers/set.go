@@ -103,6 +103,19 @@ func NewSet[T comparable](values ...T) Set[T] {
 	return ret
 }
 
+// Clone returns a copy of the set that may be mutated without
+// affecting the original.
+func (o Set[T]) Clone() Set[T] {
+	if o == nil {
+		return nil
+	}
+	ret := make(Set[T], len(o))
+	for v := range o {
+		ret[v] = struct{}{}
+	}
+	return ret
+}
+
 func (o Set[T]) Insert(v T) {
 	o[v] = struct{}{}
 }
@@ -145,6 +158,16 @@ func (a Set[T]) HasAny(b Set[T]) bool {
 	return maps.HaveAnyKeysInCommon(a, b)
 }
 
+// Union returns a new set containing every member of either a or b.
+func (a Set[T]) Union(b Set[T]) Set[T] {
+	ret := make(Set[T], len(a)+len(b))
+	ret.InsertFrom(a)
+	ret.InsertFrom(b)
+	return ret
+}
+
+// Intersection returns a new set containing only the members that
+// are in both sets.
 func (small Set[T]) Intersection(big Set[T]) Set[T] {
 	if len(big) < len(small) {
 		small, big = big, small
@@ -157,3 +180,25 @@ func (small Set[T]) Intersection(big Set[T]) Set[T] {
 	}
 	return ret
 }
+
+// IntersectWith is the in-place version of Intersection; it removes
+// from o every member that is not in p.
+func (o Set[T]) IntersectWith(p Set[T]) {
+	for v := range o {
+		if !maps.HasKey(p, v) {
+			delete(o, v)
+		}
+	}
+}
+
+// Difference returns a new set containing the members of a that are
+// not in b.
+func (a Set[T]) Difference(b Set[T]) Set[T] {
+	ret := make(Set[T])
+	for v := range a {
+		if !maps.HasKey(b, v) {
+			ret.Insert(v)
+		}
+	}
+	return ret
+}
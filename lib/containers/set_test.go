@@ -0,0 +1,109 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package containers_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func TestSetClone(t *testing.T) {
+	t.Parallel()
+
+	orig := containers.NewSet(1, 2, 3)
+	clone := orig.Clone()
+	assert.Equal(t, orig, clone)
+
+	clone.Insert(4)
+	clone.Delete(1)
+	assert.Equal(t, containers.NewSet(1, 2, 3), orig)
+	assert.Equal(t, containers.NewSet(2, 3, 4), clone)
+
+	assert.Nil(t, containers.Set[int](nil).Clone())
+}
+
+func TestSetOps(t *testing.T) {
+	t.Parallel()
+	type TestCase struct {
+		A, B         containers.Set[int]
+		Union        containers.Set[int]
+		Intersection containers.Set[int]
+		Difference   containers.Set[int]
+	}
+	self := containers.NewSet(1, 2, 3)
+	testcases := map[string]TestCase{
+		"empty": {
+			A:            containers.NewSet[int](),
+			B:            containers.NewSet[int](),
+			Union:        containers.NewSet[int](),
+			Intersection: containers.NewSet[int](),
+			Difference:   containers.NewSet[int](),
+		},
+		"nil": {
+			A:            nil,
+			B:            containers.NewSet(1),
+			Union:        containers.NewSet(1),
+			Intersection: containers.NewSet[int](),
+			Difference:   containers.NewSet[int](),
+		},
+		"empty-nonempty": {
+			A:            containers.NewSet(1, 2),
+			B:            containers.NewSet[int](),
+			Union:        containers.NewSet(1, 2),
+			Intersection: containers.NewSet[int](),
+			Difference:   containers.NewSet(1, 2),
+		},
+		"disjoint": {
+			A:            containers.NewSet(1, 2),
+			B:            containers.NewSet(3, 4),
+			Union:        containers.NewSet(1, 2, 3, 4),
+			Intersection: containers.NewSet[int](),
+			Difference:   containers.NewSet(1, 2),
+		},
+		"overlapping": {
+			A:            containers.NewSet(1, 2, 3),
+			B:            containers.NewSet(2, 3, 4),
+			Union:        containers.NewSet(1, 2, 3, 4),
+			Intersection: containers.NewSet(2, 3),
+			Difference:   containers.NewSet(1),
+		},
+		"self": {
+			A:            self,
+			B:            self,
+			Union:        containers.NewSet(1, 2, 3),
+			Intersection: containers.NewSet(1, 2, 3),
+			Difference:   containers.NewSet[int](),
+		},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			origA, origB := tc.A.Clone(), tc.B.Clone()
+
+			assert.Equal(t, tc.Union, tc.A.Union(tc.B))
+			assert.Equal(t, tc.Intersection, tc.A.Intersection(tc.B))
+			assert.Equal(t, tc.Difference, tc.A.Difference(tc.B))
+			assert.Equal(t, origA, tc.A)
+			assert.Equal(t, origB, tc.B)
+
+			if tc.A != nil {
+				inPlace := tc.A.Clone()
+				inPlace.IntersectWith(tc.B)
+				assert.Equal(t, tc.Intersection, inPlace)
+			}
+		})
+	}
+}
+
+func TestSetIntersectWithSelf(t *testing.T) {
+	t.Parallel()
+	set := containers.NewSet(1, 2, 3)
+	set.IntersectWith(set)
+	assert.Equal(t, containers.NewSet(1, 2, 3), set)
+}
@@ -74,6 +74,41 @@ func (m *SortedMap[K, V]) Search(fn func(K, V) int) (K, V, bool) {
 	return node.Value.K, node.Value.V, true
 }
 
+// SortedMapIterator is a pull-style iterator over a SortedMap; see
+// (*SortedMap).Iterator.
+type SortedMapIterator[K Ordered[K], V any] struct {
+	next *RBNode[orderedKV[K, V]]
+}
+
+// Iterator returns an iterator that yields the map's entries in
+// ascending key order.  The map must not be modified while the
+// iterator is in use.
+func (m *SortedMap[K, V]) Iterator() *SortedMapIterator[K, V] {
+	return &SortedMapIterator[K, V]{
+		next: m.inner.Min(),
+	}
+}
+
+// Next returns the next entry, or ok=false if the end of the map has
+// been reached.
+func (it *SortedMapIterator[K, V]) Next() (key K, value V, ok bool) {
+	if it.next == nil {
+		return key, value, false
+	}
+	node := it.next
+	it.next = node.Next()
+	return node.Value.K, node.Value.V, true
+}
+
+// Clone returns a shallow copy of the map; keys and values are copied
+// as if by assignment, but the copy's structure may be mutated
+// without affecting the original.
+func (m *SortedMap[K, V]) Clone() *SortedMap[K, V] {
+	return &SortedMap[K, V]{
+		inner: m.inner.clone(),
+	}
+}
+
 func (m *SortedMap[K, V]) Len() int {
 	return m.inner.Len()
 }
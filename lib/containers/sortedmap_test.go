@@ -0,0 +1,109 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package containers_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/containers"
+)
+
+func sortedMapToMap[K interface {
+	comparable
+	containers.Ordered[K]
+}, V any](m *containers.SortedMap[K, V]) map[K]V {
+	ret := make(map[K]V, m.Len())
+	m.Range(func(k K, v V) bool {
+		ret[k] = v
+		return true
+	})
+	return ret
+}
+
+func TestSortedMapClone(t *testing.T) {
+	t.Parallel()
+	type K = containers.NativeOrdered[int]
+
+	orig := new(containers.SortedMap[K, string])
+	for i := 0; i < 20; i++ {
+		orig.Store(K{Val: i}, "orig")
+	}
+	expOrig := sortedMapToMap(orig)
+
+	clone := orig.Clone()
+	assert.Equal(t, expOrig, sortedMapToMap(clone))
+
+	for i := 0; i < 20; i += 2 {
+		clone.Delete(K{Val: i})
+	}
+	for i := 20; i < 40; i++ {
+		clone.Store(K{Val: i}, "clone")
+	}
+	clone.Store(K{Val: 1}, "clone")
+
+	assert.Equal(t, expOrig, sortedMapToMap(orig))
+	assert.Equal(t, 20, orig.Len())
+
+	assert.Equal(t, 30, clone.Len())
+	v, ok := clone.Load(K{Val: 1})
+	assert.True(t, ok)
+	assert.Equal(t, "clone", v)
+	assert.False(t, clone.Has(K{Val: 2}))
+	assert.True(t, clone.Has(K{Val: 39}))
+	var keys []int
+	clone.Range(func(k K, _ string) bool {
+		keys = append(keys, k.Val)
+		return true
+	})
+	assert.IsIncreasing(t, keys)
+}
+
+func TestSortedMapIterator(t *testing.T) {
+	t.Parallel()
+	type K = containers.NativeOrdered[int]
+
+	m := new(containers.SortedMap[K, string])
+	for _, i := range []int{5, 3, 8, 1, 9, 2, 7} {
+		m.Store(K{Val: i}, fmt.Sprint(i))
+	}
+
+	var keys []int
+	it := m.Iterator()
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		assert.Equal(t, fmt.Sprint(k.Val), v)
+		keys = append(keys, k.Val)
+	}
+	assert.Equal(t, []int{1, 2, 3, 5, 7, 8, 9}, keys)
+
+	// Once exhausted, it stays exhausted.
+	_, _, ok := it.Next()
+	assert.False(t, ok)
+
+	// Iterators are independent of each other.
+	it1, it2 := m.Iterator(), m.Iterator()
+	_, _, _ = it1.Next()
+	k1, _, _ := it1.Next()
+	k2, _, _ := it2.Next()
+	assert.Equal(t, 2, k1.Val)
+	assert.Equal(t, 1, k2.Val)
+}
+
+func TestSortedMapIteratorEmpty(t *testing.T) {
+	t.Parallel()
+	type K = containers.NativeOrdered[int]
+
+	m := new(containers.SortedMap[K, string])
+	k, v, ok := m.Iterator().Next()
+	assert.False(t, ok)
+	assert.Equal(t, K{}, k)
+	assert.Equal(t, "", v)
+}
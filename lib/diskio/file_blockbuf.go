@@ -31,6 +31,10 @@ type bufferedFile[A ~int64] struct {
 
 var _ File[assertAddr] = (*bufferedFile[assertAddr])(nil)
 
+// NewBufferedFile wraps a File with a cache of up to cacheSize
+// blockSize-aligned blocks; reads and writes that fall within a
+// cached block do not touch the underlying File.  Writes are held
+// until the block is evicted or the file is flushed or closed.
 func NewBufferedFile[A ~int64](ctx context.Context, file File[A], blockSize A, cacheSize int) *bufferedFile[A] {
 	ret := &bufferedFile[A]{
 		ctx:       ctx,
@@ -68,7 +72,13 @@ func (src bufferedBlockSource[A]) Load(ctx context.Context, blockAddr A, block *
 
 func (bf *bufferedFile[A]) Name() string { return bf.inner.Name() }
 func (bf *bufferedFile[A]) Size() A      { return bf.inner.Size() }
-func (bf *bufferedFile[A]) Close() error { return bf.inner.Close() }
+
+// Close flushes any dirty blocks to the underlying file, then closes
+// it.
+func (bf *bufferedFile[A]) Close() error {
+	bf.Flush()
+	return bf.inner.Close()
+}
 
 func (bf *bufferedFile[A]) Flush() {
 	bf.blockCache.Flush(bf.ctx)
@@ -0,0 +1,121 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio_test
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestBufferedFileCloseFlushes(t *testing.T) {
+	t.Parallel()
+	dat := []byte("0123456789abcdef")
+	inner := diskio.NewMemFile[int64](t.Name(), dat)
+	file := diskio.NewBufferedFile[int64](context.Background(), inner, 4, 2)
+
+	n, err := file.WriteAt([]byte("XY"), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "0123456789abcdef", string(dat))
+
+	assert.NoError(t, file.Close())
+	assert.Equal(t, "01234XY789abcdef", string(dat))
+}
+
+func getRand(tb testing.TB, limit int64) int64 {
+	tb.Helper()
+	out, err := rand.Int(rand.Reader, big.NewInt(limit))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return out.Int64()
+}
+
+func TestBufferedFileRandomReads(t *testing.T) {
+	t.Parallel()
+	dat := make([]byte, 64*1024)
+	_, err := rand.Read(dat)
+	require.NoError(t, err)
+	// Few enough blocks that reads are a mix of hits and misses.
+	file := diskio.NewBufferedFile[int64](context.Background(), diskio.NewMemFile[int64](t.Name(), dat), 4096, 4)
+
+	for i := 0; i < 1000; i++ {
+		off := getRand(t, int64(len(dat)))
+		size := getRand(t, int64(len(dat))-off) + 1
+		buf := make([]byte, size)
+		n, err := file.ReadAt(buf, off)
+		require.NoError(t, err, "off=%v size=%v", off, size)
+		require.Equal(t, int(size), n, "off=%v size=%v", off, size)
+		require.Equal(t, dat[off:off+size], buf, "off=%v size=%v", off, size)
+	}
+}
+
+func TestBufferedFileCacheHit(t *testing.T) {
+	t.Parallel()
+	stats := diskio.NewStatsFile[int64](diskio.NewMemFile[int64](t.Name(), make([]byte, 16*1024)))
+	file := diskio.NewBufferedFile[int64](context.Background(), stats, 4096, 4)
+
+	buf := make([]byte, 512)
+	for off := int64(0); off < 4096; off += int64(len(buf)) {
+		_, err := file.ReadAt(buf, off)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), stats.Stats().ReadCalls)
+
+	_, err := file.ReadAt(buf, 4096)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Stats().ReadCalls)
+}
+
+// benchmarkSectorScan mimics btrfsutil.ScanOneDevice's access
+// pattern: reading a node-header-sized chunk from the start of every
+// sector.
+func benchmarkSectorScan(b *testing.B, wrap func(diskio.File[int64]) diskio.File[int64]) {
+	const (
+		sectorSize = 4096
+		headerSize = 101
+		fileSize   = 16 * 1024 * 1024
+	)
+	filename := filepath.Join(b.TempDir(), "img")
+	require.NoError(b, os.WriteFile(filename, make([]byte, fileSize), 0o600))
+	osFile, err := os.Open(filename)
+	require.NoError(b, err)
+	file := wrap(&diskio.OSFile[int64]{File: osFile})
+	defer func() {
+		assert.NoError(b, file.Close())
+	}()
+
+	buf := make([]byte, headerSize)
+	b.SetBytes(fileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for off := int64(0); off < fileSize; off += sectorSize {
+			if _, err := file.ReadAt(buf, off); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkSectorScanUncached(b *testing.B) {
+	benchmarkSectorScan(b, func(file diskio.File[int64]) diskio.File[int64] {
+		return file
+	})
+}
+
+func BenchmarkSectorScanCached(b *testing.B) {
+	benchmarkSectorScan(b, func(file diskio.File[int64]) diskio.File[int64] {
+		return diskio.NewBufferedFile[int64](context.Background(), file, 16*1024, 1024)
+	})
+}
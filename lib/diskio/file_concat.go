@@ -0,0 +1,119 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/datawire/dlib/derror"
+)
+
+type concatFile[A ~int64] struct {
+	name  string
+	parts []File[A]
+	// ends[i] is the offset (in the concatenated file) of the end
+	// of parts[i].
+	ends []A
+}
+
+var _ File[assertAddr] = (*concatFile[assertAddr])(nil)
+
+// NewConcatFile returns a File that presents `parts` as a single
+// contiguous file, one after the other; for when a device image has
+// been split across several files.  The sizes of the parts are read
+// once, when the concatFile is created; it does not support growing
+// any of the parts.
+func NewConcatFile[A ~int64](parts ...File[A]) File[A] {
+	names := make([]string, len(parts))
+	ends := make([]A, len(parts))
+	var end A
+	for i, part := range parts {
+		names[i] = part.Name()
+		end += part.Size()
+		ends[i] = end
+	}
+	return &concatFile[A]{
+		name:  strings.Join(names, "+"),
+		parts: parts,
+		ends:  ends,
+	}
+}
+
+func (f *concatFile[A]) Name() string { return f.name }
+
+func (f *concatFile[A]) Size() A {
+	if len(f.ends) == 0 {
+		return 0
+	}
+	return f.ends[len(f.ends)-1]
+}
+
+func (f *concatFile[A]) Close() error {
+	var errs derror.MultiError
+	for _, part := range f.parts {
+		if err := part.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// partAt returns the index of the part containing `off`, and `off`
+// relative to the start of that part.
+func (f *concatFile[A]) partAt(off A) (int, A) {
+	i := sort.Search(len(f.ends), func(i int) bool {
+		return f.ends[i] > off
+	})
+	start := f.ends[i] - f.parts[i].Size()
+	return i, off - start
+}
+
+// do splits an operation at `off` across the parts; `fn` is called
+// for each part in turn until all of `dat` has been handled.  If
+// `dat` runs past the end of the last part, then `pastEnd` is
+// returned.
+func (f *concatFile[A]) do(op string, dat []byte, off A, pastEnd error, fn func(File[A], []byte, A) (int, error)) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: op, Path: f.name, Err: fmt.Errorf("negative offset: %v", off)}
+	}
+	var done int
+	for done < len(dat) && off < f.Size() {
+		i, partOff := f.partAt(off)
+		chunk := dat[done:]
+		if partLeft := f.ends[i] - off; A(len(chunk)) > partLeft {
+			chunk = chunk[:partLeft]
+		}
+		n, err := fn(f.parts[i], chunk, partOff)
+		done += n
+		off += A(n)
+		if n < len(chunk) {
+			if err == nil || err == io.EOF { //nolint:errorlint // io.EOF is never wrapped
+				err = io.ErrUnexpectedEOF
+			}
+			return done, fmt.Errorf("%s: %w", f.parts[i].Name(), err)
+		}
+	}
+	if done < len(dat) {
+		return done, pastEnd
+	}
+	return done, nil
+}
+
+func (f *concatFile[A]) ReadAt(dat []byte, off A) (int, error) {
+	return f.do("read", dat, off, io.EOF, File[A].ReadAt)
+}
+
+func (f *concatFile[A]) WriteAt(dat []byte, off A) (int, error) {
+	return f.do("write", dat, off,
+		&os.PathError{Op: "write", Path: f.name, Err: fmt.Errorf("cannot write past the end of the last part")},
+		File[A].WriteAt)
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func newTestConcatFile() (diskio.File[int64], [][]byte) {
+	parts := [][]byte{
+		[]byte("0123456789"),
+		{},
+		[]byte("abcdef"),
+		[]byte("ABCDEFGH"),
+	}
+	files := make([]diskio.File[int64], len(parts))
+	for i := range parts {
+		files[i] = diskio.NewMemFile[int64](fmt.Sprintf("img.%d", i), parts[i])
+	}
+	return diskio.NewConcatFile[int64](files...), parts
+}
+
+func TestConcatFileRead(t *testing.T) {
+	t.Parallel()
+	file, _ := newTestConcatFile()
+	assert.Equal(t, "img.0+img.1+img.2+img.3", file.Name())
+	assert.Equal(t, int64(24), file.Size())
+
+	type TestCase struct {
+		Off    int64
+		Size   int
+		ExpDat string
+		ExpErr error
+	}
+	testcases := map[string]TestCase{
+		"first-part":     {Off: 2, Size: 4, ExpDat: "2345"},
+		"exactly-a-part": {Off: 10, Size: 6, ExpDat: "abcdef"},
+		"straddle":       {Off: 8, Size: 4, ExpDat: "89ab"},
+		"straddle-end":   {Off: 7, Size: 3, ExpDat: "789"},
+		"straddle-start": {Off: 10, Size: 3, ExpDat: "abc"},
+		"all":            {Off: 0, Size: 24, ExpDat: "0123456789abcdefABCDEFGH"},
+		"past-end":       {Off: 20, Size: 8, ExpDat: "EFGH", ExpErr: io.EOF},
+		"entirely-past":  {Off: 30, Size: 4, ExpDat: "", ExpErr: io.EOF},
+		"empty":          {Off: 5, Size: 0, ExpDat: ""},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			buf := make([]byte, tc.Size)
+			n, err := file.ReadAt(buf, tc.Off)
+			assert.Equal(t, tc.ExpErr, err)
+			assert.Equal(t, tc.ExpDat, string(buf[:n]))
+		})
+	}
+
+	_, err := file.ReadAt(make([]byte, 1), -1)
+	assert.Error(t, err)
+}
+
+func TestConcatFileWrite(t *testing.T) {
+	t.Parallel()
+	file, parts := newTestConcatFile()
+
+	n, err := file.WriteAt([]byte("xyz"), 9)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "012345678x", string(parts[0]))
+	assert.Equal(t, "yzcdef", string(parts[2]))
+
+	n, err = file.WriteAt([]byte("!!!!"), 22)
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "ABCDEF!!", string(parts[3]))
+
+	buf := make([]byte, 24)
+	n, err = file.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "012345678xyzcdefABCDEF!!", string(buf[:n]))
+}
+
+func TestConcatFileEmpty(t *testing.T) {
+	t.Parallel()
+	file := diskio.NewConcatFile[int64]()
+	assert.Equal(t, int64(0), file.Size())
+	n, err := file.ReadAt(make([]byte, 4), 0)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+	assert.NoError(t, file.Close())
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// MemFile is a File backed by a byte slice in memory; mostly useful
+// for tests.  Like a block device (and unlike an *os.File), it has a
+// fixed size: writes are not allowed to grow it.
+type MemFile[A ~int64] struct {
+	name string
+	dat  []byte
+}
+
+var _ File[assertAddr] = (*MemFile[assertAddr])(nil)
+
+// NewMemFile returns a MemFile whose contents are `dat`.  `dat` is
+// not copied; writes to the MemFile are visible in `dat`, and
+// vice-versa.
+func NewMemFile[A ~int64](name string, dat []byte) *MemFile[A] {
+	return &MemFile[A]{
+		name: name,
+		dat:  dat,
+	}
+}
+
+func (f *MemFile[A]) Name() string { return f.name }
+func (f *MemFile[A]) Size() A      { return A(len(f.dat)) }
+func (f *MemFile[A]) Close() error { return nil }
+
+func (f *MemFile[A]) ReadAt(dat []byte, off A) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: fmt.Errorf("negative offset: %v", off)}
+	}
+	if off >= f.Size() {
+		if len(dat) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(dat, f.dat[off:])
+	if n < len(dat) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *MemFile[A]) WriteAt(dat []byte, off A) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: fmt.Errorf("negative offset: %v", off)}
+	}
+	var n int
+	if off < f.Size() {
+		n = copy(f.dat[off:], dat)
+	}
+	if n < len(dat) {
+		return n, &os.PathError{Op: "write", Path: f.name, Err: fmt.Errorf("cannot write past the end of the file")}
+	}
+	return n, nil
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestMemFileRead(t *testing.T) {
+	t.Parallel()
+	file := diskio.NewMemFile[int64]("mem", []byte("0123456789"))
+	assert.Equal(t, "mem", file.Name())
+	assert.Equal(t, int64(10), file.Size())
+
+	type TestCase struct {
+		Off    int64
+		Size   int
+		ExpDat string
+		ExpErr error
+	}
+	testcases := map[string]TestCase{
+		"middle":        {Off: 2, Size: 4, ExpDat: "2345"},
+		"all":           {Off: 0, Size: 10, ExpDat: "0123456789"},
+		"past-end":      {Off: 8, Size: 4, ExpDat: "89", ExpErr: io.EOF},
+		"at-end":        {Off: 10, Size: 4, ExpDat: "", ExpErr: io.EOF},
+		"entirely-past": {Off: 30, Size: 4, ExpDat: "", ExpErr: io.EOF},
+		"empty":         {Off: 10, Size: 0, ExpDat: ""},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			buf := make([]byte, tc.Size)
+			n, err := file.ReadAt(buf, tc.Off)
+			assert.Equal(t, tc.ExpErr, err)
+			assert.Equal(t, tc.ExpDat, string(buf[:n]))
+		})
+	}
+
+	_, err := file.ReadAt(make([]byte, 1), -1)
+	assert.Error(t, err)
+}
+
+func TestMemFileWrite(t *testing.T) {
+	t.Parallel()
+	dat := []byte("0123456789")
+	file := diskio.NewMemFile[int64]("mem", dat)
+
+	n, err := file.WriteAt([]byte("xy"), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "01xy456789", string(dat))
+
+	n, err = file.WriteAt([]byte("!!!!"), 8)
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "01xy4567!!", string(dat))
+
+	n, err = file.WriteAt([]byte("!"), 30)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = file.WriteAt([]byte("!"), -1)
+	assert.Error(t, err)
+	assert.Equal(t, int64(10), file.Size())
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package diskio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+type mmapFile[A ~int64] struct {
+	name string
+
+	// mu keeps Close from unmapping .dat out from under a
+	// running ReadAt (which would be a SIGSEGV, not an error).
+	mu     sync.RWMutex
+	dat    []byte
+	closed bool
+}
+
+var _ File[assertAddr] = (*mmapFile[assertAddr])(nil)
+
+// NewMmapFile opens the named file read-only and maps the whole of it
+// in to memory, so that ReadAt is a copy out of the mapping rather
+// than a syscall.  WriteAt always returns an error.  Close waits for
+// any running ReadAt calls to finish; ReadAt after Close returns
+// os.ErrClosed.
+//
+// On platforms without mmap, it falls back to an OSFile.
+func NewMmapFile[A ~int64](filename string) (File[A], error) {
+	osFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping remains valid after the file is closed.
+	defer osFile.Close()
+
+	// Use Seek rather than Stat so that block devices report
+	// their size.
+	size, err := osFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	ret := &mmapFile[A]{
+		name: filename,
+	}
+	if size == 0 {
+		// mmap(2) rejects zero-length mappings.
+		return ret, nil
+	}
+	if int64(int(size)) != size {
+		return nil, &os.PathError{Op: "mmap", Path: filename, Err: fmt.Errorf("file too large to map: %v bytes", size)}
+	}
+	ret.dat, err = unix.Mmap(int(osFile.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: filename, Err: err}
+	}
+	return ret, nil
+}
+
+func (f *mmapFile[A]) Name() string { return f.name }
+
+func (f *mmapFile[A]) Size() A {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return A(len(f.dat))
+}
+
+func (f *mmapFile[A]) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.dat == nil {
+		return nil
+	}
+	err := unix.Munmap(f.dat)
+	f.dat = nil
+	if err != nil {
+		return &os.PathError{Op: "munmap", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *mmapFile[A]) ReadAt(dat []byte, off A) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: fmt.Errorf("negative offset: %v", off)}
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if off >= A(len(f.dat)) {
+		return 0, io.EOF
+	}
+	n := copy(dat, f.dat[off:])
+	if n < len(dat) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *mmapFile[A]) WriteAt([]byte, A) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: fmt.Errorf("memory-mapped file is read-only")}
+}
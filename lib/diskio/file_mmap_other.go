@@ -0,0 +1,21 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package diskio
+
+import (
+	"os"
+)
+
+// NewMmapFile opens the named file read-only.  This platform doesn't
+// have mmap, so it is an ordinary OSFile.
+func NewMmapFile[A ~int64](filename string) (File[A], error) {
+	osFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &OSFile[A]{File: osFile}, nil
+}
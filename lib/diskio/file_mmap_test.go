@@ -0,0 +1,125 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio_test
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/btrfs/btrfsvol"
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestMmapFile(t *testing.T) {
+	t.Parallel()
+	dat := make([]byte, 64*1024+123)
+	_, err := rand.Read(dat)
+	require.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "img")
+	require.NoError(t, os.WriteFile(filename, dat, 0o600))
+
+	osFile, err := os.Open(filename)
+	require.NoError(t, err)
+	plain := &diskio.OSFile[btrfsvol.PhysicalAddr]{File: osFile}
+	t.Cleanup(func() {
+		assert.NoError(t, plain.Close())
+	})
+	mapped, err := diskio.NewMmapFile[btrfsvol.PhysicalAddr](filename)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mapped.Close())
+	})
+
+	assert.Equal(t, plain.Size(), mapped.Size())
+	type TestCase struct {
+		Off  btrfsvol.PhysicalAddr
+		Size int
+	}
+	testcases := map[string]TestCase{
+		"start":         {Off: 0, Size: 4096},
+		"middle":        {Off: 12345, Size: 6789},
+		"end":           {Off: btrfsvol.PhysicalAddr(len(dat) - 100), Size: 100},
+		"past-end":      {Off: btrfsvol.PhysicalAddr(len(dat) - 100), Size: 200},
+		"entirely-past": {Off: btrfsvol.PhysicalAddr(len(dat) + 1), Size: 10},
+	}
+	for tcName, tc := range testcases {
+		tc := tc
+		t.Run(tcName, func(t *testing.T) {
+			t.Parallel()
+			expBuf := make([]byte, tc.Size)
+			expN, expErr := plain.ReadAt(expBuf, tc.Off)
+			actBuf := make([]byte, tc.Size)
+			actN, actErr := mapped.ReadAt(actBuf, tc.Off)
+			assert.Equal(t, expN, actN)
+			assert.Equal(t, expBuf, actBuf)
+			assert.Equal(t, expErr, actErr)
+		})
+	}
+
+	_, err = mapped.WriteAt([]byte("x"), 0)
+	assert.Error(t, err)
+
+	var lv btrfsvol.LogicalVolume[diskio.File[btrfsvol.PhysicalAddr]]
+	assert.NoError(t, lv.AddPhysicalVolume(1, mapped))
+	assert.Equal(t, mapped, lv.PhysicalVolumes()[1])
+}
+
+func TestMmapFileEmpty(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "img")
+	require.NoError(t, os.WriteFile(filename, nil, 0o600))
+
+	mapped, err := diskio.NewMmapFile[int64](filename)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), mapped.Size())
+	n, err := mapped.ReadAt(make([]byte, 1), 0)
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.NoError(t, mapped.Close())
+}
+
+func TestMmapFileCloseWhileReading(t *testing.T) {
+	t.Parallel()
+	dat := make([]byte, 1024*1024)
+	_, err := rand.Read(dat)
+	require.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "img")
+	require.NoError(t, os.WriteFile(filename, dat, 0o600))
+
+	mapped, err := diskio.NewMmapFile[int64](filename)
+	require.NoError(t, err)
+
+	// Without the lock, Close would unmap the file while the
+	// readers are copying out of it, and crash the test binary.
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, len(dat))
+			for {
+				if _, err := mapped.ReadAt(buf, 0); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	assert.NoError(t, mapped.Close())
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.ErrorIs(t, err, os.ErrClosed)
+	}
+	assert.NoError(t, mapped.Close())
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FileStats is a snapshot of the I/O counters accumulated by a
+// StatsFile.
+type FileStats struct {
+	ReadCalls int64
+	ReadBytes int64
+	ReadTime  time.Duration
+
+	WriteCalls int64
+	WriteBytes int64
+	WriteTime  time.Duration
+}
+
+// StatsFile wraps a File, counting the number of calls to, the number
+// of bytes transferred by, and the wall-clock time spent in ReadAt
+// and WriteAt.  It is safe for concurrent use.
+type StatsFile[A ~int64] struct {
+	inner File[A]
+
+	readCalls atomic.Int64
+	readBytes atomic.Int64
+	readTime  atomic.Int64
+
+	writeCalls atomic.Int64
+	writeBytes atomic.Int64
+	writeTime  atomic.Int64
+}
+
+var _ File[assertAddr] = (*StatsFile[assertAddr])(nil)
+
+func NewStatsFile[A ~int64](file File[A]) *StatsFile[A] {
+	return &StatsFile[A]{
+		inner: file,
+	}
+}
+
+func (sf *StatsFile[A]) Name() string { return sf.inner.Name() }
+func (sf *StatsFile[A]) Size() A      { return sf.inner.Size() }
+func (sf *StatsFile[A]) Close() error { return sf.inner.Close() }
+
+func (sf *StatsFile[A]) ReadAt(dat []byte, off A) (int, error) {
+	beg := time.Now()
+	n, err := sf.inner.ReadAt(dat, off)
+	sf.readTime.Add(int64(time.Since(beg)))
+	sf.readCalls.Add(1)
+	sf.readBytes.Add(int64(n))
+	return n, err
+}
+
+func (sf *StatsFile[A]) WriteAt(dat []byte, off A) (int, error) {
+	beg := time.Now()
+	n, err := sf.inner.WriteAt(dat, off)
+	sf.writeTime.Add(int64(time.Since(beg)))
+	sf.writeCalls.Add(1)
+	sf.writeBytes.Add(int64(n))
+	return n, err
+}
+
+// Stats returns the counters accumulated so far.
+func (sf *StatsFile[A]) Stats() FileStats {
+	return FileStats{
+		ReadCalls: sf.readCalls.Load(),
+		ReadBytes: sf.readBytes.Load(),
+		ReadTime:  time.Duration(sf.readTime.Load()),
+
+		WriteCalls: sf.writeCalls.Load(),
+		WriteBytes: sf.writeBytes.Load(),
+		WriteTime:  time.Duration(sf.writeTime.Load()),
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package diskio_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/diskio"
+)
+
+func TestStatsFile(t *testing.T) {
+	t.Parallel()
+	file := diskio.NewStatsFile[int64](byteReaderWithName{
+		Reader: bytes.NewReader([]byte("0123456789")),
+		name:   t.Name(),
+	})
+
+	buf := make([]byte, 4)
+	n, err := file.ReadAt(buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	n, err = file.ReadAt(buf, 8)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 2, n)
+
+	stats := file.Stats()
+	assert.Equal(t, int64(2), stats.ReadCalls)
+	assert.Equal(t, int64(6), stats.ReadBytes)
+	assert.Equal(t, int64(0), stats.WriteCalls)
+	assert.Equal(t, int64(0), stats.WriteBytes)
+}
@@ -1,4 +1,4 @@
-// Copyright (C) 2022  Luke Shumaker <lukeshu@lukeshu.com>
+// Copyright (C) 2022-2023  Luke Shumaker <lukeshu@lukeshu.com>
 //
 // SPDX-License-Identifier: GPL-2.0-or-later
 
@@ -37,7 +37,7 @@ func BitfieldString[T ~uint8 | ~uint16 | ~uint32 | ~uint64](bitfield T, bitnames
 				if !first {
 					out.WriteRune('|')
 				}
-				if i < len(bitnames) {
+				if i < len(bitnames) && bitnames[i] != "" {
 					out.WriteString(bitnames[i])
 				} else {
 					fmt.Fprintf(&out, "(1<<%v)", i)
@@ -53,7 +53,7 @@ func Reverse[T any](slice []T) {
 func Max[T constraints.Ordered](a T, rest ...T) T {
 	ret := a
 	for _, b := range rest {
-		if b > a {
+		if b > ret {
 			ret = b
 		}
 	}
@@ -63,7 +63,7 @@ func Max[T constraints.Ordered](a T, rest ...T) T {
 func Min[T constraints.Ordered](a T, rest ...T) T {
 	ret := a
 	for _, b := range rest {
-		if b < a {
+		if b < ret {
 			ret = b
 		}
 	}
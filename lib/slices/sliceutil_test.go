@@ -0,0 +1,23 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package slices_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.lukeshu.com/btrfs-progs-ng/lib/slices"
+)
+
+func TestMinMax(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 1, slices.Min(3, 1, 2))
+	assert.Equal(t, 1, slices.Min(8, 5, 1))
+	assert.Equal(t, 5, slices.Min(5))
+	assert.Equal(t, 3, slices.Max(1, 3, 2))
+	assert.Equal(t, 8, slices.Max(1, 5, 8))
+	assert.Equal(t, 5, slices.Max(5))
+}
@@ -7,6 +7,7 @@ package textui
 import (
 	"context"
 	"fmt"
+	"math"
 	"time"
 
 	"git.lukeshu.com/go/typedsync"
@@ -18,6 +19,13 @@ type Stats interface {
 	fmt.Stringer
 }
 
+// A Fractioner is a Stats that knows how much of the task is done;
+// see Progress.EnableETA.  Portion is a Fractioner, as is any struct
+// that embeds a Portion.
+type Fractioner interface {
+	Fraction() (n, d uint64)
+}
+
 // Progress helps display to the user the ongoing progress of a long
 // task.
 //
@@ -28,6 +36,14 @@ type Stats interface {
 //     after creating the progress, or right before calling .Done().  I
 //     advise against counting on a loop to have called .Set() at least
 //     once.
+//
+// If .EnableETA() has been called, then each line is suffixed with an
+// estimate of the time remaining.
+//
+// If the stats stop changing (for instance, because a single step of
+// the task is taking a long time), then a "still working" heartbeat
+// line is logged every so often, so that the user can tell that the
+// program hasn't hung.
 type Progress[T Stats] struct {
 	ctx      context.Context //nolint:containedctx // captured for separate goroutine
 	lvl      dlog.LogLevel
@@ -39,9 +55,9 @@ type Progress[T Stats] struct {
 	cur     typedsync.Value[T]
 	oldStat T
 	oldLine string
+	eta     *etaEstimator
 
-	// This isn't a functional part, but is useful for helping us
-	// to detect misuse.
+	start     time.Time
 	lastTick  time.Time
 	lastWrite time.Time
 }
@@ -55,10 +71,25 @@ func NewProgress[T Stats](ctx context.Context, lvl dlog.LogLevel, interval time.
 
 		cancel: cancel,
 		done:   make(chan struct{}),
+
+		start: time.Now(),
 	}
 	return ret
 }
 
+// EnableETA makes the Progress append an estimate of the time
+// remaining (", ETA 1m30s") to each line that it logs.  It panics if
+// T does not implement Fractioner.
+//
+// EnableETA must be called before the first call to .Set.
+func (p *Progress[T]) EnableETA() {
+	var zero T
+	if _, ok := any(zero).(Fractioner); !ok {
+		panic(fmt.Errorf("textui.Progress.EnableETA: %T does not implement textui.Fractioner", zero))
+	}
+	p.eta = new(etaEstimator)
+}
+
 // Set update the Progress.  Rate-limiting prevents this from being
 // expensive, or from spamming the user; it is reasonably safe to call
 // .Set in a tight inner loop.
@@ -86,24 +117,19 @@ func (p *Progress[T]) Done() {
 }
 
 func (p *Progress[T]) flush(now time.Time, cur T) {
-	// Check how long it's been since we last printed something.
-	// If this grows too big, it probably means that either the
-	// program deadlocked or that we forgot to call .Done().
-	if !p.lastTick.IsZero() && !p.lastWrite.IsZero() {
-		tickTimeout := Tunable(1 * time.Minute)
-		writeTimeout := Tunable(2 * time.Minute)
-		if now.Sub(p.lastTick) < tickTimeout && now.Sub(p.lastWrite) > writeTimeout {
-			err := fmt.Errorf("textui.Progress: hang detected: no updates for %v (from %v to %v)",
-				writeTimeout, p.lastWrite, now)
-			dlog.Error(p.ctx, err)
-			panic(err)
-		}
-	}
 	force := p.lastTick.IsZero()
 	p.lastTick = now
 
+	// Update the rate estimate even if there is nothing new to
+	// print, so that a stall slows the estimated rate.
+	if p.eta != nil {
+		n, d := any(cur).(Fractioner).Fraction()
+		p.eta.update(now, n, d)
+	}
+
 	// Load the data to print.
 	if !force && cur == p.oldStat {
+		p.heartbeat(now)
 		return
 	}
 	defer func() { p.oldStat = cur }()
@@ -111,15 +137,81 @@ func (p *Progress[T]) flush(now time.Time, cur T) {
 	// Format the data as text.
 	line := cur.String()
 	if !force && line == p.oldLine {
+		p.heartbeat(now)
 		return
 	}
 	defer func() { p.oldLine = line }()
 
-	// Print.
-	dlog.Log(p.ctx, p.lvl, line)
+	// Print.  The ETA isn't part of .oldLine, so that the
+	// heartbeat doesn't repeat a stale estimate.
+	msg := line
+	if p.eta != nil {
+		if eta, ok := p.eta.estimate(); ok {
+			msg = fmt.Sprintf("%s, ETA %v", line, eta)
+		}
+	}
+	dlog.Log(p.ctx, p.lvl, msg)
 	p.lastWrite = now
 }
 
+// heartbeat is called by .flush() when there is nothing new to
+// print; if nothing has been printed in a while, it prints a line
+// saying that we're still working on the last thing that was
+// printed.  Without this, a step that takes several minutes is
+// indistinguishable from a hang (or from forgetting to call .Done()).
+func (p *Progress[T]) heartbeat(now time.Time) {
+	interval := Tunable(30 * time.Second)
+	if now.Sub(p.lastWrite) < interval {
+		return
+	}
+	dlog.Logf(p.ctx, p.lvl, "still working on: %v (elapsed %v)",
+		p.oldLine, now.Sub(p.start).Round(time.Second))
+	p.lastWrite = now
+}
+
+// etaEstimator estimates the time remaining for a Progress, based on
+// an exponentially-smoothed rate of progress; so that the estimate
+// follows changes in speed (such as a scan moving from a slow region
+// of the disk to a fast one) without jumping around on every tick.
+type etaEstimator struct {
+	lastTime time.Time
+	lastN    uint64
+	rate     float64 // in units of N per second
+	haveRate bool
+
+	n, d uint64
+}
+
+func (e *etaEstimator) update(now time.Time, n, d uint64) {
+	e.n, e.d = n, d
+	if e.lastTime.IsZero() {
+		e.lastTime, e.lastN = now, n
+		return
+	}
+	dt := now.Sub(e.lastTime).Seconds()
+	if dt <= 0 {
+		return
+	}
+	rate := (float64(n) - float64(e.lastN)) / dt
+	if e.haveRate {
+		alpha := Tunable(0.3)
+		rate = alpha*rate + (1-alpha)*e.rate
+	}
+	e.rate, e.haveRate = rate, true
+	e.lastTime, e.lastN = now, n
+}
+
+func (e *etaEstimator) estimate() (time.Duration, bool) {
+	if !e.haveRate || e.rate <= 0 || e.n >= e.d {
+		return 0, false
+	}
+	secs := float64(e.d-e.n) / e.rate
+	if secs > math.MaxInt64/float64(time.Second) {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)).Round(time.Second), true
+}
+
 func (p *Progress[T]) run(initVal T) {
 	p.flush(time.Now(), initVal)
 	ticker := time.NewTicker(p.interval)
@@ -0,0 +1,65 @@
+// Copyright (C) 2023  Luke Shumaker <lukeshu@lukeshu.com>
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package textui
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressETA(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+	ctx := dlog.WithLogger(context.Background(), NewLogger(&out, dlog.LogLevelInfo))
+	p := NewProgress[Portion[int]](ctx, dlog.LogLevelInfo, time.Second)
+	p.EnableETA()
+
+	// Call .flush directly rather than going through .Set, so
+	// that the test controls the clock.
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.start = start
+	for _, step := range []struct {
+		Sec int
+		N   int
+	}{
+		{0, 0},   // no rate yet
+		{1, 10},  // 10/s
+		{2, 20},  // 10/s
+		{3, 20},  // stalled, but unchanged stats aren't printed
+		{4, 50},  // speeding up
+		{5, 100}, // done
+	} {
+		p.flush(start.Add(time.Duration(step.Sec)*time.Second), Portion[int]{N: step.N, D: 100})
+	}
+
+	// Strip the timestamps.
+	lines := regexp.MustCompile(`(?m)^[0-9:.]+ INF : `).ReplaceAllString(out.String(), "")
+	assert.Equal(t, ""+
+		"0% (0/100)\n"+
+		"10% (10/100), ETA 9s\n"+
+		"20% (20/100), ETA 8s\n"+
+		"50% (50/100), ETA 4s\n"+
+		"100% (100/100)\n",
+		lines)
+}
+
+type countStats struct {
+	N int
+}
+
+func (s countStats) String() string { return Sprintf("%v", s.N) }
+
+func TestProgressETANotFractioner(t *testing.T) {
+	t.Parallel()
+	p := NewProgress[countStats](context.Background(), dlog.LogLevelInfo, time.Second)
+	assert.Panics(t, p.EnableETA)
+}
@@ -74,7 +74,10 @@ type Portion[T constraints.Integer] struct {
 	N, D T
 }
 
-var _ fmt.Stringer = Portion[int]{}
+var (
+	_ fmt.Stringer = Portion[int]{}
+	_ Fractioner   = Portion[int]{}
+)
 
 // String implements fmt.Stringer.
 func (p Portion[T]) String() string {
@@ -85,6 +88,11 @@ func (p Portion[T]) String() string {
 	return printer.Sprintf("%d%% (%v/%v)", pct, uint64(p.N), uint64(p.D))
 }
 
+// Fraction implements Fractioner.
+func (p Portion[T]) Fraction() (n, d uint64) {
+	return uint64(p.N), uint64(p.D)
+}
+
 type metric[T constraints.Integer | constraints.Float] struct {
 	Val  T
 	Unit string